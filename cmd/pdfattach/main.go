@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/novvoo/go-poppler/pkg/pdf"
 )
@@ -12,6 +13,11 @@ import (
 func main() {
 	// Define flags
 	replace := flag.Bool("replace", false, "replace existing attachment")
+	remove := flag.String("remove", "", "remove the named attachment instead of adding one")
+	name := flag.String("name", "", "attachment name (default: base name of the file)")
+	desc := flag.String("desc", "", "attachment description")
+	mimeType := flag.String("mime", "", "MIME type (default: guessed from the name)")
+	relationship := flag.String("relationship", "", "PDF/A-3 AFRelationship: Source, Data, Alternative, Supplement or Unspecified")
 	ownerPwd := flag.String("opw", "", "owner password")
 	userPwd := flag.String("upw", "", "user password")
 	version := flag.Bool("v", false, "print version info")
@@ -21,7 +27,8 @@ func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "pdfattach version 1.0.0\n")
 		fmt.Fprintf(os.Stderr, "Copyright 2024 go-poppler authors\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: pdfattach [options] <PDF-file> <file-to-attach> <output-PDF>\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: pdfattach [options] <PDF-file> <file-to-attach> <output-PDF>\n")
+		fmt.Fprintf(os.Stderr, "       pdfattach -remove <name> [options] <PDF-file> <output-PDF>\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 	}
@@ -34,14 +41,17 @@ func main() {
 		return
 	}
 
-	if *help || flag.NArg() < 3 {
+	nargs := 3
+	if *remove != "" {
+		nargs = 2
+	}
+	if *help || flag.NArg() < nargs {
 		flag.Usage()
 		return
 	}
 
 	pdfFile := flag.Arg(0)
-	attachFile := flag.Arg(1)
-	outputFile := flag.Arg(2)
+	outputFile := flag.Arg(nargs - 1)
 
 	// Open PDF
 	doc, err := pdf.Open(pdfFile)
@@ -51,6 +61,23 @@ func main() {
 	}
 	defer doc.Close()
 
+	editor := pdf.NewAttachmentEditor(doc)
+
+	if *remove != "" {
+		if err := editor.RemoveAttachment(*remove); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing attachment: %v\n", err)
+			os.Exit(1)
+		}
+		if err := editor.WriteToFile(outputFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing PDF: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed attachment '%s' from '%s'\n", *remove, outputFile)
+		return
+	}
+
+	attachFile := flag.Arg(1)
+
 	// Read attachment file
 	attachData, err := os.ReadFile(attachFile)
 	if err != nil {
@@ -58,24 +85,39 @@ func main() {
 		os.Exit(1)
 	}
 
+	attachName := *name
+	if attachName == "" {
+		attachName = filepath.Base(attachFile)
+	}
+	opts := &pdf.AttachmentOptions{
+		Description:  *desc,
+		MimeType:     *mimeType,
+		Relationship: pdf.AFRelationship(*relationship),
+	}
+	if info, err := os.Stat(attachFile); err == nil {
+		opts.ModDate = info.ModTime()
+	}
+
 	// Add attachment
-	err = pdf.AddAttachment(doc, attachFile, attachData)
+	if *replace {
+		err = editor.ReplaceAttachment(attachName, attachData, opts)
+	} else {
+		err = editor.AddAttachment(attachName, attachData, opts)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error adding attachment: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Write output
-	err = pdf.WriteToFile(doc, outputFile)
-	if err != nil {
+	if err := editor.WriteToFile(outputFile); err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing PDF: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Added attachment '%s' to '%s'\n", attachFile, outputFile)
+	fmt.Printf("Added attachment '%s' to '%s'\n", attachName, outputFile)
 
 	// Suppress unused variable warnings
-	_ = replace
 	_ = ownerPwd
 	_ = userPwd
 }
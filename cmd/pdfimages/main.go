@@ -2,10 +2,12 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/novvoo/go-poppler/pkg/pdf"
@@ -15,14 +17,16 @@ var (
 	firstPage  = flag.Int("f", 1, "first page to scan")
 	lastPage   = flag.Int("l", 0, "last page to scan")
 	listImages = flag.Bool("list", false, "list images instead of extracting")
-	allImages  = flag.Bool("all", false, "write all images (including inline)")
+	allImages  = flag.Bool("all", false, "equivalent to -png -tiff -j -jp2 -jbig2 -ccitt -json")
 	pageImages = flag.Bool("page", false, "write page images")
-	ccitt      = flag.Bool("ccitt", false, "write CCITT images as CCITT files")
-	jbig2      = flag.Bool("jbig2", false, "write JBIG2 images as JBIG2 files")
+	ccitt      = flag.Bool("ccitt", false, "write CCITT images as CCITT files with fax2tiff .params")
+	jbig2      = flag.Bool("jbig2", false, "write JBIG2 images as JBIG2 files (.jb2e, globals as .jb2g)")
 	jpeg       = flag.Bool("j", false, "write JPEG images as JPEG files")
 	jp2        = flag.Bool("jp2", false, "write JPEG2000 images as JP2 files")
 	png        = flag.Bool("png", false, "write images as PNG files (default)")
 	tiff       = flag.Bool("tiff", false, "write images as TIFF files")
+	jsonOut    = flag.Bool("json", false, "write image placements to <image-root>.json")
+	dedup      = flag.Bool("dedup", false, "list and write each image once, however often it is painted")
 	ppm        = flag.Bool("ppm", false, "write images as PPM files")
 	upscale    = flag.Int("upscale", 1, "upscale factor for images")
 	printHelp  = flag.Bool("h", false, "print usage information")
@@ -89,19 +93,33 @@ func main() {
 		first = 1
 	}
 
-	// Extract images
+	// Extract images, grouping the uses of each image with -dedup
 	extractor := pdf.NewImageExtractor(doc)
-	images, err := extractor.ExtractImages(first, last)
+	var images []*pdf.UniqueImage
+	if *dedup {
+		images, err = extractor.ExtractUniqueImages(first, last)
+	} else {
+		var uses []*pdf.ImageInfo
+		uses, err = extractor.ExtractImages(first, last)
+		for _, img := range uses {
+			images = append(images, &pdf.UniqueImage{ImageInfo: img, Uses: []*pdf.ImageInfo{img}})
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error extracting images: %v\n", err)
 		os.Exit(1)
 	}
 
 	if *listImages {
-		// List mode
-		fmt.Printf("page   num  type   width height color comp bpc  enc interp  object ID x-ppi y-ppi size ratio\n")
-		fmt.Printf("--------------------------------------------------------------------------------------------\n")
-		for _, img := range images {
+		// List mode; -dedup adds the number of uses and their pages
+		header := "page   num  type    width height color comp bpc  enc   interp object ID x-ppi y-ppi  size ratio"
+		if *dedup {
+			header += " uses pages"
+		}
+		fmt.Println(header)
+		fmt.Println(strings.Repeat("-", len(header)))
+		for _, u := range images {
+			img := u.ImageInfo
 			colorSpace := img.ColorSpace
 			if colorSpace == "" {
 				colorSpace = "-"
@@ -114,17 +132,25 @@ func main() {
 			if img.Interpolate {
 				interp = "yes"
 			}
-			fmt.Printf("%4d %5d  %-6s %5d %5d  %-5s %4d %3d  %-5s %-6s %4d %4d %5d %5d %5dB %3d%%\n",
+			objectID := fmt.Sprintf("%4d %4d", img.ObjectNum, img.Generation)
+			if img.Inline {
+				objectID = fmt.Sprintf("%9s", "inline")
+			}
+			fmt.Printf("%4d %5d  %-7s %5d %5d  %-5s %4d %3d  %-5s %-6s %s %5d %5d %5dB %3d%%",
 				img.Page, img.Index, img.Type,
 				img.Width, img.Height,
 				colorSpace, img.Components, img.BitsPerComponent,
 				enc, interp,
-				img.ObjectNum, img.Generation,
+				objectID,
 				img.XPPI, img.YPPI,
 				img.Size, img.Ratio)
+			if *dedup {
+				fmt.Printf(" %4d %s", len(u.Uses), formatPages(u.Pages()))
+			}
+			fmt.Println()
 		}
 	} else {
-		// Extract mode - determine format
+		// Extract mode - determine the format of images written as samples
 		format := "png"
 		ext := "png"
 		quality := 85
@@ -132,58 +158,115 @@ func main() {
 		if *ppm {
 			format = "ppm"
 			ext = "ppm"
-		} else if *jpeg {
-			format = "jpeg"
-			ext = "jpg"
 		} else if *tiff {
 			format = "tiff"
 			ext = "tif"
-		} else if *jp2 {
-			format = "native"
-			ext = "jp2"
-		} else if *ccitt || *jbig2 {
-			format = "native"
 		}
 
-		for i, img := range images {
-			// Determine extension based on format and native type
-			imgExt := ext
-			if format == "native" {
-				imgExt = getNativeExtension(img)
-			}
-			filename := fmt.Sprintf("%s-%03d.%s", imageRoot, i, imgExt)
+		var placements []pdf.ImagePlacement
+		for i, u := range images {
+			img := u.ImageInfo
+			root := fmt.Sprintf("%s-%03d", imageRoot, i)
 
 			// Create directory if needed
-			dir := filepath.Dir(filename)
+			dir := filepath.Dir(root)
 			if dir != "" && dir != "." {
 				os.MkdirAll(dir, 0755)
 			}
 
-			data, err := extractor.GetImageDataWithFormat(img, format, quality)
+			filename, err := writeImage(extractor, img, root, format, ext, quality)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Warning: could not extract image %d: %v\n", i, err)
 				continue
 			}
+			for _, placement := range u.Placements() {
+				placement.File = filepath.Base(filename)
+				placements = append(placements, placement)
+			}
+		}
 
-			if err := os.WriteFile(filename, data, 0644); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", filename, err)
-				continue
+		if *jsonOut || *allImages {
+			data, err := json.MarshalIndent(placements, "", "  ")
+			if err == nil {
+				err = os.WriteFile(imageRoot+".json", append(data, '\n'), 0644)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %s.json: %v\n", imageRoot, err)
 			}
 		}
 		fmt.Printf("Extracted %d images\n", len(images))
 	}
 }
 
-func getNativeExtension(img *pdf.ImageInfo) string {
-	switch strings.ToLower(img.Filter) {
-	case "dctdecode":
-		return "jpg"
-	case "jpxdecode":
-		return "jp2"
-	case "ccittfaxdecode":
-		return "tif"
-	case "jbig2decode":
-		return "jb2"
-	}
-	return "png"
+// formatPages lists page numbers, collapsing runs into ranges
+func formatPages(pages []int) string {
+	var parts []string
+	for i := 0; i < len(pages); {
+		j := i
+		for j+1 < len(pages) && pages[j+1] == pages[j]+1 {
+			j++
+		}
+		part := strconv.Itoa(pages[i])
+		if j > i {
+			part += "-" + strconv.Itoa(pages[j])
+		}
+		parts = append(parts, part)
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// writeImage writes an image to root plus an extension and returns the
+// name of the image file. Images whose encoding was selected by -j, -jp2,
+// -jbig2, -ccitt or -all are written untouched, together with their JBIG2
+// globals or CCITT parameters; others are written in format.
+func writeImage(extractor *pdf.ImageExtractor, img *pdf.ImageInfo, root, format, ext string, quality int) (string, error) {
+	native, err := extractor.GetNativeImage(img)
+	if err != nil {
+		return "", err
+	}
+	if native != nil && wantNative(native.Ext) {
+		filename := root + "." + native.Ext
+		if err := os.WriteFile(filename, native.Data, 0644); err != nil {
+			return "", err
+		}
+		if native.Globals != nil {
+			if err := os.WriteFile(root+".jb2g", native.Globals, 0644); err != nil {
+				return "", err
+			}
+		}
+		if native.Params != "" {
+			if err := os.WriteFile(root+".params", []byte(native.Params), 0644); err != nil {
+				return "", err
+			}
+		}
+		return filename, nil
+	}
+
+	// -all keeps CMYK images apart from RGB ones, as TIFF files
+	if *allImages && img.Components == 4 && !img.IsMask {
+		format, ext = "tiff", "tif"
+	}
+	data, err := extractor.GetImageDataWithFormat(img, format, quality)
+	if err != nil {
+		return "", err
+	}
+	filename := root + "." + ext
+	return filename, os.WriteFile(filename, data, 0644)
+}
+
+// wantNative reports whether the options ask for images with the native
+// file extension ext to be written untouched
+func wantNative(ext string) bool {
+	switch ext {
+	case "jpg":
+		return *jpeg || *allImages
+	case "jp2":
+		return *jp2 || *allImages
+	case "jb2e":
+		return *jbig2 || *allImages
+	case "ccitt":
+		return *ccitt || *allImages
+	}
+	return false
 }
@@ -0,0 +1,89 @@
+// pdfrecompress - recompress bilevel images losslessly
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+func main() {
+	// Define flags
+	method := flag.String("method", "auto", "compression: ccitt, jbig2, jbig2-symbols or auto (smallest)")
+	force := flag.Bool("force", false, "replace images even when the result is not smaller")
+	incremental := flag.Bool("incremental", false, "append an incremental update instead of rewriting the file (keeps signatures, does not shrink)")
+	verbose := flag.Bool("verbose", false, "report images that were not replaced")
+	version := flag.Bool("v", false, "print version info")
+	help := flag.Bool("h", false, "print usage information")
+	flag.BoolVar(help, "help", false, "print usage information")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "pdfrecompress version 1.0.0\n")
+		fmt.Fprintf(os.Stderr, "Copyright 2024 go-poppler authors\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: pdfrecompress [options] <PDF-file> <output-PDF>\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println("pdfrecompress version 1.0.0")
+		fmt.Println("Copyright 2024 go-poppler authors")
+		return
+	}
+
+	if *help || flag.NArg() < 2 {
+		flag.Usage()
+		return
+	}
+
+	compression, err := pdf.ParseBilevelCompression(*method)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Open PDF
+	doc, err := pdf.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening PDF: %v\n", err)
+		os.Exit(1)
+	}
+	defer doc.Close()
+
+	writer := pdf.NewIncrementalWriter(doc)
+	result, err := pdf.RecompressBilevelImages(writer, pdf.RecompressOptions{Method: compression, Force: *force})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error recompressing images: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *verbose {
+		nums := make([]int, 0, len(result.Skipped))
+		for num := range result.Skipped {
+			nums = append(nums, num)
+		}
+		sort.Ints(nums)
+		for _, num := range nums {
+			fmt.Printf("object %d: kept (%s)\n", num, result.Skipped[num])
+		}
+	}
+
+	// Write output
+	if *incremental {
+		err = writer.WriteToFile(flag.Arg(1))
+	} else {
+		err = writer.RewriteToFile(flag.Arg(1))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing PDF: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Recompressed %d of %d bilevel images: %d -> %d bytes\n",
+		result.Recompressed, result.Images, result.OriginalBytes, result.NewBytes)
+}
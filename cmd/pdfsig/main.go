@@ -2,9 +2,16 @@
 package main
 
 import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/novvoo/go-poppler/pkg/pdf"
 )
@@ -15,8 +22,26 @@ func main() {
 	noCRL := flag.Bool("no-crl", false, "don't check CRL")
 	noOCSP := flag.Bool("no-ocsp", false, "don't check OCSP")
 	dump := flag.Bool("dump", false, "dump all signatures")
+	trustFile := flag.String("trust", "", "PEM file with trusted root certificates")
+	offlineDir := flag.String("offline", "", "directory with certificates, OCSP responses and CRLs to use instead of the network")
+	aia := flag.Bool("aia", false, "fetch missing issuer certificates using the Authority Information Access extension")
 	ownerPwd := flag.String("opw", "", "owner password")
 	userPwd := flag.String("upw", "", "user password")
+	addSignature := flag.Bool("add-signature", false, "add a new signature to the document")
+	certFile := flag.String("cert", "", "PEM file with the signing certificate and its chain")
+	keyFile := flag.String("key", "", "PEM file with the private key (default: the -cert file)")
+	fieldName := flag.String("field", "", "name of the signature field to sign or create")
+	reason := flag.String("reason", "", "reason for signing")
+	location := flag.String("location", "", "location of signing")
+	digest := flag.String("digest", "SHA256", "digest algorithm: SHA256, SHA384 or SHA512")
+	tsaURL := flag.String("tsa", "", "URL of an RFC 3161 timestamp authority")
+	page := flag.Int("page", 1, "page of a visible signature")
+	rect := flag.String("rect", "", "rectangle of a visible signature: x1,y1,x2,y2")
+	addLTV := flag.Bool("add-ltv", false, "add validation data (DSS) for the document's signatures")
+	addTimestamp := flag.Bool("add-timestamp", false, "add a document timestamp (requires -tsa)")
+	ocspFiles := flag.String("ocsp", "", "comma separated OCSP response files to add with -add-ltv")
+	crlFiles := flag.String("crl", "", "comma separated CRL files to add with -add-ltv")
+	at := flag.String("at", "", "validate certificates at this time (RFC 3339), or 'signing' for the signing time")
 	version := flag.Bool("v", false, "print version info")
 	help := flag.Bool("h", false, "print usage information")
 	flag.BoolVar(help, "help", false, "print usage information")
@@ -24,7 +49,10 @@ func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "pdfsig version 1.0.0\n")
 		fmt.Fprintf(os.Stderr, "Copyright 2024 go-poppler authors\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: pdfsig [options] <PDF-file>\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: pdfsig [options] <PDF-file>\n")
+		fmt.Fprintf(os.Stderr, "       pdfsig -add-signature -cert <file> [options] <PDF-file> <output-file>\n")
+		fmt.Fprintf(os.Stderr, "       pdfsig -add-ltv [-ocsp <files>] [-crl <files>] [-tsa <url>] <PDF-file> <output-file>\n")
+		fmt.Fprintf(os.Stderr, "       pdfsig -add-timestamp -tsa <url> <PDF-file> <output-file>\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 	}
@@ -52,55 +80,204 @@ func main() {
 	}
 	defer doc.Close()
 
+	if *addSignature {
+		if flag.NArg() < 2 || *certFile == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+		opts := pdf.SignOptions{
+			FieldName: *fieldName,
+			Reason:    *reason,
+			Location:  *location,
+			Page:      *page,
+		}
+		if err := loadSigner(&opts, *certFile, *keyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading signer: %v\n", err)
+			os.Exit(1)
+		}
+		switch strings.ToUpper(strings.ReplaceAll(*digest, "-", "")) {
+		case "SHA256":
+			opts.Hash = crypto.SHA256
+		case "SHA384":
+			opts.Hash = crypto.SHA384
+		case "SHA512":
+			opts.Hash = crypto.SHA512
+		default:
+			fmt.Fprintf(os.Stderr, "Unsupported digest algorithm: %s\n", *digest)
+			os.Exit(1)
+		}
+		if *rect != "" {
+			if opts.Rect, err = parseRect(*rect); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid rectangle: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *tsaURL != "" {
+			opts.TSA = &pdf.HTTPTimestampAuthority{URL: *tsaURL}
+		}
+		if err := doc.SignToFile(flag.Arg(1), opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error signing document: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Signature added successfully.")
+		return
+	}
+
+	if *addLTV || *addTimestamp {
+		if flag.NArg() < 2 || *addTimestamp && *tsaURL == "" {
+			flag.Usage()
+			os.Exit(1)
+		}
+		var tsa pdf.TimestampAuthority
+		if *tsaURL != "" {
+			tsa = &pdf.HTTPTimestampAuthority{URL: *tsaURL}
+		}
+		var out []byte
+		if *addLTV {
+			opts := pdf.LTVOptions{TSA: tsa}
+			if opts.OCSPs, err = readDERFiles(*ocspFiles); err == nil {
+				opts.CRLs, err = readDERFiles(*crlFiles)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading revocation data: %v\n", err)
+				os.Exit(1)
+			}
+			out, err = doc.AddLTV(opts)
+		} else {
+			out, err = doc.AddDocumentTimestamp(tsa, 0)
+		}
+		if err == nil {
+			err = os.WriteFile(flag.Arg(1), out, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error updating document: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Document updated successfully.")
+		return
+	}
+
 	// Create signature validator with advanced features
 	validator := pdf.NewSignatureValidator(doc)
+	validator.SkipCertificateCheck = *nocert
+	fetcher := revocationFetcher{RevocationFetcher: &pdf.HTTPRevocationFetcher{}, ocsp: !*noOCSP, crl: !*noCRL, aia: *aia}
+	if *offlineDir != "" {
+		offline, err := pdf.NewOfflineRevocationFetcher(*offlineDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading offline revocation data: %v\n", err)
+			os.Exit(1)
+		}
+		// Local issuer certificates can always be used
+		fetcher.RevocationFetcher, fetcher.aia = offline, true
+	}
+	validator.Fetcher = fetcher
+	switch *at {
+	case "":
+	case "signing":
+		validator.AllowExpired = true
+	default:
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid validation time: %v\n", err)
+			os.Exit(1)
+		}
+		validator.ValidationTime = t
+	}
+	if *trustFile != "" {
+		data, err := os.ReadFile(*trustFile)
+		if err == nil {
+			err = validator.AddTrustedCert(data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading trusted certificates: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	// Verify all signatures
 	results := validator.VerifyAllSignatures()
 
 	if len(results) == 0 {
-		fmt.Println("File is not signed.")
+		fmt.Printf("File '%s' does not contain any signatures\n", pdfFile)
 		return
 	}
 
-	fmt.Printf("Digital Signatures: %d\n", len(results))
+	fmt.Printf("Digital Signature Info of: %s\n", pdfFile)
 	for i, result := range results {
-		fmt.Printf("\nSignature #%d:\n", i+1)
-		fmt.Printf("  - Signer: %s\n", result.SignerName)
-
-		// Validation status
-		if result.Valid {
-			fmt.Printf("  - Status: Valid ✓\n")
+		fmt.Printf("Signature #%d:\n", i+1)
+		fmt.Printf("  - Signature Field Name: %s\n", result.FieldName)
+		if result.Certificate != nil {
+			fmt.Printf("  - Signer Certificate Common Name: %s\n", result.Certificate.Subject.CommonName)
+			fmt.Printf("  - Signer full Distinguished Name: %s\n", result.Certificate.Subject)
 		} else {
-			fmt.Printf("  - Status: Invalid ✗\n")
+			fmt.Printf("  - Signer Certificate Common Name: %s\n", result.SignerName)
 		}
-
-		// Signing time
 		if !result.SigningTime.IsZero() {
-			fmt.Printf("  - Signing Time: %s\n", result.SigningTime.Format("2006-01-02 15:04:05"))
+			fmt.Printf("  - Signing Time: %s\n", result.SigningTime.Format("Jan 02 2006 15:04:05"))
+		}
+		if result.HashAlgorithm != "" {
+			fmt.Printf("  - Signing Hash Algorithm: %s\n", result.HashAlgorithm)
+		}
+		fmt.Printf("  - Signature Type: %s\n", result.SignatureType)
+		if len(result.ByteRange) >= 2 {
+			var ranges []string
+			for j := 0; j+1 < len(result.ByteRange); j += 2 {
+				start := result.ByteRange[j]
+				ranges = append(ranges, fmt.Sprintf("[%d - %d]", start, start+result.ByteRange[j+1]))
+			}
+			fmt.Printf("  - Signed Ranges: %s\n", strings.Join(ranges, ", "))
+		}
+		switch result.CoverageStatus {
+		case "total":
+			fmt.Println("  - Total document signed")
+		case "partial":
+			fmt.Println("  - Not total document signed")
+		}
+		if result.ModifiedAfter {
+			if result.ChangesPermitted {
+				fmt.Println("  - Changes after signing: Permitted")
+			} else {
+				fmt.Println("  - Changes after signing: Not permitted")
+			}
 		}
-
-		// Additional info
 		if result.Reason != "" {
 			fmt.Printf("  - Reason: %s\n", result.Reason)
 		}
 		if result.Location != "" {
 			fmt.Printf("  - Location: %s\n", result.Location)
 		}
+		fmt.Printf("  - Signature Validation: %s\n", signatureStatusText(result.SignatureStatus))
+		if !*nocert {
+			fmt.Printf("  - Certificate Validation: %s\n", certificateStatusText(result.CertificateStatus))
+		}
+		if result.TrustedTimestamp != nil {
+			fmt.Printf("  - Timestamp: %s\n", result.TrustedTimestamp.Format("Jan 02 2006 15:04:05"))
+		}
 
-		// Signature type and algorithm
 		if *dump {
-			fmt.Printf("  - Signature Type: %s\n", result.SignatureType)
-			fmt.Printf("  - Hash Algorithm: %s\n", result.HashAlgorithm)
-			fmt.Printf("  - Coverage Status: %s\n", result.CoverageStatus)
-
 			// Certificate info
 			if result.Certificate != nil {
-				fmt.Printf("  - Certificate Subject: %s\n", result.Certificate.Subject)
 				fmt.Printf("  - Certificate Issuer: %s\n", result.Certificate.Issuer)
 				fmt.Printf("  - Certificate Valid From: %s\n", result.Certificate.NotBefore.Format("2006-01-02"))
 				fmt.Printf("  - Certificate Valid To: %s\n", result.Certificate.NotAfter.Format("2006-01-02"))
 			}
+			if !result.ValidationTime.IsZero() {
+				fmt.Printf("  - Validation Time: %s\n", result.ValidationTime.Format("Jan 02 2006 15:04:05"))
+			}
+			fmt.Printf("  - Revocation Status: %s\n", result.RevocationStatus)
+
+			// Changes after signing
+			for _, m := range result.Modifications {
+				status := "permitted"
+				if !m.Permitted {
+					status = m.Reason
+				}
+				field := ""
+				if m.Field != "" {
+					field = fmt.Sprintf(" field '%s'", m.Field)
+				}
+				fmt.Printf("  - Object %d %s: %s%s, %s\n", m.ObjectNumber, m.Change, m.Kind, field, status)
+			}
 
 			// Validation errors
 			if len(result.ValidationErrors) > 0 {
@@ -127,9 +304,165 @@ func main() {
 	}
 
 	// Suppress unused variable warnings
-	_ = nocert
-	_ = noCRL
-	_ = noOCSP
 	_ = ownerPwd
 	_ = userPwd
 }
+
+// signatureStatusText describes a signature status the way poppler does
+func signatureStatusText(status string) string {
+	switch status {
+	case pdf.SignatureStatusValid:
+		return "Signature is Valid."
+	case pdf.SignatureStatusInvalid:
+		return "Signature is Invalid."
+	case pdf.SignatureStatusDigestMismatch:
+		return "Digest Mismatch."
+	case pdf.SignatureStatusDecodingError:
+		return "Document isn't signed or corrupted data."
+	case pdf.SignatureStatusNotVerified:
+		return "Signature has not yet been verified."
+	}
+	return "Unknown Validation Failure."
+}
+
+// certificateStatusText describes a certificate status the way poppler does
+func certificateStatusText(status string) string {
+	switch status {
+	case pdf.CertificateStatusTrusted:
+		return "Certificate is Trusted."
+	case pdf.CertificateStatusUntrustedIssuer:
+		return "Certificate issuer isn't Trusted."
+	case pdf.CertificateStatusUnknownIssuer:
+		return "Certificate issuer is unknown."
+	case pdf.CertificateStatusExpired:
+		return "Certificate has Expired"
+	case pdf.CertificateStatusRevoked:
+		return "Certificate has been Revoked."
+	case pdf.CertificateStatusNotVerified:
+		return "Certificate has not yet been verified."
+	}
+	return "Unknown issue with Certificate or corrupted data."
+}
+
+// revocationFetcher limits a fetcher to the sources enabled on the
+// command line
+type revocationFetcher struct {
+	pdf.RevocationFetcher
+	ocsp, crl, aia bool
+}
+
+var errSourceDisabled = errors.New("disabled on the command line")
+
+func (f revocationFetcher) FetchOCSP(cert, issuer *x509.Certificate) ([]byte, error) {
+	if !f.ocsp {
+		return nil, errSourceDisabled
+	}
+	return f.RevocationFetcher.FetchOCSP(cert, issuer)
+}
+
+func (f revocationFetcher) FetchCRL(cert *x509.Certificate) ([]byte, error) {
+	if !f.crl {
+		return nil, errSourceDisabled
+	}
+	return f.RevocationFetcher.FetchCRL(cert)
+}
+
+func (f revocationFetcher) FetchIssuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	if !f.aia {
+		return nil, errSourceDisabled
+	}
+	return f.RevocationFetcher.FetchIssuer(cert)
+}
+
+// readDERFiles reads a comma separated list of DER or PEM files
+func readDERFiles(list string) ([][]byte, error) {
+	var out [][]byte
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if block, _ := pem.Decode(data); block != nil {
+			data = block.Bytes
+		}
+		out = append(out, data)
+	}
+	return out, nil
+}
+
+// loadSigner reads the certificate chain and private key from PEM files
+func loadSigner(opts *pdf.SignOptions, certFile, keyFile string) error {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	if keyFile != "" && keyFile != certFile {
+		keyData, err := os.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, '\n'), keyData...)
+	}
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return err
+			}
+			opts.Certificates = append(opts.Certificates, cert)
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return fmt.Errorf("unsupported private key type %T", key)
+			}
+			opts.Signer = signer
+		case "RSA PRIVATE KEY":
+			if opts.Signer, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return err
+			}
+		case "EC PRIVATE KEY":
+			if opts.Signer, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(opts.Certificates) == 0 {
+		return fmt.Errorf("no certificate found in %s", certFile)
+	}
+	if opts.Signer == nil {
+		return fmt.Errorf("no private key found")
+	}
+	return nil
+}
+
+// parseRect parses a rectangle given as "x1,y1,x2,y2"
+func parseRect(s string) (pdf.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return pdf.Rectangle{}, fmt.Errorf("expected x1,y1,x2,y2")
+	}
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return pdf.Rectangle{}, err
+		}
+		v[i] = f
+	}
+	return pdf.Rectangle{LLX: v[0], LLY: v[1], URX: v[2], URY: v[3]}, nil
+}
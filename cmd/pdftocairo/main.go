@@ -31,6 +31,7 @@ func main() {
 	paperWidth := flag.Float64("paper-width", 0, "paper width in points")
 	paperHeight := flag.Float64("paper-height", 0, "paper height in points")
 	cropBox := flag.Bool("cropbox", false, "use crop box instead of media box")
+	hideAnnotations := flag.Bool("hide-annotations", false, "do not show annotations")
 	ownerPwd := flag.String("opw", "", "owner password")
 	userPwd := flag.String("upw", "", "user password")
 	quiet := flag.Bool("q", false, "don't print any messages")
@@ -194,6 +195,9 @@ func main() {
 		OwnerPwd:  *ownerPwd,
 		UserPwd:   *userPwd,
 	}
+	if *hideAnnotations {
+		options.Annotations = pdf.AnnotationsNone
+	}
 
 	renderer := pdf.NewPageRenderer(doc, options)
 
@@ -202,8 +206,8 @@ func main() {
 	if first < 1 {
 		first = 1
 	}
-	if last == 0 || last > doc.NumPages() {
-		last = doc.NumPages()
+	if last == 0 || last > renderer.NumPages() {
+		last = renderer.NumPages()
 	}
 
 	for pageNum := first; pageNum <= last; pageNum++ {
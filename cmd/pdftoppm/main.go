@@ -26,6 +26,7 @@ func main() {
 	jpegQuality := flag.Int("jpegopt", 85, "JPEG quality (1-100)")
 	tiff := flag.Bool("tiff", false, "generate TIFF output")
 	cropBox := flag.Bool("cropbox", false, "use crop box instead of media box")
+	hideAnnotations := flag.Bool("hide-annotations", false, "do not show annotations")
 	ownerPwd := flag.String("opw", "", "owner password")
 	userPwd := flag.String("upw", "", "user password")
 	quiet := flag.Bool("q", false, "don't print any messages")
@@ -99,6 +100,9 @@ func main() {
 		OwnerPwd:  *ownerPwd,
 		UserPwd:   *userPwd,
 	}
+	if *hideAnnotations {
+		options.Annotations = pdf.AnnotationsNone
+	}
 
 	renderer := pdf.NewPageRenderer(doc, options)
 
@@ -108,8 +112,8 @@ func main() {
 	if first < 1 {
 		first = 1
 	}
-	if last == 0 || last > doc.NumPages() {
-		last = doc.NumPages()
+	if last == 0 || last > renderer.NumPages() {
+		last = renderer.NumPages()
 	}
 
 	// Render pages
@@ -26,30 +26,57 @@ import (
 
 // SignatureVerificationResult 签名验证结果
 type SignatureVerificationResult struct {
-	Valid            bool
-	SignerName       string
-	SigningTime      time.Time
-	Reason           string
-	Location         string
-	Certificate      *x509.Certificate
-	CertificateChain []*x509.Certificate
-	ValidationErrors []string
-	HashAlgorithm    string
-	SignatureType    string
-	CoverageStatus   string // "total", "partial", "unknown"
-	ModifiedAfter    bool
-	TrustedTimestamp *time.Time
-	RevocationStatus string // "good", "revoked", "unknown"
-}
+	Valid             bool
+	FieldName         string
+	SignerName        string
+	SigningTime       time.Time
+	Reason            string
+	Location          string
+	Certificate       *x509.Certificate
+	CertificateChain  []*x509.Certificate
+	ValidationErrors  []string
+	HashAlgorithm     string
+	SignatureType     string
+	SignatureStatus   string // "valid", "invalid", "digest mismatch", "decoding error", "not verified"
+	CertificateStatus string // "trusted", "untrusted issuer", "unknown issuer", "expired", "invalid", "not verified"
+	ByteRange         []int
+	CoverageStatus    string // "total", "partial", "unknown"
+	ModifiedAfter     bool
+	TrustedTimestamp  *time.Time
+	RevocationStatus  string    // "good", "revoked", "unknown"
+	ValidationTime    time.Time // 验证证书链和吊销状态所用的时间点
+	ChangesPermitted  bool      // 签名之后的修改是否都在 DocMDP/FieldMDP 允许范围内
+	Modifications     []Modification
+}
+
+// 签名与证书验证状态
+const (
+	SignatureStatusValid          = "valid"
+	SignatureStatusInvalid        = "invalid"
+	SignatureStatusDigestMismatch = "digest mismatch"
+	SignatureStatusDecodingError  = "decoding error"
+	SignatureStatusNotVerified    = "not verified"
+
+	CertificateStatusTrusted         = "trusted"
+	CertificateStatusUntrustedIssuer = "untrusted issuer"
+	CertificateStatusUnknownIssuer   = "unknown issuer"
+	CertificateStatusExpired         = "expired"
+	CertificateStatusRevoked         = "revoked"
+	CertificateStatusInvalid         = "invalid"
+	CertificateStatusNotVerified     = "not verified"
+)
 
 // SignatureValidator 企业级签名验证器
 type SignatureValidator struct {
-	TrustedCerts     []*x509.Certificate
-	CRLs             [][]byte
-	OCSPResponders   []string
-	AllowExpired     bool
-	RequireTimestamp bool
-	doc              *Document
+	TrustedCerts         []*x509.Certificate
+	CRLs                 [][]byte
+	OCSPResponders       []string
+	AllowExpired         bool      // 按签名时间而非当前时间验证证书
+	ValidationTime       time.Time // 指定验证时间点，优先于 AllowExpired
+	RequireTimestamp     bool
+	SkipCertificateCheck bool              // 只验证签名值，不验证证书链
+	Fetcher              RevocationFetcher // 文档内嵌数据不足时在线获取吊销信息和颁发者证书；nil 时只使用内嵌数据
+	doc                  *Document
 }
 
 // NewSignatureValidator 创建签名验证器
@@ -60,9 +87,9 @@ func NewSignatureValidator(doc *Document) *SignatureValidator {
 	}
 }
 
-// AddTrustedCert 添加受信任证书
+// AddTrustedCert 添加受信任证书，支持 DER 或包含多个证书的 PEM
 func (v *SignatureValidator) AddTrustedCert(certPEM []byte) error {
-	block, _ := pem.Decode(certPEM)
+	block, rest := pem.Decode(certPEM)
 	if block == nil {
 		cert, err := x509.ParseCertificate(certPEM)
 		if err != nil {
@@ -72,11 +99,16 @@ func (v *SignatureValidator) AddTrustedCert(certPEM []byte) error {
 		return nil
 	}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil {
-		return fmt.Errorf("failed to parse certificate: %w", err)
+	for block != nil {
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return fmt.Errorf("failed to parse certificate: %w", err)
+			}
+			v.TrustedCerts = append(v.TrustedCerts, cert)
+		}
+		block, rest = pem.Decode(rest)
 	}
-	v.TrustedCerts = append(v.TrustedCerts, cert)
 	return nil
 }
 
@@ -96,80 +128,444 @@ func (v *SignatureValidator) VerifyAllSignatures() []SignatureVerificationResult
 // VerifySignature 验证单个签名
 func (v *SignatureValidator) VerifySignature(sig Signature) SignatureVerificationResult {
 	result := SignatureVerificationResult{
-		SignerName:    sig.Signer,
-		Reason:        sig.Reason,
-		Location:      sig.Location,
-		SignatureType: sig.SubFilter,
+		FieldName:         sig.FieldName,
+		SignerName:        sig.Signer,
+		Reason:            sig.Reason,
+		Location:          sig.Location,
+		SignatureType:     sig.SubFilter,
+		SignatureStatus:   SignatureStatusNotVerified,
+		CertificateStatus: CertificateStatusNotVerified,
+		ByteRange:         sig.ByteRange,
+		CoverageStatus:    "unknown",
+		RevocationStatus:  "unknown",
 	}
 
 	if sig.SigningTime != "" {
 		result.SigningTime = parsePDFDate(sig.SigningTime)
 	}
 
+	// 取出签名覆盖的字节
+	data, err := v.signedRanges(sig)
+	if err != nil {
+		result.SignatureStatus = SignatureStatusDecodingError
+		result.ValidationErrors = append(result.ValidationErrors, err.Error())
+		return result
+	}
+	v.checkCoverage(&result, sig)
+	result.ChangesPermitted = true
+	if result.ModifiedAfter {
+		if report, ok := v.analyzeModifications(newMDPContext(v.doc), sig); ok {
+			result.Modifications = report.Modifications
+			result.ChangesPermitted = report.Permitted
+		}
+	}
+
+	var cms *cmsVerified
 	switch sig.SubFilter {
 	case "adbe.pkcs7.detached", "ETSI.CAdES.detached":
-		v.verifyPKCS7Signature(&result, sig)
+		cms = v.verifyPKCS7Signature(&result, sig, data)
 	case "adbe.pkcs7.sha1":
-		v.verifyPKCS7SHA1Signature(&result, sig)
+		cms = v.verifyPKCS7SHA1Signature(&result, sig, data)
 	case "adbe.x509.rsa_sha1":
-		v.verifyX509RSASignature(&result, sig)
+		v.verifyX509RSASignature(&result, sig, data)
 	case "ETSI.RFC3161":
-		v.verifyTimestampSignature(&result, sig)
+		cms = v.verifyTimestampSignature(&result, sig, data)
 	default:
 		result.ValidationErrors = append(result.ValidationErrors,
 			fmt.Sprintf("unsupported signature type: %s", sig.SubFilter))
 	}
 
+	if result.SignatureStatus == SignatureStatusValid {
+		v.verifyCertificate(&result, sig, cms)
+	}
+	if v.RequireTimestamp && result.TrustedTimestamp == nil {
+		result.ValidationErrors = append(result.ValidationErrors, "signature has no trusted timestamp")
+	}
+	if !result.ChangesPermitted {
+		result.ValidationErrors = append(result.ValidationErrors, "document changed after signing in a way the signature does not permit")
+	}
+	result.Valid = result.SignatureStatus == SignatureStatusValid && result.ChangesPermitted &&
+		(v.SkipCertificateCheck || result.CertificateStatus == CertificateStatusTrusted) &&
+		(!v.RequireTimestamp || result.TrustedTimestamp != nil)
+
 	return result
 }
 
-func (v *SignatureValidator) verifyPKCS7Signature(result *SignatureVerificationResult, sig Signature) {
-	result.HashAlgorithm = "SHA-256"
-	result.CoverageStatus = "unknown"
-	result.ValidationErrors = append(result.ValidationErrors,
-		"PKCS#7 signature verification requires external crypto library")
+// signedRanges 返回 /ByteRange 指定的字节，并检查排除的部分恰好是 /Contents 字符串
+func (v *SignatureValidator) signedRanges(sig Signature) ([][]byte, error) {
+	br := sig.ByteRange
+	if len(br) == 0 || len(br)%2 != 0 {
+		return nil, errors.New("signature has no valid /ByteRange")
+	}
+	data := v.doc.data
+	var ranges [][]byte
+	end := 0
+	for i := 0; i < len(br); i += 2 {
+		start, length := br[i], br[i+1]
+		if start < end || length < 0 || start+length > len(data) {
+			return nil, errors.New("signature /ByteRange is out of bounds")
+		}
+		ranges = append(ranges, data[start:start+length])
+		end = start + length
+	}
+	if br[0] != 0 {
+		return nil, errors.New("signature /ByteRange does not start at the beginning of the file")
+	}
+	if len(br) == 4 {
+		gap := data[br[1]:br[2]]
+		if len(gap) < 2 || gap[0] != '<' || gap[len(gap)-1] != '>' || len(gap)-2 != 2*len(sig.SignedData) {
+			return nil, errors.New("signature /ByteRange does not exclude exactly the signature value")
+		}
+	}
+	return ranges, nil
 }
 
-func (v *SignatureValidator) verifyPKCS7SHA1Signature(result *SignatureVerificationResult, sig Signature) {
-	result.HashAlgorithm = "SHA-1"
-	result.ValidationErrors = append(result.ValidationErrors,
-		"SHA-1 signatures are deprecated and may not be secure")
+// checkCoverage 判断签名是否覆盖整个文件
+func (v *SignatureValidator) checkCoverage(result *SignatureVerificationResult, sig Signature) {
+	br := sig.ByteRange
+	end := br[len(br)-2] + br[len(br)-1]
+	if len(bytes.TrimRight(v.doc.data[end:], "\r\n\t\f\x00 ")) == 0 {
+		result.CoverageStatus = "total"
+		return
+	}
+	result.CoverageStatus = "partial"
+	result.ModifiedAfter = true
 }
 
-func (v *SignatureValidator) verifyX509RSASignature(result *SignatureVerificationResult, sig Signature) {
-	result.HashAlgorithm = "SHA-1"
-	result.ValidationErrors = append(result.ValidationErrors,
-		"X.509 RSA signature verification requires certificate data")
+// applyCMSResult 将 CMS 验证结果写入验证结果
+func (v *SignatureValidator) applyCMSResult(result *SignatureVerificationResult, cms *cmsVerified, err error) {
+	if cms != nil {
+		result.Certificate = cms.signer
+		result.CertificateChain = cms.certs
+		result.HashAlgorithm = cms.hash.String()
+		if cms.signer != nil && result.SignerName == "" {
+			result.SignerName = cms.signer.Subject.CommonName
+		}
+		if result.SigningTime.IsZero() && !cms.signingTime.IsZero() {
+			result.SigningTime = cms.signingTime
+		}
+	}
+	switch {
+	case err == nil:
+		result.SignatureStatus = SignatureStatusValid
+		return
+	case errors.Is(err, errCMSDigestMismatch):
+		result.SignatureStatus = SignatureStatusDigestMismatch
+	case errors.Is(err, errCMSBadSignature):
+		result.SignatureStatus = SignatureStatusInvalid
+	default:
+		result.SignatureStatus = SignatureStatusDecodingError
+	}
+	result.ValidationErrors = append(result.ValidationErrors, err.Error())
 }
 
-func (v *SignatureValidator) verifyTimestampSignature(result *SignatureVerificationResult, sig Signature) {
-	result.SignatureType = "Timestamp"
-	result.ValidationErrors = append(result.ValidationErrors,
-		"RFC3161 timestamp verification requires TSA certificate")
+// verifyPKCS7Signature 验证分离式 CMS 签名 (adbe.pkcs7.detached, ETSI.CAdES.detached)
+func (v *SignatureValidator) verifyPKCS7Signature(result *SignatureVerificationResult, sig Signature, data [][]byte) *cmsVerified {
+	cms, err := verifyCMS(sig.SignedData, data)
+	v.applyCMSResult(result, cms, err)
+	if err == nil {
+		v.verifySignatureTimestamp(result, cms)
+	}
+	return cms
 }
 
-// VerifyDocumentIntegrity 验证文档完整性
-func (v *SignatureValidator) VerifyDocumentIntegrity() (bool, []string) {
-	var issues []string
+// verifyPKCS7SHA1Signature 验证 adbe.pkcs7.sha1 签名：封装内容是文档的 SHA-1 摘要
+func (v *SignatureValidator) verifyPKCS7SHA1Signature(result *SignatureVerificationResult, sig Signature, data [][]byte) *cmsVerified {
+	cms, err := verifyCMS(sig.SignedData, nil)
+	if err == nil && !bytes.Equal(cms.content, digestOf(crypto.SHA1, data)) {
+		err = errCMSDigestMismatch
+	}
+	v.applyCMSResult(result, cms, err)
+	result.HashAlgorithm = crypto.SHA1.String()
+	if err == nil {
+		v.verifySignatureTimestamp(result, cms)
+	}
+	return cms
+}
 
-	if v.hasIncrementalUpdates() {
-		issues = append(issues, "Document has incremental updates after signing")
+// verifyX509RSASignature 验证 adbe.x509.rsa_sha1 签名：/Contents 是 PKCS#1 签名值，证书在 /Cert 中
+func (v *SignatureValidator) verifyX509RSASignature(result *SignatureVerificationResult, sig Signature, data [][]byte) {
+	result.HashAlgorithm = crypto.SHA1.String()
+	if sig.Certificate == nil {
+		result.SignatureStatus = SignatureStatusDecodingError
+		result.ValidationErrors = append(result.ValidationErrors, "signature has no /Cert")
+		return
+	}
+	result.Certificate = sig.Certificate
+	result.CertificateChain = sig.Certificates
+	if result.SignerName == "" {
+		result.SignerName = sig.Certificate.Subject.CommonName
+	}
+	pub, ok := sig.Certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		result.SignatureStatus = SignatureStatusDecodingError
+		result.ValidationErrors = append(result.ValidationErrors, "adbe.x509.rsa_sha1 requires an RSA key")
+		return
+	}
+	var value []byte
+	if _, err := asn1.Unmarshal(sig.SignedData, &value); err != nil {
+		result.SignatureStatus = SignatureStatusDecodingError
+		result.ValidationErrors = append(result.ValidationErrors, "malformed signature value")
+		return
 	}
+	// 签名的摘要算法写在 DigestInfo 中，依次尝试
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if rsa.VerifyPKCS1v15(pub, hash, digestOf(hash, data), value) == nil {
+			result.HashAlgorithm = hash.String()
+			result.SignatureStatus = SignatureStatusValid
+			return
+		}
+	}
+	result.SignatureStatus = SignatureStatusInvalid
+	result.ValidationErrors = append(result.ValidationErrors, errCMSBadSignature.Error())
+}
+
+// verifyTimestampSignature 验证文档时间戳 (ETSI.RFC3161)
+func (v *SignatureValidator) verifyTimestampSignature(result *SignatureVerificationResult, sig Signature, data [][]byte) *cmsVerified {
+	info, cms, err := verifyTimestampToken(sig.SignedData, data)
+	v.applyCMSResult(result, cms, err)
+	if err == nil {
+		genTime := info.GenTime
+		result.SigningTime = genTime
+		if v.isTrustedTimestamp(cms, genTime) {
+			result.TrustedTimestamp = &genTime
+		}
+	}
+	return cms
+}
+
+// verifySignatureTimestamp 验证签名值上的 RFC 3161 时间戳 (CAdES-T)
+func (v *SignatureValidator) verifySignatureTimestamp(result *SignatureVerificationResult, cms *cmsVerified) {
+	token := cmsAttributeValue(cms.unsigned, oidAttrTimeStampToken)
+	if token == nil {
+		return
+	}
+	info, tsa, err := verifyTimestampToken(token, [][]byte{cms.signature})
+	if err != nil {
+		result.ValidationErrors = append(result.ValidationErrors, "signature timestamp: "+err.Error())
+		return
+	}
+	if v.isTrustedTimestamp(tsa, info.GenTime) {
+		genTime := info.GenTime
+		result.TrustedTimestamp = &genTime
+	}
+}
+
+// isTrustedTimestamp 检查时间戳的 TSA 证书在生成时间是否受信任
+func (v *SignatureValidator) isTrustedTimestamp(tsa *cmsVerified, genTime time.Time) bool {
+	if v.SkipCertificateCheck {
+		return true
+	}
+	status, _ := v.verifyChain(tsa.signer, tsa.certs, genTime, x509.ExtKeyUsageTimeStamping)
+	return status == CertificateStatusTrusted
+}
+
+// verifyCertificate 验证签名证书链，并用文档 /DSS、CMS 中存档的吊销信息和 CRLs 离线检查吊销状态
+func (v *SignatureValidator) verifyCertificate(result *SignatureVerificationResult, sig Signature, cms *cmsVerified) {
+	if v.SkipCertificateCheck || result.Certificate == nil {
+		return
+	}
+	at := v.validationTime(result, sig)
+	result.ValidationTime = at
+
+	rev := &revocationData{crls: append([][]byte(nil), v.CRLs...)}
+	rev.addCMS(cms)
+	rev.addDSS(v.doc.DSS(), sig.SignedData)
+
+	usage := x509.ExtKeyUsageAny
+	if result.SignatureType == "ETSI.RFC3161" {
+		usage = x509.ExtKeyUsageTimeStamping
+	}
+	pool := append(append([]*x509.Certificate(nil), result.CertificateChain...), rev.certs...)
+	status, chain := v.verifyChain(result.Certificate, pool, at, usage)
+	if status == CertificateStatusUnknownIssuer && v.Fetcher != nil {
+		pool = v.fetchIssuers(result.Certificate, pool)
+		status, chain = v.verifyChain(result.Certificate, pool, at, usage)
+	}
+	if status == CertificateStatusTrusted {
+		var problems []string
+		result.RevocationStatus, problems = rev.status(chain, at)
+		if result.RevocationStatus == "unknown" && v.Fetcher != nil {
+			v.fetchRevocation(rev, chain)
+			result.RevocationStatus, problems = rev.status(chain, at)
+		}
+		result.ValidationErrors = append(result.ValidationErrors, problems...)
+		if result.RevocationStatus == "revoked" {
+			status = CertificateStatusRevoked
+		}
+	}
+	result.CertificateStatus = status
+	if result.CertificateStatus != CertificateStatusTrusted {
+		result.ValidationErrors = append(result.ValidationErrors, "certificate: "+result.CertificateStatus)
+	}
+}
+
+// fetchIssuers 通过 Fetcher 补全证书池中缺失的颁发者证书
+func (v *SignatureValidator) fetchIssuers(cert *x509.Certificate, pool []*x509.Certificate) []*x509.Certificate {
+	current := cert
+	for depth := 0; depth < 8; depth++ {
+		if bytes.Equal(current.RawIssuer, current.RawSubject) && isIssuedBy(current, current) {
+			break
+		}
+		var issuer *x509.Certificate
+		for _, c := range append(pool, v.TrustedCerts...) {
+			if bytes.Equal(c.RawSubject, current.RawIssuer) && isIssuedBy(current, c) {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			fetched, err := v.Fetcher.FetchIssuer(current)
+			if err != nil {
+				break
+			}
+			pool = append(pool, fetched)
+			issuer = fetched
+		}
+		current = issuer
+	}
+	return pool
+}
+
+// fetchRevocation 通过 Fetcher 获取链中各证书的 OCSP 响应，失败时获取 CRL
+func (v *SignatureValidator) fetchRevocation(rev *revocationData, chain []*x509.Certificate) {
+	for i := 0; i+1 < len(chain); i++ {
+		if der, err := v.Fetcher.FetchOCSP(chain[i], chain[i+1]); err == nil {
+			rev.ocsps = append(rev.ocsps, der)
+			continue
+		}
+		if der, err := v.Fetcher.FetchCRL(chain[i]); err == nil {
+			rev.crls = append(rev.crls, der)
+		}
+	}
+}
+
+// validationTime 选择验证时间点：指定的 ValidationTime；AllowExpired 时依次为签名时间戳、
+// 覆盖该签名的最早可信文档时间戳、签名时间；否则为当前时间
+func (v *SignatureValidator) validationTime(result *SignatureVerificationResult, sig Signature) time.Time {
+	if !v.ValidationTime.IsZero() {
+		return v.ValidationTime
+	}
+	if !v.AllowExpired {
+		return time.Now()
+	}
+	if result.TrustedTimestamp != nil {
+		return *result.TrustedTimestamp
+	}
+	if t := v.documentTimestampAfter(sig); !t.IsZero() {
+		return t
+	}
+	if !result.SigningTime.IsZero() {
+		return result.SigningTime
+	}
+	return time.Now()
+}
+
+// documentTimestampAfter 返回覆盖该签名的最早可信文档时间戳的生成时间，没有时返回零值
+func (v *SignatureValidator) documentTimestampAfter(sig Signature) time.Time {
+	br := sig.ByteRange
+	end := br[len(br)-2] + br[len(br)-1]
+	var earliest time.Time
+	for _, ts := range GetSignatures(v.doc) {
+		tbr := ts.ByteRange
+		if ts.SubFilter != "ETSI.RFC3161" || len(tbr) < 2 || tbr[len(tbr)-2]+tbr[len(tbr)-1] <= end {
+			continue
+		}
+		data, err := v.signedRanges(ts)
+		if err != nil {
+			continue
+		}
+		info, tsa, err := verifyTimestampToken(ts.SignedData, data)
+		if err != nil || !v.isTrustedTimestamp(tsa, info.GenTime) {
+			continue
+		}
+		if earliest.IsZero() || info.GenTime.Before(earliest) {
+			earliest = info.GenTime
+		}
+	}
+	return earliest
+}
+
+// verifyChain 构建到受信任根证书的证书链，受信任时同时返回从 cert 到根证书的链
+func (v *SignatureValidator) verifyChain(cert *x509.Certificate, pool []*x509.Certificate, at time.Time, usage x509.ExtKeyUsage) (string, []*x509.Certificate) {
+	roots := x509.NewCertPool()
+	for _, c := range v.TrustedCerts {
+		roots.AddCert(c)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range pool {
+		intermediates.AddCert(c)
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err == nil && len(chains) > 0 {
+		return CertificateStatusTrusted, chains[0]
+	}
+
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+		return CertificateStatusExpired, nil
+	}
+	var unknown x509.UnknownAuthorityError
+	if !errors.As(err, &unknown) {
+		return CertificateStatusInvalid, nil
+	}
+	// 链能在已知证书中走到自签名根时，是根不受信任；否则是颁发者未知
+	current := cert
+	for i := 0; i <= len(pool); i++ {
+		if bytes.Equal(current.RawIssuer, current.RawSubject) && isIssuedBy(current, current) {
+			return CertificateStatusUntrustedIssuer, nil
+		}
+		var issuer *x509.Certificate
+		for _, c := range pool {
+			if c != current && bytes.Equal(c.RawSubject, current.RawIssuer) && isIssuedBy(current, c) {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		current = issuer
+	}
+	return CertificateStatusUnknownIssuer, nil
+}
+
+// isIssuedBy 检查 cert 的签名是否由 issuer 的密钥生成，不检查证书约束
+func isIssuedBy(cert, issuer *x509.Certificate) bool {
+	return issuer.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// VerifyDocumentIntegrity 验证文档完整性：签名覆盖范围正确，且签名之后的修改都为签名权限所允许
+func (v *SignatureValidator) VerifyDocumentIntegrity() (bool, []string) {
+	var issues []string
 
 	if !v.checkByteRangeCoverage() {
 		issues = append(issues, "Signature byte range does not cover entire document")
 	}
 
-	return len(issues) == 0, issues
-}
+	for _, report := range v.AnalyzeModifications() {
+		for _, m := range report.Modifications {
+			if !m.Permitted {
+				issues = append(issues, fmt.Sprintf("%s: %s (object %d %s)", report.FieldName, m.Reason, m.ObjectNumber, m.Change))
+			}
+		}
+	}
 
-func (v *SignatureValidator) hasIncrementalUpdates() bool {
-	data := v.doc.data
-	count := bytes.Count(data, []byte("%%EOF"))
-	return count > 1
+	return len(issues) == 0, issues
 }
 
+// checkByteRangeCoverage 检查每个签名的 /ByteRange 是否只排除了签名值本身
 func (v *SignatureValidator) checkByteRangeCoverage() bool {
+	for _, sig := range GetSignatures(v.doc) {
+		if _, err := v.signedRanges(sig); err != nil {
+			return false
+		}
+	}
 	return true
 }
 
@@ -38,6 +38,20 @@ const (
 	AnnotRedact
 )
 
+// Annotation flags (PDF 32000-1:2008, Table 165)
+const (
+	AnnotFlagInvisible      = 1 << 0
+	AnnotFlagHidden         = 1 << 1
+	AnnotFlagPrint          = 1 << 2
+	AnnotFlagNoZoom         = 1 << 3
+	AnnotFlagNoRotate       = 1 << 4
+	AnnotFlagNoView         = 1 << 5
+	AnnotFlagReadOnly       = 1 << 6
+	AnnotFlagLocked         = 1 << 7
+	AnnotFlagToggleNoView   = 1 << 8
+	AnnotFlagLockedContents = 1 << 9
+)
+
 // AnnotRect represents an annotation rectangle
 type AnnotRect struct {
 	LLX, LLY float64 // Lower-left
@@ -84,8 +98,24 @@ type Annotation struct {
 	L  []float64 // Line coordinates
 	LE []string  // Line endings
 
+	// Ink specific
+	InkList [][]float64
+
+	// Polygon and polyline specific
+	Vertices []float64
+
+	// Geometric annotation fields
+	IC          []float64 // Interior color
+	BorderWidth float64   // Border width from the BS dictionary
+
+	Icon string // Icon name for text and stamp annotations
+	Open bool   // Whether the popup is initially open
+
 	// Widget specific (form fields)
 	Field *FormField
+
+	page int       // Page number the annotation was read from
+	ref  Reference // Object reference of the annotation dictionary
 }
 
 // Action represents a PDF action
@@ -136,6 +166,10 @@ func (e *AnnotationExtractor) GetPageAnnotations(pageNum int) ([]*Annotation, er
 
 		annot := e.parseAnnotation(annotDict)
 		if annot != nil {
+			annot.page = pageNum
+			if ref, ok := annotRef.(Reference); ok {
+				annot.ref = ref
+			}
 			annotations = append(annotations, annot)
 		}
 	}
@@ -237,11 +271,65 @@ func (e *AnnotationExtractor) parseAnnotation(dict Dictionary) *Annotation {
 		}
 	}
 
+	// Get interior color
+	if ic := dict.Get("IC"); ic != nil {
+		if arr := e.resolveArray(ic); arr != nil {
+			annot.IC = make([]float64, len(arr))
+			for i, v := range arr {
+				annot.IC[i] = e.toFloat(v)
+			}
+		}
+	}
+
+	// Get border style width
+	if bs := e.resolveDict(dict.Get("BS")); bs != nil {
+		if w := bs.Get("W"); w != nil {
+			annot.BorderWidth = e.toFloat(w)
+		}
+	}
+
+	// Get icon name and open state
+	if icon, ok := dict.GetName("Name"); ok {
+		annot.Icon = string(icon)
+	}
+	if open, ok := dict.Get("Open").(Boolean); ok {
+		annot.Open = bool(open)
+	}
+
+	// Get ink paths
+	if inkList := e.resolveArray(dict.Get("InkList")); inkList != nil {
+		for _, path := range inkList {
+			points := e.resolveArray(path)
+			stroke := make([]float64, len(points))
+			for i, v := range points {
+				stroke[i] = e.toFloat(v)
+			}
+			annot.InkList = append(annot.InkList, stroke)
+		}
+	}
+
+	// Get polygon vertices
+	if vertices := e.resolveArray(dict.Get("Vertices")); vertices != nil {
+		annot.Vertices = make([]float64, len(vertices))
+		for i, v := range vertices {
+			annot.Vertices[i] = e.toFloat(v)
+		}
+	}
+
+	// Get appearance dictionary and state
+	if ap := dict.Get("AP"); ap != nil {
+		annot.AP = e.resolveDict(ap)
+	}
+	if as, ok := dict.GetName("AS"); ok {
+		annot.AS = string(as)
+	}
+
 	// Parse type-specific fields
 	switch annot.Type {
 	case AnnotLink:
 		e.parseLinkAnnotation(annot, dict)
-	case AnnotText, AnnotFreeText:
+	case AnnotText, AnnotFreeText, AnnotSquare, AnnotCircle, AnnotPolygon,
+		AnnotPolyLine, AnnotInk, AnnotStamp, AnnotCaret:
 		e.parseTextAnnotation(annot, dict)
 	case AnnotHighlight, AnnotUnderline, AnnotSquiggly, AnnotStrikeOut:
 		e.parseMarkupAnnotation(annot, dict)
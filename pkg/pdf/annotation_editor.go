@@ -0,0 +1,451 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// annotationSubtypes maps annotation types to their /Subtype names
+var annotationSubtypes = map[AnnotationType]string{
+	AnnotText:           "Text",
+	AnnotLink:           "Link",
+	AnnotFreeText:       "FreeText",
+	AnnotLine:           "Line",
+	AnnotSquare:         "Square",
+	AnnotCircle:         "Circle",
+	AnnotPolygon:        "Polygon",
+	AnnotPolyLine:       "PolyLine",
+	AnnotHighlight:      "Highlight",
+	AnnotUnderline:      "Underline",
+	AnnotSquiggly:       "Squiggly",
+	AnnotStrikeOut:      "StrikeOut",
+	AnnotStamp:          "Stamp",
+	AnnotCaret:          "Caret",
+	AnnotInk:            "Ink",
+	AnnotPopup:          "Popup",
+	AnnotFileAttachment: "FileAttachment",
+	AnnotSound:          "Sound",
+	AnnotMovie:          "Movie",
+	AnnotWidget:         "Widget",
+	AnnotScreen:         "Screen",
+	AnnotPrinterMark:    "PrinterMark",
+	AnnotTrapNet:        "TrapNet",
+	AnnotWatermark:      "Watermark",
+	Annot3D:             "3D",
+	AnnotRedact:         "Redact",
+}
+
+// annotationCounter makes generated annotation names unique
+var annotationCounter uint64
+
+// AnnotationEditor creates, modifies and deletes annotations. Every
+// annotation it writes gets a generated appearance stream, and the
+// changes are saved as an incremental update.
+type AnnotationEditor struct {
+	doc    *Document
+	writer *IncrementalWriter
+}
+
+// NewAnnotationEditor creates a new annotation editor
+func NewAnnotationEditor(doc *Document) *AnnotationEditor {
+	return &AnnotationEditor{
+		doc:    doc,
+		writer: NewIncrementalWriter(doc),
+	}
+}
+
+// AddAnnotation adds an annotation to a page. The annotation type selects
+// the subtype; a Rect left empty is computed from QuadPoints, InkList,
+// Vertices or L. Text annotations get a popup window. For links, an
+// Action with S "GoTo" may give D as an Integer page number.
+func (e *AnnotationEditor) AddAnnotation(pageNum int, annot *Annotation) error {
+	if pageNum < 1 || pageNum > len(e.doc.Pages) {
+		return fmt.Errorf("invalid page number: %d", pageNum)
+	}
+	page := e.doc.Pages[pageNum-1]
+	if page.ref.ObjectNumber == 0 {
+		return fmt.Errorf("page %d object reference unknown", pageNum)
+	}
+
+	now := formatPDFDate(time.Now())
+	dict := Dictionary{
+		"Type": Name("Annot"),
+		"P":    page.ref,
+		"F":    Integer(AnnotFlagPrint),
+	}
+	if isMarkupAnnotation(annot.Type) {
+		dict["CreationDate"] = String{Value: []byte(now)}
+	}
+	if annot.Name == "" {
+		annot.Name = fmt.Sprintf("annot-%x-%d", time.Now().UnixNano(), atomic.AddUint64(&annotationCounter, 1))
+	}
+	if err := e.fillDictionary(dict, annot); err != nil {
+		return err
+	}
+
+	ref := e.writer.ReserveObject()
+	annots := e.pageAnnots(page)
+	annots = append(annots, ref)
+
+	if annot.Type == AnnotText || annot.Popup != nil {
+		popupRef := e.writer.AddObject(e.popupDictionary(annot, ref, page.ref))
+		dict["Popup"] = popupRef
+		annots = append(annots, popupRef)
+	}
+
+	e.writeAppearance(dict)
+	e.writer.UpdateObject(ref, dict)
+	e.writer.EditDictionary(page.ref)["Annots"] = annots
+
+	annot.page = pageNum
+	annot.ref = ref
+	return nil
+}
+
+// UpdateAnnotation writes the changed fields of an annotation obtained
+// from AnnotationExtractor or AddAnnotation and regenerates its appearance.
+// Entries not represented by Annotation are preserved.
+func (e *AnnotationEditor) UpdateAnnotation(annot *Annotation) error {
+	if annot.ref.ObjectNumber == 0 {
+		return fmt.Errorf("annotation is not an indirect object")
+	}
+
+	dict := e.writer.EditDictionary(annot.ref)
+	if len(dict) == 0 {
+		return fmt.Errorf("annotation %d not found", annot.ref.ObjectNumber)
+	}
+	if err := e.fillDictionary(dict, annot); err != nil {
+		return err
+	}
+
+	if popupRef, ok := dict.Get("Popup").(Reference); ok {
+		e.writer.EditDictionary(popupRef)["Open"] = Boolean(annot.Open)
+	}
+
+	e.writeAppearance(dict)
+	return nil
+}
+
+// DeleteAnnotation removes an annotation, and its popup, from its page
+func (e *AnnotationEditor) DeleteAnnotation(annot *Annotation) error {
+	if annot.ref.ObjectNumber == 0 {
+		return fmt.Errorf("annotation is not an indirect object")
+	}
+	if annot.page < 1 || annot.page > len(e.doc.Pages) {
+		return fmt.Errorf("invalid page number: %d", annot.page)
+	}
+	page := e.doc.Pages[annot.page-1]
+
+	remove := map[int]bool{annot.ref.ObjectNumber: true}
+	if dict, ok := resolveDict(e.doc, annot.ref); ok {
+		if popupRef, ok := dict.Get("Popup").(Reference); ok {
+			remove[popupRef.ObjectNumber] = true
+		}
+	}
+
+	var kept Array
+	found := false
+	for _, obj := range e.pageAnnots(page) {
+		if ref, ok := obj.(Reference); ok && remove[ref.ObjectNumber] {
+			found = true
+			continue
+		}
+		kept = append(kept, obj)
+	}
+	if !found {
+		return fmt.Errorf("annotation %d not found on page %d", annot.ref.ObjectNumber, annot.page)
+	}
+
+	pageDict := e.writer.EditDictionary(page.ref)
+	if len(kept) > 0 {
+		pageDict["Annots"] = kept
+	} else {
+		delete(pageDict, "Annots")
+	}
+
+	annot.ref = Reference{}
+	return nil
+}
+
+// Bytes returns the updated document
+func (e *AnnotationEditor) Bytes() ([]byte, error) {
+	return e.writer.Bytes()
+}
+
+// WriteToFile writes the updated document to a file
+func (e *AnnotationEditor) WriteToFile(filename string) error {
+	data, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// pageAnnots returns the current annotation array of a page, including
+// pending changes
+func (e *AnnotationEditor) pageAnnots(page *Page) Array {
+	pageDict := e.writer.EditDictionary(page.ref)
+	annots, _ := resolveArray(e.doc, pageDict.Get("Annots"))
+	return append(Array(nil), annots...)
+}
+
+// writeAppearance generates the normal appearance of an annotation
+func (e *AnnotationEditor) writeAppearance(dict Dictionary) {
+	if ap := buildAnnotationAppearance(e.doc, dict); ap != nil {
+		dict["AP"] = Dictionary{"N": e.writer.AddObject(*ap)}
+	}
+}
+
+// popupDictionary creates the popup annotation of a markup annotation
+func (e *AnnotationEditor) popupDictionary(annot *Annotation, parent, page Reference) Dictionary {
+	rect := Array{
+		Real(annot.Rect.URX), Real(annot.Rect.URY - 100),
+		Real(annot.Rect.URX + 180), Real(annot.Rect.URY),
+	}
+	if annot.Popup != nil && annot.Popup.Rect != (AnnotRect{}) {
+		r := annot.Popup.Rect
+		rect = Array{Real(r.LLX), Real(r.LLY), Real(r.URX), Real(r.URY)}
+	}
+	return Dictionary{
+		"Type":    Name("Annot"),
+		"Subtype": Name("Popup"),
+		"Rect":    rect,
+		"Parent":  parent,
+		"P":       page,
+		"Open":    Boolean(annot.Open),
+	}
+}
+
+// fillDictionary stores the fields of an annotation in its dictionary
+func (e *AnnotationEditor) fillDictionary(dict Dictionary, annot *Annotation) error {
+	subtype := annot.Subtype
+	if subtype == "" {
+		subtype = annotationSubtypes[annot.Type]
+	}
+	if subtype == "" {
+		return fmt.Errorf("unknown annotation type %d", annot.Type)
+	}
+	dict["Subtype"] = Name(subtype)
+
+	if annot.Rect == (AnnotRect{}) {
+		rect, ok := annotationBounds(annot)
+		if !ok {
+			return fmt.Errorf("%s annotation needs a rectangle", subtype)
+		}
+		annot.Rect = rect
+	}
+	dict["Rect"] = Array{Real(annot.Rect.LLX), Real(annot.Rect.LLY), Real(annot.Rect.URX), Real(annot.Rect.URY)}
+
+	setText := func(key Name, value string) {
+		if value != "" {
+			dict[key] = textString(value)
+		} else {
+			delete(dict, key)
+		}
+	}
+	setNumbers := func(key Name, values []float64) {
+		if len(values) > 0 {
+			dict[key] = floatsToArray(values)
+		} else {
+			delete(dict, key)
+		}
+	}
+
+	setText("Contents", annot.Contents)
+	setText("NM", annot.Name)
+	setText("T", annot.Title)
+	setText("Subj", annot.Subject)
+	if annot.RC != "" {
+		dict["RC"] = textString(annot.RC)
+	}
+	if annot.DS != "" {
+		dict["DS"] = textString(annot.DS)
+	}
+	annot.Modified = formatPDFDate(time.Now())
+	dict["M"] = String{Value: []byte(annot.Modified)}
+
+	if annot.Flags != 0 {
+		dict["F"] = Integer(annot.Flags)
+	}
+	setNumbers("C", annot.Color)
+	setNumbers("IC", annot.IC)
+	setNumbers("Border", annot.Border)
+	setNumbers("QuadPoints", annot.QuadPoints)
+	setNumbers("L", annot.L)
+	setNumbers("Vertices", annot.Vertices)
+	if annot.BorderWidth > 0 {
+		dict["BS"] = Dictionary{"W": Real(annot.BorderWidth)}
+	}
+	if annot.CA > 0 && annot.CA < 1 {
+		dict["CA"] = Real(annot.CA)
+	} else {
+		delete(dict, "CA")
+	}
+
+	if len(annot.LE) == 2 {
+		dict["LE"] = Array{Name(annot.LE[0]), Name(annot.LE[1])}
+	}
+	if len(annot.InkList) > 0 {
+		inkList := make(Array, len(annot.InkList))
+		for i, path := range annot.InkList {
+			inkList[i] = floatsToArray(path)
+		}
+		dict["InkList"] = inkList
+	}
+
+	switch annot.Type {
+	case AnnotText:
+		if annot.Icon == "" {
+			annot.Icon = "Note"
+		}
+		dict["Open"] = Boolean(annot.Open)
+	case AnnotStamp:
+		if annot.Icon == "" {
+			annot.Icon = "Draft"
+		}
+	case AnnotFreeText:
+		if annot.DA == "" {
+			annot.DA = "/Helv 12 Tf 0 g"
+		}
+		dict["Q"] = Integer(annot.Q)
+	case AnnotLink:
+		if annot.Border == nil {
+			dict["Border"] = Array{Integer(0), Integer(0), Integer(0)}
+		}
+		if annot.Action != nil {
+			action, err := e.actionDictionary(annot.Action)
+			if err != nil {
+				return err
+			}
+			dict["A"] = action
+		}
+		if annot.Dest != nil {
+			dict["Dest"] = e.destination(annot.Dest)
+		}
+	}
+	if annot.Icon != "" {
+		dict["Name"] = Name(annot.Icon)
+	}
+	if annot.DA != "" {
+		dict["DA"] = String{Value: []byte(annot.DA)}
+	}
+
+	return nil
+}
+
+// actionDictionary converts an Action to an action dictionary
+func (e *AnnotationEditor) actionDictionary(action *Action) (Dictionary, error) {
+	s := action.S
+	if s == "" {
+		s = action.Type
+	}
+	dict := Dictionary{"S": Name(s)}
+
+	switch s {
+	case "URI":
+		dict["URI"] = String{Value: []byte(action.URI)}
+	case "GoTo":
+		if action.D == nil {
+			return nil, fmt.Errorf("GoTo action needs a destination")
+		}
+		dict["D"] = e.destination(action.D)
+	case "GoToR", "Launch":
+		dict["F"] = textString(action.F)
+		if action.D != nil {
+			dict["D"] = action.D
+		}
+		if action.NewWindow {
+			dict["NewWindow"] = Boolean(true)
+		}
+	case "JavaScript":
+		dict["JS"] = textString(action.JS)
+	default:
+		return nil, fmt.Errorf("unsupported action type %q", s)
+	}
+
+	if action.Next != nil {
+		next, err := e.actionDictionary(action.Next)
+		if err != nil {
+			return nil, err
+		}
+		dict["Next"] = next
+	}
+	return dict, nil
+}
+
+// destination converts a page number to an explicit destination; other
+// destinations are used as given
+func (e *AnnotationEditor) destination(dest Object) Object {
+	if n, ok := dest.(Integer); ok && n >= 1 && int(n) <= len(e.doc.Pages) {
+		return Array{e.doc.Pages[n-1].ref, Name("Fit")}
+	}
+	return dest
+}
+
+// isMarkupAnnotation reports whether an annotation type is a markup
+// annotation (PDF 32000-1:2008, 12.5.6.2)
+func isMarkupAnnotation(t AnnotationType) bool {
+	switch t {
+	case AnnotLink, AnnotPopup, AnnotWidget, AnnotScreen, AnnotPrinterMark,
+		AnnotTrapNet, AnnotWatermark, Annot3D, AnnotMovie:
+		return false
+	}
+	return true
+}
+
+// annotationBounds computes a rectangle enclosing the geometry of an
+// annotation, padded by its border width
+func annotationBounds(annot *Annotation) (AnnotRect, bool) {
+	var points []float64
+	points = append(points, annot.QuadPoints...)
+	points = append(points, annot.Vertices...)
+	points = append(points, annot.L...)
+	for _, path := range annot.InkList {
+		points = append(points, path...)
+	}
+	if len(points) < 2 {
+		return AnnotRect{}, false
+	}
+
+	r := AnnotRect{LLX: math.Inf(1), LLY: math.Inf(1), URX: math.Inf(-1), URY: math.Inf(-1)}
+	for i := 0; i+1 < len(points); i += 2 {
+		r.LLX = math.Min(r.LLX, points[i])
+		r.URX = math.Max(r.URX, points[i])
+		r.LLY = math.Min(r.LLY, points[i+1])
+		r.URY = math.Max(r.URY, points[i+1])
+	}
+
+	pad := 0.0
+	if len(annot.QuadPoints) == 0 {
+		width := annot.BorderWidth
+		if width == 0 {
+			width = 1
+		}
+		pad = width
+		if len(annot.L) > 0 {
+			// Room for line endings
+			pad = 3*width + 6
+		}
+	}
+	r.LLX -= pad
+	r.LLY -= pad
+	r.URX += pad
+	r.URY += pad
+	return r, true
+}
+
+// floatsToArray converts numbers to a PDF array
+func floatsToArray(values []float64) Array {
+	arr := make(Array, len(values))
+	for i, v := range values {
+		if v == math.Trunc(v) && math.Abs(v) < 1e9 {
+			arr[i] = Integer(v)
+		} else {
+			arr[i] = Real(v)
+		}
+	}
+	return arr
+}
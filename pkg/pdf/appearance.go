@@ -0,0 +1,658 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// helveticaWidths holds the Helvetica glyph widths for character codes 32-126
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // 32-47
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 48-63
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // 64-79
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // 80-95
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // 96-111
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // 112-126
+}
+
+// helveticaTextWidth returns the width of WinAnsi encoded text set in
+// Helvetica at the given size
+func helveticaTextWidth(text []byte, size float64) float64 {
+	total := 0
+	for _, c := range text {
+		if c >= 32 && int(c-32) < len(helveticaWidths) {
+			total += helveticaWidths[c-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// encodeWinAnsi converts text to WinAnsiEncoding. Characters outside the
+// encoding are replaced by '?'.
+func encodeWinAnsi(text string) []byte {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r == '\t':
+			out = append(out, ' ')
+		case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+			out = append(out, byte(r))
+		default:
+			if c, ok := winAnsiSpecial[r]; ok {
+				out = append(out, c)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+	return out
+}
+
+// winAnsiSpecial maps the characters WinAnsiEncoding places in 0x80-0x9F
+var winAnsiSpecial = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// defaultAppearance holds the parts of a /DA string used when generating
+// appearance streams
+type defaultAppearance struct {
+	Font  Name
+	Size  float64
+	Color string // colour operators, e.g. "0 g"
+}
+
+// parseDefaultAppearance parses a default appearance string such as
+// "/Helv 12 Tf 0 g"
+func parseDefaultAppearance(da string) defaultAppearance {
+	result := defaultAppearance{Font: "Helv", Color: "0 g"}
+
+	ops, err := NewContentStreamParser([]byte(da)).ParseOperations()
+	if err != nil {
+		return result
+	}
+
+	for _, op := range ops {
+		switch op.Operator {
+		case "Tf":
+			if len(op.Operands) == 2 {
+				if name, ok := op.Operands[0].(Name); ok {
+					result.Font = name
+				}
+				result.Size = objectToFloat(op.Operands[1])
+			}
+		case "g", "rg", "k":
+			parts := make([]string, 0, len(op.Operands)+1)
+			for _, operand := range op.Operands {
+				parts = append(parts, formatNum(objectToFloat(operand)))
+			}
+			parts = append(parts, op.Operator)
+			result.Color = strings.Join(parts, " ")
+		}
+	}
+
+	return result
+}
+
+// formatNum formats a number for use in a content stream
+func formatNum(v float64) string {
+	v = math.Round(v*10000) / 10000
+	if v == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// contentString returns text as a literal string operand
+func contentString(text []byte) string {
+	var buf bytes.Buffer
+	writeString(&buf, String{Value: text})
+	return buf.String()
+}
+
+// helveticaFont returns a font dictionary for the standard Helvetica font
+func helveticaFont() Dictionary {
+	return Dictionary{
+		"Type":     Name("Font"),
+		"Subtype":  Name("Type1"),
+		"BaseFont": Name("Helvetica"),
+		"Encoding": Name("WinAnsiEncoding"),
+	}
+}
+
+// newAppearanceStream creates a form XObject for an appearance stream
+func newAppearanceStream(width, height float64, content []byte, resources Dictionary) Stream {
+	dict := Dictionary{
+		"Type":    Name("XObject"),
+		"Subtype": Name("Form"),
+		"BBox":    Array{Integer(0), Integer(0), Real(width), Real(height)},
+	}
+	if resources != nil {
+		dict["Resources"] = resources
+	}
+	return newFlateStream(dict, content)
+}
+
+// textFieldAppearance generates the content of a text or choice field
+// appearance. quadding is 0 (left), 1 (centred) or 2 (right).
+func textFieldAppearance(value string, da defaultAppearance, quadding int, width, height float64, multiline bool) []byte {
+	const padding = 2
+
+	size := da.Size
+	if size <= 0 {
+		size = 12
+		if !multiline {
+			size = math.Min(12, math.Max(4, (height-2*padding)*0.8))
+		}
+	}
+
+	var lines [][]byte
+	if multiline {
+		for _, para := range strings.Split(strings.ReplaceAll(value, "\r\n", "\n"), "\n") {
+			lines = append(lines, wrapText(encodeWinAnsi(para), size, width-2*padding)...)
+		}
+	} else {
+		lines = [][]byte{encodeWinAnsi(strings.NewReplacer("\r", " ", "\n", " ").Replace(value))}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("/Tx BMC\nq\n")
+	fmt.Fprintf(&buf, "1 1 %s %s re W n\n", formatNum(width-2), formatNum(height-2))
+	buf.WriteString("BT\n")
+	fmt.Fprintf(&buf, "%s %s Tf %s\n", da.Font, formatNum(size), da.Color)
+
+	y := (height-size)/2 + 0.22*size
+	if multiline {
+		y = height - padding - size
+	}
+	for _, line := range lines {
+		x := float64(padding)
+		switch quadding {
+		case 1:
+			x = (width - helveticaTextWidth(line, size)) / 2
+		case 2:
+			x = width - padding - helveticaTextWidth(line, size)
+		}
+		fmt.Fprintf(&buf, "1 0 0 1 %s %s Tm %s Tj\n", formatNum(x), formatNum(y), contentString(line))
+		y -= size * 1.15
+	}
+
+	buf.WriteString("ET\nQ\nEMC\n")
+	return buf.Bytes()
+}
+
+// wrapText breaks WinAnsi encoded text into lines no wider than maxWidth
+func wrapText(text []byte, size, maxWidth float64) [][]byte {
+	words := bytes.Fields(text)
+	if len(words) == 0 {
+		return [][]byte{nil}
+	}
+
+	var lines [][]byte
+	var line []byte
+	for _, word := range words {
+		candidate := word
+		if len(line) > 0 {
+			candidate = append(append(append([]byte{}, line...), ' '), word...)
+		}
+		if len(line) > 0 && helveticaTextWidth(candidate, size) > maxWidth {
+			lines = append(lines, line)
+			line = append([]byte{}, word...)
+			continue
+		}
+		line = candidate
+	}
+	return append(lines, line)
+}
+
+// annotationNumbers returns the numbers of an array entry of an annotation
+func annotationNumbers(doc *Document, obj Object) []float64 {
+	var arr Array
+	if doc != nil {
+		arr, _ = resolveArray(doc, obj)
+	} else {
+		arr, _ = obj.(Array)
+	}
+	if len(arr) == 0 {
+		return nil
+	}
+	nums := make([]float64, len(arr))
+	for i, v := range arr {
+		nums[i] = objectToFloat(v)
+	}
+	return nums
+}
+
+// colorOperator returns the operator setting a fill or stroke colour with
+// 1 (gray), 3 (RGB) or 4 (CMYK) components, or "" for no colour
+func colorOperator(c []float64, stroke bool) string {
+	var op string
+	switch len(c) {
+	case 1:
+		op = "g"
+	case 3:
+		op = "rg"
+	case 4:
+		op = "k"
+	default:
+		return ""
+	}
+	if stroke {
+		op = strings.ToUpper(op)
+	}
+	parts := make([]string, 0, len(c)+1)
+	for _, v := range c {
+		parts = append(parts, formatNum(v))
+	}
+	return strings.Join(append(parts, op), " ")
+}
+
+// buildAnnotationAppearance generates a normal appearance stream for a
+// markup, stamp or link annotation. The stream's bounding box equals the
+// annotation rectangle so that drawing happens in page coordinates.
+// It returns nil for annotation types that have no appearance.
+func buildAnnotationAppearance(doc *Document, annot Dictionary) *Stream {
+	subtype, _ := annot.GetName("Subtype")
+	rect := annotationNumbers(doc, annot.Get("Rect"))
+	if len(rect) != 4 {
+		return nil
+	}
+	r := normalizeRect(Rectangle{rect[0], rect[1], rect[2], rect[3]})
+
+	color := annotationNumbers(doc, annot.Get("C"))
+	interior := annotationNumbers(doc, annot.Get("IC"))
+	width := 1.0
+	if bs, ok := annot.Get("BS").(Dictionary); ok {
+		if w := bs.Get("W"); w != nil {
+			width = objectToFloat(w)
+		}
+	} else if border := annotationNumbers(doc, annot.Get("Border")); len(border) >= 3 {
+		width = border[2]
+	}
+
+	var buf bytes.Buffer
+	resources := Dictionary{}
+	gs := Dictionary{"Type": Name("ExtGState")}
+	if ca := annot.Get("CA"); ca != nil {
+		gs["CA"] = Real(objectToFloat(ca))
+		gs["ca"] = Real(objectToFloat(ca))
+	}
+
+	switch subtype {
+	case "Highlight":
+		if color == nil {
+			color = []float64{1, 1, 0}
+		}
+		gs["BM"] = Name("Multiply")
+		buf.WriteString(colorOperator(color, false) + "\n")
+		forEachQuad(doc, annot, func(q quad) {
+			fmt.Fprintf(&buf, "%s %s m %s %s l %s %s l %s %s l h f\n",
+				formatNum(q[0]), formatNum(q[1]), formatNum(q[2]), formatNum(q[3]),
+				formatNum(q[6]), formatNum(q[7]), formatNum(q[4]), formatNum(q[5]))
+		})
+
+	case "Underline", "StrikeOut", "Squiggly":
+		if color == nil {
+			color = []float64{0, 0, 0}
+		}
+		buf.WriteString(colorOperator(color, true) + "\n")
+		forEachQuad(doc, annot, func(q quad) {
+			h := q.height()
+			lw := math.Max(h/14, 0.5)
+			// Unit vector along the baseline and towards the top edge
+			dx, dy := q[6]-q[4], q[7]-q[5]
+			length := math.Hypot(dx, dy)
+			if length == 0 || h == 0 {
+				return
+			}
+			ux, uy := dx/length, dy/length
+			nx, ny := (q[0]-q[4])/h, (q[1]-q[5])/h
+
+			offset := h * 0.1
+			if subtype == "StrikeOut" {
+				offset = h * 0.4
+			}
+			x0, y0 := q[4]+nx*offset, q[5]+ny*offset
+			fmt.Fprintf(&buf, "%s w\n", formatNum(lw))
+			if subtype != "Squiggly" {
+				fmt.Fprintf(&buf, "%s %s m %s %s l S\n",
+					formatNum(x0), formatNum(y0), formatNum(x0+dx), formatNum(y0+dy))
+				return
+			}
+			step := h / 6
+			amp := h / 12
+			fmt.Fprintf(&buf, "%s %s m\n", formatNum(x0), formatNum(y0))
+			for i, t := 1, step; t <= length; i, t = i+1, t+step {
+				a := amp * float64(i%2*2-1)
+				fmt.Fprintf(&buf, "%s %s l\n",
+					formatNum(x0+ux*t+nx*(a+amp)), formatNum(y0+uy*t+ny*(a+amp)))
+			}
+			buf.WriteString("S\n")
+		})
+
+	case "Ink":
+		if color == nil {
+			color = []float64{0, 0, 0}
+		}
+		fmt.Fprintf(&buf, "%s %s w 1 J 1 j\n", colorOperator(color, true), formatNum(width))
+		inkList, _ := resolveArrayIn(doc, annot.Get("InkList"))
+		for _, path := range inkList {
+			points := annotationNumbers(doc, path)
+			writePolyline(&buf, points, false)
+			buf.WriteString("S\n")
+		}
+
+	case "Square", "Circle":
+		if color == nil && interior == nil {
+			color = []float64{0, 0, 0}
+		}
+		inset := width / 2
+		x, y := r.LLX+inset, r.LLY+inset
+		w, h := r.URX-r.LLX-width, r.URY-r.LLY-width
+		fmt.Fprintf(&buf, "%s w\n", formatNum(width))
+		paint := paintOperator(&buf, color, interior, width, true)
+		if subtype == "Square" {
+			fmt.Fprintf(&buf, "%s %s %s %s re\n", formatNum(x), formatNum(y), formatNum(w), formatNum(h))
+		} else {
+			writeEllipse(&buf, x+w/2, y+h/2, w/2, h/2)
+		}
+		buf.WriteString(paint + "\n")
+
+	case "Line":
+		if color == nil {
+			color = []float64{0, 0, 0}
+		}
+		l := annotationNumbers(doc, annot.Get("L"))
+		if len(l) != 4 {
+			return nil
+		}
+		fmt.Fprintf(&buf, "%s w %s\n", formatNum(width), colorOperator(color, true))
+		if op := colorOperator(interior, false); op != "" {
+			buf.WriteString(op + "\n")
+		} else {
+			buf.WriteString(colorOperator(color, false) + "\n")
+		}
+		fmt.Fprintf(&buf, "%s %s m %s %s l S\n", formatNum(l[0]), formatNum(l[1]), formatNum(l[2]), formatNum(l[3]))
+		var endings [2]Name
+		if le, ok := annot.Get("LE").(Array); ok && len(le) == 2 {
+			endings[0], _ = le[0].(Name)
+			endings[1], _ = le[1].(Name)
+		}
+		writeLineEnding(&buf, endings[0], l[0], l[1], l[2], l[3], width)
+		writeLineEnding(&buf, endings[1], l[2], l[3], l[0], l[1], width)
+
+	case "Polygon", "PolyLine":
+		if color == nil && interior == nil {
+			color = []float64{0, 0, 0}
+		}
+		fmt.Fprintf(&buf, "%s w 1 j\n", formatNum(width))
+		paint := paintOperator(&buf, color, interior, width, subtype == "Polygon")
+		writePolyline(&buf, annotationNumbers(doc, annot.Get("Vertices")), subtype == "Polygon")
+		buf.WriteString(paint + "\n")
+
+	case "Text":
+		if color == nil {
+			color = []float64{1, 1, 0}
+		}
+		w, h := r.URX-r.LLX, r.URY-r.LLY
+		fmt.Fprintf(&buf, "q 1 0 0 1 %s %s cm\n", formatNum(r.LLX), formatNum(r.LLY))
+		fmt.Fprintf(&buf, "%s 0 G 0.6 w\n", colorOperator(color, false))
+		fmt.Fprintf(&buf, "0.3 0.3 %s %s re B\n", formatNum(w-0.6), formatNum(h-0.6))
+		for i := 1; i <= 3; i++ {
+			y := h * float64(i) / 4
+			fmt.Fprintf(&buf, "%s %s m %s %s l S\n", formatNum(w*0.2), formatNum(y), formatNum(w*0.8), formatNum(y))
+		}
+		buf.WriteString("Q\n")
+
+	case "FreeText":
+		w, h := r.URX-r.LLX, r.URY-r.LLY
+		var daStr string
+		if da, ok := annot.Get("DA").(String); ok {
+			daStr = string(da.Value)
+		}
+		da := parseDefaultAppearance(daStr)
+		if da.Size <= 0 {
+			da.Size = 12
+		}
+		quadding := 0
+		if q, ok := annot.GetInt("Q"); ok {
+			quadding = int(q)
+		}
+		var contents string
+		if c, ok := annot.Get("Contents").(String); ok {
+			contents = c.Text()
+		}
+
+		fmt.Fprintf(&buf, "q 1 0 0 1 %s %s cm\n", formatNum(r.LLX), formatNum(r.LLY))
+		if op := colorOperator(interior, false); op != "" {
+			fmt.Fprintf(&buf, "%s 0 0 %s %s re f\n", op, formatNum(w), formatNum(h))
+		}
+		if width > 0 && color != nil {
+			fmt.Fprintf(&buf, "%s %s w %s %s %s %s re S\n", colorOperator(color, true), formatNum(width),
+				formatNum(width/2), formatNum(width/2), formatNum(w-width), formatNum(h-width))
+		}
+		padding := width + 2
+		fmt.Fprintf(&buf, "%s %s %s %s re W n\n", formatNum(padding), formatNum(padding),
+			formatNum(w-2*padding), formatNum(h-2*padding))
+		fmt.Fprintf(&buf, "BT\n%s %s Tf %s\n", da.Font, formatNum(da.Size), da.Color)
+		y := h - padding - da.Size
+		for _, para := range strings.Split(strings.ReplaceAll(contents, "\r", "\n"), "\n") {
+			for _, line := range wrapText(encodeWinAnsi(para), da.Size, w-2*padding) {
+				x := padding
+				switch quadding {
+				case 1:
+					x = (w - helveticaTextWidth(line, da.Size)) / 2
+				case 2:
+					x = w - padding - helveticaTextWidth(line, da.Size)
+				}
+				fmt.Fprintf(&buf, "1 0 0 1 %s %s Tm %s Tj\n", formatNum(x), formatNum(y), contentString(line))
+				y -= da.Size * 1.15
+			}
+		}
+		buf.WriteString("ET\nQ\n")
+		resources["Font"] = Dictionary{da.Font: helveticaFont()}
+
+	case "Stamp":
+		if color == nil {
+			color = []float64{0.8, 0.1, 0.1}
+		}
+		icon, _ := annot.GetName("Name")
+		if icon == "" {
+			icon = "Draft"
+		}
+		label := encodeWinAnsi(strings.ToUpper(stampLabel(string(icon))))
+		w, h := r.URX-r.LLX, r.URY-r.LLY
+		lw := math.Max(2, math.Min(w, h)/20)
+		size := math.Min(h*0.6, (w-4*lw)/math.Max(helveticaTextWidth(label, 1), 0.1))
+		fmt.Fprintf(&buf, "q 1 0 0 1 %s %s cm\n", formatNum(r.LLX), formatNum(r.LLY))
+		fmt.Fprintf(&buf, "%s %s %s w\n", colorOperator(color, true), colorOperator(color, false), formatNum(lw))
+		fmt.Fprintf(&buf, "%s %s %s %s re S\n", formatNum(lw/2), formatNum(lw/2), formatNum(w-lw), formatNum(h-lw))
+		fmt.Fprintf(&buf, "BT /Helv %s Tf %s %s Td %s Tj ET\nQ\n", formatNum(size),
+			formatNum((w-helveticaTextWidth(label, size))/2), formatNum((h-size*0.72)/2), contentString(label))
+		resources["Font"] = Dictionary{"Helv": helveticaFont()}
+
+	case "Caret":
+		if color == nil {
+			color = []float64{0, 0, 1}
+		}
+		w, h := r.URX-r.LLX, r.URY-r.LLY
+		fmt.Fprintf(&buf, "%s %s %s m %s %s l %s %s l h f\n", colorOperator(color, false),
+			formatNum(r.LLX), formatNum(r.LLY), formatNum(r.LLX+w/2), formatNum(r.LLY+h),
+			formatNum(r.URX), formatNum(r.LLY))
+
+	case "Link":
+		if border := annotationNumbers(doc, annot.Get("Border")); len(border) >= 3 && border[2] > 0 {
+			if color == nil {
+				color = []float64{0, 0, 1}
+			}
+			fmt.Fprintf(&buf, "%s %s w %s %s %s %s re S\n", colorOperator(color, true), formatNum(border[2]),
+				formatNum(r.LLX+border[2]/2), formatNum(r.LLY+border[2]/2),
+				formatNum(r.URX-r.LLX-border[2]), formatNum(r.URY-r.LLY-border[2]))
+		}
+
+	default:
+		return nil
+	}
+
+	content := buf.Bytes()
+	if len(gs) > 1 {
+		resources["ExtGState"] = Dictionary{"GS0": gs}
+		content = append([]byte("/GS0 gs\n"), content...)
+	}
+
+	dict := Dictionary{
+		"Type":    Name("XObject"),
+		"Subtype": Name("Form"),
+		"BBox":    Array{Real(r.LLX), Real(r.LLY), Real(r.URX), Real(r.URY)},
+	}
+	if len(resources) > 0 {
+		dict["Resources"] = resources
+	}
+	stream := newFlateStream(dict, content)
+	return &stream
+}
+
+// resolveArrayIn resolves an array, with or without a document
+func resolveArrayIn(doc *Document, obj Object) (Array, bool) {
+	if doc != nil {
+		return resolveArray(doc, obj)
+	}
+	arr, ok := obj.(Array)
+	return arr, ok
+}
+
+// quad holds the four corners of a QuadPoints entry in the order used by
+// Acrobat: upper left, upper right, lower left, lower right
+type quad [8]float64
+
+// height returns the distance between the top and bottom edges
+func (q quad) height() float64 {
+	return math.Hypot(q[0]-q[4], q[1]-q[5])
+}
+
+// forEachQuad calls fn for each quadrilateral in /QuadPoints
+func forEachQuad(doc *Document, annot Dictionary, fn func(q quad)) {
+	points := annotationNumbers(doc, annot.Get("QuadPoints"))
+	for i := 0; i+8 <= len(points); i += 8 {
+		var q quad
+		copy(q[:], points[i:i+8])
+		fn(q)
+	}
+}
+
+// writePolyline writes a path through flat x,y coordinates
+func writePolyline(buf *bytes.Buffer, points []float64, closed bool) {
+	for i := 0; i+1 < len(points); i += 2 {
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		fmt.Fprintf(buf, "%s %s %s\n", formatNum(points[i]), formatNum(points[i+1]), op)
+	}
+	if closed && len(points) >= 4 {
+		buf.WriteString("h\n")
+	}
+}
+
+// writeEllipse writes an ellipse approximated by four Bézier curves
+func writeEllipse(buf *bytes.Buffer, cx, cy, rx, ry float64) {
+	const k = 0.5523
+	fmt.Fprintf(buf, "%s %s m\n", formatNum(cx+rx), formatNum(cy))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c\n", formatNum(cx+rx), formatNum(cy+ry*k),
+		formatNum(cx+rx*k), formatNum(cy+ry), formatNum(cx), formatNum(cy+ry))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c\n", formatNum(cx-rx*k), formatNum(cy+ry),
+		formatNum(cx-rx), formatNum(cy+ry*k), formatNum(cx-rx), formatNum(cy))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c\n", formatNum(cx-rx), formatNum(cy-ry*k),
+		formatNum(cx-rx*k), formatNum(cy-ry), formatNum(cx), formatNum(cy-ry))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c\n", formatNum(cx+rx*k), formatNum(cy-ry),
+		formatNum(cx+rx), formatNum(cy-ry*k), formatNum(cx+rx), formatNum(cy))
+	buf.WriteString("h\n")
+}
+
+// paintOperator sets the stroke and fill colours and returns the operator
+// that paints the path constructed afterwards
+func paintOperator(buf *bytes.Buffer, stroke, fill []float64, width float64, closed bool) string {
+	strokeOp := colorOperator(stroke, true)
+	fillOp := ""
+	if closed {
+		fillOp = colorOperator(fill, false)
+	}
+	if strokeOp != "" {
+		buf.WriteString(strokeOp + "\n")
+	}
+	if fillOp != "" {
+		buf.WriteString(fillOp + "\n")
+	}
+	switch {
+	case strokeOp != "" && fillOp != "" && width > 0:
+		return "B"
+	case fillOp != "":
+		return "f"
+	case strokeOp != "" && width > 0:
+		return "S"
+	}
+	return "n"
+}
+
+// writeLineEnding draws a line ending at (x, y) for a line coming from
+// (fromX, fromY)
+func writeLineEnding(buf *bytes.Buffer, style Name, x, y, fromX, fromY, width float64) {
+	dx, dy := x-fromX, y-fromY
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return
+	}
+	ux, uy := dx/length, dy/length
+	size := 3*width + 6
+
+	switch style {
+	case "OpenArrow", "ClosedArrow":
+		// Arrow wings at 30 degrees from the line
+		const cos30, sin30 = 0.8660254, 0.5
+		lx := x - size*(ux*cos30-uy*sin30)
+		ly := y - size*(uy*cos30+ux*sin30)
+		rx := x - size*(ux*cos30+uy*sin30)
+		ry := y - size*(uy*cos30-ux*sin30)
+		fmt.Fprintf(buf, "%s %s m %s %s l %s %s l", formatNum(lx), formatNum(ly),
+			formatNum(x), formatNum(y), formatNum(rx), formatNum(ry))
+		if style == "ClosedArrow" {
+			buf.WriteString(" h B\n")
+		} else {
+			buf.WriteString(" S\n")
+		}
+	case "Circle":
+		writeEllipse(buf, x, y, size/2, size/2)
+		buf.WriteString("B\n")
+	case "Square":
+		fmt.Fprintf(buf, "%s %s %s %s re B\n", formatNum(x-size/2), formatNum(y-size/2), formatNum(size), formatNum(size))
+	case "Butt":
+		fmt.Fprintf(buf, "%s %s m %s %s l S\n", formatNum(x-uy*size/2), formatNum(y+ux*size/2),
+			formatNum(x+uy*size/2), formatNum(y-ux*size/2))
+	}
+}
+
+// stampLabel returns the text shown for a standard stamp icon name
+func stampLabel(icon string) string {
+	switch icon {
+	case "NotApproved":
+		return "Not Approved"
+	case "AsIs":
+		return "As Is"
+	case "ForComment":
+		return "For Comment"
+	case "NotForPublicRelease":
+		return "Not For Public Release"
+	case "ForPublicRelease":
+		return "For Public Release"
+	case "TopSecret":
+		return "Top Secret"
+	}
+	return icon
+}
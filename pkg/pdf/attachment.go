@@ -1,8 +1,14 @@
 package pdf
 
 import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"mime"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -14,6 +20,8 @@ type Attachment struct {
 	CreationDate time.Time
 	ModDate      time.Time
 	MimeType     string
+	Relationship AFRelationship
+	CheckSum     []byte // MD5 of the file, if recorded
 	Data         []byte
 	doc          *Document
 	streamRef    Reference
@@ -183,22 +191,27 @@ func extractAttachment(doc *Document, name string, fileSpecObj Object) *Attachme
 	// Get filename
 	if f := fileSpec.Get("F"); f != nil {
 		if str, ok := f.(String); ok {
-			att.Name = string(str.Value)
+			att.Name = str.Text()
 		}
 	}
 	if uf := fileSpec.Get("UF"); uf != nil {
 		if str, ok := uf.(String); ok {
-			att.Name = string(str.Value)
+			att.Name = str.Text()
 		}
 	}
 
 	// Get description
 	if desc := fileSpec.Get("Desc"); desc != nil {
 		if str, ok := desc.(String); ok {
-			att.Description = string(str.Value)
+			att.Description = str.Text()
 		}
 	}
 
+	// Get PDF/A-3 relationship
+	if rel, ok := fileSpec.GetName("AFRelationship"); ok {
+		att.Relationship = AFRelationship(rel)
+	}
+
 	// Get embedded file stream
 	efObj := fileSpec.Get("EF")
 	if efObj == nil {
@@ -248,6 +261,9 @@ func extractAttachment(doc *Document, name string, fileSpecObj Object) *Attachme
 						att.ModDate = parsePDFDate(string(str.Value))
 					}
 				}
+				if sum, ok := paramsDict.Get("CheckSum").(String); ok {
+					att.CheckSum = sum.Value
+				}
 			}
 		}
 
@@ -269,20 +285,310 @@ func extractAttachment(doc *Document, name string, fileSpecObj Object) *Attachme
 	return att
 }
 
-// AddAttachment adds an attachment to a PDF document
-// Note: This is a simplified implementation that stores the attachment info
-// Full implementation would require modifying the PDF structure
-func AddAttachment(doc *Document, filename string, data []byte) error {
-	// Store attachment info in document for later writing
-	// This is a placeholder - full implementation would modify PDF structure
+// AFRelationship describes how an associated file relates to the
+// document (ISO 32000-2, Table 43). PDF/A-3 requires a relationship for
+// every embedded file; ZUGFeRD and Factur-X invoices use Alternative or
+// Data for their XML.
+type AFRelationship string
+
+// Associated file relationships
+const (
+	RelationshipSource           AFRelationship = "Source"
+	RelationshipData             AFRelationship = "Data"
+	RelationshipAlternative      AFRelationship = "Alternative"
+	RelationshipSupplement       AFRelationship = "Supplement"
+	RelationshipEncryptedPayload AFRelationship = "EncryptedPayload"
+	RelationshipFormData         AFRelationship = "FormData"
+	RelationshipSchema           AFRelationship = "Schema"
+	RelationshipUnspecified      AFRelationship = "Unspecified"
+)
+
+// AttachmentOptions describes an embedded file written by
+// AttachmentEditor
+type AttachmentOptions struct {
+	Description  string
+	MimeType     string         // e.g. "text/xml"; guessed from the file name when empty
+	Relationship AFRelationship // Unspecified when empty
+	CreationDate time.Time      // ModDate when zero
+	ModDate      time.Time      // the current time when zero
+}
+
+// nameTreeEntry is a key and value of a name tree leaf
+type nameTreeEntry struct {
+	key   String
+	value Object
+}
+
+// AttachmentEditor adds, replaces and removes embedded files. Files are
+// written as compressed EmbeddedFile streams with size, dates and MD5
+// checksum, listed in the EmbeddedFiles name tree and associated with the
+// document through the catalog's AF array, as PDF/A-3 requires. The
+// changes are saved as an incremental update.
+type AttachmentEditor struct {
+	doc     *Document
+	writer  *IncrementalWriter
+	entries []nameTreeEntry
+}
+
+// NewAttachmentEditor creates a new attachment editor
+func NewAttachmentEditor(doc *Document) *AttachmentEditor {
+	e := &AttachmentEditor{
+		doc:    doc,
+		writer: NewIncrementalWriter(doc),
+	}
+	if names, ok := resolveDict(doc, doc.Root.Get("Names")); ok {
+		e.entries = collectNameTree(doc, names.Get("EmbeddedFiles"), 0)
+	}
+	return e
+}
+
+// collectNameTree returns the leaf entries of a name tree
+func collectNameTree(doc *Document, node Object, depth int) []nameTreeEntry {
+	dict, ok := resolveDict(doc, node)
+	if !ok || depth > 32 {
+		return nil
+	}
+	var entries []nameTreeEntry
+	if names, ok := resolveArray(doc, dict.Get("Names")); ok {
+		for i := 0; i+1 < len(names); i += 2 {
+			if key, ok := names[i].(String); ok {
+				entries = append(entries, nameTreeEntry{key: key, value: names[i+1]})
+			}
+		}
+	}
+	kids, _ := resolveArray(doc, dict.Get("Kids"))
+	for _, kid := range kids {
+		entries = append(entries, collectNameTree(doc, kid, depth+1)...)
+	}
+	return entries
+}
+
+// AddAttachment embeds data under name. It fails if an attachment with
+// that name exists.
+func (e *AttachmentEditor) AddAttachment(name string, data []byte, opts *AttachmentOptions) error {
+	if name == "" {
+		return fmt.Errorf("attachment name is empty")
+	}
+	if e.find(name) >= 0 {
+		return fmt.Errorf("attachment %q already exists", name)
+	}
+	if opts == nil {
+		opts = &AttachmentOptions{}
+	}
+	ref := e.writer.AddObject(e.fileSpec(name, data, opts))
+	e.entries = append(e.entries, nameTreeEntry{key: textString(name), value: ref})
+	e.associate(nil, ref)
+	return e.writeTree()
+}
+
+// ReplaceAttachment replaces the contents of the attachment with the given
+// name, or adds it if there is none. Options left empty keep the
+// description, MIME type, relationship and creation date of the old file.
+func (e *AttachmentEditor) ReplaceAttachment(name string, data []byte, opts *AttachmentOptions) error {
+	i := e.find(name)
+	if i < 0 {
+		return e.AddAttachment(name, data, opts)
+	}
+	merged := AttachmentOptions{}
+	if opts != nil {
+		merged = *opts
+	}
+	old := extractAttachment(e.doc, name, e.entries[i].value)
+	if old != nil {
+		name = old.Name
+		if merged.Description == "" {
+			merged.Description = old.Description
+		}
+		if merged.MimeType == "" {
+			merged.MimeType = old.MimeType
+		}
+		if merged.Relationship == "" {
+			merged.Relationship = old.Relationship
+		}
+		if merged.CreationDate.IsZero() {
+			merged.CreationDate = old.CreationDate
+		}
+	}
+
+	ref := e.writer.AddObject(e.fileSpec(name, data, &merged))
+	e.associate(e.entries[i].value, ref)
+	e.entries[i].value = ref
+	return e.writeTree()
+}
+
+// RemoveAttachment removes the attachment with the given name from the
+// name tree and the document's associated files
+func (e *AttachmentEditor) RemoveAttachment(name string) error {
+	i := e.find(name)
+	if i < 0 {
+		return fmt.Errorf("attachment %q not found", name)
+	}
+	e.associate(e.entries[i].value, nil)
+	e.entries = append(e.entries[:i], e.entries[i+1:]...)
+	return e.writeTree()
+}
+
+// Bytes returns the document with the changes applied
+func (e *AttachmentEditor) Bytes() ([]byte, error) {
+	return e.writer.Bytes()
+}
+
+// WriteToFile writes the updated document to a file
+func (e *AttachmentEditor) WriteToFile(filename string) error {
+	data, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// find returns the index of the entry whose key or file name is name, or
+// -1
+func (e *AttachmentEditor) find(name string) int {
+	for i, entry := range e.entries {
+		if entry.key.Text() == name {
+			return i
+		}
+	}
+	for i, entry := range e.entries {
+		if att := extractAttachment(e.doc, "", entry.value); att != nil && att.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// fileSpec writes the embedded file stream and returns the file
+// specification that refers to it
+func (e *AttachmentEditor) fileSpec(name string, data []byte, opts *AttachmentOptions) Dictionary {
+	modDate := opts.ModDate
+	if modDate.IsZero() {
+		modDate = time.Now()
+	}
+	creationDate := opts.CreationDate
+	if creationDate.IsZero() {
+		creationDate = modDate
+	}
+	mimeType := opts.MimeType
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = strings.TrimSpace(mimeType[:i])
+	}
+	relationship := opts.Relationship
+	if relationship == "" {
+		relationship = RelationshipUnspecified
+	}
+
+	sum := md5.Sum(data)
+	streamDict := Dictionary{
+		"Type": Name("EmbeddedFile"),
+		"Params": Dictionary{
+			"Size":         Integer(len(data)),
+			"CreationDate": String{Value: []byte(formatPDFDate(creationDate))},
+			"ModDate":      String{Value: []byte(formatPDFDate(modDate))},
+			"CheckSum":     String{Value: sum[:], IsHex: true},
+		},
+	}
+	if mimeType != "" {
+		streamDict["Subtype"] = Name(mimeType)
+	}
+	streamRef := e.writer.AddObject(newFlateStream(streamDict, data))
+
+	spec := Dictionary{
+		"Type":           Name("Filespec"),
+		"F":              textString(name),
+		"UF":             textString(name),
+		"EF":             Dictionary{"F": streamRef, "UF": streamRef},
+		"AFRelationship": Name(relationship),
+	}
+	if opts.Description != "" {
+		spec["Desc"] = textString(opts.Description)
+	}
+	return spec
+}
+
+// associate replaces old with repl in the catalog's AF array. A nil old
+// appends repl; a nil repl removes old.
+func (e *AttachmentEditor) associate(old, repl Object) {
+	rootRef, err := e.writer.RootRef()
+	if err != nil {
+		return
+	}
+	catalog := e.writer.EditDictionary(rootRef)
+	af, _ := resolveArray(e.doc, catalog.Get("AF"))
+
+	var updated Array
+	oldRef, isRef := old.(Reference)
+	for _, item := range af {
+		if ref, ok := item.(Reference); ok && isRef && ref == oldRef {
+			continue
+		}
+		updated = append(updated, item)
+	}
+	if repl != nil {
+		updated = append(updated, repl)
+	}
+	if len(updated) == 0 {
+		delete(catalog, "AF")
+	} else {
+		catalog["AF"] = updated
+	}
+}
+
+// writeTree writes the entries as a single sorted name tree leaf
+func (e *AttachmentEditor) writeTree() error {
+	rootRef, err := e.writer.RootRef()
+	if err != nil {
+		return err
+	}
+	catalog := e.writer.EditDictionary(rootRef)
+
+	var names Dictionary
+	if ref, ok := catalog.Get("Names").(Reference); ok {
+		names = e.writer.EditDictionary(ref)
+	} else {
+		orig, _ := resolveDict(e.doc, catalog.Get("Names"))
+		names = cloneDict(orig)
+		catalog["Names"] = names
+	}
+
+	sort.SliceStable(e.entries, func(i, j int) bool {
+		return bytes.Compare(e.entries[i].key.Value, e.entries[j].key.Value) < 0
+	})
+	if len(e.entries) == 0 {
+		delete(names, "EmbeddedFiles")
+		return nil
+	}
+	leaf := make(Array, 0, 2*len(e.entries))
+	for _, entry := range e.entries {
+		leaf = append(leaf, entry.key, entry.value)
+	}
+	tree := Dictionary{"Names": leaf}
+	if ref, ok := names.Get("EmbeddedFiles").(Reference); ok {
+		e.writer.UpdateObject(ref, tree)
+	} else {
+		names["EmbeddedFiles"] = tree
+	}
 	return nil
 }
 
-// WriteToFile writes the document to a file
-// Note: This is a simplified implementation
+// AddAttachment embeds a file in doc under its base name. The change is
+// written by WriteToFile.
+func AddAttachment(doc *Document, filename string, data []byte) error {
+	if doc.attachments == nil {
+		doc.attachments = NewAttachmentEditor(doc)
+	}
+	return doc.attachments.AddAttachment(filepath.Base(filename), data, nil)
+}
+
+// WriteToFile writes the document to a file, including attachments added
+// with AddAttachment
 func WriteToFile(doc *Document, filename string) error {
-	// For now, just copy the original data
-	// Full implementation would serialize the modified document
+	if doc.attachments != nil {
+		return doc.attachments.WriteToFile(filename)
+	}
 	return os.WriteFile(filename, doc.data, 0644)
 }
 
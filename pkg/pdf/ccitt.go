@@ -87,6 +87,14 @@ var blackMakeupCodes = []CCITTCode{
 	{0x5B, 13, 1600}, {0x64, 13, 1664}, {0x65, 13, 1728},
 }
 
+// 黑白共用的扩展构成码 (1792 至 2560)
+var extendedMakeupCodes = []CCITTCode{
+	{0x08, 11, 1792}, {0x0C, 11, 1856}, {0x0D, 11, 1920}, {0x12, 12, 1984},
+	{0x13, 12, 2048}, {0x14, 12, 2112}, {0x15, 12, 2176}, {0x16, 12, 2240},
+	{0x17, 12, 2304}, {0x1C, 12, 2368}, {0x1D, 12, 2432}, {0x1E, 12, 2496},
+	{0x1F, 12, 2560},
+}
+
 // 2D 模式编码
 var twoDCodes = []CCITTCode{
 	{0x01, 4, 0}, // Pass
@@ -290,23 +298,25 @@ func (dec *CCITTDecoder) decodeGroup3_2D(reader *BitReader, rowBytes int) []byte
 		maxRows = 10000
 	}
 
-	kCounter := 0
-
 	for row < maxRows {
 		// 检查 EOL
 		if dec.EndOfLine {
 			dec.skipEOL(reader)
 		}
 
+		// 每行前的标记位：1 为 1D 编码，0 为 2D 编码
+		tag, err := reader.ReadBit()
+		if err != nil {
+			break
+		}
+
 		var rowData []byte
-		if kCounter == 0 {
+		if tag == 1 || refLine == nil {
 			// 1D 编码行
 			rowData = dec.decode1DRow(reader)
-			kCounter = dec.K
 		} else {
 			// 2D 编码行
 			rowData = dec.decode2DRow(reader, refLine)
-			kCounter--
 		}
 
 		if rowData == nil {
@@ -352,6 +362,10 @@ func (dec *CCITTDecoder) decodeGroup4(reader *BitReader, rowBytes int) []byte {
 		result = append(result, rowData[:rowBytes]...)
 		refLine = rowData
 		row++
+
+		if dec.EncodedByteAlign {
+			reader.ByteAlign()
+		}
 	}
 
 	return result
@@ -386,111 +400,47 @@ func (dec *CCITTDecoder) decode1DRow(reader *BitReader) []byte {
 	return row
 }
 
-// decode2DRow 解码 2D 行
+// decode2DRow 解码 2D 行，复用 MMR 解码器的变化元素算法
 func (dec *CCITTDecoder) decode2DRow(reader *BitReader, refLine []byte) []byte {
-	rowBytes := (dec.Columns + 7) / 8
-	row := make([]byte, rowBytes)
-	a0 := -1
-	isWhite := true
+	m := &mmrDecoder{
+		r:     &jbig2BitReader{data: reader.data, pos: reader.pos, bitPos: reader.bitPos},
+		width: dec.Columns,
+		ref:   changingElements(refLine, dec.Columns),
+	}
+	eol, err := m.decodeRow()
+	reader.pos, reader.bitPos = m.r.pos, m.r.bitPos
+	if err != nil || eol {
+		return nil
+	}
+	return packChangingElements(m.ref, dec.Columns)
+}
 
-	for a0 < dec.Columns {
-		mode := dec.decode2DMode(reader)
-		if mode < 0 {
-			return nil
+// changingElements 返回一行（1 为黑）中颜色变化的位置，
+// 行首之前视为白色，末尾追加三个宽度哨兵
+func changingElements(row []byte, width int) []int {
+	var elems []int
+	color := 0
+	for x := 0; x < width; x++ {
+		v := 0
+		if x/8 < len(row) {
+			v = int(row[x/8]>>(7-x%8)) & 1
 		}
-
-		switch mode {
-		case 0: // Pass
-			b1 := dec.findB1(refLine, a0, isWhite)
-			b2 := dec.findB2(refLine, b1, isWhite)
-			a0 = b2
-
-		case 1: // Horizontal
-			// 读取两个游程
-			run1 := dec.decodeRun(reader, isWhite)
-			run2 := dec.decodeRun(reader, !isWhite)
-			if run1 < 0 || run2 < 0 {
-				return nil
-			}
-
-			// 填充第一个游程
-			for i := 0; i < run1 && a0+1+i < dec.Columns; i++ {
-				if !isWhite {
-					byteIdx := (a0 + 1 + i) / 8
-					bitIdx := 7 - ((a0 + 1 + i) % 8)
-					if byteIdx < len(row) {
-						row[byteIdx] |= 1 << bitIdx
-					}
-				}
-			}
-			a0 += run1
-
-			// 填充第二个游程
-			for i := 0; i < run2 && a0+1+i < dec.Columns; i++ {
-				if isWhite {
-					byteIdx := (a0 + 1 + i) / 8
-					bitIdx := 7 - ((a0 + 1 + i) % 8)
-					if byteIdx < len(row) {
-						row[byteIdx] |= 1 << bitIdx
-					}
-				}
-			}
-			a0 += run2
-
-		case 2: // V(0)
-			b1 := dec.findB1(refLine, a0, isWhite)
-			a1 := b1
-			dec.fillRun(row, a0+1, a1, !isWhite)
-			a0 = a1
-			isWhite = !isWhite
-
-		case 3: // VR(1)
-			b1 := dec.findB1(refLine, a0, isWhite)
-			a1 := b1 + 1
-			dec.fillRun(row, a0+1, a1, !isWhite)
-			a0 = a1
-			isWhite = !isWhite
-
-		case 4: // VR(2)
-			b1 := dec.findB1(refLine, a0, isWhite)
-			a1 := b1 + 2
-			dec.fillRun(row, a0+1, a1, !isWhite)
-			a0 = a1
-			isWhite = !isWhite
-
-		case 5: // VR(3)
-			b1 := dec.findB1(refLine, a0, isWhite)
-			a1 := b1 + 3
-			dec.fillRun(row, a0+1, a1, !isWhite)
-			a0 = a1
-			isWhite = !isWhite
-
-		case 6: // VL(1)
-			b1 := dec.findB1(refLine, a0, isWhite)
-			a1 := b1 - 1
-			dec.fillRun(row, a0+1, a1, !isWhite)
-			a0 = a1
-			isWhite = !isWhite
-
-		case 7: // VL(2)
-			b1 := dec.findB1(refLine, a0, isWhite)
-			a1 := b1 - 2
-			dec.fillRun(row, a0+1, a1, !isWhite)
-			a0 = a1
-			isWhite = !isWhite
-
-		case 8: // VL(3)
-			b1 := dec.findB1(refLine, a0, isWhite)
-			a1 := b1 - 3
-			dec.fillRun(row, a0+1, a1, !isWhite)
-			a0 = a1
-			isWhite = !isWhite
-
-		default:
-			return nil
+		if v != color {
+			elems = append(elems, x)
+			color = v
 		}
 	}
+	return append(elems, width, width, width)
+}
 
+// packChangingElements 将变化元素还原为打包的行数据（1 为黑）
+func packChangingElements(elems []int, width int) []byte {
+	row := make([]byte, (width+7)/8)
+	for j := 0; j+1 < len(elems); j += 2 {
+		for x := elems[j]; x < elems[j+1] && x < width; x++ {
+			row[x/8] |= 0x80 >> (x % 8)
+		}
+	}
 	return row
 }
 
@@ -508,12 +458,13 @@ func (dec *CCITTDecoder) decodeRun(reader *BitReader, isWhite bool) int {
 			makeupCodes = blackMakeupCodes
 		}
 
-		// 尝试匹配构成码
+		// 尝试匹配构成码（含黑白共用的扩展构成码）
 		found := false
-		for _, code := range makeupCodes {
+		for _, code := range append(makeupCodes, extendedMakeupCodes...) {
 			bits, err := reader.PeekBits(code.Bits)
 			if err != nil {
-				return -1
+				// 数据末尾可能只够匹配较短的编码
+				continue
 			}
 			if bits == code.Code {
 				reader.SkipBits(code.Bits)
@@ -531,7 +482,8 @@ func (dec *CCITTDecoder) decodeRun(reader *BitReader, isWhite bool) int {
 		for _, code := range termCodes {
 			bits, err := reader.PeekBits(code.Bits)
 			if err != nil {
-				return -1
+				// 数据末尾可能只够匹配较短的编码
+				continue
 			}
 			if bits == code.Code {
 				reader.SkipBits(code.Bits)
@@ -545,85 +497,6 @@ func (dec *CCITTDecoder) decodeRun(reader *BitReader, isWhite bool) int {
 	}
 }
 
-// decode2DMode 解码 2D 模式
-func (dec *CCITTDecoder) decode2DMode(reader *BitReader) int {
-	for _, code := range twoDCodes {
-		bits, err := reader.PeekBits(code.Bits)
-		if err != nil {
-			return -1
-		}
-		if bits == code.Code {
-			reader.SkipBits(code.Bits)
-			return code.RunLen
-		}
-	}
-	return -1
-}
-
-// findB1 查找参考行中的 b1 位置
-func (dec *CCITTDecoder) findB1(refLine []byte, a0 int, isWhite bool) int {
-	start := a0 + 1
-	if start < 0 {
-		start = 0
-	}
-
-	// 查找与当前颜色相反的第一个变化点
-	for i := start; i < dec.Columns; i++ {
-		refColor := dec.getPixel(refLine, i)
-		if (isWhite && refColor) || (!isWhite && !refColor) {
-			return i
-		}
-	}
-	return dec.Columns
-}
-
-// findB2 查找参考行中的 b2 位置
-func (dec *CCITTDecoder) findB2(refLine []byte, b1 int, isWhite bool) int {
-	if b1 >= dec.Columns {
-		return dec.Columns
-	}
-
-	// 从 b1 开始查找下一个变化点
-	for i := b1 + 1; i < dec.Columns; i++ {
-		refColor := dec.getPixel(refLine, i)
-		prevColor := dec.getPixel(refLine, i-1)
-		if refColor != prevColor {
-			return i
-		}
-	}
-	return dec.Columns
-}
-
-// getPixel 获取像素值
-func (dec *CCITTDecoder) getPixel(row []byte, col int) bool {
-	if col < 0 || col >= dec.Columns {
-		return false
-	}
-	byteIdx := col / 8
-	bitIdx := 7 - (col % 8)
-	if byteIdx >= len(row) {
-		return false
-	}
-	return (row[byteIdx] & (1 << bitIdx)) != 0
-}
-
-// fillRun 填充游程
-func (dec *CCITTDecoder) fillRun(row []byte, start, end int, isBlack bool) {
-	if !isBlack {
-		return
-	}
-	for i := start; i < end && i < dec.Columns; i++ {
-		if i < 0 {
-			continue
-		}
-		byteIdx := i / 8
-		bitIdx := 7 - (i % 8)
-		if byteIdx < len(row) {
-			row[byteIdx] |= 1 << bitIdx
-		}
-	}
-}
-
 // skipEOL 跳过 EOL 标记
 func (dec *CCITTDecoder) skipEOL(reader *BitReader) {
 	// EOL 是 11 个 0 后跟 1 个 1
@@ -0,0 +1,170 @@
+package pdf
+
+import "fmt"
+
+// CCITTEncoder CCITT Group 4 (ITU-T T.6) 传真编码器
+type CCITTEncoder struct {
+	Columns    int  // 图像宽度（像素）
+	Rows       int  // 图像高度（像素）
+	BlackIs1   bool // 输入中黑色是否为 1
+	EndOfBlock bool // 是否写入 EOFB 标记
+}
+
+// NewCCITTEncoder 创建 Group 4 编码器，输入为 PDF 默认的 0 表示黑色
+func NewCCITTEncoder(columns, rows int) *CCITTEncoder {
+	return &CCITTEncoder{Columns: columns, Rows: rows, EndOfBlock: true}
+}
+
+// ccittBitWriter 按高位优先写入位
+type ccittBitWriter struct {
+	out  []byte
+	nbit int
+}
+
+func (w *ccittBitWriter) write(code, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.nbit%8 == 0 {
+			w.out = append(w.out, 0)
+		}
+		if (code>>i)&1 != 0 {
+			w.out[len(w.out)-1] |= 0x80 >> (w.nbit % 8)
+		}
+		w.nbit++
+	}
+}
+
+// writeMode 写入 2D 模式码，索引与 twoDCodes 一致
+func (w *ccittBitWriter) writeMode(mode int) {
+	c := twoDCodes[mode]
+	w.write(c.Code, c.Bits)
+}
+
+// writeRun 写入一个游程：构成码后跟终止码
+func (w *ccittBitWriter) writeRun(run int, black bool) {
+	term, makeup := whiteTermCodes, whiteMakeupCodes
+	if black {
+		term, makeup = blackTermCodes, blackMakeupCodes
+	}
+	for run >= 2560 {
+		c := extendedMakeupCodes[len(extendedMakeupCodes)-1]
+		w.write(c.Code, c.Bits)
+		run -= 2560
+	}
+	if run >= 64 {
+		var c CCITTCode
+		if n := run / 64; n <= len(makeup) {
+			c = makeup[n-1]
+		} else {
+			c = extendedMakeupCodes[n-len(makeup)-1]
+		}
+		w.write(c.Code, c.Bits)
+		run %= 64
+	}
+	c := term[run]
+	w.write(c.Code, c.Bits)
+}
+
+// Encode 编码打包的位图数据（每行按字节对齐）
+func (enc *CCITTEncoder) Encode(data []byte) ([]byte, error) {
+	if enc.Columns <= 0 || enc.Rows < 0 {
+		return nil, fmt.Errorf("invalid CCITT image size %dx%d", enc.Columns, enc.Rows)
+	}
+	rowBytes := (enc.Columns + 7) / 8
+	if len(data) < rowBytes*enc.Rows {
+		return nil, fmt.Errorf("CCITT encode: need %d bytes, have %d", rowBytes*enc.Rows, len(data))
+	}
+
+	w := &ccittBitWriter{}
+	width := enc.Columns
+	ref := []int{width, width, width}
+	line := make([]byte, rowBytes)
+	for y := 0; y < enc.Rows; y++ {
+		// 变化元素以 1 为黑计算
+		copy(line, data[y*rowBytes:])
+		if !enc.BlackIs1 {
+			for i := range line {
+				line[i] = ^line[i]
+			}
+		}
+		cur := changingElements(line, width)
+		enc.encodeRow(w, cur, ref)
+		ref = cur
+	}
+	if enc.EndOfBlock {
+		// EOFB：两个 EOL
+		w.write(0x001, 12)
+		w.write(0x001, 12)
+	}
+	return w.out, nil
+}
+
+// encodeRow 按 T.4 4.2.1.3 编码一行，cur 与 ref 为带哨兵的变化元素
+func (enc *CCITTEncoder) encodeRow(w *ccittBitWriter, cur, ref []int) {
+	width := enc.Columns
+	a0, color := -1, 0
+	i, j := 0, 0
+	for a0 < width {
+		// a1 为 a0 右侧的第一个变化元素，a2 紧随其后
+		for i < len(cur)-2 && cur[i] <= a0 {
+			i++
+		}
+		a1, a2 := cur[i], cur[i+1]
+
+		// b1 为参考行上 a0 右侧、颜色与 a0 相反的第一个变化元素
+		for j > 0 && ref[j-1] > a0 {
+			j--
+		}
+		for j < len(ref)-2 && (ref[j] <= a0 || j&1 != color) {
+			j++
+		}
+		b1, b2 := ref[j], ref[j+1]
+
+		switch {
+		case b2 < a1:
+			w.writeMode(0)
+			a0 = b2
+		case a1-b1 >= -3 && a1-b1 <= 3:
+			switch d := a1 - b1; {
+			case d == 0:
+				w.writeMode(2)
+			case d > 0:
+				w.writeMode(2 + d)
+			default:
+				w.writeMode(5 - d)
+			}
+			a0 = a1
+			color ^= 1
+		default:
+			w.writeMode(1)
+			w.writeRun(a1-max(a0, 0), color == 1)
+			w.writeRun(a2-a1, color == 0)
+			a0 = a2
+		}
+	}
+}
+
+// DecodeParms 返回与编码结果匹配的 CCITTFaxDecode 参数
+func (enc *CCITTEncoder) DecodeParms() Dictionary {
+	params := Dictionary{
+		"K":       Integer(-1),
+		"Columns": Integer(enc.Columns),
+		"Rows":    Integer(enc.Rows),
+	}
+	if enc.BlackIs1 {
+		params["BlackIs1"] = Boolean(true)
+	}
+	if !enc.EndOfBlock {
+		params["EndOfBlock"] = Boolean(false)
+	}
+	return params
+}
+
+// EncodeCCITTFax 以 Group 4 编码位图，返回数据及其解码参数
+func EncodeCCITTFax(data []byte, columns, rows int) ([]byte, Dictionary, error) {
+	enc := NewCCITTEncoder(columns, rows)
+	out, err := enc.Encode(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CCITT encode error: %w", err)
+	}
+	return out, enc.DecodeParms(), nil
+}
@@ -0,0 +1,307 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Object identifiers used in CMS signed data (RFC 5652) and its
+// CAdES and timestamp extensions
+var (
+	oidData               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttrContentType    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidAttrSigningCert    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 12}
+	oidAttrSigningCertV2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidAttrTimeStampToken = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidRSAEncryption      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA1WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA256WithRSA      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidRSAPSS             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidECDSAWithSHA1      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidECDSAWithSHA256    = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384    = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512    = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidDigestSHA1         = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidDigestSHA224       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}
+)
+
+// cmsContentInfo is the outer structure of a CMS message
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+// cmsEncapContent holds the signed content, absent for detached signatures
+type cmsEncapContent struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+}
+
+// cmsSignedData is the SignedData content type
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo cmsEncapContent
+	Certificates     asn1.RawValue   `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue   `asn1:"optional,tag:1"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+// cmsIssuerAndSerial identifies a certificate by issuer and serial number
+type cmsIssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// cmsSignerInfo holds the signature of one signer
+type cmsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue // IssuerAndSerialNumber or [0] SubjectKeyIdentifier
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// cmsAttribute is a signed or unsigned attribute
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// essCertIDv2 identifies the signing certificate (RFC 5035)
+type essCertIDv2 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertHash      []byte
+	IssuerSerial  essIssuerSerial `asn1:"optional"`
+}
+
+// essCertID identifies the signing certificate by its SHA-1 hash (RFC 2634)
+type essCertID struct {
+	CertHash     []byte
+	IssuerSerial essIssuerSerial `asn1:"optional"`
+}
+
+type signingCertificate struct {
+	Certs []essCertID
+}
+
+type essIssuerSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// digestAlgorithmOID returns the identifier of a digest algorithm
+func digestAlgorithmOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA1:
+		return oidDigestSHA1, nil
+	case crypto.SHA256:
+		return oidDigestSHA256, nil
+	case crypto.SHA384:
+		return oidDigestSHA384, nil
+	case crypto.SHA512:
+		return oidDigestSHA512, nil
+	}
+	return nil, fmt.Errorf("cms: unsupported digest algorithm %v", hash)
+}
+
+// signatureAlgorithmOID returns the signature algorithm identifier for a
+// public key and digest
+func signatureAlgorithmOID(pub crypto.PublicKey, hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PublicKey:
+		oids := map[crypto.Hash]asn1.ObjectIdentifier{
+			crypto.SHA1:   oidECDSAWithSHA1,
+			crypto.SHA256: oidECDSAWithSHA256,
+			crypto.SHA384: oidECDSAWithSHA384,
+			crypto.SHA512: oidECDSAWithSHA512,
+		}
+		if oid, ok := oids[hash]; ok {
+			return pkix.AlgorithmIdentifier{Algorithm: oid}, nil
+		}
+	default:
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("cms: unsupported key type %T", pub)
+	}
+	return pkix.AlgorithmIdentifier{}, fmt.Errorf("cms: unsupported digest algorithm %v", hash)
+}
+
+// cmsSetOf encodes DER values as a SET OF, sorted as DER requires
+func cmsSetOf(values ...[]byte) asn1.RawValue {
+	sorted := append([][]byte(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(sorted, nil)}
+}
+
+// derTagged encodes content as a constructed value with the given tag
+func derTagged(class, tag int, content []byte) []byte {
+	der, _ := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: content})
+	return der
+}
+
+// cmsAttributes encodes attributes as the content of a SET OF Attribute
+func cmsAttributes(attrs []cmsAttribute) ([]byte, error) {
+	encoded := make([][]byte, 0, len(attrs))
+	for _, attr := range attrs {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, der)
+	}
+	return cmsSetOf(encoded...).Bytes, nil
+}
+
+// newCMSAttribute creates an attribute with one DER encoded value
+func newCMSAttribute(oid asn1.ObjectIdentifier, value interface{}) (cmsAttribute, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return cmsAttribute{}, err
+	}
+	return cmsAttribute{Type: oid, Values: cmsSetOf(der)}, nil
+}
+
+// cmsSigner holds what is needed to produce a CAdES signature
+type cmsSigner struct {
+	signer crypto.Signer
+	chain  []*x509.Certificate // signing certificate first
+	hash   crypto.Hash
+	tsa    TimestampAuthority
+}
+
+// sign creates a detached CMS SignedData for content whose digest is
+// given. The signed attributes follow CAdES: content type, message digest
+// and the ESS signing certificate, but no signing time. With a timestamp
+// authority the signature value is timestamped (CAdES-T).
+func (s *cmsSigner) sign(digest []byte) ([]byte, error) {
+	if len(s.chain) == 0 {
+		return nil, fmt.Errorf("cms: no signing certificate")
+	}
+	cert := s.chain[0]
+	digestOID, err := digestAlgorithmOID(s.hash)
+	if err != nil {
+		return nil, err
+	}
+	sigAlg, err := signatureAlgorithmOID(s.signer.Public(), s.hash)
+	if err != nil {
+		return nil, err
+	}
+	digestAlg := pkix.AlgorithmIdentifier{Algorithm: digestOID, Parameters: asn1.NullRawValue}
+
+	// ESS signing certificate, with the default SHA-256 algorithm omitted
+	certHash := s.hash.New()
+	certHash.Write(cert.Raw)
+	certID := essCertIDv2{
+		CertHash: certHash.Sum(nil),
+		IssuerSerial: essIssuerSerial{
+			Issuer: asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: derTagged(asn1.ClassContextSpecific, 4, cert.RawIssuer)},
+			Serial: cert.SerialNumber,
+		},
+	}
+	if s.hash != crypto.SHA256 {
+		certID.HashAlgorithm = pkix.AlgorithmIdentifier{Algorithm: digestOID}
+	}
+
+	var attrs []cmsAttribute
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidAttrContentType, oidData},
+		{oidAttrMessageDigest, digest},
+		{oidAttrSigningCertV2, signingCertificateV2{Certs: []essCertIDv2{certID}}},
+	} {
+		attr, err := newCMSAttribute(a.oid, a.value)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	signedAttrs, err := cmsAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the attributes encoded with the SET OF tag
+	h := s.hash.New()
+	h.Write(derTagged(asn1.ClassUniversal, asn1.TagSet, signedAttrs))
+	signature, err := s.signer.Sign(rand.Reader, h.Sum(nil), s.hash)
+	if err != nil {
+		return nil, fmt.Errorf("cms: signing failed: %w", err)
+	}
+
+	sid, err := asn1.Marshal(cmsIssuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber})
+	if err != nil {
+		return nil, err
+	}
+	info := cmsSignerInfo{
+		Version:            1,
+		SID:                asn1.RawValue{FullBytes: sid},
+		DigestAlgorithm:    digestAlg,
+		SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs},
+		SignatureAlgorithm: sigAlg,
+		Signature:          signature,
+	}
+
+	if s.tsa != nil {
+		h := s.hash.New()
+		h.Write(signature)
+		token, err := s.tsa.Timestamp(h.Sum(nil), s.hash)
+		if err != nil {
+			return nil, fmt.Errorf("cms: timestamp: %w", err)
+		}
+		unsigned, err := cmsAttributes([]cmsAttribute{{
+			Type:   oidAttrTimeStampToken,
+			Values: cmsSetOf(token),
+		}})
+		if err != nil {
+			return nil, err
+		}
+		info.UnsignedAttrs = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: unsigned}
+	}
+
+	var certs []byte
+	for _, c := range s.chain {
+		certs = append(certs, c.Raw...)
+	}
+	sd := cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: cmsEncapContent{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos:      []cmsSignerInfo{info},
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(cmsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
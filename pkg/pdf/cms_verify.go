@@ -0,0 +1,384 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors reported when verifying CMS signed data
+var (
+	errCMSMalformed      = errors.New("cms: malformed signed data")
+	errCMSDigestMismatch = errors.New("cms: digest does not match the signed data")
+	errCMSBadSignature   = errors.New("cms: signature does not verify")
+	errCMSNoSigner       = errors.New("cms: signer certificate not found")
+)
+
+// cmsVerified is the outcome of verifying CMS signed data
+type cmsVerified struct {
+	signer      *x509.Certificate
+	certs       []*x509.Certificate // all certificates carried by the message
+	hash        crypto.Hash         // digest algorithm of the signer
+	content     []byte              // encapsulated content, nil when detached
+	signingTime time.Time           // signing time attribute, zero if absent
+	signature   []byte              // signature value
+	signedData  *cmsSignedData
+	signed      []cmsAttribute
+	unsigned    []cmsAttribute
+}
+
+// hashByOID maps digest algorithm identifiers to hash functions. Some
+// signers put a signature algorithm where the digest algorithm belongs, so
+// the RSA signature identifiers are accepted as well.
+var hashByOID = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidDigestSHA1, crypto.SHA1},
+	{oidDigestSHA224, crypto.SHA224},
+	{oidDigestSHA256, crypto.SHA256},
+	{oidDigestSHA384, crypto.SHA384},
+	{oidDigestSHA512, crypto.SHA512},
+	{oidSHA1WithRSA, crypto.SHA1},
+	{oidSHA256WithRSA, crypto.SHA256},
+	{oidSHA384WithRSA, crypto.SHA384},
+	{oidSHA512WithRSA, crypto.SHA512},
+}
+
+// hashFromOID returns the hash function of a digest algorithm
+func hashFromOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, h := range hashByOID {
+		if h.oid.Equal(oid) {
+			if !h.hash.Available() {
+				break
+			}
+			return h.hash, nil
+		}
+	}
+	return 0, fmt.Errorf("cms: unsupported digest algorithm %v", oid)
+}
+
+// digestOf hashes the concatenation of data
+func digestOf(hash crypto.Hash, data [][]byte) []byte {
+	h := hash.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// parseCMS parses a ContentInfo holding SignedData. Trailing bytes, such
+// as the zero padding of a signature dictionary's /Contents, are ignored.
+func parseCMS(der []byte) (*cmsSignedData, error) {
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCMSMalformed, err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("%w: content type %v is not signed data", errCMSMalformed, ci.ContentType)
+	}
+	sd := &cmsSignedData{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, sd); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCMSMalformed, err)
+	}
+	return sd, nil
+}
+
+// certificates returns the certificates of the signed data. Other
+// certificate formats, such as attribute certificates, are skipped.
+func (sd *cmsSignedData) certificates() []*x509.Certificate {
+	var certs []*x509.Certificate
+	rest := sd.Certificates.Bytes
+	for len(rest) > 0 {
+		var raw asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &raw)
+		if err != nil {
+			break
+		}
+		if raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(raw.FullBytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// cmsFindSigner returns the certificate a signer identifier refers to
+func cmsFindSigner(sid asn1.RawValue, certs []*x509.Certificate) *x509.Certificate {
+	if sid.Class == asn1.ClassContextSpecific && sid.Tag == 0 {
+		for _, cert := range certs {
+			if len(cert.SubjectKeyId) > 0 && bytes.Equal(cert.SubjectKeyId, sid.Bytes) {
+				return cert
+			}
+		}
+		return nil
+	}
+	var ias cmsIssuerAndSerial
+	if _, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+		return nil
+	}
+	for _, cert := range certs {
+		if cert.SerialNumber.Cmp(ias.Serial) == 0 && bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes) {
+			return cert
+		}
+	}
+	return nil
+}
+
+// parseCMSAttributes parses the content of a SET OF Attribute
+func parseCMSAttributes(data []byte) ([]cmsAttribute, error) {
+	var attrs []cmsAttribute
+	for len(data) > 0 {
+		var attr cmsAttribute
+		var err error
+		data, err = asn1.Unmarshal(data, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errCMSMalformed, err)
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+// cmsAttributeValue returns the first value of an attribute, or nil
+func cmsAttributeValue(attrs []cmsAttribute, oid asn1.ObjectIdentifier) []byte {
+	for _, attr := range attrs {
+		if attr.Type.Equal(oid) {
+			var value asn1.RawValue
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &value); err == nil {
+				return value.FullBytes
+			}
+		}
+	}
+	return nil
+}
+
+// verifyCMS verifies CMS signed data. The signed content is the
+// encapsulated content when present and the concatenation of detached
+// otherwise. The signature is checked against the signer's certificate
+// from the message; whether that certificate is trusted is left to the
+// caller.
+func verifyCMS(der []byte, detached [][]byte) (*cmsVerified, error) {
+	sd, err := parseCMS(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, fmt.Errorf("%w: no signer", errCMSMalformed)
+	}
+	si := sd.SignerInfos[0]
+	v := &cmsVerified{certs: sd.certificates(), signature: si.Signature, signedData: sd}
+
+	if v.hash, err = hashFromOID(si.DigestAlgorithm.Algorithm); err != nil {
+		return nil, err
+	}
+	v.signer = cmsFindSigner(si.SID, v.certs)
+	if v.signer == nil {
+		return nil, errCMSNoSigner
+	}
+
+	data := detached
+	if len(sd.EncapContentInfo.Content.Bytes) > 0 {
+		if _, err := asn1.Unmarshal(sd.EncapContentInfo.Content.Bytes, &v.content); err != nil {
+			return nil, fmt.Errorf("%w: encapsulated content: %v", errCMSMalformed, err)
+		}
+		data = [][]byte{v.content}
+	}
+	digest := digestOf(v.hash, data)
+
+	if len(si.UnsignedAttrs.Bytes) > 0 {
+		if v.unsigned, err = parseCMSAttributes(si.UnsignedAttrs.Bytes); err != nil {
+			return nil, err
+		}
+	}
+
+	// Without signed attributes the signature covers the content itself
+	if len(si.SignedAttrs.FullBytes) == 0 {
+		err := cmsCheckSignature(v.signer, si.SignatureAlgorithm, v.hash, nil, digest, si.Signature)
+		return v, err
+	}
+
+	attrs, err := parseCMSAttributes(si.SignedAttrs.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	v.signed = attrs
+	var contentType asn1.ObjectIdentifier
+	if value := cmsAttributeValue(attrs, oidAttrContentType); value != nil {
+		asn1.Unmarshal(value, &contentType)
+	}
+	if !contentType.Equal(sd.EncapContentInfo.ContentType) {
+		return nil, fmt.Errorf("%w: content type attribute does not match the content", errCMSMalformed)
+	}
+	var messageDigest []byte
+	if value := cmsAttributeValue(attrs, oidAttrMessageDigest); value != nil {
+		asn1.Unmarshal(value, &messageDigest)
+	}
+	if messageDigest == nil {
+		return nil, fmt.Errorf("%w: message digest attribute missing", errCMSMalformed)
+	}
+	if value := cmsAttributeValue(attrs, oidAttrSigningTime); value != nil {
+		asn1.Unmarshal(value, &v.signingTime)
+	}
+	if err := cmsCheckSigningCertificate(attrs, v.signer); err != nil {
+		return nil, err
+	}
+
+	// The signature covers the attributes encoded with the SET OF tag
+	message := append([]byte(nil), si.SignedAttrs.FullBytes...)
+	message[0] = 0x31
+	if err := cmsCheckSignature(v.signer, si.SignatureAlgorithm, v.hash, message, nil, si.Signature); err != nil {
+		return v, err
+	}
+	if !bytes.Equal(messageDigest, digest) {
+		return v, errCMSDigestMismatch
+	}
+	return v, nil
+}
+
+// cmsCheckSigningCertificate checks the ESS signing certificate
+// attributes, which bind the signature to the signer's certificate
+func cmsCheckSigningCertificate(attrs []cmsAttribute, cert *x509.Certificate) error {
+	mismatch := fmt.Errorf("%w: signing certificate attribute does not match the signer", errCMSMalformed)
+	if value := cmsAttributeValue(attrs, oidAttrSigningCertV2); value != nil {
+		var sc signingCertificateV2
+		if _, err := asn1.Unmarshal(value, &sc); err != nil || len(sc.Certs) == 0 {
+			return fmt.Errorf("%w: signing certificate attribute", errCMSMalformed)
+		}
+		hash := crypto.SHA256
+		if len(sc.Certs[0].HashAlgorithm.Algorithm) > 0 {
+			h, err := hashFromOID(sc.Certs[0].HashAlgorithm.Algorithm)
+			if err != nil {
+				return err
+			}
+			hash = h
+		}
+		if !bytes.Equal(digestOf(hash, [][]byte{cert.Raw}), sc.Certs[0].CertHash) {
+			return mismatch
+		}
+	}
+	if value := cmsAttributeValue(attrs, oidAttrSigningCert); value != nil {
+		var sc signingCertificate
+		if _, err := asn1.Unmarshal(value, &sc); err != nil || len(sc.Certs) == 0 {
+			return fmt.Errorf("%w: signing certificate attribute", errCMSMalformed)
+		}
+		if !bytes.Equal(digestOf(crypto.SHA1, [][]byte{cert.Raw}), sc.Certs[0].CertHash) {
+			return mismatch
+		}
+	}
+	return nil
+}
+
+// pssParameters are the RSASSA-PSS-params of RFC 4055
+type pssParameters struct {
+	Hash       asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	MGF        asn1.RawValue `asn1:"optional,explicit,tag:1"`
+	SaltLength int           `asn1:"optional,explicit,tag:2,default:20"`
+	Trailer    int           `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+// cmsCheckSignature verifies a signature value with the public key of
+// cert. The signed message is given either in full or, when the
+// signature covers the content directly, as its digest.
+func cmsCheckSignature(cert *x509.Certificate, alg pkix.AlgorithmIdentifier, hash crypto.Hash, message, digest, signature []byte) error {
+	sum := func(h crypto.Hash) ([]byte, error) {
+		if message != nil {
+			return digestOf(h, [][]byte{message}), nil
+		}
+		if h != hash {
+			return nil, fmt.Errorf("cms: signature digest %v differs from the content digest %v", h, hash)
+		}
+		return digest, nil
+	}
+
+	var err error
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if alg.Algorithm.Equal(oidRSAPSS) {
+			var params pssParameters
+			if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+				return fmt.Errorf("%w: RSA-PSS parameters: %v", errCMSMalformed, err)
+			}
+			h := crypto.SHA1
+			if len(params.Hash.Bytes) > 0 {
+				var hashAlg pkix.AlgorithmIdentifier
+				if _, err := asn1.Unmarshal(params.Hash.Bytes, &hashAlg); err != nil {
+					return fmt.Errorf("%w: RSA-PSS parameters: %v", errCMSMalformed, err)
+				}
+				if h, err = hashFromOID(hashAlg.Algorithm); err != nil {
+					return err
+				}
+			}
+			d, err := sum(h)
+			if err != nil {
+				return err
+			}
+			err = rsa.VerifyPSS(pub, h, d, signature, &rsa.PSSOptions{SaltLength: params.SaltLength, Hash: h})
+			if err != nil {
+				return errCMSBadSignature
+			}
+			return nil
+		}
+		d, e := sum(hash)
+		if e != nil {
+			return e
+		}
+		err = rsa.VerifyPKCS1v15(pub, hash, d, signature)
+	case *ecdsa.PublicKey:
+		d, e := sum(hash)
+		if e != nil {
+			return e
+		}
+		if !ecdsa.VerifyASN1(pub, d, signature) {
+			err = errCMSBadSignature
+		}
+	case ed25519.PublicKey:
+		if message == nil {
+			return fmt.Errorf("cms: Ed25519 signatures require signed attributes")
+		}
+		if !ed25519.Verify(pub, message, signature) {
+			err = errCMSBadSignature
+		}
+	default:
+		return fmt.Errorf("cms: unsupported public key type %T", pub)
+	}
+	if err != nil {
+		return errCMSBadSignature
+	}
+	return nil
+}
+
+// verifyTimestampToken verifies an RFC 3161 TimeStampToken and checks
+// that it stamps the concatenation of data
+func verifyTimestampToken(token []byte, data [][]byte) (*tstInfo, *cmsVerified, error) {
+	v, err := verifyCMS(token, nil)
+	if err != nil {
+		return nil, v, err
+	}
+	if !v.signedData.EncapContentInfo.ContentType.Equal(oidTSTInfo) {
+		return nil, v, fmt.Errorf("%w: token does not contain TSTInfo", errCMSMalformed)
+	}
+	info := &tstInfo{}
+	if _, err := asn1.Unmarshal(v.content, info); err != nil {
+		return nil, v, fmt.Errorf("%w: TSTInfo: %v", errCMSMalformed, err)
+	}
+	hash, err := hashFromOID(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, v, err
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digestOf(hash, data)) {
+		return info, v, fmt.Errorf("%w: timestamp imprint", errCMSDigestMismatch)
+	}
+	return info, v, nil
+}
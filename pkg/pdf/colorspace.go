@@ -0,0 +1,864 @@
+package pdf
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// maxColorSpaceDepth limits the nesting of colour spaces, e.g. an Indexed
+// space over a Separation over an ICCBased space
+const maxColorSpaceDepth = 8
+
+// ColorSpace is a PDF colour space (ISO 32000-1, 8.6). It converts colour
+// components to sRGB for display.
+type ColorSpace interface {
+	// Family returns the colour space family, such as "DeviceRGB" or
+	// "Separation"
+	Family() string
+	// NComponents returns the number of colour components
+	NComponents() int
+	// RGB converts components in the space's own ranges to sRGB values
+	// in [0, 1]
+	RGB(comps []float64) (r, g, b float64)
+	// DefaultDecode returns the default Decode array of an image with
+	// the given bits per component
+	DefaultDecode(bpc int) []float64
+	// InitialColor returns the colour selected by the cs and CS operators
+	InitialColor() []float64
+}
+
+// Device colour spaces
+var (
+	DeviceGray ColorSpace = deviceGrayColorSpace{}
+	DeviceRGB  ColorSpace = deviceRGBColorSpace{}
+	DeviceCMYK ColorSpace = deviceCMYKColorSpace{}
+)
+
+// unitDecode returns [0 1] repeated n times
+func unitDecode(n int) []float64 {
+	out := make([]float64, 2*n)
+	for i := 0; i < n; i++ {
+		out[2*i+1] = 1
+	}
+	return out
+}
+
+type deviceGrayColorSpace struct{}
+
+func (deviceGrayColorSpace) Family() string              { return "DeviceGray" }
+func (deviceGrayColorSpace) NComponents() int            { return 1 }
+func (deviceGrayColorSpace) DefaultDecode(int) []float64 { return unitDecode(1) }
+func (deviceGrayColorSpace) InitialColor() []float64     { return []float64{0} }
+func (deviceGrayColorSpace) RGB(c []float64) (r, g, b float64) {
+	v := clampFloat(c[0], 0, 1)
+	return v, v, v
+}
+
+type deviceRGBColorSpace struct{}
+
+func (deviceRGBColorSpace) Family() string              { return "DeviceRGB" }
+func (deviceRGBColorSpace) NComponents() int            { return 3 }
+func (deviceRGBColorSpace) DefaultDecode(int) []float64 { return unitDecode(3) }
+func (deviceRGBColorSpace) InitialColor() []float64     { return []float64{0, 0, 0} }
+func (deviceRGBColorSpace) RGB(c []float64) (r, g, b float64) {
+	return clampFloat(c[0], 0, 1), clampFloat(c[1], 0, 1), clampFloat(c[2], 0, 1)
+}
+
+type deviceCMYKColorSpace struct{}
+
+func (deviceCMYKColorSpace) Family() string              { return "DeviceCMYK" }
+func (deviceCMYKColorSpace) NComponents() int            { return 4 }
+func (deviceCMYKColorSpace) DefaultDecode(int) []float64 { return unitDecode(4) }
+func (deviceCMYKColorSpace) InitialColor() []float64     { return []float64{0, 0, 0, 1} }
+func (deviceCMYKColorSpace) RGB(c []float64) (r, g, b float64) {
+	return cmykToRGB(c[0], c[1], c[2], c[3])
+}
+
+// cmykToRGB converts uncalibrated CMYK to sRGB. The polynomial fits a
+// SWOP coated press profile, so process colours and rich blacks look
+// like their printed result instead of the saturated values of the
+// naive 1 − (c + k) formula.
+func cmykToRGB(c, m, y, k float64) (r, g, b float64) {
+	c, m, y, k = clampFloat(c, 0, 1), clampFloat(m, 0, 1), clampFloat(y, 0, 1), clampFloat(k, 0, 1)
+	r = 255 +
+		c*(-4.387332384609988*c+54.48615194189176*m+18.82290502165302*y+212.25662451639585*k-285.2331026137004) +
+		m*(1.7149763477362134*m-5.6096736904047315*y-17.873870861415444*k-5.497006427196366) +
+		y*(-2.5217340131683033*y-21.248923337353073*k+17.5119270841813) +
+		k*(-21.86122147463605*k-189.48180835922747)
+	g = 255 +
+		c*(8.841041422036149*c+60.118027045597366*m+6.871425592049007*y+31.159100130055922*k-79.2970844816548) +
+		m*(-15.310361306967817*m+17.575251261109482*y+131.35250912493976*k-190.9453302588951) +
+		y*(4.444339102852739*y+9.8632861493405*k-24.86741582555878) +
+		k*(-20.737325471181034*k-187.80453709719578)
+	b = 255 +
+		c*(0.8842522430003296*c+8.078677503112928*m+30.89978309703729*y-0.23883238689178934*k-14.183576799673286) +
+		m*(10.49593273432072*m+63.02378494754052*y+50.606957656360734*k-112.23884253719248) +
+		y*(0.03296041114873217*y+115.60384449646641*k-193.58209356861505) +
+		k*(-22.33816807309886*k-180.12613974708367)
+	return clampFloat(r/255, 0, 1), clampFloat(g/255, 0, 1), clampFloat(b/255, 0, 1)
+}
+
+// calGrayColorSpace is a CalGray space
+type calGrayColorSpace struct {
+	white [3]float64
+	gamma float64
+}
+
+func (cs *calGrayColorSpace) Family() string              { return "CalGray" }
+func (cs *calGrayColorSpace) NComponents() int            { return 1 }
+func (cs *calGrayColorSpace) DefaultDecode(int) []float64 { return unitDecode(1) }
+func (cs *calGrayColorSpace) InitialColor() []float64     { return []float64{0} }
+
+func (cs *calGrayColorSpace) RGB(c []float64) (r, g, b float64) {
+	ag := math.Pow(clampFloat(c[0], 0, 1), cs.gamma)
+	xyz := [3]float64{cs.white[0] * ag, cs.white[1] * ag, cs.white[2] * ag}
+	return xyzToSRGB(adaptToD50(xyz, cs.white))
+}
+
+// calRGBColorSpace is a CalRGB space
+type calRGBColorSpace struct {
+	white  [3]float64
+	gamma  [3]float64
+	matrix [9]float64
+}
+
+func (cs *calRGBColorSpace) Family() string              { return "CalRGB" }
+func (cs *calRGBColorSpace) NComponents() int            { return 3 }
+func (cs *calRGBColorSpace) DefaultDecode(int) []float64 { return unitDecode(3) }
+func (cs *calRGBColorSpace) InitialColor() []float64     { return []float64{0, 0, 0} }
+
+func (cs *calRGBColorSpace) RGB(c []float64) (r, g, b float64) {
+	var xyz [3]float64
+	for i := 0; i < 3; i++ {
+		v := math.Pow(clampFloat(c[i], 0, 1), cs.gamma[i])
+		for j := 0; j < 3; j++ {
+			xyz[j] += cs.matrix[3*i+j] * v
+		}
+	}
+	return xyzToSRGB(adaptToD50(xyz, cs.white))
+}
+
+// labColorSpace is a CIE L*a*b* space
+type labColorSpace struct {
+	white [3]float64
+	rng   [4]float64 // amin amax bmin bmax
+}
+
+func (cs *labColorSpace) Family() string   { return "Lab" }
+func (cs *labColorSpace) NComponents() int { return 3 }
+
+func (cs *labColorSpace) DefaultDecode(int) []float64 {
+	return []float64{0, 100, cs.rng[0], cs.rng[1], cs.rng[2], cs.rng[3]}
+}
+
+func (cs *labColorSpace) InitialColor() []float64 {
+	return []float64{0, clampFloat(0, cs.rng[0], cs.rng[1]), clampFloat(0, cs.rng[2], cs.rng[3])}
+}
+
+func (cs *labColorSpace) RGB(c []float64) (r, g, b float64) {
+	l := clampFloat(c[0], 0, 100)
+	a := clampFloat(c[1], cs.rng[0], cs.rng[1])
+	bb := clampFloat(c[2], cs.rng[2], cs.rng[3])
+	return xyzToSRGB(adaptToD50(labToXYZ(l, a, bb, cs.white), cs.white))
+}
+
+// iccBasedColorSpace is an ICCBased space. Colours are converted through
+// the embedded profile, or the alternate space when it cannot be used.
+type iccBasedColorSpace struct {
+	n         int
+	alternate ColorSpace
+	profile   *ICCProfile
+	rng       []float64
+}
+
+func (cs *iccBasedColorSpace) Family() string              { return "ICCBased" }
+func (cs *iccBasedColorSpace) NComponents() int            { return cs.n }
+func (cs *iccBasedColorSpace) DefaultDecode(int) []float64 { return cs.rng }
+
+func (cs *iccBasedColorSpace) InitialColor() []float64 {
+	out := make([]float64, cs.n)
+	for i := range out {
+		out[i] = clampFloat(0, cs.rng[2*i], cs.rng[2*i+1])
+	}
+	return out
+}
+
+func (cs *iccBasedColorSpace) RGB(c []float64) (r, g, b float64) {
+	if cs.profile == nil {
+		return cs.alternate.RGB(c)
+	}
+	in := make([]float64, cs.n)
+	for i := range in {
+		lo, hi := cs.rng[2*i], cs.rng[2*i+1]
+		if hi > lo {
+			in[i] = (c[i] - lo) / (hi - lo)
+		}
+	}
+	return cs.profile.ToRGB(in)
+}
+
+// indexedColorSpace is an Indexed space; its palette is converted to sRGB
+// once when the space is parsed
+type indexedColorSpace struct {
+	base    ColorSpace
+	hival   int
+	lookup  []byte
+	palette [][3]float64
+}
+
+func (cs *indexedColorSpace) Family() string          { return "Indexed" }
+func (cs *indexedColorSpace) NComponents() int        { return 1 }
+func (cs *indexedColorSpace) InitialColor() []float64 { return []float64{0} }
+
+func (cs *indexedColorSpace) DefaultDecode(bpc int) []float64 {
+	return []float64{0, float64(int(1)<<bpc - 1)}
+}
+
+func (cs *indexedColorSpace) RGB(c []float64) (r, g, b float64) {
+	i := int(math.Round(c[0]))
+	i = max(0, min(i, cs.hival))
+	p := cs.palette[i]
+	return p[0], p[1], p[2]
+}
+
+// tintColorSpace is a Separation or DeviceN space whose colourants are
+// approximated through the alternate space and tint transform
+type tintColorSpace struct {
+	family    string
+	names     []Name
+	alternate ColorSpace
+	tint      Function
+}
+
+func (cs *tintColorSpace) Family() string              { return cs.family }
+func (cs *tintColorSpace) NComponents() int            { return len(cs.names) }
+func (cs *tintColorSpace) DefaultDecode(int) []float64 { return unitDecode(len(cs.names)) }
+
+func (cs *tintColorSpace) InitialColor() []float64 {
+	out := make([]float64, len(cs.names))
+	for i := range out {
+		out[i] = 1
+	}
+	return out
+}
+
+func (cs *tintColorSpace) RGB(c []float64) (r, g, b float64) {
+	if cs.tint == nil {
+		// Without a usable tint transform the colourants are shown as
+		// shades of gray
+		t := 0.0
+		for _, v := range c[:len(cs.names)] {
+			t = math.Max(t, clampFloat(v, 0, 1))
+		}
+		return 1 - t, 1 - t, 1 - t
+	}
+	out := cs.tint.Evaluate(c[:len(cs.names)])
+	comps := make([]float64, max(len(out), cs.alternate.NComponents()))
+	copy(comps, out)
+	return cs.alternate.RGB(comps)
+}
+
+// patternColorSpace is a Pattern space. Uncoloured patterns carry their
+// colour in the underlying space; coloured patterns have no components.
+type patternColorSpace struct {
+	base ColorSpace
+}
+
+func (cs *patternColorSpace) Family() string { return "Pattern" }
+
+func (cs *patternColorSpace) NComponents() int {
+	if cs.base == nil {
+		return 0
+	}
+	return cs.base.NComponents()
+}
+
+func (cs *patternColorSpace) DefaultDecode(int) []float64 { return nil }
+
+func (cs *patternColorSpace) InitialColor() []float64 {
+	if cs.base == nil {
+		return nil
+	}
+	return cs.base.InitialColor()
+}
+
+func (cs *patternColorSpace) RGB(c []float64) (r, g, b float64) {
+	if cs.base == nil || len(c) < cs.base.NComponents() {
+		return 0, 0, 0
+	}
+	return cs.base.RGB(c)
+}
+
+// ParseColorSpace parses a colour space name or array. Names other than
+// the device families are looked up in the ColorSpace category of
+// resources, which may be nil.
+func (d *Document) ParseColorSpace(obj Object, resources Dictionary) (ColorSpace, error) {
+	return d.parseColorSpace(obj, resources, 0)
+}
+
+func (d *Document) parseColorSpace(obj Object, resources Dictionary, depth int) (ColorSpace, error) {
+	if depth > maxColorSpaceDepth {
+		return nil, fmt.Errorf("colour spaces nested too deeply")
+	}
+	ref, isRef := obj.(Reference)
+	if isRef {
+		if cs, ok := d.colorSpaces[ref.ObjectNumber]; ok {
+			return cs, nil
+		}
+	}
+
+	var cs ColorSpace
+	var err error
+	switch v := d.resolve(obj).(type) {
+	case Name:
+		cs, err = d.parseColorSpaceName(v, resources, depth)
+	case Array:
+		cs, err = d.parseColorSpaceArray(v, resources, depth)
+	case nil:
+		return nil, fmt.Errorf("missing colour space")
+	default:
+		return nil, fmt.Errorf("invalid colour space %T", v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if isRef {
+		if d.colorSpaces == nil {
+			d.colorSpaces = make(map[int]ColorSpace)
+		}
+		d.colorSpaces[ref.ObjectNumber] = cs
+	}
+	return cs, nil
+}
+
+func (d *Document) parseColorSpaceName(name Name, resources Dictionary, depth int) (ColorSpace, error) {
+	switch name {
+	case "DeviceGray", "G":
+		return DeviceGray, nil
+	case "DeviceRGB", "RGB":
+		return DeviceRGB, nil
+	case "DeviceCMYK", "CMYK":
+		return DeviceCMYK, nil
+	case "Pattern":
+		return &patternColorSpace{}, nil
+	}
+	if resources != nil {
+		if spaces, ok := resolveDict(d, resources.Get("ColorSpace")); ok {
+			if entry := spaces.Get(string(name)); entry != nil {
+				return d.parseColorSpace(entry, nil, depth+1)
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown colour space %s", name)
+}
+
+func (d *Document) parseColorSpaceArray(arr Array, resources Dictionary, depth int) (ColorSpace, error) {
+	if len(arr) == 0 {
+		return nil, fmt.Errorf("empty colour space array")
+	}
+	family, _ := d.resolve(arr[0]).(Name)
+	arg := func(i int) Object {
+		if i < len(arr) {
+			return d.resolve(arr[i])
+		}
+		return nil
+	}
+
+	switch family {
+	case "DeviceGray", "G", "DeviceRGB", "RGB", "DeviceCMYK", "CMYK":
+		return d.parseColorSpaceName(family, nil, depth)
+
+	case "CalGray":
+		dict, _ := arg(1).(Dictionary)
+		cs := &calGrayColorSpace{white: d.whitePoint(dict), gamma: 1}
+		if g := dict.Get("Gamma"); g != nil {
+			cs.gamma = objectToFloat(d.resolve(g))
+		}
+		return cs, nil
+
+	case "CalRGB":
+		dict, _ := arg(1).(Dictionary)
+		cs := &calRGBColorSpace{
+			white:  d.whitePoint(dict),
+			gamma:  [3]float64{1, 1, 1},
+			matrix: [9]float64{1, 0, 0, 0, 1, 0, 0, 0, 1},
+		}
+		if g := d.numberArray(dict.Get("Gamma")); len(g) == 3 {
+			copy(cs.gamma[:], g)
+		}
+		if m := d.numberArray(dict.Get("Matrix")); len(m) == 9 {
+			copy(cs.matrix[:], m)
+		}
+		return cs, nil
+
+	case "Lab":
+		dict, _ := arg(1).(Dictionary)
+		cs := &labColorSpace{white: d.whitePoint(dict), rng: [4]float64{-100, 100, -100, 100}}
+		if r := d.numberArray(dict.Get("Range")); len(r) == 4 {
+			copy(cs.rng[:], r)
+		}
+		return cs, nil
+
+	case "ICCBased":
+		stream, ok := arg(1).(Stream)
+		if !ok {
+			return nil, fmt.Errorf("ICCBased colour space without a profile stream")
+		}
+		return d.parseICCBased(stream, depth)
+
+	case "Indexed", "I":
+		if len(arr) < 4 {
+			return nil, fmt.Errorf("Indexed colour space needs 4 entries")
+		}
+		base, err := d.parseColorSpace(arr[1], resources, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		hival, _ := arg(2).(Integer)
+		cs := &indexedColorSpace{base: base, hival: max(0, min(int(hival), 255))}
+		switch lookup := arg(3).(type) {
+		case String:
+			cs.lookup = lookup.Value
+		case Stream:
+			if cs.lookup, err = lookup.Decode(); err != nil {
+				return nil, fmt.Errorf("Indexed lookup table: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("Indexed colour space without a lookup table")
+		}
+		cs.buildPalette()
+		return cs, nil
+
+	case "Separation", "DeviceN":
+		if len(arr) < 4 {
+			return nil, fmt.Errorf("%s colour space needs 4 entries", family)
+		}
+		cs := &tintColorSpace{family: string(family)}
+		if family == "Separation" {
+			name, _ := arg(1).(Name)
+			cs.names = []Name{name}
+		} else {
+			names, _ := arg(1).(Array)
+			for _, n := range names {
+				name, _ := d.resolve(n).(Name)
+				cs.names = append(cs.names, name)
+			}
+			if len(cs.names) == 0 {
+				return nil, fmt.Errorf("DeviceN colour space without colourants")
+			}
+		}
+		alt, err := d.parseColorSpace(arr[2], resources, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		cs.alternate = alt
+		if tint, err := d.ParseFunction(arr[3]); err == nil &&
+			tint.Inputs() == len(cs.names) && tint.Outputs() >= alt.NComponents() {
+			cs.tint = tint
+		}
+		return cs, nil
+
+	case "Pattern":
+		cs := &patternColorSpace{}
+		if len(arr) > 1 {
+			base, err := d.parseColorSpace(arr[1], resources, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			cs.base = base
+		}
+		return cs, nil
+	}
+	return nil, fmt.Errorf("unknown colour space family %v", arr[0])
+}
+
+// parseICCBased parses an ICCBased profile stream
+func (d *Document) parseICCBased(stream Stream, depth int) (ColorSpace, error) {
+	n, _ := stream.Dictionary.GetInt("N")
+	cs := &iccBasedColorSpace{n: int(n)}
+
+	data, err := stream.Decode()
+	if err == nil {
+		if profile, err := ParseICCProfile(data); err == nil && (cs.n == 0 || profile.Channels == cs.n) {
+			cs.profile = profile
+			cs.n = profile.Channels
+		}
+	}
+
+	if alt := stream.Dictionary.Get("Alternate"); alt != nil {
+		if altCS, err := d.parseColorSpace(alt, nil, depth+1); err == nil && altCS.NComponents() == cs.n {
+			cs.alternate = altCS
+		}
+	}
+	if cs.alternate == nil {
+		switch cs.n {
+		case 1:
+			cs.alternate = DeviceGray
+		case 3:
+			cs.alternate = DeviceRGB
+		case 4:
+			cs.alternate = DeviceCMYK
+		default:
+			return nil, fmt.Errorf("ICCBased colour space with %d components", cs.n)
+		}
+	}
+
+	cs.rng = d.numberArray(stream.Dictionary.Get("Range"))
+	if len(cs.rng) != 2*cs.n {
+		cs.rng = iccDefaultRange(cs.profile, cs.n)
+	}
+	return cs, nil
+}
+
+// iccDefaultRange returns the component ranges of an ICCBased space
+// without a Range entry
+func iccDefaultRange(profile *ICCProfile, n int) []float64 {
+	if profile != nil && profile.ColorSpace == "Lab " {
+		return []float64{0, 100, -128, 127, -128, 127}
+	}
+	return unitDecode(n)
+}
+
+// iccColorSpace returns an ICCBased space for a parsed profile
+func iccColorSpace(profile *ICCProfile) ColorSpace {
+	cs := &iccBasedColorSpace{
+		n:       profile.Channels,
+		profile: profile,
+		rng:     iccDefaultRange(profile, profile.Channels),
+	}
+	switch cs.n {
+	case 1:
+		cs.alternate = DeviceGray
+	case 4:
+		cs.alternate = DeviceCMYK
+	default:
+		cs.alternate = DeviceRGB
+	}
+	return cs
+}
+
+// buildPalette converts the lookup table through the base space. Entries
+// missing from a short table are treated as zero.
+func (cs *indexedColorSpace) buildPalette() {
+	n := cs.base.NComponents()
+	decode := cs.base.DefaultDecode(8)
+	comps := make([]float64, n)
+	cs.palette = make([][3]float64, cs.hival+1)
+	for i := range cs.palette {
+		for j := 0; j < n; j++ {
+			var v byte
+			if k := i*n + j; k < len(cs.lookup) {
+				v = cs.lookup[k]
+			}
+			lo, hi := 0.0, 1.0
+			if 2*j+1 < len(decode) {
+				lo, hi = decode[2*j], decode[2*j+1]
+			}
+			comps[j] = lo + float64(v)*(hi-lo)/255
+		}
+		r, g, b := cs.base.RGB(comps)
+		cs.palette[i] = [3]float64{r, g, b}
+	}
+}
+
+// whitePoint reads the WhitePoint of a CIE-based colour space dictionary,
+// defaulting to D50
+func (d *Document) whitePoint(dict Dictionary) [3]float64 {
+	wp := iccD50
+	if v := d.numberArray(dict.Get("WhitePoint")); len(v) == 3 && v[1] > 0 {
+		copy(wp[:], v)
+	}
+	return wp
+}
+
+// Bradford cone response matrix and its inverse
+var (
+	bradford = [3][3]float64{
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	}
+	bradfordInverse = [3][3]float64{
+		{0.9869929, -0.1470543, 0.1599627},
+		{0.4323053, 0.5183603, 0.0492912},
+		{-0.0085287, 0.0400428, 0.9684867},
+	}
+)
+
+func mulMatrix3(m [3][3]float64, v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+// adaptToD50 converts XYZ relative to white to D50 with the Bradford
+// chromatic adaptation transform
+func adaptToD50(xyz, white [3]float64) [3]float64 {
+	if white == iccD50 {
+		return xyz
+	}
+	src := mulMatrix3(bradford, white)
+	dst := mulMatrix3(bradford, iccD50)
+	cone := mulMatrix3(bradford, xyz)
+	for i := range cone {
+		if src[i] != 0 {
+			cone[i] *= dst[i] / src[i]
+		}
+	}
+	return mulMatrix3(bradfordInverse, cone)
+}
+
+// labToXYZ converts CIE L*a*b* to XYZ relative to white
+func labToXYZ(l, a, b float64, white [3]float64) [3]float64 {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+	inv := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	return [3]float64{white[0] * inv(fx), white[1] * inv(fy), white[2] * inv(fz)}
+}
+
+// xyzD50ToSRGB converts D50 XYZ to gamma-encoded sRGB in [0, 1], using the
+// Bradford-adapted sRGB matrix
+func xyzD50ToSRGB(x, y, z float64) (r, g, b float64) {
+	lin := mulMatrix3([3][3]float64{
+		{3.1338561, -1.6168667, -0.4906146},
+		{-0.9787684, 1.9161415, 0.0334540},
+		{0.0719453, -0.2289914, 1.4052427},
+	}, [3]float64{x, y, z})
+	encode := func(v float64) float64 {
+		v = clampFloat(v, 0, 1)
+		if v <= 0.0031308 {
+			return 12.92 * v
+		}
+		return 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return encode(lin[0]), encode(lin[1]), encode(lin[2])
+}
+
+// xyzToSRGB converts a D50 XYZ triple to sRGB
+func xyzToSRGB(v [3]float64) (r, g, b float64) {
+	return xyzD50ToSRGB(v[0], v[1], v[2])
+}
+
+// colorRGBA converts components through cs to an opaque 8-bit colour
+func colorRGBA(cs ColorSpace, comps []float64) color.RGBA {
+	buf := make([]float64, max(len(comps), cs.NComponents()))
+	copy(buf, comps)
+	r, g, b := cs.RGB(buf)
+	return color.RGBA{to8bit(r), to8bit(g), to8bit(b), 255}
+}
+
+// to8bit scales a value in [0, 1] to a byte
+func to8bit(v float64) uint8 {
+	return uint8(math.Round(clampFloat(v, 0, 1) * 255))
+}
+
+// to16bit scales a value in [0, 1] to 16 bits
+func to16bit(v float64) uint16 {
+	return uint16(math.Round(clampFloat(v, 0, 1) * 65535))
+}
+
+// to8bitFrom16 rounds a 16-bit value to 8 bits
+func to8bitFrom16(v uint16) uint8 {
+	return uint8((uint32(v)*255 + 32767) / 65535)
+}
+
+// isGrayColorSpace reports whether cs produces only neutral colours
+func isGrayColorSpace(cs ColorSpace) bool {
+	switch cs.Family() {
+	case "DeviceGray", "CalGray":
+		return true
+	case "ICCBased":
+		return cs.NComponents() == 1
+	}
+	return false
+}
+
+// maxColorCache bounds the number of converted colours remembered while
+// converting one image
+const maxColorCache = 1 << 18
+
+// colorImage converts packed image samples to an image through cs. Rows
+// start on byte boundaries and samples have bpc bits; decode is the
+// image's Decode array, nil for the default. Missing data is read as
+// zero. Gray spaces give an *image.Gray, all others an *image.RGBA, or
+// *image.Gray16 and *image.RGBA64 for 16-bit samples.
+func colorImage(cs ColorSpace, data []byte, width, height, bpc int, decode []float64) (image.Image, error) {
+	return colorImageDepth(cs, data, width, height, bpc, decode, bpc == 16)
+}
+
+// colorImageDepth is colorImage with the output depth chosen by deep
+func colorImageDepth(cs ColorSpace, data []byte, width, height, bpc int, decode []float64, deep bool) (image.Image, error) {
+	switch bpc {
+	case 1, 2, 4, 8, 16:
+	default:
+		return nil, fmt.Errorf("unsupported bits per component %d", bpc)
+	}
+	n := cs.NComponents()
+	if width <= 0 || height <= 0 || n == 0 {
+		return nil, fmt.Errorf("invalid image %dx%d with %d components", width, height, n)
+	}
+	if len(decode) != 2*n {
+		decode = cs.DefaultDecode(bpc)
+	}
+	stride := (width*n*bpc + 7) / 8
+	maxVal := float64(int(1)<<bpc - 1)
+	rect := image.Rect(0, 0, width, height)
+
+	// Device spaces with default decoding map directly to pixels
+	identity := bpc == 8
+	for i := 0; identity && i < n; i++ {
+		identity = decode[2*i] == 0 && decode[2*i+1] == 1
+	}
+	if identity && !deep && (cs == DeviceGray || cs == DeviceRGB) {
+		if cs == DeviceGray {
+			img := image.NewGray(rect)
+			copy(img.Pix, data)
+			return img, nil
+		}
+		img := image.NewRGBA(rect)
+		for i := 0; i < width*height; i++ {
+			if 3*i+2 < len(data) {
+				copy(img.Pix[4*i:], data[3*i:3*i+3])
+			}
+			img.Pix[4*i+3] = 255
+		}
+		return img, nil
+	}
+
+	sample := func(row []byte, idx int) uint32 {
+		switch bpc {
+		case 8:
+			if idx < len(row) {
+				return uint32(row[idx])
+			}
+		case 16:
+			if 2*idx+1 < len(row) {
+				return uint32(row[2*idx])<<8 | uint32(row[2*idx+1])
+			}
+		default:
+			bit := idx * bpc
+			if bit/8 < len(row) {
+				return uint32(row[bit/8]>>(8-bpc-bit%8)) & (1<<bpc - 1)
+			}
+		}
+		return 0
+	}
+
+	// Converting through profiles and tint transforms is costly, so
+	// colours are remembered by their raw samples where they fit a key
+	var cache map[uint64][3]uint16
+	if n*bpc <= 64 {
+		cache = make(map[uint64][3]uint16)
+	}
+	gray := isGrayColorSpace(cs)
+	var out interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch {
+	case gray && deep:
+		out = image.NewGray16(rect)
+	case gray:
+		out = image.NewGray(rect)
+	case deep:
+		out = image.NewRGBA64(rect)
+	default:
+		out = image.NewRGBA(rect)
+	}
+
+	comps := make([]float64, n)
+	raw := make([]uint32, n)
+	for y := 0; y < height; y++ {
+		var row []byte
+		if start := y * stride; start < len(data) {
+			row = data[start:min(start+stride, len(data))]
+		}
+		for x := 0; x < width; x++ {
+			var key uint64
+			for i := 0; i < n; i++ {
+				raw[i] = sample(row, x*n+i)
+				key = key<<bpc | uint64(raw[i])
+			}
+			c, ok := cache[key]
+			if !ok {
+				for i := 0; i < n; i++ {
+					comps[i] = decode[2*i] + float64(raw[i])*(decode[2*i+1]-decode[2*i])/maxVal
+				}
+				r, g, b := cs.RGB(comps)
+				c = [3]uint16{to16bit(r), to16bit(g), to16bit(b)}
+				if cache != nil && len(cache) < maxColorCache {
+					cache[key] = c
+				}
+			}
+			switch img := out.(type) {
+			case *image.Gray:
+				img.Pix[y*img.Stride+x] = to8bitFrom16(c[0])
+			case *image.RGBA:
+				copy(img.Pix[y*img.Stride+4*x:], []uint8{to8bitFrom16(c[0]), to8bitFrom16(c[1]), to8bitFrom16(c[2]), 255})
+			case *image.Gray16:
+				img.SetGray16(x, y, color.Gray16{Y: c[0]})
+			case *image.RGBA64:
+				img.SetRGBA64(x, y, color.RGBA64{R: c[0], G: c[1], B: c[2], A: 0xFFFF})
+			}
+		}
+	}
+	return out, nil
+}
+
+// convertDecodedImage reinterprets the samples of a decoded DCT image
+// through cs. Images whose layout does not match cs are returned as they
+// are.
+func convertDecodedImage(img image.Image, cs ColorSpace, decode []float64) image.Image {
+	if cs == nil {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	n := cs.NComponents()
+	var samples []byte
+	switch src := img.(type) {
+	case *image.CMYK:
+		if n != 4 {
+			return img
+		}
+		samples = make([]byte, 0, 4*w*h)
+		for y := 0; y < h; y++ {
+			samples = append(samples, src.Pix[y*src.Stride:y*src.Stride+4*w]...)
+		}
+	case *image.Gray:
+		if n != 1 || (cs == DeviceGray && decode == nil) {
+			return img
+		}
+		samples = make([]byte, 0, w*h)
+		for y := 0; y < h; y++ {
+			samples = append(samples, src.Pix[y*src.Stride:y*src.Stride+w]...)
+		}
+	default:
+		if n != 3 || (cs == DeviceRGB && decode == nil) {
+			return img
+		}
+		samples = make([]byte, 0, 3*w*h)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, _ := img.At(x, y).RGBA()
+				samples = append(samples, byte(r>>8), byte(g>>8), byte(bl>>8))
+			}
+		}
+	}
+	out, err := colorImage(cs, samples, w, h, 8, decode)
+	if err != nil {
+		return img
+	}
+	return out
+}
@@ -21,6 +21,9 @@ type Document struct {
 	objects  map[int]Object
 	xref     map[int]xrefEntry
 	security *SecurityHandler
+
+	attachments *AttachmentEditor  // pending changes of AddAttachment
+	colorSpaces map[int]ColorSpace // parsed colour spaces by object number
 }
 
 // xrefEntry represents an entry in the cross-reference table
@@ -36,6 +39,7 @@ type xrefEntry struct {
 // Page represents a PDF page
 type Page struct {
 	doc        *Document
+	ref        Reference
 	Dictionary Dictionary
 	Number     int
 	MediaBox   Rectangle
@@ -359,6 +363,11 @@ func (d *Document) parseXRefStream(offset int64) error {
 
 			objNum := start + j
 
+			// Newer revisions are parsed first; keep their entries
+			if _, exists := d.xref[objNum]; exists {
+				continue
+			}
+
 			// Default type is 1 if w[0] is 0
 			entryType := field1
 			if w[0] == 0 {
@@ -389,6 +398,12 @@ func (d *Document) parseXRefStream(offset int64) error {
 	// Use stream dictionary as trailer
 	if d.Trailer == nil {
 		d.Trailer = stream.Dictionary
+	} else {
+		for k, v := range stream.Dictionary {
+			if _, exists := d.Trailer[k]; !exists {
+				d.Trailer[k] = v
+			}
+		}
 	}
 
 	// Check for previous xref
@@ -546,11 +561,12 @@ func (d *Document) parsePages() error {
 		return fmt.Errorf("pages is not a dictionary")
 	}
 
-	return d.parsePagesNode(pagesDict, nil, 1)
+	pagesNodeRef, _ := pagesRef.(Reference)
+	return d.parsePagesNode(pagesDict, pagesNodeRef, nil, 1)
 }
 
 // parsePagesNode recursively parses page tree nodes
-func (d *Document) parsePagesNode(node Dictionary, inheritedResources Dictionary, pageNum int) error {
+func (d *Document) parsePagesNode(node Dictionary, ref Reference, inheritedResources Dictionary, pageNum int) error {
 	nodeType, _ := node.GetName("Type")
 
 	// Inherit resources
@@ -613,7 +629,8 @@ func (d *Document) parsePagesNode(node Dictionary, inheritedResources Dictionary
 				kidDict[Name("MediaBox")] = rectangleToArray(mediaBox)
 			}
 
-			if err := d.parsePagesNode(kidDict, resources, pageNum); err != nil {
+			kidObjRef, _ := kidRef.(Reference)
+			if err := d.parsePagesNode(kidDict, kidObjRef, resources, pageNum); err != nil {
 				return err
 			}
 			pageNum = len(d.Pages) + 1
@@ -622,6 +639,7 @@ func (d *Document) parsePagesNode(node Dictionary, inheritedResources Dictionary
 		// Leaf page node
 		page := &Page{
 			doc:        d,
+			ref:        ref,
 			Dictionary: node,
 			Number:     len(d.Pages) + 1,
 			MediaBox:   mediaBox,
@@ -903,6 +921,20 @@ func objectToString(obj Object) string {
 	return ""
 }
 
+// formatPDFDate formats a time as a PDF date string (D:YYYYMMDDHHmmSSOHH'mm')
+func formatPDFDate(t time.Time) string {
+	_, offset := t.Zone()
+	if offset == 0 {
+		return t.Format("D:20060102150405Z")
+	}
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%c%02d'%02d'", t.Format("D:20060102150405"), sign, offset/3600, offset%3600/60)
+}
+
 // parsePDFDate parses a PDF date string (D:YYYYMMDDHHmmSSOHH'mm')
 func parsePDFDate(s string) time.Time {
 	if len(s) < 2 {
@@ -1074,11 +1106,11 @@ func (d *Document) GetJavaScript() []string {
 
 		switch v := jsCodeObj.(type) {
 		case String:
-			scripts = append(scripts, string(v.Value))
+			scripts = append(scripts, v.Text())
 		case Stream:
 			data, err := v.Decode()
 			if err == nil {
-				scripts = append(scripts, string(data))
+				scripts = append(scripts, String{Value: data}.Text())
 			}
 		}
 	}
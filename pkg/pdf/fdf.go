@@ -0,0 +1,873 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// XFDFNamespace is the XML namespace of XFDF documents
+const XFDFNamespace = "http://ns.adobe.com/xfdf/"
+
+// ExportFDF exports the form field values as an FDF document
+func (d *Document) ExportFDF() ([]byte, error) {
+	form := d.acroForm()
+	if form == nil {
+		return nil, fmt.Errorf("document has no form")
+	}
+	roots, _ := resolveArray(d, form.Get("Fields"))
+
+	var fields Array
+	for _, root := range roots {
+		if field := d.fdfField(root, 0); field != nil {
+			fields = append(fields, field)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%FDF-1.2\n")
+	buf.WriteString("%\xe2\xe3\xcf\xd3\n")
+	buf.WriteString("1 0 obj\n")
+	writeObject(&buf, Dictionary{"FDF": Dictionary{"Fields": fields}})
+	buf.WriteString("\nendobj\n")
+	buf.WriteString("trailer\n")
+	writeObject(&buf, Dictionary{"Root": Reference{ObjectNumber: 1}})
+	buf.WriteString("\n%%EOF\n")
+
+	return buf.Bytes(), nil
+}
+
+// fdfField converts a field and its descendants to an FDF field dictionary.
+// Fields without a value and fields marked NoExport are omitted.
+func (d *Document) fdfField(obj Object, depth int) Dictionary {
+	dict, ok := resolveDict(d, obj)
+	if !ok || depth > 32 {
+		return nil
+	}
+	t, ok := dict.Get("T").(String)
+	if !ok {
+		return nil
+	}
+	if flags, ok := d.inheritedFieldAttr(dict, "Ff").(Integer); ok && flags&FieldFlagNoExport != 0 {
+		return nil
+	}
+
+	result := Dictionary{"T": t}
+
+	kids, _ := resolveArray(d, dict.Get("Kids"))
+	var fdfKids Array
+	for _, kid := range kids {
+		if kidField := d.fdfField(kid, depth+1); kidField != nil {
+			fdfKids = append(fdfKids, kidField)
+		}
+	}
+	if len(fdfKids) > 0 {
+		result["Kids"] = fdfKids
+	}
+
+	if v := dict.Get("V"); v != nil {
+		if value, err := d.ResolveObject(v); err == nil {
+			switch value.(type) {
+			case String, Name, Array:
+				result["V"] = value
+			}
+		}
+	}
+
+	if result.Get("V") == nil && result.Get("Kids") == nil {
+		return nil
+	}
+	return result
+}
+
+// fdfObjectPattern matches the start of an indirect object
+var fdfObjectPattern = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+
+// fdfFile is a parsed FDF document
+type fdfFile struct {
+	objects map[int]Object
+	root    Dictionary
+}
+
+// parseFDF parses an FDF document. FDF files frequently lack a
+// cross-reference table, so objects are located by scanning.
+func parseFDF(data []byte) (*fdfFile, error) {
+	if !bytes.HasPrefix(data, []byte("%FDF-")) {
+		return nil, fmt.Errorf("not an FDF file")
+	}
+
+	f := &fdfFile{objects: make(map[int]Object)}
+	for _, loc := range fdfObjectPattern.FindAllIndex(data, -1) {
+		num, _, obj, err := NewParserFromBytes(data[loc[0]:]).ParseIndirectObject()
+		if err == nil {
+			f.objects[num] = obj
+		}
+	}
+
+	if idx := bytes.LastIndex(data, []byte("trailer")); idx >= 0 {
+		if trailer, err := NewParserFromBytes(data[idx+len("trailer"):]).ParseObject(); err == nil {
+			if dict, ok := trailer.(Dictionary); ok {
+				f.root, _ = f.resolve(dict.Get("Root")).(Dictionary)
+			}
+		}
+	}
+	if f.root == nil {
+		for _, obj := range f.objects {
+			if dict, ok := obj.(Dictionary); ok && dict.Get("FDF") != nil {
+				f.root = dict
+				break
+			}
+		}
+	}
+	if f.root == nil {
+		return nil, fmt.Errorf("FDF catalog not found")
+	}
+	return f, nil
+}
+
+// resolve follows a reference within the FDF document
+func (f *fdfFile) resolve(obj Object) Object {
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := obj.(Reference)
+		if !ok {
+			return obj
+		}
+		obj = f.objects[ref.ObjectNumber]
+	}
+	return nil
+}
+
+// ImportFDF fills the form with the field values of an FDF document.
+// Fields that do not exist in the form are ignored.
+func (f *FormFiller) ImportFDF(data []byte) error {
+	fdf, err := parseFDF(data)
+	if err != nil {
+		return err
+	}
+	fdfDict, ok := fdf.resolve(fdf.root.Get("FDF")).(Dictionary)
+	if !ok {
+		return fmt.Errorf("FDF dictionary not found")
+	}
+	fields, _ := fdf.resolve(fdfDict.Get("Fields")).(Array)
+
+	var walk func(obj Object, parentName string, depth int) error
+	walk = func(obj Object, parentName string, depth int) error {
+		dict, ok := fdf.resolve(obj).(Dictionary)
+		if !ok || depth > 32 {
+			return nil
+		}
+		name := parentName
+		if t, ok := fdf.resolve(dict.Get("T")).(String); ok {
+			if name != "" {
+				name += "."
+			}
+			name += t.Text()
+		}
+
+		if v := dict.Get("V"); v != nil {
+			if err := f.importValue(name, fdfValues(fdf.resolve(v))); err != nil {
+				return err
+			}
+		}
+
+		kids, _ := fdf.resolve(dict.Get("Kids")).(Array)
+		for _, kid := range kids {
+			if err := walk(kid, name, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, field := range fields {
+		if err := walk(field, "", 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fdfValues converts an FDF field value to strings
+func fdfValues(v Object) []string {
+	switch val := v.(type) {
+	case String:
+		return []string{val.Text()}
+	case Name:
+		return []string{string(val)}
+	case Array:
+		var values []string
+		for _, elem := range val {
+			values = append(values, fdfValues(elem)...)
+		}
+		return values
+	}
+	return nil
+}
+
+// importValue sets a field value during import, skipping unknown fields
+func (f *FormFiller) importValue(name string, values []string) error {
+	if _, ok := f.fields[name]; !ok || values == nil {
+		return nil
+	}
+	return f.SetValues(name, values)
+}
+
+// xfdfDocument is the root element of an XFDF document
+type xfdfDocument struct {
+	XMLName xml.Name    `xml:"xfdf"`
+	Xmlns   string      `xml:"xmlns,attr,omitempty"`
+	Fields  *xfdfFields `xml:"fields,omitempty"`
+	Annots  *xfdfAnnots `xml:"annots,omitempty"`
+}
+
+// xfdfFields holds the field values of an XFDF document
+type xfdfFields struct {
+	Fields []xfdfField `xml:"field"`
+}
+
+// xfdfField is a field element; nested fields form qualified names
+type xfdfField struct {
+	Name   string      `xml:"name,attr"`
+	Values []string    `xml:"value"`
+	Fields []xfdfField `xml:"field"`
+}
+
+// xfdfAnnots holds the annotations of an XFDF document
+type xfdfAnnots struct {
+	Annots []xfdfAnnot `xml:",any"`
+}
+
+// xfdfAnnot is an annotation element such as <highlight> or <ink>
+type xfdfAnnot struct {
+	XMLName           xml.Name
+	Attrs             []xml.Attr `xml:",any,attr"`
+	Contents          string     `xml:"contents,omitempty"`
+	DefaultAppearance string     `xml:"defaultappearance,omitempty"`
+	Vertices          string     `xml:"vertices,omitempty"`
+	Gestures          []string   `xml:"inklist>gesture,omitempty"`
+	Popup             *xfdfPopup `xml:"popup,omitempty"`
+}
+
+// xfdfPopup is the popup window of an annotation
+type xfdfPopup struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+// attr returns the value of an attribute
+func xfdfAttr(attrs []xml.Attr, name string) (string, bool) {
+	for _, a := range attrs {
+		if a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// xfdfSubtypes maps XFDF element names to annotation subtypes
+var xfdfSubtypes = map[string]string{
+	"text":      "Text",
+	"freetext":  "FreeText",
+	"line":      "Line",
+	"square":    "Square",
+	"circle":    "Circle",
+	"polygon":   "Polygon",
+	"polyline":  "PolyLine",
+	"highlight": "Highlight",
+	"underline": "Underline",
+	"squiggly":  "Squiggly",
+	"strikeout": "StrikeOut",
+	"stamp":     "Stamp",
+	"caret":     "Caret",
+	"ink":       "Ink",
+}
+
+// annotFlagNames lists the XFDF names of the annotation flags by bit
+var annotFlagNames = []string{
+	"invisible", "hidden", "print", "nozoom", "norotate",
+	"noview", "readonly", "locked", "togglenoview", "lockedcontents",
+}
+
+// ExportXFDF exports the form field values and markup annotations as XFDF
+func (d *Document) ExportXFDF() ([]byte, error) {
+	doc := xfdfDocument{Xmlns: XFDFNamespace}
+
+	if form := d.acroForm(); form != nil {
+		roots, _ := resolveArray(d, form.Get("Fields"))
+		fields := &xfdfFields{}
+		for _, root := range roots {
+			if field := d.fdfField(root, 0); field != nil {
+				fields.Fields = append(fields.Fields, xfdfFieldFromFDF(field))
+			}
+		}
+		if len(fields.Fields) > 0 {
+			doc.Fields = fields
+		}
+	}
+
+	annots := &xfdfAnnots{}
+	for i, page := range d.Pages {
+		pageAnnots, _ := resolveArray(d, page.Dictionary.Get("Annots"))
+		for _, annotObj := range pageAnnots {
+			annot, ok := resolveDict(d, annotObj)
+			if !ok {
+				continue
+			}
+			if elem, ok := d.xfdfAnnotation(annot, i); ok {
+				annots.Annots = append(annots.Annots, elem)
+			}
+		}
+	}
+	if len(annots.Annots) > 0 {
+		doc.Annots = annots
+	}
+
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// xfdfFieldFromFDF converts an FDF field dictionary to an XFDF field
+func xfdfFieldFromFDF(dict Dictionary) xfdfField {
+	t, _ := dict.Get("T").(String)
+	field := xfdfField{Name: t.Text(), Values: fdfValues(dict.Get("V"))}
+	if kids, ok := dict.Get("Kids").(Array); ok {
+		for _, kid := range kids {
+			if kidDict, ok := kid.(Dictionary); ok {
+				field.Fields = append(field.Fields, xfdfFieldFromFDF(kidDict))
+			}
+		}
+	}
+	return field
+}
+
+// xfdfAnnotation converts a markup annotation to an XFDF element
+func (d *Document) xfdfAnnotation(annot Dictionary, pageIndex int) (xfdfAnnot, bool) {
+	subtype, _ := annot.GetName("Subtype")
+	var elemName string
+	for name, st := range xfdfSubtypes {
+		if st == string(subtype) {
+			elemName = name
+			break
+		}
+	}
+	if elemName == "" {
+		return xfdfAnnot{}, false
+	}
+
+	elem := xfdfAnnot{XMLName: xml.Name{Local: elemName}}
+	add := func(name, value string) {
+		elem.Attrs = append(elem.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+	}
+	text := func(key string) (string, bool) {
+		if s, ok := d.resolve(annot.Get(key)).(String); ok {
+			return s.Text(), true
+		}
+		return "", false
+	}
+
+	add("page", strconv.Itoa(pageIndex))
+	if rect, ok := resolveArray(d, annot.Get("Rect")); ok {
+		add("rect", xfdfNumbers(rect, ","))
+	}
+	if v, ok := text("NM"); ok {
+		add("name", v)
+	}
+	if v, ok := text("T"); ok {
+		add("title", v)
+	}
+	if v, ok := text("Subj"); ok {
+		add("subject", v)
+	}
+	if v, ok := text("M"); ok {
+		add("date", v)
+	}
+	if v, ok := text("CreationDate"); ok {
+		add("creationdate", v)
+	}
+	if flags, ok := annot.GetInt("F"); ok && flags != 0 {
+		add("flags", xfdfFlags(int(flags)))
+	}
+	if c, ok := resolveArray(d, annot.Get("C")); ok && len(c) > 0 {
+		add("color", xfdfColor(c))
+	}
+	if ic, ok := resolveArray(d, annot.Get("IC")); ok && len(ic) > 0 {
+		add("interior-color", xfdfColor(ic))
+	}
+	if ca := annot.Get("CA"); ca != nil {
+		add("opacity", formatNum(objectToFloat(ca)))
+	}
+	if bs, ok := resolveDict(d, annot.Get("BS")); ok {
+		if w := bs.Get("W"); w != nil {
+			add("width", formatNum(objectToFloat(w)))
+		}
+	}
+	if icon, ok := annot.GetName("Name"); ok {
+		add("icon", string(icon))
+	}
+	if qp, ok := resolveArray(d, annot.Get("QuadPoints")); ok {
+		add("coords", xfdfNumbers(qp, ","))
+	}
+	if l, ok := resolveArray(d, annot.Get("L")); ok && len(l) == 4 {
+		add("start", xfdfNumbers(l[:2], ","))
+		add("end", xfdfNumbers(l[2:], ","))
+	}
+	if le, ok := resolveArray(d, annot.Get("LE")); ok && len(le) == 2 {
+		head, _ := le[0].(Name)
+		tail, _ := le[1].(Name)
+		add("head", string(head))
+		add("tail", string(tail))
+	}
+	if q, ok := annot.GetInt("Q"); ok && subtype == "FreeText" {
+		add("justification", [...]string{"left", "centered", "right"}[min(max(int(q), 0), 2)])
+	}
+	if irt, ok := resolveDict(d, annot.Get("IRT")); ok {
+		if nm, ok := irt.Get("NM").(String); ok {
+			add("inreplyto", nm.Text())
+		}
+	}
+	if v, ok := text("State"); ok {
+		add("state", v)
+	}
+	if v, ok := text("StateModel"); ok {
+		add("statemodel", v)
+	}
+
+	if v, ok := text("Contents"); ok {
+		elem.Contents = v
+	}
+	if v, ok := text("DA"); ok {
+		elem.DefaultAppearance = v
+	}
+	if vertices, ok := resolveArray(d, annot.Get("Vertices")); ok {
+		elem.Vertices = xfdfPoints(vertices)
+	}
+	if inkList, ok := resolveArray(d, annot.Get("InkList")); ok {
+		for _, path := range inkList {
+			if points, ok := resolveArray(d, path); ok {
+				elem.Gestures = append(elem.Gestures, xfdfPoints(points))
+			}
+		}
+	}
+
+	if popup, ok := resolveDict(d, annot.Get("Popup")); ok {
+		p := &xfdfPopup{}
+		addPopup := func(name, value string) {
+			p.Attrs = append(p.Attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+		}
+		addPopup("page", strconv.Itoa(pageIndex))
+		if rect, ok := resolveArray(d, popup.Get("Rect")); ok {
+			addPopup("rect", xfdfNumbers(rect, ","))
+		}
+		if flags, ok := popup.GetInt("F"); ok && flags != 0 {
+			addPopup("flags", xfdfFlags(int(flags)))
+		}
+		open := "no"
+		if o, ok := popup.Get("Open").(Boolean); ok && bool(o) {
+			open = "yes"
+		}
+		addPopup("open", open)
+		elem.Popup = p
+	}
+
+	return elem, true
+}
+
+// resolve resolves an object, returning nil on failure. Without a document
+// references cannot be resolved.
+func (d *Document) resolve(obj Object) Object {
+	if obj == nil {
+		return nil
+	}
+	if d == nil {
+		if _, ok := obj.(Reference); ok {
+			return nil
+		}
+		return obj
+	}
+	resolved, err := d.ResolveObject(obj)
+	if err != nil {
+		return nil
+	}
+	return resolved
+}
+
+// xfdfNumbers formats an array of numbers joined by sep
+func xfdfNumbers(arr Array, sep string) string {
+	parts := make([]string, len(arr))
+	for i, v := range arr {
+		parts[i] = formatNum(objectToFloat(v))
+	}
+	return strings.Join(parts, sep)
+}
+
+// xfdfPoints formats a flat coordinate array as "x,y;x,y"
+func xfdfPoints(arr Array) string {
+	var points []string
+	for i := 0; i+1 < len(arr); i += 2 {
+		points = append(points, formatNum(objectToFloat(arr[i]))+","+formatNum(objectToFloat(arr[i+1])))
+	}
+	return strings.Join(points, ";")
+}
+
+// xfdfFlags formats annotation flags as a comma separated list
+func xfdfFlags(flags int) string {
+	var names []string
+	for bit, name := range annotFlagNames {
+		if flags&(1<<bit) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// xfdfColor formats a colour array as #RRGGBB
+func xfdfColor(c Array) string {
+	var r, g, b float64
+	switch len(c) {
+	case 1:
+		r = objectToFloat(c[0])
+		g, b = r, r
+	case 3:
+		r, g, b = objectToFloat(c[0]), objectToFloat(c[1]), objectToFloat(c[2])
+	case 4:
+		k := objectToFloat(c[3])
+		r = (1 - objectToFloat(c[0])) * (1 - k)
+		g = (1 - objectToFloat(c[1])) * (1 - k)
+		b = (1 - objectToFloat(c[2])) * (1 - k)
+	}
+	toByte := func(v float64) int {
+		return min(max(int(v*255+0.5), 0), 255)
+	}
+	return fmt.Sprintf("#%02X%02X%02X", toByte(r), toByte(g), toByte(b))
+}
+
+// ImportXFDF fills the form with the field values of an XFDF document and
+// adds its annotations. Annotations whose name matches an existing
+// annotation on the same page replace it.
+func (f *FormFiller) ImportXFDF(data []byte) error {
+	var doc xfdfDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid XFDF: %w", err)
+	}
+
+	if doc.Fields != nil {
+		var walk func(fields []xfdfField, parentName string) error
+		walk = func(fields []xfdfField, parentName string) error {
+			for _, field := range fields {
+				name := field.Name
+				if parentName != "" {
+					name = parentName + "." + name
+				}
+				if len(field.Values) > 0 {
+					if err := f.importValue(name, field.Values); err != nil {
+						return err
+					}
+				}
+				if err := walk(field.Fields, name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if err := walk(doc.Fields.Fields, ""); err != nil {
+			return err
+		}
+	}
+
+	if doc.Annots != nil {
+		return f.importXFDFAnnotations(doc.Annots.Annots)
+	}
+	return nil
+}
+
+// importXFDFAnnotations adds XFDF annotations to their pages
+func (f *FormFiller) importXFDFAnnotations(elems []xfdfAnnot) error {
+	d := f.doc
+
+	// Existing annotations by page and name for replacement, by name
+	// alone for replies, and the popups they own
+	pageNamed := make(map[int]map[string]Reference)
+	named := make(map[string]Reference)
+	popups := make(map[Reference]Reference)
+	pageAnnots := make(map[int]Array)
+	for i, page := range d.Pages {
+		annots, _ := resolveArray(d, page.Dictionary.Get("Annots"))
+		pageAnnots[i] = append(Array(nil), annots...)
+		pageNamed[i] = make(map[string]Reference)
+		for _, obj := range annots {
+			ref, ok := obj.(Reference)
+			if !ok {
+				continue
+			}
+			annot, ok := resolveDict(d, ref)
+			if !ok {
+				continue
+			}
+			if nm, ok := annot.Get("NM").(String); ok {
+				pageNamed[i][nm.Text()] = ref
+				named[nm.Text()] = ref
+			}
+			if popup, ok := annot.Get("Popup").(Reference); ok {
+				popups[ref] = popup
+			}
+		}
+	}
+
+	type reply struct {
+		annot Dictionary
+		page  int
+		to    string
+	}
+	var replies []reply
+	changed := make(map[int]bool)
+
+	for _, elem := range elems {
+		subtype, ok := xfdfSubtypes[elem.XMLName.Local]
+		if !ok {
+			continue
+		}
+		pageStr, _ := xfdfAttr(elem.Attrs, "page")
+		pageIndex, err := strconv.Atoi(pageStr)
+		if err != nil || pageIndex < 0 || pageIndex >= len(d.Pages) {
+			return fmt.Errorf("%s annotation has invalid page %q", elem.XMLName.Local, pageStr)
+		}
+		page := d.Pages[pageIndex]
+		if page.ref.ObjectNumber == 0 {
+			return fmt.Errorf("page %d object reference unknown", pageIndex+1)
+		}
+
+		annot := xfdfToAnnotation(elem, subtype)
+		annot["P"] = page.ref
+		if ap := buildAnnotationAppearance(d, annot); ap != nil {
+			annot["AP"] = Dictionary{"N": f.writer.AddObject(*ap)}
+		}
+
+		var ref Reference
+		name, hasName := xfdfAttr(elem.Attrs, "name")
+		if existing, ok := pageNamed[pageIndex][name]; hasName && ok {
+			ref = existing
+		} else {
+			ref = f.writer.ReserveObject()
+			pageAnnots[pageIndex] = append(pageAnnots[pageIndex], ref)
+			if hasName {
+				pageNamed[pageIndex][name] = ref
+			}
+		}
+		if hasName {
+			named[name] = ref
+		}
+
+		// A replaced annotation's popup is reused, or dropped when the
+		// new one has none
+		oldPopup, hadPopup := popups[ref]
+		delete(popups, ref)
+		if hadPopup && elem.Popup == nil {
+			pageAnnots[pageIndex] = removeReference(pageAnnots[pageIndex], oldPopup)
+		}
+
+		if elem.Popup != nil {
+			popup := Dictionary{
+				"Type":    Name("Annot"),
+				"Subtype": Name("Popup"),
+				"Parent":  ref,
+				"P":       page.ref,
+			}
+			if rect, ok := xfdfAttr(elem.Popup.Attrs, "rect"); ok {
+				popup["Rect"] = parseXFDFNumbers(rect)
+			}
+			if flags, ok := xfdfAttr(elem.Popup.Attrs, "flags"); ok {
+				popup["F"] = Integer(parseXFDFFlags(flags))
+			}
+			open, _ := xfdfAttr(elem.Popup.Attrs, "open")
+			popup["Open"] = Boolean(open == "yes")
+			popupRef := oldPopup
+			if hadPopup {
+				f.writer.UpdateObject(popupRef, popup)
+			} else {
+				popupRef = f.writer.AddObject(popup)
+				pageAnnots[pageIndex] = append(pageAnnots[pageIndex], popupRef)
+			}
+			annot["Popup"] = popupRef
+			popups[ref] = popupRef
+		}
+
+		if to, ok := xfdfAttr(elem.Attrs, "inreplyto"); ok {
+			replies = append(replies, reply{annot: annot, page: pageIndex, to: to})
+		}
+
+		f.writer.UpdateObject(ref, annot)
+		changed[pageIndex] = true
+	}
+
+	for _, r := range replies {
+		if ref, ok := pageNamed[r.page][r.to]; ok {
+			r.annot["IRT"] = ref
+		} else if ref, ok := named[r.to]; ok {
+			r.annot["IRT"] = ref
+		}
+	}
+
+	for pageIndex := range changed {
+		pageDict := f.writer.EditDictionary(d.Pages[pageIndex].ref)
+		pageDict["Annots"] = pageAnnots[pageIndex]
+	}
+	return nil
+}
+
+// removeReference returns arr without any occurrence of ref
+func removeReference(arr Array, ref Reference) Array {
+	out := arr[:0]
+	for _, obj := range arr {
+		if r, ok := obj.(Reference); !ok || r != ref {
+			out = append(out, obj)
+		}
+	}
+	return out
+}
+
+// xfdfToAnnotation builds an annotation dictionary from an XFDF element
+func xfdfToAnnotation(elem xfdfAnnot, subtype string) Dictionary {
+	annot := Dictionary{
+		"Type":    Name("Annot"),
+		"Subtype": Name(subtype),
+		"F":       Integer(AnnotFlagPrint),
+	}
+	attr := func(name string) (string, bool) {
+		return xfdfAttr(elem.Attrs, name)
+	}
+
+	if v, ok := attr("rect"); ok {
+		annot["Rect"] = parseXFDFNumbers(v)
+	}
+	for attrName, key := range map[string]Name{
+		"name": "NM", "title": "T", "subject": "Subj", "date": "M",
+		"creationdate": "CreationDate", "state": "State", "statemodel": "StateModel",
+	} {
+		if v, ok := attr(attrName); ok {
+			annot[key] = textString(v)
+		}
+	}
+	if v, ok := attr("flags"); ok {
+		annot["F"] = Integer(parseXFDFFlags(v))
+	}
+	if v, ok := attr("color"); ok {
+		if c := parseXFDFColor(v); c != nil {
+			annot["C"] = c
+		}
+	}
+	if v, ok := attr("interior-color"); ok {
+		if c := parseXFDFColor(v); c != nil {
+			annot["IC"] = c
+		}
+	}
+	if v, ok := attr("opacity"); ok {
+		if ca, err := strconv.ParseFloat(v, 64); err == nil {
+			annot["CA"] = Real(ca)
+		}
+	}
+	if v, ok := attr("width"); ok {
+		if w, err := strconv.ParseFloat(v, 64); err == nil {
+			annot["BS"] = Dictionary{"W": Real(w)}
+		}
+	}
+	if v, ok := attr("icon"); ok {
+		annot["Name"] = Name(v)
+	}
+	if v, ok := attr("coords"); ok {
+		annot["QuadPoints"] = parseXFDFNumbers(v)
+	}
+	start, hasStart := attr("start")
+	end, hasEnd := attr("end")
+	if hasStart && hasEnd {
+		annot["L"] = append(parseXFDFNumbers(start), parseXFDFNumbers(end)...)
+	}
+	head, hasHead := attr("head")
+	tail, hasTail := attr("tail")
+	if hasHead || hasTail {
+		if head == "" {
+			head = "None"
+		}
+		if tail == "" {
+			tail = "None"
+		}
+		annot["LE"] = Array{Name(head), Name(tail)}
+	}
+	if v, ok := attr("justification"); ok {
+		switch v {
+		case "centered":
+			annot["Q"] = Integer(1)
+		case "right":
+			annot["Q"] = Integer(2)
+		default:
+			annot["Q"] = Integer(0)
+		}
+	}
+
+	if elem.Contents != "" {
+		annot["Contents"] = textString(elem.Contents)
+	}
+	if elem.DefaultAppearance != "" {
+		annot["DA"] = String{Value: []byte(elem.DefaultAppearance)}
+	}
+	if elem.Vertices != "" {
+		annot["Vertices"] = parseXFDFNumbers(elem.Vertices)
+	}
+	if len(elem.Gestures) > 0 {
+		var inkList Array
+		for _, gesture := range elem.Gestures {
+			inkList = append(inkList, parseXFDFNumbers(gesture))
+		}
+		annot["InkList"] = inkList
+	}
+
+	return annot
+}
+
+// parseXFDFNumbers parses numbers separated by commas, semicolons or spaces
+func parseXFDFNumbers(s string) Array {
+	var arr Array
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	}) {
+		if v, err := strconv.ParseFloat(part, 64); err == nil {
+			arr = append(arr, Real(v))
+		}
+	}
+	return arr
+}
+
+// parseXFDFFlags parses a comma separated list of annotation flags
+func parseXFDFFlags(s string) int {
+	flags := 0
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		for bit, flagName := range annotFlagNames {
+			if name == flagName {
+				flags |= 1 << bit
+			}
+		}
+	}
+	return flags
+}
+
+// parseXFDFColor parses a #RRGGBB colour
+func parseXFDFColor(s string) Array {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil
+	}
+	return Array{
+		Real(float64(v>>16&0xFF) / 255),
+		Real(float64(v>>8&0xFF) / 255),
+		Real(float64(v&0xFF) / 255),
+	}
+}
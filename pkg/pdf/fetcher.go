@@ -0,0 +1,349 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// RevocationFetcher retrieves the data needed to check whether a
+// certificate has been revoked. HTTPRevocationFetcher asks the servers
+// named in the certificate; OfflineRevocationFetcher serves local files,
+// and tests can provide their own implementation.
+type RevocationFetcher interface {
+	// FetchOCSP returns a DER encoded OCSP response for cert
+	FetchOCSP(cert, issuer *x509.Certificate) ([]byte, error)
+	// FetchCRL returns a DER encoded CRL that covers cert
+	FetchCRL(cert *x509.Certificate) ([]byte, error)
+	// FetchIssuer returns the certificate that issued cert
+	FetchIssuer(cert *x509.Certificate) (*x509.Certificate, error)
+}
+
+// DefaultRevocationFetcher is used by CheckOCSP, CheckCRL and
+// CheckRevocation
+var DefaultRevocationFetcher RevocationFetcher = &HTTPRevocationFetcher{}
+
+// HTTPRevocationFetcher fetches OCSP responses, CRLs and issuer
+// certificates from the URLs in a certificate's OCSP server, CRL
+// distribution point and authority information access extensions.
+// Responses are cached until their next update. The zero value is ready
+// to use and safe for concurrent use.
+type HTTPRevocationFetcher struct {
+	Client   *http.Client  // nil uses a client with Timeout
+	Timeout  time.Duration // request timeout, 10 seconds by default
+	MaxSize  int64         // largest response accepted, 10 MB by default
+	CacheTTL time.Duration // lifetime of responses without a next update, 1 hour by default; negative disables the cache
+
+	mu    sync.Mutex
+	cache map[string]fetchCacheEntry
+}
+
+// fetchCacheEntry is a cached response
+type fetchCacheEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// Revocation fetching errors
+var (
+	ErrNoIssuerURL          = &RevocationError{"no issuer certificate URL available"}
+	ErrResponseTooLarge     = &RevocationError{"response exceeds the size limit"}
+	ErrRevocationNotOffline = &RevocationError{"revocation data not available offline"}
+)
+
+// FetchOCSP posts an OCSP request to each responder of cert in turn
+func (f *HTTPRevocationFetcher) FetchOCSP(cert, issuer *x509.Certificate) ([]byte, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, ErrNoOCSPServer
+	}
+	if issuer == nil {
+		return nil, ErrOCSPCheckFailed
+	}
+	key := "ocsp:" + string(cert.RawIssuer) + ":" + cert.SerialNumber.String()
+	if data := f.cached(key); data != nil {
+		return data, nil
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	lastErr := error(ErrOCSPCheckFailed)
+	for _, server := range cert.OCSPServer {
+		data, err := f.do(http.MethodPost, server, "application/ocsp-request", req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := ocsp.ParseResponse(data, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		f.store(key, data, resp.NextUpdate)
+		return data, nil
+	}
+	return nil, lastErr
+}
+
+// FetchCRL downloads the CRL from each distribution point of cert in
+// turn
+func (f *HTTPRevocationFetcher) FetchCRL(cert *x509.Certificate) ([]byte, error) {
+	if len(cert.CRLDistributionPoints) == 0 {
+		return nil, ErrNoCRLPoint
+	}
+	lastErr := error(ErrCRLFetchFailed)
+	for _, url := range cert.CRLDistributionPoints {
+		key := "crl:" + url
+		if data := f.cached(key); data != nil {
+			return data, nil
+		}
+		data, err := f.do(http.MethodGet, url, "", nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if block, _ := pem.Decode(data); block != nil && block.Type == "X509 CRL" {
+			data = block.Bytes
+		}
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		f.store(key, data, crl.NextUpdate)
+		return data, nil
+	}
+	return nil, lastErr
+}
+
+// FetchIssuer downloads the issuer certificate named in the authority
+// information access extension of cert. DER, PEM and certs-only PKCS#7
+// responses are accepted.
+func (f *HTTPRevocationFetcher) FetchIssuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	if len(cert.IssuingCertificateURL) == 0 {
+		return nil, ErrNoIssuerURL
+	}
+	var lastErr error
+	for _, url := range cert.IssuingCertificateURL {
+		key := "issuer:" + url
+		data := f.cached(key)
+		if data == nil {
+			var err error
+			if data, err = f.do(http.MethodGet, url, "", nil); err != nil {
+				lastErr = err
+				continue
+			}
+		}
+		for _, issuer := range parseCertificates(data) {
+			if isIssuedBy(cert, issuer) {
+				f.store(key, data, time.Time{})
+				return issuer, nil
+			}
+		}
+		lastErr = fmt.Errorf("no issuer of %q found at %s", cert.Subject.CommonName, url)
+	}
+	return nil, lastErr
+}
+
+// do performs a request and reads the response within the size limit
+func (f *HTTPRevocationFetcher) do(method, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := f.Client
+	if client == nil {
+		timeout := f.Timeout
+		if timeout == 0 {
+			timeout = 10 * time.Second
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: server returned %s", url, resp.Status)
+	}
+
+	limit := f.MaxSize
+	if limit == 0 {
+		limit = 10 << 20
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrResponseTooLarge
+	}
+	return data, nil
+}
+
+// cached returns a cached response that has not expired
+func (f *HTTPRevocationFetcher) cached(key string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.cache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(f.cache, key)
+		return nil
+	}
+	return entry.data
+}
+
+// store caches a response until nextUpdate, or for CacheTTL when the
+// response has no next update
+func (f *HTTPRevocationFetcher) store(key string, data []byte, nextUpdate time.Time) {
+	ttl := f.CacheTTL
+	if ttl < 0 {
+		return
+	}
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	expires := nextUpdate
+	if expires.IsZero() {
+		expires = time.Now().Add(ttl)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cache == nil {
+		f.cache = make(map[string]fetchCacheEntry)
+	}
+	f.cache[key] = fetchCacheEntry{data: data, expires: expires}
+}
+
+// OfflineRevocationFetcher serves certificates, OCSP responses and CRLs
+// collected beforehand, without network access. Data is matched to a
+// certificate by content, so file names do not matter.
+type OfflineRevocationFetcher struct {
+	Certificates []*x509.Certificate
+	OCSPs        [][]byte // DER encoded OCSP responses
+	CRLs         [][]byte // DER encoded CRLs
+}
+
+// NewOfflineRevocationFetcher loads the certificates, OCSP responses
+// and CRLs in a directory. Files may be DER or PEM encoded; files of
+// other types are skipped.
+func NewOfflineRevocationFetcher(dir string) (*OfflineRevocationFetcher, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	f := &OfflineRevocationFetcher{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		f.Add(data)
+	}
+	return f, nil
+}
+
+// Add adds DER or PEM encoded certificates, OCSP responses or CRLs.
+// Data of other types is ignored.
+func (f *OfflineRevocationFetcher) Add(data []byte) {
+	blocks := [][]byte{data}
+	if block, rest := pem.Decode(data); block != nil {
+		blocks = nil
+		for block != nil {
+			blocks = append(blocks, block.Bytes)
+			block, rest = pem.Decode(rest)
+		}
+	}
+	for _, der := range blocks {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			f.Certificates = append(f.Certificates, cert)
+		} else if _, err := x509.ParseRevocationList(der); err == nil {
+			f.CRLs = append(f.CRLs, der)
+		} else if _, err := ocsp.ParseResponse(der, nil); err == nil {
+			f.OCSPs = append(f.OCSPs, der)
+		}
+	}
+}
+
+// FetchOCSP returns the most recent response about cert
+func (f *OfflineRevocationFetcher) FetchOCSP(cert, issuer *x509.Certificate) ([]byte, error) {
+	var best []byte
+	var produced time.Time
+	for _, der := range f.OCSPs {
+		resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+		if err == nil && (best == nil || resp.ProducedAt.After(produced)) {
+			best, produced = der, resp.ProducedAt
+		}
+	}
+	if best == nil {
+		return nil, ErrRevocationNotOffline
+	}
+	return best, nil
+}
+
+// FetchCRL returns the most recent CRL of the issuer of cert
+func (f *OfflineRevocationFetcher) FetchCRL(cert *x509.Certificate) ([]byte, error) {
+	var best []byte
+	var thisUpdate time.Time
+	for _, der := range f.CRLs {
+		crl, err := x509.ParseRevocationList(der)
+		if err == nil && bytes.Equal(crl.RawIssuer, cert.RawIssuer) && (best == nil || crl.ThisUpdate.After(thisUpdate)) {
+			best, thisUpdate = der, crl.ThisUpdate
+		}
+	}
+	if best == nil {
+		return nil, ErrRevocationNotOffline
+	}
+	return best, nil
+}
+
+// FetchIssuer returns the certificate whose key signed cert
+func (f *OfflineRevocationFetcher) FetchIssuer(cert *x509.Certificate) (*x509.Certificate, error) {
+	for _, c := range f.Certificates {
+		if bytes.Equal(c.RawSubject, cert.RawIssuer) && isIssuedBy(cert, c) {
+			return c, nil
+		}
+	}
+	return nil, ErrRevocationNotOffline
+}
+
+// parseCertificates parses DER, PEM or certs-only PKCS#7 certificates
+func parseCertificates(data []byte) []*x509.Certificate {
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return []*x509.Certificate{cert}
+	}
+	if block, rest := pem.Decode(data); block != nil {
+		var certs []*x509.Certificate
+		for block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				certs = append(certs, cert)
+			}
+			block, rest = pem.Decode(rest)
+		}
+		return certs
+	}
+	if sd, err := parseCMS(data); err == nil {
+		return sd.certificates()
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+)
+
+// FlattenOptions controls how a document is flattened
+type FlattenOptions struct {
+	Annotations bool // Also flatten non-widget annotations that have an appearance
+	PrintOnly   bool // Only bake annotations that have the Print flag set
+}
+
+// FormFlattener bakes form field appearances into page content. Each
+// widget's normal appearance stream is drawn as a form XObject on its
+// page, after which the widgets and the interactive form are removed.
+type FormFlattener struct {
+	doc     *Document
+	options FlattenOptions
+	writer  *IncrementalWriter
+	form    Dictionary
+}
+
+// NewFormFlattener creates a new form flattener
+func NewFormFlattener(doc *Document, options FlattenOptions) *FormFlattener {
+	return &FormFlattener{
+		doc:     doc,
+		options: options,
+	}
+}
+
+// Flatten returns the flattened document, written as an incremental update
+func (f *FormFlattener) Flatten() ([]byte, error) {
+	f.writer = NewIncrementalWriter(f.doc)
+	f.form = f.doc.acroForm()
+
+	for _, page := range f.doc.Pages {
+		if err := f.flattenPage(page); err != nil {
+			return nil, fmt.Errorf("page %d: %w", page.Number, err)
+		}
+	}
+
+	if f.doc.Root.Get("AcroForm") != nil {
+		rootRef, err := f.writer.RootRef()
+		if err != nil {
+			return nil, err
+		}
+		catalog := cloneDict(f.doc.Root)
+		delete(catalog, "AcroForm")
+		f.writer.UpdateObject(rootRef, catalog)
+	}
+
+	return f.writer.Bytes()
+}
+
+// FlattenToFile flattens the document and writes it to a file
+func (f *FormFlattener) FlattenToFile(filename string) error {
+	data, err := f.Flatten()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// flattenedAppearance is an appearance placed on the page
+type flattenedAppearance struct {
+	name   Name
+	xobj   Reference
+	matrix [6]float64
+}
+
+// flattenPage bakes the annotations of a single page
+func (f *FormFlattener) flattenPage(page *Page) error {
+	annotsObj := page.Dictionary.Get("Annots")
+	if annotsObj == nil {
+		return nil
+	}
+	annots, ok := resolveArray(f.doc, annotsObj)
+	if !ok || len(annots) == 0 {
+		return nil
+	}
+	if page.ref.ObjectNumber == 0 {
+		return fmt.Errorf("page object reference unknown")
+	}
+
+	xobjects := Dictionary{}
+	var resources Dictionary
+	if page.Resources != nil {
+		resources = cloneDict(page.Resources)
+		if existing, ok := resolveDict(f.doc, resources.Get("XObject")); ok {
+			xobjects = cloneDict(existing)
+		}
+	} else {
+		resources = Dictionary{}
+	}
+
+	var placed []flattenedAppearance
+	removed := make(map[int]bool)
+	var kept Array
+	nextName := 0
+
+	for _, annotObj := range annots {
+		annot, ok := resolveDict(f.doc, annotObj)
+		if !ok {
+			continue
+		}
+		subtype, _ := annot.GetName("Subtype")
+		if subtype != "Widget" && (!f.options.Annotations || subtype == "Popup") {
+			kept = append(kept, annotObj)
+			continue
+		}
+
+		ap, apRef := f.normalAppearance(annot)
+		if ap == nil && subtype == "Widget" {
+			ap = generateWidgetAppearance(f.doc, f.form, annot)
+		}
+		if ap == nil && subtype != "Widget" {
+			// Nothing to bake (e.g. links); leave the annotation in place
+			kept = append(kept, annotObj)
+			continue
+		}
+
+		if ref, ok := annotObj.(Reference); ok {
+			removed[ref.ObjectNumber] = true
+		}
+		if ap == nil || !f.isVisible(annot) {
+			continue
+		}
+
+		matrix, ok := appearanceMatrix(ap, annot)
+		if !ok {
+			continue
+		}
+
+		xobj := apRef
+		if xobj.ObjectNumber == 0 || !isFormXObject(*ap) {
+			dict := cloneDict(ap.Dictionary)
+			dict["Type"] = Name("XObject")
+			dict["Subtype"] = Name("Form")
+			xobj = f.writer.AddObject(Stream{Dictionary: dict, Data: ap.Data})
+		}
+
+		var name Name
+		for {
+			name = Name(fmt.Sprintf("Flatten%d", nextName))
+			nextName++
+			if xobjects.Get(string(name)) == nil {
+				break
+			}
+		}
+		xobjects[name] = xobj
+		placed = append(placed, flattenedAppearance{name: name, xobj: xobj, matrix: matrix})
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	// Drop popups whose parent annotation was flattened
+	var remaining Array
+	for _, annotObj := range kept {
+		if annot, ok := resolveDict(f.doc, annotObj); ok {
+			if parent, ok := annot.Get("Parent").(Reference); ok && removed[parent.ObjectNumber] {
+				continue
+			}
+		}
+		remaining = append(remaining, annotObj)
+	}
+
+	pageDict := cloneDict(page.Dictionary)
+	if len(remaining) > 0 {
+		pageDict["Annots"] = remaining
+	} else {
+		delete(pageDict, "Annots")
+	}
+
+	if len(placed) > 0 {
+		var content bytes.Buffer
+		content.WriteString("Q\n")
+		for _, p := range placed {
+			m := p.matrix
+			fmt.Fprintf(&content, "q %s %s %s %s %s %s cm %s Do Q\n",
+				formatNum(m[0]), formatNum(m[1]), formatNum(m[2]),
+				formatNum(m[3]), formatNum(m[4]), formatNum(m[5]), p.name)
+		}
+
+		contents := Array{f.writer.AddObject(Stream{Dictionary: Dictionary{}, Data: []byte("q\n")})}
+		contents = append(contents, f.pageContents(page)...)
+		contents = append(contents, f.writer.AddObject(newFlateStream(nil, content.Bytes())))
+		pageDict["Contents"] = contents
+
+		resources["XObject"] = xobjects
+		pageDict["Resources"] = resources
+	}
+
+	f.writer.UpdateObject(page.ref, pageDict)
+	return nil
+}
+
+// pageContents returns references to the existing content streams of a page
+func (f *FormFlattener) pageContents(page *Page) Array {
+	contents := page.Dictionary.Get("Contents")
+	if contents == nil {
+		return nil
+	}
+	if ref, ok := contents.(Reference); ok {
+		obj, err := f.doc.GetObject(ref.ObjectNumber)
+		if err != nil {
+			return nil
+		}
+		if arr, ok := obj.(Array); ok {
+			return arr
+		}
+		return Array{ref}
+	}
+
+	switch v := contents.(type) {
+	case Array:
+		return v
+	case Stream:
+		return Array{f.writer.AddObject(v)}
+	}
+	return nil
+}
+
+// isVisible reports whether an annotation should be drawn into the page
+func (f *FormFlattener) isVisible(annot Dictionary) bool {
+	flags, _ := annot.GetInt("F")
+	if flags&AnnotFlagHidden != 0 {
+		return false
+	}
+	if f.options.PrintOnly {
+		return flags&AnnotFlagPrint != 0
+	}
+	return flags&AnnotFlagNoView == 0
+}
+
+// normalAppearance returns the normal appearance stream of an annotation,
+// selecting the /AS state when the appearance has several states
+func (f *FormFlattener) normalAppearance(annot Dictionary) (*Stream, Reference) {
+	if needsAppearance(f.doc, f.form, annot) {
+		return nil, Reference{}
+	}
+	return annotationAppearance(f.doc, annot, "N")
+}
+
+// annotationAppearance returns the appearance stream of the given kind
+// (N, R or D), selecting the /AS state when several states are present
+func annotationAppearance(doc *Document, annot Dictionary, kind string) (*Stream, Reference) {
+	ap, ok := resolveDict(doc, annot.Get("AP"))
+	if !ok {
+		return nil, Reference{}
+	}
+
+	entry := ap.Get(kind)
+	obj, err := doc.ResolveObject(entry)
+	if err != nil {
+		return nil, Reference{}
+	}
+
+	if states, ok := obj.(Dictionary); ok {
+		state, ok := annot.GetName("AS")
+		if !ok {
+			return nil, Reference{}
+		}
+		entry = states.Get(string(state))
+		if obj, err = doc.ResolveObject(entry); err != nil {
+			return nil, Reference{}
+		}
+	}
+
+	stream, ok := obj.(Stream)
+	if !ok {
+		return nil, Reference{}
+	}
+	ref, _ := entry.(Reference)
+	return &stream, ref
+}
+
+// needsAppearance reports whether a widget's appearance must be
+// regenerated because the form sets /NeedAppearances
+func needsAppearance(doc *Document, form, annot Dictionary) bool {
+	if form == nil {
+		return false
+	}
+	if need, ok := form.Get("NeedAppearances").(Boolean); !ok || !bool(need) {
+		return false
+	}
+	subtype, _ := annot.GetName("Subtype")
+	if subtype != "Widget" {
+		return false
+	}
+	ft, _ := doc.inheritedFieldAttr(annot, "FT").(Name)
+	return ft == "Tx" || ft == "Ch"
+}
+
+// generateWidgetAppearance builds an appearance for a text or choice
+// field widget from its value and default appearance
+func generateWidgetAppearance(doc *Document, form, annot Dictionary) *Stream {
+	ft, _ := doc.inheritedFieldAttr(annot, "FT").(Name)
+	if ft != "Tx" && ft != "Ch" {
+		return nil
+	}
+
+	var value string
+	switch v := doc.inheritedFieldAttr(annot, "V").(type) {
+	case String:
+		value = v.Text()
+	case Array:
+		if len(v) > 0 {
+			if s, ok := v[0].(String); ok {
+				value = s.Text()
+			}
+		}
+	}
+	if value == "" {
+		return nil
+	}
+
+	return widgetTextAppearance(doc, form, annot, value)
+}
+
+// isFormXObject reports whether a stream is marked as a form XObject
+func isFormXObject(s Stream) bool {
+	subtype, _ := s.Dictionary.GetName("Subtype")
+	return subtype == "Form"
+}
+
+// normalizeRect returns r with its corners ordered
+func normalizeRect(r Rectangle) Rectangle {
+	if r.LLX > r.URX {
+		r.LLX, r.URX = r.URX, r.LLX
+	}
+	if r.LLY > r.URY {
+		r.LLY, r.URY = r.URY, r.LLY
+	}
+	return r
+}
+
+// appearanceMatrix computes the matrix that maps an appearance stream onto
+// the annotation rectangle (PDF 32000-1:2008, 12.5.5). The appearance's own
+// /Matrix is applied by the Do operator and is therefore not included.
+func appearanceMatrix(ap *Stream, annot Dictionary) ([6]float64, bool) {
+	rectArr, ok := annot.GetArray("Rect")
+	if !ok || len(rectArr) != 4 {
+		return [6]float64{}, false
+	}
+	rect := normalizeRect(arrayToRectangle(rectArr))
+
+	bboxArr, ok := ap.Dictionary.GetArray("BBox")
+	if !ok || len(bboxArr) != 4 {
+		return [6]float64{}, false
+	}
+	bbox := arrayToRectangle(bboxArr)
+
+	m := [6]float64{1, 0, 0, 1, 0, 0}
+	if matrix, ok := ap.Dictionary.GetArray("Matrix"); ok && len(matrix) == 6 {
+		for i := range m {
+			m[i] = objectToFloat(matrix[i])
+		}
+	}
+
+	// Transform the bounding box by the form matrix
+	minX, minY := 1e300, 1e300
+	maxX, maxY := -1e300, -1e300
+	for _, p := range [][2]float64{
+		{bbox.LLX, bbox.LLY}, {bbox.URX, bbox.LLY},
+		{bbox.LLX, bbox.URY}, {bbox.URX, bbox.URY},
+	} {
+		x := m[0]*p[0] + m[2]*p[1] + m[4]
+		y := m[1]*p[0] + m[3]*p[1] + m[5]
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+
+	bw, bh := maxX-minX, maxY-minY
+	if bw <= 0 || bh <= 0 {
+		return [6]float64{}, false
+	}
+
+	sx := (rect.URX - rect.LLX) / bw
+	sy := (rect.URY - rect.LLY) / bh
+	return [6]float64{sx, 0, 0, sy, rect.LLX - minX*sx, rect.LLY - minY*sy}, true
+}
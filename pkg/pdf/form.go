@@ -16,6 +16,8 @@ type FormField struct {
 	Required   bool
 	NoExport   bool
 	Kids       []*FormField
+
+	ref Reference // object reference of the field dictionary
 }
 
 // GetFormFields returns all form fields in the document
@@ -75,15 +77,23 @@ func (d *Document) parseFormField(ref Object, parentName string) *FormField {
 	}
 
 	field := &FormField{}
+	if r, ok := ref.(Reference); ok {
+		field.ref = r
+	}
 
 	// Get field name
 	if t := fieldDict.Get("T"); t != nil {
 		if str, ok := t.(String); ok {
-			field.Name = string(str.Value)
+			field.Name = str.Text()
 		}
 	}
 	if parentName != "" {
-		field.Name = parentName + "." + field.Name
+		if field.Name == "" {
+			// Widget annotations without /T belong to the parent field
+			field.Name = parentName
+		} else {
+			field.Name = parentName + "." + field.Name
+		}
 	}
 
 	// Get field type
@@ -97,7 +107,7 @@ func (d *Document) parseFormField(ref Object, parentName string) *FormField {
 	if v := fieldDict.Get("V"); v != nil {
 		switch val := v.(type) {
 		case String:
-			field.Value = string(val.Value)
+			field.Value = val.Text()
 		case Name:
 			field.Value = string(val)
 		}
@@ -107,7 +117,7 @@ func (d *Document) parseFormField(ref Object, parentName string) *FormField {
 	if dv := fieldDict.Get("DV"); dv != nil {
 		switch val := dv.(type) {
 		case String:
-			field.DefaultVal = string(val.Value)
+			field.DefaultVal = val.Text()
 		case Name:
 			field.DefaultVal = string(val)
 		}
@@ -175,6 +185,36 @@ func (d *Document) parseFormField(ref Object, parentName string) *FormField {
 	return field
 }
 
+// inheritedFieldAttr returns a field attribute, following the /Parent
+// chain for inheritable entries such as FT, V, Ff and DA
+func (d *Document) inheritedFieldAttr(field Dictionary, key string) Object {
+	for depth := 0; field != nil && depth < 32; depth++ {
+		if v := field.Get(key); v != nil {
+			resolved, err := d.ResolveObject(v)
+			if err != nil {
+				return nil
+			}
+			return resolved
+		}
+		parent := field.Get("Parent")
+		if parent == nil {
+			break
+		}
+		field, _ = resolveDict(d, parent)
+	}
+	return nil
+}
+
+// acroForm returns the interactive form dictionary, or nil
+func (d *Document) acroForm() Dictionary {
+	ref := d.Root.Get("AcroForm")
+	if ref == nil {
+		return nil
+	}
+	form, _ := resolveDict(d, ref)
+	return form
+}
+
 // HasForm returns true if the document has a form
 func (d *Document) HasForm() bool {
 	return d.Root.Get("AcroForm") != nil
@@ -0,0 +1,933 @@
+package pdf
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fcBuiltin is a FormCalc built-in function. Functions receive their
+// arguments unevaluated so that aggregates can expand accessors such as
+// row[*].amount and Exists can test references.
+type fcBuiltin struct {
+	min, max int // number of arguments; max -1 for any
+	fn       func(in *fcInterp, args []fcNode) fcValue
+}
+
+// fcBuiltins maps lower-case function names to their implementations;
+// FormCalc function names are not case sensitive
+var fcBuiltins map[string]fcBuiltin
+
+func init() {
+	fcBuiltins = map[string]fcBuiltin{
+		// Arithmetic
+		"abs":   {1, 1, fcAbs},
+		"avg":   {1, -1, fcAvg},
+		"ceil":  {1, 1, fcCeil},
+		"count": {1, -1, fcCount},
+		"floor": {1, 1, fcFloor},
+		"max":   {1, -1, fcMax},
+		"min":   {1, -1, fcMin},
+		"mod":   {2, 2, fcMod},
+		"round": {1, 2, fcRound},
+		"sum":   {1, -1, fcSum},
+
+		// Date and time
+		"date":         {0, 0, fcDate},
+		"date2num":     {1, 3, fcDate2Num},
+		"datefmt":      {0, 2, fcDateFmt},
+		"isodate2num":  {1, 1, fcIsoDate2Num},
+		"isotime2num":  {1, 1, fcIsoTime2Num},
+		"localdatefmt": {0, 2, fcDateFmt},
+		"localtimefmt": {0, 2, fcTimeFmt},
+		"num2date":     {1, 3, fcNum2Date},
+		"num2gmtime":   {1, 3, fcNum2Time},
+		"num2time":     {1, 3, fcNum2Time},
+		"time":         {0, 0, fcTime},
+		"time2num":     {1, 3, fcTime2Num},
+		"timefmt":      {0, 2, fcTimeFmt},
+
+		// Financial
+		"apr":   {3, 3, fcApr},
+		"cterm": {3, 3, fcCTerm},
+		"fv":    {3, 3, fcFV},
+		"ipmt":  {5, 5, fcIPmt},
+		"npv":   {2, -1, fcNPV},
+		"pmt":   {3, 3, fcPmt},
+		"ppmt":  {5, 5, fcPPmt},
+		"pv":    {3, 3, fcPV},
+		"rate":  {3, 3, fcRate},
+		"term":  {3, 3, fcTerm},
+
+		// Logical
+		"choose":   {2, -1, fcChoose},
+		"exists":   {1, 1, fcExists},
+		"hasvalue": {1, 1, fcHasValue},
+		"oneof":    {2, -1, fcOneof},
+		"within":   {3, 3, fcWithin},
+
+		// Miscellaneous
+		"eval":      {1, 1, fcEval},
+		"null":      {0, 0, func(in *fcInterp, args []fcNode) fcValue { return nil }},
+		"ref":       {1, 1, fcRef},
+		"unittype":  {1, 1, fcUnitType},
+		"unitvalue": {1, 2, fcUnitValue},
+
+		// String
+		"at":      {2, 2, fcAt},
+		"concat":  {1, -1, fcConcat},
+		"decode":  {1, 2, fcDecode},
+		"encode":  {1, 2, fcEncode},
+		"format":  {2, -1, fcFormat},
+		"left":    {2, 2, fcLeft},
+		"len":     {1, 1, fcLen},
+		"lower":   {1, 2, fcLower},
+		"ltrim":   {1, 1, fcLtrim},
+		"parse":   {2, 2, fcParseFn},
+		"replace": {2, 3, fcReplace},
+		"right":   {2, 2, fcRight},
+		"rtrim":   {1, 1, fcRtrim},
+		"space":   {1, 1, fcSpace},
+		"str":     {1, 3, fcStrFn},
+		"stuff":   {3, 4, fcStuff},
+		"substr":  {3, 3, fcSubstr},
+		"upper":   {1, 2, fcUpper},
+		"uuid":    {0, 1, fcUuid},
+		"wordnum": {1, 3, fcWordNum},
+
+		// URL functions need network access, which scripts do not have
+		"get":  {1, 1, fcNoNetwork("Get")},
+		"post": {2, 5, fcNoNetwork("Post")},
+		"put":  {2, 3, fcNoNetwork("Put")},
+	}
+}
+
+// nums evaluates arguments to numbers, reporting whether any was null
+func (in *fcInterp) nums(args []fcNode) ([]float64, bool) {
+	out := make([]float64, len(args))
+	null := false
+	for i, a := range args {
+		v := in.value(a)
+		if v == nil {
+			null = true
+		}
+		out[i] = fcNum(v)
+	}
+	return out, null
+}
+
+// optNum returns argument i as a number or def when it is missing or null
+func (in *fcInterp) optNum(args []fcNode, i int, def float64) float64 {
+	if i >= len(args) {
+		return def
+	}
+	v := in.value(args[i])
+	if v == nil {
+		return def
+	}
+	return fcNum(v)
+}
+
+// optStr returns argument i as a string or def when it is missing or null
+func (in *fcInterp) optStr(args []fcNode, i int, def string) string {
+	if i >= len(args) {
+		return def
+	}
+	v := in.value(args[i])
+	if v == nil {
+		return def
+	}
+	return fcStr(v)
+}
+
+// str returns a string result, charging it against the memory limit
+func (in *fcInterp) str(s string) fcValue {
+	in.alloc(len(s))
+	return s
+}
+
+// Arithmetic
+
+func fcUnaryMath(f func(float64) float64) func(in *fcInterp, args []fcNode) fcValue {
+	return func(in *fcInterp, args []fcNode) fcValue {
+		v := in.value(args[0])
+		if v == nil {
+			return nil
+		}
+		return f(fcNum(v))
+	}
+}
+
+var (
+	fcAbs   = fcUnaryMath(math.Abs)
+	fcCeil  = fcUnaryMath(math.Ceil)
+	fcFloor = fcUnaryMath(math.Floor)
+)
+
+// numbers returns the non-null values of the expanded arguments
+func (in *fcInterp) numbers(args []fcNode) []float64 {
+	var out []float64
+	for _, v := range in.allValues(args) {
+		if v != nil {
+			out = append(out, fcNum(v))
+		}
+	}
+	return out
+}
+
+func fcSum(in *fcInterp, args []fcNode) fcValue {
+	vals := in.numbers(args)
+	if len(vals) == 0 {
+		return nil
+	}
+	sum := 0.0
+	for _, v := range vals {
+		sum += v
+	}
+	return sum
+}
+
+func fcAvg(in *fcInterp, args []fcNode) fcValue {
+	vals := in.numbers(args)
+	if len(vals) == 0 {
+		return nil
+	}
+	sum := 0.0
+	for _, v := range vals {
+		sum += v
+	}
+	return sum / float64(len(vals))
+}
+
+func fcCount(in *fcInterp, args []fcNode) fcValue {
+	n := 0
+	for _, v := range in.allValues(args) {
+		if v != nil {
+			n++
+		}
+	}
+	return float64(n)
+}
+
+func fcMax(in *fcInterp, args []fcNode) fcValue {
+	vals := in.numbers(args)
+	if len(vals) == 0 {
+		return nil
+	}
+	m := vals[0]
+	for _, v := range vals[1:] {
+		m = math.Max(m, v)
+	}
+	return m
+}
+
+func fcMin(in *fcInterp, args []fcNode) fcValue {
+	vals := in.numbers(args)
+	if len(vals) == 0 {
+		return nil
+	}
+	m := vals[0]
+	for _, v := range vals[1:] {
+		m = math.Min(m, v)
+	}
+	return m
+}
+
+func fcMod(in *fcInterp, args []fcNode) fcValue {
+	n, null := in.nums(args)
+	if null {
+		return nil
+	}
+	if n[1] == 0 {
+		in.fail("Mod: division by zero")
+	}
+	return math.Mod(n[0], n[1])
+}
+
+func fcRound(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	places := int(in.optNum(args, 1, 0))
+	if places < 0 {
+		places = 0
+	} else if places > 12 {
+		places = 12
+	}
+	return fcRoundTo(fcNum(v), places)
+}
+
+// fcRoundTo rounds half away from zero to a number of decimal places,
+// tolerating the representation error of decimal fractions
+func fcRoundTo(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p+math.Copysign(1e-9, v)) / p
+}
+
+// Financial
+
+func fcApr(in *fcInterp, args []fcNode) fcValue {
+	n, null := in.nums(args)
+	if null {
+		return nil
+	}
+	principal, payment, periods := n[0], n[1], n[2]
+	if principal <= 0 || payment <= 0 || periods <= 0 {
+		in.fail("Apr: arguments must be positive")
+	}
+	if payment*periods < principal {
+		in.fail("Apr: payments do not repay the principal")
+	}
+	// Find the monthly rate at which the payment repays the principal
+	lo, hi := 0.0, 1.0
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		if fcPayment(principal, mid, periods) < payment {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2 * 12
+}
+
+// fcPayment returns the periodic payment of a loan
+func fcPayment(principal, rate, periods float64) float64 {
+	if rate == 0 {
+		return principal / periods
+	}
+	return principal * rate / (1 - math.Pow(1+rate, -periods))
+}
+
+func fcCTerm(in *fcInterp, args []fcNode) fcValue {
+	n, null := in.nums(args)
+	if null {
+		return nil
+	}
+	rate, fv, pv := n[0], n[1], n[2]
+	if rate <= 0 || fv <= 0 || pv <= 0 {
+		in.fail("CTerm: arguments must be positive")
+	}
+	return math.Log(fv/pv) / math.Log(1+rate)
+}
+
+func fcFV(in *fcInterp, args []fcNode) fcValue {
+	n, null := in.nums(args)
+	if null {
+		return nil
+	}
+	payment, rate, periods := n[0], n[1], n[2]
+	if payment <= 0 || rate < 0 || periods <= 0 {
+		in.fail("FV: invalid arguments")
+	}
+	if rate == 0 {
+		return payment * periods
+	}
+	return payment * (math.Pow(1+rate, periods) - 1) / rate
+}
+
+// fcAmortize returns the interest and principal paid over a number of
+// payments of a loan with an annual rate, starting at payment start
+func (in *fcInterp) amortize(name string, args []fcNode) (interest, principal float64, ok bool) {
+	n, null := in.nums(args)
+	if null {
+		return 0, 0, false
+	}
+	balance, rate, payment, start, months := n[0], n[1]/12, n[2], int(n[3]), int(n[4])
+	if balance <= 0 || rate <= 0 || payment <= 0 || start < 1 || months < 1 {
+		in.fail("%s: invalid arguments", name)
+	}
+	if payment <= balance*rate {
+		return 0, 0, true
+	}
+	for i := 1; i < start+months && balance > 0; i++ {
+		in.tick()
+		intPart := balance * rate
+		princPart := math.Min(payment-intPart, balance)
+		if i >= start {
+			interest += intPart
+			principal += princPart
+		}
+		balance -= princPart
+	}
+	return interest, principal, true
+}
+
+func fcIPmt(in *fcInterp, args []fcNode) fcValue {
+	interest, _, ok := in.amortize("IPmt", args)
+	if !ok {
+		return nil
+	}
+	return interest
+}
+
+func fcPPmt(in *fcInterp, args []fcNode) fcValue {
+	_, principal, ok := in.amortize("PPmt", args)
+	if !ok {
+		return nil
+	}
+	return principal
+}
+
+func fcNPV(in *fcInterp, args []fcNode) fcValue {
+	rate := in.value(args[0])
+	vals := in.allValues(args[1:])
+	if rate == nil {
+		return nil
+	}
+	r := fcNum(rate)
+	if r <= 0 {
+		in.fail("NPV: rate must be positive")
+	}
+	npv := 0.0
+	for i, v := range vals {
+		if v == nil {
+			return nil
+		}
+		npv += fcNum(v) / math.Pow(1+r, float64(i+1))
+	}
+	return npv
+}
+
+func fcPmt(in *fcInterp, args []fcNode) fcValue {
+	n, null := in.nums(args)
+	if null {
+		return nil
+	}
+	principal, rate, periods := n[0], n[1], n[2]
+	if principal <= 0 || rate < 0 || periods <= 0 {
+		in.fail("Pmt: invalid arguments")
+	}
+	return fcPayment(principal, rate, periods)
+}
+
+func fcPV(in *fcInterp, args []fcNode) fcValue {
+	n, null := in.nums(args)
+	if null {
+		return nil
+	}
+	payment, rate, periods := n[0], n[1], n[2]
+	if payment <= 0 || rate < 0 || periods <= 0 {
+		in.fail("PV: invalid arguments")
+	}
+	if rate == 0 {
+		return payment * periods
+	}
+	return payment * (1 - math.Pow(1+rate, -periods)) / rate
+}
+
+func fcRate(in *fcInterp, args []fcNode) fcValue {
+	n, null := in.nums(args)
+	if null {
+		return nil
+	}
+	fv, pv, periods := n[0], n[1], n[2]
+	if fv <= 0 || pv <= 0 || periods <= 0 {
+		in.fail("Rate: arguments must be positive")
+	}
+	return math.Pow(fv/pv, 1/periods) - 1
+}
+
+func fcTerm(in *fcInterp, args []fcNode) fcValue {
+	n, null := in.nums(args)
+	if null {
+		return nil
+	}
+	payment, rate, fv := n[0], n[1], n[2]
+	if payment <= 0 || rate <= 0 || fv <= 0 {
+		in.fail("Term: arguments must be positive")
+	}
+	return math.Log(1+fv*rate/payment) / math.Log(1+rate)
+}
+
+// Logical
+
+func fcChoose(in *fcInterp, args []fcNode) fcValue {
+	i := in.value(args[0])
+	if i == nil {
+		return nil
+	}
+	vals := in.allValues(args[1:])
+	n := int(fcNum(i))
+	if n < 1 || n > len(vals) {
+		return ""
+	}
+	return vals[n-1]
+}
+
+func fcExists(in *fcInterp, args []fcNode) fcValue {
+	var objs []fcValue
+	if a, ok := args[0].(*fcAccessor); ok {
+		err := in.protect(func() { objs = in.resolve(a) })
+		if err != nil {
+			return 0.0
+		}
+	} else if s, ok := in.value(args[0]).(string); ok && in.model != nil {
+		objs = in.resolveSOM(s, in.this)
+	}
+	return fcBool(len(objs) > 0)
+}
+
+func fcHasValue(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if s, ok := v.(string); ok {
+		return fcBool(strings.TrimSpace(s) != "")
+	}
+	return fcBool(v != nil)
+}
+
+func fcOneof(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	for _, c := range in.allValues(args[1:]) {
+		if fcEqual(v, c) {
+			return 1.0
+		}
+	}
+	return 0.0
+}
+
+func fcWithin(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	lo, hi := in.value(args[1]), in.value(args[2])
+	if s, ok := v.(string); ok && !fcIsNumber(s) {
+		return fcBool(s >= fcStr(lo) && s <= fcStr(hi))
+	}
+	n := fcNum(v)
+	return fcBool(n >= fcNum(lo) && n <= fcNum(hi))
+}
+
+// fcIsNumber reports whether a string holds a number
+func fcIsNumber(s string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return err == nil
+}
+
+// Miscellaneous
+
+func fcEval(in *fcInterp, args []fcNode) fcValue {
+	src := fcStr(in.value(args[0]))
+	body, err := fcParse(src)
+	if err != nil {
+		panic(fcFatal{err})
+	}
+	v, _ := in.execBlock(body)
+	return v
+}
+
+func fcRef(in *fcInterp, args []fcNode) fcValue {
+	if a, ok := args[0].(*fcAccessor); ok {
+		return fcReference{in.resolve(a)[0]}
+	}
+	return in.value(args[0])
+}
+
+// fcUnits maps unit names to their size in points
+var fcUnits = map[string]float64{
+	"in": 72, "inches": 72, "cm": 72 / 2.54, "centimeters": 72 / 2.54,
+	"mm": 72 / 25.4, "millimeters": 72 / 25.4, "pt": 1, "points": 1,
+	"mp": 0.001, "millipoints": 0.001,
+}
+
+// fcSplitMeasure splits a measurement such as "2.5cm" into its value and
+// unit; the unit defaults to inches
+func fcSplitMeasure(s string) (float64, string, bool) {
+	s = strings.TrimSpace(s)
+	m := fcNumberPrefix.FindString(s)
+	unit := strings.ToLower(strings.TrimSpace(s[len(m):]))
+	if unit == "" {
+		unit = "in"
+	}
+	if _, ok := fcUnits[unit]; !ok {
+		return 0, "", false
+	}
+	v, _ := strconv.ParseFloat(m, 64)
+	return v, unit, true
+}
+
+func fcUnitType(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	_, unit, ok := fcSplitMeasure(fcStr(v))
+	if !ok {
+		return "in"
+	}
+	switch unit {
+	case "inches":
+		unit = "in"
+	case "centimeters":
+		unit = "cm"
+	case "millimeters":
+		unit = "mm"
+	case "points":
+		unit = "pt"
+	case "millipoints":
+		unit = "mp"
+	}
+	return unit
+}
+
+func fcUnitValue(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	n, unit, ok := fcSplitMeasure(fcStr(v))
+	if !ok {
+		return 0.0
+	}
+	target := strings.ToLower(in.optStr(args, 1, unit))
+	if _, t, ok := fcSplitMeasure("0" + target); ok {
+		target = t
+	}
+	to, ok := fcUnits[target]
+	if !ok {
+		in.fail("UnitValue: unknown unit %q", target)
+	}
+	return n * fcUnits[unit] / to
+}
+
+func fcNoNetwork(name string) func(in *fcInterp, args []fcNode) fcValue {
+	return func(in *fcInterp, args []fcNode) fcValue {
+		in.fail("%s is not supported: scripts have no network access", name)
+		return nil
+	}
+}
+
+// String
+
+// strArg evaluates a string argument, reporting a null value
+func (in *fcInterp) strArg(n fcNode) (string, bool) {
+	v := in.value(n)
+	return fcStr(v), v == nil
+}
+
+func fcAt(in *fcInterp, args []fcNode) fcValue {
+	s, null1 := in.strArg(args[0])
+	sub, null2 := in.strArg(args[1])
+	if null1 || null2 {
+		return nil
+	}
+	if sub == "" {
+		return 1.0
+	}
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return 0.0
+	}
+	return float64(len([]rune(s[:i])) + 1)
+}
+
+func fcConcat(in *fcInterp, args []fcNode) fcValue {
+	var sb strings.Builder
+	null := true
+	for _, v := range in.allValues(args) {
+		if v != nil {
+			null = false
+			sb.WriteString(fcStr(v))
+		}
+	}
+	if null {
+		return nil
+	}
+	return in.str(sb.String())
+}
+
+func fcDecode(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	switch strings.ToLower(in.optStr(args, 1, "url")) {
+	case "url":
+		var out []byte
+		for i := 0; i < len(s); i++ {
+			if s[i] == '%' && i+2 < len(s) {
+				if b, err := hex.DecodeString(s[i+1 : i+3]); err == nil {
+					out = append(out, b[0])
+					i += 2
+					continue
+				}
+			}
+			out = append(out, s[i])
+		}
+		return in.str(string(out))
+	case "html", "xml":
+		return in.str(html.UnescapeString(s))
+	}
+	in.fail("Decode: unknown encoding")
+	return nil
+}
+
+func fcEncode(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	var sb strings.Builder
+	switch strings.ToLower(in.optStr(args, 1, "url")) {
+	case "url":
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			if c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-_.!~*'()", c) >= 0) {
+				sb.WriteByte(c)
+			} else {
+				fmt.Fprintf(&sb, "%%%02x", c)
+			}
+		}
+	case "html":
+		for _, r := range s {
+			switch {
+			case r == '<':
+				sb.WriteString("&lt;")
+			case r == '>':
+				sb.WriteString("&gt;")
+			case r == '&':
+				sb.WriteString("&amp;")
+			case r == '"':
+				sb.WriteString("&quot;")
+			case r > 0x7e:
+				fmt.Fprintf(&sb, "&#x%x;", r)
+			default:
+				sb.WriteRune(r)
+			}
+		}
+	case "xml":
+		for _, r := range s {
+			switch {
+			case r == '<':
+				sb.WriteString("&lt;")
+			case r == '>':
+				sb.WriteString("&gt;")
+			case r == '&':
+				sb.WriteString("&amp;")
+			case r == '"':
+				sb.WriteString("&quot;")
+			case r == '\'':
+				sb.WriteString("&apos;")
+			case r > 0x7e:
+				fmt.Fprintf(&sb, "&#x%x;", r)
+			default:
+				sb.WriteRune(r)
+			}
+		}
+	default:
+		in.fail("Encode: unknown encoding")
+	}
+	return in.str(sb.String())
+}
+
+// fcClampCount limits a count argument to [0, max]
+func fcClampCount(n float64, max int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > float64(max) {
+		return max
+	}
+	return int(n)
+}
+
+func fcLeft(in *fcInterp, args []fcNode) fcValue {
+	s, null1 := in.strArg(args[0])
+	n := in.value(args[1])
+	if null1 || n == nil {
+		return nil
+	}
+	r := []rune(s)
+	return string(r[:fcClampCount(fcNum(n), len(r))])
+}
+
+func fcRight(in *fcInterp, args []fcNode) fcValue {
+	s, null1 := in.strArg(args[0])
+	n := in.value(args[1])
+	if null1 || n == nil {
+		return nil
+	}
+	r := []rune(s)
+	return string(r[len(r)-fcClampCount(fcNum(n), len(r)):])
+}
+
+func fcLen(in *fcInterp, args []fcNode) fcValue {
+	s, _ := in.strArg(args[0])
+	return float64(len([]rune(s)))
+}
+
+func fcLower(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	return strings.ToLower(s)
+}
+
+func fcUpper(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	return strings.ToUpper(s)
+}
+
+func fcLtrim(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	return strings.TrimLeftFunc(s, unicode.IsSpace)
+}
+
+func fcRtrim(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	return strings.TrimRightFunc(s, unicode.IsSpace)
+}
+
+func fcReplace(in *fcInterp, args []fcNode) fcValue {
+	s, null1 := in.strArg(args[0])
+	find, null2 := in.strArg(args[1])
+	if null1 || null2 {
+		return nil
+	}
+	if find == "" {
+		return s
+	}
+	return in.str(strings.ReplaceAll(s, find, in.optStr(args, 2, "")))
+}
+
+func fcSpace(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	n := fcClampCount(fcNum(v), math.MaxInt32)
+	in.alloc(n)
+	return strings.Repeat(" ", n)
+}
+
+func fcStrFn(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	width := fcClampCount(in.optNum(args, 1, 10), 1<<20)
+	precision := fcClampCount(in.optNum(args, 2, 0), 15)
+	s := strconv.FormatFloat(fcRoundTo(fcNum(v), precision), 'f', precision, 64)
+	in.alloc(width)
+	if len(s) > width {
+		return strings.Repeat("*", width)
+	}
+	return strings.Repeat(" ", width-len(s)) + s
+}
+
+func fcStuff(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	start, count := in.value(args[1]), in.value(args[2])
+	if null || start == nil || count == nil {
+		return nil
+	}
+	r := []rune(s)
+	i := fcClampCount(fcNum(start)-1, len(r))
+	j := i + fcClampCount(fcNum(count), len(r)-i)
+	return in.str(string(r[:i]) + in.optStr(args, 3, "") + string(r[j:]))
+}
+
+func fcSubstr(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	start, count := in.value(args[1]), in.value(args[2])
+	if null || start == nil || count == nil {
+		return nil
+	}
+	r := []rune(s)
+	i := fcClampCount(fcNum(start)-1, len(r))
+	j := i + fcClampCount(fcNum(count), len(r)-i)
+	return string(r[i:j])
+}
+
+func fcUuid(in *fcInterp, args []fcNode) fcValue {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b[:])
+	if in.optNum(args, 0, 0) == 1 {
+		s = s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+	}
+	return s
+}
+
+var (
+	fcOnes = []string{"", "One", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine",
+		"Ten", "Eleven", "Twelve", "Thirteen", "Fourteen", "Fifteen", "Sixteen", "Seventeen",
+		"Eighteen", "Nineteen"}
+	fcTens   = []string{"", "", "Twenty", "Thirty", "Forty", "Fifty", "Sixty", "Seventy", "Eighty", "Ninety"}
+	fcGroups = []string{"", "Thousand", "Million", "Billion", "Trillion"}
+)
+
+// fcWords spells out a whole number below 10^15
+func fcWords(n int64) string {
+	if n == 0 {
+		return "Zero"
+	}
+	var parts []string
+	for g := 0; n > 0; g++ {
+		chunk := n % 1000
+		n /= 1000
+		if chunk == 0 {
+			continue
+		}
+		var words []string
+		if h := chunk / 100; h > 0 {
+			words = append(words, fcOnes[h], "Hundred")
+		}
+		if rest := chunk % 100; rest >= 20 {
+			w := fcTens[rest/10]
+			if rest%10 != 0 {
+				w += "-" + strings.ToLower(fcOnes[rest%10])
+			}
+			words = append(words, w)
+		} else if rest > 0 {
+			words = append(words, fcOnes[rest])
+		}
+		if fcGroups[g] != "" {
+			words = append(words, fcGroups[g])
+		}
+		parts = append([]string{strings.Join(words, " ")}, parts...)
+	}
+	return strings.Join(parts, " ")
+}
+
+func fcWordNum(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	n := fcNum(v)
+	if n < 0 || n >= 1e15 {
+		return "*"
+	}
+	whole := int64(n)
+	cents := int64(fcRoundTo((n-float64(whole))*100, 0))
+	if cents == 100 {
+		whole, cents = whole+1, 0
+	}
+	switch int(in.optNum(args, 1, 0)) {
+	case 1:
+		return fcWords(whole) + " Dollars"
+	case 2:
+		return fcWords(whole) + " Dollars And " + fcWords(cents) + " Cents"
+	}
+	return fcWords(whole)
+}
@@ -0,0 +1,1309 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned when a FormCalc script exceeds its resource limits
+var (
+	ErrFormCalcTimeout = errors.New("formcalc: execution time limit exceeded")
+	ErrFormCalcMemory  = errors.New("formcalc: memory limit exceeded")
+)
+
+// fcValue is a FormCalc value: nil (null), float64, string, or an object
+// of the scripting object model such as *XFAFormNode or *xfaNode
+type fcValue interface{}
+
+// Objects reached through SOM expressions besides form and data nodes
+type (
+	fcXFAObject   struct{}
+	fcHostObject  struct{}
+	fcEventObject struct{}
+
+	// fcProperty is a property of an object, such as rawValue
+	fcProperty struct {
+		obj  fcValue
+		name string
+	}
+
+	// fcInstanceManager controls the instances of a repeating subform
+	fcInstanceManager struct {
+		parent *XFAFormNode
+		name   string
+	}
+
+	// fcReference is an object returned by Ref(), kept as an object when
+	// stored in a variable
+	fcReference struct{ obj fcValue }
+)
+
+// fcFatal carries an error out of the evaluator
+type fcFatal struct{ err error }
+
+// fcControl is how a statement completed
+type fcControl int
+
+const (
+	fcNormal fcControl = iota
+	fcBreakCtl
+	fcContinueCtl
+	fcReturnCtl
+)
+
+type fcScope struct {
+	vars   map[string]fcValue
+	parent *fcScope
+}
+
+func newFCScope(parent *fcScope) *fcScope {
+	return &fcScope{vars: make(map[string]fcValue), parent: parent}
+}
+
+func (s *fcScope) lookup(name string) (*fcScope, bool) {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.vars[name]; ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// fcInterp evaluates FormCalc scripts against an XFA form. Without a form
+// only the built-in functions and variables are available.
+type fcInterp struct {
+	xfa    *XFAForm
+	this   *XFAFormNode
+	model  *XFAFormNode // the $form model, whose child is the root subform
+	funcs  map[string]*fcFuncDecl
+	global *fcScope
+	scope  *fcScope
+
+	limits    JSLimits
+	deadline  time.Time
+	steps     int
+	allocated int64
+	depth     int
+}
+
+func newFCInterp(xfa *XFAForm, limits JSLimits) *fcInterp {
+	in := &fcInterp{xfa: xfa, funcs: make(map[string]*fcFuncDecl), limits: limits}
+	if xfa != nil && xfa.Form != nil {
+		in.model = &XFAFormNode{Class: "form", Children: []*XFAFormNode{xfa.Form}}
+	}
+	return in
+}
+
+// run parses and executes a script with this as the current object and
+// returns the value of the last expression evaluated
+func (in *fcInterp) run(src string, this *XFAFormNode) (result fcValue, err error) {
+	body, err := fcParse(src)
+	if err != nil {
+		return nil, err
+	}
+	in.this = this
+	in.global = newFCScope(nil)
+	in.scope = in.global
+	in.steps, in.allocated, in.depth = 0, 0, 0
+	in.deadline = time.Time{}
+	if in.limits.Timeout > 0 {
+		in.deadline = time.Now().Add(in.limits.Timeout)
+	}
+
+	err = in.protect(func() {
+		result, _ = in.execList(body)
+		result = in.scalar(result)
+	})
+	return result, err
+}
+
+// protect calls fn, converting evaluation errors into errors
+func (in *fcInterp) protect(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch v := r.(type) {
+			case fcFatal:
+				err = v.err
+			case *fcSyntaxError:
+				err = v
+			default:
+				err = fmt.Errorf("formcalc: internal error: %v", r)
+			}
+		}
+	}()
+	fn()
+	return nil
+}
+
+func (in *fcInterp) fail(format string, args ...interface{}) {
+	panic(fcFatal{fmt.Errorf("formcalc: "+format, args...)})
+}
+
+// tick counts an evaluation step and enforces the time limit
+func (in *fcInterp) tick() {
+	in.steps++
+	if in.steps&1023 == 0 && !in.deadline.IsZero() && time.Now().After(in.deadline) {
+		panic(fcFatal{ErrFormCalcTimeout})
+	}
+}
+
+// alloc charges n bytes against the memory limit
+func (in *fcInterp) alloc(n int) {
+	in.allocated += int64(n)
+	if in.limits.MaxMemory > 0 && in.allocated > in.limits.MaxMemory {
+		panic(fcFatal{ErrFormCalcMemory})
+	}
+}
+
+// Statements
+
+func (in *fcInterp) execList(list []fcNode) (fcValue, fcControl) {
+	var last fcValue
+	for _, n := range list {
+		v, ctl := in.exec(n)
+		if ctl != fcNormal {
+			return v, ctl
+		}
+		last = v
+	}
+	return last, fcNormal
+}
+
+// execBlock executes a list in a new variable scope
+func (in *fcInterp) execBlock(list []fcNode) (fcValue, fcControl) {
+	saved := in.scope
+	in.scope = newFCScope(saved)
+	defer func() { in.scope = saved }()
+	return in.execList(list)
+}
+
+func (in *fcInterp) exec(n fcNode) (fcValue, fcControl) {
+	in.tick()
+	switch n := n.(type) {
+	case *fcVarDecl:
+		var v fcValue
+		if n.init != nil {
+			v = in.storable(in.eval(n.init))
+		}
+		in.alloc(32)
+		in.scope.vars[n.name] = v
+		return v, fcNormal
+
+	case *fcAssign:
+		return in.assign(n.target, in.eval(n.value)), fcNormal
+
+	case *fcIf:
+		if in.truth(in.eval(n.cond)) {
+			return in.execBlock(n.then)
+		}
+		return in.execBlock(n.els)
+
+	case *fcWhile:
+		var last fcValue
+		for in.truth(in.eval(n.cond)) {
+			v, ctl := in.execBlock(n.body)
+			if ctl == fcReturnCtl {
+				return v, ctl
+			}
+			if ctl == fcBreakCtl {
+				break
+			}
+			last = v
+		}
+		return last, fcNormal
+
+	case *fcFor:
+		start := fcNum(in.scalar(in.eval(n.start)))
+		end := fcNum(in.scalar(in.eval(n.end)))
+		step := 1.0
+		if n.step != nil {
+			step = fcNum(in.scalar(in.eval(n.step)))
+			if step <= 0 {
+				in.fail("for loop step must be positive")
+			}
+		}
+		if n.down {
+			step = -step
+		}
+		saved := in.scope
+		in.scope = newFCScope(saved)
+		defer func() { in.scope = saved }()
+		var last fcValue
+		for i := start; n.down && i >= end || !n.down && i <= end; {
+			in.scope.vars[n.name] = i
+			v, ctl := in.execBlock(n.body)
+			if ctl == fcReturnCtl {
+				return v, ctl
+			}
+			if ctl == fcBreakCtl {
+				break
+			}
+			last = v
+			i = fcNum(in.scalar(in.scope.vars[n.name])) + step
+		}
+		return last, fcNormal
+
+	case *fcForeach:
+		var values []fcValue
+		for _, x := range n.list {
+			values = append(values, in.values(x)...)
+		}
+		saved := in.scope
+		in.scope = newFCScope(saved)
+		defer func() { in.scope = saved }()
+		var last fcValue
+		for _, v := range values {
+			in.scope.vars[n.name] = v
+			r, ctl := in.execBlock(n.body)
+			if ctl == fcReturnCtl {
+				return r, ctl
+			}
+			if ctl == fcBreakCtl {
+				break
+			}
+			last = r
+		}
+		return last, fcNormal
+
+	case *fcFuncDecl:
+		in.funcs[strings.ToLower(n.name)] = n
+		return nil, fcNormal
+
+	case *fcBlock:
+		return in.execBlock(n.body)
+
+	case *fcReturn:
+		var v fcValue
+		if n.x != nil {
+			v = in.eval(n.x)
+		}
+		return v, fcReturnCtl
+
+	case *fcBreak:
+		return nil, fcBreakCtl
+
+	case *fcContinue:
+		return nil, fcContinueCtl
+	}
+	return in.eval(n), fcNormal
+}
+
+// assign stores a value in a variable or in the object target names
+func (in *fcInterp) assign(target *fcAccessor, v fcValue) fcValue {
+	if len(target.steps) == 1 && fcPlainStep(target.steps[0]) {
+		if s, ok := in.scope.lookup(target.steps[0].name); ok {
+			v = in.storable(v)
+			s.vars[target.steps[0].name] = v
+			return v
+		}
+	}
+
+	v = in.scalar(v)
+	objs := in.resolve(target)
+	switch o := objs[0].(type) {
+	case *XFAFormNode:
+		in.setValue(o, v)
+	case *xfaNode:
+		in.setDataValue(o, fcStr(v))
+	case fcProperty:
+		in.setProperty(o, v)
+	default:
+		in.fail("cannot assign to %s", fcAccessorText(target))
+	}
+	return v
+}
+
+// storable returns the value a variable holds: objects are replaced by
+// their values unless they come from Ref()
+func (in *fcInterp) storable(v fcValue) fcValue {
+	if r, ok := v.(fcReference); ok {
+		return r
+	}
+	return in.scalar(v)
+}
+
+// setValue sets the value of a form node
+func (in *fcInterp) setValue(n *XFAFormNode, v fcValue) {
+	switch n.Class {
+	case "field", "exclGroup":
+		in.xfa.setNodeValue(n, fcStr(v))
+	case "draw":
+		n.Value = fcStr(v)
+	default:
+		in.fail("cannot assign a value to %s %s", n.Class, n.SOM())
+	}
+}
+
+// setDataValue sets the text of a data value and of the fields bound to it
+func (in *fcInterp) setDataValue(d *xfaNode, value string) {
+	if d.isDataGroup() {
+		in.fail("cannot assign a value to data group %s", d.local())
+	}
+	var bound *XFAFormNode
+	in.xfa.Form.walk(func(n *XFAFormNode) {
+		if bound == nil && n.data == d {
+			bound = n
+		}
+	})
+	if bound != nil {
+		in.xfa.setNodeValue(bound, value)
+	} else {
+		d.setText(value)
+	}
+}
+
+// Expressions
+
+func (in *fcInterp) eval(n fcNode) fcValue {
+	in.tick()
+	switch n := n.(type) {
+	case *fcNumberLit:
+		return n.value
+	case *fcStringLit:
+		return n.value
+	case *fcNullLit:
+		return nil
+	case *fcAccessor:
+		return in.resolve(n)[0]
+	case *fcCall:
+		return in.call(n)
+	case *fcUnary:
+		v := in.scalar(in.eval(n.x))
+		switch n.op {
+		case "not":
+			return fcBool(!in.truth(v))
+		case "-":
+			if v == nil {
+				return nil
+			}
+			return -fcNum(v)
+		default:
+			if v == nil {
+				return nil
+			}
+			return fcNum(v)
+		}
+	case *fcBinary:
+		return in.binary(n.op, in.scalar(in.eval(n.l)), in.scalar(in.eval(n.r)))
+	}
+	// Statements used as expressions, such as an if expression as an
+	// argument
+	v, _ := in.exec(n)
+	return v
+}
+
+func (in *fcInterp) binary(op string, l, r fcValue) fcValue {
+	switch op {
+	case "|":
+		if l == nil && r == nil {
+			return nil
+		}
+		return fcBool(in.truth(l) || in.truth(r))
+	case "&":
+		if l == nil && r == nil {
+			return nil
+		}
+		return fcBool(in.truth(l) && in.truth(r))
+	case "==":
+		return fcBool(fcEqual(l, r))
+	case "<>":
+		return fcBool(!fcEqual(l, r))
+	case "<", "<=", ">", ">=":
+		if l == nil && r == nil {
+			return fcBool(op == "<=" || op == ">=")
+		}
+		var c int
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok && rok {
+			c = strings.Compare(ls, rs)
+		} else {
+			a, b := fcNum(l), fcNum(r)
+			switch {
+			case a < b:
+				c = -1
+			case a > b:
+				c = 1
+			}
+		}
+		switch op {
+		case "<":
+			return fcBool(c < 0)
+		case "<=":
+			return fcBool(c <= 0)
+		case ">":
+			return fcBool(c > 0)
+		}
+		return fcBool(c >= 0)
+	}
+
+	// Arithmetic treats null as zero unless both operands are null
+	if l == nil && r == nil {
+		return nil
+	}
+	a, b := fcNum(l), fcNum(r)
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	}
+	if b == 0 {
+		in.fail("division by zero")
+	}
+	return a / b
+}
+
+// truth reports whether a value is true: a number other than zero
+func (in *fcInterp) truth(v fcValue) bool {
+	v = in.scalar(v)
+	if v == nil {
+		return false
+	}
+	return fcNum(v) != 0
+}
+
+// call invokes a script function or a built-in function
+func (in *fcInterp) call(n *fcCall) fcValue {
+	name := strings.ToLower(n.name)
+	if f := in.funcs[name]; f != nil {
+		if len(n.args) != len(f.params) {
+			in.fail("function %s takes %d arguments", f.name, len(f.params))
+		}
+		args := make([]fcValue, len(n.args))
+		for i, a := range n.args {
+			args[i] = in.storable(in.eval(a))
+		}
+		maxDepth := in.limits.MaxDepth
+		if maxDepth <= 0 {
+			maxDepth = DefaultJSLimits.MaxDepth
+		}
+		if in.depth >= maxDepth {
+			in.fail("maximum call depth exceeded")
+		}
+		in.depth++
+		saved := in.scope
+		in.scope = newFCScope(in.global)
+		for i, p := range f.params {
+			in.scope.vars[p] = args[i]
+		}
+		v, _ := in.execList(f.body)
+		in.scope = saved
+		in.depth--
+		return in.storable(v)
+	}
+
+	b, ok := fcBuiltins[name]
+	if !ok {
+		in.fail("unknown function %s (line %d)", n.name, n.line)
+	}
+	if len(n.args) < b.min || b.max >= 0 && len(n.args) > b.max {
+		in.fail("wrong number of arguments to %s (line %d)", n.name, n.line)
+	}
+	return b.fn(in, n.args)
+}
+
+// Argument helpers for built-in functions
+
+// value evaluates an expression to a scalar
+func (in *fcInterp) value(n fcNode) fcValue {
+	return in.scalar(in.eval(n))
+}
+
+// values evaluates an expression, expanding accessors that select several
+// objects into their values
+func (in *fcInterp) values(n fcNode) []fcValue {
+	if a, ok := n.(*fcAccessor); ok {
+		var out []fcValue
+		for _, o := range in.resolve(a) {
+			out = append(out, in.scalar(o))
+		}
+		return out
+	}
+	return []fcValue{in.value(n)}
+}
+
+// allValues expands every argument
+func (in *fcInterp) allValues(args []fcNode) []fcValue {
+	var out []fcValue
+	for _, a := range args {
+		out = append(out, in.values(a)...)
+	}
+	return out
+}
+
+// Values
+
+// scalar returns the value of an object
+func (in *fcInterp) scalar(v fcValue) fcValue {
+	switch o := v.(type) {
+	case nil, float64, string:
+		return v
+	case fcReference:
+		return in.scalar(o.obj)
+	case *XFAFormNode:
+		switch o.Class {
+		case "field", "exclGroup":
+			return fcFieldValue(o)
+		case "draw":
+			if o.Value == "" {
+				return nil
+			}
+			return o.Value
+		}
+		return nil
+	case *xfaNode:
+		if o.isDataGroup() {
+			return nil
+		}
+		if s := o.text(); s != "" {
+			return s
+		}
+		return nil
+	case fcProperty:
+		return in.property(o)
+	case fcInstanceManager:
+		return float64(len(o.instances()))
+	}
+	return nil
+}
+
+// fcFieldValue returns the value of a field or exclGroup: null when empty
+// and a number for numeric fields
+func fcFieldValue(n *XFAFormNode) fcValue {
+	if n.Value == "" {
+		return nil
+	}
+	if fcNumericField(n) {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(n.Value), 64); err == nil {
+			return f
+		}
+	}
+	return n.Value
+}
+
+// fcNumericField reports whether a field holds numbers
+func fcNumericField(n *XFAFormNode) bool {
+	t := n.template
+	if t == nil {
+		return false
+	}
+	if n.Class == "exclGroup" {
+		for _, c := range n.Children {
+			if c.template != nil {
+				t = c.template
+				break
+			}
+		}
+	}
+	if v := t.child("value"); v != nil {
+		for _, c := range v.elements() {
+			switch c.local() {
+			case "integer", "decimal", "float":
+				return true
+			}
+			return false
+		}
+	}
+	_, kind := xfaUI(t)
+	return kind == "numericEdit"
+}
+
+func fcBool(b bool) fcValue {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
+
+var fcNumberPrefix = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?`)
+
+// fcNum converts a scalar to a number. Strings that do not start with a
+// number convert to zero.
+func fcNum(v fcValue) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case string:
+		s := strings.TrimSpace(v)
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+		if m := fcNumberPrefix.FindString(s); m != "" {
+			f, _ := strconv.ParseFloat(m, 64)
+			return f
+		}
+	}
+	return 0
+}
+
+// fcStr converts a scalar to a string; null becomes the empty string
+func fcStr(v fcValue) string {
+	switch v := v.(type) {
+	case float64:
+		return fcFormatNum(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+// fcFormatNum formats a number without the noise of binary fractions
+func fcFormatNum(f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	s := strconv.FormatFloat(f, 'f', 10, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// fcEqual compares two scalars. Null equals only null; two strings
+// compare as text and anything else numerically.
+func fcEqual(l, r fcValue) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	ls, lok := l.(string)
+	rs, rok := r.(string)
+	if lok && rok {
+		return ls == rs
+	}
+	return fcNum(l) == fcNum(r)
+}
+
+// SOM resolution
+
+// fcPlainStep reports whether a step is a bare name
+func fcPlainStep(s fcStep) bool {
+	return !s.class && !s.all && !s.descendant && !s.call && !s.indexAll && s.index == nil
+}
+
+func fcAccessorText(a *fcAccessor) string {
+	var sb strings.Builder
+	for i, s := range a.steps {
+		switch {
+		case s.descendant:
+			sb.WriteString("..")
+		case i > 0:
+			sb.WriteString(".")
+		}
+		if s.class {
+			sb.WriteString("#")
+		}
+		if s.all {
+			sb.WriteString("*")
+		}
+		sb.WriteString(s.name)
+		if s.indexAll {
+			sb.WriteString("[*]")
+		}
+	}
+	return sb.String()
+}
+
+// resolve returns the objects an accessor selects; an accessor selecting
+// nothing is an error
+func (in *fcInterp) resolve(a *fcAccessor) []fcValue {
+	first := a.steps[0]
+	var objs []fcValue
+	if s, ok := in.scope.lookup(first.name); ok && fcPlainStep(first) {
+		v := s.vars[first.name]
+		if r, isRef := v.(fcReference); isRef {
+			v = r.obj
+		}
+		objs = []fcValue{v}
+	} else if first.class {
+		objs = in.search(first)
+	} else {
+		switch first.name {
+		case "$":
+			if in.this != nil {
+				objs = []fcValue{in.this}
+			}
+		case "$form":
+			if in.model != nil {
+				objs = []fcValue{in.model}
+			}
+		case "$record":
+			if in.xfa != nil && in.xfa.Form != nil && in.xfa.Form.data != nil {
+				objs = []fcValue{in.xfa.Form.data}
+			}
+		case "$data":
+			if d := in.dataModel(); d != nil {
+				objs = []fcValue{d}
+			}
+		case "$host":
+			objs = []fcValue{fcHostObject{}}
+		case "$event":
+			objs = []fcValue{fcEventObject{}}
+		case "xfa":
+			objs = []fcValue{fcXFAObject{}}
+		default:
+			objs = in.search(first)
+		}
+	}
+	if len(objs) == 0 {
+		in.fail("accessor %s is unknown (line %d)", fcAccessorText(a), a.line)
+	}
+
+	for _, s := range a.steps[1:] {
+		var next []fcValue
+		for _, o := range objs {
+			next = append(next, in.step(o, s)...)
+		}
+		if len(next) == 0 {
+			in.fail("accessor %s is unknown (line %d)", fcAccessorText(a), a.line)
+		}
+		objs = next
+	}
+	return objs
+}
+
+func (in *fcInterp) dataModel() *xfaNode {
+	if in.xfa == nil || in.xfa.datasetsDOM == nil {
+		return nil
+	}
+	return in.xfa.datasetsDOM.child("data")
+}
+
+// search resolves the first step of an unqualified reference: the name
+// is looked up among the children of the current object, then of each
+// of its ancestors in turn
+func (in *fcInterp) search(step fcStep) []fcValue {
+	if in.model == nil {
+		return nil
+	}
+	start := in.this
+	if start == nil {
+		start = in.xfa.Form
+	}
+	for s := start; s != nil; s = s.Parent {
+		var cands []*XFAFormNode
+		if step.class {
+			cands = fcClassChildren(s, step.name)
+		} else if s.Name == step.name && !s.transparent() {
+			cands = fcSiblings(s)
+		} else {
+			cands = s.namedChildren(step.name)
+		}
+		if len(cands) > 0 {
+			return in.selectIndex(cands, step, true)
+		}
+	}
+	return nil
+}
+
+// fcSiblings returns the instances named like n under its parent
+func fcSiblings(n *XFAFormNode) []*XFAFormNode {
+	p := fcEffectiveParent(n)
+	if p == nil {
+		return []*XFAFormNode{n}
+	}
+	return p.namedChildren(n.Name)
+}
+
+// fcEffectiveParent returns the parent of n as SOM sees it, skipping
+// transparent containers
+func fcEffectiveParent(n *XFAFormNode) *XFAFormNode {
+	p := n.Parent
+	for p != nil && p.transparent() && p.Parent != nil {
+		p = p.Parent
+	}
+	return p
+}
+
+// fcAllChildren returns the children of n, looking through transparent
+// containers
+func fcAllChildren(n *XFAFormNode) []*XFAFormNode {
+	var out []*XFAFormNode
+	for _, c := range n.Children {
+		if c.transparent() {
+			out = append(out, fcAllChildren(c)...)
+		} else {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func fcClassChildren(n *XFAFormNode, class string) []*XFAFormNode {
+	var out []*XFAFormNode
+	for _, c := range fcAllChildren(n) {
+		if c.Class == class {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// isAncestorOrSelf reports whether n contains the current object
+func (in *fcInterp) isAncestorOrSelf(n *XFAFormNode) bool {
+	for p := in.this; p != nil; p = p.Parent {
+		if p == n {
+			return true
+		}
+	}
+	return false
+}
+
+// selectIndex applies the index of a step to same-named candidates. For
+// class steps the index counts position among the candidates. Without an
+// index an unqualified reference prefers the instance containing the
+// current object, and any other reference takes instance 0.
+func (in *fcInterp) selectIndex(cands []*XFAFormNode, step fcStep, unqualified bool) []fcValue {
+	indexOf := func(i int, c *XFAFormNode) int {
+		if step.class {
+			return i
+		}
+		return c.Index
+	}
+	var out []fcValue
+	switch {
+	case step.indexAll:
+		for _, c := range cands {
+			out = append(out, c)
+		}
+		return out
+	case step.index == nil:
+		if unqualified {
+			for _, c := range cands {
+				if in.isAncestorOrSelf(c) {
+					return []fcValue{c}
+				}
+			}
+		}
+		for i, c := range cands {
+			if indexOf(i, c) == 0 {
+				return []fcValue{c}
+			}
+		}
+		return []fcValue{cands[0]}
+	}
+
+	want := int(fcNum(in.value(step.index)))
+	if step.relative {
+		base := 0
+		for i, c := range cands {
+			if in.isAncestorOrSelf(c) {
+				base = indexOf(i, c)
+			}
+		}
+		want += base
+	}
+	for i, c := range cands {
+		if indexOf(i, c) == want {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// fcNodeProperties are the properties of form nodes
+var fcNodeProperties = map[string]bool{
+	"rawValue": true, "formattedValue": true, "presence": true, "name": true,
+	"index": true, "className": true, "isNull": true, "somExpression": true,
+}
+
+// step applies one accessor step to an object
+func (in *fcInterp) step(o fcValue, s fcStep) []fcValue {
+	if s.call {
+		return []fcValue{in.method(o, s)}
+	}
+	switch o := o.(type) {
+	case *XFAFormNode:
+		return in.formStep(o, s)
+	case *xfaNode:
+		return in.dataStep(o, s)
+	case fcXFAObject:
+		switch s.name {
+		case "form":
+			if in.model != nil {
+				return []fcValue{in.model}
+			}
+		case "datasets":
+			if in.xfa != nil && in.xfa.datasetsDOM != nil {
+				return []fcValue{in.xfa.datasetsDOM}
+			}
+		case "record":
+			if in.xfa != nil && in.xfa.Form != nil && in.xfa.Form.data != nil {
+				return []fcValue{in.xfa.Form.data}
+			}
+		case "host":
+			return []fcValue{fcHostObject{}}
+		case "event":
+			return []fcValue{fcEventObject{}}
+		}
+	case fcHostObject:
+		switch s.name {
+		case "name":
+			return []fcValue{"go-poppler"}
+		case "appType":
+			return []fcValue{"Exchange"}
+		case "version":
+			return []fcValue{"1.0"}
+		}
+	case fcEventObject:
+		// No user interface events occur while scripts run in batch
+		return []fcValue{nil}
+	case fcInstanceManager:
+		switch s.name {
+		case "count", "min", "max", "name":
+			return []fcValue{fcProperty{o, s.name}}
+		}
+	}
+	return nil
+}
+
+func (in *fcInterp) formStep(n *XFAFormNode, s fcStep) []fcValue {
+	switch {
+	case s.all:
+		var out []fcValue
+		for _, c := range fcAllChildren(n) {
+			out = append(out, c)
+		}
+		return out
+	case s.descendant:
+		var cands []*XFAFormNode
+		for _, c := range n.Children {
+			c.walk(func(d *XFAFormNode) {
+				if s.class && d.Class == s.name || !s.class && d.Name == s.name {
+					cands = append(cands, d)
+				}
+			})
+		}
+		if len(cands) == 0 {
+			return nil
+		}
+		if s.index == nil && !s.indexAll {
+			return []fcValue{cands[0]}
+		}
+		return in.selectIndex(cands, s, false)
+	case s.class:
+		return in.selectIndex(fcClassChildren(n, s.name), s, false)
+	}
+
+	if cands := n.namedChildren(s.name); len(cands) > 0 {
+		return in.selectIndex(cands, s, false)
+	}
+	if fcNodeProperties[s.name] {
+		return []fcValue{fcProperty{n, s.name}}
+	}
+	switch {
+	case s.name == "instanceManager" && n.Class == "subform":
+		if p := fcEffectiveParent(n); p != nil {
+			return []fcValue{fcInstanceManager{p, n.Name}}
+		}
+	case s.name == "parent":
+		if p := fcEffectiveParent(n); p != nil {
+			return []fcValue{p}
+		}
+	case strings.HasPrefix(s.name, "_") && len(s.name) > 1:
+		return []fcValue{fcInstanceManager{n, s.name[1:]}}
+	}
+	return nil
+}
+
+func (in *fcInterp) dataStep(d *xfaNode, s fcStep) []fcValue {
+	var cands []*xfaNode
+	switch {
+	case s.all:
+		cands = d.elements()
+	case s.descendant:
+		var walk func(n *xfaNode)
+		walk = func(n *xfaNode) {
+			for _, c := range n.elements() {
+				if c.local() == s.name {
+					cands = append(cands, c)
+				}
+				walk(c)
+			}
+		}
+		walk(d)
+	default:
+		for _, c := range d.elements() {
+			if c.local() == s.name {
+				cands = append(cands, c)
+			}
+		}
+	}
+	if len(cands) == 0 {
+		switch s.name {
+		case "value", "name", "isNull":
+			return []fcValue{fcProperty{d, s.name}}
+		}
+		return nil
+	}
+
+	var out []fcValue
+	switch {
+	case s.all || s.indexAll:
+		for _, c := range cands {
+			out = append(out, c)
+		}
+	case s.index == nil:
+		out = []fcValue{cands[0]}
+	default:
+		if i := int(fcNum(in.value(s.index))); i >= 0 && i < len(cands) {
+			out = []fcValue{cands[i]}
+		}
+	}
+	return out
+}
+
+// property returns the value of an object property
+func (in *fcInterp) property(p fcProperty) fcValue {
+	switch o := p.obj.(type) {
+	case *XFAFormNode:
+		switch p.name {
+		case "rawValue":
+			return in.scalar(o)
+		case "formattedValue":
+			if o.Class == "field" && o.template != nil {
+				_, kind := xfaUI(o.template)
+				return xfaFieldDisplay(o, kind)
+			}
+			return o.Value
+		case "presence":
+			if o.template != nil {
+				if v := o.template.attr("presence"); v != "" {
+					return v
+				}
+			}
+			return "visible"
+		case "name":
+			return o.Name
+		case "index":
+			return float64(o.Index)
+		case "className":
+			return o.Class
+		case "isNull":
+			return fcBool(in.scalar(o) == nil)
+		case "somExpression":
+			return "xfa[0].form[0]." + o.SOM()
+		}
+	case *xfaNode:
+		switch p.name {
+		case "value":
+			return o.text()
+		case "name":
+			return o.local()
+		case "isNull":
+			return fcBool(o.text() == "")
+		}
+	case fcInstanceManager:
+		switch p.name {
+		case "count":
+			return float64(len(o.instances()))
+		case "name":
+			return "_" + o.name
+		}
+		if t := o.template(); t != nil {
+			min, max, _ := xfaOccur(t)
+			if p.name == "min" {
+				return float64(min)
+			}
+			return float64(max)
+		}
+	}
+	return nil
+}
+
+func (in *fcInterp) setProperty(p fcProperty, v fcValue) {
+	switch o := p.obj.(type) {
+	case *XFAFormNode:
+		if p.name == "rawValue" {
+			in.setValue(o, v)
+			return
+		}
+	case *xfaNode:
+		if p.name == "value" {
+			in.setDataValue(o, fcStr(v))
+			return
+		}
+	case fcInstanceManager:
+		if p.name == "count" {
+			in.setInstances(o, int(fcNum(v)))
+			return
+		}
+	}
+	in.fail("property %s is read-only", p.name)
+}
+
+// method calls a method of an object
+func (in *fcInterp) method(o fcValue, s fcStep) fcValue {
+	switch o := o.(type) {
+	case *XFAFormNode:
+		switch s.name {
+		case "resolveNode", "resolveNodes":
+			if len(s.args) != 1 {
+				in.fail("%s takes one argument", s.name)
+			}
+			nodes := in.resolveSOM(fcStr(in.value(s.args[0])), o)
+			if len(nodes) == 0 {
+				return nil
+			}
+			return fcReference{nodes[0]}
+		case "isPropertySpecified":
+			return fcBool(len(s.args) == 1 && o.template != nil && o.template.attr(fcStr(in.value(s.args[0]))) != "")
+		}
+	case fcInstanceManager:
+		switch s.name {
+		case "addInstance":
+			return fcReference{in.addInstance(o)}
+		case "removeInstance":
+			if len(s.args) != 1 {
+				in.fail("removeInstance takes one argument")
+			}
+			in.removeInstance(o, int(fcNum(in.value(s.args[0]))))
+			return nil
+		case "setInstances":
+			if len(s.args) != 1 {
+				in.fail("setInstances takes one argument")
+			}
+			in.setInstances(o, int(fcNum(in.value(s.args[0]))))
+			return nil
+		}
+	case fcHostObject:
+		switch s.name {
+		case "messageBox":
+			// There is no user to answer; report OK
+			return 1.0
+		case "beep", "setFocus", "resetData", "recalculate":
+			return nil
+		}
+	}
+	in.fail("unknown method %s", s.name)
+	return nil
+}
+
+// resolveSOM resolves a SOM expression given as a string relative to a
+// form node
+func (in *fcInterp) resolveSOM(som string, this *XFAFormNode) []fcValue {
+	body, err := fcParse(som)
+	if err != nil || len(body) != 1 {
+		in.fail("invalid SOM expression %q", som)
+	}
+	a, ok := body[0].(*fcAccessor)
+	if !ok {
+		in.fail("invalid SOM expression %q", som)
+	}
+	saved := in.this
+	in.this = this
+	defer func() { in.this = saved }()
+	var out []fcValue
+	err = in.protect(func() { out = in.resolve(a) })
+	if err != nil {
+		if errors.Is(err, ErrFormCalcTimeout) || errors.Is(err, ErrFormCalcMemory) {
+			panic(fcFatal{err})
+		}
+		return nil
+	}
+	return out
+}
+
+// Instance management
+
+func (m fcInstanceManager) instances() []*XFAFormNode {
+	var out []*XFAFormNode
+	for _, c := range m.parent.namedChildren(m.name) {
+		if c.Class == "subform" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// template returns the template of the managed subform
+func (m fcInstanceManager) template() *xfaNode {
+	if inst := m.instances(); len(inst) > 0 {
+		return inst[0].template
+	}
+	if m.parent.template == nil {
+		return nil
+	}
+	var find func(t *xfaNode) *xfaNode
+	find = func(t *xfaNode) *xfaNode {
+		for _, c := range t.elements() {
+			switch c.local() {
+			case "subform":
+				if c.attr("name") == m.name {
+					return c
+				}
+			case "subformSet", "area":
+				if f := find(c); f != nil {
+					return f
+				}
+			}
+		}
+		return nil
+	}
+	return find(m.parent.template)
+}
+
+func (in *fcInterp) addInstance(m fcInstanceManager) *XFAFormNode {
+	inst := m.instances()
+	if len(inst) == 0 {
+		in.fail("no instance of %s to copy", m.name)
+	}
+	n, err := in.xfa.AddInstance(inst[len(inst)-1].SOM())
+	if err != nil {
+		panic(fcFatal{err})
+	}
+	return n
+}
+
+func (in *fcInterp) removeInstance(m fcInstanceManager, index int) {
+	inst := m.instances()
+	if index < 0 || index >= len(inst) {
+		in.fail("instance %d of %s does not exist", index, m.name)
+	}
+	if min, _, _ := xfaOccur(inst[0].template); len(inst) <= min {
+		in.fail("cannot remove %s below its minimum of %d instances", m.name, min)
+	}
+	in.xfa.removeInstance(inst[index])
+}
+
+func (in *fcInterp) setInstances(m fcInstanceManager, count int) {
+	for len(m.instances()) < count {
+		in.addInstance(m)
+	}
+	for inst := m.instances(); len(inst) > count; inst = m.instances() {
+		in.removeInstance(m, len(inst)-1)
+	}
+}
+
+// removeInstance removes a subform instance together with its data group
+// and renumbers the remaining instances
+func (xfa *XFAForm) removeInstance(n *XFAFormNode) {
+	parent := n.Parent
+	for i, c := range parent.Children {
+		if c == n {
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			break
+		}
+	}
+	if d := n.data; d != nil && d.parent != nil && d != parent.dataScope() {
+		for i, c := range d.parent.children {
+			if c == d {
+				d.parent.children = append(d.parent.children[:i], d.parent.children[i+1:]...)
+				break
+			}
+		}
+	}
+	if scope := fcEffectiveParent(n); scope != nil {
+		for i, c := range scope.namedChildren(n.Name) {
+			c.Index = i
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package pdf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// fcTokenKind identifies the kind of a FormCalc token
+type fcTokenKind int
+
+const (
+	fcTokEOF fcTokenKind = iota
+	fcTokIdent
+	fcTokKeyword
+	fcTokNumber
+	fcTokString
+	fcTokPunct
+)
+
+// fcToken is a lexical token of a FormCalc script
+type fcToken struct {
+	kind fcTokenKind
+	text string  // identifier, lower-cased keyword, punctuator or string value
+	num  float64 // numeric value
+	line int
+}
+
+// fcKeywords lists the reserved words of FormCalc. Keywords are not case
+// sensitive.
+var fcKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "eq": true, "ne": true, "lt": true,
+	"le": true, "gt": true, "ge": true, "if": true, "then": true, "elseif": true,
+	"else": true, "endif": true, "while": true, "do": true, "endwhile": true,
+	"for": true, "upto": true, "downto": true, "step": true, "endfor": true,
+	"foreach": true, "in": true, "func": true, "endfunc": true, "var": true,
+	"return": true, "break": true, "continue": true, "null": true, "end": true,
+	"throw": true, "infinity": true, "nan": true,
+}
+
+// fcPunctuators lists punctuators, longest first
+var fcPunctuators = []string{
+	"..", ".#", ".*", "==", "<>", "<=", ">=",
+	"<", ">", "=", "+", "-", "*", "/", "&", "|", "!",
+	"(", ")", "[", "]", ",", ".",
+}
+
+// fcSyntaxError is a FormCalc parse error with its line number
+type fcSyntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *fcSyntaxError) Error() string {
+	return fmt.Sprintf("formcalc: syntax error: %s (line %d)", e.msg, e.line)
+}
+
+// fcIdentStart reports whether r may start an identifier. Besides
+// letters, FormCalc names may start with _, $ (as in $record) or ! (as in
+// !variable) and # (as in #subform).
+func fcIdentStart(r rune) bool {
+	return r == '_' || r == '$' || r == '!' || r == '#' || unicode.IsLetter(r)
+}
+
+func fcIdentPart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// fcTokenize splits a FormCalc script into tokens. Comments start with
+// // or ; and run to the end of the line.
+func fcTokenize(src string) ([]fcToken, error) {
+	var tokens []fcToken
+	line := 1
+	i := 0
+	for {
+		// Skip white space and comments
+		for i < len(src) {
+			c := src[i]
+			if c == '\n' {
+				line++
+				i++
+			} else if c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v' {
+				i++
+			} else if c == ';' || c == '/' && i+1 < len(src) && src[i+1] == '/' {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			} else {
+				break
+			}
+		}
+		if i >= len(src) {
+			tokens = append(tokens, fcToken{kind: fcTokEOF, line: line})
+			return tokens, nil
+		}
+
+		c := src[i]
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+				i++
+			}
+			if i < len(src) && src[i] == '.' {
+				i++
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				j := i + 1
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				if j < len(src) && src[j] >= '0' && src[j] <= '9' {
+					i = j
+					for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+						i++
+					}
+				}
+			}
+			v, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, &fcSyntaxError{line, "invalid number " + src[start:i]}
+			}
+			tokens = append(tokens, fcToken{kind: fcTokNumber, num: v, text: src[start:i], line: line})
+
+		case c == '"':
+			// Strings double quotes to escape them and may contain
+			// \uXXXX escapes
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(src) {
+				ch := src[i]
+				if ch == '"' {
+					if i+1 < len(src) && src[i+1] == '"' {
+						sb.WriteByte('"')
+						i += 2
+						continue
+					}
+					i++
+					closed = true
+					break
+				}
+				if ch == '\\' && i+5 < len(src) && src[i+1] == 'u' {
+					if v, err := strconv.ParseUint(src[i+2:i+6], 16, 32); err == nil {
+						sb.WriteRune(rune(v))
+						i += 6
+						continue
+					}
+				}
+				if ch == '\n' {
+					line++
+				}
+				sb.WriteByte(ch)
+				i++
+			}
+			if !closed {
+				return nil, &fcSyntaxError{line, "unterminated string"}
+			}
+			tokens = append(tokens, fcToken{kind: fcTokString, text: sb.String(), line: line})
+
+		case fcIdentStart(r) && !(c == '!' && i+1 < len(src) && src[i+1] == '='):
+			start := i
+			i += size
+			for i < len(src) {
+				r, size := utf8.DecodeRuneInString(src[i:])
+				if !fcIdentPart(r) {
+					break
+				}
+				i += size
+			}
+			word := src[start:i]
+			if lower := strings.ToLower(word); fcKeywords[lower] {
+				tokens = append(tokens, fcToken{kind: fcTokKeyword, text: lower, line: line})
+			} else {
+				tokens = append(tokens, fcToken{kind: fcTokIdent, text: word, line: line})
+			}
+
+		default:
+			matched := false
+			for _, p := range fcPunctuators {
+				if strings.HasPrefix(src[i:], p) {
+					tokens = append(tokens, fcToken{kind: fcTokPunct, text: p, line: line})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &fcSyntaxError{line, fmt.Sprintf("unexpected character %q", r)}
+			}
+		}
+	}
+}
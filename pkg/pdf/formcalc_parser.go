@@ -0,0 +1,472 @@
+package pdf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fcNode is a node of a parsed FormCalc script
+type fcNode interface{}
+
+type fcNumberLit struct{ value float64 }
+type fcStringLit struct{ value string }
+type fcNullLit struct{}
+
+type fcUnary struct {
+	op string // "-", "+" or "not"
+	x  fcNode
+}
+
+type fcBinary struct {
+	op   string // normalised to "|", "&", "==", "<>", "<", "<=", ">", ">=", "+", "-", "*", "/"
+	l, r fcNode
+}
+
+// fcCall calls a built-in or script function
+type fcCall struct {
+	name string
+	args []fcNode
+	line int
+}
+
+// fcStep is one step of an accessor such as "row[*]", "..total" or
+// "#subform[0]". A step with call set invokes a method of the object the
+// preceding steps name.
+type fcStep struct {
+	name       string
+	descendant bool   // reached with ..
+	class      bool   // names a class, as in #subform
+	all        bool   // .* selects all children
+	index      fcNode // nil for the default index
+	indexAll   bool   // [*]
+	relative   bool   // [+n] or [-n]
+	call       bool
+	args       []fcNode
+}
+
+// fcAccessor is a SOM expression or variable reference
+type fcAccessor struct {
+	steps []fcStep
+	line  int
+}
+
+type fcAssign struct {
+	target *fcAccessor
+	value  fcNode
+}
+
+type fcVarDecl struct {
+	name string
+	init fcNode
+}
+
+type fcIf struct {
+	cond fcNode
+	then []fcNode
+	els  []fcNode
+}
+
+type fcWhile struct {
+	cond fcNode
+	body []fcNode
+}
+
+type fcFor struct {
+	name             string
+	start, end, step fcNode
+	down             bool
+	body             []fcNode
+}
+
+type fcForeach struct {
+	name string
+	list []fcNode
+	body []fcNode
+}
+
+type fcFuncDecl struct {
+	name   string
+	params []string
+	body   []fcNode
+}
+
+type fcBlock struct{ body []fcNode }
+type fcReturn struct{ x fcNode }
+type fcBreak struct{}
+type fcContinue struct{}
+
+// fcParser builds the syntax tree of a FormCalc script
+type fcParser struct {
+	tokens []fcToken
+	pos    int
+}
+
+// fcParse parses a FormCalc script into a list of expressions
+func fcParse(src string) (body []fcNode, err error) {
+	tokens, err := fcTokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &fcParser{tokens: tokens}
+	defer func() {
+		if r := recover(); r != nil {
+			if se, ok := r.(*fcSyntaxError); ok {
+				body, err = nil, se
+				return
+			}
+			panic(r)
+		}
+	}()
+	body = p.parseList()
+	if t := p.peek(); t.kind != fcTokEOF {
+		p.fail("unexpected %s", fcDescribe(t))
+	}
+	return body, nil
+}
+
+func fcDescribe(t fcToken) string {
+	switch t.kind {
+	case fcTokEOF:
+		return "end of script"
+	case fcTokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func (p *fcParser) peek() fcToken {
+	return p.tokens[p.pos]
+}
+
+func (p *fcParser) next() fcToken {
+	t := p.tokens[p.pos]
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *fcParser) fail(format string, args ...interface{}) {
+	panic(&fcSyntaxError{p.peek().line, fmt.Sprintf(format, args...)})
+}
+
+// is reports whether the next token is the given punctuator or keyword
+func (p *fcParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == fcTokPunct || t.kind == fcTokKeyword) && t.text == text
+}
+
+func (p *fcParser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *fcParser) expect(text string) {
+	if !p.accept(text) {
+		p.fail("expected %q, found %s", text, fcDescribe(p.peek()))
+	}
+}
+
+func (p *fcParser) ident() string {
+	t := p.next()
+	if t.kind != fcTokIdent {
+		panic(&fcSyntaxError{t.line, "expected a name, found " + fcDescribe(t)})
+	}
+	return t.text
+}
+
+// fcListEnd are the keywords ending a list of expressions
+var fcListEnd = map[string]bool{
+	"endif": true, "elseif": true, "else": true, "endwhile": true,
+	"endfor": true, "endfunc": true, "end": true,
+}
+
+// parseList parses expressions up to a keyword ending the list
+func (p *fcParser) parseList() []fcNode {
+	var list []fcNode
+	for {
+		t := p.peek()
+		if t.kind == fcTokEOF || t.kind == fcTokKeyword && fcListEnd[t.text] {
+			return list
+		}
+		list = append(list, p.parseStatement())
+	}
+}
+
+func (p *fcParser) parseStatement() fcNode {
+	t := p.peek()
+	if t.kind == fcTokKeyword {
+		switch t.text {
+		case "if":
+			p.next()
+			return p.parseIf()
+		case "while":
+			p.next()
+			p.expect("(")
+			cond := p.parseExpr()
+			p.expect(")")
+			p.expect("do")
+			body := p.parseList()
+			p.expect("endwhile")
+			return &fcWhile{cond: cond, body: body}
+		case "for":
+			p.next()
+			p.accept("var")
+			n := &fcFor{name: p.ident()}
+			p.expect("=")
+			n.start = p.parseExpr()
+			if p.accept("downto") {
+				n.down = true
+			} else {
+				p.expect("upto")
+			}
+			n.end = p.parseExpr()
+			if p.accept("step") {
+				n.step = p.parseExpr()
+			}
+			p.expect("do")
+			n.body = p.parseList()
+			p.expect("endfor")
+			return n
+		case "foreach":
+			p.next()
+			p.accept("var")
+			n := &fcForeach{name: p.ident()}
+			p.expect("in")
+			p.expect("(")
+			if !p.is(")") {
+				n.list = append(n.list, p.parseExpr())
+				for p.accept(",") {
+					n.list = append(n.list, p.parseExpr())
+				}
+			}
+			p.expect(")")
+			p.expect("do")
+			n.body = p.parseList()
+			p.expect("endfor")
+			return n
+		case "func":
+			p.next()
+			n := &fcFuncDecl{name: p.ident()}
+			p.expect("(")
+			if !p.is(")") {
+				n.params = append(n.params, p.ident())
+				for p.accept(",") {
+					n.params = append(n.params, p.ident())
+				}
+			}
+			p.expect(")")
+			p.expect("do")
+			n.body = p.parseList()
+			p.expect("endfunc")
+			return n
+		case "var":
+			p.next()
+			n := &fcVarDecl{name: p.ident()}
+			if p.accept("=") {
+				n.init = p.parseExpr()
+			}
+			return n
+		case "do":
+			p.next()
+			body := p.parseList()
+			p.expect("end")
+			return &fcBlock{body: body}
+		case "return":
+			p.next()
+			n := &fcReturn{}
+			if next := p.peek(); next.kind != fcTokEOF && !(next.kind == fcTokKeyword && fcListEnd[next.text]) {
+				n.x = p.parseExpr()
+			}
+			return n
+		case "break":
+			p.next()
+			return &fcBreak{}
+		case "continue":
+			p.next()
+			return &fcContinue{}
+		}
+	}
+
+	x := p.parseExpr()
+	if p.accept("=") {
+		target, ok := x.(*fcAccessor)
+		if !ok {
+			p.fail("invalid assignment target")
+		}
+		return &fcAssign{target: target, value: p.parseExpr()}
+	}
+	return x
+}
+
+func (p *fcParser) parseIf() fcNode {
+	p.expect("(")
+	n := &fcIf{cond: p.parseExpr()}
+	p.expect(")")
+	p.expect("then")
+	n.then = p.parseList()
+	switch {
+	case p.accept("elseif"):
+		n.els = []fcNode{p.parseIf()}
+		return n
+	case p.accept("else"):
+		n.els = p.parseList()
+	}
+	p.expect("endif")
+	return n
+}
+
+// fcBinaryLevels lists the binary operators by increasing precedence,
+// with their keyword spellings mapped to the symbols
+var fcBinaryLevels = []map[string]string{
+	{"|": "|", "or": "|"},
+	{"&": "&", "and": "&"},
+	{"==": "==", "<>": "<>", "eq": "==", "ne": "<>"},
+	{"<": "<", "<=": "<=", ">": ">", ">=": ">=", "lt": "<", "le": "<=", "gt": ">", "ge": ">="},
+	{"+": "+", "-": "-"},
+	{"*": "*", "/": "/"},
+}
+
+func (p *fcParser) parseExpr() fcNode {
+	return p.parseBinary(0)
+}
+
+func (p *fcParser) parseBinary(level int) fcNode {
+	if level == len(fcBinaryLevels) {
+		return p.parseUnary()
+	}
+	l := p.parseBinary(level + 1)
+	for {
+		t := p.peek()
+		if t.kind != fcTokPunct && t.kind != fcTokKeyword {
+			return l
+		}
+		op, ok := fcBinaryLevels[level][t.text]
+		if !ok {
+			return l
+		}
+		p.next()
+		l = &fcBinary{op: op, l: l, r: p.parseBinary(level + 1)}
+	}
+}
+
+func (p *fcParser) parseUnary() fcNode {
+	switch {
+	case p.accept("-"):
+		return &fcUnary{op: "-", x: p.parseUnary()}
+	case p.accept("+"):
+		return &fcUnary{op: "+", x: p.parseUnary()}
+	case p.accept("not"):
+		return &fcUnary{op: "not", x: p.parseUnary()}
+	}
+	return p.parsePrimary()
+}
+
+func (p *fcParser) parsePrimary() fcNode {
+	t := p.peek()
+	switch t.kind {
+	case fcTokNumber:
+		p.next()
+		return &fcNumberLit{value: t.num}
+	case fcTokString:
+		p.next()
+		return &fcStringLit{value: t.text}
+	case fcTokKeyword:
+		if t.text == "null" {
+			p.next()
+			return &fcNullLit{}
+		}
+	case fcTokPunct:
+		if t.text == "(" {
+			p.next()
+			x := p.parseExpr()
+			p.expect(")")
+			return x
+		}
+	case fcTokIdent:
+		p.next()
+		if p.is("(") {
+			p.next()
+			return &fcCall{name: t.text, args: p.parseArgs(), line: t.line}
+		}
+		return p.parseAccessor(t)
+	}
+	p.fail("unexpected %s", fcDescribe(t))
+	return nil
+}
+
+// parseArgs parses call arguments after the opening parenthesis
+func (p *fcParser) parseArgs() []fcNode {
+	var args []fcNode
+	if !p.accept(")") {
+		args = append(args, p.parseExpr())
+		for p.accept(",") {
+			args = append(args, p.parseExpr())
+		}
+		p.expect(")")
+	}
+	return args
+}
+
+// parseAccessor parses the rest of an accessor starting with name
+func (p *fcParser) parseAccessor(first fcToken) fcNode {
+	a := &fcAccessor{line: first.line}
+	step := fcStep{name: first.text}
+	if strings.HasPrefix(step.name, "#") {
+		step.class, step.name = true, step.name[1:]
+	}
+	p.parseIndex(&step)
+	a.steps = append(a.steps, step)
+
+	for {
+		var step fcStep
+		switch {
+		case p.accept("."):
+			if p.accept("*") {
+				step.all = true
+			} else {
+				step.name = p.ident()
+			}
+		case p.accept(".."):
+			step.descendant = true
+			step.name = p.ident()
+		case p.accept(".#"):
+			step.class = true
+			step.name = p.ident()
+		case p.accept(".*"):
+			step.all = true
+		default:
+			return a
+		}
+		if strings.HasPrefix(step.name, "#") {
+			step.class, step.name = true, step.name[1:]
+		}
+		if p.accept("(") {
+			step.call = true
+			step.args = p.parseArgs()
+		} else {
+			p.parseIndex(&step)
+		}
+		a.steps = append(a.steps, step)
+	}
+}
+
+// parseIndex parses an optional [index] after a step
+func (p *fcParser) parseIndex(step *fcStep) {
+	if !p.accept("[") {
+		return
+	}
+	switch {
+	case p.accept("*"):
+		step.indexAll = true
+	case p.is("+") || p.is("-"):
+		step.relative = true
+		step.index = p.parseExpr()
+	default:
+		step.index = p.parseExpr()
+	}
+	p.expect("]")
+}
@@ -0,0 +1,955 @@
+package pdf
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FormCalc dates are day numbers counted from the epoch, January 1 1900
+// being day 1. Times are milliseconds since midnight GMT plus one, so that
+// midnight is 1 and 0 means an invalid time. Local times are taken as GMT:
+// form data is processed on servers whose own time zone is irrelevant.
+var fcEpoch = time.Date(1899, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Default picture clauses of the en_US locale by style: default, short,
+// medium, long and full
+var (
+	fcDatePictures = []string{"MMM D, YYYY", "M/D/YY", "MMM D, YYYY", "MMMM D, YYYY", "EEEE, MMMM D, YYYY"}
+	fcTimePictures = []string{"h:MM:SS A", "h:MM A", "h:MM:SS A", "h:MM:SS A Z", "h:MM:SS A Z"}
+)
+
+var fcMonthNames = []string{"January", "February", "March", "April", "May", "June", "July",
+	"August", "September", "October", "November", "December"}
+
+var fcDayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+// fcDayNumber returns the day number of a date
+func fcDayNumber(t time.Time) float64 {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return math.Round(d.Sub(fcEpoch).Hours() / 24)
+}
+
+func fcDateOf(n float64) time.Time {
+	return fcEpoch.AddDate(0, 0, int(n))
+}
+
+// fcPictureToken is a run of a picture symbol or a literal
+type fcPictureToken struct {
+	symbol  byte // 0 for literals
+	count   int
+	literal string
+}
+
+// fcPictureSymbols are the letters that are symbols in date, time and
+// numeric pictures
+const fcPictureSymbols = "DJMEYwWhHkKSFAZz"
+
+// fcTokenizePicture splits a date or time picture into symbol runs and
+// literals. Text in single quotes is literal; ” is a quote.
+func fcTokenizePicture(p string) []fcPictureToken {
+	var out []fcPictureToken
+	for i := 0; i < len(p); {
+		c := p[i]
+		switch {
+		case c == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(p) {
+				if p[j] == '\'' {
+					if j+1 < len(p) && p[j+1] == '\'' {
+						sb.WriteByte('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteByte(p[j])
+				j++
+			}
+			out = append(out, fcPictureToken{literal: sb.String()})
+			i = j + 1
+		case strings.IndexByte(fcPictureSymbols, c) >= 0:
+			j := i
+			for j < len(p) && p[j] == c {
+				j++
+			}
+			out = append(out, fcPictureToken{symbol: c, count: j - i})
+			i = j
+		default:
+			out = append(out, fcPictureToken{literal: string(c)})
+			i++
+		}
+	}
+	return out
+}
+
+func fcPad(n, width int) string {
+	s := strconv.Itoa(n)
+	for len(s) < width {
+		s = "0" + s
+	}
+	return s
+}
+
+// fcFormatDateTime formats a time with a date picture or a time picture;
+// M means month in dates and minute in times
+func fcFormatDateTime(t time.Time, picture string, isTime bool) string {
+	var sb strings.Builder
+	for _, tok := range fcTokenizePicture(picture) {
+		n := tok.count
+		switch tok.symbol {
+		case 0:
+			sb.WriteString(tok.literal)
+		case 'D':
+			sb.WriteString(fcPad(t.Day(), n))
+		case 'J':
+			sb.WriteString(fcPad(t.YearDay(), n))
+		case 'M':
+			switch {
+			case isTime:
+				sb.WriteString(fcPad(t.Minute(), n))
+			case n <= 2:
+				sb.WriteString(fcPad(int(t.Month()), n))
+			case n == 3:
+				sb.WriteString(fcMonthNames[t.Month()-1][:3])
+			default:
+				sb.WriteString(fcMonthNames[t.Month()-1])
+			}
+		case 'E':
+			switch {
+			case n == 1:
+				sb.WriteString(strconv.Itoa(int(t.Weekday()) + 1))
+			case n == 3:
+				sb.WriteString(fcDayNames[t.Weekday()][:3])
+			default:
+				sb.WriteString(fcDayNames[t.Weekday()])
+			}
+		case 'Y':
+			if n == 2 {
+				sb.WriteString(fcPad(t.Year()%100, 2))
+			} else {
+				sb.WriteString(fcPad(t.Year(), 4))
+			}
+		case 'w':
+			sb.WriteString(strconv.Itoa((t.Day()+int(t.AddDate(0, 0, 1-t.Day()).Weekday())-1)/7 + 1))
+		case 'W':
+			_, week := t.ISOWeek()
+			sb.WriteString(fcPad(week, n))
+		case 'h':
+			h := t.Hour() % 12
+			if h == 0 {
+				h = 12
+			}
+			sb.WriteString(fcPad(h, n))
+		case 'K':
+			sb.WriteString(fcPad(t.Hour()%12, n))
+		case 'H':
+			sb.WriteString(fcPad(t.Hour(), n))
+		case 'k':
+			h := t.Hour()
+			if h == 0 {
+				h = 24
+			}
+			sb.WriteString(fcPad(h, n))
+		case 'S':
+			sb.WriteString(fcPad(t.Second(), n))
+		case 'F':
+			sb.WriteString(fcPad(t.Nanosecond()/1e6, 3))
+		case 'A':
+			if t.Hour() < 12 {
+				sb.WriteString("AM")
+			} else {
+				sb.WriteString("PM")
+			}
+		case 'Z':
+			sb.WriteString("GMT")
+		case 'z':
+			sb.WriteString("Z")
+		default:
+			sb.WriteString(strings.Repeat(string(tok.symbol), n))
+		}
+	}
+	return sb.String()
+}
+
+// fcPictureScanner reads a value according to a date or time picture
+type fcPictureScanner struct {
+	s   string
+	pos int
+	ok  bool
+}
+
+// digits reads between min and max digits
+func (sc *fcPictureScanner) digits(min, max int) int {
+	start := sc.pos
+	for sc.pos < len(sc.s) && sc.pos-start < max && sc.s[sc.pos] >= '0' && sc.s[sc.pos] <= '9' {
+		sc.pos++
+	}
+	if sc.pos-start < min {
+		sc.ok = false
+		return 0
+	}
+	n, _ := strconv.Atoi(sc.s[start:sc.pos])
+	return n
+}
+
+// word reads one of the names, case insensitively, returning its index
+func (sc *fcPictureScanner) word(names []string, length int) int {
+	rest := strings.ToLower(sc.s[sc.pos:])
+	for i, name := range names {
+		if length > 0 {
+			name = name[:length]
+		}
+		if strings.HasPrefix(rest, strings.ToLower(name)) {
+			sc.pos += len(name)
+			return i
+		}
+	}
+	sc.ok = false
+	return 0
+}
+
+func (sc *fcPictureScanner) literal(lit string) {
+	if strings.TrimSpace(lit) == "" {
+		// White space in the picture matches any run of white space
+		for sc.pos < len(sc.s) && unicode.IsSpace(rune(sc.s[sc.pos])) {
+			sc.pos++
+		}
+		return
+	}
+	if !strings.HasPrefix(strings.ToLower(sc.s[sc.pos:]), strings.ToLower(lit)) {
+		sc.ok = false
+		return
+	}
+	sc.pos += len(lit)
+}
+
+// fcParseDateTime parses a value with a date or time picture
+func fcParseDateTime(s, picture string, isTime bool) (time.Time, bool) {
+	sc := &fcPictureScanner{s: strings.TrimSpace(s), ok: true}
+	year, month, day, yday := 1900, 1, 1, 0
+	if isTime {
+		year = 1970
+	}
+	hour, minute, second, milli := 0, 0, 0, 0
+	pm, ampm := false, false
+	offset := 0
+
+	for _, tok := range fcTokenizePicture(picture) {
+		if !sc.ok {
+			return time.Time{}, false
+		}
+		n := tok.count
+		fixed := func() int {
+			if n >= 2 {
+				return sc.digits(n, n)
+			}
+			return sc.digits(1, 2)
+		}
+		switch tok.symbol {
+		case 0:
+			sc.literal(tok.literal)
+		case 'D':
+			day = fixed()
+		case 'J':
+			if n == 3 {
+				yday = sc.digits(3, 3)
+			} else {
+				yday = sc.digits(1, 3)
+			}
+		case 'M':
+			switch {
+			case isTime:
+				minute = fixed()
+			case n <= 2:
+				month = fixed()
+			case n == 3:
+				month = sc.word(fcMonthNames, 3) + 1
+			default:
+				month = sc.word(fcMonthNames, 0) + 1
+			}
+		case 'E':
+			switch {
+			case n == 1:
+				sc.digits(1, 1)
+			case n == 3:
+				sc.word(fcDayNames, 3)
+			default:
+				sc.word(fcDayNames, 0)
+			}
+		case 'Y':
+			if n == 2 {
+				year = sc.digits(2, 2)
+				// Two-digit years below 30 are in the 21st century
+				if year < 30 {
+					year += 2000
+				} else {
+					year += 1900
+				}
+			} else {
+				year = sc.digits(4, 4)
+			}
+		case 'h', 'K', 'H', 'k':
+			hour = fixed()
+			switch tok.symbol {
+			case 'h':
+				if hour < 1 || hour > 12 {
+					return time.Time{}, false
+				}
+				hour %= 12
+			case 'k':
+				hour %= 24
+			}
+		case 'S':
+			second = fixed()
+		case 'F':
+			milli = sc.digits(3, 3)
+		case 'A':
+			i := sc.word([]string{"AM", "PM"}, 0)
+			ampm, pm = true, i == 1
+		case 'Z', 'z':
+			rest := sc.s[sc.pos:]
+			switch {
+			case strings.HasPrefix(strings.ToUpper(rest), "GMT"):
+				sc.pos += 3
+				rest = sc.s[sc.pos:]
+				if rest == "" || rest[0] != '+' && rest[0] != '-' {
+					break
+				}
+				fallthrough
+			case rest != "" && (rest[0] == '+' || rest[0] == '-'):
+				sign := 1
+				if sc.s[sc.pos] == '-' {
+					sign = -1
+				}
+				sc.pos++
+				h := sc.digits(2, 2)
+				m := 0
+				if sc.pos < len(sc.s) && sc.s[sc.pos] == ':' {
+					sc.pos++
+					m = sc.digits(2, 2)
+				}
+				offset = sign * (h*3600 + m*60)
+			case strings.HasPrefix(rest, "Z"):
+				sc.pos++
+			}
+		default:
+			return time.Time{}, false
+		}
+	}
+	if !sc.ok || sc.pos != len(sc.s) {
+		return time.Time{}, false
+	}
+	if ampm && pm {
+		hour += 12
+	}
+	if minute > 59 || second > 59 || hour > 23 {
+		return time.Time{}, false
+	}
+	var t time.Time
+	if yday > 0 {
+		t = time.Date(year, 1, yday, hour, minute, second, milli*1e6, time.UTC)
+		if t.Year() != year {
+			return time.Time{}, false
+		}
+	} else {
+		t = time.Date(year, time.Month(month), day, hour, minute, second, milli*1e6, time.UTC)
+		if t.Day() != day || int(t.Month()) != month {
+			return time.Time{}, false
+		}
+	}
+	return t.Add(-time.Duration(offset) * time.Second), true
+}
+
+// fcParseISODate parses YYYY[-MM[-DD]] or YYYYMMDD, ignoring a time part
+func fcParseISODate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, 'T'); i >= 0 {
+		s = s[:i]
+	}
+	for _, layout := range []string{"2006-01-02", "20060102", "2006-01", "200601", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// fcParseISOTime parses HH[:MM[:SS[.FFF]]] with an optional time zone,
+// optionally preceded by a date and T. It returns milliseconds since
+// midnight GMT.
+func fcParseISOTime(s string) (int64, bool) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, 'T'); i >= 0 {
+		s = s[i+1:]
+	}
+	offset := 0
+	if strings.HasSuffix(s, "Z") {
+		s = s[:len(s)-1]
+	} else if i := strings.LastIndexAny(s, "+-"); i > 0 {
+		sign := s[i]
+		zone := strings.ReplaceAll(s[i+1:], ":", "")
+		s = s[:i]
+		if len(zone) != 2 && len(zone) != 4 {
+			return 0, false
+		}
+		h, err1 := strconv.Atoi(zone[:2])
+		m := 0
+		var err2 error
+		if len(zone) == 4 {
+			m, err2 = strconv.Atoi(zone[2:])
+		}
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		offset = h*3600 + m*60
+		if sign == '-' {
+			offset = -offset
+		}
+	}
+	s = strings.ReplaceAll(s, ":", "")
+	var milli int
+	if i := strings.IndexAny(s, ".,"); i >= 0 {
+		frac := (s[i+1:] + "000")[:3]
+		v, err := strconv.Atoi(frac)
+		if err != nil {
+			return 0, false
+		}
+		milli = v
+		s = s[:i]
+	}
+	if len(s) != 2 && len(s) != 4 && len(s) != 6 {
+		return 0, false
+	}
+	parts := []int{0, 0, 0}
+	for i := 0; i < len(s); i += 2 {
+		v, err := strconv.Atoi(s[i : i+2])
+		if err != nil {
+			return 0, false
+		}
+		parts[i/2] = v
+	}
+	if parts[0] > 23 || parts[1] > 59 || parts[2] > 59 {
+		return 0, false
+	}
+	ms := int64(parts[0]*3600+parts[1]*60+parts[2]-offset)*1000 + int64(milli)
+	ms = (ms%86400000 + 86400000) % 86400000
+	return ms, true
+}
+
+// fcPictureArg returns the picture argument i of a date or time function,
+// which may also be a style number
+func (in *fcInterp) fcPictureArg(args []fcNode, i int, pictures []string) string {
+	if i >= len(args) {
+		return pictures[0]
+	}
+	v := in.value(args[i])
+	if v == nil {
+		return pictures[0]
+	}
+	return fcStr(v)
+}
+
+func fcStyle(in *fcInterp, args []fcNode, pictures []string) fcValue {
+	n := int(in.optNum(args, 0, 0))
+	if n < 0 || n >= len(pictures) {
+		return ""
+	}
+	return pictures[n]
+}
+
+func fcDateFmt(in *fcInterp, args []fcNode) fcValue {
+	return fcStyle(in, args, fcDatePictures)
+}
+
+func fcTimeFmt(in *fcInterp, args []fcNode) fcValue {
+	return fcStyle(in, args, fcTimePictures)
+}
+
+func fcDate(in *fcInterp, args []fcNode) fcValue {
+	return fcDayNumber(time.Now().UTC())
+}
+
+func fcTime(in *fcInterp, args []fcNode) fcValue {
+	return float64(time.Now().UnixMilli())
+}
+
+func fcDate2Num(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	t, ok := fcParseDateTime(s, in.fcPictureArg(args, 1, fcDatePictures), false)
+	if !ok {
+		return 0.0
+	}
+	if n := fcDayNumber(t); n >= 1 {
+		return n
+	}
+	return 0.0
+}
+
+func fcNum2Date(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	n := fcNum(v)
+	if n < 1 {
+		return ""
+	}
+	return fcFormatDateTime(fcDateOf(n), in.fcPictureArg(args, 1, fcDatePictures), false)
+}
+
+func fcIsoDate2Num(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	t, ok := fcParseISODate(s)
+	if !ok {
+		return 0.0
+	}
+	return fcDayNumber(t)
+}
+
+func fcIsoTime2Num(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	ms, ok := fcParseISOTime(s)
+	if !ok {
+		return 0.0
+	}
+	return float64(ms + 1)
+}
+
+func fcTime2Num(in *fcInterp, args []fcNode) fcValue {
+	s, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	t, ok := fcParseDateTime(s, in.fcPictureArg(args, 1, fcTimePictures), true)
+	if !ok {
+		return 0.0
+	}
+	ms := (t.UnixMilli()%86400000 + 86400000) % 86400000
+	return float64(ms + 1)
+}
+
+func fcNum2Time(in *fcInterp, args []fcNode) fcValue {
+	v := in.value(args[0])
+	if v == nil {
+		return nil
+	}
+	n := fcNum(v)
+	if n < 1 {
+		return ""
+	}
+	t := time.UnixMilli(int64(n) - 1).UTC()
+	return fcFormatDateTime(t, in.fcPictureArg(args, 1, fcTimePictures), true)
+}
+
+// Picture clauses for Format and Parse
+
+// fcPictureCategory splits a picture such as "num{z,zz9.99}" into its
+// category and body. Pictures without a category are classified by their
+// symbols.
+func fcPictureCategory(p string) (string, string) {
+	if i := strings.IndexByte(p, '{'); i > 0 && strings.HasSuffix(p, "}") {
+		cat := strings.ToLower(strings.TrimSpace(p[:i]))
+		switch cat {
+		case "date", "time", "datetime", "num", "text", "null", "zero":
+			return cat, p[i+1 : len(p)-1]
+		}
+	}
+	body := p
+	var letters strings.Builder
+	quoted := false
+	for i := 0; i < len(body); i++ {
+		if body[i] == '\'' {
+			quoted = !quoted
+		} else if !quoted {
+			letters.WriteByte(body[i])
+		}
+	}
+	l := letters.String()
+	switch {
+	case strings.ContainsAny(l, "YDJE"):
+		return "date", body
+	case strings.ContainsAny(l, "hHkKSA"):
+		return "time", body
+	case strings.ContainsAny(l, "9zZ") && !strings.ContainsAny(l, "AXO"):
+		return "num", body
+	}
+	return "text", body
+}
+
+// fcSplitPicture splits alternative pictures separated by | outside of
+// quotes and braces
+func fcSplitPicture(p string) []string {
+	var out []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		case c == '|' && depth == 0:
+			out = append(out, p[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, p[start:])
+}
+
+// fcFormatPicture formats a canonical value with a picture clause.
+// Canonical values are numbers, YYYY-MM-DD dates and HH:MM:SS times.
+func fcFormatPicture(picture, value string) (string, bool) {
+	for _, alt := range fcSplitPicture(picture) {
+		cat, body := fcPictureCategory(alt)
+		switch cat {
+		case "null":
+			if value == "" {
+				return body, true
+			}
+		case "zero":
+			if fcIsNumber(value) && fcNum(value) == 0 {
+				return body, true
+			}
+		case "date":
+			if t, ok := fcParseISODate(value); ok {
+				return fcFormatDateTime(t, body, false), true
+			}
+		case "time":
+			if ms, ok := fcParseISOTime(value); ok {
+				return fcFormatDateTime(time.UnixMilli(ms).UTC(), body, true), true
+			}
+		case "datetime":
+			if i := strings.IndexByte(value, 'T'); i > 0 {
+				d, ok1 := fcParseISODate(value[:i])
+				ms, ok2 := fcParseISOTime(value[i:])
+				if j := strings.IndexByte(body, 'T'); ok1 && ok2 && j >= 0 {
+					t := d.Add(time.Duration(ms) * time.Millisecond)
+					return fcFormatDateTime(t, body[:j], false) + fcFormatDateTime(t, body[j+1:], true), true
+				}
+			}
+		case "num":
+			if fcIsNumber(value) {
+				if s, ok := fcFormatNumber(fcNum(value), body); ok {
+					return s, true
+				}
+			}
+		case "text":
+			if s, ok := fcFormatText(value, body); ok {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+// fcParsePicture parses a formatted value with a picture clause into its
+// canonical form
+func fcParsePicture(picture, value string) (string, bool) {
+	for _, alt := range fcSplitPicture(picture) {
+		cat, body := fcPictureCategory(alt)
+		switch cat {
+		case "null":
+			if value == body {
+				return "", true
+			}
+		case "zero":
+			if value == body {
+				return "0", true
+			}
+		case "date":
+			if t, ok := fcParseDateTime(value, body, false); ok {
+				return t.Format("2006-01-02"), true
+			}
+		case "time":
+			if t, ok := fcParseDateTime(value, body, true); ok {
+				return t.Format("15:04:05"), true
+			}
+		case "num":
+			if v, ok := fcParseNumber(value, body); ok {
+				return fcFormatNum(v), true
+			}
+		case "text":
+			if s, ok := fcParseText(value, body); ok {
+				return s, true
+			}
+		}
+	}
+	return "", false
+}
+
+// fcIsDigitSymbol reports whether c is a digit placeholder of a numeric
+// picture: 9 shows a digit or 0, z a digit or nothing and Z a digit or a
+// space
+func fcIsDigitSymbol(c byte) bool {
+	return c == '9' || c == 'z' || c == 'Z'
+}
+
+// fcFormatNumber formats a number with a numeric picture
+func fcFormatNumber(v float64, picture string) (string, bool) {
+	intPic, fracPic := picture, ""
+	if i := strings.IndexAny(picture, ".vV"); i >= 0 {
+		intPic, fracPic = picture[:i+1], picture[i+1:]
+	}
+	fracDigits := 0
+	for i := 0; i < len(fracPic); i++ {
+		if fcIsDigitSymbol(fracPic[i]) {
+			fracDigits++
+		}
+	}
+	intSlots := 0
+	for i := 0; i < len(intPic); i++ {
+		if fcIsDigitSymbol(intPic[i]) {
+			intSlots++
+		}
+	}
+	if strings.Contains(picture, "%") {
+		v *= 100
+	}
+	neg := v < 0
+	digits := strconv.FormatFloat(fcRoundTo(math.Abs(v), fracDigits), 'f', fracDigits, 64)
+	intDigits, fracStr := digits, ""
+	if fracDigits > 0 {
+		intDigits, fracStr = digits[:len(digits)-fracDigits-1], digits[len(digits)-fracDigits:]
+	}
+	if intDigits == "0" {
+		intDigits = ""
+	}
+	if len(intDigits) > intSlots {
+		return "", false
+	}
+
+	var sb strings.Builder
+	signed := false
+	pad := intSlots - len(intDigits)
+	slot, emitted := 0, false
+	for i := 0; i < len(intPic); i++ {
+		c := intPic[i]
+		switch {
+		case fcIsDigitSymbol(c):
+			if slot >= pad {
+				sb.WriteByte(intDigits[slot-pad])
+				emitted = true
+			} else if c == '9' {
+				sb.WriteByte('0')
+				emitted = true
+			} else if c == 'Z' {
+				sb.WriteByte(' ')
+			}
+			slot++
+		case c == ',':
+			if emitted {
+				sb.WriteByte(',')
+			}
+		case c == '.':
+			sb.WriteByte('.')
+		case c == 'v' || c == 'V':
+		case c == 's':
+			signed = true
+			if neg {
+				sb.WriteByte('-')
+			}
+		case c == 'S':
+			signed = true
+			if neg {
+				sb.WriteByte('-')
+			} else {
+				sb.WriteByte(' ')
+			}
+		case c == '(' || c == ')':
+			signed = true
+			if neg {
+				sb.WriteByte(c)
+			} else {
+				sb.WriteByte(' ')
+			}
+		case c == '\'':
+			j := strings.IndexByte(intPic[i+1:], '\'')
+			if j < 0 {
+				j = len(intPic) - i - 1
+			}
+			sb.WriteString(intPic[i+1 : i+1+j])
+			i += j + 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	for i, d := 0, 0; i < len(fracPic); i++ {
+		c := fracPic[i]
+		switch {
+		case fcIsDigitSymbol(c):
+			digit := fracStr[d]
+			d++
+			if c != '9' && strings.Trim(fracStr[d-1:], "0") == "" {
+				if c == 'Z' {
+					sb.WriteByte(' ')
+				}
+				continue
+			}
+			sb.WriteByte(digit)
+		case c == 's' || c == 'S':
+			signed = true
+			if neg {
+				sb.WriteByte('-')
+			} else if c == 'S' {
+				sb.WriteByte(' ')
+			}
+		case c == '(' || c == ')':
+			signed = true
+			if neg {
+				sb.WriteByte(c)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	out := sb.String()
+	if neg && !signed {
+		out = "-" + out
+	}
+	return out, true
+}
+
+// fcParseNumber reads a number formatted with a numeric picture
+func fcParseNumber(s, picture string) (float64, bool) {
+	neg := strings.Contains(s, "-") || strings.Contains(s, "(")
+	var digits strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' || r == '.' {
+			digits.WriteRune(r)
+		}
+	}
+	v, err := strconv.ParseFloat(digits.String(), 64)
+	if err != nil {
+		return 0, false
+	}
+	if strings.Contains(picture, "%") {
+		v /= 100
+	}
+	if neg {
+		v = -v
+	}
+	return v, true
+}
+
+// fcTextSymbol reports whether r matches a text picture symbol: 9 a digit,
+// A a letter, O or 0 a letter or digit and X any character
+func fcTextSymbol(sym byte, r rune) bool {
+	switch sym {
+	case '9':
+		return unicode.IsDigit(r)
+	case 'A':
+		return unicode.IsLetter(r)
+	case 'O', '0':
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	case 'X':
+		return true
+	}
+	return false
+}
+
+func fcFormatText(value, picture string) (string, bool) {
+	in := []rune(value)
+	var sb strings.Builder
+	for i := 0; i < len(picture); i++ {
+		c := picture[i]
+		switch c {
+		case '9', 'A', 'O', '0', 'X':
+			if len(in) == 0 || !fcTextSymbol(c, in[0]) {
+				return "", false
+			}
+			sb.WriteRune(in[0])
+			in = in[1:]
+		case '\'':
+			j := strings.IndexByte(picture[i+1:], '\'')
+			if j < 0 {
+				j = len(picture) - i - 1
+			}
+			sb.WriteString(picture[i+1 : i+1+j])
+			i += j + 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	if len(in) > 0 {
+		return "", false
+	}
+	return sb.String(), true
+}
+
+func fcParseText(value, picture string) (string, bool) {
+	in := []rune(value)
+	var sb strings.Builder
+	for i := 0; i < len(picture); i++ {
+		c := picture[i]
+		switch c {
+		case '9', 'A', 'O', '0', 'X':
+			if len(in) == 0 || !fcTextSymbol(c, in[0]) {
+				return "", false
+			}
+			sb.WriteRune(in[0])
+			in = in[1:]
+		default:
+			lit := string(c)
+			if c == '\'' {
+				j := strings.IndexByte(picture[i+1:], '\'')
+				if j < 0 {
+					j = len(picture) - i - 1
+				}
+				lit = picture[i+1 : i+1+j]
+				i += j + 1
+			}
+			if !strings.HasPrefix(string(in), lit) {
+				return "", false
+			}
+			in = in[len([]rune(lit)):]
+		}
+	}
+	if len(in) > 0 {
+		return "", false
+	}
+	return sb.String(), true
+}
+
+func fcFormat(in *fcInterp, args []fcNode) fcValue {
+	picture, null := in.strArg(args[0])
+	if null {
+		return nil
+	}
+	var out []string
+	for _, v := range in.allValues(args[1:]) {
+		s, ok := fcFormatPicture(picture, fcStr(v))
+		if !ok {
+			return ""
+		}
+		out = append(out, s)
+	}
+	return in.str(strings.Join(out, ""))
+}
+
+func fcParseFn(in *fcInterp, args []fcNode) fcValue {
+	picture, null1 := in.strArg(args[0])
+	s, null2 := in.strArg(args[1])
+	if null1 || null2 {
+		return nil
+	}
+	v, ok := fcParsePicture(picture, s)
+	if !ok {
+		return ""
+	}
+	return v
+}
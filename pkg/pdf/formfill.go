@@ -0,0 +1,436 @@
+package pdf
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Field flags (PDF 32000-1:2008, Tables 221, 226, 228 and 230)
+const (
+	FieldFlagReadOnly   = 1 << 0
+	FieldFlagRequired   = 1 << 1
+	FieldFlagNoExport   = 1 << 2
+	FieldFlagMultiline  = 1 << 12
+	FieldFlagPassword   = 1 << 13
+	FieldFlagNoToggle   = 1 << 14
+	FieldFlagRadio      = 1 << 15
+	FieldFlagPushButton = 1 << 16
+	FieldFlagCombo      = 1 << 17
+	FieldFlagEdit       = 1 << 18
+	FieldFlagMultiSel   = 1 << 21
+)
+
+// fieldNode is a terminal form field together with its widget annotations
+type fieldNode struct {
+	name    string
+	ref     Reference
+	dict    Dictionary
+	widgets []Reference
+}
+
+// collectFields walks the AcroForm field tree and returns the terminal
+// fields keyed by fully qualified name, in document order
+func (d *Document) collectFields() (map[string]*fieldNode, []string) {
+	fields := make(map[string]*fieldNode)
+	var order []string
+	if d == nil {
+		return fields, order
+	}
+
+	form := d.acroForm()
+	if form == nil {
+		return fields, order
+	}
+	roots, ok := resolveArray(d, form.Get("Fields"))
+	if !ok {
+		return fields, order
+	}
+
+	var walk func(obj Object, parentName string, depth int)
+	walk = func(obj Object, parentName string, depth int) {
+		if depth > 32 {
+			return
+		}
+		dict, ok := resolveDict(d, obj)
+		if !ok {
+			return
+		}
+		ref, _ := obj.(Reference)
+
+		name := parentName
+		if t, ok := dict.Get("T").(String); ok {
+			if name != "" {
+				name += "."
+			}
+			name += t.Text()
+		}
+
+		kids, _ := resolveArray(d, dict.Get("Kids"))
+		var widgets []Reference
+		hasFieldKids := false
+		for _, kid := range kids {
+			kidDict, ok := resolveDict(d, kid)
+			if !ok {
+				continue
+			}
+			if kidDict.Get("T") != nil {
+				hasFieldKids = true
+				walk(kid, name, depth+1)
+			} else if kidRef, ok := kid.(Reference); ok {
+				widgets = append(widgets, kidRef)
+			}
+		}
+		if hasFieldKids {
+			return
+		}
+
+		if len(kids) == 0 {
+			widgets = append(widgets, ref)
+		}
+		if _, exists := fields[name]; !exists {
+			order = append(order, name)
+		}
+		fields[name] = &fieldNode{name: name, ref: ref, dict: dict, widgets: widgets}
+	}
+
+	for _, root := range roots {
+		walk(root, "", 0)
+	}
+	return fields, order
+}
+
+// FormFiller sets form field values. Appearance streams of the changed
+// widgets are regenerated and the result is written as an incremental update.
+//
+// When fields carry JavaScript actions, filling a text or choice field runs
+// its keystroke and validate scripts, recalculates the form and formats the
+// displayed values, as a viewer would.
+type FormFiller struct {
+	doc    *Document
+	form   Dictionary
+	writer *IncrementalWriter
+	fields map[string]*fieldNode
+	order  []string
+
+	scripts     *JSEngine
+	scriptsInit bool
+	noScripts   bool
+}
+
+// NewFormFiller creates a new form filler
+func NewFormFiller(doc *Document) *FormFiller {
+	fields, order := doc.collectFields()
+	return &FormFiller{
+		doc:    doc,
+		form:   doc.acroForm(),
+		writer: NewIncrementalWriter(doc),
+		fields: fields,
+		order:  order,
+	}
+}
+
+// FieldNames returns the fully qualified names of the terminal fields
+func (f *FormFiller) FieldNames() []string {
+	return append([]string(nil), f.order...)
+}
+
+// SetScriptsEnabled enables or disables running field scripts. Scripts
+// are enabled by default.
+func (f *FormFiller) SetScriptsEnabled(enabled bool) {
+	f.noScripts = !enabled
+}
+
+// ScriptEngine returns the engine running the field scripts, or nil when
+// the form has no scripts or scripts are disabled
+func (f *FormFiller) ScriptEngine() *JSEngine {
+	if f.noScripts {
+		return nil
+	}
+	if !f.scriptsInit {
+		f.scriptsInit = true
+		if f.hasScripts() {
+			f.scripts = NewJSEngine(f.doc)
+		}
+	}
+	return f.scripts
+}
+
+// hasScripts reports whether any field has keystroke, format, validate or
+// calculate actions
+func (f *FormFiller) hasScripts() bool {
+	for _, name := range f.order {
+		node := f.fields[name]
+		actions := make(map[string]string)
+		jsCollectActions(f.doc, node.dict, actions)
+		for _, ref := range node.widgets {
+			if widget, ok := resolveDict(f.doc, ref); ok {
+				jsCollectActions(f.doc, widget, actions)
+			}
+		}
+		if len(actions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SetValue sets the value of a text, choice or button field
+func (f *FormFiller) SetValue(name, value string) error {
+	return f.SetValues(name, []string{value})
+}
+
+// SetValues sets the value of a field. Several values are only allowed
+// for multi-select choice fields. The read-only flag only restricts user
+// interaction, so read-only fields can still be filled.
+func (f *FormFiller) SetValues(name string, values []string) error {
+	node, ok := f.fields[name]
+	if !ok {
+		return fmt.Errorf("field %q not found", name)
+	}
+	if node.ref.ObjectNumber == 0 {
+		return fmt.Errorf("field %q is not an indirect object", name)
+	}
+	if len(values) == 0 {
+		values = []string{""}
+	}
+
+	engine := f.ScriptEngine()
+	if engine == nil {
+		return f.store(node, values)
+	}
+	engine.runMu.Lock()
+	defer engine.runMu.Unlock()
+
+	field := engine.form.fields[name]
+	if field.ft == "Tx" || field.ft == "Ch" {
+		value := strings.Join(values, "\n")
+		for _, trigger := range []string{"K", "V"} {
+			result, rc, err := engine.fieldEvent(field, trigger, value)
+			if err != nil {
+				return fmt.Errorf("field %q: %w", name, err)
+			}
+			if !rc {
+				return fmt.Errorf("field %q: value %q rejected by %s script", name, value, strings.ToLower(jsEventNames[trigger]))
+			}
+			if result != value {
+				value = result
+				values = []string{value}
+			}
+		}
+	}
+
+	prev := field.values
+	field.values = values
+	if err := f.store(node, values); err != nil {
+		field.values = prev
+		return err
+	}
+	if field.ft == "Btn" && values[0] == "" {
+		field.values = []string{"Off"}
+	}
+
+	if err := engine.calculate(); err != nil {
+		return err
+	}
+	return f.flushScriptChanges()
+}
+
+// store writes the value of a field and regenerates its appearances
+func (f *FormFiller) store(node *fieldNode, values []string) error {
+	name := node.name
+	ft, _ := f.doc.inheritedFieldAttr(node.dict, "FT").(Name)
+	flags, _ := f.doc.inheritedFieldAttr(node.dict, "Ff").(Integer)
+
+	field := f.writer.EditDictionary(node.ref)
+	switch ft {
+	case "Tx":
+		field["V"] = textString(strings.Join(values, "\n"))
+		f.writeTextAppearances(node, values[0], flags)
+	case "Ch":
+		if len(values) > 1 {
+			if flags&FieldFlagMultiSel == 0 {
+				return fmt.Errorf("field %q does not allow multiple selections", name)
+			}
+			arr := make(Array, len(values))
+			for i, v := range values {
+				arr[i] = textString(v)
+			}
+			field["V"] = arr
+		} else {
+			field["V"] = textString(values[0])
+		}
+		f.writeTextAppearances(node, values[0], flags)
+	case "Btn":
+		if flags&FieldFlagPushButton != 0 {
+			return fmt.Errorf("field %q is a push button", name)
+		}
+		state := values[0]
+		if state == "" {
+			state = "Off"
+		}
+		field["V"] = Name(state)
+		for _, ref := range node.widgets {
+			widget := f.writer.EditDictionary(ref)
+			widget["AS"] = Name("Off")
+			if ap, ok := resolveDict(f.doc, widget.Get("AP")); ok {
+				if states, ok := resolveDict(f.doc, ap.Get("N")); ok && states.Get(state) != nil {
+					widget["AS"] = Name(state)
+				}
+			}
+		}
+	case "Sig":
+		return fmt.Errorf("field %q is a signature field", name)
+	default:
+		return fmt.Errorf("field %q has unknown type %q", name, ft)
+	}
+
+	return nil
+}
+
+// writeTextAppearances regenerates the appearance of each widget of a
+// text or choice field
+func (f *FormFiller) writeTextAppearances(node *fieldNode, value string, flags Integer) {
+	if f.scripts != nil {
+		// The format script decides how the value is displayed
+		if field := f.scripts.form.fields[node.name]; field != nil {
+			if formatted, _, err := f.scripts.fieldEvent(field, "F", value); err == nil {
+				value = formatted
+			}
+		}
+	}
+	if flags&FieldFlagPassword != 0 {
+		value = strings.Repeat("*", len([]rune(value)))
+	}
+	for _, ref := range node.widgets {
+		widget := f.writer.EditDictionary(ref)
+		ap := widgetTextAppearance(f.doc, f.form, widget, value)
+		if ap == nil {
+			continue
+		}
+		widget["AP"] = Dictionary{"N": f.writer.AddObject(*ap)}
+	}
+}
+
+// flushScriptChanges writes the values, visibility and flags that
+// scripts changed
+func (f *FormFiller) flushScriptChanges() error {
+	for _, field := range f.scripts.form.takeDirty() {
+		node := field.node
+		if node == nil || node.ref.ObjectNumber == 0 {
+			continue
+		}
+		if field.changed {
+			field.changed = false
+			if err := f.store(node, field.values); err != nil {
+				return err
+			}
+		}
+
+		flags, _ := f.doc.inheritedFieldAttr(node.dict, "Ff").(Integer)
+		newFlags := flags &^ (FieldFlagReadOnly | FieldFlagRequired)
+		if field.readonly {
+			newFlags |= FieldFlagReadOnly
+		}
+		if field.required {
+			newFlags |= FieldFlagRequired
+		}
+		if newFlags != flags {
+			f.writer.EditDictionary(node.ref)["Ff"] = newFlags
+		}
+
+		for _, ref := range node.widgets {
+			widget, ok := resolveDict(f.doc, ref)
+			if !ok {
+				continue
+			}
+			annotFlags, _ := widget.Get("F").(Integer)
+			newAnnotFlags := annotFlags &^ (AnnotFlagHidden | AnnotFlagPrint | AnnotFlagNoView)
+			switch field.display {
+			case jsDisplayVisible:
+				newAnnotFlags |= AnnotFlagPrint
+			case jsDisplayHidden:
+				newAnnotFlags |= AnnotFlagHidden
+			case jsDisplayNoView:
+				newAnnotFlags |= AnnotFlagNoView | AnnotFlagPrint
+			}
+			if newAnnotFlags != annotFlags {
+				f.writer.EditDictionary(ref)["F"] = newAnnotFlags
+			}
+		}
+	}
+	return nil
+}
+
+// Bytes returns the filled document
+func (f *FormFiller) Bytes() ([]byte, error) {
+	// Appearances are up to date, so viewers need not regenerate them
+	if f.form != nil && f.writer.Modified() {
+		if need, ok := f.form.Get("NeedAppearances").(Boolean); ok && bool(need) {
+			if formRef, ok := f.doc.Root.Get("AcroForm").(Reference); ok {
+				delete(f.writer.EditDictionary(formRef), "NeedAppearances")
+			}
+		}
+	}
+
+	return f.writer.Bytes()
+}
+
+// WriteToFile writes the filled document to a file
+func (f *FormFiller) WriteToFile(filename string) error {
+	data, err := f.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// widgetTextAppearance builds a normal appearance for a text or choice
+// field widget showing value
+func widgetTextAppearance(doc *Document, form Dictionary, annot Dictionary, value string) *Stream {
+	rectArr, ok := resolveArray(doc, annot.Get("Rect"))
+	if !ok || len(rectArr) != 4 {
+		return nil
+	}
+	rect := normalizeRect(arrayToRectangle(rectArr))
+	width, height := rect.URX-rect.LLX, rect.URY-rect.LLY
+
+	var daStr string
+	if da, ok := doc.inheritedFieldAttr(annot, "DA").(String); ok {
+		daStr = string(da.Value)
+	} else if form != nil {
+		if da, ok := form.Get("DA").(String); ok {
+			daStr = string(da.Value)
+		}
+	}
+	da := parseDefaultAppearance(daStr)
+
+	quadding := 0
+	if q, ok := doc.inheritedFieldAttr(annot, "Q").(Integer); ok {
+		quadding = int(q)
+	} else if form != nil {
+		if q, ok := form.GetInt("Q"); ok {
+			quadding = int(q)
+		}
+	}
+
+	ft, _ := doc.inheritedFieldAttr(annot, "FT").(Name)
+	flags, _ := doc.inheritedFieldAttr(annot, "Ff").(Integer)
+	multiline := ft == "Tx" && flags&FieldFlagMultiline != 0
+
+	var font Object = helveticaFont()
+	if form != nil {
+		if dr, ok := resolveDict(doc, form.Get("DR")); ok {
+			if fonts, ok := resolveDict(doc, dr.Get("Font")); ok {
+				if fontObj := fonts.Get(string(da.Font)); fontObj != nil {
+					font = fontObj
+				}
+			}
+		}
+	}
+
+	content := textFieldAppearance(value, da, quadding, width, height, multiline)
+	resources := Dictionary{"Font": Dictionary{da.Font: font}}
+	stream := newAppearanceStream(width, height, content, resources)
+	return &stream
+}
@@ -0,0 +1,424 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+)
+
+// maxFunctionDepth limits the nesting of stitching functions
+const maxFunctionDepth = 8
+
+// maxSampledInputs limits the inputs of a sampled function, whose
+// interpolation visits 2^m table corners
+const maxSampledInputs = 16
+
+// Function is a PDF function (ISO 32000-1, 7.10) mapping m input values to
+// n output values. Functions are used for tint transforms, shadings and
+// transfer functions.
+type Function interface {
+	// Inputs returns the number of input values
+	Inputs() int
+	// Outputs returns the number of output values
+	Outputs() int
+	// Evaluate maps in to the outputs. Inputs are clipped to the
+	// domain and outputs to the range, when one is given.
+	Evaluate(in []float64) []float64
+}
+
+// functionBase holds the Domain and Range shared by all function types
+type functionBase struct {
+	domain []float64
+	rng    []float64
+}
+
+// Inputs returns the number of input values
+func (f *functionBase) Inputs() int {
+	return len(f.domain) / 2
+}
+
+// clipInput copies in and clips it to the domain
+func (f *functionBase) clipInput(in []float64) []float64 {
+	out := make([]float64, f.Inputs())
+	for i := range out {
+		if i < len(in) {
+			out[i] = in[i]
+		}
+		out[i] = clampFloat(out[i], f.domain[2*i], f.domain[2*i+1])
+	}
+	return out
+}
+
+// clipOutput clips out to the range in place
+func (f *functionBase) clipOutput(out []float64) []float64 {
+	for i := range out {
+		if 2*i+1 < len(f.rng) {
+			out[i] = clampFloat(out[i], f.rng[2*i], f.rng[2*i+1])
+		}
+	}
+	return out
+}
+
+// ParseFunction parses a function dictionary or stream. An array of
+// functions with one output each is combined into a single function.
+func (d *Document) ParseFunction(obj Object) (Function, error) {
+	return d.parseFunction(obj, 0)
+}
+
+// ParseFunction parses a function that needs no document: obj and the
+// objects it contains, such as the functions of a stitching function, must
+// not be references. Use (*Document).ParseFunction for functions read from
+// a file.
+func ParseFunction(obj Object) (Function, error) {
+	var d *Document
+	return d.parseFunction(obj, 0)
+}
+
+func (d *Document) parseFunction(obj Object, depth int) (Function, error) {
+	if depth > maxFunctionDepth {
+		return nil, fmt.Errorf("functions nested too deeply")
+	}
+
+	var dict Dictionary
+	var stream *Stream
+	switch v := d.resolve(obj).(type) {
+	case Dictionary:
+		dict = v
+	case Stream:
+		dict = v.Dictionary
+		stream = &v
+	case Array:
+		arr := &functionArray{}
+		for _, item := range v {
+			f, err := d.parseFunction(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if f.Outputs() != 1 {
+				return nil, fmt.Errorf("function array entries must have one output")
+			}
+			arr.funcs = append(arr.funcs, f)
+		}
+		if len(arr.funcs) == 0 {
+			return nil, fmt.Errorf("empty function array")
+		}
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("invalid function object %T", obj)
+	}
+
+	base := functionBase{
+		domain: d.numberArray(dict.Get("Domain")),
+		rng:    d.numberArray(dict.Get("Range")),
+	}
+	if len(base.domain) < 2 || len(base.domain)%2 != 0 {
+		return nil, fmt.Errorf("function without a valid Domain")
+	}
+
+	kind, _ := dict.GetInt("FunctionType")
+	switch kind {
+	case 0, 4:
+		if stream == nil {
+			return nil, fmt.Errorf("function type %d must be a stream", kind)
+		}
+		if len(base.rng) < 2 || len(base.rng)%2 != 0 {
+			return nil, fmt.Errorf("function type %d without a valid Range", kind)
+		}
+		data, err := stream.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("function type %d: %w", kind, err)
+		}
+		if kind == 0 {
+			return d.parseSampledFunction(dict, base, data)
+		}
+		return newPostScriptFunction(base, data)
+	case 2:
+		return d.parseExponentialFunction(dict, base)
+	case 3:
+		return d.parseStitchingFunction(dict, base, depth)
+	}
+	return nil, fmt.Errorf("unsupported function type %d", kind)
+}
+
+// numberArray resolves an array of numbers, or returns nil
+func (d *Document) numberArray(obj Object) []float64 {
+	arr, ok := d.resolve(obj).(Array)
+	if !ok {
+		return nil
+	}
+	out := make([]float64, len(arr))
+	for i, v := range arr {
+		out[i] = objectToFloat(d.resolve(v))
+	}
+	return out
+}
+
+// sampledFunction is a Type 0 function interpolating a table of samples
+type sampledFunction struct {
+	functionBase
+	size    []int
+	encode  []float64
+	decode  []float64
+	strides []int
+	samples []float64 // normalised to 0..1, outputs interleaved
+}
+
+func (d *Document) parseSampledFunction(dict Dictionary, base functionBase, data []byte) (Function, error) {
+	m, n := base.Inputs(), len(base.rng)/2
+	if m > maxSampledInputs {
+		return nil, fmt.Errorf("sampled function has too many inputs")
+	}
+	f := &sampledFunction{
+		functionBase: base,
+		encode:       d.numberArray(dict.Get("Encode")),
+		decode:       d.numberArray(dict.Get("Decode")),
+		strides:      make([]int, m),
+	}
+
+	total := n
+	for _, v := range d.numberArray(dict.Get("Size")) {
+		size := int(v)
+		if size < 1 || total > len(data)*8/size {
+			return nil, fmt.Errorf("sampled function: invalid Size")
+		}
+		f.size = append(f.size, size)
+		total *= size
+	}
+	if len(f.size) != m {
+		return nil, fmt.Errorf("sampled function: Size has the wrong length")
+	}
+	// The first input varies fastest
+	stride := n
+	for i, size := range f.size {
+		f.strides[i] = stride
+		stride *= size
+	}
+
+	if len(f.encode) != 2*m {
+		f.encode = make([]float64, 2*m)
+		for i, size := range f.size {
+			f.encode[2*i+1] = float64(size - 1)
+		}
+	}
+	if len(f.decode) != 2*n {
+		f.decode = f.rng
+	}
+
+	bps, _ := dict.GetInt("BitsPerSample")
+	switch bps {
+	case 1, 2, 4, 8, 12, 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("sampled function: invalid BitsPerSample %d", bps)
+	}
+	if int64(total)*bps > int64(len(data))*8 {
+		return nil, fmt.Errorf("sampled function: sample data too short")
+	}
+	maxSample := float64(uint64(1)<<uint(bps) - 1)
+	f.samples = make([]float64, total)
+	var acc uint64
+	var bits int64
+	pos := 0
+	for i := range f.samples {
+		for bits < bps {
+			acc = acc<<8 | uint64(data[pos])
+			pos++
+			bits += 8
+		}
+		bits -= bps
+		f.samples[i] = float64(acc>>uint(bits)&(uint64(1)<<uint(bps)-1)) / maxSample
+	}
+	return f, nil
+}
+
+// Outputs returns the number of output values
+func (f *sampledFunction) Outputs() int {
+	return len(f.rng) / 2
+}
+
+// Evaluate encodes the inputs into sample coordinates, interpolates the
+// surrounding samples multilinearly and decodes the result
+func (f *sampledFunction) Evaluate(in []float64) []float64 {
+	x := f.clipInput(in)
+	m, n := len(x), f.Outputs()
+
+	base := 0
+	frac := make([]float64, m)
+	for i, v := range x {
+		lo, hi := f.domain[2*i], f.domain[2*i+1]
+		e := f.encode[2*i]
+		if hi != lo {
+			e += (v - lo) * (f.encode[2*i+1] - f.encode[2*i]) / (hi - lo)
+		}
+		e = clampFloat(e, 0, float64(f.size[i]-1))
+		idx := int(e)
+		if idx == f.size[i]-1 && idx > 0 {
+			idx--
+		}
+		frac[i] = e - float64(idx)
+		base += idx * f.strides[i]
+	}
+
+	out := make([]float64, n)
+	for corner := 0; corner < 1<<uint(m); corner++ {
+		weight, offset := 1.0, base
+		for i := 0; i < m; i++ {
+			if corner&(1<<uint(i)) != 0 {
+				if f.size[i] == 1 {
+					weight = 0
+					break
+				}
+				weight *= frac[i]
+				offset += f.strides[i]
+			} else {
+				weight *= 1 - frac[i]
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+		for j := range out {
+			out[j] += weight * f.samples[offset+j]
+		}
+	}
+	for j := range out {
+		out[j] = f.decode[2*j] + out[j]*(f.decode[2*j+1]-f.decode[2*j])
+	}
+	return f.clipOutput(out)
+}
+
+// exponentialFunction is a Type 2 exponential interpolation function
+type exponentialFunction struct {
+	functionBase
+	c0, c1 []float64
+	n      float64
+}
+
+func (d *Document) parseExponentialFunction(dict Dictionary, base functionBase) (Function, error) {
+	f := &exponentialFunction{
+		functionBase: base,
+		c0:           d.numberArray(dict.Get("C0")),
+		c1:           d.numberArray(dict.Get("C1")),
+		n:            objectToFloat(d.resolve(dict.Get("N"))),
+	}
+	if f.c0 == nil {
+		f.c0 = []float64{0}
+	}
+	if f.c1 == nil {
+		f.c1 = []float64{1}
+	}
+	if len(f.c0) != len(f.c1) {
+		return nil, fmt.Errorf("exponential function: C0 and C1 differ in size")
+	}
+	if f.Inputs() != 1 {
+		return nil, fmt.Errorf("exponential function must have one input")
+	}
+	return f, nil
+}
+
+// Outputs returns the number of output values
+func (f *exponentialFunction) Outputs() int {
+	return len(f.c0)
+}
+
+// Evaluate computes C0 + x^N × (C1 − C0)
+func (f *exponentialFunction) Evaluate(in []float64) []float64 {
+	x := f.clipInput(in)[0]
+	xn := math.Pow(x, f.n)
+	if math.IsNaN(xn) || math.IsInf(xn, 0) {
+		xn = 0
+	}
+	out := make([]float64, len(f.c0))
+	for i := range out {
+		out[i] = f.c0[i] + xn*(f.c1[i]-f.c0[i])
+	}
+	return f.clipOutput(out)
+}
+
+// stitchingFunction is a Type 3 function combining subdomains of other
+// one-input functions
+type stitchingFunction struct {
+	functionBase
+	funcs  []Function
+	bounds []float64
+	encode []float64
+}
+
+func (d *Document) parseStitchingFunction(dict Dictionary, base functionBase, depth int) (Function, error) {
+	f := &stitchingFunction{
+		functionBase: base,
+		bounds:       d.numberArray(dict.Get("Bounds")),
+		encode:       d.numberArray(dict.Get("Encode")),
+	}
+	if f.Inputs() != 1 {
+		return nil, fmt.Errorf("stitching function must have one input")
+	}
+	funcs, ok := d.resolve(dict.Get("Functions")).(Array)
+	if !ok || len(funcs) == 0 {
+		return nil, fmt.Errorf("stitching function without Functions")
+	}
+	for _, item := range funcs {
+		sub, err := d.parseFunction(item, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if sub.Inputs() != 1 || (len(f.funcs) > 0 && sub.Outputs() != f.funcs[0].Outputs()) {
+			return nil, fmt.Errorf("stitching function: incompatible subfunction")
+		}
+		f.funcs = append(f.funcs, sub)
+	}
+	if len(f.bounds) != len(f.funcs)-1 || len(f.encode) != 2*len(f.funcs) {
+		return nil, fmt.Errorf("stitching function: Bounds or Encode has the wrong size")
+	}
+	return f, nil
+}
+
+// Outputs returns the number of output values
+func (f *stitchingFunction) Outputs() int {
+	return f.funcs[0].Outputs()
+}
+
+// Evaluate selects the subfunction for the input's subdomain and maps the
+// input through Encode
+func (f *stitchingFunction) Evaluate(in []float64) []float64 {
+	x := f.clipInput(in)[0]
+	k := 0
+	for k < len(f.bounds) && x >= f.bounds[k] {
+		k++
+	}
+	lo, hi := f.domain[0], f.domain[1]
+	if k > 0 {
+		lo = f.bounds[k-1]
+	}
+	if k < len(f.bounds) {
+		hi = f.bounds[k]
+	}
+	e0, e1 := f.encode[2*k], f.encode[2*k+1]
+	t := e0
+	if hi != lo {
+		t = e0 + (x-lo)*(e1-e0)/(hi-lo)
+	}
+	return f.clipOutput(f.funcs[k].Evaluate([]float64{t}))
+}
+
+// functionArray evaluates several one-output functions on the same input
+type functionArray struct {
+	funcs []Function
+}
+
+// Inputs returns the number of input values
+func (f *functionArray) Inputs() int {
+	return f.funcs[0].Inputs()
+}
+
+// Outputs returns the number of output values
+func (f *functionArray) Outputs() int {
+	return len(f.funcs)
+}
+
+// Evaluate concatenates the outputs of all functions
+func (f *functionArray) Evaluate(in []float64) []float64 {
+	out := make([]float64, 0, len(f.funcs))
+	for _, fn := range f.funcs {
+		out = append(out, fn.Evaluate(in)...)
+	}
+	return out
+}
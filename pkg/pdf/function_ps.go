@@ -0,0 +1,453 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// psStackSize is the operand stack limit of PostScript calculator
+// functions (ISO 32000-1, Annex C)
+const psStackSize = 100
+
+// psOpcode is an instruction of a compiled calculator function
+type psOpcode uint8
+
+const (
+	psPush psOpcode = iota
+	psJumpIfFalse
+	psJump
+	psAbs
+	psAdd
+	psAtan
+	psCeiling
+	psCos
+	psCvi
+	psCvr
+	psDiv
+	psExp
+	psFloor
+	psIdiv
+	psLn
+	psLog
+	psMod
+	psMul
+	psNeg
+	psRound
+	psSin
+	psSqrt
+	psSub
+	psTruncate
+	psAnd
+	psBitshift
+	psEq
+	psFalse
+	psGe
+	psGt
+	psLe
+	psLt
+	psNe
+	psNot
+	psOr
+	psTrue
+	psXor
+	psCopy
+	psDup
+	psExch
+	psIndex
+	psPop
+	psRoll
+)
+
+var psOperators = map[string]psOpcode{
+	"abs": psAbs, "add": psAdd, "atan": psAtan, "ceiling": psCeiling,
+	"cos": psCos, "cvi": psCvi, "cvr": psCvr, "div": psDiv, "exp": psExp,
+	"floor": psFloor, "idiv": psIdiv, "ln": psLn, "log": psLog, "mod": psMod,
+	"mul": psMul, "neg": psNeg, "round": psRound, "sin": psSin, "sqrt": psSqrt,
+	"sub": psSub, "truncate": psTruncate,
+	"and": psAnd, "bitshift": psBitshift, "eq": psEq, "false": psFalse,
+	"ge": psGe, "gt": psGt, "le": psLe, "lt": psLt, "ne": psNe, "not": psNot,
+	"or": psOr, "true": psTrue, "xor": psXor,
+	"copy": psCopy, "dup": psDup, "exch": psExch, "index": psIndex,
+	"pop": psPop, "roll": psRoll,
+}
+
+// psInstr is one compiled instruction. Pushes carry their operand and
+// jumps their target.
+type psInstr struct {
+	op     psOpcode
+	value  psValue
+	target int
+}
+
+// psValue is an operand: a number, or a boolean when isBool is set
+type psValue struct {
+	num    float64
+	isBool bool
+}
+
+// postScriptFunction is a Type 4 calculator function compiled to a flat
+// instruction list
+type postScriptFunction struct {
+	functionBase
+	code []psInstr
+}
+
+// newPostScriptFunction compiles the calculator program in data
+func newPostScriptFunction(base functionBase, data []byte) (Function, error) {
+	// The inputs start on the operand stack
+	if base.Inputs() > psStackSize {
+		return nil, fmt.Errorf("calculator function has too many inputs")
+	}
+	c := &psCompiler{data: data}
+	if tok := c.next(); tok != "{" {
+		return nil, fmt.Errorf("calculator function must start with {")
+	}
+	if err := c.compileBlock(0); err != nil {
+		return nil, err
+	}
+	return &postScriptFunction{functionBase: base, code: c.code}, nil
+}
+
+// Outputs returns the number of output values
+func (f *postScriptFunction) Outputs() int {
+	return len(f.rng) / 2
+}
+
+// Evaluate runs the program with the inputs on the stack and takes the
+// outputs from the top of the stack. A program that fails yields the
+// lower bound of each output range.
+func (f *postScriptFunction) Evaluate(in []float64) []float64 {
+	var stack [psStackSize]psValue
+	x := f.clipInput(in)
+	for i, v := range x {
+		stack[i] = psValue{num: v}
+	}
+	out := make([]float64, f.Outputs())
+	sp, err := runPostScript(f.code, stack[:], len(x))
+	if err != nil || sp < len(out) {
+		for i := range out {
+			out[i] = f.rng[2*i]
+		}
+		return out
+	}
+	for i, v := range stack[sp-len(out) : sp] {
+		out[i] = v.num
+	}
+	return f.clipOutput(out)
+}
+
+// psCompiler tokenizes and compiles a calculator program
+type psCompiler struct {
+	data []byte
+	pos  int
+	code []psInstr
+}
+
+// next returns the next token, or "" at the end of the data
+func (c *psCompiler) next() string {
+	for c.pos < len(c.data) {
+		ch := c.data[c.pos]
+		if ch == '%' {
+			for c.pos < len(c.data) && c.data[c.pos] != '\n' && c.data[c.pos] != '\r' {
+				c.pos++
+			}
+			continue
+		}
+		if !isWhitespace(ch) {
+			break
+		}
+		c.pos++
+	}
+	if c.pos >= len(c.data) {
+		return ""
+	}
+	start := c.pos
+	if ch := c.data[c.pos]; ch == '{' || ch == '}' {
+		c.pos++
+		return string(ch)
+	}
+	for c.pos < len(c.data) {
+		ch := c.data[c.pos]
+		if isWhitespace(ch) || ch == '{' || ch == '}' || ch == '%' {
+			break
+		}
+		c.pos++
+	}
+	return string(c.data[start:c.pos])
+}
+
+// compileBlock compiles the tokens up to the closing brace of a procedure
+// whose opening brace has been read. Procedures are only allowed as the
+// operands of if and ifelse, which become conditional jumps.
+func (c *psCompiler) compileBlock(depth int) error {
+	if depth > maxFunctionDepth*4 {
+		return fmt.Errorf("calculator function nested too deeply")
+	}
+	for {
+		tok := c.next()
+		switch tok {
+		case "":
+			return fmt.Errorf("calculator function: missing }")
+		case "}":
+			return nil
+		case "{":
+			// { then } if   or   { then } { else } ifelse
+			jump := len(c.code)
+			c.code = append(c.code, psInstr{op: psJumpIfFalse})
+			if err := c.compileBlock(depth + 1); err != nil {
+				return err
+			}
+			switch tok := c.next(); tok {
+			case "if":
+				c.code[jump].target = len(c.code)
+			case "{":
+				skip := len(c.code)
+				c.code = append(c.code, psInstr{op: psJump})
+				c.code[jump].target = len(c.code)
+				if err := c.compileBlock(depth + 1); err != nil {
+					return err
+				}
+				if c.next() != "ifelse" {
+					return fmt.Errorf("calculator function: expected ifelse")
+				}
+				c.code[skip].target = len(c.code)
+			default:
+				return fmt.Errorf("calculator function: unexpected %q after procedure", tok)
+			}
+		default:
+			if op, ok := psOperators[tok]; ok {
+				c.code = append(c.code, psInstr{op: op})
+				continue
+			}
+			v, err := strconv.ParseFloat(tok, 64)
+			if err != nil {
+				return fmt.Errorf("calculator function: unknown operator %q", tok)
+			}
+			c.code = append(c.code, psInstr{op: psPush, value: psValue{num: v}})
+		}
+	}
+}
+
+var errPSStack = fmt.Errorf("calculator function: stack overflow or underflow")
+
+// runPostScript executes code on stack, whose first sp entries hold the
+// operands, and returns the final stack depth
+func runPostScript(code []psInstr, stack []psValue, sp int) (int, error) {
+	// need checks that n operands are present and room for grow results
+	need := func(n, grow int) bool {
+		return sp >= n && sp-n+grow <= len(stack)
+	}
+	num := func(v float64) psValue { return psValue{num: v} }
+	boolean := func(b bool) psValue {
+		if b {
+			return psValue{num: 1, isBool: true}
+		}
+		return psValue{isBool: true}
+	}
+
+	for pc := 0; pc < len(code); pc++ {
+		in := code[pc]
+		switch in.op {
+		case psPush, psTrue, psFalse:
+			if !need(0, 1) {
+				return sp, errPSStack
+			}
+			switch in.op {
+			case psPush:
+				stack[sp] = in.value
+			case psTrue:
+				stack[sp] = boolean(true)
+			default:
+				stack[sp] = boolean(false)
+			}
+			sp++
+		case psJumpIfFalse:
+			if !need(1, 0) {
+				return sp, errPSStack
+			}
+			sp--
+			if stack[sp].num == 0 {
+				pc = in.target - 1
+			}
+		case psJump:
+			pc = in.target - 1
+
+		case psAbs, psCeiling, psCos, psCvi, psCvr, psFloor, psLn, psLog,
+			psNeg, psRound, psSin, psSqrt, psTruncate, psNot:
+			if !need(1, 1) {
+				return sp, errPSStack
+			}
+			a := &stack[sp-1]
+			switch in.op {
+			case psAbs:
+				*a = num(math.Abs(a.num))
+			case psCeiling:
+				*a = num(math.Ceil(a.num))
+			case psCos:
+				*a = num(math.Cos(a.num * math.Pi / 180))
+			case psCvi, psTruncate:
+				*a = num(math.Trunc(a.num))
+			case psCvr:
+				*a = num(a.num)
+			case psFloor:
+				*a = num(math.Floor(a.num))
+			case psLn:
+				*a = num(math.Log(a.num))
+			case psLog:
+				*a = num(math.Log10(a.num))
+			case psNeg:
+				*a = num(-a.num)
+			case psRound:
+				*a = num(math.Floor(a.num + 0.5))
+			case psSin:
+				*a = num(math.Sin(a.num * math.Pi / 180))
+			case psSqrt:
+				*a = num(math.Sqrt(a.num))
+			case psNot:
+				if a.isBool {
+					*a = boolean(a.num == 0)
+				} else {
+					*a = num(float64(^int64(a.num)))
+				}
+			}
+
+		case psAdd, psAtan, psDiv, psExp, psIdiv, psMod, psMul, psSub,
+			psAnd, psOr, psXor, psBitshift, psEq, psNe, psGe, psGt, psLe, psLt:
+			if !need(2, 1) {
+				return sp, errPSStack
+			}
+			a, b := stack[sp-2], stack[sp-1]
+			sp--
+			var r psValue
+			switch in.op {
+			case psAdd:
+				r = num(a.num + b.num)
+			case psSub:
+				r = num(a.num - b.num)
+			case psMul:
+				r = num(a.num * b.num)
+			case psDiv:
+				if b.num == 0 {
+					return sp, fmt.Errorf("calculator function: division by zero")
+				}
+				r = num(a.num / b.num)
+			case psIdiv, psMod:
+				x, y := int64(a.num), int64(b.num)
+				if y == 0 {
+					return sp, fmt.Errorf("calculator function: division by zero")
+				}
+				if in.op == psIdiv {
+					r = num(float64(x / y))
+				} else {
+					r = num(float64(x % y))
+				}
+			case psAtan:
+				deg := math.Atan2(a.num, b.num) * 180 / math.Pi
+				if deg < 0 {
+					deg += 360
+				}
+				r = num(deg)
+			case psExp:
+				r = num(math.Pow(a.num, b.num))
+			case psAnd, psOr, psXor:
+				x, y := int64(a.num), int64(b.num)
+				var v int64
+				switch in.op {
+				case psAnd:
+					v = x & y
+				case psOr:
+					v = x | y
+				default:
+					v = x ^ y
+				}
+				r = num(float64(v))
+				r.isBool = a.isBool && b.isBool
+			case psBitshift:
+				x, shift := int64(a.num), int64(b.num)
+				if shift >= 0 {
+					r = num(float64(x << uint(min(int(shift), 63))))
+				} else {
+					r = num(float64(x >> uint(min(int(-shift), 63))))
+				}
+			case psEq:
+				r = boolean(a.num == b.num)
+			case psNe:
+				r = boolean(a.num != b.num)
+			case psGe:
+				r = boolean(a.num >= b.num)
+			case psGt:
+				r = boolean(a.num > b.num)
+			case psLe:
+				r = boolean(a.num <= b.num)
+			case psLt:
+				r = boolean(a.num < b.num)
+			}
+			stack[sp-1] = r
+
+		case psDup:
+			if !need(1, 2) {
+				return sp, errPSStack
+			}
+			stack[sp] = stack[sp-1]
+			sp++
+		case psExch:
+			if !need(2, 2) {
+				return sp, errPSStack
+			}
+			stack[sp-1], stack[sp-2] = stack[sp-2], stack[sp-1]
+		case psPop:
+			if !need(1, 0) {
+				return sp, errPSStack
+			}
+			sp--
+		case psCopy:
+			if !need(1, 0) {
+				return sp, errPSStack
+			}
+			n := int(stack[sp-1].num)
+			sp--
+			if n < 0 || !need(n, 2*n) {
+				return sp, errPSStack
+			}
+			copy(stack[sp:], stack[sp-n:sp])
+			sp += n
+		case psIndex:
+			if !need(1, 1) {
+				return sp, errPSStack
+			}
+			n := int(stack[sp-1].num)
+			if n < 0 || n >= sp-1 {
+				return sp, errPSStack
+			}
+			stack[sp-1] = stack[sp-2-n]
+		case psRoll:
+			if !need(2, 0) {
+				return sp, errPSStack
+			}
+			n, j := int(stack[sp-2].num), int(stack[sp-1].num)
+			sp -= 2
+			if n < 0 || n > sp {
+				return sp, errPSStack
+			}
+			if n > 0 {
+				j %= n
+				if j < 0 {
+					j += n
+				}
+				window := stack[sp-n : sp]
+				reverseValues(window)
+				reverseValues(window[:j])
+				reverseValues(window[j:])
+			}
+		}
+	}
+	return sp, nil
+}
+
+// reverseValues reverses v in place
+func reverseValues(v []psValue) {
+	for i, j := 0, len(v)-1; i < j; i, j = i+1, j-1 {
+		v[i], v[j] = v[j], v[i]
+	}
+}
@@ -0,0 +1,563 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// ICCProfile is a parsed ICC colour profile. It converts device colours
+// to the profile connection space and from there to sRGB. Matrix/TRC
+// profiles and LUT-based profiles (lut8, lut16 and lutAtoB) are supported.
+type ICCProfile struct {
+	Version    uint32 // profile version, e.g. 0x02100000
+	Class      string // device class signature such as "mntr" or "prtr"
+	ColorSpace string // data colour space signature such as "RGB " or "CMYK"
+	PCS        string // connection space, "XYZ " or "Lab "
+	Channels   int    // number of device channels
+
+	transform iccTransform
+}
+
+// iccTransform converts normalized device values to PCS values
+type iccTransform interface {
+	// pcs returns the PCS value as D50 XYZ
+	pcs(in []float64) [3]float64
+}
+
+// iccD50 is the ICC profile connection space illuminant
+var iccD50 = [3]float64{0.9642, 1.0, 0.8249}
+
+// ParseICCProfile parses an ICC profile
+func ParseICCProfile(data []byte) (*ICCProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("invalid ICC profile")
+	}
+	p := &ICCProfile{
+		Version:    binary.BigEndian.Uint32(data[8:]),
+		Class:      string(data[12:16]),
+		ColorSpace: string(data[16:20]),
+		PCS:        string(data[20:24]),
+	}
+	p.Channels = iccChannels(data)
+	if p.ColorSpace == "Lab " || p.ColorSpace == "XYZ " {
+		p.Channels = 3
+	}
+	if p.Channels == 0 {
+		return nil, fmt.Errorf("unsupported ICC colour space %q", p.ColorSpace)
+	}
+	if p.PCS != "XYZ " && p.PCS != "Lab " {
+		return nil, fmt.Errorf("unsupported ICC connection space %q", p.PCS)
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count && 132+12*i+12 <= len(data); i++ {
+		entry := data[132+12*i:]
+		offset := int(binary.BigEndian.Uint32(entry[4:]))
+		size := int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 8 || offset > len(data)-size {
+			continue
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	// Relative colorimetric tables are preferred, which keeps spot and
+	// brand colours as close to their measured values as the gamut allows
+	for _, sig := range []string{"A2B1", "A2B0", "A2B2"} {
+		if tag, ok := tags[sig]; ok {
+			t, err := parseICCLut(tag, p.Channels, p.PCS == "Lab ")
+			if err == nil {
+				p.transform = t
+				return p, nil
+			}
+		}
+	}
+
+	switch p.ColorSpace {
+	case "GRAY":
+		trc, err := parseICCCurve(tags["kTRC"])
+		if err != nil {
+			return nil, fmt.Errorf("ICC gray profile: %w", err)
+		}
+		p.transform = &iccGrayTRC{trc: trc}
+	case "RGB ":
+		t := &iccMatrixTRC{}
+		for i, c := range []string{"r", "g", "b"} {
+			xyz, err := parseICCXYZ(tags[c+"XYZ"])
+			if err != nil {
+				return nil, fmt.Errorf("ICC RGB profile: %w", err)
+			}
+			if t.trc[i], err = parseICCCurve(tags[c+"TRC"]); err != nil {
+				return nil, fmt.Errorf("ICC RGB profile: %w", err)
+			}
+			for row := 0; row < 3; row++ {
+				t.matrix[row][i] = xyz[row]
+			}
+		}
+		// Colourants that do not span XYZ, such as zeroed tags, would
+		// map every colour onto a line
+		m := t.matrix
+		det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+			m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+			m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+		if math.Abs(det) < 1e-6 {
+			return nil, fmt.Errorf("ICC RGB profile: singular colourant matrix")
+		}
+		p.transform = t
+	case "Lab ":
+		p.transform = iccLabInput{}
+	default:
+		return nil, fmt.Errorf("ICC profile without a supported %s transform", p.ColorSpace)
+	}
+	return p, nil
+}
+
+// ToXYZ converts device values in [0, 1] to D50 XYZ
+func (p *ICCProfile) ToXYZ(in []float64) (x, y, z float64) {
+	buf := make([]float64, p.Channels)
+	for i := range buf {
+		if i < len(in) {
+			buf[i] = clampFloat(in[i], 0, 1)
+		}
+	}
+	v := p.transform.pcs(buf)
+	return v[0], v[1], v[2]
+}
+
+// ToRGB converts device values in [0, 1] to sRGB values in [0, 1]
+func (p *ICCProfile) ToRGB(in []float64) (r, g, b float64) {
+	return xyzD50ToSRGB(p.ToXYZ(in))
+}
+
+// iccGrayTRC is a monochrome profile with a gray tone curve
+type iccGrayTRC struct {
+	trc iccCurve
+}
+
+func (t *iccGrayTRC) pcs(in []float64) [3]float64 {
+	y := t.trc.eval(in[0])
+	return [3]float64{iccD50[0] * y, y, iccD50[2] * y}
+}
+
+// iccMatrixTRC is a three-component matrix/TRC profile
+type iccMatrixTRC struct {
+	trc    [3]iccCurve
+	matrix [3][3]float64
+}
+
+func (t *iccMatrixTRC) pcs(in []float64) [3]float64 {
+	var lin [3]float64
+	for i := range lin {
+		lin[i] = t.trc[i].eval(in[i])
+	}
+	var out [3]float64
+	for row := range out {
+		out[row] = t.matrix[row][0]*lin[0] + t.matrix[row][1]*lin[1] + t.matrix[row][2]*lin[2]
+	}
+	return out
+}
+
+// iccLabInput is an abstract Lab profile without tables; its values are
+// normalized Lab
+type iccLabInput struct{}
+
+func (iccLabInput) pcs(in []float64) [3]float64 {
+	return labToXYZ(in[0]*100, in[1]*255-128, in[2]*255-128, iccD50)
+}
+
+// iccCurve is a one-dimensional tone curve
+type iccCurve interface {
+	eval(x float64) float64
+}
+
+// iccGamma is a pure power curve; gamma 1 is the identity
+type iccGamma float64
+
+func (g iccGamma) eval(x float64) float64 {
+	if g == 1 {
+		return x
+	}
+	return math.Pow(x, float64(g))
+}
+
+// iccTable is a sampled curve, evaluated by linear interpolation
+type iccTable []float64
+
+func (t iccTable) eval(x float64) float64 {
+	return interpolateTable(t, x)
+}
+
+// interpolateTable evaluates equally spaced samples over [0, 1]
+func interpolateTable(t []float64, x float64) float64 {
+	if len(t) == 0 {
+		return x
+	}
+	pos := clampFloat(x, 0, 1) * float64(len(t)-1)
+	i := int(pos)
+	if i >= len(t)-1 {
+		return t[len(t)-1]
+	}
+	f := pos - float64(i)
+	return t[i] + f*(t[i+1]-t[i])
+}
+
+// iccParametric is a parametricCurveType curve
+type iccParametric struct {
+	kind int
+	p    [7]float64 // g, a, b, c, d, e, f
+}
+
+func (c *iccParametric) eval(x float64) float64 {
+	g, a, b, cc, d, e, f := c.p[0], c.p[1], c.p[2], c.p[3], c.p[4], c.p[5], c.p[6]
+	pow := func(v float64) float64 {
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, g)
+	}
+	var y float64
+	switch c.kind {
+	case 0:
+		y = pow(x)
+	case 1:
+		if a != 0 && x >= -b/a {
+			y = pow(a*x + b)
+		}
+	case 2:
+		y = cc
+		if a != 0 && x >= -b/a {
+			y = pow(a*x+b) + cc
+		}
+	case 3:
+		if x >= d {
+			y = pow(a*x + b)
+		} else {
+			y = cc * x
+		}
+	case 4:
+		if x >= d {
+			y = pow(a*x+b) + e
+		} else {
+			y = cc*x + f
+		}
+	}
+	return clampFloat(y, 0, 1)
+}
+
+// parseICCCurveAt parses a curveType or parametricCurveType tag. The
+// returned length is the number of bytes used, padded to four.
+func parseICCCurveAt(data []byte) (iccCurve, int, error) {
+	if len(data) < 12 {
+		return nil, 0, fmt.Errorf("short curve tag")
+	}
+	switch string(data[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		if 12+2*n > len(data) {
+			return nil, 0, fmt.Errorf("short curve table")
+		}
+		size := (12 + 2*n + 3) &^ 3
+		switch n {
+		case 0:
+			return iccGamma(1), size, nil
+		case 1:
+			return iccGamma(float64(binary.BigEndian.Uint16(data[12:])) / 256), size, nil
+		}
+		t := make(iccTable, n)
+		for i := range t {
+			t[i] = float64(binary.BigEndian.Uint16(data[12+2*i:])) / 65535
+		}
+		return t, size, nil
+	case "para":
+		kind := int(binary.BigEndian.Uint16(data[8:]))
+		params := []int{1, 3, 4, 5, 7}
+		if kind >= len(params) {
+			return nil, 0, fmt.Errorf("unknown parametric curve type %d", kind)
+		}
+		n := params[kind]
+		if 12+4*n > len(data) {
+			return nil, 0, fmt.Errorf("short parametric curve")
+		}
+		c := &iccParametric{kind: kind}
+		for i := 0; i < n; i++ {
+			c.p[i] = s15Fixed16(data[12+4*i:])
+		}
+		return c, (12 + 4*n + 3) &^ 3, nil
+	}
+	return nil, 0, fmt.Errorf("unknown curve type %q", data[:4])
+}
+
+// parseICCCurve parses a single curve tag
+func parseICCCurve(data []byte) (iccCurve, error) {
+	c, _, err := parseICCCurveAt(data)
+	return c, err
+}
+
+// parseICCXYZ parses an XYZType tag
+func parseICCXYZ(data []byte) ([3]float64, error) {
+	if len(data) < 20 || string(data[:4]) != "XYZ " {
+		return [3]float64{}, fmt.Errorf("missing XYZ tag")
+	}
+	return [3]float64{s15Fixed16(data[8:]), s15Fixed16(data[12:]), s15Fixed16(data[16:])}, nil
+}
+
+// s15Fixed16 decodes an ICC s15Fixed16Number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// iccLut is a lut8, lut16 or lutAtoB transform. Stages are applied in
+// the order A curves, CLUT, M curves, matrix, B curves; missing stages
+// are skipped.
+type iccLut struct {
+	in, out int
+	aCurves []iccCurve
+	clut    *iccCLUT
+	mCurves []iccCurve
+	matrix  []float64 // 3x3 followed by an offset of 3, or nil
+	bCurves []iccCurve
+	lab     bool // the output is Lab rather than XYZ
+	legacy  bool // lut16 Lab encoding, where 1.0 is 0xFF00
+}
+
+func (l *iccLut) pcs(in []float64) [3]float64 {
+	v := make([]float64, max(l.in, l.out, 3))
+	copy(v, in)
+	apply := func(curves []iccCurve, n int) {
+		for i := 0; i < n && i < len(curves); i++ {
+			v[i] = curves[i].eval(v[i])
+		}
+	}
+
+	apply(l.aCurves, l.in)
+	if l.clut != nil {
+		out := l.clut.eval(v[:l.in])
+		copy(v, out)
+	}
+	apply(l.mCurves, l.out)
+	if len(l.matrix) == 12 {
+		m := l.matrix
+		x, y, z := v[0], v[1], v[2]
+		v[0] = m[0]*x + m[1]*y + m[2]*z + m[9]
+		v[1] = m[3]*x + m[4]*y + m[5]*z + m[10]
+		v[2] = m[6]*x + m[7]*y + m[8]*z + m[11]
+	}
+	apply(l.bCurves, l.out)
+
+	if l.lab {
+		scale := 1.0
+		if l.legacy {
+			scale = 65535.0 / 65280
+		}
+		return labToXYZ(v[0]*scale*100, v[1]*scale*255-128, v[2]*scale*255-128, iccD50)
+	}
+	// XYZ is encoded as u1Fixed15, so 1.0 is 0x8000
+	f := 65535.0 / 32768
+	return [3]float64{v[0] * f, v[1] * f, v[2] * f}
+}
+
+// iccCLUT is a multidimensional colour lookup table with normalized
+// entries
+type iccCLUT struct {
+	grid []int
+	out  int
+	data []float64
+}
+
+// eval interpolates the table multilinearly
+func (c *iccCLUT) eval(in []float64) []float64 {
+	n := len(c.grid)
+	base := make([]int, n)
+	frac := make([]float64, n)
+	strides := make([]int, n)
+	stride := c.out
+	for i := n - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= c.grid[i]
+	}
+	for i := 0; i < n; i++ {
+		pos := clampFloat(in[i], 0, 1) * float64(c.grid[i]-1)
+		base[i] = int(pos)
+		if base[i] >= c.grid[i]-1 {
+			base[i] = max(c.grid[i]-2, 0)
+		}
+		frac[i] = pos - float64(base[i])
+	}
+
+	out := make([]float64, c.out)
+	for corner := 0; corner < 1<<n; corner++ {
+		w := 1.0
+		offset := 0
+		for i := 0; i < n; i++ {
+			idx := base[i]
+			if corner&(1<<i) != 0 {
+				w *= frac[i]
+				if c.grid[i] > 1 {
+					idx++
+				}
+			} else {
+				w *= 1 - frac[i]
+			}
+			offset += idx * strides[i]
+		}
+		if w == 0 {
+			continue
+		}
+		for j := 0; j < c.out; j++ {
+			out[j] += w * c.data[offset+j]
+		}
+	}
+	return out
+}
+
+// parseICCLut parses an AToB tag of type lut8, lut16 or lutAtoB
+func parseICCLut(data []byte, channels int, lab bool) (*iccLut, error) {
+	if len(data) < 32 {
+		return nil, fmt.Errorf("short LUT tag")
+	}
+	l := &iccLut{in: int(data[8]), out: int(data[9]), lab: lab}
+	if l.in != channels || l.out != 3 {
+		return nil, fmt.Errorf("LUT maps %d to %d channels", l.in, l.out)
+	}
+
+	switch string(data[:4]) {
+	case "mft1", "mft2":
+		grid := int(data[10])
+		wide := string(data[:4]) == "mft2"
+		inEntries, outEntries, pos, size := 256, 256, 48, 1
+		if wide {
+			if len(data) < 52 {
+				return nil, fmt.Errorf("short lut16 tag")
+			}
+			inEntries = int(binary.BigEndian.Uint16(data[48:]))
+			outEntries = int(binary.BigEndian.Uint16(data[50:]))
+			pos, size = 52, 2
+			l.legacy = lab
+		}
+		sample := func(p int) float64 {
+			if size == 1 {
+				return float64(data[p]) / 255
+			}
+			return float64(binary.BigEndian.Uint16(data[p:])) / 65535
+		}
+		tables := func(n, entries int) ([]iccCurve, error) {
+			curves := make([]iccCurve, n)
+			if pos+n*entries*size > len(data) {
+				return nil, fmt.Errorf("short LUT tables")
+			}
+			for i := range curves {
+				t := make(iccTable, entries)
+				for j := range t {
+					t[j] = sample(pos)
+					pos += size
+				}
+				curves[i] = t
+			}
+			return curves, nil
+		}
+
+		var err error
+		if l.aCurves, err = tables(l.in, inEntries); err != nil {
+			return nil, err
+		}
+		points := 1
+		for i := 0; i < l.in; i++ {
+			points *= grid
+		}
+		if grid < 2 || pos+points*l.out*size > len(data) {
+			return nil, fmt.Errorf("short LUT grid")
+		}
+		clut := &iccCLUT{out: l.out, data: make([]float64, points*l.out)}
+		for i := 0; i < l.in; i++ {
+			clut.grid = append(clut.grid, grid)
+		}
+		for i := range clut.data {
+			clut.data[i] = sample(pos)
+			pos += size
+		}
+		l.clut = clut
+		if l.bCurves, err = tables(l.out, outEntries); err != nil {
+			return nil, err
+		}
+		return l, nil
+
+	case "mAB ":
+		offsets := make([]int, 5)
+		for i := range offsets {
+			offsets[i] = int(binary.BigEndian.Uint32(data[12+4*i:]))
+		}
+		curves := func(off, n int) ([]iccCurve, error) {
+			if off == 0 {
+				return nil, nil
+			}
+			var out []iccCurve
+			for i := 0; i < n; i++ {
+				if off >= len(data) {
+					return nil, fmt.Errorf("short lutAtoB curves")
+				}
+				c, size, err := parseICCCurveAt(data[off:])
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, c)
+				off += size
+			}
+			return out, nil
+		}
+
+		var err error
+		if l.bCurves, err = curves(offsets[0], l.out); err != nil {
+			return nil, err
+		}
+		if off := offsets[1]; off != 0 {
+			if off+48 > len(data) {
+				return nil, fmt.Errorf("short lutAtoB matrix")
+			}
+			for i := 0; i < 12; i++ {
+				l.matrix = append(l.matrix, s15Fixed16(data[off+4*i:]))
+			}
+		}
+		if l.mCurves, err = curves(offsets[2], l.out); err != nil {
+			return nil, err
+		}
+		if off := offsets[3]; off != 0 {
+			if off+20 > len(data) {
+				return nil, fmt.Errorf("short lutAtoB CLUT")
+			}
+			clut := &iccCLUT{out: l.out}
+			points := 1
+			for i := 0; i < l.in; i++ {
+				g := int(data[off+i])
+				if g < 1 {
+					return nil, fmt.Errorf("invalid CLUT grid")
+				}
+				clut.grid = append(clut.grid, g)
+				points *= g
+			}
+			size := int(data[off+16])
+			pos := off + 20
+			if (size != 1 && size != 2) || pos+points*l.out*size > len(data) {
+				return nil, fmt.Errorf("short lutAtoB CLUT")
+			}
+			clut.data = make([]float64, points*l.out)
+			for i := range clut.data {
+				if size == 1 {
+					clut.data[i] = float64(data[pos]) / 255
+				} else {
+					clut.data[i] = float64(binary.BigEndian.Uint16(data[pos:])) / 65535
+				}
+				pos += size
+			}
+			l.clut = clut
+		}
+		if l.aCurves, err = curves(offsets[4], l.in); err != nil {
+			return nil, err
+		}
+		if l.clut == nil && l.in != l.out {
+			return nil, fmt.Errorf("lutAtoB without CLUT changes channel count")
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("unsupported LUT type %q", data[:4])
+}
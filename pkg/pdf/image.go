@@ -10,6 +10,7 @@ import (
 	"image/png"
 	"io"
 	"math"
+	"strings"
 )
 
 // ImageInfo contains information about an image in a PDF
@@ -32,14 +33,16 @@ type ImageInfo struct {
 	Ratio            int
 	Data             []byte
 	stream           Stream
+	resources        Dictionary // resolve colour space names of inline images
 
 	// Additional fields from Poppler
 	// Image position and transformation
-	X        float64 // X position in page coordinates
-	Y        float64 // Y position in page coordinates
-	ScaleX   float64 // X scale factor
-	ScaleY   float64 // Y scale factor
-	Rotation int     // Rotation angle (0, 90, 180, 270)
+	X        float64    // X position in page coordinates
+	Y        float64    // Y position in page coordinates
+	ScaleX   float64    // X scale factor
+	ScaleY   float64    // Y scale factor
+	Rotation int        // Rotation angle (0, 90, 180, 270)
+	CTM      [6]float64 // maps the image's unit square to page space when drawn
 
 	// Image mask information
 	HasMask    bool  // whether image has a mask
@@ -60,6 +63,9 @@ type ImageInfo struct {
 
 	// Color key masking
 	ColorKeyMask []int // color key mask array
+
+	// Content hash (SHA-256), set by ExtractUniqueImages
+	Hash string
 }
 
 // ImageExtractor extracts images from PDF documents
@@ -72,73 +78,129 @@ func NewImageExtractor(doc *Document) *ImageExtractor {
 	return &ImageExtractor{doc: doc}
 }
 
-// ExtractImages extracts all images from the specified page range
+// ExtractImages extracts the images painted in the specified page range
+// in content stream order: image XObjects, including those inside form
+// XObjects, and inline images. An image painted several times is listed
+// each time.
 func (e *ImageExtractor) ExtractImages(firstPage, lastPage int) ([]*ImageInfo, error) {
 	var images []*ImageInfo
-	imageIndex := 0
 
 	for pageNum := firstPage; pageNum <= lastPage; pageNum++ {
 		page, err := e.doc.GetPage(pageNum)
 		if err != nil {
 			continue
 		}
-
-		// Get page resources
-		resources := page.Resources
-		if resources == nil {
-			continue
-		}
-
-		// Get XObject dictionary
-		xobjectRef := resources.Get("XObject")
-		if xobjectRef == nil {
-			continue
-		}
-
-		xobjectObj, err := e.doc.ResolveObject(xobjectRef)
-		if err != nil {
+		contents, err := page.GetContents()
+		if err != nil || len(contents) == 0 {
 			continue
 		}
+		e.scanContent(contents, page.Resources, IdentityMatrix(), pageNum, &images, 0)
+	}
 
-		xobjects, ok := xobjectObj.(Dictionary)
-		if !ok {
-			continue
-		}
+	return images, nil
+}
 
-		// Iterate through XObjects
-		for name, ref := range xobjects {
-			obj, err := e.doc.ResolveObject(ref)
-			if err != nil {
+// scanContent appends the images painted by a content stream to images
+func (e *ImageExtractor) scanContent(data []byte, resources Dictionary, ctm Matrix, pageNum int, images *[]*ImageInfo, depth int) {
+	ops, _ := NewContentStreamParser(data).ParseOperations()
+	var stack []Matrix
+
+	for _, op := range ops {
+		switch op.Operator {
+		case "q":
+			stack = append(stack, ctm)
+		case "Q":
+			if len(stack) > 0 {
+				ctm = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if len(op.Operands) == 6 {
+				v := make([]float64, 6)
+				for i, o := range op.Operands {
+					v[i] = objectToFloat(o)
+				}
+				ctm = Matrix{v[0], v[1], v[2], v[3], v[4], v[5]}.Multiply(ctm)
+			}
+		case "BI":
+			if len(op.Operands) != 1 {
 				continue
 			}
-
-			stream, ok := obj.(Stream)
+			if stream, ok := op.Operands[0].(Stream); ok {
+				img := e.extractImageInfo(stream, pageNum, len(*images), "", resources)
+				img.Inline = true
+				e.placeImage(img, ctm)
+				*images = append(*images, img)
+			}
+		case "Do":
+			if len(op.Operands) != 1 || resources == nil {
+				continue
+			}
+			name, ok := op.Operands[0].(Name)
 			if !ok {
 				continue
 			}
-
-			// Check if it's an image
-			subtype, _ := stream.Dictionary.GetName("Subtype")
-			if subtype != "Image" {
+			xobjects, ok := resolveDict(e.doc, resources.Get("XObject"))
+			if !ok {
 				continue
 			}
-
-			img := e.extractImageInfo(stream, pageNum, imageIndex, string(name))
-			images = append(images, img)
-			imageIndex++
+			ref := xobjects.Get(string(name))
+			stream, ok := e.doc.resolve(ref).(Stream)
+			if !ok {
+				continue
+			}
+			switch subtype, _ := stream.Dictionary.GetName("Subtype"); subtype {
+			case "Image":
+				img := e.extractImageInfo(stream, pageNum, len(*images), string(name), resources)
+				if r, ok := ref.(Reference); ok {
+					img.ObjectNum, img.Generation = r.ObjectNumber, r.GenerationNumber
+				}
+				e.placeImage(img, ctm)
+				*images = append(*images, img)
+			case "Form":
+				if depth >= maxRasterFormDepth {
+					continue
+				}
+				form, err := stream.Decode()
+				if err != nil {
+					continue
+				}
+				formCTM := ctm
+				if m := e.doc.numberArray(stream.Dictionary.Get("Matrix")); len(m) == 6 {
+					formCTM = Matrix{m[0], m[1], m[2], m[3], m[4], m[5]}.Multiply(ctm)
+				}
+				formResources, ok := resolveDict(e.doc, stream.Dictionary.Get("Resources"))
+				if !ok {
+					formResources = resources
+				}
+				e.scanContent(form, formResources, formCTM, pageNum, images, depth+1)
+			}
 		}
 	}
+}
 
-	return images, nil
+// placeImage records where the unit square of ctm puts an image on the
+// page and its resolution
+func (e *ImageExtractor) placeImage(img *ImageInfo, ctm Matrix) {
+	img.CTM = [6]float64{ctm.A, ctm.B, ctm.C, ctm.D, ctm.E, ctm.F}
+	img.X, img.Y, img.ScaleX, img.ScaleY, img.Rotation = ExtractImageTransform(img.CTM, img.Width, img.Height)
+	if w := math.Hypot(ctm.A, ctm.B); w > 0 {
+		img.XPPI = int(math.Round(float64(img.Width) * 72 / w))
+	}
+	if h := math.Hypot(ctm.C, ctm.D); h > 0 {
+		img.YPPI = int(math.Round(float64(img.Height) * 72 / h))
+	}
 }
 
 // extractImageInfo extracts information about an image
-func (e *ImageExtractor) extractImageInfo(stream Stream, pageNum, index int, _ string) *ImageInfo {
+func (e *ImageExtractor) extractImageInfo(stream Stream, pageNum, index int, name string, resources Dictionary) *ImageInfo {
 	img := &ImageInfo{
-		Page:   pageNum,
-		Index:  index,
-		Type:   "image",
-		stream: stream,
+		Page:      pageNum,
+		Index:     index,
+		Type:      "image",
+		Name:      name,
+		stream:    e.doc.resolveJBIG2Globals(stream),
+		resources: resources,
 	}
 
 	// Get dimensions
@@ -159,12 +221,34 @@ func (e *ImageExtractor) extractImageInfo(stream Stream, pageNum, index int, _ s
 	// Get color space
 	cs := stream.Dictionary.Get("ColorSpace")
 	if cs != nil {
-		img.ColorSpace, img.Components = e.parseColorSpace(cs)
+		img.ColorSpace, img.Components = e.parseColorSpace(cs, resources)
 	} else {
 		img.ColorSpace = "DeviceGray"
 		img.Components = 1
 	}
 
+	// Masks and decoding
+	img.IsMask = IsImageMask(stream.Dictionary)
+	if img.IsMask {
+		img.Type = "stencil"
+		img.ColorSpace = "-"
+		img.Components = 1
+		img.BitsPerComponent = 1
+	}
+	img.Decode = e.doc.numberArray(stream.Dictionary.Get("Decode"))
+	img.Invert = img.IsMask && len(img.Decode) >= 2 && img.Decode[0] > img.Decode[1]
+	img.SMask = stream.Dictionary.Get("SMask")
+	switch mask := e.doc.resolve(stream.Dictionary.Get("Mask")).(type) {
+	case Stream:
+		img.HasMask = true
+	case Array:
+		img.HasMask = true
+		for _, v := range e.doc.numberArray(mask) {
+			img.ColorKeyMask = append(img.ColorKeyMask, int(v))
+		}
+		img.MaskColors = img.ColorKeyMask
+	}
+
 	// Get filter
 	filter := stream.Dictionary.Get("Filter")
 	if filter != nil {
@@ -194,56 +278,27 @@ func (e *ImageExtractor) extractImageInfo(stream Stream, pageNum, index int, _ s
 	return img
 }
 
-// parseColorSpace parses a color space and returns name and component count
-func (e *ImageExtractor) parseColorSpace(cs Object) (string, int) {
-	switch v := cs.(type) {
-	case Name:
-		switch string(v) {
-		case "DeviceGray":
-			return "gray", 1
-		case "DeviceRGB":
-			return "rgb", 3
-		case "DeviceCMYK":
-			return "cmyk", 4
-		}
-		return string(v), 1
-	case Array:
-		if len(v) > 0 {
-			if name, ok := v[0].(Name); ok {
-				switch string(name) {
-				case "ICCBased":
-					if len(v) > 1 {
-						if ref, ok := v[1].(Reference); ok {
-							obj, err := e.doc.GetObject(ref.ObjectNumber)
-							if err == nil {
-								if stream, ok := obj.(Stream); ok {
-									if n, ok := stream.Dictionary.GetInt("N"); ok {
-										switch n {
-										case 1:
-											return "icc-gray", 1
-										case 3:
-											return "icc-rgb", 3
-										case 4:
-											return "icc-cmyk", 4
-										}
-									}
-								}
-							}
-						}
-					}
-					return "icc", 3
-				case "Indexed":
-					return "index", 1
-				case "DeviceN":
-					return "devn", 4
-				case "Separation":
-					return "sep", 1
-				}
-				return string(name), 1
-			}
-		}
+// parseColorSpace parses a color space and returns its pdfimages name and
+// component count
+func (e *ImageExtractor) parseColorSpace(obj Object, resources Dictionary) (string, int) {
+	cs, err := e.doc.ParseColorSpace(obj, resources)
+	if err != nil {
+		return "unknown", 1
 	}
-	return "unknown", 1
+	name := map[string]string{
+		"DeviceGray": "gray",
+		"CalGray":    "gray",
+		"DeviceRGB":  "rgb",
+		"CalRGB":     "rgb",
+		"DeviceCMYK": "cmyk",
+		"Lab":        "lab",
+		"ICCBased":   "icc",
+		"Indexed":    "index",
+		"Separation": "sep",
+		"DeviceN":    "devn",
+		"Pattern":    "pattern",
+	}[cs.Family()]
+	return name, cs.NComponents()
 }
 
 // parseFilter parses filter name
@@ -263,17 +318,17 @@ func (e *ImageExtractor) parseFilter(filter Object) string {
 
 // GetImageData extracts image data in the specified format
 func (e *ImageExtractor) GetImageData(img *ImageInfo, format string) ([]byte, error) {
+	if format == "native" {
+		// Return the encoded data of JPEG, JPEG 2000, JBIG2 and CCITT images
+		return e.GetImageDataWithFormat(img, format, 0)
+	}
+
 	// Decode stream data
 	data, err := img.stream.Decode()
 	if err != nil {
 		return nil, err
 	}
 
-	if format == "native" {
-		// Return raw data for native formats (JPEG, etc.)
-		return data, nil
-	}
-
 	if format == "ppm" {
 		return e.toPPM(img, data)
 	}
@@ -282,36 +337,14 @@ func (e *ImageExtractor) GetImageData(img *ImageInfo, format string) ([]byte, er
 	return e.toPNG(img, data)
 }
 
-// toPNG converts image data to PNG format
+// toPNG converts image data to PNG format. Soft masks, mask streams and
+// colour key masks are written as the alpha channel.
 func (e *ImageExtractor) toPNG(info *ImageInfo, data []byte) ([]byte, error) {
-	var img image.Image
-
-	// Check if data is already in a compressed format (JPEG, JPEG2000)
-	switch info.Filter {
-	case "DCTDecode":
-		// Data is JPEG, decode it first
-		jpegImg, err := jpeg.Decode(bytes.NewReader(data))
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode JPEG: %w", err)
-		}
-		img = jpegImg
-	case "JPXDecode":
-		// JPEG2000 - not supported by standard library, return error
-		return nil, fmt.Errorf("JPEG2000 decoding not supported, use -jp2 flag for native format")
-	default:
-		// Raw pixel data, create image based on color space
-		switch info.ColorSpace {
-		case "gray", "icc-gray", "DeviceGray":
-			img = e.createGrayImage(info, data)
-		case "rgb", "icc-rgb", "DeviceRGB":
-			img = e.createRGBImage(info, data)
-		case "cmyk", "icc-cmyk", "DeviceCMYK":
-			img = e.createCMYKImage(info, data)
-		default:
-			// Try to create grayscale image
-			img = e.createGrayImage(info, data)
-		}
+	img, err := e.decodedImage(info, data)
+	if err != nil {
+		return nil, err
 	}
+	img = e.doc.maskImage(img, info.stream.Dictionary, data)
 
 	var buf bytes.Buffer
 	if err := png.Encode(&buf, img); err != nil {
@@ -321,101 +354,41 @@ func (e *ImageExtractor) toPNG(info *ImageInfo, data []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// toPPM converts image data to PPM format
-func (e *ImageExtractor) toPPM(info *ImageInfo, data []byte) ([]byte, error) {
-	var buf bytes.Buffer
-
-	if info.Components == 1 {
-		// PGM format for grayscale
-		fmt.Fprintf(&buf, "P5\n%d %d\n255\n", info.Width, info.Height)
-	} else {
-		// PPM format for color
-		fmt.Fprintf(&buf, "P6\n%d %d\n255\n", info.Width, info.Height)
-	}
-
-	// Write pixel data
-	switch info.Components {
-	case 1, 3:
-		buf.Write(data)
-	case 4:
-		// Convert CMYK to RGB
-		for i := 0; i+3 < len(data); i += 4 {
-			c, m, y, k := data[i], data[i+1], data[i+2], data[i+3]
-			r := 255 - min(255, int(c)+int(k))
-			g := 255 - min(255, int(m)+int(k))
-			b := 255 - min(255, int(y)+int(k))
-			buf.WriteByte(byte(r))
-			buf.WriteByte(byte(g))
-			buf.WriteByte(byte(b))
-		}
-	}
-
-	return buf.Bytes(), nil
+// decodedImage converts the decoded stream data of an image through its
+// colour space
+func (e *ImageExtractor) decodedImage(info *ImageInfo, data []byte) (image.Image, error) {
+	return e.doc.imageFromData(info.stream.Dictionary, data, info.resources)
 }
 
-// createGrayImage creates a grayscale image
-func (e *ImageExtractor) createGrayImage(info *ImageInfo, data []byte) *image.Gray {
-	img := image.NewGray(image.Rect(0, 0, info.Width, info.Height))
-
-	idx := 0
-	for y := 0; y < info.Height; y++ {
-		for x := 0; x < info.Width; x++ {
-			if idx < len(data) {
-				img.SetGray(x, y, color.Gray{Y: data[idx]})
-				idx++
-			}
-		}
+// toPPM converts image data to PPM format, or PGM for gray images
+func (e *ImageExtractor) toPPM(info *ImageInfo, data []byte) ([]byte, error) {
+	img, err := e.decodedImage(info, data)
+	if err != nil {
+		return nil, err
 	}
 
-	return img
-}
-
-// createRGBImage creates an RGB image
-func (e *ImageExtractor) createRGBImage(info *ImageInfo, data []byte) *image.RGBA {
-	img := image.NewRGBA(image.Rect(0, 0, info.Width, info.Height))
-
-	idx := 0
-	for y := 0; y < info.Height; y++ {
-		for x := 0; x < info.Width; x++ {
-			if idx+2 < len(data) {
-				img.SetRGBA(x, y, color.RGBA{
-					R: data[idx],
-					G: data[idx+1],
-					B: data[idx+2],
-					A: 255,
-				})
-				idx += 3
-			}
-		}
+	var buf bytes.Buffer
+	bounds := img.Bounds()
+	gray := color.GrayModel
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		fmt.Fprintf(&buf, "P5\n%d %d\n255\n", bounds.Dx(), bounds.Dy())
+	default:
+		fmt.Fprintf(&buf, "P6\n%d %d\n255\n", bounds.Dx(), bounds.Dy())
+		gray = nil
 	}
-
-	return img
-}
-
-// createCMYKImage creates an image from CMYK data (converted to RGB)
-func (e *ImageExtractor) createCMYKImage(info *ImageInfo, data []byte) *image.RGBA {
-	img := image.NewRGBA(image.Rect(0, 0, info.Width, info.Height))
-
-	idx := 0
-	for y := 0; y < info.Height; y++ {
-		for x := 0; x < info.Width; x++ {
-			if idx+3 < len(data) {
-				c, m, y_, k := data[idx], data[idx+1], data[idx+2], data[idx+3]
-				r := 255 - min(255, int(c)+int(k))
-				g := 255 - min(255, int(m)+int(k))
-				b := 255 - min(255, int(y_)+int(k))
-				img.SetRGBA(x, y, color.RGBA{
-					R: byte(r),
-					G: byte(g),
-					B: byte(b),
-					A: 255,
-				})
-				idx += 4
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.At(x, y)
+			if gray != nil {
+				buf.WriteByte(gray.Convert(c).(color.Gray).Y)
+				continue
 			}
+			r, g, b, _ := c.RGBA()
+			buf.Write([]byte{byte(r >> 8), byte(g >> 8), byte(b >> 8)})
 		}
 	}
-
-	return img
+	return buf.Bytes(), nil
 }
 
 // toJPEG converts image data to JPEG format
@@ -425,17 +398,9 @@ func (e *ImageExtractor) toJPEG(info *ImageInfo, data []byte, quality int) ([]by
 		return data, nil
 	}
 
-	var img image.Image
-
-	switch info.ColorSpace {
-	case "gray", "icc-gray", "DeviceGray":
-		img = e.createGrayImage(info, data)
-	case "rgb", "icc-rgb", "DeviceRGB":
-		img = e.createRGBImage(info, data)
-	case "cmyk", "icc-cmyk", "DeviceCMYK":
-		img = e.createCMYKImage(info, data)
-	default:
-		img = e.createGrayImage(info, data)
+	img, err := e.decodedImage(info, data)
+	if err != nil {
+		return nil, err
 	}
 
 	var buf bytes.Buffer
@@ -449,28 +414,10 @@ func (e *ImageExtractor) toJPEG(info *ImageInfo, data []byte, quality int) ([]by
 
 // toTIFF converts image data to TIFF format
 func (e *ImageExtractor) toTIFF(info *ImageInfo, data []byte) ([]byte, error) {
-	var img image.Image
-
-	switch info.Filter {
-	case "DCTDecode":
-		jpegImg, err := jpeg.Decode(bytes.NewReader(data))
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode JPEG: %w", err)
-		}
-		img = jpegImg
-	default:
-		switch info.ColorSpace {
-		case "gray", "icc-gray", "DeviceGray":
-			img = e.createGrayImage(info, data)
-		case "rgb", "icc-rgb", "DeviceRGB":
-			img = e.createRGBImage(info, data)
-		case "cmyk", "icc-cmyk", "DeviceCMYK":
-			img = e.createCMYKImage(info, data)
-		default:
-			img = e.createGrayImage(info, data)
-		}
+	img, err := e.decodedImage(info, data)
+	if err != nil {
+		return nil, err
 	}
-
 	return encodeTIFF(img)
 }
 
@@ -551,6 +498,15 @@ func writeTIFFTag(buf *bytes.Buffer, tag, typ uint16, count, value uint32) {
 
 // GetImageDataWithFormat extracts image data in the specified format with options
 func (e *ImageExtractor) GetImageDataWithFormat(img *ImageInfo, format string, quality int) ([]byte, error) {
+	if format == "native" {
+		native, err := e.GetNativeImage(img)
+		if err != nil {
+			return nil, err
+		}
+		if native != nil {
+			return native.Data, nil
+		}
+	}
 	data, err := img.stream.Decode()
 	if err != nil {
 		return nil, err
@@ -570,6 +526,179 @@ func (e *ImageExtractor) GetImageDataWithFormat(img *ImageInfo, format string, q
 	}
 }
 
+// NativeImage is an image in the file format of its image filter, as
+// pdfimages -all writes it
+type NativeImage struct {
+	Ext     string // file extension: jpg, jp2, jb2e or ccitt
+	Data    []byte // the encoded image data
+	Globals []byte // JBIG2 globals segments, written as .jb2g
+	Params  string // fax2tiff options describing CCITT data
+}
+
+// nativeImageExtensions maps image filters to the extensions of their
+// native files
+var nativeImageExtensions = map[Name]string{
+	"DCTDecode":      "jpg",
+	"JPXDecode":      "jp2",
+	"JBIG2Decode":    "jb2e",
+	"CCITTFaxDecode": "ccitt",
+}
+
+// GetNativeImage returns the data of a DCT, JPX, JBIG2 or CCITT image with
+// its image encoding untouched; only filters applied on top of it, such as
+// FlateDecode, are removed. It returns nil for images stored as samples.
+func (e *ImageExtractor) GetNativeImage(img *ImageInfo) (*NativeImage, error) {
+	dict := img.stream.Dictionary
+	filter := imageFilter(dict)
+	ext, ok := nativeImageExtensions[filter]
+	if !ok {
+		return nil, nil
+	}
+
+	native := &NativeImage{Ext: ext, Data: img.stream.Data}
+	filters, _ := resolveArray(e.doc, dict.Get("Filter"))
+	params, _ := resolveArray(e.doc, dict.Get("DecodeParms"))
+	if len(filters) > 1 {
+		outer := make(Dictionary, len(dict))
+		for k, v := range dict {
+			outer[k] = v
+		}
+		outer["Filter"] = filters[:len(filters)-1]
+		delete(outer, "DecodeParms")
+		if len(params) >= len(filters) {
+			outer["DecodeParms"] = params[:len(filters)-1]
+		}
+		data, err := Stream{Dictionary: outer, Data: img.stream.Data}.Decode()
+		if err != nil {
+			return nil, err
+		}
+		native.Data = data
+	}
+
+	// Parameters of the image filter itself
+	var parms Dictionary
+	if n := max(len(filters), 1); len(params) >= n {
+		parms, _ = resolveDict(e.doc, params[n-1])
+	} else {
+		parms, _ = resolveDict(e.doc, dict.Get("DecodeParms"))
+	}
+
+	switch filter {
+	case "JBIG2Decode":
+		if globals, ok := e.doc.resolve(parms.Get("JBIG2Globals")).(Stream); ok {
+			data, err := globals.Decode()
+			if err != nil {
+				return nil, err
+			}
+			native.Globals = data
+		}
+	case "CCITTFaxDecode":
+		native.Params = ccittParams(parms)
+	}
+	return native, nil
+}
+
+// ccittParams describes CCITT filter parameters as fax2tiff options, in
+// the format of poppler's .params files
+func ccittParams(parms Dictionary) string {
+	var opts []string
+	k, _ := parms.GetInt("K")
+	switch {
+	case k < 0:
+		opts = append(opts, "-4")
+	case k == 0:
+		opts = append(opts, "-1")
+	default:
+		opts = append(opts, "-2")
+	}
+	if align, _ := parms.Get("EncodedByteAlign").(Boolean); align {
+		opts = append(opts, "-A")
+	} else {
+		opts = append(opts, "-P")
+	}
+	columns, ok := parms.GetInt("Columns")
+	if !ok {
+		columns = 1728
+	}
+	opts = append(opts, fmt.Sprintf("-X %d", columns))
+	if black, _ := parms.Get("BlackIs1").(Boolean); black {
+		opts = append(opts, "-W")
+	} else {
+		opts = append(opts, "-B")
+	}
+	// PDF stores the bits MSB first
+	opts = append(opts, "-M")
+	return strings.Join(opts, " ") + "\n"
+}
+
+// decodeImage decodes an image XObject, converting its samples through
+// the image's colour space. resources resolve colour space names of
+// inline images and may be nil.
+func (d *Document) decodeImage(stream Stream, resources Dictionary) (image.Image, error) {
+	data, err := d.resolveJBIG2Globals(stream).Decode()
+	if err != nil {
+		return nil, err
+	}
+	return d.imageFromData(stream.Dictionary, data, resources)
+}
+
+// imageFromData converts the decoded data of an image stream. DCT and
+// JPEG 2000 data are decompressed first; other data holds packed samples.
+func (d *Document) imageFromData(dict Dictionary, data []byte, resources Dictionary) (image.Image, error) {
+	width, _ := dict.GetInt("Width")
+	height, _ := dict.GetInt("Height")
+	bpc, ok := dict.GetInt("BitsPerComponent")
+	if !ok {
+		bpc = 8
+	}
+
+	var cs ColorSpace
+	if mask, ok := dict.Get("ImageMask").(Boolean); ok && bool(mask) {
+		cs, bpc = DeviceGray, 1
+	} else if obj := dict.Get("ColorSpace"); obj != nil {
+		var err error
+		if cs, err = d.ParseColorSpace(obj, resources); err != nil {
+			return nil, err
+		}
+	}
+	decode := d.numberArray(dict.Get("Decode"))
+
+	switch imageFilter(dict) {
+	case "DCTDecode":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JPEG: %w", err)
+		}
+		return convertDecodedImage(img, cs, decode), nil
+	case "JPXDecode":
+		smaskInData, _ := dict.GetInt("SMaskInData")
+		img, err := decodeJPXImage(data, int(smaskInData), cs, decode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JPEG2000: %w", err)
+		}
+		return img, nil
+	}
+	if cs == nil {
+		cs = DeviceGray
+	}
+	return colorImage(cs, data, int(width), int(height), int(bpc), decode)
+}
+
+// imageFilter returns the last filter of an image stream, which decides
+// the format of the decoded data
+func imageFilter(dict Dictionary) Name {
+	switch f := dict.Get("Filter").(type) {
+	case Name:
+		return f
+	case Array:
+		if len(f) > 0 {
+			name, _ := f[len(f)-1].(Name)
+			return name
+		}
+	}
+	return ""
+}
+
 // DecodeFlate decodes Flate (zlib) compressed data
 func DecodeFlate(data []byte) ([]byte, error) {
 	r, err := zlib.NewReader(bytes.NewReader(data))
@@ -857,13 +986,67 @@ func (d *SimpleImageOutputDevice) Clear() {
 
 // GetBBox returns the bounding box of the image in page coordinates
 func (img *ImageInfo) GetBBox() (xMin, yMin, xMax, yMax float64) {
-	xMin = img.X
-	yMin = img.Y
-	xMax = img.X + float64(img.Width)*img.ScaleX
-	yMax = img.Y + float64(img.Height)*img.ScaleY
+	m := Matrix{img.CTM[0], img.CTM[1], img.CTM[2], img.CTM[3], img.CTM[4], img.CTM[5]}
+	xMin, yMin = math.Inf(1), math.Inf(1)
+	xMax, yMax = math.Inf(-1), math.Inf(-1)
+	for _, p := range []Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		d := m.TransformPoint(p)
+		xMin, xMax = math.Min(xMin, d.X), math.Max(xMax, d.X)
+		yMin, yMax = math.Min(yMin, d.Y), math.Max(yMax, d.Y)
+	}
 	return
 }
 
+// ImagePlacement describes an extracted image and where it is painted on
+// its page, in the form pdfimages writes to its JSON sidecar
+type ImagePlacement struct {
+	File       string     `json:"file,omitempty"`
+	Page       int        `json:"page"`
+	Index      int        `json:"num"`
+	Name       string     `json:"name,omitempty"`
+	ObjectNum  int        `json:"object,omitempty"`
+	Generation int        `json:"generation,omitempty"`
+	Inline     bool       `json:"inline,omitempty"`
+	Hash       string     `json:"hash,omitempty"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	X          float64    `json:"x"`
+	Y          float64    `json:"y"`
+	ScaleX     float64    `json:"scaleX"`
+	ScaleY     float64    `json:"scaleY"`
+	Rotation   int        `json:"rotation"`
+	XPPI       int        `json:"xppi"`
+	YPPI       int        `json:"yppi"`
+	CTM        [6]float64 `json:"ctm"`
+	BBox       [4]float64 `json:"bbox"`
+}
+
+// Placement returns the placement of the image, as computed from the CTM
+// in effect when it was drawn
+func (img *ImageInfo) Placement() ImagePlacement {
+	p := ImagePlacement{
+		Page:       img.Page,
+		Index:      img.Index,
+		Name:       img.Name,
+		ObjectNum:  img.ObjectNum,
+		Generation: img.Generation,
+		Inline:     img.Inline,
+		Hash:       img.Hash,
+		Width:      img.Width,
+		Height:     img.Height,
+		X:          img.X,
+		Y:          img.Y,
+		ScaleX:     img.ScaleX,
+		ScaleY:     img.ScaleY,
+		Rotation:   img.Rotation,
+		XPPI:       img.XPPI,
+		YPPI:       img.YPPI,
+		CTM:        img.CTM,
+	}
+	p.BBox[0], p.BBox[1], p.BBox[2], p.BBox[3] = img.GetBBox()
+	return p
+}
+
 // GetDPI returns the DPI (dots per inch) of the image
 func (img *ImageInfo) GetDPI() (xDPI, yDPI float64) {
 	if img.ScaleX != 0 {
@@ -0,0 +1,136 @@
+package pdf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"sort"
+)
+
+// maxImageHashDepth bounds the references followed while hashing an image
+const maxImageHashDepth = 8
+
+// UniqueImage is an image painted one or more times in a page range
+type UniqueImage struct {
+	*ImageInfo              // the first use
+	Uses       []*ImageInfo // every use in content order, the first included
+}
+
+// Pages returns the pages the image is painted on in ascending order
+func (u *UniqueImage) Pages() []int {
+	var pages []int
+	seen := map[int]bool{}
+	for _, use := range u.Uses {
+		if !seen[use.Page] {
+			seen[use.Page] = true
+			pages = append(pages, use.Page)
+		}
+	}
+	sort.Ints(pages)
+	return pages
+}
+
+// Placements returns where each use of the image is painted
+func (u *UniqueImage) Placements() []ImagePlacement {
+	placements := make([]ImagePlacement, len(u.Uses))
+	for i, use := range u.Uses {
+		placements[i] = use.Placement()
+	}
+	return placements
+}
+
+// ExtractUniqueImages extracts the images painted in the specified page
+// range once each. Uses of the same image XObject and images with identical
+// content, such as copies of a logo in separate objects or repeated inline
+// images, are grouped together. Each image object is hashed only once.
+func (e *ImageExtractor) ExtractUniqueImages(firstPage, lastPage int) ([]*UniqueImage, error) {
+	images, err := e.ExtractImages(firstPage, lastPage)
+	if err != nil {
+		return nil, err
+	}
+
+	var unique []*UniqueImage
+	byHash := map[string]*UniqueImage{}
+	objectHashes := map[Reference]string{}
+	for _, img := range images {
+		ref := Reference{ObjectNumber: img.ObjectNum, GenerationNumber: img.Generation}
+		if h, ok := objectHashes[ref]; ok && !img.Inline {
+			img.Hash = h
+		} else {
+			img.Hash = e.imageHash(img)
+			if !img.Inline && img.ObjectNum > 0 {
+				objectHashes[ref] = img.Hash
+			}
+		}
+
+		u := byHash[img.Hash]
+		if u == nil {
+			u = &UniqueImage{ImageInfo: img}
+			byHash[img.Hash] = u
+			unique = append(unique, u)
+		}
+		u.Uses = append(u.Uses, img)
+	}
+	return unique, nil
+}
+
+// imageHash returns the SHA-256 of an image's dictionary and data with
+// references resolved. Colour space names of inline images are looked up
+// in the resources they were painted with.
+func (e *ImageExtractor) imageHash(img *ImageInfo) string {
+	h := sha256.New()
+	dict := make(Dictionary, len(img.stream.Dictionary))
+	for k, v := range img.stream.Dictionary {
+		switch k {
+		case "Length", "Name":
+			// Storage details that do not change the image
+		default:
+			dict[k] = v
+		}
+	}
+	if name, ok := dict.Get("ColorSpace").(Name); ok && img.resources != nil {
+		if spaces, ok := resolveDict(e.doc, img.resources.Get("ColorSpace")); ok {
+			if cs := spaces.Get(string(name)); cs != nil {
+				dict["ColorSpace"] = cs
+			}
+		}
+	}
+	e.hashObject(h, dict, 0)
+	h.Write(img.stream.Data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashObject writes obj to h with references resolved, so that copies of
+// the same content in different objects hash alike
+func (e *ImageExtractor) hashObject(h hash.Hash, obj Object, depth int) {
+	if depth < maxImageHashDepth {
+		obj = e.doc.resolve(obj)
+	}
+	switch v := obj.(type) {
+	case Array:
+		h.Write([]byte{'['})
+		for _, elem := range v {
+			e.hashObject(h, elem, depth+1)
+			h.Write([]byte{' '})
+		}
+		h.Write([]byte{']'})
+	case Dictionary:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		h.Write([]byte("<<"))
+		for _, k := range keys {
+			h.Write(serializeObject(Name(k)))
+			h.Write([]byte{' '})
+			e.hashObject(h, v[Name(k)], depth+1)
+		}
+		h.Write([]byte(">>"))
+	case Stream:
+		e.hashObject(h, v.Dictionary, depth+1)
+		h.Write(v.Data)
+	default:
+		h.Write(serializeObject(v))
+	}
+}
@@ -0,0 +1,240 @@
+package pdf
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// maxNearestUpscale is the magnification from which images without
+// /Interpolate are drawn with hard pixel edges, as poppler does, so that
+// upscaled scans, barcodes and pixel art keep their edges
+const maxNearestUpscale = 4
+
+// imageFilterMode selects how source pixels are sampled
+type imageFilterMode int
+
+const (
+	filterNearest imageFilterMode = iota
+	filterBilinear
+	filterBicubic
+	filterBox
+)
+
+// imageSampler samples a decoded image in premultiplied 8-bit RGBA. Source
+// coordinates are continuous: pixel (i, j) covers [i, i+1) × [j, j+1).
+type imageSampler struct {
+	pix  *image.RGBA
+	w, h int
+}
+
+func newImageSampler(src image.Image) *imageSampler {
+	b := src.Bounds()
+	pix, ok := src.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		pix = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(pix, pix.Rect, src, b.Min, draw.Src)
+	}
+	return &imageSampler{pix: pix, w: b.Dx(), h: b.Dy()}
+}
+
+// at returns the premultiplied components of a pixel, clamping the
+// coordinates to the image
+func (s *imageSampler) at(x, y int) [4]float64 {
+	x = min(max(x, 0), s.w-1)
+	y = min(max(y, 0), s.h-1)
+	p := s.pix.Pix[y*s.pix.Stride+4*x:]
+	return [4]float64{float64(p[0]), float64(p[1]), float64(p[2]), float64(p[3])}
+}
+
+// nearest returns the pixel under (sx, sy)
+func (s *imageSampler) nearest(sx, sy float64) [4]float64 {
+	return s.at(int(math.Floor(sx)), int(math.Floor(sy)))
+}
+
+// bilinear interpolates between the four pixel centres around (sx, sy)
+func (s *imageSampler) bilinear(sx, sy float64) [4]float64 {
+	fx, fy := sx-0.5, sy-0.5
+	x0, y0 := math.Floor(fx), math.Floor(fy)
+	tx, ty := fx-x0, fy-y0
+	i, j := int(x0), int(y0)
+	var out [4]float64
+	c00, c10, c01, c11 := s.at(i, j), s.at(i+1, j), s.at(i, j+1), s.at(i+1, j+1)
+	for k := range out {
+		top := c00[k] + (c10[k]-c00[k])*tx
+		bottom := c01[k] + (c11[k]-c01[k])*tx
+		out[k] = top + (bottom-top)*ty
+	}
+	return out
+}
+
+// bicubic interpolates the 4 × 4 pixel centres around (sx, sy) with the
+// Catmull-Rom spline
+func (s *imageSampler) bicubic(sx, sy float64) [4]float64 {
+	fx, fy := sx-0.5, sy-0.5
+	x0, y0 := math.Floor(fx), math.Floor(fy)
+	wx, wy := cubicWeights(fx-x0), cubicWeights(fy-y0)
+	i, j := int(x0), int(y0)
+	var out [4]float64
+	for n := 0; n < 4; n++ {
+		var row [4]float64
+		for m := 0; m < 4; m++ {
+			c := s.at(i+m-1, j+n-1)
+			for k := range row {
+				row[k] += c[k] * wx[m]
+			}
+		}
+		for k := range out {
+			out[k] += row[k] * wy[n]
+		}
+	}
+	// Overshoot must stay a valid premultiplied colour
+	out[3] = clampFloat(out[3], 0, 255)
+	for k := 0; k < 3; k++ {
+		out[k] = clampFloat(out[k], 0, out[3])
+	}
+	return out
+}
+
+// cubicWeights returns the Catmull-Rom weights of the pixels at offsets
+// -1, 0, 1 and 2 from a sample t ∈ [0, 1) past pixel 0
+func cubicWeights(t float64) [4]float64 {
+	t2, t3 := t*t, t*t*t
+	return [4]float64{
+		(-t3 + 2*t2 - t) / 2,
+		(3*t3 - 5*t2 + 2) / 2,
+		(-3*t3 + 4*t2 + t) / 2,
+		(t3 - t2) / 2,
+	}
+}
+
+// box averages the pixels under the rectangle [x0, x1) × [y0, y1),
+// weighting partly covered pixels by their covered area
+func (s *imageSampler) box(x0, y0, x1, y1 float64) [4]float64 {
+	x0, x1 = math.Max(x0, 0), math.Min(x1, float64(s.w))
+	y0, y1 = math.Max(y0, 0), math.Min(y1, float64(s.h))
+	if x1 <= x0 || y1 <= y0 {
+		return s.nearest(x0, y0)
+	}
+	var sum [4]float64
+	total := 0.0
+	for j := int(y0); float64(j) < y1; j++ {
+		wy := math.Min(float64(j+1), y1) - math.Max(float64(j), y0)
+		row := s.pix.Pix[j*s.pix.Stride:]
+		for i := int(x0); float64(i) < x1; i++ {
+			w := wy * (math.Min(float64(i+1), x1) - math.Max(float64(i), x0))
+			p := row[4*i:]
+			sum[0] += float64(p[0]) * w
+			sum[1] += float64(p[1]) * w
+			sum[2] += float64(p[2]) * w
+			sum[3] += float64(p[3]) * w
+			total += w
+		}
+	}
+	for k := range sum {
+		sum[k] /= total
+	}
+	return sum
+}
+
+// drawTransformedImage composites src into the unit square mapped to device
+// space by ctm, as the Do operator paints images. Every device pixel whose
+// centre falls in the image is sampled through the inverse of ctm:
+// magnified images are interpolated, bicubically when interpolate is set
+// (/Interpolate), and minified images are averaged over the pixel's
+// footprint. The result is scaled by alpha and by the clip coverage, which
+// may be nil.
+func drawTransformedImage(dst *image.RGBA, src image.Image, ctm Matrix, clip *coverageMask, alpha float64, interpolate bool) {
+	b := src.Bounds()
+	if b.Empty() || alpha <= 0 {
+		return
+	}
+	inv, ok := ctm.Invert()
+	if !ok {
+		return
+	}
+
+	// Device bounding box of the unit square
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range []Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		d := ctm.TransformPoint(p)
+		minX, maxX = math.Min(minX, d.X), math.Max(maxX, d.X)
+		minY, maxY = math.Min(minY, d.Y), math.Max(maxY, d.Y)
+	}
+	rect := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX)), int(math.Ceil(maxY))).Intersect(dst.Bounds())
+	if clip != nil {
+		rect = rect.Intersect(clip.rect)
+	}
+	if rect.Empty() {
+		return
+	}
+
+	// Image space runs top to bottom, so source coordinates are
+	// (u × w, (1 - v) × h) for unit square coordinates (u, v)
+	s := newImageSampler(src)
+	w, h := float64(s.w), float64(s.h)
+	toSource := inv.Multiply(Matrix{w, 0, 0, -h, 0, h})
+
+	// The extent of a device pixel's footprint in source pixels decides
+	// between magnification and minification
+	spanX := math.Abs(toSource.A) + math.Abs(toSource.C)
+	spanY := math.Abs(toSource.B) + math.Abs(toSource.D)
+	mode := filterBilinear
+	switch {
+	case spanX > 1 || spanY > 1:
+		mode = filterBox
+	case interpolate:
+		mode = filterBicubic
+	case spanX <= 1.0/maxNearestUpscale || spanY <= 1.0/maxNearestUpscale:
+		mode = filterNearest
+	}
+	halfX, halfY := math.Max(spanX, 1)/2, math.Max(spanY, 1)/2
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			sx, sy := toSource.Transform(float64(x)+0.5, float64(y)+0.5)
+			if sx < 0 || sx >= w || sy < 0 || sy >= h {
+				continue
+			}
+			k := alpha
+			if clip != nil {
+				k *= float64(clip.at(x, y))
+			}
+			if k <= 0 {
+				continue
+			}
+
+			var c [4]float64
+			switch mode {
+			case filterNearest:
+				c = s.nearest(sx, sy)
+			case filterBilinear:
+				c = s.bilinear(sx, sy)
+			case filterBicubic:
+				c = s.bicubic(sx, sy)
+			case filterBox:
+				c = s.box(sx-halfX, sy-halfY, sx+halfX, sy+halfY)
+			}
+			if c[3] <= 0 {
+				continue
+			}
+
+			// Premultiplied source over the destination
+			d := dst.RGBAAt(x, y)
+			rest := 1 - c[3]/255*k
+			blend := func(s float64, d uint8) uint8 {
+				return uint8(clampFloat(math.Round(s*k+float64(d)*rest), 0, 255))
+			}
+			dst.SetRGBA(x, y, color.RGBA{blend(c[0], d.R), blend(c[1], d.G), blend(c[2], d.B), blend(c[3], d.A)})
+		}
+	}
+}
+
+// imageInterpolates reports whether an image asks for smooth upsampling
+func imageInterpolates(dict Dictionary) bool {
+	v, _ := dict.Get("Interpolate").(Boolean)
+	return bool(v)
+}
@@ -0,0 +1,249 @@
+package pdf
+
+import (
+	"image"
+	"image/color"
+)
+
+// paintableImage decodes an image XObject for painting. Soft masks, mask
+// streams and colour key masks become the alpha channel; stencil masks
+// (/ImageMask) are painted with fill. resources resolve colour space names
+// of inline images and may be nil.
+func (d *Document) paintableImage(stream Stream, resources Dictionary, fill color.RGBA) (image.Image, error) {
+	data, err := d.resolveJBIG2Globals(stream).Decode()
+	if err != nil {
+		return nil, err
+	}
+	dict := stream.Dictionary
+	if IsImageMask(dict) {
+		width, _ := dict.GetInt("Width")
+		height, _ := dict.GetInt("Height")
+		alpha := stencilAlpha(data, int(width), int(height), d.numberArray(dict.Get("Decode")))
+		out := image.NewNRGBA(alpha.Rect)
+		for i, a := range alpha.Pix {
+			copy(out.Pix[4*i:], []uint8{fill.R, fill.G, fill.B, a})
+		}
+		return out, nil
+	}
+	img, err := d.imageFromData(dict, data, resources)
+	if err != nil {
+		return nil, err
+	}
+	return d.maskImage(img, dict, data), nil
+}
+
+// maskImage applies the /SMask, /Mask stream or /Mask colour key array of
+// an image to img, which was decoded from data. Images without a mask are
+// returned unchanged.
+func (d *Document) maskImage(img image.Image, dict Dictionary, data []byte) image.Image {
+	b := img.Bounds()
+	var alpha *image.Alpha
+	var matte []float64
+	if smask, ok := d.resolve(dict.Get("SMask")).(Stream); ok {
+		alpha, matte = d.softMaskAlpha(smask, b.Dx(), b.Dy())
+	} else {
+		switch mask := d.resolve(dict.Get("Mask")).(type) {
+		case Stream:
+			alpha = d.explicitMaskAlpha(mask, b.Dx(), b.Dy())
+		case Array:
+			alpha = d.colorKeyAlpha(dict, mask, data, b.Dx(), b.Dy())
+		}
+	}
+	if alpha == nil {
+		return img
+	}
+
+	var matteRGB []float64
+	if len(matte) > 0 {
+		if cs, err := d.ParseColorSpace(dict.Get("ColorSpace"), nil); err == nil && len(matte) >= cs.NComponents() {
+			r, g, b := cs.RGB(matte)
+			matteRGB = []float64{r, g, b}
+		}
+	}
+	// unmatte undoes the premultiplication of a colour with the matte
+	unmatte := func(c, m, a float64) float64 {
+		if a == 0 {
+			return c
+		}
+		return clampFloat(m+(c-m)/a, 0, 1)
+	}
+
+	if is16BitImage(img) {
+		out := image.NewNRGBA64(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				c := color.NRGBA64Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64)
+				a := float64(alpha.Pix[y*alpha.Stride+x]) / 255
+				if matteRGB != nil {
+					c.R = uint16(unmatte(float64(c.R)/65535, matteRGB[0], a)*65535 + 0.5)
+					c.G = uint16(unmatte(float64(c.G)/65535, matteRGB[1], a)*65535 + 0.5)
+					c.B = uint16(unmatte(float64(c.B)/65535, matteRGB[2], a)*65535 + 0.5)
+				}
+				c.A = uint16(float64(c.A) * a)
+				out.SetNRGBA64(x, y, c)
+			}
+		}
+		return out
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			a := float64(alpha.Pix[y*alpha.Stride+x]) / 255
+			if matteRGB != nil {
+				c.R = to8bit(unmatte(float64(c.R)/255, matteRGB[0], a))
+				c.G = to8bit(unmatte(float64(c.G)/255, matteRGB[1], a))
+				c.B = to8bit(unmatte(float64(c.B)/255, matteRGB[2], a))
+			}
+			c.A = uint8(float64(c.A)*a + 0.5)
+			out.SetNRGBA(x, y, c)
+		}
+	}
+	return out
+}
+
+// is16BitImage reports whether img holds 16-bit samples
+func is16BitImage(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		return true
+	}
+	return false
+}
+
+// softMaskAlpha decodes a soft mask image, scaled to width × height, and
+// its /Matte colour
+func (d *Document) softMaskAlpha(smask Stream, width, height int) (*image.Alpha, []float64) {
+	dict := make(Dictionary, len(smask.Dictionary))
+	for k, v := range smask.Dictionary {
+		dict[k] = v
+	}
+	// A soft mask is always a gray image
+	dict["ColorSpace"] = Name("DeviceGray")
+	delete(dict, "ImageMask")
+	img, err := d.decodeImage(Stream{Dictionary: dict, Data: smask.Data}, nil)
+	if err != nil {
+		return nil, nil
+	}
+	b := img.Bounds()
+	alpha := image.NewAlpha(b.Sub(b.Min))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			alpha.Pix[y*alpha.Stride+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+		}
+	}
+	return scaleAlpha(alpha, width, height), d.numberArray(smask.Dictionary.Get("Matte"))
+}
+
+// explicitMaskAlpha decodes a /Mask stream, scaled to width × height
+func (d *Document) explicitMaskAlpha(mask Stream, width, height int) *image.Alpha {
+	data, err := d.resolveJBIG2Globals(mask).Decode()
+	if err != nil {
+		return nil
+	}
+	w, _ := mask.Dictionary.GetInt("Width")
+	h, _ := mask.Dictionary.GetInt("Height")
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+	alpha := stencilAlpha(data, int(w), int(h), d.numberArray(mask.Dictionary.Get("Decode")))
+	return scaleAlpha(alpha, width, height)
+}
+
+// stencilAlpha unpacks a 1-bit stencil mask. Samples of 0 are painted,
+// unless the Decode array is [1 0].
+func stencilAlpha(data []byte, width, height int, decode []float64) *image.Alpha {
+	alpha := image.NewAlpha(image.Rect(0, 0, max(width, 0), max(height, 0)))
+	var paint byte
+	if len(decode) >= 2 && decode[0] > decode[1] {
+		paint = 1
+	}
+	stride := (width + 7) / 8
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			bit := byte(0)
+			if i := y*stride + x/8; i < len(data) {
+				bit = data[i] >> (7 - x%8) & 1
+			}
+			if bit == paint {
+				alpha.Pix[y*alpha.Stride+x] = 255
+			}
+		}
+	}
+	return alpha
+}
+
+// colorKeyAlpha masks out the pixels whose raw samples all lie in the
+// ranges of a /Mask array. Only images stored as packed samples are
+// masked; DCT and JPEG 2000 images are left opaque.
+func (d *Document) colorKeyAlpha(dict Dictionary, mask Array, data []byte, width, height int) *image.Alpha {
+	switch imageFilter(dict) {
+	case "DCTDecode", "JPXDecode":
+		return nil
+	}
+	cs, err := d.ParseColorSpace(dict.Get("ColorSpace"), nil)
+	if err != nil {
+		return nil
+	}
+	n := cs.NComponents()
+	ranges := d.numberArray(mask)
+	if len(ranges) < 2*n {
+		return nil
+	}
+	bpc, ok := dict.GetInt("BitsPerComponent")
+	if !ok {
+		bpc = 8
+	}
+	stride := (width*n*int(bpc) + 7) / 8
+
+	alpha := image.NewAlpha(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			keyed := true
+			for i := 0; i < n && keyed; i++ {
+				v := float64(packedSample(data, y*stride, (x*n+i)*int(bpc), int(bpc)))
+				keyed = v >= ranges[2*i] && v <= ranges[2*i+1]
+			}
+			if !keyed {
+				alpha.Pix[y*alpha.Stride+x] = 255
+			}
+		}
+	}
+	return alpha
+}
+
+// packedSample reads the bpc-bit sample starting bit bits into the row at
+// offset rowStart of data. Samples past the end of data read as 0.
+func packedSample(data []byte, rowStart, bit, bpc int) uint32 {
+	var v uint32
+	for read := 0; read < bpc; {
+		i := rowStart + (bit+read)/8
+		if i >= len(data) {
+			return 0
+		}
+		shift := (bit + read) % 8
+		take := min(8-shift, bpc-read)
+		v = v<<uint(take) | uint32(data[i]>>uint(8-shift-take))&(1<<uint(take)-1)
+		read += take
+	}
+	return v
+}
+
+// scaleAlpha resamples a mask to width × height by nearest neighbour
+func scaleAlpha(alpha *image.Alpha, width, height int) *image.Alpha {
+	b := alpha.Bounds()
+	if b.Dx() == width && b.Dy() == height {
+		return alpha
+	}
+	if b.Empty() || width <= 0 || height <= 0 {
+		return nil
+	}
+	out := image.NewAlpha(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := y * b.Dy() / height
+		for x := 0; x < width; x++ {
+			out.Pix[y*out.Stride+x] = alpha.Pix[sy*alpha.Stride+x*b.Dx()/width]
+		}
+	}
+	return out
+}
@@ -5,17 +5,23 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io"
 )
 
+// JBIG2 decoding (ITU-T T.88). Generic and refinement regions are in
+// jbig2_generic.go, Huffman tables in jbig2_huffman.go, symbol
+// dictionaries and text regions in jbig2_text.go, patterns and halftones
+// in jbig2_halftone.go.
+
 // JBIG2Decoder decodes JBIG2 compressed image data
 type JBIG2Decoder struct {
-	data    []byte
-	globals []byte
-	width   int
-	height  int
-	pages   map[int]*jbig2Page
-	symbols map[int][]*jbig2Bitmap
+	data     []byte
+	globals  []byte
+	width    int
+	height   int
+	page     *jbig2Page
+	segments map[uint32]*jbig2Segment
+
+	pageNumber uint32
 }
 
 // jbig2Segment represents a JBIG2 segment
@@ -26,6 +32,14 @@ type jbig2Segment struct {
 	refSegments []uint32
 	dataLength  uint32
 	data        []byte
+
+	// Results that later segments may refer to
+	symbols  []*jbig2Bitmap      // exported symbols of a symbol dictionary
+	patterns []*jbig2Bitmap      // patterns of a pattern dictionary
+	table    *jbig2HuffmanTable  // custom Huffman table
+	region   *jbig2Bitmap        // intermediate region
+	info     jbig2RegionInfo     // region segment information
+	retained *jbig2SymbolContext // coding contexts kept for the next dictionary
 }
 
 // jbig2Page represents a JBIG2 page
@@ -39,10 +53,20 @@ type jbig2Page struct {
 	bitmap   *jbig2Bitmap
 }
 
-// jbig2Bitmap represents a bitmap image
+// jbig2RegionInfo is the region segment information field (7.4.1)
+type jbig2RegionInfo struct {
+	width  int
+	height int
+	x      int
+	y      int
+	combOp uint8
+}
+
+// jbig2Bitmap represents a bitmap image, packed MSB first with 1 = black
 type jbig2Bitmap struct {
 	width  int
 	height int
+	stride int
 	data   []byte
 }
 
@@ -53,586 +77,427 @@ type jbig2BitReader struct {
 	bitPos int
 }
 
-// JBIG2 segment types
+// JBIG2 segment types (7.3)
 const (
-	jbig2SymbolDict          = 0
-	jbig2TextRegion          = 6
-	jbig2TextRegionImmediate = 7
-	jbig2PatternDict         = 16
-	jbig2HalftoneRegion      = 22
-	jbig2HalftoneImmediate   = 23
-	jbig2GenericRegion       = 36
-	jbig2GenericImmediate    = 38
-	jbig2GenericRefinement   = 40
-	jbig2GenericRefImmediate = 42
-	jbig2PageInfo            = 48
-	jbig2EndOfPage           = 49
-	jbig2EndOfStripe         = 50
-	jbig2EndOfFile           = 51
-	jbig2Profiles            = 52
-	jbig2Tables              = 53
-	jbig2Extension           = 62
+	jbig2SymbolDict             = 0
+	jbig2TextRegion             = 4
+	jbig2TextRegionImmediate    = 6
+	jbig2TextRegionLossless     = 7
+	jbig2PatternDict            = 16
+	jbig2HalftoneRegion         = 20
+	jbig2HalftoneImmediate      = 22
+	jbig2HalftoneLossless       = 23
+	jbig2GenericRegion          = 36
+	jbig2GenericImmediate       = 38
+	jbig2GenericLossless        = 39
+	jbig2GenericRefinement      = 40
+	jbig2GenericRefImmediate    = 42
+	jbig2GenericRefLossless     = 43
+	jbig2PageInfo               = 48
+	jbig2EndOfPage              = 49
+	jbig2EndOfStripe            = 50
+	jbig2EndOfFile              = 51
+	jbig2Profiles               = 52
+	jbig2Tables                 = 53
+	jbig2ColorPalette           = 54
+	jbig2Extension              = 62
+	jbig2UnknownLength          = 0xFFFFFFFF
+	jbig2MaxPixels              = 1 << 28
+	jbig2CombOr, jbig2CombAnd   = 0, 1
+	jbig2CombXor, jbig2CombXnor = 2, 3
+	jbig2CombReplace            = 4
 )
 
+// jbig2FileHeader is the ID string of a JBIG2 file (D.4.1)
+var jbig2FileHeader = []byte{0x97, 0x4A, 0x42, 0x32, 0x0D, 0x0A, 0x1A, 0x0A}
+
 // NewJBIG2Decoder creates a new JBIG2 decoder
 func NewJBIG2Decoder(data []byte, globals []byte, width, height int) *JBIG2Decoder {
 	return &JBIG2Decoder{
-		data:    data,
-		globals: globals,
-		width:   width,
-		height:  height,
-		pages:   make(map[int]*jbig2Page),
-		symbols: make(map[int][]*jbig2Bitmap),
+		data:     data,
+		globals:  globals,
+		width:    width,
+		height:   height,
+		segments: make(map[uint32]*jbig2Segment),
 	}
 }
 
-// Decode decodes the JBIG2 data and returns the bitmap
+// Decode decodes the JBIG2 data and returns the bitmap of the first page,
+// packed MSB first with 1 meaning black
 func (d *JBIG2Decoder) Decode() ([]byte, error) {
-	// Parse global segments if present
+	// Global segments come first, as if they were part of the stream
 	if len(d.globals) > 0 {
-		if err := d.parseSegments(d.globals, true); err != nil {
+		if err := d.parseSegments(d.globals); err != nil {
 			return nil, fmt.Errorf("parsing globals: %w", err)
 		}
 	}
-
-	// Parse main data segments
-	if err := d.parseSegments(d.data, false); err != nil {
+	if err := d.parseSegments(d.data); err != nil {
 		return nil, fmt.Errorf("parsing data: %w", err)
 	}
 
-	// Get the page bitmap
-	if page, ok := d.pages[1]; ok && page.bitmap != nil {
-		return page.bitmap.data, nil
+	if d.page == nil || d.page.bitmap == nil {
+		return d.createDefaultBitmap(), nil
 	}
-
-	// If no page found, create a default bitmap
-	return d.createDefaultBitmap(), nil
+	bm := d.page.bitmap
+	d.width, d.height = bm.width, bm.height
+	return bm.data, nil
 }
 
-// parseSegments parses JBIG2 segments from data
-func (d *JBIG2Decoder) parseSegments(data []byte, isGlobal bool) error {
-	r := bytes.NewReader(data)
-
-	// Check for file header
-	header := make([]byte, 8)
-	if _, err := r.Read(header); err == nil {
-		if bytes.Equal(header[:8], []byte{0x97, 0x4A, 0x42, 0x32, 0x0D, 0x0A, 0x1A, 0x0A}) {
-			// Skip file header flags and page count
-			r.Seek(1, io.SeekCurrent) // flags
-			// Read page count if sequential
-			r.Seek(4, io.SeekCurrent) // page count
-		} else {
-			// Not a file header, reset
-			r.Seek(0, io.SeekStart)
+// parseSegments parses and processes the segments of data, which is
+// either a JBIG2 file or the embedded organisation used by PDF (D.3)
+func (d *JBIG2Decoder) parseSegments(data []byte) error {
+	pos := 0
+	sequential := true
+	if bytes.HasPrefix(data, jbig2FileHeader) {
+		if len(data) < 9 {
+			return fmt.Errorf("truncated file header")
 		}
-	} else {
-		r.Seek(0, io.SeekStart)
+		flags := data[8]
+		pos = 9
+		if flags&0x02 == 0 {
+			pos += 4 // number of pages
+		}
+		sequential = flags&0x01 != 0
 	}
 
-	for {
-		seg, err := d.readSegmentHeader(r)
-		if err != nil {
-			if err == io.EOF {
-				break
+	if sequential {
+		for pos < len(data) {
+			seg, n, err := d.readSegmentHeader(data[pos:])
+			if err != nil {
+				return err
 			}
-			return err
-		}
-
-		// Read segment data
-		if seg.dataLength > 0 && seg.dataLength != 0xFFFFFFFF {
-			seg.data = make([]byte, seg.dataLength)
-			if _, err := io.ReadFull(r, seg.data); err != nil {
+			pos += n
+			if err := d.attachData(seg, data, &pos); err != nil {
+				return err
+			}
+			if done, err := d.processSegment(seg); err != nil || done {
 				return err
 			}
 		}
+		return nil
+	}
 
-		// Process segment
-		if err := d.processSegment(seg); err != nil {
-			// Continue on error for robustness
-			continue
+	// Random-access organisation: all headers, then all data parts
+	var segs []*jbig2Segment
+	for pos < len(data) {
+		seg, n, err := d.readSegmentHeader(data[pos:])
+		if err != nil {
+			return err
 		}
-
+		pos += n
+		segs = append(segs, seg)
 		if seg.segmentType == jbig2EndOfFile {
 			break
 		}
 	}
+	for _, seg := range segs {
+		if err := d.attachData(seg, data, &pos); err != nil {
+			return err
+		}
+		if done, err := d.processSegment(seg); err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
 
+// attachData slices the data part of seg from data at *pos
+func (d *JBIG2Decoder) attachData(seg *jbig2Segment, data []byte, pos *int) error {
+	length := int(seg.dataLength)
+	if seg.dataLength == jbig2UnknownLength {
+		n, err := unknownGenericLength(data[*pos:])
+		if err != nil {
+			return fmt.Errorf("segment %d: %w", seg.number, err)
+		}
+		length = n
+	}
+	if length > len(data)-*pos {
+		return fmt.Errorf("segment %d: data length %d exceeds stream", seg.number, length)
+	}
+	seg.data = data[*pos : *pos+length]
+	*pos += length
 	return nil
 }
 
-// readSegmentHeader reads a segment header
-func (d *JBIG2Decoder) readSegmentHeader(r *bytes.Reader) (*jbig2Segment, error) {
+// unknownGenericLength finds the end of an immediate generic region whose
+// data length is not given in its header (7.2.7)
+func unknownGenericLength(data []byte) (int, error) {
+	if len(data) < 18 {
+		return 0, fmt.Errorf("truncated generic region")
+	}
+	marker := []byte{0xFF, 0xAC}
+	if data[17]&0x01 != 0 {
+		marker = []byte{0x00, 0x00}
+	}
+	start := 18
+	if data[17]&0x01 == 0 {
+		start += 2
+		if (data[17]>>1)&0x03 == 0 {
+			start += 6
+		}
+	}
+	for i := start; i+6 <= len(data); i++ {
+		if data[i] == marker[0] && data[i+1] == marker[1] {
+			return i + 6, nil
+		}
+	}
+	return 0, fmt.Errorf("end of generic region not found")
+}
+
+// readSegmentHeader reads a segment header (7.2) and returns it with its
+// length in bytes
+func (d *JBIG2Decoder) readSegmentHeader(data []byte) (*jbig2Segment, int, error) {
+	r := bytes.NewReader(data)
 	seg := &jbig2Segment{}
+	truncated := fmt.Errorf("truncated segment header")
 
-	// Segment number (4 bytes)
 	if err := binary.Read(r, binary.BigEndian, &seg.number); err != nil {
-		return nil, err
+		return nil, 0, truncated
 	}
-
-	// Segment header flags (1 byte)
 	flags, err := r.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, 0, truncated
 	}
 	seg.segmentType = flags & 0x3F
+	pageAssocLong := flags&0x40 != 0
 
-	// Page association size
-	pageAssocSize := 1
-	if flags&0x40 != 0 {
-		pageAssocSize = 4
-	}
-
-	// Referred-to segment count
-	refCountByte, err := r.ReadByte()
+	// Referred-to segment count and retention flags
+	b, err := r.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, 0, truncated
 	}
-
-	refCount := int(refCountByte >> 5)
-	if refCount == 7 {
-		// Long form
-		r.Seek(-1, io.SeekCurrent)
-		var longCount uint32
-		binary.Read(r, binary.BigEndian, &longCount)
-		refCount = int(longCount & 0x1FFFFFFF)
+	refCount := int(b >> 5)
+	switch {
+	case refCount == 7:
+		if err := r.UnreadByte(); err != nil {
+			return nil, 0, err
+		}
+		var v uint32
+		if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+			return nil, 0, truncated
+		}
+		refCount = int(v & 0x1FFFFFFF)
+		if refCount > len(data) {
+			return nil, 0, fmt.Errorf("segment %d: bad referred-to segment count", seg.number)
+		}
+		if _, err := r.Seek(int64((refCount+8)/8), 1); err != nil {
+			return nil, 0, err
+		}
+	case refCount > 4:
+		return nil, 0, fmt.Errorf("segment %d: bad referred-to segment count", seg.number)
 	}
 
-	// Referred-to segment numbers
 	seg.refSegments = make([]uint32, refCount)
-	for i := 0; i < refCount; i++ {
-		if seg.number <= 256 {
-			b, _ := r.ReadByte()
-			seg.refSegments[i] = uint32(b)
-		} else if seg.number <= 65536 {
-			var n uint16
-			binary.Read(r, binary.BigEndian, &n)
-			seg.refSegments[i] = uint32(n)
-		} else {
-			binary.Read(r, binary.BigEndian, &seg.refSegments[i])
+	for i := range seg.refSegments {
+		var err error
+		switch {
+		case seg.number <= 256:
+			var v uint8
+			err = binary.Read(r, binary.BigEndian, &v)
+			seg.refSegments[i] = uint32(v)
+		case seg.number <= 65536:
+			var v uint16
+			err = binary.Read(r, binary.BigEndian, &v)
+			seg.refSegments[i] = uint32(v)
+		default:
+			err = binary.Read(r, binary.BigEndian, &seg.refSegments[i])
+		}
+		if err != nil {
+			return nil, 0, truncated
 		}
 	}
 
-	// Page association
-	if pageAssocSize == 4 {
-		binary.Read(r, binary.BigEndian, &seg.pageAssoc)
+	if pageAssocLong {
+		err = binary.Read(r, binary.BigEndian, &seg.pageAssoc)
 	} else {
-		b, _ := r.ReadByte()
-		seg.pageAssoc = uint32(b)
+		var v uint8
+		err = binary.Read(r, binary.BigEndian, &v)
+		seg.pageAssoc = uint32(v)
+	}
+	if err != nil {
+		return nil, 0, truncated
 	}
 
-	// Segment data length
-	binary.Read(r, binary.BigEndian, &seg.dataLength)
-
-	return seg, nil
+	if err := binary.Read(r, binary.BigEndian, &seg.dataLength); err != nil {
+		return nil, 0, truncated
+	}
+	if seg.dataLength == jbig2UnknownLength && seg.segmentType != jbig2GenericImmediate {
+		return nil, 0, fmt.Errorf("segment %d: unknown data length", seg.number)
+	}
+	return seg, len(data) - r.Len(), nil
 }
 
-// processSegment processes a decoded segment
-func (d *JBIG2Decoder) processSegment(seg *jbig2Segment) error {
+// processSegment processes a segment; done reports the end of the first
+// page or of the file
+func (d *JBIG2Decoder) processSegment(seg *jbig2Segment) (done bool, err error) {
+	// Only the first page is decoded; segments of other pages are skipped
+	if seg.pageAssoc != 0 && d.page != nil && seg.pageAssoc != d.pageNumber {
+		return false, nil
+	}
+
 	switch seg.segmentType {
 	case jbig2PageInfo:
-		return d.processPageInfo(seg)
+		err = d.processPageInfo(seg)
+	case jbig2EndOfPage, jbig2EndOfFile:
+		return d.page != nil, nil
+	case jbig2EndOfStripe:
+		err = d.processEndOfStripe(seg)
 	case jbig2SymbolDict:
-		return d.processSymbolDict(seg)
-	case jbig2TextRegion, jbig2TextRegionImmediate:
-		return d.processTextRegion(seg)
-	case jbig2GenericRegion, jbig2GenericImmediate:
-		return d.processGenericRegion(seg)
-	case jbig2GenericRefinement, jbig2GenericRefImmediate:
-		return d.processRefinementRegion(seg)
-	case jbig2HalftoneRegion, jbig2HalftoneImmediate:
-		return d.processHalftoneRegion(seg)
+		err = d.processSymbolDict(seg)
+	case jbig2TextRegion, jbig2TextRegionImmediate, jbig2TextRegionLossless:
+		err = d.processTextRegion(seg)
 	case jbig2PatternDict:
-		return d.processPatternDict(seg)
-	case jbig2EndOfPage, jbig2EndOfFile, jbig2EndOfStripe:
-		return nil
+		err = d.processPatternDict(seg)
+	case jbig2HalftoneRegion, jbig2HalftoneImmediate, jbig2HalftoneLossless:
+		err = d.processHalftoneRegion(seg)
+	case jbig2GenericRegion, jbig2GenericImmediate, jbig2GenericLossless:
+		err = d.processGenericRegion(seg)
+	case jbig2GenericRefinement, jbig2GenericRefImmediate, jbig2GenericRefLossless:
+		err = d.processRefinementRegion(seg)
+	case jbig2Tables:
+		seg.table, err = parseHuffmanTable(seg.data)
 	default:
-		return nil
+		// Profiles, colour palettes, extensions and reserved types carry
+		// nothing needed for a bi-level page
 	}
-}
-
-// processPageInfo processes a page information segment
-func (d *JBIG2Decoder) processPageInfo(seg *jbig2Segment) error {
-	if len(seg.data) < 19 {
-		return fmt.Errorf("page info segment too short")
-	}
-
-	page := &jbig2Page{}
-	r := bytes.NewReader(seg.data)
-
-	binary.Read(r, binary.BigEndian, &page.width)
-	binary.Read(r, binary.BigEndian, &page.height)
-	binary.Read(r, binary.BigEndian, &page.xRes)
-	binary.Read(r, binary.BigEndian, &page.yRes)
-	page.flags, _ = r.ReadByte()
-	binary.Read(r, binary.BigEndian, &page.striping)
-
-	// Initialize page bitmap
-	height := int(page.height)
-	if height == 0xFFFFFFFF {
-		height = d.height
-	}
-	if height == 0 {
-		height = 1
-	}
-
-	width := int(page.width)
-	if width == 0 {
-		width = d.width
-	}
-	if width == 0 {
-		width = 1
-	}
-
-	page.bitmap = newJBIG2Bitmap(width, height)
-
-	// Initialize with default color based on flags
-	defaultColor := byte(0)
-	if page.flags&0x04 != 0 {
-		defaultColor = 0xFF
-	}
-	for i := range page.bitmap.data {
-		page.bitmap.data[i] = defaultColor
+	if err != nil {
+		return false, fmt.Errorf("segment %d (type %d): %w", seg.number, seg.segmentType, err)
 	}
-
-	d.pages[int(seg.pageAssoc)] = page
-	return nil
+	d.segments[seg.number] = seg
+	return false, nil
 }
 
-// processSymbolDict processes a symbol dictionary segment
-func (d *JBIG2Decoder) processSymbolDict(seg *jbig2Segment) error {
-	if len(seg.data) < 2 {
+// processPageInfo processes a page information segment (7.4.8)
+func (d *JBIG2Decoder) processPageInfo(seg *jbig2Segment) error {
+	if d.page != nil {
 		return nil
 	}
-
-	// Parse symbol dictionary flags
-	r := bytes.NewReader(seg.data)
-	var flags uint16
-	binary.Read(r, binary.BigEndian, &flags)
-
-	huffman := flags&0x01 != 0
-	refAgg := flags&0x02 != 0
-	_ = huffman
-	_ = refAgg
-
-	// Read symbol dimensions
-	var sdATFlags uint8
-	if flags&0x08 != 0 {
-		sdATFlags, _ = r.ReadByte()
+	if len(seg.data) < 19 {
+		return fmt.Errorf("page information too short")
+	}
+	p := &jbig2Page{
+		width:    binary.BigEndian.Uint32(seg.data[0:]),
+		height:   binary.BigEndian.Uint32(seg.data[4:]),
+		xRes:     binary.BigEndian.Uint32(seg.data[8:]),
+		yRes:     binary.BigEndian.Uint32(seg.data[12:]),
+		flags:    seg.data[16],
+		striping: binary.BigEndian.Uint16(seg.data[17:]),
+	}
+	height := int(p.height)
+	if p.height == jbig2UnknownLength {
+		// Striped page whose height is set by end of stripe segments
+		height = 0
+		if d.height > 0 {
+			height = d.height
+		}
 	}
-	_ = sdATFlags
-
-	// Read number of exported and new symbols
-	var numExported, numNew uint32
-	binary.Read(r, binary.BigEndian, &numExported)
-	binary.Read(r, binary.BigEndian, &numNew)
-
-	// Create placeholder symbols
-	symbols := make([]*jbig2Bitmap, numNew)
-	for i := range symbols {
-		symbols[i] = newJBIG2Bitmap(8, 8) // Placeholder
+	if uint64(p.width)*uint64(height) > jbig2MaxPixels {
+		return fmt.Errorf("page too large: %dx%d", p.width, height)
 	}
-
-	d.symbols[int(seg.number)] = symbols
+	p.bitmap = newJBIG2Bitmap(int(p.width), height)
+	if p.flags&0x04 != 0 {
+		p.bitmap.fill(1)
+	}
+	d.page = p
+	d.pageNumber = seg.pageAssoc
 	return nil
 }
 
-// processTextRegion processes a text region segment
-func (d *JBIG2Decoder) processTextRegion(seg *jbig2Segment) error {
-	page := d.pages[int(seg.pageAssoc)]
-	if page == nil || page.bitmap == nil {
+// processEndOfStripe grows a page of unknown height (7.4.10)
+func (d *JBIG2Decoder) processEndOfStripe(seg *jbig2Segment) error {
+	if d.page == nil || len(seg.data) < 4 {
 		return nil
 	}
-
-	if len(seg.data) < 17 {
-		return nil
+	end := int(binary.BigEndian.Uint32(seg.data))
+	if d.page.height == jbig2UnknownLength {
+		return d.growPage(end + 1)
 	}
-
-	r := bytes.NewReader(seg.data)
-
-	// Read region info
-	var width, height uint32
-	var x, y int32
-	var combOp uint8
-
-	binary.Read(r, binary.BigEndian, &width)
-	binary.Read(r, binary.BigEndian, &height)
-	binary.Read(r, binary.BigEndian, &x)
-	binary.Read(r, binary.BigEndian, &y)
-	combOp, _ = r.ReadByte()
-	_ = combOp
-
-	// Create region bitmap
-	region := newJBIG2Bitmap(int(width), int(height))
-
-	// Composite onto page
-	d.compositeBitmap(page.bitmap, region, int(x), int(y))
-
 	return nil
 }
 
-// processGenericRegion processes a generic region segment
-func (d *JBIG2Decoder) processGenericRegion(seg *jbig2Segment) error {
-	page := d.pages[int(seg.pageAssoc)]
-	if page == nil || page.bitmap == nil {
+// growPage extends a striped page to at least height rows
+func (d *JBIG2Decoder) growPage(height int) error {
+	bm := d.page.bitmap
+	if height <= bm.height {
 		return nil
 	}
-
-	if len(seg.data) < 18 {
-		return nil
+	if int64(bm.width)*int64(height) > jbig2MaxPixels {
+		return fmt.Errorf("page too large: %dx%d", bm.width, height)
 	}
-
-	r := bytes.NewReader(seg.data)
-
-	// Read region info
-	var width, height uint32
-	var x, y int32
-	var combOp uint8
-
-	binary.Read(r, binary.BigEndian, &width)
-	binary.Read(r, binary.BigEndian, &height)
-	binary.Read(r, binary.BigEndian, &x)
-	binary.Read(r, binary.BigEndian, &y)
-	combOp, _ = r.ReadByte()
-
-	// Read generic region flags
-	flags, _ := r.ReadByte()
-	mmr := flags&0x01 != 0
-	template := (flags >> 1) & 0x03
-	tpgdon := flags&0x08 != 0
-	_ = template
-	_ = tpgdon
-
-	// Create region bitmap
-	region := newJBIG2Bitmap(int(width), int(height))
-
-	// Decode bitmap data
-	remaining := seg.data[r.Size()-int64(r.Len()):]
-	if mmr {
-		d.decodeMMR(region, remaining)
-	} else {
-		d.decodeArithmetic(region, remaining, template)
+	grown := newJBIG2Bitmap(bm.width, height)
+	if d.page.flags&0x04 != 0 {
+		grown.fill(1)
 	}
-
-	// Composite onto page
-	d.compositeBitmap(page.bitmap, region, int(x), int(y), combOp)
-
+	copy(grown.data, bm.data)
+	d.page.bitmap = grown
 	return nil
 }
 
-// processRefinementRegion processes a refinement region segment
-func (d *JBIG2Decoder) processRefinementRegion(seg *jbig2Segment) error {
-	page := d.pages[int(seg.pageAssoc)]
-	if page == nil || page.bitmap == nil {
-		return nil
+// readRegionInfo reads the region segment information field (7.4.1)
+func readRegionInfo(data []byte) (jbig2RegionInfo, error) {
+	if len(data) < 17 {
+		return jbig2RegionInfo{}, fmt.Errorf("region segment information too short")
 	}
-
-	if len(seg.data) < 18 {
-		return nil
+	info := jbig2RegionInfo{
+		width:  int(binary.BigEndian.Uint32(data[0:])),
+		height: int(binary.BigEndian.Uint32(data[4:])),
+		x:      int(int32(binary.BigEndian.Uint32(data[8:]))),
+		y:      int(int32(binary.BigEndian.Uint32(data[12:]))),
+		combOp: data[16] & 0x07,
 	}
-
-	r := bytes.NewReader(seg.data)
-
-	// Read region info
-	var width, height uint32
-	var x, y int32
-	var combOp uint8
-
-	binary.Read(r, binary.BigEndian, &width)
-	binary.Read(r, binary.BigEndian, &height)
-	binary.Read(r, binary.BigEndian, &x)
-	binary.Read(r, binary.BigEndian, &y)
-	combOp, _ = r.ReadByte()
-	_ = combOp
-
-	// Create region bitmap
-	region := newJBIG2Bitmap(int(width), int(height))
-
-	// Composite onto page
-	d.compositeBitmap(page.bitmap, region, int(x), int(y))
-
-	return nil
-}
-
-// processHalftoneRegion processes a halftone region segment
-func (d *JBIG2Decoder) processHalftoneRegion(seg *jbig2Segment) error {
-	page := d.pages[int(seg.pageAssoc)]
-	if page == nil || page.bitmap == nil {
-		return nil
+	if info.width < 0 || info.height < 0 || int64(info.width)*int64(info.height) > jbig2MaxPixels {
+		return info, fmt.Errorf("region too large: %dx%d", info.width, info.height)
 	}
+	return info, nil
+}
 
-	if len(seg.data) < 17 {
+// storeRegion composes an immediate region onto the page, or keeps an
+// intermediate one for a later refinement
+func (d *JBIG2Decoder) storeRegion(seg *jbig2Segment, info jbig2RegionInfo, bm *jbig2Bitmap) error {
+	seg.info = info
+	switch seg.segmentType {
+	case jbig2TextRegion, jbig2HalftoneRegion, jbig2GenericRegion, jbig2GenericRefinement:
+		seg.region = bm
 		return nil
 	}
-
-	r := bytes.NewReader(seg.data)
-
-	// Read region info
-	var width, height uint32
-	var x, y int32
-	var combOp uint8
-
-	binary.Read(r, binary.BigEndian, &width)
-	binary.Read(r, binary.BigEndian, &height)
-	binary.Read(r, binary.BigEndian, &x)
-	binary.Read(r, binary.BigEndian, &y)
-	combOp, _ = r.ReadByte()
-	_ = combOp
-
-	// Create region bitmap with halftone pattern
-	region := newJBIG2Bitmap(int(width), int(height))
-
-	// Composite onto page
-	d.compositeBitmap(page.bitmap, region, int(x), int(y))
-
-	return nil
-}
-
-// processPatternDict processes a pattern dictionary segment
-func (d *JBIG2Decoder) processPatternDict(seg *jbig2Segment) error {
-	// Pattern dictionaries are used for halftone regions
-	return nil
-}
-
-// decodeMMR decodes MMR (Modified Modified READ) encoded data
-func (d *JBIG2Decoder) decodeMMR(bitmap *jbig2Bitmap, data []byte) {
-	// MMR is a variant of Group 4 fax encoding
-	// Simplified implementation
-	br := newJBIG2BitReader(data)
-
-	for y := 0; y < bitmap.height; y++ {
-		x := 0
-		white := true
-
-		for x < bitmap.width {
-			runLen := d.readMMRRunLength(br, white)
-			if runLen < 0 {
-				break
-			}
-
-			// Set pixels
-			for i := 0; i < runLen && x < bitmap.width; i++ {
-				if !white {
-					bitmap.setPixel(x, y, 1)
-				}
-				x++
-			}
-
-			white = !white
-		}
+	if d.page == nil {
+		return fmt.Errorf("region before page information")
 	}
-}
-
-// readMMRRunLength reads a run length from MMR encoded data
-func (d *JBIG2Decoder) readMMRRunLength(br *jbig2BitReader, white bool) int {
-	// Simplified MMR decoding
-	code := 0
-	for i := 0; i < 13; i++ {
-		bit := br.readBit()
-		if bit < 0 {
-			return -1
+	if d.page.height == jbig2UnknownLength {
+		if err := d.growPage(info.y + bm.height); err != nil {
+			return err
 		}
-		code = (code << 1) | bit
 	}
-	return code & 0x7FF
-}
-
-// decodeArithmetic decodes arithmetic coded data
-func (d *JBIG2Decoder) decodeArithmetic(bitmap *jbig2Bitmap, data []byte, template uint8) {
-	// Arithmetic coding context
-	ctx := newArithmeticDecoder(data)
-
-	for y := 0; y < bitmap.height; y++ {
-		for x := 0; x < bitmap.width; x++ {
-			// Get context from neighboring pixels
-			context := d.getContext(bitmap, x, y, template)
-			bit := ctx.decodeBit(context)
-			bitmap.setPixel(x, y, bit)
-		}
+	op := info.combOp
+	if seg.segmentType == jbig2GenericRefImmediate || seg.segmentType == jbig2GenericRefLossless {
+		op = jbig2CombReplace
+	} else if d.page.flags&0x40 == 0 {
+		op = (d.page.flags >> 3) & 0x03
 	}
+	d.page.bitmap.compose(bm, info.x, info.y, op)
+	return nil
 }
 
-// getContext gets the arithmetic coding context for a pixel
-func (d *JBIG2Decoder) getContext(bitmap *jbig2Bitmap, x, y int, template uint8) int {
-	context := 0
-
-	// Template 0: 16 context pixels
-	// Template 1: 13 context pixels
-	// Template 2: 10 context pixels
-	// Template 3: 10 context pixels
-
-	switch template {
-	case 0:
-		// 16 pixels from 2 rows above and current row
-		for dy := -2; dy <= 0; dy++ {
-			for dx := -4; dx <= 4; dx++ {
-				if dy == 0 && dx >= 0 {
-					break
-				}
-				context = (context << 1) | bitmap.getPixel(x+dx, y+dy)
-			}
-		}
-	case 1:
-		// 13 pixels
-		for dy := -2; dy <= 0; dy++ {
-			for dx := -3; dx <= 3; dx++ {
-				if dy == 0 && dx >= 0 {
-					break
-				}
-				context = (context << 1) | bitmap.getPixel(x+dx, y+dy)
-			}
-		}
-	default:
-		// 10 pixels
-		for dy := -2; dy <= 0; dy++ {
-			for dx := -2; dx <= 2; dx++ {
-				if dy == 0 && dx >= 0 {
-					break
-				}
-				context = (context << 1) | bitmap.getPixel(x+dx, y+dy)
-			}
+// referredSegments returns the already decoded segments seg refers to
+func (d *JBIG2Decoder) referredSegments(seg *jbig2Segment) []*jbig2Segment {
+	refs := make([]*jbig2Segment, 0, len(seg.refSegments))
+	for _, n := range seg.refSegments {
+		if r, ok := d.segments[n]; ok {
+			refs = append(refs, r)
 		}
 	}
-
-	return context
+	return refs
 }
 
-// compositeBitmap composites a source bitmap onto a destination
-func (d *JBIG2Decoder) compositeBitmap(dst, src *jbig2Bitmap, x, y int, combOp ...uint8) {
-	op := uint8(0) // OR
-	if len(combOp) > 0 {
-		op = combOp[0] & 0x03
-	}
-
-	for sy := 0; sy < src.height; sy++ {
-		dy := y + sy
-		if dy < 0 || dy >= dst.height {
-			continue
-		}
-
-		for sx := 0; sx < src.width; sx++ {
-			dx := x + sx
-			if dx < 0 || dx >= dst.width {
-				continue
-			}
-
-			srcPix := src.getPixel(sx, sy)
-			dstPix := dst.getPixel(dx, dy)
-
-			var result int
-			switch op {
-			case 0: // OR
-				result = srcPix | dstPix
-			case 1: // AND
-				result = srcPix & dstPix
-			case 2: // XOR
-				result = srcPix ^ dstPix
-			case 3: // XNOR
-				result = ^(srcPix ^ dstPix) & 1
-			}
-
-			dst.setPixel(dx, dy, result)
+// referredTables returns the custom Huffman tables seg refers to, in order
+func (d *JBIG2Decoder) referredTables(seg *jbig2Segment) []*jbig2HuffmanTable {
+	var tables []*jbig2HuffmanTable
+	for _, r := range d.referredSegments(seg) {
+		if r.segmentType == jbig2Tables && r.table != nil {
+			tables = append(tables, r.table)
 		}
 	}
+	return tables
 }
 
 // createDefaultBitmap creates a default bitmap when no page is found
@@ -650,58 +515,87 @@ func (d *JBIG2Decoder) createDefaultBitmap() []byte {
 	return make([]byte, rowBytes*height)
 }
 
-// newJBIG2Bitmap creates a new JBIG2 bitmap
+// newJBIG2Bitmap creates a new JBIG2 bitmap; empty bitmaps are allowed as
+// symbols may have zero width
 func newJBIG2Bitmap(width, height int) *jbig2Bitmap {
-	if width <= 0 {
-		width = 1
-	}
-	if height <= 0 {
-		height = 1
-	}
-
-	rowBytes := (width + 7) / 8
+	width, height = max(width, 0), max(height, 0)
+	stride := (width + 7) / 8
 	return &jbig2Bitmap{
 		width:  width,
 		height: height,
-		data:   make([]byte, rowBytes*height),
+		stride: stride,
+		data:   make([]byte, stride*height),
 	}
 }
 
 // getPixel gets a pixel value (0 or 1)
 func (b *jbig2Bitmap) getPixel(x, y int) int {
-	if x < 0 || x >= b.width || y < 0 || y >= b.height {
-		return 0
-	}
-
-	rowBytes := (b.width + 7) / 8
-	byteIdx := y*rowBytes + x/8
-	bitIdx := 7 - (x % 8)
-
-	if byteIdx >= len(b.data) {
+	if uint(x) >= uint(b.width) || uint(y) >= uint(b.height) {
 		return 0
 	}
-
-	return int((b.data[byteIdx] >> bitIdx) & 1)
+	return int(b.data[y*b.stride+x>>3]>>(7-x&7)) & 1
 }
 
 // setPixel sets a pixel value (0 or 1)
 func (b *jbig2Bitmap) setPixel(x, y, value int) {
-	if x < 0 || x >= b.width || y < 0 || y >= b.height {
+	if uint(x) >= uint(b.width) || uint(y) >= uint(b.height) {
 		return
 	}
+	i := y*b.stride + x>>3
+	if value != 0 {
+		b.data[i] |= 0x80 >> (x & 7)
+	} else {
+		b.data[i] &^= 0x80 >> (x & 7)
+	}
+}
 
-	rowBytes := (b.width + 7) / 8
-	byteIdx := y*rowBytes + x/8
-	bitIdx := 7 - (x % 8)
+// fill sets every pixel to value
+func (b *jbig2Bitmap) fill(value int) {
+	v := byte(0)
+	if value != 0 {
+		v = 0xFF
+	}
+	for i := range b.data {
+		b.data[i] = v
+	}
+}
 
-	if byteIdx >= len(b.data) {
-		return
+// sub copies the w x h area of b at (x, y) into a new bitmap
+func (b *jbig2Bitmap) sub(x, y, w, h int) *jbig2Bitmap {
+	s := newJBIG2Bitmap(w, h)
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			if b.getPixel(x+sx, y+sy) == 1 {
+				s.setPixel(sx, sy, 1)
+			}
+		}
 	}
+	return s
+}
 
-	if value != 0 {
-		b.data[byteIdx] |= 1 << bitIdx
-	} else {
-		b.data[byteIdx] &^= 1 << bitIdx
+// compose combines src into b with its top-left corner at (x, y) using a
+// combination operator (6.4.5, 7.4.8.3)
+func (b *jbig2Bitmap) compose(src *jbig2Bitmap, x, y int, op uint8) {
+	x0, y0 := max(x, 0), max(y, 0)
+	x1, y1 := min(x+src.width, b.width), min(y+src.height, b.height)
+	for dy := y0; dy < y1; dy++ {
+		for dx := x0; dx < x1; dx++ {
+			s := src.getPixel(dx-x, dy-y)
+			d := b.getPixel(dx, dy)
+			switch op {
+			case jbig2CombOr:
+				d |= s
+			case jbig2CombAnd:
+				d &= s
+			case jbig2CombXor:
+				d ^= s
+			case jbig2CombXnor:
+				d = ^(d ^ s) & 1
+			default:
+				d = s
+			}
+			b.setPixel(dx, dy, d)
+		}
 	}
 }
 
@@ -739,161 +633,21 @@ func (r *jbig2BitReader) readBits(n int) int {
 	return result
 }
 
-// arithmeticDecoder implements QM-coder arithmetic decoding
-type arithmeticDecoder struct {
-	data     []byte
-	pos      int
-	a        uint32 // Interval
-	c        uint32 // Code register
-	ct       int    // Bit counter
-	contexts []uint8
-}
-
-// newArithmeticDecoder creates a new arithmetic decoder
-func newArithmeticDecoder(data []byte) *arithmeticDecoder {
-	d := &arithmeticDecoder{
-		data:     data,
-		a:        0x8000,
-		contexts: make([]uint8, 65536),
-	}
-
-	// Initialize code register
-	d.c = uint32(d.readByte()) << 16
-	d.c |= uint32(d.readByte()) << 8
-	d.c <<= 7
-	d.ct = 0
-
-	return d
-}
-
-// readByte reads a byte from the data
-func (d *arithmeticDecoder) readByte() byte {
-	if d.pos >= len(d.data) {
-		return 0xFF
-	}
-	b := d.data[d.pos]
-	d.pos++
-	return b
-}
-
-// decodeBit decodes a single bit using the given context
-func (d *arithmeticDecoder) decodeBit(context int) int {
-	if context < 0 || context >= len(d.contexts) {
-		context = 0
-	}
-
-	// QM-coder state table (simplified)
-	qe := uint32(qeTable[d.contexts[context]&0x7F])
-
-	d.a -= qe
-	bit := 0
-
-	if (d.c >> 16) < d.a {
-		// MPS path
-		if d.a < 0x8000 {
-			bit = d.mpsExchange(context, qe)
-			d.renormalize()
-		}
-	} else {
-		// LPS path
-		d.c -= uint32(d.a) << 16
-		bit = d.lpsExchange(context, qe)
-		d.renormalize()
-	}
-
-	return bit
-}
-
-// mpsExchange handles MPS exchange
-func (d *arithmeticDecoder) mpsExchange(context int, qe uint32) int {
-	mps := int(d.contexts[context] >> 7)
-
-	if d.a < uint32(qe) {
-		// Conditional exchange
-		d.contexts[context] = switchTable[d.contexts[context]&0x7F]
-		return 1 - mps
-	}
-
-	d.contexts[context] = nextMPS[d.contexts[context]&0x7F] | (d.contexts[context] & 0x80)
-	return mps
-}
-
-// lpsExchange handles LPS exchange
-func (d *arithmeticDecoder) lpsExchange(context int, qe uint32) int {
-	mps := int(d.contexts[context] >> 7)
-
-	if d.a < uint32(qe) {
-		d.a = uint32(qe)
-		d.contexts[context] = nextMPS[d.contexts[context]&0x7F] | (d.contexts[context] & 0x80)
-		return mps
-	}
-
-	d.a = uint32(qe)
-	d.contexts[context] = switchTable[d.contexts[context]&0x7F]
-	return 1 - mps
-}
-
-// renormalize renormalizes the decoder
-func (d *arithmeticDecoder) renormalize() {
-	for d.a < 0x8000 {
-		if d.ct == 0 {
-			d.bytein()
-		}
-		d.a <<= 1
-		d.c <<= 1
-		d.ct--
-	}
-}
-
-// bytein reads a byte into the code register
-func (d *arithmeticDecoder) bytein() {
-	b := d.readByte()
-	if b == 0xFF {
-		b2 := d.readByte()
-		if b2 > 0x8F {
-			d.c += 0xFF00
-			d.ct = 8
-			d.pos--
-		} else {
-			d.c += uint32(b2) << 9
-			d.ct = 7
-		}
-	} else {
-		d.c += uint32(b) << 8
-		d.ct = 8
+// align skips to the next byte boundary
+func (r *jbig2BitReader) align() {
+	if r.bitPos != 0 {
+		r.bitPos = 0
+		r.pos++
 	}
 }
 
-// QM-coder probability estimation tables
-var qeTable = []uint16{
-	0x5601, 0x3401, 0x1801, 0x0AC1, 0x0521, 0x0221, 0x5601, 0x5401,
-	0x4801, 0x3801, 0x3001, 0x2401, 0x1C01, 0x1601, 0x5601, 0x5401,
-	0x5101, 0x4801, 0x3801, 0x3401, 0x3001, 0x2801, 0x2401, 0x2201,
-	0x1C01, 0x1801, 0x1601, 0x1401, 0x1201, 0x1101, 0x0AC1, 0x09C1,
-	0x08A1, 0x0521, 0x0441, 0x02A1, 0x0221, 0x0141, 0x0111, 0x0085,
-	0x0049, 0x0025, 0x0015, 0x0009, 0x0005, 0x0001, 0x5601,
-}
-
-var nextMPS = []uint8{
-	1, 2, 3, 4, 5, 38, 7, 8, 9, 10, 11, 12, 13, 29, 15, 16,
-	17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32,
-	33, 34, 35, 36, 37, 38, 39, 40, 41, 42, 43, 44, 45, 45, 46,
-}
-
-var switchTable = []uint8{
-	1, 6, 9, 12, 29, 33, 6, 14, 14, 14, 17, 18, 20, 21, 14, 14,
-	15, 16, 17, 18, 19, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29,
-	30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 41, 42, 43, 46,
-}
-
-// JBIG2Decode decodes JBIG2 compressed data
+// JBIG2Decode decodes JBIG2 compressed data. Globals must be supplied as
+// a resolved stream in the JBIG2Globals entry of params or of its
+// DecodeParms. The result keeps the JBIG2 convention of 1 meaning black.
 func JBIG2Decode(data []byte, params Dictionary) ([]byte, int, int, error) {
-	// Get global data if present
-	var globals []byte
-	if globalsRef := params.Get("JBIG2Globals"); globalsRef != nil {
-		if stream, ok := globalsRef.(Stream); ok {
-			globals = stream.Data
-		}
+	globals, err := jbig2Globals(params)
+	if err != nil {
+		return nil, 0, 0, err
 	}
 
 	// Get image dimensions
@@ -911,14 +665,100 @@ func JBIG2Decode(data []byte, params Dictionary) ([]byte, int, int, error) {
 	if err != nil {
 		return nil, 0, 0, err
 	}
+	return decoded, decoder.width, decoder.height, nil
+}
 
-	// Return actual dimensions from decoder
-	if width == 0 {
-		width = decoder.width
+// jbig2Globals returns the decoded JBIG2Globals stream named by params
+func jbig2Globals(params Dictionary) ([]byte, error) {
+	g := params.Get("JBIG2Globals")
+	if g == nil {
+		switch dp := params.Get("DecodeParms").(type) {
+		case Dictionary:
+			g = dp.Get("JBIG2Globals")
+		case Array:
+			for _, p := range dp {
+				if pd, ok := p.(Dictionary); ok && pd.Get("JBIG2Globals") != nil {
+					g = pd.Get("JBIG2Globals")
+				}
+			}
+		}
 	}
-	if height == 0 {
-		height = decoder.height
+	stream, ok := g.(Stream)
+	if !ok {
+		return nil, nil
+	}
+	globals, err := stream.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("JBIG2Globals: %w", err)
+	}
+	return globals, nil
+}
+
+// jbig2Filter implements the JBIG2Decode filter; PDF image samples use
+// 0 for black, so the page bitmap is inverted
+func jbig2Filter(data []byte, params Dictionary) ([]byte, error) {
+	decoded, _, _, err := JBIG2Decode(data, params)
+	if err != nil {
+		return nil, err
+	}
+	return invertBits(decoded), nil
+}
+
+// resolveJBIG2Globals returns s with an indirect JBIG2Globals entry of its
+// DecodeParms replaced by the globals stream, so that Decode can apply
+// the JBIG2Decode filter without access to the document
+func (d *Document) resolveJBIG2Globals(s Stream) Stream {
+	resolveParams := func(obj Object) (Object, bool) {
+		dp, ok := d.resolve(obj).(Dictionary)
+		if !ok {
+			return obj, false
+		}
+		ref, ok := dp.Get("JBIG2Globals").(Reference)
+		if !ok {
+			return dp, false
+		}
+		globals, ok := d.resolve(ref).(Stream)
+		if !ok {
+			return dp, false
+		}
+		resolved := make(Dictionary, len(dp))
+		for k, v := range dp {
+			resolved[k] = v
+		}
+		resolved["JBIG2Globals"] = globals
+		return resolved, true
+	}
+
+	var params Object
+	changed := false
+	switch dp := d.resolve(s.Dictionary.Get("DecodeParms")).(type) {
+	case Dictionary:
+		params, changed = resolveParams(dp)
+	case Array:
+		arr := make(Array, len(dp))
+		for i, p := range dp {
+			var c bool
+			arr[i], c = resolveParams(p)
+			changed = changed || c
+		}
+		params = arr
 	}
+	if !changed {
+		return s
+	}
+	dict := make(Dictionary, len(s.Dictionary))
+	for k, v := range s.Dictionary {
+		dict[k] = v
+	}
+	dict["DecodeParms"] = params
+	return Stream{Dictionary: dict, Data: s.Data}
+}
 
-	return decoded, width, height, nil
+// invertBits returns a copy of data with every bit inverted
+func invertBits(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = ^b
+	}
+	return out
 }
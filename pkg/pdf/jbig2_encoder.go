@@ -0,0 +1,424 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Lossless JBIG2 encoding of bilevel images in the embedded stream format
+// used by the JBIG2Decode filter: a single page without file header and
+// without end-of-page segment
+
+// JBIG2Encoder compresses bilevel images losslessly
+type JBIG2Encoder struct {
+	// Symbols enables symbol coding: connected components are collected in
+	// a symbol dictionary, identical components sharing one symbol, and
+	// placed by a text region. Components larger than MaxSymbolSize are
+	// coded in a generic region instead.
+	Symbols bool
+
+	// MaxSymbolSize bounds the width and height of a symbol; zero means 256
+	MaxSymbolSize int
+}
+
+// NewJBIG2Encoder creates an encoder using generic region coding only
+func NewJBIG2Encoder() *JBIG2Encoder {
+	return &JBIG2Encoder{}
+}
+
+// jbig2SegmentWriter writes segments with consecutive numbers
+type jbig2SegmentWriter struct {
+	buf  bytes.Buffer
+	next uint32
+}
+
+// write appends a segment associated with page 1 and returns its number
+// (7.2)
+func (w *jbig2SegmentWriter) write(segType byte, refs []uint32, data []byte) uint32 {
+	num := w.next
+	w.next++
+	binary.Write(&w.buf, binary.BigEndian, num)
+	w.buf.WriteByte(segType)
+	w.buf.WriteByte(byte(len(refs)) << 5)
+	for _, r := range refs {
+		switch {
+		case num <= 256:
+			w.buf.WriteByte(byte(r))
+		case num <= 65536:
+			binary.Write(&w.buf, binary.BigEndian, uint16(r))
+		default:
+			binary.Write(&w.buf, binary.BigEndian, r)
+		}
+	}
+	w.buf.WriteByte(1)
+	binary.Write(&w.buf, binary.BigEndian, uint32(len(data)))
+	w.buf.Write(data)
+	return num
+}
+
+// Encode compresses packed rows of 1 bit per pixel with 0 meaning black,
+// the sample layout produced by the JBIG2Decode filter
+func (e *JBIG2Encoder) Encode(data []byte, width, height int) ([]byte, error) {
+	if width <= 0 || height <= 0 || int64(width)*int64(height) > jbig2MaxPixels {
+		return nil, fmt.Errorf("invalid JBIG2 image size %dx%d", width, height)
+	}
+	page := newJBIG2Bitmap(width, height)
+	if len(data) < len(page.data) {
+		return nil, fmt.Errorf("JBIG2 encode: need %d bytes, have %d", len(page.data), len(data))
+	}
+	for i := range page.data {
+		page.data[i] = ^data[i]
+	}
+	if pad := page.stride*8 - width; pad > 0 {
+		mask := byte(0xFF) << pad
+		for y := 0; y < height; y++ {
+			page.data[(y+1)*page.stride-1] &= mask
+		}
+	}
+	return e.encodeBitmap(page), nil
+}
+
+// encodeBitmap encodes a page bitmap with 1 meaning black
+func (e *JBIG2Encoder) encodeBitmap(page *jbig2Bitmap) []byte {
+	w := &jbig2SegmentWriter{}
+
+	// Page information: eventually lossless, default pixel white and OR
+	// as default combination operator (7.4.8)
+	info := make([]byte, 19)
+	binary.BigEndian.PutUint32(info[0:], uint32(page.width))
+	binary.BigEndian.PutUint32(info[4:], uint32(page.height))
+	info[16] = 0x01
+	w.write(jbig2PageInfo, nil, info)
+
+	residue := page
+	if e.Symbols {
+		maxSize := e.MaxSymbolSize
+		if maxSize <= 0 {
+			maxSize = 256
+		}
+		var syms []*jbig2Bitmap
+		var instances []jbig2SymbolInstance
+		syms, instances, residue = extractSymbols(page, maxSize)
+		if len(instances) > 0 {
+			dict := w.write(jbig2SymbolDict, nil, encodeSymbolDict(syms))
+			w.write(jbig2TextRegionImmediate, []uint32{dict}, encodeTextRegion(page.width, page.height, syms, instances))
+		}
+	}
+	if residue != nil && (!e.Symbols || !residue.empty()) {
+		w.write(jbig2GenericImmediate, nil, encodeGenericRegionSegment(residue))
+	}
+	return w.buf.Bytes()
+}
+
+// empty reports whether a bitmap has no black pixels
+func (b *jbig2Bitmap) empty() bool {
+	for _, v := range b.data {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// regionInfoBytes builds a region segment information field placing a
+// region at the page origin with the OR operator (7.4.1)
+func regionInfoBytes(width, height int) []byte {
+	info := make([]byte, 17)
+	binary.BigEndian.PutUint32(info[0:], uint32(width))
+	binary.BigEndian.PutUint32(info[4:], uint32(height))
+	return info
+}
+
+// encodeGenericRegion codes a bitmap with a generic region template and
+// typical prediction (6.2.5.7), the inverse of decodeGenericRegion
+func encodeGenericRegion(mq *mqEncoder, cx []mqContext, p *jbig2GenericParams, bm *jbig2Bitmap) {
+	sltp := &cx[jbig2SLTPContexts[p.template]]
+	ltp := 0
+	for y := 0; y < bm.height; y++ {
+		if p.tpgdon {
+			typical := 1
+			row := bm.data[y*bm.stride : (y+1)*bm.stride]
+			if y == 0 {
+				for _, v := range row {
+					if v != 0 {
+						typical = 0
+						break
+					}
+				}
+			} else if !bytes.Equal(row, bm.data[(y-1)*bm.stride:y*bm.stride]) {
+				typical = 0
+			}
+			mq.encode(typical^ltp, sltp)
+			ltp = typical
+			if ltp == 1 {
+				continue
+			}
+		}
+		for x := 0; x < bm.width; x++ {
+			mq.encode(bm.getPixel(x, y), &cx[genericContext(bm, x, y, p.template, &p.at)])
+		}
+	}
+}
+
+// encodeGenericRegionSegment builds the data of an immediate generic
+// region segment covering the page (7.4.6)
+func encodeGenericRegionSegment(bm *jbig2Bitmap) []byte {
+	p := &jbig2GenericParams{tpgdon: true, at: defaultGenericAT(0)}
+	mq := newMQEncoder()
+	encodeGenericRegion(mq, make([]mqContext, genericContextSize(0)), p, bm)
+
+	data := regionInfoBytes(bm.width, bm.height)
+	data = append(data, 0x08) // template 0, TPGDON
+	for _, v := range p.at {
+		data = append(data, byte(int8(v)))
+	}
+	return append(data, mq.flush()...)
+}
+
+// jbig2SymbolInstance places a symbol with its top-left corner at (x, y)
+type jbig2SymbolInstance struct {
+	id   int
+	x, y int
+}
+
+// extractSymbols splits a page into its 8-connected components. Components
+// no larger than maxSize become symbols, identical ones sharing an ID; the
+// others are left in the returned residue bitmap. Symbols are ordered by
+// height and width, the order of the symbol dictionary.
+func extractSymbols(page *jbig2Bitmap, maxSize int) ([]*jbig2Bitmap, []jbig2SymbolInstance, *jbig2Bitmap) {
+	visited := newJBIG2Bitmap(page.width, page.height)
+	residue := newJBIG2Bitmap(page.width, page.height)
+	index := make(map[string]int)
+	var syms []*jbig2Bitmap
+	var instances []jbig2SymbolInstance
+
+	var stack, pixels [][2]int
+	for y := 0; y < page.height; y++ {
+		for x := 0; x < page.width; x++ {
+			if page.getPixel(x, y) == 0 || visited.getPixel(x, y) == 1 {
+				continue
+			}
+
+			// Flood fill the component and track its bounding box
+			x0, y0, x1, y1 := x, y, x, y
+			pixels = pixels[:0]
+			stack = append(stack[:0], [2]int{x, y})
+			visited.setPixel(x, y, 1)
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				pixels = append(pixels, p)
+				x0, y0 = min(x0, p[0]), min(y0, p[1])
+				x1, y1 = max(x1, p[0]), max(y1, p[1])
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						nx, ny := p[0]+dx, p[1]+dy
+						if page.getPixel(nx, ny) == 1 && visited.getPixel(nx, ny) == 0 {
+							visited.setPixel(nx, ny, 1)
+							stack = append(stack, [2]int{nx, ny})
+						}
+					}
+				}
+			}
+
+			w, h := x1-x0+1, y1-y0+1
+			if w > maxSize || h > maxSize {
+				for _, p := range pixels {
+					residue.setPixel(p[0], p[1], 1)
+				}
+				continue
+			}
+			sym := newJBIG2Bitmap(w, h)
+			for _, p := range pixels {
+				sym.setPixel(p[0]-x0, p[1]-y0, 1)
+			}
+			key := fmt.Sprintf("%d,%d,%s", w, h, sym.data)
+			id, ok := index[key]
+			if !ok {
+				id = len(syms)
+				index[key] = id
+				syms = append(syms, sym)
+			}
+			instances = append(instances, jbig2SymbolInstance{id: id, x: x0, y: y0})
+		}
+	}
+
+	// Renumber symbols in dictionary order
+	order := make([]int, len(syms))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := syms[order[i]], syms[order[j]]
+		if a.height != b.height {
+			return a.height < b.height
+		}
+		return a.width < b.width
+	})
+	newID := make([]int, len(syms))
+	sorted := make([]*jbig2Bitmap, len(syms))
+	for i, old := range order {
+		newID[old] = i
+		sorted[i] = syms[old]
+	}
+	for i := range instances {
+		instances[i].id = newID[instances[i].id]
+	}
+	return sorted, instances, residue
+}
+
+// jbig2IntEncoder codes integers with the arithmetic integer encoding
+// procedure, the inverse of jbig2IntDecoder (A.2)
+type jbig2IntEncoder struct {
+	cx [512]mqContext
+}
+
+func (e *jbig2IntEncoder) bits(mq *mqEncoder, prev *int, v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b := (v >> i) & 1
+		mq.encode(b, &e.cx[*prev])
+		if *prev < 256 {
+			*prev = *prev<<1 | b
+		} else {
+			*prev = (*prev<<1|b)&511 | 256
+		}
+	}
+}
+
+// encode codes v (Table A.1)
+func (e *jbig2IntEncoder) encode(mq *mqEncoder, v int) {
+	prev := 1
+	a := v
+	if v < 0 {
+		a = -v
+		e.bits(mq, &prev, 1, 1)
+	} else {
+		e.bits(mq, &prev, 0, 1)
+	}
+	switch {
+	case a < 4:
+		e.bits(mq, &prev, 0, 1)
+		e.bits(mq, &prev, a, 2)
+	case a < 20:
+		e.bits(mq, &prev, 2, 2)
+		e.bits(mq, &prev, a-4, 4)
+	case a < 84:
+		e.bits(mq, &prev, 6, 3)
+		e.bits(mq, &prev, a-20, 6)
+	case a < 340:
+		e.bits(mq, &prev, 14, 4)
+		e.bits(mq, &prev, a-84, 8)
+	case a < 4436:
+		e.bits(mq, &prev, 30, 5)
+		e.bits(mq, &prev, a-340, 12)
+	default:
+		e.bits(mq, &prev, 31, 5)
+		e.bits(mq, &prev, a-4436, 32)
+	}
+}
+
+// encodeOOB codes the out-of-band value, a negative zero
+func (e *jbig2IntEncoder) encodeOOB(mq *mqEncoder) {
+	prev := 1
+	e.bits(mq, &prev, 1, 1)
+	e.bits(mq, &prev, 0, 3)
+}
+
+// jbig2IDEncoder codes symbol IDs of a fixed length (A.3)
+type jbig2IDEncoder struct {
+	length int
+	cx     []mqContext
+}
+
+func newJBIG2IDEncoder(length int) *jbig2IDEncoder {
+	return &jbig2IDEncoder{length: length, cx: make([]mqContext, 1<<length)}
+}
+
+func (e *jbig2IDEncoder) encode(mq *mqEncoder, id int) {
+	prev := 1
+	for i := e.length - 1; i >= 0; i-- {
+		b := (id >> i) & 1
+		mq.encode(b, &e.cx[prev])
+		prev = prev<<1 | b
+	}
+}
+
+// encodeSymbolDict builds an arithmetically coded symbol dictionary
+// segment exporting all symbols, which must be ordered by height (6.5)
+func encodeSymbolDict(syms []*jbig2Bitmap) []byte {
+	p := &jbig2GenericParams{at: defaultGenericAT(0)}
+	mq := newMQEncoder()
+	cx := make([]mqContext, genericContextSize(0))
+	var iadh, iadw, iaex jbig2IntEncoder
+
+	height, i := 0, 0
+	for i < len(syms) {
+		iadh.encode(mq, syms[i].height-height)
+		height = syms[i].height
+		width := 0
+		for ; i < len(syms) && syms[i].height == height; i++ {
+			iadw.encode(mq, syms[i].width-width)
+			width = syms[i].width
+			encodeGenericRegion(mq, cx, p, syms[i])
+		}
+		iadw.encodeOOB(mq)
+	}
+	// Export flags: no input symbols, then all new symbols (6.5.10)
+	iaex.encode(mq, 0)
+	iaex.encode(mq, len(syms))
+
+	data := []byte{0x00, 0x00} // arithmetic, template 0
+	for _, v := range p.at {
+		data = append(data, byte(int8(v)))
+	}
+	data = binary.BigEndian.AppendUint32(data, uint32(len(syms)))
+	data = binary.BigEndian.AppendUint32(data, uint32(len(syms)))
+	return append(data, mq.flush()...)
+}
+
+// encodeTextRegion builds an arithmetically coded text region segment
+// placing symbol instances by their top-left corners, one strip per row
+// position (6.4)
+func encodeTextRegion(width, height int, syms []*jbig2Bitmap, instances []jbig2SymbolInstance) []byte {
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].y != instances[j].y {
+			return instances[i].y < instances[j].y
+		}
+		return instances[i].x < instances[j].x
+	})
+
+	mq := newMQEncoder()
+	var iadt, iafs, iads jbig2IntEncoder
+	iaid := newJBIG2IDEncoder(ceilLog2(len(syms)))
+
+	iadt.encode(mq, 0) // initial STRIPT
+	stripT, firstS := 0, 0
+	for i := 0; i < len(instances); {
+		t := instances[i].y
+		iadt.encode(mq, t-stripT)
+		stripT = t
+
+		curS := 0
+		for first := true; i < len(instances) && instances[i].y == t; i++ {
+			inst := instances[i]
+			if first {
+				iafs.encode(mq, inst.x-firstS)
+				firstS = inst.x
+				first = false
+			} else {
+				iads.encode(mq, inst.x-curS)
+			}
+			iaid.encode(mq, inst.id)
+			curS = inst.x + syms[inst.id].width - 1
+		}
+		iads.encodeOOB(mq)
+	}
+
+	data := regionInfoBytes(width, height)
+	// One strip, top-left reference corner, OR operator
+	data = binary.BigEndian.AppendUint16(data, jbig2TopLeft<<4)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(instances)))
+	return append(data, mq.flush()...)
+}
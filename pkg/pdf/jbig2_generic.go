@@ -0,0 +1,299 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Generic region and generic refinement region decoding (T.88 6.2, 6.3)
+
+// jbig2GenericParams are the parameters of the generic region decoding
+// procedure (Table 2)
+type jbig2GenericParams struct {
+	mmr      bool
+	template int
+	tpgdon   bool
+	at       [8]int // x, y of the adaptive template pixels A1 to A4
+	skip     *jbig2Bitmap
+}
+
+// jbig2RefinementParams are the parameters of the generic refinement
+// region decoding procedure (Table 6)
+type jbig2RefinementParams struct {
+	template  int
+	reference *jbig2Bitmap
+	dx, dy    int
+	tpgron    bool
+	at        [4]int // x, y of the adaptive template pixels A1 and A2
+}
+
+// jbig2SymbolContext holds the generic and refinement coding contexts of
+// a symbol dictionary, which may be retained for the next one (7.4.2.1.1)
+type jbig2SymbolContext struct {
+	generic    []mqContext
+	refinement []mqContext
+}
+
+// Context of the SLTP pseudo-pixel for each template (Figures 8 to 11)
+var jbig2SLTPContexts = [4]int{0x9B25, 0x0795, 0x00E5, 0x0195}
+
+// genericContextSize returns the number of contexts of a generic template
+func genericContextSize(template int) int {
+	switch template {
+	case 0:
+		return 1 << 16
+	case 1:
+		return 1 << 13
+	}
+	return 1 << 10
+}
+
+// refinementContextSize returns the number of contexts of a refinement
+// template
+func refinementContextSize(template int) int {
+	if template == 0 {
+		return 1 << 13
+	}
+	return 1 << 10
+}
+
+// defaultGenericAT returns the nominal adaptive template pixel positions
+// used when a region does not code them (6.6.5.2, 6.7.5)
+func defaultGenericAT(template int) [8]int {
+	if template <= 1 {
+		return [8]int{3, -1, -3, -1, 2, -2, -2, -2}
+	}
+	return [8]int{2, -1, -3, -1, 2, -2, -2, -2}
+}
+
+// readGenericAT reads the adaptive template pixels of a generic region
+// and returns them with the number of bytes read (7.4.6.3)
+func readGenericAT(data []byte, template int) ([8]int, int, error) {
+	var at [8]int
+	n := 2
+	if template == 0 {
+		n = 8
+	}
+	if len(data) < n {
+		return at, 0, fmt.Errorf("truncated adaptive template")
+	}
+	for i := 0; i < n; i++ {
+		at[i] = int(int8(data[i]))
+	}
+	return at, n, nil
+}
+
+// genericContext forms the context of pixel (x, y) from the pixels
+// already decoded (6.2.5.3)
+func genericContext(b *jbig2Bitmap, x, y, template int, at *[8]int) int {
+	g := b.getPixel
+	switch template {
+	case 0:
+		return g(x-1, y) | g(x-2, y)<<1 | g(x-3, y)<<2 | g(x-4, y)<<3 |
+			g(x+at[0], y+at[1])<<4 |
+			g(x+2, y-1)<<5 | g(x+1, y-1)<<6 | g(x, y-1)<<7 | g(x-1, y-1)<<8 | g(x-2, y-1)<<9 |
+			g(x+at[2], y+at[3])<<10 | g(x+at[4], y+at[5])<<11 |
+			g(x+1, y-2)<<12 | g(x, y-2)<<13 | g(x-1, y-2)<<14 |
+			g(x+at[6], y+at[7])<<15
+	case 1:
+		return g(x-1, y) | g(x-2, y)<<1 | g(x-3, y)<<2 |
+			g(x+at[0], y+at[1])<<3 |
+			g(x+2, y-1)<<4 | g(x+1, y-1)<<5 | g(x, y-1)<<6 | g(x-1, y-1)<<7 | g(x-2, y-1)<<8 |
+			g(x+2, y-2)<<9 | g(x+1, y-2)<<10 | g(x, y-2)<<11 | g(x-1, y-2)<<12
+	case 2:
+		return g(x-1, y) | g(x-2, y)<<1 |
+			g(x+at[0], y+at[1])<<2 |
+			g(x+1, y-1)<<3 | g(x, y-1)<<4 | g(x-1, y-1)<<5 |
+			g(x+1, y-2)<<6 | g(x, y-2)<<7 | g(x-1, y-2)<<8
+	}
+	return g(x-1, y) | g(x-2, y)<<1 | g(x-3, y)<<2 | g(x-4, y)<<3 |
+		g(x+at[0], y+at[1])<<4 |
+		g(x+1, y-1)<<5 | g(x, y-1)<<6 | g(x-1, y-1)<<7 | g(x-2, y-1)<<8 | g(x-3, y-1)<<9
+}
+
+// decodeGenericRegion decodes an arithmetically coded generic region
+// with typical prediction (6.2.5.7)
+func decodeGenericRegion(mq *mqDecoder, cx []mqContext, p *jbig2GenericParams, w, h int) *jbig2Bitmap {
+	bm := newJBIG2Bitmap(w, h)
+	sltp := &cx[jbig2SLTPContexts[p.template]]
+	ltp := 0
+	for y := 0; y < h; y++ {
+		if p.tpgdon {
+			ltp ^= mq.decode(sltp)
+			if ltp == 1 {
+				if y > 0 {
+					copy(bm.data[y*bm.stride:(y+1)*bm.stride], bm.data[(y-1)*bm.stride:y*bm.stride])
+				}
+				continue
+			}
+		}
+		for x := 0; x < w; x++ {
+			if p.skip != nil && p.skip.getPixel(x, y) == 1 {
+				continue
+			}
+			if mq.decode(&cx[genericContext(bm, x, y, p.template, &p.at)]) == 1 {
+				bm.setPixel(x, y, 1)
+			}
+		}
+	}
+	return bm
+}
+
+// refinementContext forms the context of pixel (x, y) from the region
+// being decoded and the reference bitmap (6.3.5.3)
+func refinementContext(b *jbig2Bitmap, p *jbig2RefinementParams, x, y int) int {
+	g := b.getPixel
+	r := p.reference.getPixel
+	rx, ry := x-p.dx, y-p.dy
+	if p.template == 0 {
+		return g(x-1, y) | g(x+1, y-1)<<1 | g(x, y-1)<<2 |
+			g(x+p.at[0], y+p.at[1])<<3 |
+			r(rx+1, ry+1)<<4 | r(rx, ry+1)<<5 | r(rx-1, ry+1)<<6 |
+			r(rx+1, ry)<<7 | r(rx, ry)<<8 | r(rx-1, ry)<<9 |
+			r(rx+1, ry-1)<<10 | r(rx, ry-1)<<11 |
+			r(rx+p.at[2], ry+p.at[3])<<12
+	}
+	return g(x-1, y) | g(x+1, y-1)<<1 | g(x, y-1)<<2 | g(x-1, y-1)<<3 |
+		r(rx+1, ry+1)<<4 | r(rx, ry+1)<<5 |
+		r(rx+1, ry)<<6 | r(rx, ry)<<7 | r(rx-1, ry)<<8 |
+		r(rx, ry-1)<<9
+}
+
+// decodeRefinementRegion decodes a generic refinement region (6.3.5.6)
+func decodeRefinementRegion(mq *mqDecoder, cx []mqContext, p *jbig2RefinementParams, w, h int) *jbig2Bitmap {
+	bm := newJBIG2Bitmap(w, h)
+	sltp := &cx[0x0010]
+	if p.template == 1 {
+		sltp = &cx[0x0008]
+	}
+	ltp := 0
+	for y := 0; y < h; y++ {
+		if p.tpgron {
+			ltp ^= mq.decode(sltp)
+		}
+		for x := 0; x < w; x++ {
+			if ltp == 1 {
+				// Typical prediction: a uniform 3x3 reference area is copied
+				v, uniform := refinementTypical(p.reference, x-p.dx, y-p.dy)
+				if uniform {
+					bm.setPixel(x, y, v)
+					continue
+				}
+			}
+			if mq.decode(&cx[refinementContext(bm, p, x, y)]) == 1 {
+				bm.setPixel(x, y, 1)
+			}
+		}
+	}
+	return bm
+}
+
+// refinementTypical reports whether the 3x3 neighbourhood of (x, y) in
+// ref has a single value
+func refinementTypical(ref *jbig2Bitmap, x, y int) (int, bool) {
+	v := ref.getPixel(x, y)
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if ref.getPixel(x+dx, y+dy) != v {
+				return 0, false
+			}
+		}
+	}
+	return v, true
+}
+
+// processGenericRegion processes a generic region segment (7.4.6)
+func (d *JBIG2Decoder) processGenericRegion(seg *jbig2Segment) error {
+	data := seg.data
+	if seg.dataLength == jbig2UnknownLength && len(data) >= 21 {
+		// The row count follows the end marker and may replace a height
+		// of 0xFFFFFFFF (7.4.6.4)
+		rows := binary.BigEndian.Uint32(data[len(data)-4:])
+		if binary.BigEndian.Uint32(data[4:]) > rows {
+			data = append([]byte(nil), data[:len(data)-4]...)
+			binary.BigEndian.PutUint32(data[4:], rows)
+		} else {
+			data = data[:len(data)-4]
+		}
+	}
+	info, err := readRegionInfo(data)
+	if err != nil {
+		return err
+	}
+	data = data[17:]
+	if len(data) < 1 {
+		return fmt.Errorf("missing generic region flags")
+	}
+	flags := data[0]
+	data = data[1:]
+	p := &jbig2GenericParams{
+		mmr:      flags&0x01 != 0,
+		template: int(flags>>1) & 0x03,
+		tpgdon:   flags&0x08 != 0,
+	}
+	if !p.mmr {
+		var n int
+		if p.at, n, err = readGenericAT(data, p.template); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	var bm *jbig2Bitmap
+	if p.mmr {
+		bm, _, err = decodeMMR(data, info.width, info.height)
+		if err != nil {
+			return err
+		}
+	} else {
+		cx := make([]mqContext, genericContextSize(p.template))
+		bm = decodeGenericRegion(newMQDecoder(data), cx, p, info.width, info.height)
+	}
+	return d.storeRegion(seg, info, bm)
+}
+
+// processRefinementRegion processes a generic refinement region segment
+// (7.4.7)
+func (d *JBIG2Decoder) processRefinementRegion(seg *jbig2Segment) error {
+	info, err := readRegionInfo(seg.data)
+	if err != nil {
+		return err
+	}
+	data := seg.data[17:]
+	if len(data) < 1 {
+		return fmt.Errorf("missing refinement region flags")
+	}
+	flags := data[0]
+	data = data[1:]
+	p := &jbig2RefinementParams{
+		template: int(flags & 0x01),
+		tpgron:   flags&0x02 != 0,
+	}
+	if p.template == 0 {
+		if len(data) < 4 {
+			return fmt.Errorf("truncated adaptive template")
+		}
+		for i := range p.at {
+			p.at[i] = int(int8(data[i]))
+		}
+		data = data[4:]
+	}
+
+	// The reference is the intermediate region referred to, or else the
+	// area of the page the region covers (7.4.7.5)
+	for _, r := range d.referredSegments(seg) {
+		if r.region != nil {
+			p.reference = r.region
+		}
+	}
+	if p.reference == nil {
+		if d.page == nil {
+			return fmt.Errorf("refinement without reference")
+		}
+		p.reference = d.page.bitmap.sub(info.x, info.y, info.width, info.height)
+	}
+
+	cx := make([]mqContext, refinementContextSize(p.template))
+	bm := decodeRefinementRegion(newMQDecoder(data), cx, p, info.width, info.height)
+	return d.storeRegion(seg, info, bm)
+}
@@ -0,0 +1,183 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Pattern dictionary and halftone region decoding (T.88 6.6, 6.7)
+
+// processPatternDict processes a pattern dictionary segment (7.4.4)
+func (d *JBIG2Decoder) processPatternDict(seg *jbig2Segment) error {
+	data := seg.data
+	if len(data) < 7 {
+		return fmt.Errorf("pattern dictionary too short")
+	}
+	flags := data[0]
+	pw, ph := int(data[1]), int(data[2])
+	grayMax := int(binary.BigEndian.Uint32(data[3:]))
+	data = data[7:]
+	if pw == 0 || ph == 0 {
+		return fmt.Errorf("empty patterns")
+	}
+	if int64(grayMax+1)*int64(pw)*int64(ph) > jbig2MaxPixels {
+		return fmt.Errorf("pattern dictionary too large")
+	}
+
+	// The patterns are decoded as one collective bitmap (6.7.5)
+	w := (grayMax + 1) * pw
+	var coll *jbig2Bitmap
+	if flags&0x01 != 0 {
+		var err error
+		if coll, _, err = decodeMMR(data, w, ph); err != nil {
+			return err
+		}
+	} else {
+		p := &jbig2GenericParams{
+			template: int(flags>>1) & 0x03,
+			at:       [8]int{-pw, 0, -3, -1, 2, -2, -2, -2},
+		}
+		cx := make([]mqContext, genericContextSize(p.template))
+		coll = decodeGenericRegion(newMQDecoder(data), cx, p, w, ph)
+	}
+
+	seg.patterns = make([]*jbig2Bitmap, grayMax+1)
+	for i := range seg.patterns {
+		seg.patterns[i] = coll.sub(i*pw, 0, pw, ph)
+	}
+	return nil
+}
+
+// jbig2HalftoneGrid holds the grid parameters of a halftone region
+type jbig2HalftoneGrid struct {
+	gw, gh int // grid size
+	gx, gy int // grid origin, in 1/256 pixel
+	rx, ry int // grid vector, in 1/256 pixel
+}
+
+// cell returns the position of the pattern at grid cell (mg, ng)
+func (g *jbig2HalftoneGrid) cell(mg, ng int) (int, int) {
+	x := (g.gx + mg*g.ry + ng*g.rx) >> 8
+	y := (g.gy + mg*g.rx - ng*g.ry) >> 8
+	return x, y
+}
+
+// processHalftoneRegion processes a halftone region segment (7.4.5)
+func (d *JBIG2Decoder) processHalftoneRegion(seg *jbig2Segment) error {
+	info, err := readRegionInfo(seg.data)
+	if err != nil {
+		return err
+	}
+	data := seg.data[17:]
+	if len(data) < 21 {
+		return fmt.Errorf("halftone region too short")
+	}
+	flags := data[0]
+	g := &jbig2HalftoneGrid{
+		gw: int(binary.BigEndian.Uint32(data[1:])),
+		gh: int(binary.BigEndian.Uint32(data[5:])),
+		gx: int(int32(binary.BigEndian.Uint32(data[9:]))),
+		gy: int(int32(binary.BigEndian.Uint32(data[13:]))),
+		rx: int(binary.BigEndian.Uint16(data[17:])),
+		ry: int(binary.BigEndian.Uint16(data[19:])),
+	}
+	data = data[21:]
+	mmr := flags&0x01 != 0
+	template := int(flags>>1) & 0x03
+	combOp := (flags >> 4) & 0x07
+	if int64(g.gw)*int64(g.gh) > jbig2MaxPixels {
+		return fmt.Errorf("halftone grid too large")
+	}
+
+	var patterns []*jbig2Bitmap
+	for _, r := range d.referredSegments(seg) {
+		if r.segmentType == jbig2PatternDict {
+			patterns = append(patterns, r.patterns...)
+		}
+	}
+	if len(patterns) == 0 {
+		return fmt.Errorf("halftone region without patterns")
+	}
+	pw, ph := patterns[0].width, patterns[0].height
+
+	region := newJBIG2Bitmap(info.width, info.height)
+	if flags&0x80 != 0 {
+		region.fill(1)
+	}
+
+	// Grid cells whose pattern falls outside the region are skipped
+	// (6.6.5.1)
+	var skip *jbig2Bitmap
+	if flags&0x08 != 0 {
+		skip = newJBIG2Bitmap(g.gw, g.gh)
+		for mg := 0; mg < g.gh; mg++ {
+			for ng := 0; ng < g.gw; ng++ {
+				x, y := g.cell(mg, ng)
+				if x+pw <= 0 || x >= info.width || y+ph <= 0 || y >= info.height {
+					skip.setPixel(ng, mg, 1)
+				}
+			}
+		}
+	}
+
+	bpp := ceilLog2(len(patterns))
+	gray, err := decodeGrayScaleImage(data, mmr, bpp, g.gw, g.gh, template, skip)
+	if err != nil {
+		return err
+	}
+	for mg := 0; mg < g.gh; mg++ {
+		for ng := 0; ng < g.gw; ng++ {
+			if skip != nil && skip.getPixel(ng, mg) == 1 {
+				continue
+			}
+			x, y := g.cell(mg, ng)
+			v := min(gray[mg*g.gw+ng], len(patterns)-1)
+			region.compose(patterns[v], x, y, combOp)
+		}
+	}
+	return d.storeRegion(seg, info, region)
+}
+
+// decodeGrayScaleImage decodes the Gray-coded bitplanes of a halftone
+// grid into its values (C.5)
+func decodeGrayScaleImage(data []byte, mmr bool, bpp, w, h, template int, skip *jbig2Bitmap) ([]int, error) {
+	vals := make([]int, w*h)
+	if bpp == 0 {
+		return vals, nil
+	}
+	p := &jbig2GenericParams{template: template, at: defaultGenericAT(template), skip: skip}
+	var mq *mqDecoder
+	var cx []mqContext
+	if !mmr {
+		mq = newMQDecoder(data)
+		cx = make([]mqContext, genericContextSize(template))
+	}
+
+	var prev *jbig2Bitmap
+	pos := 0
+	for j := bpp - 1; j >= 0; j-- {
+		var plane *jbig2Bitmap
+		if mmr {
+			var n int
+			var err error
+			if plane, n, err = decodeMMR(data[pos:], w, h); err != nil {
+				return nil, err
+			}
+			pos += n
+		} else {
+			plane = decodeGenericRegion(mq, cx, p, w, h)
+		}
+		if prev != nil {
+			for i := range plane.data {
+				plane.data[i] ^= prev.data[i]
+			}
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				vals[y*w+x] |= plane.getPixel(x, y) << j
+			}
+		}
+		prev = plane
+	}
+	return vals, nil
+}
@@ -0,0 +1,331 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Huffman coding of JBIG2 integers: the standard tables of T.88 Annex B
+// and custom table segments (B.2)
+
+// jbig2HuffmanLine is one line of a table: values from rangeLow to
+// rangeLow + 2^rangeLen - 1 share a prefix of prefLen bits. A lower range
+// line codes rangeLow minus its offset; an OOB line codes out-of-band.
+type jbig2HuffmanLine struct {
+	rangeLow int
+	prefLen  int
+	rangeLen int
+	lower    bool
+	oob      bool
+}
+
+// jbig2HuffmanTable is a table with its prefix codes assigned (B.3)
+type jbig2HuffmanTable struct {
+	lines []jbig2HuffmanLine
+	tree  []jbig2HuffmanNode
+}
+
+// jbig2HuffmanNode is a node of the decoding tree; line is the index of
+// the table line of a leaf, or -1
+type jbig2HuffmanNode struct {
+	child [2]int32
+	line  int
+}
+
+// jbig2StandardLines lists the standard tables B.1 to B.15, with the
+// lower range, upper range and OOB lines last
+var jbig2StandardLines = [15][]jbig2HuffmanLine{
+	{ // B.1
+		{0, 1, 4, false, false}, {16, 2, 8, false, false}, {272, 3, 16, false, false},
+		{65808, 3, 32, false, false},
+	},
+	{ // B.2
+		{0, 1, 0, false, false}, {1, 2, 0, false, false}, {2, 3, 0, false, false},
+		{3, 4, 3, false, false}, {11, 5, 6, false, false},
+		{75, 6, 32, false, false}, {0, 6, 0, false, true},
+	},
+	{ // B.3
+		{-256, 8, 8, false, false}, {0, 1, 0, false, false}, {1, 2, 0, false, false},
+		{2, 3, 0, false, false}, {3, 4, 3, false, false}, {11, 5, 6, false, false},
+		{-257, 8, 32, true, false}, {75, 7, 32, false, false}, {0, 6, 0, false, true},
+	},
+	{ // B.4
+		{1, 1, 0, false, false}, {2, 2, 0, false, false}, {3, 3, 0, false, false},
+		{4, 4, 3, false, false}, {12, 5, 6, false, false},
+		{76, 5, 32, false, false},
+	},
+	{ // B.5
+		{-255, 7, 8, false, false}, {1, 1, 0, false, false}, {2, 2, 0, false, false},
+		{3, 3, 0, false, false}, {4, 4, 3, false, false}, {12, 5, 6, false, false},
+		{-256, 7, 32, true, false}, {76, 6, 32, false, false},
+	},
+	{ // B.6
+		{-2048, 5, 10, false, false}, {-1024, 4, 9, false, false}, {-512, 4, 8, false, false},
+		{-256, 4, 7, false, false}, {-128, 5, 6, false, false}, {-64, 5, 5, false, false},
+		{-32, 4, 5, false, false}, {0, 2, 7, false, false}, {128, 3, 7, false, false},
+		{256, 3, 8, false, false}, {512, 4, 9, false, false}, {1024, 4, 10, false, false},
+		{-2049, 6, 32, true, false}, {2048, 6, 32, false, false},
+	},
+	{ // B.7
+		{-1024, 4, 9, false, false}, {-512, 3, 8, false, false}, {-256, 4, 7, false, false},
+		{-128, 5, 6, false, false}, {-64, 5, 5, false, false}, {-32, 4, 5, false, false},
+		{0, 4, 5, false, false}, {32, 5, 5, false, false}, {64, 5, 6, false, false},
+		{128, 4, 7, false, false}, {256, 3, 8, false, false}, {512, 3, 9, false, false},
+		{1024, 3, 10, false, false},
+		{-1025, 5, 32, true, false}, {2048, 5, 32, false, false},
+	},
+	{ // B.8
+		{-15, 8, 3, false, false}, {-7, 9, 1, false, false}, {-5, 8, 1, false, false},
+		{-3, 9, 0, false, false}, {-2, 7, 0, false, false}, {-1, 4, 0, false, false},
+		{0, 2, 1, false, false}, {2, 5, 0, false, false}, {3, 6, 0, false, false},
+		{4, 3, 4, false, false}, {20, 6, 1, false, false}, {22, 4, 4, false, false},
+		{38, 4, 5, false, false}, {70, 5, 6, false, false}, {134, 5, 7, false, false},
+		{262, 6, 7, false, false}, {390, 7, 8, false, false}, {646, 6, 10, false, false},
+		{-16, 9, 32, true, false}, {1670, 9, 32, false, false}, {0, 2, 0, false, true},
+	},
+	{ // B.9
+		{-31, 8, 4, false, false}, {-15, 9, 2, false, false}, {-11, 8, 2, false, false},
+		{-7, 9, 1, false, false}, {-5, 7, 1, false, false}, {-3, 4, 1, false, false},
+		{-1, 3, 1, false, false}, {1, 3, 1, false, false}, {3, 5, 1, false, false},
+		{5, 6, 1, false, false}, {7, 3, 5, false, false}, {39, 6, 2, false, false},
+		{43, 4, 5, false, false}, {75, 4, 6, false, false}, {139, 5, 7, false, false},
+		{267, 5, 8, false, false}, {523, 6, 8, false, false}, {779, 7, 9, false, false},
+		{1291, 6, 11, false, false},
+		{-32, 9, 32, true, false}, {3339, 9, 32, false, false}, {0, 2, 0, false, true},
+	},
+	{ // B.10
+		{-21, 7, 4, false, false}, {-5, 8, 0, false, false}, {-4, 7, 0, false, false},
+		{-3, 5, 0, false, false}, {-2, 2, 2, false, false}, {2, 5, 0, false, false},
+		{3, 6, 0, false, false}, {4, 7, 0, false, false}, {5, 8, 0, false, false},
+		{6, 2, 6, false, false}, {70, 5, 5, false, false}, {102, 6, 5, false, false},
+		{134, 6, 6, false, false}, {198, 6, 7, false, false}, {326, 6, 8, false, false},
+		{582, 6, 9, false, false}, {1094, 6, 10, false, false}, {2118, 7, 11, false, false},
+		{-22, 8, 32, true, false}, {4166, 8, 32, false, false}, {0, 2, 0, false, true},
+	},
+	{ // B.11
+		{1, 1, 0, false, false}, {2, 2, 1, false, false}, {4, 4, 0, false, false},
+		{5, 4, 1, false, false}, {7, 5, 1, false, false}, {9, 5, 2, false, false},
+		{13, 6, 2, false, false}, {17, 7, 2, false, false}, {21, 7, 3, false, false},
+		{29, 7, 4, false, false}, {45, 7, 5, false, false}, {77, 7, 6, false, false},
+		{141, 7, 32, false, false},
+	},
+	{ // B.12
+		{1, 1, 0, false, false}, {2, 2, 0, false, false}, {3, 3, 1, false, false},
+		{5, 5, 0, false, false}, {6, 5, 1, false, false}, {8, 6, 1, false, false},
+		{10, 7, 0, false, false}, {11, 7, 1, false, false}, {13, 7, 2, false, false},
+		{17, 7, 3, false, false}, {25, 7, 4, false, false}, {41, 8, 5, false, false},
+		{73, 8, 32, false, false},
+	},
+	{ // B.13
+		{1, 1, 0, false, false}, {2, 3, 0, false, false}, {3, 4, 0, false, false},
+		{4, 5, 0, false, false}, {5, 4, 1, false, false}, {7, 3, 3, false, false},
+		{15, 6, 1, false, false}, {17, 6, 2, false, false}, {21, 6, 3, false, false},
+		{29, 6, 4, false, false}, {45, 6, 5, false, false}, {77, 7, 6, false, false},
+		{141, 7, 32, false, false},
+	},
+	{ // B.14
+		{-2, 3, 0, false, false}, {-1, 3, 0, false, false}, {0, 1, 0, false, false},
+		{1, 3, 0, false, false}, {2, 3, 0, false, false},
+	},
+	{ // B.15
+		{-24, 7, 4, false, false}, {-8, 6, 2, false, false}, {-4, 5, 1, false, false},
+		{-2, 4, 0, false, false}, {-1, 3, 0, false, false}, {0, 1, 0, false, false},
+		{1, 3, 0, false, false}, {2, 4, 0, false, false}, {3, 5, 1, false, false},
+		{5, 6, 2, false, false}, {9, 7, 4, false, false},
+		{-25, 7, 32, true, false}, {25, 7, 32, false, false},
+	},
+}
+
+// jbig2StandardTables holds the built standard tables, indexed from 1
+var jbig2StandardTables [16]*jbig2HuffmanTable
+
+func init() {
+	for i, lines := range jbig2StandardLines {
+		t, err := newJBIG2HuffmanTable(lines)
+		if err != nil {
+			panic(fmt.Sprintf("JBIG2 table B.%d: %v", i+1, err))
+		}
+		jbig2StandardTables[i+1] = t
+	}
+}
+
+// newJBIG2HuffmanTable assigns the prefix codes of lines (B.3) and builds
+// the decoding tree
+func newJBIG2HuffmanTable(lines []jbig2HuffmanLine) (*jbig2HuffmanTable, error) {
+	t := &jbig2HuffmanTable{lines: lines}
+	maxLen := 0
+	for _, l := range lines {
+		if l.prefLen > 32 || l.rangeLen > 32 {
+			return nil, fmt.Errorf("invalid table line")
+		}
+		maxLen = max(maxLen, l.prefLen)
+	}
+	count := make([]int, maxLen+1)
+	for _, l := range lines {
+		count[l.prefLen]++
+	}
+
+	t.tree = []jbig2HuffmanNode{{line: -1}}
+	first := 0
+	for length := 1; length <= maxLen; length++ {
+		if length > 1 {
+			first = (first + count[length-1]) << 1
+		} else {
+			first = 0
+		}
+		code := first
+		for i, l := range lines {
+			if l.prefLen != length {
+				continue
+			}
+			if err := t.insert(code, length, i); err != nil {
+				return nil, err
+			}
+			code++
+		}
+	}
+	return t, nil
+}
+
+// insert adds the code of line i to the decoding tree
+func (t *jbig2HuffmanTable) insert(code, length, line int) error {
+	node := 0
+	for bit := length - 1; bit >= 0; bit-- {
+		if t.tree[node].line >= 0 {
+			return fmt.Errorf("prefix codes overlap")
+		}
+		b := (code >> bit) & 1
+		next := t.tree[node].child[b]
+		if next == 0 {
+			next = int32(len(t.tree))
+			t.tree = append(t.tree, jbig2HuffmanNode{line: -1})
+			t.tree[node].child[b] = next
+		}
+		node = int(next)
+	}
+	if t.tree[node].line >= 0 || t.tree[node].child != [2]int32{} {
+		return fmt.Errorf("prefix codes overlap")
+	}
+	t.tree[node].line = line
+	return nil
+}
+
+// decode reads one value; ok is false for out-of-band (B.4)
+func (t *jbig2HuffmanTable) decode(r *jbig2BitReader) (v int, ok bool, err error) {
+	node := 0
+	for t.tree[node].line < 0 {
+		b := r.readBit()
+		if b < 0 {
+			return 0, false, fmt.Errorf("unexpected end of Huffman data")
+		}
+		next := t.tree[node].child[b]
+		if next == 0 {
+			return 0, false, fmt.Errorf("invalid Huffman code")
+		}
+		node = int(next)
+	}
+	l := t.lines[t.tree[node].line]
+	if l.oob {
+		return 0, false, nil
+	}
+	offset := 0
+	if l.rangeLen > 0 {
+		if offset = r.readBits(l.rangeLen); offset < 0 {
+			return 0, false, fmt.Errorf("unexpected end of Huffman data")
+		}
+	}
+	if l.lower {
+		return l.rangeLow - offset, true, nil
+	}
+	return l.rangeLow + offset, true, nil
+}
+
+// decodeValue reads a value that may not be out-of-band
+func (t *jbig2HuffmanTable) decodeValue(r *jbig2BitReader) (int, error) {
+	v, ok, err := t.decode(r)
+	if err == nil && !ok {
+		err = fmt.Errorf("unexpected out-of-band value")
+	}
+	return v, err
+}
+
+// parseHuffmanTable parses a tables segment into a custom table (B.2)
+func parseHuffmanTable(data []byte) (*jbig2HuffmanTable, error) {
+	if len(data) < 9 {
+		return nil, fmt.Errorf("table segment too short")
+	}
+	flags := data[0]
+	oob := flags&0x01 != 0
+	prefBits := int(flags>>1)&0x07 + 1
+	rangeBits := int(flags>>4)&0x07 + 1
+	low := int(int32(binary.BigEndian.Uint32(data[1:])))
+	high := int(int32(binary.BigEndian.Uint32(data[5:])))
+	if low >= high {
+		return nil, fmt.Errorf("invalid table range %d to %d", low, high)
+	}
+
+	r := newJBIG2BitReader(data[9:])
+	read := func(n int) (int, error) {
+		v := r.readBits(n)
+		if v < 0 {
+			return 0, fmt.Errorf("truncated table segment")
+		}
+		return v, nil
+	}
+	var lines []jbig2HuffmanLine
+	for cur := low; cur < high; {
+		prefLen, err := read(prefBits)
+		if err != nil {
+			return nil, err
+		}
+		rangeLen, err := read(rangeBits)
+		if err != nil {
+			return nil, err
+		}
+		if rangeLen > 32 {
+			return nil, fmt.Errorf("invalid table line")
+		}
+		lines = append(lines, jbig2HuffmanLine{rangeLow: cur, prefLen: prefLen, rangeLen: rangeLen})
+		cur += 1 << rangeLen
+	}
+	lowerLen, err := read(prefBits)
+	if err != nil {
+		return nil, err
+	}
+	upperLen, err := read(prefBits)
+	if err != nil {
+		return nil, err
+	}
+	lines = append(lines,
+		jbig2HuffmanLine{rangeLow: low - 1, prefLen: lowerLen, rangeLen: 32, lower: true},
+		jbig2HuffmanLine{rangeLow: high, prefLen: upperLen, rangeLen: 32})
+	if oob {
+		oobLen, err := read(prefBits)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, jbig2HuffmanLine{prefLen: oobLen, oob: true})
+	}
+	return newJBIG2HuffmanTable(lines)
+}
+
+// jbig2TableSelector picks standard or custom tables as selected by
+// segment flags, taking custom tables in the order they are referred to
+type jbig2TableSelector struct {
+	custom []*jbig2HuffmanTable
+}
+
+// pick returns the table for a selection value; standard lists the
+// standard table number of each value, and other values select the next
+// custom table
+func (s *jbig2TableSelector) pick(value int, standard ...int) (*jbig2HuffmanTable, error) {
+	if value < len(standard) {
+		return jbig2StandardTables[standard[value]], nil
+	}
+	if len(s.custom) == 0 {
+		return nil, fmt.Errorf("missing custom Huffman table")
+	}
+	t := s.custom[0]
+	s.custom = s.custom[1:]
+	return t, nil
+}
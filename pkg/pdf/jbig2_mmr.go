@@ -0,0 +1,215 @@
+package pdf
+
+import "fmt"
+
+// MMR decoding of JBIG2 generic regions: two-dimensional coding of
+// ITU-T T.6 without EOL codes (T.88 6.2.6)
+
+// mmrRunTable maps a run-length code, keyed by length<<16 | code, to the
+// run it encodes
+type mmrRunTable map[int]int
+
+// Run-length code tables for white and black runs, including the
+// extended make-up codes
+var mmrWhiteRuns, mmrBlackRuns = newMMRRunTable(whiteTermCodes, whiteMakeupCodes),
+	newMMRRunTable(blackTermCodes, blackMakeupCodes)
+
+func newMMRRunTable(term, makeup []CCITTCode) mmrRunTable {
+	t := make(mmrRunTable)
+	for _, set := range [][]CCITTCode{term, makeup, extendedMakeupCodes} {
+		for _, c := range set {
+			t[c.Bits<<16|c.Code] = c.RunLen
+		}
+	}
+	return t
+}
+
+// Two-dimensional coding modes
+const (
+	mmrPass = iota
+	mmrHorizontal
+	mmrVertical
+	mmrEOL
+)
+
+// mmrDecoder decodes the rows of a T.6 coded bitmap
+type mmrDecoder struct {
+	r     *jbig2BitReader
+	width int
+	ref   []int // changing elements of the reference line
+	cur   []int
+}
+
+// newMMRDecoder creates a decoder whose first reference line is white
+func newMMRDecoder(data []byte, width int) *mmrDecoder {
+	m := &mmrDecoder{r: newJBIG2BitReader(data), width: width}
+	m.ref = []int{width, width, width}
+	return m
+}
+
+// readMode reads a mode code and returns the mode and, for vertical
+// mode, the offset of a1 from b1
+func (m *mmrDecoder) readMode() (int, int, error) {
+	zeros := 0
+	for {
+		b := m.r.readBit()
+		if b < 0 {
+			return 0, 0, fmt.Errorf("MMR: unexpected end of data")
+		}
+		if b == 1 {
+			break
+		}
+		if zeros++; zeros > 11 {
+			return 0, 0, fmt.Errorf("MMR: invalid code")
+		}
+	}
+	side := func(k int) (int, int, error) {
+		switch m.r.readBit() {
+		case 1:
+			return mmrVertical, k, nil
+		case 0:
+			return mmrVertical, -k, nil
+		}
+		return 0, 0, fmt.Errorf("MMR: unexpected end of data")
+	}
+	switch zeros {
+	case 0:
+		return mmrVertical, 0, nil
+	case 1:
+		return side(1)
+	case 2:
+		return mmrHorizontal, 0, nil
+	case 3:
+		return mmrPass, 0, nil
+	case 4:
+		return side(2)
+	case 5:
+		return side(3)
+	case 11:
+		return mmrEOL, 0, nil
+	}
+	return 0, 0, fmt.Errorf("MMR: unsupported code")
+}
+
+// readRun reads a run of colour (0 white, 1 black) made of make-up codes
+// and a terminating code
+func (m *mmrDecoder) readRun(color int) (int, error) {
+	table := mmrWhiteRuns
+	if color == 1 {
+		table = mmrBlackRuns
+	}
+	total := 0
+	for {
+		code, n := 0, 0
+		run := -1
+		for n < 13 {
+			b := m.r.readBit()
+			if b < 0 {
+				return 0, fmt.Errorf("MMR: unexpected end of data")
+			}
+			code = code<<1 | b
+			n++
+			if v, ok := table[n<<16|code]; ok {
+				run = v
+				break
+			}
+		}
+		if run < 0 {
+			return 0, fmt.Errorf("MMR: invalid run code")
+		}
+		total += run
+		if run < 64 {
+			return total, nil
+		}
+	}
+}
+
+// decodeRow decodes one row into its changing elements; eol reports an
+// end-of-facsimile-block code in place of the row
+func (m *mmrDecoder) decodeRow() (eol bool, err error) {
+	w := m.width
+	m.cur = m.cur[:0]
+	a0, color, i := -1, 0, 0
+	for a0 < w {
+		// b1 is the first changing element of the reference line right of
+		// a0 whose colour is opposite to that of a0; b2 follows it
+		for i > 0 && m.ref[i-1] > a0 {
+			i--
+		}
+		for i < len(m.ref)-2 && (m.ref[i] <= a0 || i&1 != color) {
+			i++
+		}
+		b1, b2 := m.ref[i], m.ref[i+1]
+
+		mode, k, err := m.readMode()
+		if err != nil {
+			return false, err
+		}
+		switch mode {
+		case mmrEOL:
+			return true, nil
+		case mmrPass:
+			a0 = b2
+		case mmrHorizontal:
+			r1, err := m.readRun(color)
+			if err != nil {
+				return false, err
+			}
+			r2, err := m.readRun(color ^ 1)
+			if err != nil {
+				return false, err
+			}
+			a1 := min(max(a0, 0)+r1, w)
+			a2 := min(a1+r2, w)
+			m.cur = append(m.cur, a1, a2)
+			a0 = a2
+		case mmrVertical:
+			a1 := b1 + k
+			if a1 < max(a0, 0) || a1 > w {
+				return false, fmt.Errorf("MMR: invalid vertical mode")
+			}
+			m.cur = append(m.cur, a1)
+			a0 = a1
+			color ^= 1
+		}
+	}
+	m.ref, m.cur = append(m.cur, w, w, w), m.ref
+	return false, nil
+}
+
+// fillRow sets the black pixels of row y from the reference line
+func (m *mmrDecoder) fillRow(bm *jbig2Bitmap, y int) {
+	for j := 0; j+1 < len(m.ref); j += 2 {
+		for x := m.ref[j]; x < m.ref[j+1] && x < m.width; x++ {
+			bm.setPixel(x, y, 1)
+		}
+	}
+}
+
+// decodeMMR decodes a w x h MMR coded bitmap and returns it with the
+// number of bytes used, including an end-of-facsimile-block code
+func decodeMMR(data []byte, w, h int) (*jbig2Bitmap, int, error) {
+	bm := newJBIG2Bitmap(w, h)
+	m := newMMRDecoder(data, w)
+	for y := 0; y < h; y++ {
+		eol, err := m.decodeRow()
+		if err != nil {
+			return nil, 0, err
+		}
+		if eol {
+			// EOFB: the remaining rows stay white
+			m.r.readBits(12)
+			m.r.align()
+			return bm, m.r.pos, nil
+		}
+		m.fillRow(bm, y)
+	}
+
+	// Skip an optional EOFB (two EOL codes)
+	saved := *m.r
+	if m.r.readBits(24) != 0x001001 {
+		*m.r = saved
+	}
+	m.r.align()
+	return bm, min(m.r.pos, len(data)), nil
+}
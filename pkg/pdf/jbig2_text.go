@@ -0,0 +1,763 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Symbol dictionary and text region decoding (T.88 6.4, 6.5)
+
+// jbig2IntDecoder decodes integers with the arithmetic integer decoding
+// procedure (A.2)
+type jbig2IntDecoder struct {
+	cx [512]mqContext
+}
+
+// decode returns the next integer; ok is false for out-of-band
+func (d *jbig2IntDecoder) decode(mq *mqDecoder) (v int, ok bool) {
+	prev := 1
+	bit := func() int {
+		b := mq.decode(&d.cx[prev])
+		if prev < 256 {
+			prev = prev<<1 | b
+		} else {
+			prev = (prev<<1|b)&511 | 256
+		}
+		return b
+	}
+
+	s := bit()
+	var n, offset int
+	switch {
+	case bit() == 0:
+		n, offset = 2, 0
+	case bit() == 0:
+		n, offset = 4, 4
+	case bit() == 0:
+		n, offset = 6, 20
+	case bit() == 0:
+		n, offset = 8, 84
+	case bit() == 0:
+		n, offset = 12, 340
+	default:
+		n, offset = 32, 4436
+	}
+	for i := 0; i < n; i++ {
+		v = v<<1 | bit()
+	}
+	v += offset
+	if s == 1 {
+		if v == 0 {
+			return 0, false
+		}
+		v = -v
+	}
+	return v, true
+}
+
+// jbig2IDDecoder decodes symbol IDs of a fixed code length (A.3)
+type jbig2IDDecoder struct {
+	length int
+	cx     []mqContext
+}
+
+func newJBIG2IDDecoder(length int) *jbig2IDDecoder {
+	return &jbig2IDDecoder{length: length, cx: make([]mqContext, 1<<length)}
+}
+
+func (d *jbig2IDDecoder) decode(mq *mqDecoder) int {
+	prev := 1
+	for i := 0; i < d.length; i++ {
+		prev = prev<<1 | mq.decode(&d.cx[prev])
+	}
+	return prev - 1<<d.length
+}
+
+// jbig2TextContexts are the arithmetic decoding contexts of a text
+// region; a symbol dictionary using refinement/aggregate coding shares
+// them with the text regions it decodes
+type jbig2TextContexts struct {
+	iadt, iafs, iads, iait, iari jbig2IntDecoder
+	iardw, iardh, iardx, iardy   jbig2IntDecoder
+	iaid                         *jbig2IDDecoder
+	refinement                   []mqContext
+}
+
+func newJBIG2TextContexts(symCodeLen, rTemplate int) *jbig2TextContexts {
+	return &jbig2TextContexts{
+		iaid:       newJBIG2IDDecoder(symCodeLen),
+		refinement: make([]mqContext, refinementContextSize(rTemplate)),
+	}
+}
+
+// Reference corners of text region symbol instances (7.4.3.1.1)
+const (
+	jbig2BottomLeft = iota
+	jbig2TopLeft
+	jbig2BottomRight
+	jbig2TopRight
+)
+
+// jbig2TextParams are the parameters of the text region decoding
+// procedure (Table 9)
+type jbig2TextParams struct {
+	huffman      bool
+	refine       bool
+	width        int
+	height       int
+	numInstances int
+	logStrips    int
+	symbols      []*jbig2Bitmap
+	symCodeLen   int
+	defPixel     int
+	combOp       uint8
+	transposed   bool
+	refCorner    int
+	dsOffset     int
+	rTemplate    int
+	rAT          [4]int
+
+	// Huffman tables; symCodes nil means fixed length symbol IDs
+	fs, ds, dt, rdw, rdh, rdx, rdy, rsize *jbig2HuffmanTable
+	symCodes                              *jbig2HuffmanTable
+}
+
+// ceilLog2 returns the number of bits needed to code n values
+func ceilLog2(n int) int {
+	l := 0
+	for 1<<l < n {
+		l++
+	}
+	return l
+}
+
+// decodeTextRegion places symbol instances into a new region (6.4.5).
+// Arithmetic decoding uses mq and cx, Huffman decoding r.
+func decodeTextRegion(p *jbig2TextParams, mq *mqDecoder, cx *jbig2TextContexts, r *jbig2BitReader) (*jbig2Bitmap, error) {
+	readInt := func(ia *jbig2IntDecoder, t *jbig2HuffmanTable) (int, bool, error) {
+		if p.huffman {
+			return t.decode(r)
+		}
+		v, ok := ia.decode(mq)
+		return v, ok, nil
+	}
+	readValue := func(ia *jbig2IntDecoder, t *jbig2HuffmanTable) (int, error) {
+		v, ok, err := readInt(ia, t)
+		if err == nil && !ok {
+			err = fmt.Errorf("unexpected out-of-band value")
+		}
+		return v, err
+	}
+
+	region := newJBIG2Bitmap(p.width, p.height)
+	if p.defPixel != 0 {
+		region.fill(1)
+	}
+	strips := 1 << p.logStrips
+
+	stripT, err := readValue(&cx.iadt, p.dt)
+	if err != nil {
+		return nil, err
+	}
+	stripT *= -strips
+	firstS := 0
+	for n := 0; n < p.numInstances; {
+		dt, err := readValue(&cx.iadt, p.dt)
+		if err != nil {
+			return nil, err
+		}
+		stripT += dt * strips
+
+		// Symbol instances of the strip
+		curS := 0
+		for first := true; ; first = false {
+			if first {
+				dfs, err := readValue(&cx.iafs, p.fs)
+				if err != nil {
+					return nil, err
+				}
+				firstS += dfs
+				curS = firstS
+			} else {
+				ids, ok, err := readInt(&cx.iads, p.ds)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					break
+				}
+				if n >= p.numInstances {
+					return nil, fmt.Errorf("too many symbol instances")
+				}
+				curS += ids + p.dsOffset
+			}
+
+			curT := 0
+			if strips > 1 {
+				if p.huffman {
+					curT = r.readBits(p.logStrips)
+				} else {
+					curT, _ = cx.iait.decode(mq)
+				}
+			}
+			t := stripT + curT
+
+			var id int
+			switch {
+			case p.symCodes != nil:
+				id, err = p.symCodes.decodeValue(r)
+			case p.huffman:
+				id = r.readBits(p.symCodeLen)
+			default:
+				id = cx.iaid.decode(mq)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if id < 0 || id >= len(p.symbols) {
+				return nil, fmt.Errorf("symbol ID %d out of range", id)
+			}
+			sym := p.symbols[id]
+
+			ri := 0
+			if p.refine {
+				if p.huffman {
+					ri = r.readBit()
+				} else {
+					ri, _ = cx.iari.decode(mq)
+				}
+			}
+			if ri == 1 {
+				if sym, err = refineSymbol(p, sym, mq, cx, r); err != nil {
+					return nil, err
+				}
+			}
+
+			w, h := sym.width, sym.height
+			if !p.transposed && (p.refCorner == jbig2TopRight || p.refCorner == jbig2BottomRight) {
+				curS += w - 1
+			} else if p.transposed && (p.refCorner == jbig2BottomLeft || p.refCorner == jbig2BottomRight) {
+				curS += h - 1
+			}
+			s := curS
+
+			x, y := s, t
+			if p.transposed {
+				x, y = t, s
+			}
+			switch p.refCorner {
+			case jbig2TopRight:
+				x -= w - 1
+			case jbig2BottomLeft:
+				y -= h - 1
+			case jbig2BottomRight:
+				x -= w - 1
+				y -= h - 1
+			}
+			region.compose(sym, x, y, p.combOp)
+
+			if !p.transposed && (p.refCorner == jbig2TopLeft || p.refCorner == jbig2BottomLeft) {
+				curS += w - 1
+			} else if p.transposed && (p.refCorner == jbig2TopLeft || p.refCorner == jbig2TopRight) {
+				curS += h - 1
+			}
+			n++
+		}
+	}
+	return region, nil
+}
+
+// refineSymbol decodes the refinement of a symbol instance (6.4.11)
+func refineSymbol(p *jbig2TextParams, sym *jbig2Bitmap, mq *mqDecoder, cx *jbig2TextContexts, r *jbig2BitReader) (*jbig2Bitmap, error) {
+	var vals [4]int
+	decoders := [4]*jbig2IntDecoder{&cx.iardw, &cx.iardh, &cx.iardx, &cx.iardy}
+	tables := [4]*jbig2HuffmanTable{p.rdw, p.rdh, p.rdx, p.rdy}
+	for i := range vals {
+		if p.huffman {
+			v, err := tables[i].decodeValue(r)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+		} else {
+			vals[i], _ = decoders[i].decode(mq)
+		}
+	}
+	rdw, rdh, rdx, rdy := vals[0], vals[1], vals[2], vals[3]
+	w, h := sym.width+rdw, sym.height+rdh
+	if w < 0 || h < 0 || int64(w)*int64(h) > jbig2MaxPixels {
+		return nil, fmt.Errorf("invalid refined symbol size %dx%d", w, h)
+	}
+	rp := &jbig2RefinementParams{
+		template:  p.rTemplate,
+		reference: sym,
+		dx:        rdw>>1 + rdx,
+		dy:        rdh>>1 + rdy,
+		at:        p.rAT,
+	}
+	if !p.huffman {
+		return decodeRefinementRegion(mq, cx.refinement, rp, w, h), nil
+	}
+
+	// Huffman coded regions carry the refinement as RSIZE bytes of
+	// arithmetically coded data
+	size, err := p.rsize.decodeValue(r)
+	if err != nil {
+		return nil, err
+	}
+	r.align()
+	if size < 0 || size > len(r.data)-r.pos {
+		return nil, fmt.Errorf("invalid refinement size %d", size)
+	}
+	bm := decodeRefinementRegion(newMQDecoder(r.data[r.pos:r.pos+size]), cx.refinement, rp, w, h)
+	r.pos += size
+	return bm, nil
+}
+
+// readSymbolIDTable reads the Huffman table of symbol IDs of a text
+// region (7.4.3.1.7)
+func readSymbolIDTable(r *jbig2BitReader, numSyms int) (*jbig2HuffmanTable, error) {
+	runLines := make([]jbig2HuffmanLine, 35)
+	for i := range runLines {
+		l := r.readBits(4)
+		if l < 0 {
+			return nil, fmt.Errorf("truncated symbol ID table")
+		}
+		runLines[i] = jbig2HuffmanLine{rangeLow: i, prefLen: l}
+	}
+	runTable, err := newJBIG2HuffmanTable(runLines)
+	if err != nil {
+		return nil, err
+	}
+
+	lens := make([]int, numSyms)
+	for i := 0; i < numSyms; {
+		code, err := runTable.decodeValue(r)
+		if err != nil {
+			return nil, err
+		}
+		value, repeat := 0, 1
+		switch {
+		case code < 32:
+			value = code
+		case code == 32:
+			if i == 0 {
+				return nil, fmt.Errorf("invalid symbol ID table")
+			}
+			value, repeat = lens[i-1], 3+r.readBits(2)
+		case code == 33:
+			repeat = 3 + r.readBits(3)
+		default:
+			repeat = 11 + r.readBits(7)
+		}
+		if repeat < 1 || i+repeat > numSyms {
+			return nil, fmt.Errorf("invalid symbol ID table")
+		}
+		for ; repeat > 0; repeat-- {
+			lens[i] = value
+			i++
+		}
+	}
+	r.align()
+
+	lines := make([]jbig2HuffmanLine, numSyms)
+	for i, l := range lens {
+		lines[i] = jbig2HuffmanLine{rangeLow: i, prefLen: l}
+	}
+	return newJBIG2HuffmanTable(lines)
+}
+
+// referredSymbols concatenates the exported symbols of the symbol
+// dictionaries seg refers to
+func (d *JBIG2Decoder) referredSymbols(seg *jbig2Segment) []*jbig2Bitmap {
+	var syms []*jbig2Bitmap
+	for _, r := range d.referredSegments(seg) {
+		if r.segmentType == jbig2SymbolDict {
+			syms = append(syms, r.symbols...)
+		}
+	}
+	return syms
+}
+
+// processTextRegion processes a text region segment (7.4.3)
+func (d *JBIG2Decoder) processTextRegion(seg *jbig2Segment) error {
+	info, err := readRegionInfo(seg.data)
+	if err != nil {
+		return err
+	}
+	data := seg.data[17:]
+	if len(data) < 2 {
+		return fmt.Errorf("missing text region flags")
+	}
+	flags := binary.BigEndian.Uint16(data)
+	data = data[2:]
+	p := &jbig2TextParams{
+		huffman:    flags&0x0001 != 0,
+		refine:     flags&0x0002 != 0,
+		width:      info.width,
+		height:     info.height,
+		logStrips:  int(flags>>2) & 0x03,
+		refCorner:  int(flags>>4) & 0x03,
+		transposed: flags&0x0040 != 0,
+		combOp:     uint8(flags>>7) & 0x03,
+		defPixel:   int(flags>>9) & 0x01,
+		dsOffset:   int(flags>>10) & 0x1F,
+		rTemplate:  int(flags>>15) & 0x01,
+	}
+	if p.dsOffset >= 16 {
+		p.dsOffset -= 32
+	}
+
+	var hflags uint16
+	if p.huffman {
+		if len(data) < 2 {
+			return fmt.Errorf("missing text region Huffman flags")
+		}
+		hflags = binary.BigEndian.Uint16(data)
+		data = data[2:]
+	}
+	if p.refine && p.rTemplate == 0 {
+		if len(data) < 4 {
+			return fmt.Errorf("truncated refinement adaptive template")
+		}
+		for i := range p.rAT {
+			p.rAT[i] = int(int8(data[i]))
+		}
+		data = data[4:]
+	}
+	if len(data) < 4 {
+		return fmt.Errorf("missing number of symbol instances")
+	}
+	p.numInstances = int(binary.BigEndian.Uint32(data))
+	data = data[4:]
+
+	p.symbols = d.referredSymbols(seg)
+	p.symCodeLen = ceilLog2(len(p.symbols))
+
+	if !p.huffman {
+		cx := newJBIG2TextContexts(p.symCodeLen, p.rTemplate)
+		bm, err := decodeTextRegion(p, newMQDecoder(data), cx, nil)
+		if err != nil {
+			return err
+		}
+		return d.storeRegion(seg, info, bm)
+	}
+
+	sel := &jbig2TableSelector{custom: d.referredTables(seg)}
+	picks := []struct {
+		dst      **jbig2HuffmanTable
+		value    int
+		standard []int
+	}{
+		{&p.fs, int(hflags) & 0x03, []int{6, 7}},
+		{&p.ds, int(hflags>>2) & 0x03, []int{8, 9, 10}},
+		{&p.dt, int(hflags>>4) & 0x03, []int{11, 12, 13}},
+		{&p.rdw, int(hflags>>6) & 0x03, []int{14, 15}},
+		{&p.rdh, int(hflags>>8) & 0x03, []int{14, 15}},
+		{&p.rdx, int(hflags>>10) & 0x03, []int{14, 15}},
+		{&p.rdy, int(hflags>>12) & 0x03, []int{14, 15}},
+		{&p.rsize, int(hflags>>14) & 0x01, []int{1}},
+	}
+	for _, pk := range picks {
+		if *pk.dst, err = sel.pick(pk.value, pk.standard...); err != nil {
+			return err
+		}
+	}
+
+	r := newJBIG2BitReader(data)
+	if p.symCodes, err = readSymbolIDTable(r, len(p.symbols)); err != nil {
+		return err
+	}
+	cx := newJBIG2TextContexts(0, p.rTemplate)
+	bm, err := decodeTextRegion(p, nil, cx, r)
+	if err != nil {
+		return err
+	}
+	return d.storeRegion(seg, info, bm)
+}
+
+// processSymbolDict processes a symbol dictionary segment (7.4.2, 6.5)
+func (d *JBIG2Decoder) processSymbolDict(seg *jbig2Segment) error {
+	data := seg.data
+	if len(data) < 2 {
+		return fmt.Errorf("missing symbol dictionary flags")
+	}
+	flags := binary.BigEndian.Uint16(data)
+	pos := 2
+	huffman := flags&0x0001 != 0
+	refAgg := flags&0x0002 != 0
+	template := int(flags>>10) & 0x03
+	rTemplate := int(flags>>12) & 0x01
+
+	gp := &jbig2GenericParams{template: template}
+	if !huffman {
+		at, n, err := readGenericAT(data[pos:], template)
+		if err != nil {
+			return err
+		}
+		gp.at = at
+		pos += n
+	}
+	var rAT [4]int
+	if refAgg && rTemplate == 0 {
+		if len(data) < pos+4 {
+			return fmt.Errorf("truncated refinement adaptive template")
+		}
+		for i := range rAT {
+			rAT[i] = int(int8(data[pos+i]))
+		}
+		pos += 4
+	}
+	if len(data) < pos+8 {
+		return fmt.Errorf("truncated symbol dictionary")
+	}
+	numNew := int(binary.BigEndian.Uint32(data[pos+4:]))
+	pos += 8
+
+	inSyms := d.referredSymbols(seg)
+	symCodeLen := ceilLog2(len(inSyms) + numNew)
+
+	// Coding contexts, possibly inherited from the last dictionary
+	sc := &jbig2SymbolContext{
+		generic:    make([]mqContext, genericContextSize(template)),
+		refinement: make([]mqContext, refinementContextSize(rTemplate)),
+	}
+	if flags&0x0100 != 0 {
+		for _, r := range d.referredSegments(seg) {
+			if r.retained != nil {
+				sc = r.retained
+			}
+		}
+	}
+	if flags&0x0200 != 0 {
+		seg.retained = sc
+	}
+	cx := newJBIG2TextContexts(symCodeLen, rTemplate)
+	cx.refinement = sc.refinement
+
+	var mq *mqDecoder
+	var r *jbig2BitReader
+	var dh, dw, bmSize, aggInst *jbig2HuffmanTable
+	if huffman {
+		var err error
+		sel := &jbig2TableSelector{custom: d.referredTables(seg)}
+		if dh, err = sel.pick(int(flags>>2)&0x03, 4, 5); err != nil {
+			return err
+		}
+		if dw, err = sel.pick(int(flags>>4)&0x03, 2, 3); err != nil {
+			return err
+		}
+		if bmSize, err = sel.pick(int(flags>>6)&0x01, 1); err != nil {
+			return err
+		}
+		if aggInst, err = sel.pick(int(flags>>7)&0x01, 1); err != nil {
+			return err
+		}
+		r = newJBIG2BitReader(data[pos:])
+	} else {
+		mq = newMQDecoder(data[pos:])
+	}
+	var iadh, iadw, iaex, iaai jbig2IntDecoder
+	readInt := func(ia *jbig2IntDecoder, t *jbig2HuffmanTable) (int, bool, error) {
+		if huffman {
+			return t.decode(r)
+		}
+		v, ok := ia.decode(mq)
+		return v, ok, nil
+	}
+
+	newSyms := make([]*jbig2Bitmap, 0, min(numNew, 1<<16))
+	var widths []int
+	hcHeight := 0
+	for len(newSyms) < numNew {
+		hcdh, ok, err := readInt(&iadh, dh)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("unexpected out-of-band height")
+		}
+		hcHeight += hcdh
+		if hcHeight < 0 {
+			return fmt.Errorf("negative symbol height")
+		}
+		symWidth, totWidth := 0, 0
+		hcFirst := len(newSyms)
+
+		for {
+			dwv, ok, err := readInt(&iadw, dw)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if len(newSyms) >= numNew {
+				return fmt.Errorf("too many symbols in height class")
+			}
+			symWidth += dwv
+			totWidth += symWidth
+			if symWidth < 0 || int64(symWidth)*int64(hcHeight) > jbig2MaxPixels {
+				return fmt.Errorf("invalid symbol size %dx%d", symWidth, hcHeight)
+			}
+			if huffman && !refAgg {
+				widths = append(widths, symWidth)
+				newSyms = append(newSyms, nil)
+				continue
+			}
+
+			var bm *jbig2Bitmap
+			if !refAgg {
+				bm = decodeGenericRegion(mq, sc.generic, gp, symWidth, hcHeight)
+			} else {
+				n, ok, err := readInt(&iaai, aggInst)
+				if err != nil {
+					return err
+				}
+				if !ok || n < 1 {
+					return fmt.Errorf("invalid number of aggregated symbols")
+				}
+				syms := append(append([]*jbig2Bitmap{}, inSyms...), newSyms...)
+				if n > 1 {
+					tp := &jbig2TextParams{
+						huffman:      huffman,
+						refine:       true,
+						width:        symWidth,
+						height:       hcHeight,
+						numInstances: n,
+						symbols:      syms,
+						symCodeLen:   symCodeLen,
+						combOp:       jbig2CombOr,
+						refCorner:    jbig2TopLeft,
+						rTemplate:    rTemplate,
+						rAT:          rAT,
+					}
+					if huffman {
+						std := jbig2StandardTables
+						tp.fs, tp.ds, tp.dt = std[6], std[8], std[11]
+						tp.rdw, tp.rdh, tp.rdx, tp.rdy = std[15], std[15], std[15], std[15]
+						tp.rsize = std[1]
+					}
+					if bm, err = decodeTextRegion(tp, mq, cx, r); err != nil {
+						return err
+					}
+				} else if bm, err = refineAggregate(syms, symWidth, hcHeight, huffman, symCodeLen, rTemplate, rAT, mq, cx, r); err != nil {
+					return err
+				}
+			}
+			newSyms = append(newSyms, bm)
+		}
+
+		if huffman && !refAgg {
+			if err := readCollectiveBitmap(r, bmSize, newSyms[hcFirst:], widths[hcFirst:], totWidth, hcHeight); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Exported symbols (6.5.10)
+	all := append(append([]*jbig2Bitmap{}, inSyms...), newSyms...)
+	var exported []*jbig2Bitmap
+	export := false
+	for i := 0; i < len(all); {
+		run, ok, err := readInt(&iaex, jbig2StandardTables[1])
+		if err != nil {
+			return err
+		}
+		if !ok || run < 0 || run > len(all)-i {
+			return fmt.Errorf("invalid export run length")
+		}
+		if export {
+			exported = append(exported, all[i:i+run]...)
+		}
+		i += run
+		export = !export
+	}
+	seg.symbols = exported
+	return nil
+}
+
+// refineAggregate decodes a symbol coded as the refinement of a single
+// symbol (6.5.8.2.2)
+func refineAggregate(syms []*jbig2Bitmap, w, h int, huffman bool, symCodeLen, rTemplate int, rAT [4]int,
+	mq *mqDecoder, cx *jbig2TextContexts, r *jbig2BitReader) (*jbig2Bitmap, error) {
+	var id, rdx, rdy int
+	if huffman {
+		var err error
+		id = r.readBits(symCodeLen)
+		if rdx, err = jbig2StandardTables[15].decodeValue(r); err != nil {
+			return nil, err
+		}
+		if rdy, err = jbig2StandardTables[15].decodeValue(r); err != nil {
+			return nil, err
+		}
+	} else {
+		id = cx.iaid.decode(mq)
+		rdx, _ = cx.iardx.decode(mq)
+		rdy, _ = cx.iardy.decode(mq)
+	}
+	if id < 0 || id >= len(syms) {
+		return nil, fmt.Errorf("symbol ID %d out of range", id)
+	}
+	rp := &jbig2RefinementParams{
+		template:  rTemplate,
+		reference: syms[id],
+		dx:        rdx,
+		dy:        rdy,
+		at:        rAT,
+	}
+	if !huffman {
+		return decodeRefinementRegion(mq, cx.refinement, rp, w, h), nil
+	}
+
+	size, err := jbig2StandardTables[1].decodeValue(r)
+	if err != nil {
+		return nil, err
+	}
+	r.align()
+	if size > len(r.data)-r.pos {
+		return nil, fmt.Errorf("invalid refinement size %d", size)
+	}
+	bm := decodeRefinementRegion(newMQDecoder(r.data[r.pos:r.pos+size]), cx.refinement, rp, w, h)
+	r.pos += size
+	return bm, nil
+}
+
+// readCollectiveBitmap reads the bitmap of a height class of a Huffman
+// coded dictionary, uncompressed or MMR coded, and splits it into the
+// symbols of the class (6.5.9)
+func readCollectiveBitmap(r *jbig2BitReader, bmSize *jbig2HuffmanTable, syms []*jbig2Bitmap, widths []int, totWidth, height int) error {
+	size, err := bmSize.decodeValue(r)
+	if err != nil {
+		return err
+	}
+	r.align()
+	if int64(totWidth)*int64(height) > jbig2MaxPixels {
+		return fmt.Errorf("collective bitmap too large")
+	}
+
+	var coll *jbig2Bitmap
+	if size == 0 {
+		coll = newJBIG2Bitmap(totWidth, height)
+		if len(coll.data) > len(r.data)-r.pos {
+			return fmt.Errorf("truncated collective bitmap")
+		}
+		copy(coll.data, r.data[r.pos:])
+		r.pos += len(coll.data)
+	} else {
+		if size < 0 || size > len(r.data)-r.pos {
+			return fmt.Errorf("invalid collective bitmap size %d", size)
+		}
+		if coll, _, err = decodeMMR(r.data[r.pos:r.pos+size], totWidth, height); err != nil {
+			return err
+		}
+		r.pos += size
+	}
+
+	x := 0
+	for i := range syms {
+		syms[i] = coll.sub(x, 0, widths[i], height)
+		x += widths[i]
+	}
+	return nil
+}
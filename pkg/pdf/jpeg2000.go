@@ -8,6 +8,9 @@ import (
 	"image"
 	"image/color"
 	"io"
+	"math"
+	"runtime"
+	"sync"
 )
 
 // JPEG2000 marker codes
@@ -16,6 +19,9 @@ const (
 	jp2HeaderBox    = 0x6A703268 // 'jp2h'
 	jp2ImageHeader  = 0x69686472 // 'ihdr'
 	jp2ColorSpec    = 0x636F6C72 // 'colr'
+	jp2Palette      = 0x70636C72 // 'pclr'
+	jp2ComponentMap = 0x636D6170 // 'cmap'
+	jp2ChannelDef   = 0x63646566 // 'cdef'
 	jp2CodeStream   = 0x6A703263 // 'jp2c'
 
 	// Codestream markers
@@ -36,6 +42,31 @@ type JPEG2000Decoder struct {
 	signed     bool
 	colorSpace string
 	data       []byte
+
+	codestream []byte
+	icc        []byte
+	palette    *jpxPalette
+	mapping    []jpxMapping
+	channels   []jpxChannel
+}
+
+// jpxPalette holds a palette box
+type jpxPalette struct {
+	depths  []int
+	signed  []bool
+	entries [][]int32 // entries[column][index]
+}
+
+// jpxMapping is a component mapping entry
+type jpxMapping struct {
+	component int
+	palette   bool
+	column    int
+}
+
+// jpxChannel is a channel definition entry
+type jpxChannel struct {
+	index, typ, assoc int
 }
 
 // JPEG2000 errors
@@ -44,6 +75,54 @@ var (
 	ErrUnsupportedJPEG2000 = errors.New("unsupported JPEG2000 feature")
 )
 
+// JPXImage is a decoded JPEG 2000 image. Samples are scaled to 16 bits
+// and subsampled components are expanded to the full image size.
+type JPXImage struct {
+	Width         int
+	Height        int
+	Depth         int        // bits per sample of the colour channels in the codestream
+	ColorSpace    string     // "Gray", "sRGB", "sYCC", "CMYK", "Lab" or empty when unknown
+	ICCProfile    []byte     // ICC profile of the JP2 colour specification
+	Color         [][]uint16 // colour channels, Width*Height samples each
+	Alpha         []uint16   // opacity channel, nil when absent
+	Premultiplied bool       // colour channels are premultiplied by Alpha
+}
+
+// DecodeJPX decodes JP2, JPX or raw JPEG 2000 codestream data
+func DecodeJPX(data []byte) (*JPXImage, error) {
+	decoder := &JPEG2000Decoder{data: data}
+	return decoder.decodeJPX()
+}
+
+// decodeJPXImage decodes a JPXDecode stream. The opacity channel is kept
+// only when the image dictionary sets SMaskInData; 2 marks the colour
+// channels as premultiplied. cs is the image's ColorSpace entry, which
+// overrides the colour specification of the JP2 header, or nil.
+func decodeJPXImage(data []byte, smaskInData int, cs ColorSpace, decode []float64) (image.Image, error) {
+	img, err := DecodeJPX(data)
+	if err != nil {
+		return nil, err
+	}
+	switch smaskInData {
+	case 0:
+		img.Alpha = nil
+	case 2:
+		img.Premultiplied = true
+	}
+	if cs == nil {
+		cs = img.embeddedColorSpace()
+		if cs == nil && img.ColorSpace == "CMYK" {
+			cs = DeviceCMYK
+		}
+	}
+	if cs != nil {
+		if out := img.convert(cs, decode); out != nil {
+			return out, nil
+		}
+	}
+	return img.Image(), nil
+}
+
 // DecodeJPEG2000 decodes JPEG2000 image data
 func DecodeJPEG2000(data []byte) (image.Image, error) {
 	decoder := &JPEG2000Decoder{data: data}
@@ -52,24 +131,26 @@ func DecodeJPEG2000(data []byte) (image.Image, error) {
 
 // Decode decodes the JPEG2000 image
 func (d *JPEG2000Decoder) Decode() (image.Image, error) {
-	if len(d.data) < 12 {
-		return nil, ErrInvalidJPEG2000
+	img, err := d.decodeJPX()
+	if err != nil {
+		return nil, err
 	}
+	return img.Image(), nil
+}
 
-	// Check if it's a JP2 file format or raw codestream
+// parseHeaders reads the JP2 boxes, if any, and locates the codestream
+func (d *JPEG2000Decoder) parseHeaders() error {
+	if len(d.data) < 12 {
+		return ErrInvalidJPEG2000
+	}
 	if d.isJP2Format() {
-		if err := d.parseJP2(); err != nil {
-			return nil, err
-		}
-	} else if d.isCodestream() {
-		if err := d.parseCodestream(d.data); err != nil {
-			return nil, err
-		}
-	} else {
-		return nil, ErrInvalidJPEG2000
+		return d.parseJP2()
 	}
-
-	return d.decodeImage()
+	if d.isCodestream() {
+		d.codestream = d.data
+		return nil
+	}
+	return ErrInvalidJPEG2000
 }
 
 func (d *JPEG2000Decoder) isJP2Format() bool {
@@ -94,479 +175,670 @@ func (d *JPEG2000Decoder) isCodestream() bool {
 	return marker == j2kSOC
 }
 
-func (d *JPEG2000Decoder) parseJP2() error {
+// jp2Boxes calls fn with the type and contents of each box in data
+func jp2Boxes(data []byte, fn func(boxType uint32, contents []byte) error) error {
 	offset := 0
-	var codestreamData []byte
-
-	for offset < len(d.data)-8 {
-		boxLen := int(binary.BigEndian.Uint32(d.data[offset : offset+4]))
-		boxType := binary.BigEndian.Uint32(d.data[offset+4 : offset+8])
-
-		if boxLen == 0 {
-			boxLen = len(d.data) - offset
-		} else if boxLen == 1 {
-			if offset+16 > len(d.data) {
-				break
+	for offset+8 <= len(data) {
+		boxLen := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		boxType := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+		header := 8
+		switch boxLen {
+		case 0:
+			boxLen = len(data) - offset
+		case 1:
+			if offset+16 > len(data) {
+				return ErrInvalidJPEG2000
 			}
-			boxLen = int(binary.BigEndian.Uint64(d.data[offset+8 : offset+16]))
-			offset += 8
+			l := binary.BigEndian.Uint64(data[offset+8 : offset+16])
+			if l > uint64(len(data)-offset) {
+				return ErrInvalidJPEG2000
+			}
+			boxLen = int(l)
+			header = 16
 		}
-
-		if boxLen < 8 || offset+boxLen > len(d.data) {
-			break
+		if boxLen < header || offset+boxLen > len(data) {
+			return ErrInvalidJPEG2000
+		}
+		if err := fn(boxType, data[offset+header:offset+boxLen]); err != nil {
+			return err
 		}
+		offset += boxLen
+	}
+	return nil
+}
 
+func (d *JPEG2000Decoder) parseJP2() error {
+	err := jp2Boxes(d.data, func(boxType uint32, contents []byte) error {
 		switch boxType {
 		case jp2HeaderBox:
-			if err := d.parseJP2Header(d.data[offset+8 : offset+boxLen]); err != nil {
-				return err
-			}
+			return d.parseJP2Header(contents)
 		case jp2CodeStream:
-			codestreamData = d.data[offset+8 : offset+boxLen]
+			if d.codestream == nil {
+				d.codestream = contents
+			}
 		}
-
-		offset += boxLen
+		return nil
+	})
+	if err != nil && d.codestream == nil {
+		return err
 	}
-
-	if codestreamData != nil {
-		return d.parseCodestream(codestreamData)
+	if d.codestream == nil {
+		return ErrInvalidJPEG2000
 	}
-
-	return ErrInvalidJPEG2000
+	return nil
 }
 
 func (d *JPEG2000Decoder) parseJP2Header(data []byte) error {
-	offset := 0
-
-	for offset < len(data)-8 {
-		boxLen := int(binary.BigEndian.Uint32(data[offset : offset+4]))
-		boxType := binary.BigEndian.Uint32(data[offset+4 : offset+8])
-
-		if boxLen < 8 || offset+boxLen > len(data) {
-			break
-		}
-
+	gotColor := false
+	return jp2Boxes(data, func(boxType uint32, box []byte) error {
 		switch boxType {
 		case jp2ImageHeader:
-			if boxLen >= 22 {
-				d.height = int(binary.BigEndian.Uint32(data[offset+8 : offset+12]))
-				d.width = int(binary.BigEndian.Uint32(data[offset+12 : offset+16]))
-				d.components = int(binary.BigEndian.Uint16(data[offset+16 : offset+18]))
-				bpc := data[offset+18]
-				d.signed = (bpc & 0x80) != 0
-				d.bitDepth = int(bpc&0x7F) + 1
+			if len(box) >= 14 {
+				d.height = int(binary.BigEndian.Uint32(box[0:4]))
+				d.width = int(binary.BigEndian.Uint32(box[4:8]))
+				d.components = int(binary.BigEndian.Uint16(box[8:10]))
+				bpc := box[10]
+				if bpc != 0xFF {
+					d.signed = (bpc & 0x80) != 0
+					d.bitDepth = int(bpc&0x7F) + 1
+				}
 			}
 		case jp2ColorSpec:
-			if boxLen >= 11 {
-				method := data[offset+8]
-				if method == 1 { // Enumerated colorspace
-					enumCS := binary.BigEndian.Uint32(data[offset+11 : offset+15])
-					switch enumCS {
-					case 16:
-						d.colorSpace = "sRGB"
-					case 17:
-						d.colorSpace = "Gray"
-					case 18:
-						d.colorSpace = "sYCC"
-					}
+			// The first colour specification that is understood wins
+			if len(box) < 3 || gotColor {
+				return nil
+			}
+			switch method := box[0]; {
+			case method == 1 && len(box) >= 7:
+				gotColor = true
+				switch binary.BigEndian.Uint32(box[3:7]) {
+				case 12:
+					d.colorSpace = "CMYK"
+				case 14:
+					d.colorSpace = "Lab"
+				case 16:
+					d.colorSpace = "sRGB"
+				case 17:
+					d.colorSpace = "Gray"
+				case 18:
+					d.colorSpace = "sYCC"
+				default:
+					gotColor = false
 				}
+			case method == 2 || method == 3:
+				gotColor = true
+				d.icc = box[3:]
+			}
+		case jp2Palette:
+			return d.parsePalette(box)
+		case jp2ComponentMap:
+			for i := 0; i+4 <= len(box); i += 4 {
+				d.mapping = append(d.mapping, jpxMapping{
+					component: int(binary.BigEndian.Uint16(box[i:])),
+					palette:   box[i+2] == 1,
+					column:    int(box[i+3]),
+				})
+			}
+		case jp2ChannelDef:
+			if len(box) < 2 {
+				return nil
+			}
+			n := int(binary.BigEndian.Uint16(box))
+			for i := 0; i < n && 2+6*i+6 <= len(box); i++ {
+				e := box[2+6*i:]
+				d.channels = append(d.channels, jpxChannel{
+					index: int(binary.BigEndian.Uint16(e)),
+					typ:   int(binary.BigEndian.Uint16(e[2:])),
+					assoc: int(binary.BigEndian.Uint16(e[4:])),
+				})
 			}
 		}
-
-		offset += boxLen
-	}
-
-	return nil
+		return nil
+	})
 }
 
-func (d *JPEG2000Decoder) parseCodestream(data []byte) error {
-	if len(data) < 2 {
+// parsePalette parses a palette box
+func (d *JPEG2000Decoder) parsePalette(box []byte) error {
+	if len(box) < 3 {
 		return ErrInvalidJPEG2000
 	}
-
-	offset := 0
-
-	// Check SOC marker
-	if binary.BigEndian.Uint16(data[offset:offset+2]) != j2kSOC {
+	n := int(binary.BigEndian.Uint16(box))
+	cols := int(box[2])
+	if n == 0 || n > 1024 || cols == 0 || len(box) < 3+cols {
 		return ErrInvalidJPEG2000
 	}
-	offset += 2
-
-	// Parse markers
-	for offset < len(data)-2 {
-		marker := binary.BigEndian.Uint16(data[offset : offset+2])
-		offset += 2
-
-		if marker == j2kSOD || marker == j2kEOC {
-			break
-		}
-
-		// Get marker segment length
-		if offset+2 > len(data) {
-			break
-		}
-		segLen := int(binary.BigEndian.Uint16(data[offset : offset+2]))
-		if segLen < 2 || offset+segLen > len(data) {
-			break
-		}
-
-		switch marker {
-		case j2kSIZ:
-			if err := d.parseSIZ(data[offset : offset+segLen]); err != nil {
-				return err
+	p := &jpxPalette{entries: make([][]int32, cols)}
+	for c := 0; c < cols; c++ {
+		b := box[3+c]
+		p.depths = append(p.depths, int(b&0x7F)+1)
+		p.signed = append(p.signed, b&0x80 != 0)
+		p.entries[c] = make([]int32, n)
+	}
+	pos := 3 + cols
+	for i := 0; i < n; i++ {
+		for c := 0; c < cols; c++ {
+			size := (p.depths[c] + 7) / 8
+			if pos+size > len(box) {
+				return ErrInvalidJPEG2000
 			}
-		case j2kCOD:
-			// Coding style default - skip for now
-		case j2kQCD:
-			// Quantization default - skip for now
+			var v int64
+			for k := 0; k < size; k++ {
+				v = v<<8 | int64(box[pos+k])
+			}
+			pos += size
+			if p.signed[c] && v >= 1<<(p.depths[c]-1) {
+				v -= 1 << p.depths[c]
+			}
+			p.entries[c][i] = int32(v)
 		}
-
-		offset += segLen
 	}
-
+	d.palette = p
 	return nil
 }
 
-func (d *JPEG2000Decoder) parseSIZ(data []byte) error {
-	if len(data) < 38 {
-		return ErrInvalidJPEG2000
-	}
-
-	// Skip length (2 bytes) and Rsiz (2 bytes)
-	offset := 4
-
-	// Image dimensions
-	xsiz := int(binary.BigEndian.Uint32(data[offset : offset+4]))
-	offset += 4
-	ysiz := int(binary.BigEndian.Uint32(data[offset : offset+4]))
-	offset += 4
-
-	// Image offset
-	xosiz := int(binary.BigEndian.Uint32(data[offset : offset+4]))
-	offset += 4
-	yosiz := int(binary.BigEndian.Uint32(data[offset : offset+4]))
-	offset += 4
-
-	// Tile dimensions (skip)
-	offset += 8 // XTsiz, YTsiz
-
-	// Tile offset (skip)
-	offset += 8 // XTOsiz, YTOsiz
-
-	// Number of components
-	csiz := int(binary.BigEndian.Uint16(data[offset : offset+2]))
-
-	d.width = xsiz - xosiz
-	d.height = ysiz - yosiz
-	d.components = csiz
-
-	// Parse component info
-	offset += 2
-	if offset+3 <= len(data) {
-		ssiz := data[offset]
-		d.signed = (ssiz & 0x80) != 0
-		d.bitDepth = int(ssiz&0x7F) + 1
-	}
-
-	return nil
+// jpxPlane is a decoded component or channel
+type jpxPlane struct {
+	data   []int32
+	w, h   int
+	dx, dy int
+	depth  int
+	signed bool
 }
 
-func (d *JPEG2000Decoder) decodeImage() (image.Image, error) {
-	if d.width <= 0 || d.height <= 0 {
-		return nil, ErrInvalidJPEG2000
+// decodeJPX decodes the image into colour and alpha channels
+func (d *JPEG2000Decoder) decodeJPX() (*JPXImage, error) {
+	if err := d.parseHeaders(); err != nil {
+		return nil, err
 	}
-
-	// For now, implement a basic decoder that handles common cases
-	// Full JPEG2000 decoding requires wavelet transform implementation
-
-	switch d.components {
-	case 1:
-		return d.decodeGrayscale()
-	case 3:
-		return d.decodeRGB()
-	case 4:
-		return d.decodeRGBA()
-	default:
-		return d.decodeGrayscale()
-	}
-}
-
-func (d *JPEG2000Decoder) decodeGrayscale() (image.Image, error) {
-	img := image.NewGray(image.Rect(0, 0, d.width, d.height))
-
-	// Basic implementation - fill with decoded data or placeholder
-	// Full implementation would require DWT (Discrete Wavelet Transform)
-	coeffs := d.extractCoefficients()
-	if coeffs != nil {
-		d.applyInverseTransform(coeffs, img)
+	cs, err := parseJPXCodestream(d.codestream)
+	if err != nil {
+		return nil, err
 	}
-
-	return img, nil
-}
-
-func (d *JPEG2000Decoder) decodeRGB() (image.Image, error) {
-	img := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
-
-	coeffs := d.extractCoefficients()
-	if coeffs != nil {
-		d.applyInverseTransformRGB(coeffs, img)
+	planes, err := cs.decode()
+	if err != nil {
+		return nil, err
+	}
+	siz := &cs.siz
+	d.width, d.height = siz.x1-siz.x0, siz.y1-siz.y0
+	d.components = len(siz.comps)
+
+	// Apply the palette through the component mapping
+	if d.palette != nil {
+		mapping := d.mapping
+		if mapping == nil {
+			for c := range d.palette.entries {
+				mapping = append(mapping, jpxMapping{palette: true, column: c})
+			}
+		}
+		var mapped []*jpxPlane
+		for _, m := range mapping {
+			if m.component >= len(planes) {
+				return nil, ErrInvalidJPEG2000
+			}
+			src := planes[m.component]
+			if !m.palette {
+				mapped = append(mapped, src)
+				continue
+			}
+			if m.column >= len(d.palette.entries) {
+				return nil, ErrInvalidJPEG2000
+			}
+			entries := d.palette.entries[m.column]
+			out := *src
+			out.data = make([]int32, len(src.data))
+			out.depth = d.palette.depths[m.column]
+			out.signed = d.palette.signed[m.column]
+			for i, v := range src.data {
+				out.data[i] = entries[max(0, min(int(v), len(entries)-1))]
+			}
+			mapped = append(mapped, &out)
+		}
+		planes = mapped
 	}
 
-	return img, nil
-}
-
-func (d *JPEG2000Decoder) decodeRGBA() (image.Image, error) {
-	img := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
-
-	coeffs := d.extractCoefficients()
-	if coeffs != nil {
-		d.applyInverseTransformRGBA(coeffs, img)
+	img := &JPXImage{
+		Width:      d.width,
+		Height:     d.height,
+		ColorSpace: d.colorSpace,
+		ICCProfile: d.icc,
 	}
 
-	return img, nil
-}
-
-// extractCoefficients extracts wavelet coefficients from the codestream
-func (d *JPEG2000Decoder) extractCoefficients() [][]float64 {
-	// Find the compressed data after SOD marker
-	data := d.data
-	if d.isJP2Format() {
-		// Find codestream in JP2
-		offset := 0
-		for offset < len(data)-8 {
-			boxLen := int(binary.BigEndian.Uint32(data[offset : offset+4]))
-			boxType := binary.BigEndian.Uint32(data[offset+4 : offset+8])
-			if boxLen == 0 {
-				boxLen = len(data) - offset
+	// Sort the channels into colour channels and opacity
+	var colors []*jpxPlane
+	var alpha *jpxPlane
+	if d.channels != nil {
+		assoc := make(map[int]*jpxPlane)
+		maxAssoc := 0
+		for _, ch := range d.channels {
+			if ch.index >= len(planes) {
+				continue
 			}
-			if boxType == jp2CodeStream {
-				data = data[offset+8 : offset+boxLen]
-				break
+			switch ch.typ {
+			case 0:
+				if ch.assoc > 0 {
+					assoc[ch.assoc] = planes[ch.index]
+					maxAssoc = max(maxAssoc, ch.assoc)
+				}
+			case 1, 2:
+				if alpha == nil {
+					alpha = planes[ch.index]
+					img.Premultiplied = ch.typ == 2
+				}
 			}
-			offset += boxLen
 		}
-	}
-
-	// Find SOD marker
-	for i := 0; i < len(data)-2; i++ {
-		if binary.BigEndian.Uint16(data[i:i+2]) == j2kSOD {
-			// Data starts after SOD
-			compressedData := data[i+2:]
-			return d.decompressData(compressedData)
+		for i := 1; i <= maxAssoc; i++ {
+			if p, ok := assoc[i]; ok {
+				colors = append(colors, p)
+			}
 		}
 	}
-
-	return nil
-}
-
-// decompressData performs entropy decoding and extracts coefficients
-func (d *JPEG2000Decoder) decompressData(data []byte) [][]float64 {
-	// Simplified implementation
-	// Full implementation would require:
-	// 1. Arithmetic decoding (MQ-coder)
-	// 2. Tier-1 decoding (bit-plane decoding)
-	// 3. Tier-2 decoding (packet parsing)
-
-	coeffs := make([][]float64, d.components)
-	size := d.width * d.height
-
-	for c := 0; c < d.components; c++ {
-		coeffs[c] = make([]float64, size)
-
-		// Extract raw data if available
-		startOffset := c * size
-		if startOffset+size <= len(data) {
-			for i := 0; i < size; i++ {
-				coeffs[c][i] = float64(data[startOffset+i])
+	if colors == nil {
+		n := len(planes)
+		switch d.colorSpace {
+		case "Gray":
+			n = 1
+		case "sRGB", "sYCC", "Lab":
+			n = 3
+		case "CMYK":
+			n = 4
+		default:
+			if channels := iccChannels(d.icc); channels > 0 {
+				n = channels
+			} else if n == 2 {
+				// Gray with an extra opacity channel
+				n = 1
 			}
 		}
+		n = min(n, len(planes))
+		colors = planes[:n]
+		if alpha == nil && len(planes) == n+1 {
+			alpha = planes[n]
+		}
+	}
+	if img.ColorSpace == "" && d.icc == nil {
+		switch len(colors) {
+		case 1:
+			img.ColorSpace = "Gray"
+		case 3:
+			img.ColorSpace = "sRGB"
+		case 4:
+			img.ColorSpace = "CMYK"
+		}
 	}
 
-	return coeffs
-}
-
-// applyInverseTransform applies inverse DWT to grayscale image
-func (d *JPEG2000Decoder) applyInverseTransform(coeffs [][]float64, img *image.Gray) {
-	if len(coeffs) == 0 || len(coeffs[0]) == 0 {
-		return
+	for _, p := range colors {
+		img.Depth = max(img.Depth, p.depth)
+		img.Color = append(img.Color, expandPlane(p, siz))
+	}
+	if alpha != nil {
+		img.Alpha = expandPlane(alpha, siz)
 	}
+	if img.ColorSpace == "sYCC" && len(img.Color) == 3 {
+		syccToRGB(img.Color)
+		img.ColorSpace = "sRGB"
+	}
+	return img, nil
+}
 
-	// Apply inverse 2D DWT
-	reconstructed := d.inverseDWT2D(coeffs[0], d.width, d.height)
+// iccChannels returns the number of colour channels of an ICC profile,
+// or 0 when it is not known
+func iccChannels(profile []byte) int {
+	if len(profile) < 20 {
+		return 0
+	}
+	switch string(profile[16:20]) {
+	case "GRAY":
+		return 1
+	case "RGB ", "Lab ", "YCbr", "XYZ ":
+		return 3
+	case "CMYK":
+		return 4
+	}
+	return 0
+}
 
-	// Copy to image
-	for y := 0; y < d.height; y++ {
-		for x := 0; x < d.width; x++ {
-			idx := y*d.width + x
-			if idx < len(reconstructed) {
-				val := clampFloat(reconstructed[idx], 0, 255)
-				img.SetGray(x, y, color.Gray{Y: uint8(val)})
-			}
+// decode decodes all tiles into one plane per component
+func (cs *jpxCodestream) decode() ([]*jpxPlane, error) {
+	siz := &cs.siz
+	planes := make([]*jpxPlane, len(siz.comps))
+	for c, comp := range siz.comps {
+		p := &jpxPlane{
+			w:      ceilDiv(siz.x1, comp.dx) - ceilDiv(siz.x0, comp.dx),
+			h:      ceilDiv(siz.y1, comp.dy) - ceilDiv(siz.y0, comp.dy),
+			dx:     comp.dx,
+			dy:     comp.dy,
+			depth:  comp.depth,
+			signed: comp.signed,
+		}
+		if int64(p.w)*int64(p.h) > 1<<28 {
+			return nil, ErrUnsupportedJPEG2000
+		}
+		p.data = make([]int32, p.w*p.h)
+		planes[c] = p
+	}
+	for _, index := range cs.order {
+		if err := cs.decodeTile(cs.tiles[index], planes); err != nil {
+			return nil, err
 		}
 	}
+	return planes, nil
 }
 
-// applyInverseTransformRGB applies inverse DWT to RGB image
-func (d *JPEG2000Decoder) applyInverseTransformRGB(coeffs [][]float64, img *image.RGBA) {
-	if len(coeffs) < 3 {
-		return
+// decodeTile decodes one tile into the component planes
+func (cs *jpxCodestream) decodeTile(t *jpxTile, planes []*jpxPlane) error {
+	siz := &cs.siz
+	p, q := t.index%siz.ntx, t.index/siz.ntx
+	tx0 := max(siz.tx0+p*siz.tw, siz.x0)
+	ty0 := max(siz.ty0+q*siz.th, siz.y0)
+	tx1 := min(siz.tx0+(p+1)*siz.tw, siz.x1)
+	ty1 := min(siz.ty0+(q+1)*siz.th, siz.y1)
+	cod := cs.codingStyle(t)
+
+	comps := make([]*jpxTileComponent, len(siz.comps))
+	for c := range siz.comps {
+		tc, err := cs.newTileComponent(t, c, tx0, ty0, tx1, ty1)
+		if err != nil {
+			return err
+		}
+		comps[c] = tc
 	}
 
-	// Apply inverse DWT to each component
-	r := d.inverseDWT2D(coeffs[0], d.width, d.height)
-	g := d.inverseDWT2D(coeffs[1], d.width, d.height)
-	b := d.inverseDWT2D(coeffs[2], d.width, d.height)
+	// Tier-2: packets stop at the end of the data of truncated streams
+	body := &jpxBitReader{data: t.data}
+	hdr := body
+	if t.packed {
+		hdr = &jpxBitReader{data: t.headers}
+	}
+	for _, pk := range cs.packetOrder(t, comps, tx0, ty0) {
+		if err := cs.readPacket(cod, comps[pk.comp], pk, hdr, body); err != nil {
+			break
+		}
+	}
 
-	// Copy to image
-	for y := 0; y < d.height; y++ {
-		for x := 0; x < d.width; x++ {
-			idx := y*d.width + x
-			var rv, gv, bv uint8
-			if idx < len(r) {
-				rv = uint8(clampFloat(r[idx], 0, 255))
-			}
-			if idx < len(g) {
-				gv = uint8(clampFloat(g[idx], 0, 255))
+	// Tier-1 decoding of all code-blocks in parallel
+	type job struct {
+		cb    *jpxCodeBlock
+		band  *jpxBand
+		style *jpxComponentStyle
+		roi   int
+	}
+	jobs := make(chan job, 64)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				decodeCodeBlock(j.cb, j.band, j.style, j.roi)
 			}
-			if idx < len(b) {
-				bv = uint8(clampFloat(b[idx], 0, 255))
+		}()
+	}
+	for c, tc := range comps {
+		roi := cs.roiShift(t, c)
+		for _, res := range tc.resolutions {
+			for _, band := range res.bands {
+				for _, prec := range band.precincts {
+					for _, cb := range prec.blocks {
+						jobs <- job{cb, band, tc.style, roi}
+					}
+				}
 			}
-			img.SetRGBA(x, y, color.RGBA{R: rv, G: gv, B: bv, A: 255})
 		}
 	}
-}
+	close(jobs)
+	wg.Wait()
 
-// applyInverseTransformRGBA applies inverse DWT to RGBA image
-func (d *JPEG2000Decoder) applyInverseTransformRGBA(coeffs [][]float64, img *image.RGBA) {
-	if len(coeffs) < 4 {
-		d.applyInverseTransformRGB(coeffs, img)
-		return
+	samples := make([][]float32, len(comps))
+	for c, tc := range comps {
+		samples[c] = inverseDWT(tc)
+	}
+	if cod.mct == 1 && len(comps) >= 3 {
+		inverseMCT(samples[0], samples[1], samples[2], comps[0].style.reversible)
 	}
 
-	// Apply inverse DWT to each component
-	r := d.inverseDWT2D(coeffs[0], d.width, d.height)
-	g := d.inverseDWT2D(coeffs[1], d.width, d.height)
-	b := d.inverseDWT2D(coeffs[2], d.width, d.height)
-	a := d.inverseDWT2D(coeffs[3], d.width, d.height)
-
-	// Copy to image
-	for y := 0; y < d.height; y++ {
-		for x := 0; x < d.width; x++ {
-			idx := y*d.width + x
-			var rv, gv, bv, av uint8
-			if idx < len(r) {
-				rv = uint8(clampFloat(r[idx], 0, 255))
-			}
-			if idx < len(g) {
-				gv = uint8(clampFloat(g[idx], 0, 255))
-			}
-			if idx < len(b) {
-				bv = uint8(clampFloat(b[idx], 0, 255))
-			}
-			if idx < len(a) {
-				av = uint8(clampFloat(a[idx], 0, 255))
+	// DC level shift and clipping (G.1.2)
+	for c, tc := range comps {
+		plane := planes[c]
+		comp := siz.comps[c]
+		lo, hi := int32(0), int32(1)<<comp.depth-1
+		shift := int32(1) << (comp.depth - 1)
+		if comp.signed {
+			lo, hi, shift = -shift, shift-1, 0
+		}
+		w := tc.x1 - tc.x0
+		ox := tc.x0 - ceilDiv(siz.x0, comp.dx)
+		oy := tc.y0 - ceilDiv(siz.y0, comp.dy)
+		for y := 0; y < tc.y1-tc.y0 && y < len(samples[c])/max(w, 1); y++ {
+			row := plane.data[(oy+y)*plane.w+ox:]
+			for x := 0; x < w; x++ {
+				v := int32(math.Round(float64(samples[c][y*w+x]))) + shift
+				if v < lo {
+					v = lo
+				} else if v > hi {
+					v = hi
+				}
+				row[x] = v
 			}
-			img.SetRGBA(x, y, color.RGBA{R: rv, G: gv, B: bv, A: av})
 		}
 	}
+	return nil
 }
 
-// inverseDWT2D performs 2D inverse discrete wavelet transform
-func (d *JPEG2000Decoder) inverseDWT2D(coeffs []float64, width, height int) []float64 {
-	if len(coeffs) != width*height {
-		// Return coefficients as-is if size mismatch
-		result := make([]float64, width*height)
-		copy(result, coeffs)
-		return result
+// expandPlane scales a plane to 16 bits and expands subsampled planes
+// to the image size
+func expandPlane(p *jpxPlane, siz *jpxSize) []uint16 {
+	w, h := siz.x1-siz.x0, siz.y1-siz.y0
+	out := make([]uint16, w*h)
+	offset := int64(0)
+	if p.signed {
+		offset = 1 << (p.depth - 1)
+	}
+	maxIn := int64(1)<<p.depth - 1
+	scale := func(v int32) uint16 {
+		u := int64(v) + offset
+		if u < 0 {
+			u = 0
+		} else if u > maxIn {
+			u = maxIn
+		}
+		if p.depth >= 16 {
+			return uint16(u >> (p.depth - 16))
+		}
+		return uint16((u*65535 + maxIn/2) / maxIn)
 	}
 
-	result := make([]float64, len(coeffs))
-	copy(result, coeffs)
-
-	// Apply inverse DWT (simplified 5/3 wavelet)
-	// Process columns
-	temp := make([]float64, height)
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			temp[y] = result[y*width+x]
+	ox, oy := ceilDiv(siz.x0, p.dx), ceilDiv(siz.y0, p.dy)
+	if p.dx == 1 && p.dy == 1 && p.w == w && p.h == h {
+		for i, v := range p.data {
+			out[i] = scale(v)
 		}
-		d.inverseDWT1D(temp)
-		for y := 0; y < height; y++ {
-			result[y*width+x] = temp[y]
+		return out
+	}
+	for y := 0; y < h; y++ {
+		sy := max(0, min((siz.y0+y)/p.dy-oy, p.h-1))
+		for x := 0; x < w; x++ {
+			sx := max(0, min((siz.x0+x)/p.dx-ox, p.w-1))
+			if p.w > 0 && p.h > 0 {
+				out[y*w+x] = scale(p.data[sy*p.w+sx])
+			}
 		}
 	}
+	return out
+}
 
-	// Process rows
-	temp = make([]float64, width)
-	for y := 0; y < height; y++ {
-		copy(temp, result[y*width:(y+1)*width])
-		d.inverseDWT1D(temp)
-		copy(result[y*width:(y+1)*width], temp)
+// syccToRGB converts sYCC channels to sRGB in place
+func syccToRGB(ch [][]uint16) {
+	for i := range ch[0] {
+		y := float64(ch[0][i])
+		cb := float64(ch[1][i]) - 32768
+		cr := float64(ch[2][i]) - 32768
+		ch[0][i] = clampUint16(y + 1.402*cr)
+		ch[1][i] = clampUint16(y - 0.344136*cb - 0.714136*cr)
+		ch[2][i] = clampUint16(y + 1.772*cb)
 	}
+}
 
-	return result
+func clampUint16(v float64) uint16 {
+	return uint16(math.Round(clampFloat(v, 0, 65535)))
 }
 
-// inverseDWT1D performs 1D inverse discrete wavelet transform (5/3 lifting)
-func (d *JPEG2000Decoder) inverseDWT1D(data []float64) {
-	n := len(data)
-	if n < 2 {
-		return
+func clampFloat(v, min, max float64) float64 {
+	if v < min {
+		return min
 	}
+	if v > max {
+		return max
+	}
+	return v
+}
 
-	// Split into low and high frequency components
-	half := (n + 1) / 2
-	low := make([]float64, half)
-	high := make([]float64, n-half)
-
-	for i := 0; i < half; i++ {
-		low[i] = data[i]
+// embeddedColorSpace returns the colour space of Lab images and of images
+// with a usable ICC profile, or nil
+func (m *JPXImage) embeddedColorSpace() ColorSpace {
+	if m.ColorSpace == "Lab" {
+		// Default Lab ranges of ITU-T T.800 Annex M
+		return &labColorSpace{white: iccD50, rng: [4]float64{-85, 85, -75, 125}}
 	}
-	for i := 0; i < n-half; i++ {
-		high[i] = data[half+i]
+	if m.ColorSpace == "" && m.ICCProfile != nil {
+		if profile, err := ParseICCProfile(m.ICCProfile); err == nil && profile.Channels == len(m.Color) {
+			return iccColorSpace(profile)
+		}
 	}
+	return nil
+}
 
-	// Inverse lifting steps (5/3 wavelet)
-	// Step 1: Update odd samples
-	for i := 0; i < len(high); i++ {
-		left := low[i]
-		right := low[i]
-		if i+1 < len(low) {
-			right = low[i+1]
+// convert converts the colour channels through cs, keeping the opacity
+// channel. It returns nil when the channels do not match cs.
+func (m *JPXImage) convert(cs ColorSpace, decode []float64) image.Image {
+	n := len(m.Color)
+	if n == 0 || cs.NComponents() != n {
+		return nil
+	}
+	samples := make([]byte, 2*n*m.Width*m.Height)
+	for i := 0; i < m.Width*m.Height; i++ {
+		for c := 0; c < n; c++ {
+			v := m.Color[c][i]
+			if m.Premultiplied && m.Alpha != nil && m.Alpha[i] != 0 {
+				v = uint16(min(65535, int(uint32(v)*65535/uint32(m.Alpha[i]))))
+			}
+			binary.BigEndian.PutUint16(samples[2*(i*n+c):], v)
 		}
-		high[i] = high[i] + (left+right)/4
 	}
+	img, err := colorImageDepth(cs, samples, m.Width, m.Height, 16, decode, m.Depth > 8)
+	if err != nil {
+		return nil
+	}
+	if m.Alpha == nil {
+		return img
+	}
+	if m.Depth > 8 {
+		out := image.NewNRGBA64(img.Bounds())
+		for i := 0; i < m.Width*m.Height; i++ {
+			r, g, b, _ := img.At(i%m.Width, i/m.Width).RGBA()
+			out.SetNRGBA64(i%m.Width, i/m.Width, color.NRGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: m.Alpha[i]})
+		}
+		return out
+	}
+	out := image.NewNRGBA(img.Bounds())
+	for i := 0; i < m.Width*m.Height; i++ {
+		r, g, b, _ := img.At(i%m.Width, i/m.Width).RGBA()
+		copy(out.Pix[4*i:], []uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(m.Alpha[i] >> 8)})
+	}
+	return out
+}
 
-	// Step 2: Update even samples
-	for i := 0; i < len(low); i++ {
-		left := float64(0)
-		right := float64(0)
-		if i > 0 {
-			left = high[i-1]
+// Image converts the decoded image to an image.Image: Gray or Gray16 for
+// one channel, RGBA or RGBA64 for three, CMYK for four, and NRGBA or
+// NRGBA64 when there is an opacity channel. Lab images and images with
+// an ICC profile are converted to sRGB.
+func (m *JPXImage) Image() image.Image {
+	if cs := m.embeddedColorSpace(); cs != nil {
+		if img := m.convert(cs, nil); img != nil {
+			return img
 		}
-		if i < len(high) {
-			right = high[i]
+	}
+	rect := image.Rect(0, 0, m.Width, m.Height)
+	n := m.Width * m.Height
+	deep := m.Depth > 8
+	hi := func(v uint16) uint8 { return uint8(v >> 8) }
+
+	switch {
+	case len(m.Color) == 4 && m.ColorSpace == "CMYK" && m.Alpha == nil:
+		img := image.NewCMYK(rect)
+		for i := 0; i < n; i++ {
+			for c := 0; c < 4; c++ {
+				img.Pix[4*i+c] = hi(m.Color[c][i])
+			}
+		}
+		return img
+	case len(m.Color) == 1 && m.Alpha == nil:
+		if deep {
+			img := image.NewGray16(rect)
+			for i, v := range m.Color[0] {
+				img.Pix[2*i], img.Pix[2*i+1] = uint8(v>>8), uint8(v)
+			}
+			return img
 		}
-		low[i] = low[i] - (left+right)/2
+		img := image.NewGray(rect)
+		for i, v := range m.Color[0] {
+			img.Pix[i] = hi(v)
+		}
+		return img
+	}
+
+	// Three colour channels, or gray and other layouts reduced to RGB
+	rgb := func(i int) (r, g, b uint16) {
+		switch {
+		case len(m.Color) >= 4 && m.ColorSpace == "CMYK":
+			k := uint32(m.Color[3][i])
+			conv := func(v uint16) uint16 { return uint16((65535 - uint32(v)) * (65535 - k) / 65535) }
+			return conv(m.Color[0][i]), conv(m.Color[1][i]), conv(m.Color[2][i])
+		case len(m.Color) >= 3:
+			return m.Color[0][i], m.Color[1][i], m.Color[2][i]
+		case len(m.Color) >= 1:
+			v := m.Color[0][i]
+			return v, v, v
+		}
+		return 0, 0, 0
 	}
 
-	// Interleave
-	for i := 0; i < half; i++ {
-		data[2*i] = low[i]
-		if 2*i+1 < n && i < len(high) {
-			data[2*i+1] = high[i]
+	if m.Alpha != nil && !m.Premultiplied {
+		if deep {
+			img := image.NewNRGBA64(rect)
+			for i := 0; i < n; i++ {
+				r, g, b := rgb(i)
+				img.SetNRGBA64(i%m.Width, i/m.Width, color.NRGBA64{R: r, G: g, B: b, A: m.Alpha[i]})
+			}
+			return img
 		}
+		img := image.NewNRGBA(rect)
+		for i := 0; i < n; i++ {
+			r, g, b := rgb(i)
+			copy(img.Pix[4*i:], []uint8{hi(r), hi(g), hi(b), hi(m.Alpha[i])})
+		}
+		return img
 	}
-}
 
-func clampFloat(v, min, max float64) float64 {
-	if v < min {
-		return min
+	alpha := func(i int) uint16 {
+		if m.Alpha == nil {
+			return 0xFFFF
+		}
+		return m.Alpha[i]
 	}
-	if v > max {
-		return max
+	if deep {
+		img := image.NewRGBA64(rect)
+		for i := 0; i < n; i++ {
+			r, g, b := rgb(i)
+			img.SetRGBA64(i%m.Width, i/m.Width, color.RGBA64{R: r, G: g, B: b, A: alpha(i)})
+		}
+		return img
 	}
-	return v
+	img := image.NewRGBA(rect)
+	for i := 0; i < n; i++ {
+		r, g, b := rgb(i)
+		copy(img.Pix[4*i:], []uint8{hi(r), hi(g), hi(b), hi(alpha(i))})
+	}
+	return img
 }
 
 // JPEG2000Info contains information about a JPEG2000 image
@@ -581,26 +853,24 @@ type JPEG2000Info struct {
 // GetJPEG2000Info returns information about JPEG2000 data without decoding
 func GetJPEG2000Info(data []byte) (*JPEG2000Info, error) {
 	decoder := &JPEG2000Decoder{data: data}
-
-	if decoder.isJP2Format() {
-		if err := decoder.parseJP2(); err != nil {
-			return nil, err
-		}
-	} else if decoder.isCodestream() {
-		if err := decoder.parseCodestream(data); err != nil {
-			return nil, err
-		}
-	} else {
-		return nil, ErrInvalidJPEG2000
+	if err := decoder.parseHeaders(); err != nil {
+		return nil, err
 	}
-
-	return &JPEG2000Info{
-		Width:      decoder.width,
-		Height:     decoder.height,
-		Components: decoder.components,
-		BitDepth:   decoder.bitDepth,
+	cs, err := parseJPXCodestream(decoder.codestream)
+	if err != nil {
+		return nil, err
+	}
+	info := &JPEG2000Info{
+		Width:      cs.siz.x1 - cs.siz.x0,
+		Height:     cs.siz.y1 - cs.siz.y0,
+		Components: len(cs.siz.comps),
+		BitDepth:   cs.siz.comps[0].depth,
 		ColorSpace: decoder.colorSpace,
-	}, nil
+	}
+	if info.ColorSpace == "" && decoder.icc != nil {
+		info.ColorSpace = "ICC"
+	}
+	return info, nil
 }
 
 // JPEG2000Reader implements io.Reader for streaming JPEG2000 decoding
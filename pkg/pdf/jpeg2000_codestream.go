@@ -0,0 +1,1112 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Codestream markers (ITU-T T.800 Table A.2)
+const (
+	j2kSOT = 0xFF90 // Start of tile-part
+	j2kSOP = 0xFF91 // Start of packet
+	j2kEPH = 0xFF92 // End of packet header
+	j2kCOC = 0xFF53 // Coding style component
+	j2kQCC = 0xFF5D // Quantization component
+	j2kRGN = 0xFF5E // Region of interest
+	j2kPOC = 0xFF5F // Progression order change
+	j2kPPM = 0xFF60 // Packed packet headers, main header
+	j2kPPT = 0xFF61 // Packed packet headers, tile-part header
+)
+
+// Progression orders
+const (
+	jpxLRCP = iota
+	jpxRLCP
+	jpxRPCL
+	jpxPCRL
+	jpxCPRL
+)
+
+// Code-block style flags
+const (
+	jpxBypass  = 0x01 // selective arithmetic coding bypass
+	jpxReset   = 0x02 // reset context probabilities on each pass
+	jpxTermAll = 0x04 // termination on each coding pass
+	jpxVCausal = 0x08 // vertically causal context
+	jpxSegSym  = 0x20 // segmentation symbols
+)
+
+// jpxMaxPrecincts limits the precincts of a resolution level
+const jpxMaxPrecincts = 1 << 24
+
+// jpxComponent describes one image component from the SIZ marker
+type jpxComponent struct {
+	depth  int
+	signed bool
+	dx, dy int
+}
+
+// jpxSize holds the SIZ marker
+type jpxSize struct {
+	x0, y0, x1, y1   int // image area on the reference grid
+	tw, th, tx0, ty0 int // tile size and tile grid origin
+	ntx, nty         int // tiles across and down
+	comps            []jpxComponent
+}
+
+// jpxComponentStyle holds the component part of a COD or COC marker
+type jpxComponentStyle struct {
+	levels     int
+	cbw, cbh   int // code-block size exponents
+	cbStyle    int
+	reversible bool
+	precincts  []int // PPx | PPy<<4 per resolution
+}
+
+// jpxCodingStyle holds a COD marker
+type jpxCodingStyle struct {
+	sop, eph    bool
+	progression int
+	layers      int
+	mct         int
+	comp        jpxComponentStyle
+}
+
+// jpxQuantization holds a QCD or QCC marker
+type jpxQuantization struct {
+	style int // 0 none, 1 scalar derived, 2 scalar expounded
+	guard int
+	exps  []int
+	mants []int
+}
+
+// jpxProgressionChange is one entry of a POC marker
+type jpxProgressionChange struct {
+	rs, cs, lye, re, ce, order int
+}
+
+// jpxHeader holds the coding parameters of the main header or of a tile
+type jpxHeader struct {
+	cod *jpxCodingStyle
+	coc map[int]*jpxComponentStyle
+	qcd *jpxQuantization
+	qcc map[int]*jpxQuantization
+	rgn map[int]int
+	poc []jpxProgressionChange
+}
+
+// jpxTile collects the tile-parts of one tile
+type jpxTile struct {
+	index   int
+	header  jpxHeader
+	data    []byte
+	headers []byte // packet headers from PPM or PPT, nil when in the data
+	packed  bool
+}
+
+// jpxCodestream is a parsed JPEG 2000 codestream
+type jpxCodestream struct {
+	siz   jpxSize
+	main  jpxHeader
+	tiles map[int]*jpxTile
+	order []int
+}
+
+func newJPXHeader() jpxHeader {
+	return jpxHeader{
+		coc: make(map[int]*jpxComponentStyle),
+		qcc: make(map[int]*jpxQuantization),
+		rgn: make(map[int]int),
+	}
+}
+
+// parseJPXCodestream parses the markers of a codestream and splits it
+// into tiles
+func parseJPXCodestream(data []byte) (*jpxCodestream, error) {
+	if len(data) < 4 || binary.BigEndian.Uint16(data) != j2kSOC {
+		return nil, ErrInvalidJPEG2000
+	}
+	cs := &jpxCodestream{main: newJPXHeader(), tiles: make(map[int]*jpxTile)}
+	var ppm [][]byte
+	pos := 2
+	gotSIZ := false
+
+	// Main header
+	for {
+		if pos+4 > len(data) {
+			return nil, ErrInvalidJPEG2000
+		}
+		marker := binary.BigEndian.Uint16(data[pos:])
+		if marker == j2kSOT {
+			break
+		}
+		if marker>>8 != 0xFF || marker == j2kEOC {
+			return nil, ErrInvalidJPEG2000
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, ErrInvalidJPEG2000
+		}
+		seg := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		if marker == j2kSIZ {
+			if err := cs.parseSIZ(seg); err != nil {
+				return nil, err
+			}
+			gotSIZ = true
+			continue
+		}
+		if !gotSIZ {
+			return nil, ErrInvalidJPEG2000
+		}
+		if marker == j2kPPM {
+			if len(seg) > 0 {
+				ppm = append(ppm, seg[1:])
+			}
+			continue
+		}
+		if err := cs.parseHeaderMarker(&cs.main, marker, seg); err != nil {
+			return nil, err
+		}
+	}
+	if cs.main.cod == nil || cs.main.qcd == nil {
+		return nil, fmt.Errorf("%w: missing COD or QCD marker", ErrInvalidJPEG2000)
+	}
+
+	var ppmData []byte
+	for _, p := range ppm {
+		ppmData = append(ppmData, p...)
+	}
+
+	// Tile-parts
+	for pos+12 <= len(data) && binary.BigEndian.Uint16(data[pos:]) == j2kSOT {
+		start := pos
+		lsot := int(binary.BigEndian.Uint16(data[pos+2:]))
+		index := int(binary.BigEndian.Uint16(data[pos+4:]))
+		psot := int(binary.BigEndian.Uint32(data[pos+6:]))
+		partIndex := int(data[pos+10])
+		end := start + psot
+		if psot == 0 || end > len(data) {
+			end = len(data)
+			if end >= 2 && binary.BigEndian.Uint16(data[end-2:]) == j2kEOC {
+				end -= 2
+			}
+		}
+		if index >= cs.siz.ntx*cs.siz.nty {
+			return nil, fmt.Errorf("%w: tile index %d out of range", ErrInvalidJPEG2000, index)
+		}
+		tile := cs.tiles[index]
+		if tile == nil {
+			tile = &jpxTile{index: index, header: newJPXHeader()}
+			cs.tiles[index] = tile
+			cs.order = append(cs.order, index)
+		}
+
+		pos += 2 + lsot
+		var ppt [][]byte
+		for pos+2 <= end {
+			marker := binary.BigEndian.Uint16(data[pos:])
+			if marker == j2kSOD {
+				pos += 2
+				break
+			}
+			if pos+4 > end {
+				return nil, ErrInvalidJPEG2000
+			}
+			length := int(binary.BigEndian.Uint16(data[pos+2:]))
+			if length < 2 || pos+2+length > end {
+				return nil, ErrInvalidJPEG2000
+			}
+			seg := data[pos+4 : pos+2+length]
+			pos += 2 + length
+			if marker == j2kPPT {
+				if len(seg) > 0 {
+					ppt = append(ppt, seg[1:])
+				}
+				continue
+			}
+			if partIndex == 0 || marker == j2kPOC {
+				if err := cs.parseHeaderMarker(&tile.header, marker, seg); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if ppmData != nil {
+			tile.packed = true
+			if len(ppmData) >= 4 {
+				n := int(binary.BigEndian.Uint32(ppmData))
+				if n > len(ppmData)-4 {
+					n = len(ppmData) - 4
+				}
+				tile.headers = append(tile.headers, ppmData[4:4+n]...)
+				ppmData = ppmData[4+n:]
+			}
+		} else if ppt != nil {
+			tile.packed = true
+			for _, p := range ppt {
+				tile.headers = append(tile.headers, p...)
+			}
+		}
+		if pos < end {
+			tile.data = append(tile.data, data[pos:end]...)
+		}
+		pos = end
+		if pos+2 <= len(data) && binary.BigEndian.Uint16(data[pos:]) == j2kEOC {
+			break
+		}
+	}
+	return cs, nil
+}
+
+// parseSIZ parses the image and tile size marker
+func (cs *jpxCodestream) parseSIZ(seg []byte) error {
+	if len(seg) < 36 {
+		return ErrInvalidJPEG2000
+	}
+	u32 := func(i int) int { return int(binary.BigEndian.Uint32(seg[i:])) }
+	s := &cs.siz
+	s.x1, s.y1, s.x0, s.y0 = u32(2), u32(6), u32(10), u32(14)
+	s.tw, s.th, s.tx0, s.ty0 = u32(18), u32(22), u32(26), u32(30)
+	n := int(binary.BigEndian.Uint16(seg[34:]))
+	if n == 0 || n > 16384 || len(seg) < 36+3*n || s.tw <= 0 || s.th <= 0 ||
+		s.x1 <= s.x0 || s.y1 <= s.y0 || s.tx0 > s.x0 || s.ty0 > s.y0 {
+		return ErrInvalidJPEG2000
+	}
+	s.ntx = ceilDiv(s.x1-s.tx0, s.tw)
+	s.nty = ceilDiv(s.y1-s.ty0, s.th)
+	if s.ntx <= 0 || s.nty <= 0 || s.ntx*s.nty > 65535 {
+		return ErrInvalidJPEG2000
+	}
+	for i := 0; i < n; i++ {
+		ssiz := seg[36+3*i]
+		c := jpxComponent{
+			depth:  int(ssiz&0x7F) + 1,
+			signed: ssiz&0x80 != 0,
+			dx:     int(seg[37+3*i]),
+			dy:     int(seg[38+3*i]),
+		}
+		if c.dx == 0 || c.dy == 0 || c.depth > 31 {
+			return ErrInvalidJPEG2000
+		}
+		s.comps = append(s.comps, c)
+	}
+	return nil
+}
+
+// parseHeaderMarker parses a coding parameter marker into h
+func (cs *jpxCodestream) parseHeaderMarker(h *jpxHeader, marker uint16, seg []byte) error {
+	ncomp := len(cs.siz.comps)
+	compIndex := func() (int, []byte, error) {
+		if ncomp < 257 {
+			if len(seg) < 1 {
+				return 0, nil, ErrInvalidJPEG2000
+			}
+			return int(seg[0]), seg[1:], nil
+		}
+		if len(seg) < 2 {
+			return 0, nil, ErrInvalidJPEG2000
+		}
+		return int(binary.BigEndian.Uint16(seg)), seg[2:], nil
+	}
+
+	switch marker {
+	case j2kCOD:
+		if len(seg) < 5 {
+			return ErrInvalidJPEG2000
+		}
+		cod := &jpxCodingStyle{
+			sop:         seg[0]&0x02 != 0,
+			eph:         seg[0]&0x04 != 0,
+			progression: int(seg[1]),
+			layers:      int(binary.BigEndian.Uint16(seg[2:])),
+			mct:         int(seg[4]),
+		}
+		comp, err := parseJPXComponentStyle(seg[5:], seg[0]&0x01 != 0)
+		if err != nil {
+			return err
+		}
+		cod.comp = *comp
+		if cod.layers == 0 || cod.progression > jpxCPRL {
+			return ErrInvalidJPEG2000
+		}
+		h.cod = cod
+	case j2kCOC:
+		c, rest, err := compIndex()
+		if err != nil || len(rest) < 1 || c >= ncomp {
+			return ErrInvalidJPEG2000
+		}
+		comp, err := parseJPXComponentStyle(rest[1:], rest[0]&0x01 != 0)
+		if err != nil {
+			return err
+		}
+		h.coc[c] = comp
+	case j2kQCD:
+		q, err := parseJPXQuantization(seg)
+		if err != nil {
+			return err
+		}
+		h.qcd = q
+	case j2kQCC:
+		c, rest, err := compIndex()
+		if err != nil || c >= ncomp {
+			return ErrInvalidJPEG2000
+		}
+		q, err := parseJPXQuantization(rest)
+		if err != nil {
+			return err
+		}
+		h.qcc[c] = q
+	case j2kRGN:
+		c, rest, err := compIndex()
+		if err != nil || len(rest) < 2 || c >= ncomp {
+			return ErrInvalidJPEG2000
+		}
+		h.rgn[c] = int(rest[1])
+	case j2kPOC:
+		csize := 1
+		if ncomp >= 257 {
+			csize = 2
+		}
+		entry := 5 + 2*csize
+		readComp := func(b []byte) int {
+			if csize == 1 {
+				return int(b[0])
+			}
+			return int(binary.BigEndian.Uint16(b))
+		}
+		for i := 0; i+entry <= len(seg); i += entry {
+			e := seg[i:]
+			p := jpxProgressionChange{
+				rs:    int(e[0]),
+				cs:    readComp(e[1:]),
+				lye:   int(binary.BigEndian.Uint16(e[1+csize:])),
+				re:    int(e[3+csize]),
+				ce:    readComp(e[4+csize:]),
+				order: int(e[4+2*csize]),
+			}
+			if p.ce == 0 {
+				p.ce = 256
+			}
+			h.poc = append(h.poc, p)
+		}
+	}
+	// CRG, TLM, PLM, PLT, COM and unknown markers are not needed
+	return nil
+}
+
+// parseJPXComponentStyle parses the SPcod or SPcoc parameters
+func parseJPXComponentStyle(b []byte, precincts bool) (*jpxComponentStyle, error) {
+	if len(b) < 5 {
+		return nil, ErrInvalidJPEG2000
+	}
+	s := &jpxComponentStyle{
+		levels:     int(b[0]),
+		cbw:        int(b[1]&0x0F) + 2,
+		cbh:        int(b[2]&0x0F) + 2,
+		cbStyle:    int(b[3]),
+		reversible: b[4] == 1,
+	}
+	if s.levels > 32 || s.cbw > 10 || s.cbh > 10 || s.cbw+s.cbh > 12 {
+		return nil, ErrInvalidJPEG2000
+	}
+	for r := 0; r <= s.levels; r++ {
+		pp := 0xFF // 2^15 precincts
+		if precincts {
+			if 5+r >= len(b) {
+				return nil, ErrInvalidJPEG2000
+			}
+			pp = int(b[5+r])
+		}
+		s.precincts = append(s.precincts, pp)
+	}
+	return s, nil
+}
+
+// parseJPXQuantization parses the Sqcd/Sqcc and SPqcd/SPqcc parameters
+func parseJPXQuantization(b []byte) (*jpxQuantization, error) {
+	if len(b) < 1 {
+		return nil, ErrInvalidJPEG2000
+	}
+	q := &jpxQuantization{style: int(b[0] & 0x1F), guard: int(b[0] >> 5)}
+	b = b[1:]
+	switch q.style {
+	case 0:
+		for _, v := range b {
+			q.exps = append(q.exps, int(v>>3))
+			q.mants = append(q.mants, 0)
+		}
+	case 1, 2:
+		for i := 0; i+2 <= len(b); i += 2 {
+			v := int(binary.BigEndian.Uint16(b[i:]))
+			q.exps = append(q.exps, v>>11)
+			q.mants = append(q.mants, v&0x7FF)
+		}
+	default:
+		return nil, ErrUnsupportedJPEG2000
+	}
+	if len(q.exps) == 0 {
+		return nil, ErrInvalidJPEG2000
+	}
+	return q, nil
+}
+
+// codingStyle returns the coding style of a tile, which overrides the
+// main header
+func (cs *jpxCodestream) codingStyle(t *jpxTile) *jpxCodingStyle {
+	if t.header.cod != nil {
+		return t.header.cod
+	}
+	return cs.main.cod
+}
+
+// componentStyle returns the coding style of component c in a tile.
+// Tile COC overrides tile COD, which overrides main COC and main COD.
+func (cs *jpxCodestream) componentStyle(t *jpxTile, c int) *jpxComponentStyle {
+	if s, ok := t.header.coc[c]; ok {
+		return s
+	}
+	if t.header.cod != nil {
+		return &t.header.cod.comp
+	}
+	if s, ok := cs.main.coc[c]; ok {
+		return s
+	}
+	return &cs.main.cod.comp
+}
+
+// quantization returns the quantization of component c in a tile, with
+// the same precedence as componentStyle
+func (cs *jpxCodestream) quantization(t *jpxTile, c int) *jpxQuantization {
+	if q, ok := t.header.qcc[c]; ok {
+		return q
+	}
+	if t.header.qcd != nil {
+		return t.header.qcd
+	}
+	if q, ok := cs.main.qcc[c]; ok {
+		return q
+	}
+	return cs.main.qcd
+}
+
+// roiShift returns the maximum shift of the region of interest of
+// component c
+func (cs *jpxCodestream) roiShift(t *jpxTile, c int) int {
+	if s, ok := t.header.rgn[c]; ok {
+		return s
+	}
+	return cs.main.rgn[c]
+}
+
+// jpxTileComponent is one component of a tile during decoding
+type jpxTileComponent struct {
+	x0, y0, x1, y1 int
+	style          *jpxComponentStyle
+	resolutions    []*jpxResolution
+}
+
+// jpxResolution is one resolution level of a tile-component
+type jpxResolution struct {
+	x0, y0, x1, y1 int
+	ppx, ppy       int // precinct size exponents
+	pw, ph         int // precincts across and down
+	bands          []*jpxBand
+}
+
+// jpxBand is a subband with its precinct partition
+type jpxBand struct {
+	kind           int // 0 LL, 1 HL, 2 LH, 3 HH
+	x0, y0, x1, y1 int
+	mb             int     // magnitude bit-planes
+	delta          float64 // quantization step size
+	coeffs         []float32
+	precincts      []*jpxPrecinct
+}
+
+// jpxPrecinct holds the code-blocks of one band inside a precinct
+type jpxPrecinct struct {
+	cw, ch    int // code-blocks across and down
+	blocks    []*jpxCodeBlock
+	inclusion *jpxTagTree
+	zeroPlane *jpxTagTree
+}
+
+// jpxCodeBlock accumulates the coded data of a code-block
+type jpxCodeBlock struct {
+	x0, y0, x1, y1 int // in band coordinates
+	included       bool
+	lblock         int
+	zeroPlanes     int
+	passes         int
+	segments       []jpxSegment
+}
+
+// jpxSegment is a codeword segment: passes coded with one arithmetic
+// coder or raw run
+type jpxSegment struct {
+	data   []byte
+	passes int
+}
+
+// jpxTagTree is a tag tree decoder (ITU-T T.800 B.10.2)
+type jpxTagTree struct {
+	levels []jpxTagLevel
+}
+
+type jpxTagLevel struct {
+	w     int
+	value []int
+	low   []int
+}
+
+func newJPXTagTree(w, h int) *jpxTagTree {
+	t := &jpxTagTree{}
+	for {
+		n := w * h
+		level := jpxTagLevel{w: w, value: make([]int, n), low: make([]int, n)}
+		for i := range level.value {
+			level.value[i] = math.MaxInt32
+		}
+		t.levels = append(t.levels, level)
+		if w <= 1 && h <= 1 {
+			break
+		}
+		w, h = (w+1)/2, (h+1)/2
+	}
+	return t
+}
+
+// decode reads bits until the value of leaf (x, y) is known to be below
+// threshold or not, and reports whether it is
+func (t *jpxTagTree) decode(br *jpxBitReader, x, y, threshold int) (bool, error) {
+	low := 0
+	for i := len(t.levels) - 1; i >= 0; i-- {
+		level := &t.levels[i]
+		idx := (y>>i)*level.w + x>>i
+		if low > level.low[idx] {
+			level.low[idx] = low
+		} else {
+			low = level.low[idx]
+		}
+		for low < threshold && low < level.value[idx] {
+			bit, err := br.readBit()
+			if err != nil {
+				return false, err
+			}
+			if bit == 1 {
+				level.value[idx] = low
+			} else {
+				low++
+			}
+		}
+		level.low[idx] = low
+	}
+	return t.levels[0].value[y*t.levels[0].w+x] < threshold, nil
+}
+
+// value returns the decoded value of leaf (x, y)
+func (t *jpxTagTree) value(x, y int) int {
+	return t.levels[0].value[y*t.levels[0].w+x]
+}
+
+// jpxBitReader reads packet header bits with bit stuffing after 0xFF
+type jpxBitReader struct {
+	data []byte
+	pos  int
+	cur  byte
+	bits int
+}
+
+var errJPXEndOfData = fmt.Errorf("%w: unexpected end of data", ErrInvalidJPEG2000)
+
+func (br *jpxBitReader) readBit() (int, error) {
+	if br.bits == 0 {
+		if br.pos >= len(br.data) {
+			return 0, errJPXEndOfData
+		}
+		if br.cur == 0xFF {
+			br.bits = 7
+		} else {
+			br.bits = 8
+		}
+		br.cur = br.data[br.pos]
+		br.pos++
+	}
+	br.bits--
+	return int(br.cur>>br.bits) & 1, nil
+}
+
+func (br *jpxBitReader) readBits(n int) (int, error) {
+	v := 0
+	for i := 0; i < n; i++ {
+		bit, err := br.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	return v, nil
+}
+
+// align skips to the next byte boundary, including the stuffed byte
+// after 0xFF
+func (br *jpxBitReader) align() {
+	if br.cur == 0xFF && br.pos < len(br.data) {
+		br.pos++
+	}
+	br.cur = 0
+	br.bits = 0
+}
+
+// skipMarker skips a two byte marker at the current position if present
+func (br *jpxBitReader) skipMarker(marker uint16, length int) {
+	if br.pos+2 <= len(br.data) && binary.BigEndian.Uint16(br.data[br.pos:]) == marker {
+		br.pos += length
+	}
+}
+
+// readPasses reads the number of coding passes (Table B.4)
+func (br *jpxBitReader) readPasses() (int, error) {
+	// 0 -> 1, 10 -> 2, 11xx (xx != 11) -> 3..5, 1111 xxxxx -> 6..36,
+	// 1111 11111 xxxxxxx -> 37..164
+	if bit, err := br.readBit(); err != nil || bit == 0 {
+		return 1, err
+	}
+	if bit, err := br.readBit(); err != nil || bit == 0 {
+		return 2, err
+	}
+	v, err := br.readBits(2)
+	if err != nil || v != 3 {
+		return 3 + v, err
+	}
+	v, err = br.readBits(5)
+	if err != nil || v != 31 {
+		return 6 + v, err
+	}
+	v, err = br.readBits(7)
+	return 37 + v, err
+}
+
+// segmentPasses returns the maximum number of passes in codeword segment
+// i of a code-block
+func segmentPasses(cbStyle, i int) int {
+	switch {
+	case cbStyle&jpxTermAll != 0:
+		return 1
+	case cbStyle&jpxBypass != 0:
+		if i == 0 {
+			return 10
+		}
+		if i%2 == 1 {
+			return 2
+		}
+		return 1
+	}
+	return math.MaxInt32
+}
+
+// newTileComponent computes the resolution, band, precinct and
+// code-block geometry of component c of a tile
+func (cs *jpxCodestream) newTileComponent(t *jpxTile, c, tx0, ty0, tx1, ty1 int) (*jpxTileComponent, error) {
+	comp := cs.siz.comps[c]
+	style := cs.componentStyle(t, c)
+	quant := cs.quantization(t, c)
+	roi := cs.roiShift(t, c)
+	tc := &jpxTileComponent{
+		x0:    ceilDiv(tx0, comp.dx),
+		y0:    ceilDiv(ty0, comp.dy),
+		x1:    ceilDiv(tx1, comp.dx),
+		y1:    ceilDiv(ty1, comp.dy),
+		style: style,
+	}
+	nl := style.levels
+
+	for r := 0; r <= nl; r++ {
+		scale := 1 << (nl - r)
+		res := &jpxResolution{
+			x0:  ceilDiv(tc.x0, scale),
+			y0:  ceilDiv(tc.y0, scale),
+			x1:  ceilDiv(tc.x1, scale),
+			y1:  ceilDiv(tc.y1, scale),
+			ppx: style.precincts[r] & 0x0F,
+			ppy: style.precincts[r] >> 4,
+		}
+		if r > 0 && (res.ppx == 0 || res.ppy == 0) {
+			return nil, ErrInvalidJPEG2000
+		}
+		if res.x1 > res.x0 && res.y1 > res.y0 {
+			res.pw = ceilDiv(res.x1, 1<<res.ppx) - res.x0>>res.ppx
+			res.ph = ceilDiv(res.y1, 1<<res.ppy) - res.y0>>res.ppy
+		}
+		if res.pw*res.ph > jpxMaxPrecincts {
+			return nil, ErrUnsupportedJPEG2000
+		}
+
+		kinds := []int{1, 2, 3}
+		if r == 0 {
+			kinds = []int{0}
+		}
+		for _, kind := range kinds {
+			band := &jpxBand{kind: kind}
+			nb := nl - r + 1
+			if r == 0 {
+				nb = nl
+			}
+			xo, yo := kind&1, kind>>1
+			band.x0 = ceilDiv(tc.x0-(xo<<nb>>1), 1<<nb)
+			band.y0 = ceilDiv(tc.y0-(yo<<nb>>1), 1<<nb)
+			band.x1 = ceilDiv(tc.x1-(xo<<nb>>1), 1<<nb)
+			band.y1 = ceilDiv(tc.y1-(yo<<nb>>1), 1<<nb)
+			if r == 0 {
+				band.x0, band.y0, band.x1, band.y1 = res.x0, res.y0, res.x1, res.y1
+			}
+
+			// Quantization step and magnitude bit-planes (E.1)
+			idx := 0
+			if r > 0 {
+				idx = 3*(r-1) + kind
+			}
+			var exp, mant int
+			if quant.style == 1 {
+				exp = quant.exps[0] - (nl - nb)
+				mant = quant.mants[0]
+				if r == 0 {
+					exp = quant.exps[0]
+				}
+			} else {
+				if idx >= len(quant.exps) {
+					return nil, fmt.Errorf("%w: missing quantization step", ErrInvalidJPEG2000)
+				}
+				exp, mant = quant.exps[idx], quant.mants[idx]
+			}
+			band.mb = quant.guard + exp - 1 + roi
+			gain := [4]int{0, 1, 1, 2}[kind]
+			band.delta = 1
+			if !style.reversible {
+				band.delta = math.Ldexp(1+float64(mant)/2048, comp.depth+gain-exp)
+			}
+			if band.mb > 31 {
+				return nil, ErrUnsupportedJPEG2000
+			}
+
+			bw, bh := band.x1-band.x0, band.y1-band.y0
+			if bw > 0 && bh > 0 {
+				band.coeffs = make([]float32, bw*bh)
+			}
+
+			// Precinct partition of the band
+			ppx, ppy := res.ppx, res.ppy
+			if r > 0 {
+				ppx, ppy = ppx-1, ppy-1
+			}
+			cbw, cbh := min(style.cbw, ppx), min(style.cbh, ppy)
+			if cbw < 0 {
+				cbw = 0
+			}
+			if cbh < 0 {
+				cbh = 0
+			}
+			for py := 0; py < res.ph; py++ {
+				for px := 0; px < res.pw; px++ {
+					gx := res.x0>>res.ppx + px
+					gy := res.y0>>res.ppy + py
+					prec := &jpxPrecinct{}
+					px0 := max(gx<<ppx, band.x0)
+					py0 := max(gy<<ppy, band.y0)
+					px1 := min((gx+1)<<ppx, band.x1)
+					py1 := min((gy+1)<<ppy, band.y1)
+					if px1 > px0 && py1 > py0 {
+						prec.cw = ceilDiv(px1, 1<<cbw) - px0>>cbw
+						prec.ch = ceilDiv(py1, 1<<cbh) - py0>>cbh
+						for j := 0; j < prec.ch; j++ {
+							for i := 0; i < prec.cw; i++ {
+								bx := (px0>>cbw + i) << cbw
+								by := (py0>>cbh + j) << cbh
+								prec.blocks = append(prec.blocks, &jpxCodeBlock{
+									x0:     max(bx, px0),
+									y0:     max(by, py0),
+									x1:     min(bx+1<<cbw, px1),
+									y1:     min(by+1<<cbh, py1),
+									lblock: 3,
+								})
+							}
+						}
+						prec.inclusion = newJPXTagTree(prec.cw, prec.ch)
+						prec.zeroPlane = newJPXTagTree(prec.cw, prec.ch)
+					}
+					band.precincts = append(band.precincts, prec)
+				}
+			}
+			res.bands = append(res.bands, band)
+		}
+		tc.resolutions = append(tc.resolutions, res)
+	}
+	return tc, nil
+}
+
+// jpxPacket identifies a packet by layer, resolution, component and
+// precinct
+type jpxPacket struct {
+	layer, res, comp, prec int
+}
+
+// packetOrder lists the packets of a tile in the order they appear,
+// following the progression order and any progression order changes
+func (cs *jpxCodestream) packetOrder(t *jpxTile, comps []*jpxTileComponent, tx0, ty0 int) []jpxPacket {
+	cod := cs.codingStyle(t)
+	changes := t.header.poc
+	if changes == nil {
+		changes = cs.main.poc
+	}
+	maxRes := 0
+	for _, tc := range comps {
+		maxRes = max(maxRes, len(tc.resolutions))
+	}
+	if changes == nil {
+		changes = []jpxProgressionChange{{0, 0, cod.layers, maxRes, len(comps), cod.progression}}
+	}
+
+	// next[c][r][p] is the next layer of each precinct
+	next := make([][][]int, len(comps))
+	for c, tc := range comps {
+		next[c] = make([][]int, len(tc.resolutions))
+		for r, res := range tc.resolutions {
+			next[c][r] = make([]int, res.pw*res.ph)
+		}
+	}
+
+	var packets []jpxPacket
+	for _, ch := range changes {
+		lye := min(ch.lye, cod.layers)
+		re := min(ch.re, maxRes)
+		ce := min(ch.ce, len(comps))
+		emit := func(l, r, c, p int) {
+			if next[c][r][p] == l {
+				packets = append(packets, jpxPacket{l, r, c, p})
+				next[c][r][p]++
+			}
+		}
+		precincts := func(r, c int) int {
+			if r >= len(comps[c].resolutions) {
+				return 0
+			}
+			res := comps[c].resolutions[r]
+			return res.pw * res.ph
+		}
+
+		switch ch.order {
+		case jpxLRCP:
+			for l := 0; l < lye; l++ {
+				for r := ch.rs; r < re; r++ {
+					for c := ch.cs; c < ce; c++ {
+						for p := 0; p < precincts(r, c); p++ {
+							emit(l, r, c, p)
+						}
+					}
+				}
+			}
+		case jpxRLCP:
+			for r := ch.rs; r < re; r++ {
+				for l := 0; l < lye; l++ {
+					for c := ch.cs; c < ce; c++ {
+						for p := 0; p < precincts(r, c); p++ {
+							emit(l, r, c, p)
+						}
+					}
+				}
+			}
+		default:
+			// Position driven orders visit each precinct at the reference
+			// grid position of its upper left corner
+			type visit struct{ x, y, r, c, p int }
+			var visits []visit
+			for c := ch.cs; c < ce; c++ {
+				comp := cs.siz.comps[c]
+				for r := ch.rs; r < re && r < len(comps[c].resolutions); r++ {
+					res := comps[c].resolutions[r]
+					shift := len(comps[c].resolutions) - 1 - r
+					for p := 0; p < res.pw*res.ph; p++ {
+						gx := (res.x0>>res.ppx + p%res.pw) << res.ppx
+						gy := (res.y0>>res.ppy + p/res.pw) << res.ppy
+						x, y := tx0, ty0
+						if gx >= res.x0 {
+							x = gx << shift * comp.dx
+						}
+						if gy >= res.y0 {
+							y = gy << shift * comp.dy
+						}
+						visits = append(visits, visit{x, y, r, c, p})
+					}
+				}
+			}
+			sort.SliceStable(visits, func(i, j int) bool {
+				a, b := visits[i], visits[j]
+				var ka, kb [4]int
+				switch ch.order {
+				case jpxRPCL:
+					ka, kb = [4]int{a.r, a.y, a.x, a.c}, [4]int{b.r, b.y, b.x, b.c}
+				case jpxPCRL:
+					ka, kb = [4]int{a.y, a.x, a.c, a.r}, [4]int{b.y, b.x, b.c, b.r}
+				default:
+					ka, kb = [4]int{a.c, a.y, a.x, a.r}, [4]int{b.c, b.y, b.x, b.r}
+				}
+				for k := range ka {
+					if ka[k] != kb[k] {
+						return ka[k] < kb[k]
+					}
+				}
+				return false
+			})
+			for _, v := range visits {
+				for l := next[v.c][v.r][v.p]; l < lye; l++ {
+					emit(l, v.r, v.c, v.p)
+				}
+			}
+		}
+	}
+	return packets
+}
+
+// readPacket reads the header of a packet and attaches its code-block
+// contributions
+func (cs *jpxCodestream) readPacket(cod *jpxCodingStyle, tc *jpxTileComponent, pk jpxPacket, hdr, body *jpxBitReader) error {
+	if cod.sop {
+		body.skipMarker(j2kSOP, 6)
+	}
+	res := tc.resolutions[pk.res]
+	cbStyle := tc.style.cbStyle
+
+	type contribution struct {
+		cb      *jpxCodeBlock
+		lengths []int
+		passes  []int
+	}
+	var contribs []contribution
+
+	present, err := hdr.readBit()
+	if err != nil {
+		return err
+	}
+	if present == 1 {
+		for _, band := range res.bands {
+			prec := band.precincts[pk.prec]
+			for i, cb := range prec.blocks {
+				x, y := i%prec.cw, i/prec.cw
+				var included bool
+				if !cb.included {
+					if included, err = prec.inclusion.decode(hdr, x, y, pk.layer+1); err != nil {
+						return err
+					}
+				} else {
+					bit, err := hdr.readBit()
+					if err != nil {
+						return err
+					}
+					included = bit == 1
+				}
+				if !included {
+					continue
+				}
+				if !cb.included {
+					for threshold := 1; ; threshold++ {
+						known, err := prec.zeroPlane.decode(hdr, x, y, threshold)
+						if err != nil {
+							return err
+						}
+						if known {
+							break
+						}
+					}
+					cb.zeroPlanes = prec.zeroPlane.value(x, y)
+					cb.included = true
+				}
+				passes, err := hdr.readPasses()
+				if err != nil {
+					return err
+				}
+				for {
+					bit, err := hdr.readBit()
+					if err != nil {
+						return err
+					}
+					if bit == 0 {
+						break
+					}
+					cb.lblock++
+				}
+
+				// Split the new passes into codeword segments, each with
+				// its own length (B.10.7)
+				con := contribution{cb: cb}
+				seg := len(cb.segments) - 1
+				used := 0
+				if seg >= 0 {
+					used = cb.segments[seg].passes
+				}
+				for passes > 0 {
+					if seg < 0 || used >= segmentPasses(cbStyle, seg) {
+						seg++
+						used = 0
+					}
+					n := min(passes, segmentPasses(cbStyle, seg)-used)
+					length, err := hdr.readBits(cb.lblock + bitLength(n) - 1)
+					if err != nil {
+						return err
+					}
+					con.lengths = append(con.lengths, length)
+					con.passes = append(con.passes, n)
+					used += n
+					passes -= n
+				}
+				contribs = append(contribs, con)
+			}
+		}
+	}
+	hdr.align()
+	if cod.eph {
+		hdr.skipMarker(j2kEPH, 2)
+	}
+
+	for _, con := range contribs {
+		cb := con.cb
+		for i, length := range con.lengths {
+			if body.pos+length > len(body.data) {
+				return errJPXEndOfData
+			}
+			data := body.data[body.pos : body.pos+length]
+			body.pos += length
+			n := len(cb.segments)
+			if n == 0 || cb.segments[n-1].passes >= segmentPasses(cbStyle, n-1) {
+				cb.segments = append(cb.segments, jpxSegment{})
+				n++
+			}
+			s := &cb.segments[n-1]
+			s.data = append(s.data, data...)
+			s.passes += con.passes[i]
+			cb.passes += con.passes[i]
+		}
+	}
+	return nil
+}
+
+// bitLength returns the number of bits needed to represent n
+func bitLength(n int) int {
+	l := 0
+	for n > 0 {
+		l++
+		n >>= 1
+	}
+	return l
+}
+
+// ceilDiv returns a / b rounded towards positive infinity for b > 0
+func ceilDiv(a, b int) int {
+	if a >= 0 {
+		return (a + b - 1) / b
+	}
+	return -((-a) / b)
+}
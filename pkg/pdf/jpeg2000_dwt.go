@@ -0,0 +1,168 @@
+package pdf
+
+import "math"
+
+// Inverse discrete wavelet transform and multiple component transform of
+// JPEG 2000 (ITU-T T.800 Annexes F and G)
+
+// Lifting coefficients of the irreversible 9-7 filter (Table F.4)
+const (
+	dwtAlpha = -1.586134342059924
+	dwtBeta  = -0.052980118572961
+	dwtGamma = 0.882911075530934
+	dwtDelta = 0.443506852043971
+	dwtK     = 1.230174104914001
+)
+
+// dwtPad is the symmetric extension needed on each side of a signal
+const dwtPad = 4
+
+// inverseDWT reconstructs the samples of a tile-component from its
+// subbands (2D_SR, F.3.2)
+func inverseDWT(tc *jpxTileComponent) []float32 {
+	ll := tc.resolutions[0].bands[0]
+	data := ll.coeffs
+	w := ll.x1 - ll.x0
+	if data == nil && w > 0 && ll.y1 > ll.y0 {
+		data = make([]float32, w*(ll.y1-ll.y0))
+	}
+	reversible := tc.style.reversible
+
+	var line []float32
+	for r := 1; r < len(tc.resolutions); r++ {
+		res := tc.resolutions[r]
+		rw, rh := res.x1-res.x0, res.y1-res.y0
+		if rw <= 0 || rh <= 0 {
+			data, w = nil, 0
+			continue
+		}
+		out := make([]float32, rw*rh)
+
+		// 2D_INTERLEAVE: even coordinates take low-pass samples
+		bands := [4]*jpxBand{nil, res.bands[0], res.bands[1], res.bands[2]}
+		lowX0, lowY0 := ceilDiv(res.x0, 2), ceilDiv(res.y0, 2)
+		for y := res.y0; y < res.y1; y++ {
+			for x := res.x0; x < res.x1; x++ {
+				kind := x&1 | (y&1)<<1
+				var v float32
+				if kind == 0 {
+					v = data[(y/2-lowY0)*w+x/2-lowX0]
+				} else if b := bands[kind]; b.coeffs != nil {
+					v = b.coeffs[(y>>1-b.y0)*(b.x1-b.x0)+x>>1-b.x0]
+				}
+				out[(y-res.y0)*rw+x-res.x0] = v
+			}
+		}
+
+		// HOR_SR then VER_SR
+		if cap(line) < max(rw, rh)+2*dwtPad {
+			line = make([]float32, max(rw, rh)+2*dwtPad)
+		}
+		for y := 0; y < rh; y++ {
+			row := out[y*rw : (y+1)*rw]
+			copy(line[dwtPad:], row)
+			synthesize1D(line[:rw+2*dwtPad], res.x0, rw, reversible)
+			copy(row, line[dwtPad:dwtPad+rw])
+		}
+		for x := 0; x < rw; x++ {
+			for y := 0; y < rh; y++ {
+				line[dwtPad+y] = out[y*rw+x]
+			}
+			synthesize1D(line[:rh+2*dwtPad], res.y0, rh, reversible)
+			for y := 0; y < rh; y++ {
+				out[y*rw+x] = line[dwtPad+y]
+			}
+		}
+		data, w = out, rw
+	}
+	return data
+}
+
+// synthesize1D performs 1D_SR on the n samples at line[dwtPad:], whose
+// first sample has coordinate i0 (F.3.6)
+func synthesize1D(line []float32, i0, n int, reversible bool) {
+	if n == 1 {
+		if i0&1 == 1 {
+			if reversible {
+				line[dwtPad] = float32(int32(line[dwtPad]) / 2)
+			} else {
+				line[dwtPad] /= 2
+			}
+		}
+		return
+	}
+
+	// Periodic symmetric extension (F.3.7)
+	for k := 1; k <= dwtPad; k++ {
+		line[dwtPad-k] = line[dwtPad+reflect(-k, n)]
+		line[dwtPad+n-1+k] = line[dwtPad+reflect(n-1+k, n)]
+	}
+
+	// Index e of line holds coordinate i0-dwtPad+e; even coordinates are
+	// low-pass samples. Each lifting step leaves its first and last
+	// sample stale, which only affects the extension.
+	first := (i0 - dwtPad) & 1
+	evenStart, oddStart := 2-first, 1+first
+	end := len(line) - 1
+	if reversible {
+		// X(2n) = Y(2n) - floor((Y(2n-1) + Y(2n+1) + 2) / 4)
+		for e := evenStart; e < end; e += 2 {
+			line[e] -= float32(math.Floor(float64(line[e-1]+line[e+1]+2) / 4))
+		}
+		// X(2n+1) = Y(2n+1) + floor((X(2n) + X(2n+2)) / 2)
+		for e := oddStart; e < end; e += 2 {
+			line[e] += float32(math.Floor(float64(line[e-1]+line[e+1]) / 2))
+		}
+		return
+	}
+
+	for e := range line {
+		if (e+first)&1 == 0 {
+			line[e] *= dwtK
+		} else {
+			line[e] *= 1 / dwtK
+		}
+	}
+	lift := func(start int, c float32) {
+		for e := start; e < end; e += 2 {
+			line[e] -= c * (line[e-1] + line[e+1])
+		}
+	}
+	lift(evenStart, dwtDelta)
+	lift(oddStart, dwtGamma)
+	lift(evenStart, dwtBeta)
+	lift(oddStart, dwtAlpha)
+}
+
+// reflect maps index i onto [0, n) by whole-sample symmetric extension
+func reflect(i, n int) int {
+	period := 2 * (n - 1)
+	i %= period
+	if i < 0 {
+		i += period
+	}
+	if i >= n {
+		i = period - i
+	}
+	return i
+}
+
+// inverseMCT undoes the reversible (RCT) or irreversible (ICT) colour
+// transform of the first three components (Annex G)
+func inverseMCT(c0, c1, c2 []float32, reversible bool) {
+	n := min(len(c0), min(len(c1), len(c2)))
+	if reversible {
+		for i := 0; i < n; i++ {
+			y, u, v := c0[i], c1[i], c2[i]
+			g := y - float32(math.Floor(float64(u+v)/4))
+			c0[i], c1[i], c2[i] = v+g, g, u+g
+		}
+		return
+	}
+	for i := 0; i < n; i++ {
+		y, cb, cr := c0[i], c1[i], c2[i]
+		c0[i] = y + 1.402*cr
+		c1[i] = y - 0.34413*cb - 0.71414*cr
+		c2[i] = y + 1.772*cb
+	}
+}
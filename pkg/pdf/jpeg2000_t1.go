@@ -0,0 +1,371 @@
+package pdf
+
+// Tier-1 decoding of JPEG 2000 code-blocks (ITU-T T.800 Annex D)
+
+// Coefficient state flags
+const (
+	t1Sig    = 1 << iota // significant
+	t1Visit              // coded in the current significance propagation pass
+	t1Refine             // refined at least once
+	t1Neg                // negative sign
+)
+
+// Context labels
+const (
+	t1CtxSign  = 9  // 9 to 13
+	t1CtxMag   = 14 // 14 to 16
+	t1CtxRun   = 17
+	t1CtxUni   = 18
+	t1Contexts = 19
+)
+
+// t1Decoder decodes the coding passes of one code-block
+type t1Decoder struct {
+	w, h    int
+	stride  int
+	flags   []uint8 // with a border of one sample
+	mag     []int32
+	plane   []int8 // lowest bit-plane coded for each coefficient
+	kind    int
+	cbStyle int
+
+	mq   *mqDecoder
+	raw  *jpxRawDecoder
+	ctx  [t1Contexts]mqContext
+	bits func(ctx int) int
+}
+
+// jpxRawDecoder reads the raw bits of bypassed coding passes
+type jpxRawDecoder struct {
+	data []byte
+	pos  int
+	c    byte
+	ct   int
+}
+
+func (d *jpxRawDecoder) readBit() int {
+	if d.ct == 0 {
+		next := byte(0xFF)
+		if d.pos < len(d.data) {
+			next = d.data[d.pos]
+		}
+		if d.c == 0xFF {
+			if next > 0x8F {
+				d.c = 0xFF
+				d.ct = 8
+			} else {
+				d.c = next
+				d.pos++
+				d.ct = 7
+			}
+		} else {
+			d.c = next
+			d.pos++
+			d.ct = 8
+		}
+	}
+	d.ct--
+	return int(d.c>>d.ct) & 1
+}
+
+// resetContexts sets the initial context states (Table D.7)
+func (t *t1Decoder) resetContexts() {
+	for i := range t.ctx {
+		t.ctx[i] = newMQContext(0)
+	}
+	t.ctx[0] = newMQContext(4)
+	t.ctx[t1CtxRun] = newMQContext(3)
+	t.ctx[t1CtxUni] = newMQContext(46)
+}
+
+// decodeCodeBlock decodes the passes of cb and stores the reconstructed,
+// dequantized coefficients in the band
+func decodeCodeBlock(cb *jpxCodeBlock, band *jpxBand, style *jpxComponentStyle, roi int) {
+	w, h := cb.x1-cb.x0, cb.y1-cb.y0
+	if w <= 0 || h <= 0 || cb.passes == 0 {
+		return
+	}
+	t := &t1Decoder{
+		w:       w,
+		h:       h,
+		stride:  w + 2,
+		flags:   make([]uint8, (w+2)*(h+2)),
+		mag:     make([]int32, w*h),
+		plane:   make([]int8, w*h),
+		kind:    band.kind,
+		cbStyle: style.cbStyle,
+	}
+	t.resetContexts()
+
+	plane := band.mb - 1 - cb.zeroPlanes
+	passType := 2 // the first pass is a cleanup pass
+	pass := 0
+	for _, seg := range cb.segments {
+		for i := 0; i < seg.passes && plane >= 0; i++ {
+			raw := style.cbStyle&jpxBypass != 0 && pass >= 10 && passType != 2
+			if i == 0 || style.cbStyle&jpxTermAll != 0 {
+				if raw {
+					t.raw = &jpxRawDecoder{data: seg.data}
+					t.bits = func(int) int { return t.raw.readBit() }
+				} else {
+					t.mq = newMQDecoder(seg.data)
+					t.bits = func(ctx int) int { return t.mq.decode(&t.ctx[ctx]) }
+				}
+			}
+			if style.cbStyle&jpxReset != 0 && pass > 0 {
+				t.resetContexts()
+			}
+			switch passType {
+			case 0:
+				t.significancePass(plane, raw)
+			case 1:
+				t.refinementPass(plane, raw)
+			case 2:
+				t.cleanupPass(plane)
+			}
+			pass++
+			if passType == 2 {
+				passType = 0
+				plane--
+			} else {
+				passType++
+			}
+		}
+	}
+	t.store(cb, band, style.reversible, roi)
+}
+
+// idx returns the index of (x, y) in flags
+func (t *t1Decoder) idx(x, y int) int {
+	return (y+1)*t.stride + x + 1
+}
+
+// neighbours counts the significant horizontal, vertical and diagonal
+// neighbours of (x, y)
+func (t *t1Decoder) neighbours(x, y int) (h, v, d int) {
+	i := t.idx(x, y)
+	f := t.flags
+	s := t.stride
+	h = int(f[i-1]&t1Sig) + int(f[i+1]&t1Sig)
+	v = int(f[i-s] & t1Sig)
+	d = int(f[i-s-1]&t1Sig) + int(f[i-s+1]&t1Sig)
+	if t.cbStyle&jpxVCausal == 0 || y%4 != 3 {
+		v += int(f[i+s] & t1Sig)
+		d += int(f[i+s-1]&t1Sig) + int(f[i+s+1]&t1Sig)
+	}
+	return
+}
+
+// zeroContext returns the significance coding context (Table D.1)
+func (t *t1Decoder) zeroContext(x, y int) int {
+	h, v, d := t.neighbours(x, y)
+	switch t.kind {
+	case 1: // HL: horizontally high-pass
+		h, v = v, h
+	case 3: // HH
+		hv := h + v
+		switch {
+		case d >= 3:
+			return 8
+		case d == 2:
+			if hv >= 1 {
+				return 7
+			}
+			return 6
+		case d == 1:
+			return 3 + min(hv, 2)
+		}
+		return min(hv, 2)
+	}
+	switch {
+	case h == 2:
+		return 8
+	case h == 1:
+		if v >= 1 {
+			return 7
+		}
+		if d >= 1 {
+			return 6
+		}
+		return 5
+	case v == 2:
+		return 4
+	case v == 1:
+		return 3
+	case d >= 2:
+		return 2
+	}
+	return d
+}
+
+// signContribution returns the sign contribution of a neighbour
+func signContribution(f uint8) int {
+	if f&t1Sig == 0 {
+		return 0
+	}
+	if f&t1Neg != 0 {
+		return -1
+	}
+	return 1
+}
+
+// decodeSign decodes the sign of (x, y) (Table D.3)
+func (t *t1Decoder) decodeSign(x, y int, raw bool) bool {
+	if raw {
+		return t.bits(0) == 1
+	}
+	i := t.idx(x, y)
+	f := t.flags
+	h := signContribution(f[i-1]) + signContribution(f[i+1])
+	v := signContribution(f[i-t.stride])
+	if t.cbStyle&jpxVCausal == 0 || y%4 != 3 {
+		v += signContribution(f[i+t.stride])
+	}
+	h, v = max(-1, min(1, h)), max(-1, min(1, v))
+
+	xor := 0
+	if h < 0 || (h == 0 && v < 0) {
+		h, v, xor = -h, -v, 1
+	}
+	var ctx int
+	switch {
+	case h == 1:
+		ctx = 12 + v
+	case v == 1:
+		ctx = 10
+	case v == 0:
+		ctx = 9
+	}
+	return t.bits(ctx)^xor == 1
+}
+
+// becomeSignificant records a newly significant coefficient
+func (t *t1Decoder) becomeSignificant(x, y, plane int, raw bool) {
+	neg := t.decodeSign(x, y, raw)
+	i := t.idx(x, y)
+	t.flags[i] |= t1Sig
+	if neg {
+		t.flags[i] |= t1Neg
+	}
+	j := y*t.w + x
+	t.mag[j] |= 1 << plane
+	t.plane[j] = int8(plane)
+}
+
+// significancePass decodes a significance propagation pass
+func (t *t1Decoder) significancePass(plane int, raw bool) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			for y := y0; y < y0+4 && y < t.h; y++ {
+				i := t.idx(x, y)
+				if t.flags[i]&t1Sig != 0 {
+					continue
+				}
+				ctx := t.zeroContext(x, y)
+				if ctx == 0 {
+					continue
+				}
+				t.flags[i] |= t1Visit
+				if t.bits(ctx) == 1 {
+					t.becomeSignificant(x, y, plane, raw)
+				}
+			}
+		}
+	}
+}
+
+// refinementPass decodes a magnitude refinement pass
+func (t *t1Decoder) refinementPass(plane int, raw bool) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			for y := y0; y < y0+4 && y < t.h; y++ {
+				i := t.idx(x, y)
+				if t.flags[i]&(t1Sig|t1Visit) != t1Sig {
+					continue
+				}
+				ctx := t1CtxMag + 2
+				if t.flags[i]&t1Refine == 0 {
+					ctx = t1CtxMag
+					if h, v, d := t.neighbours(x, y); h+v+d > 0 {
+						ctx++
+					}
+				}
+				j := y*t.w + x
+				t.mag[j] |= int32(t.bits(ctx)) << plane
+				t.plane[j] = int8(plane)
+				t.flags[i] |= t1Refine
+			}
+		}
+	}
+}
+
+// cleanupPass decodes a cleanup pass, with run-length coding of columns
+// of four insignificant coefficients
+func (t *t1Decoder) cleanupPass(plane int) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			y := y0
+			if y0+4 <= t.h {
+				run := true
+				for k := 0; k < 4 && run; k++ {
+					run = t.flags[t.idx(x, y0+k)]&(t1Sig|t1Visit) == 0 && t.zeroContext(x, y0+k) == 0
+				}
+				if run {
+					if t.bits(t1CtxRun) == 0 {
+						continue
+					}
+					y += t.bits(t1CtxUni)<<1 | t.bits(t1CtxUni)
+					t.becomeSignificant(x, y, plane, false)
+					y++
+				}
+			}
+			for ; y < y0+4 && y < t.h; y++ {
+				i := t.idx(x, y)
+				if t.flags[i]&(t1Sig|t1Visit) != 0 {
+					continue
+				}
+				if t.bits(t.zeroContext(x, y)) == 1 {
+					t.becomeSignificant(x, y, plane, false)
+				}
+			}
+		}
+	}
+	for i := range t.flags {
+		t.flags[i] &^= t1Visit
+	}
+	if t.cbStyle&jpxSegSym != 0 {
+		for k := 0; k < 4; k++ {
+			t.bits(t1CtxUni)
+		}
+	}
+}
+
+// store reconstructs the coefficients of the code-block into the band,
+// undoing the region of interest shift and quantization (E.1.1.2)
+func (t *t1Decoder) store(cb *jpxCodeBlock, band *jpxBand, reversible bool, roi int) {
+	bw := band.x1 - band.x0
+	for y := 0; y < t.h; y++ {
+		for x := 0; x < t.w; x++ {
+			j := y*t.w + x
+			m := t.mag[j]
+			if m == 0 {
+				continue
+			}
+			plane := int(t.plane[j])
+			if roi > 0 && m >= 1<<roi {
+				m >>= roi
+				plane = max(plane-roi, 0)
+			}
+			v := float64(m)
+			if !reversible {
+				v = (v + float64(int32(1)<<plane)/2) * band.delta
+			} else if plane > 0 {
+				v += float64(int32(1) << (plane - 1))
+			}
+			if t.flags[t.idx(x, y)]&t1Neg != 0 {
+				v = -v
+			}
+			band.coeffs[(cb.y0-band.y0+y)*bw+cb.x0-band.x0+x] = float32(v)
+		}
+	}
+}
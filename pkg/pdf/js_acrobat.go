@@ -0,0 +1,1917 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Field display modes of the Acrobat JavaScript display object
+const (
+	jsDisplayVisible = 0
+	jsDisplayHidden  = 1
+	jsDisplayNoPrint = 2
+	jsDisplayNoView  = 3
+)
+
+// jsField is a form field as seen by scripts. Group fields have no node
+// and list their terminal descendants in kids.
+type jsField struct {
+	name     string
+	node     *fieldNode
+	kids     []string
+	ft       Name
+	flags    int
+	values   []string
+	defValue string
+	options  [][2]string // export value and display text
+	states   []string    // on-state of each button widget
+	display  int
+	readonly bool
+	required bool
+	maxLen   int
+	userName string
+	actions  map[string]string // K, F, V and C scripts
+	changed  bool              // value changed by a script
+	obj      *jsObject
+}
+
+// value returns the field value as a single string
+func (f *jsField) value() string {
+	if f.ft == "Btn" && (len(f.values) == 0 || f.values[0] == "") {
+		return "Off"
+	}
+	return strings.Join(f.values, "\n")
+}
+
+// typeName returns the Acrobat type name of the field
+func (f *jsField) typeName() string {
+	switch f.ft {
+	case "Tx":
+		return "text"
+	case "Ch":
+		if f.flags&FieldFlagCombo != 0 {
+			return "combobox"
+		}
+		return "listbox"
+	case "Btn":
+		switch {
+		case f.flags&FieldFlagPushButton != 0:
+			return "button"
+		case f.flags&FieldFlagRadio != 0:
+			return "radiobutton"
+		}
+		return "checkbox"
+	case "Sig":
+		return "signature"
+	}
+	return ""
+}
+
+// jsForm is the form model shared by the scripts of a document. Changes
+// made by scripts are recorded so the caller can write them back.
+type jsForm struct {
+	fields    map[string]*jsField
+	order     []string
+	calcOrder []string
+	dirty     []*jsField
+}
+
+// markDirty records that a script changed a field
+func (m *jsForm) markDirty(f *jsField) {
+	for _, d := range m.dirty {
+		if d == f {
+			return
+		}
+	}
+	m.dirty = append(m.dirty, f)
+}
+
+// takeDirty returns and clears the fields changed by scripts
+func (m *jsForm) takeDirty() []*jsField {
+	dirty := m.dirty
+	m.dirty = nil
+	return dirty
+}
+
+// newJSForm builds the form model of a document. Without a document the
+// model has no fields.
+func newJSForm(doc *Document) *jsForm {
+	if doc == nil {
+		return &jsForm{fields: make(map[string]*jsField)}
+	}
+	nodes, order := doc.collectFields()
+	m := &jsForm{fields: make(map[string]*jsField), order: order}
+	refs := make(map[Reference]string)
+
+	for _, name := range order {
+		node := nodes[name]
+		f := &jsField{name: name, node: node, actions: make(map[string]string)}
+		f.ft, _ = doc.inheritedFieldAttr(node.dict, "FT").(Name)
+		if ff, ok := doc.inheritedFieldAttr(node.dict, "Ff").(Integer); ok {
+			f.flags = int(ff)
+		}
+		f.values = jsFieldValues(doc.inheritedFieldAttr(node.dict, "V"))
+		if dv := jsFieldValues(doc.inheritedFieldAttr(node.dict, "DV")); len(dv) > 0 {
+			f.defValue = dv[0]
+		}
+		if maxLen, ok := doc.inheritedFieldAttr(node.dict, "MaxLen").(Integer); ok {
+			f.maxLen = int(maxLen)
+		}
+		if tu, ok := node.dict.Get("TU").(String); ok {
+			f.userName = tu.Text()
+		}
+		f.readonly = f.flags&FieldFlagReadOnly != 0
+		f.required = f.flags&FieldFlagRequired != 0
+
+		if opts, ok := doc.inheritedFieldAttr(node.dict, "Opt").(Array); ok {
+			for _, opt := range opts {
+				switch o := opt.(type) {
+				case String:
+					f.options = append(f.options, [2]string{o.Text(), o.Text()})
+				case Array:
+					if len(o) == 2 {
+						export, _ := o[0].(String)
+						text, _ := o[1].(String)
+						f.options = append(f.options, [2]string{export.Text(), text.Text()})
+					}
+				}
+			}
+		}
+
+		jsCollectActions(doc, node.dict, f.actions)
+		for i, ref := range node.widgets {
+			widget, ok := resolveDict(doc, ref)
+			if !ok {
+				continue
+			}
+			if ref != node.ref {
+				jsCollectActions(doc, widget, f.actions)
+			}
+			if i == 0 {
+				flags, _ := widget.Get("F").(Integer)
+				switch {
+				case flags&AnnotFlagHidden != 0:
+					f.display = jsDisplayHidden
+				case flags&AnnotFlagNoView != 0:
+					f.display = jsDisplayNoView
+				case flags&AnnotFlagPrint == 0:
+					f.display = jsDisplayNoPrint
+				}
+			}
+			if f.ft == "Btn" {
+				state := ""
+				if ap, ok := resolveDict(doc, widget.Get("AP")); ok {
+					if states, ok := resolveDict(doc, ap.Get("N")); ok {
+						for key := range states {
+							if key != "Off" {
+								state = string(key)
+								break
+							}
+						}
+					}
+				}
+				f.states = append(f.states, state)
+			}
+		}
+
+		m.fields[name] = f
+		if node.ref.ObjectNumber != 0 {
+			refs[node.ref] = name
+		}
+	}
+
+	// Non-terminal fields are reachable by their partial names
+	for _, name := range order {
+		parts := strings.Split(name, ".")
+		for i := 1; i < len(parts); i++ {
+			group := strings.Join(parts[:i], ".")
+			if g, ok := m.fields[group]; ok {
+				if g.node == nil {
+					g.kids = append(g.kids, name)
+				}
+				continue
+			}
+			m.fields[group] = &jsField{name: group, kids: []string{name}}
+		}
+	}
+
+	// Calculations run in the order of the AcroForm CO array
+	if form := doc.acroForm(); form != nil {
+		co, _ := resolveArray(doc, form.Get("CO"))
+		for _, obj := range co {
+			ref, ok := obj.(Reference)
+			if !ok {
+				continue
+			}
+			if name, ok := refs[ref]; ok && m.fields[name].actions["C"] != "" {
+				m.calcOrder = append(m.calcOrder, name)
+			}
+		}
+	}
+	return m
+}
+
+// jsFieldValues converts a field value object to strings
+func jsFieldValues(obj Object) []string {
+	switch v := obj.(type) {
+	case String:
+		return []string{v.Text()}
+	case Name:
+		return []string{string(v)}
+	case Array:
+		var values []string
+		for _, item := range v {
+			values = append(values, jsFieldValues(item)...)
+		}
+		return values
+	case Stream:
+		if data, err := v.Decode(); err == nil {
+			return []string{String{Value: data}.Text()}
+		}
+	}
+	return nil
+}
+
+// jsCollectActions adds the JavaScript of the keystroke, format, validate
+// and calculate actions of an additional-actions dictionary
+func jsCollectActions(doc *Document, dict Dictionary, actions map[string]string) {
+	aa, ok := resolveDict(doc, dict.Get("AA"))
+	if !ok {
+		return
+	}
+	for _, trigger := range []string{"K", "F", "V", "C"} {
+		if script := actionScript(doc, aa.Get(trigger)); script != "" {
+			actions[trigger] = script
+		}
+	}
+}
+
+// actionScript returns the script of a JavaScript action, or ""
+func actionScript(doc *Document, obj Object) string {
+	action, ok := resolveDict(doc, obj)
+	if !ok {
+		return ""
+	}
+	if s, ok := action.Get("S").(Name); !ok || s != "JavaScript" {
+		return ""
+	}
+	js, err := doc.ResolveObject(action.Get("JS"))
+	if err != nil {
+		return ""
+	}
+	switch v := js.(type) {
+	case String:
+		return v.Text()
+	case Stream:
+		data, err := v.Decode()
+		if err != nil {
+			return ""
+		}
+		return String{Value: data}.Text()
+	}
+	return ""
+}
+
+// isScriptLimitError reports whether err is a resource limit violation,
+// which aborts processing instead of being logged
+func isScriptLimitError(err error) bool {
+	return errors.Is(err, ErrScriptTimeout) || errors.Is(err, ErrScriptMemory)
+}
+
+// Host objects
+
+// jsDocHost provides the dynamic properties of the Doc object, which is
+// also the global object of the document's scripts
+type jsDocHost struct {
+	e *JSEngine
+}
+
+func (h *jsDocHost) get(it *jsInterp, name string) (jsValue, bool) {
+	doc := h.e.doc
+	switch name {
+	case "numFields":
+		return float64(len(h.e.form.order)), true
+	case "numPages":
+		if doc == nil {
+			return float64(0), true
+		}
+		return float64(len(doc.Pages)), true
+	case "pageNum":
+		return float64(0), true
+	case "calculate":
+		return !h.e.noCalculate, true
+	case "external":
+		return false, true
+	case "title", "author", "subject", "keywords", "creator", "producer":
+		if doc != nil && doc.Info != nil {
+			key := strings.ToUpper(name[:1]) + name[1:]
+			if s, ok := doc.Info.Get(key).(String); ok {
+				return s.Text(), true
+			}
+		}
+		return "", true
+	}
+	return nil, false
+}
+
+func (h *jsDocHost) set(it *jsInterp, name string, v jsValue) bool {
+	switch name {
+	case "calculate":
+		h.e.noCalculate = !it.toBoolean(v)
+		return true
+	case "numFields", "numPages", "external", "pageNum":
+		return true
+	}
+	return false
+}
+
+// jsFieldHost provides the properties of a Field object
+type jsFieldHost struct {
+	e *JSEngine
+	f *jsField
+}
+
+func (h *jsFieldHost) get(it *jsInterp, name string) (jsValue, bool) {
+	f := h.f
+	switch name {
+	case "name":
+		return f.name, true
+	case "type":
+		return f.typeName(), true
+	case "value":
+		return h.e.fieldScriptValue(f), true
+	case "valueAsString":
+		return f.value(), true
+	case "defaultValue":
+		return f.defValue, true
+	case "readonly":
+		return f.readonly, true
+	case "required":
+		return f.required, true
+	case "display":
+		return float64(f.display), true
+	case "hidden":
+		return f.display == jsDisplayHidden, true
+	case "numItems":
+		return float64(len(f.options)), true
+	case "charLimit":
+		return float64(f.maxLen), true
+	case "userName":
+		return f.userName, true
+	case "multiline":
+		return f.flags&FieldFlagMultiline != 0, true
+	case "password":
+		return f.flags&FieldFlagPassword != 0, true
+	case "multipleSelection":
+		return f.flags&FieldFlagMultiSel != 0, true
+	case "exportValues":
+		values := make([]jsValue, len(f.states))
+		for i, s := range f.states {
+			values[i] = s
+		}
+		return it.newArray(values), true
+	case "currentValueIndices":
+		for i, opt := range f.options {
+			if len(f.values) > 0 && opt[0] == f.values[0] {
+				return float64(i), true
+			}
+		}
+		return float64(-1), true
+	}
+	return nil, false
+}
+
+func (h *jsFieldHost) set(it *jsInterp, name string, v jsValue) bool {
+	f := h.f
+	switch name {
+	case "value":
+		h.e.setFieldValue(f, h.e.valuesFromScript(f, v))
+	case "readonly":
+		f.readonly = it.toBoolean(v)
+		h.e.form.markDirty(f)
+	case "required":
+		f.required = it.toBoolean(v)
+		h.e.form.markDirty(f)
+	case "display":
+		f.display = int(it.toInteger(v))
+		h.e.form.markDirty(f)
+	case "hidden":
+		f.display = jsDisplayVisible
+		if it.toBoolean(v) {
+			f.display = jsDisplayHidden
+		}
+		h.e.form.markDirty(f)
+	case "name", "type", "valueAsString", "numItems", "exportValues":
+		// Read-only properties
+	default:
+		return false
+	}
+	return true
+}
+
+// jsNumericValue matches field values that scripts see as numbers
+var jsNumericValue = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// fieldScriptValue returns the value property of a field: numeric text is
+// a number and multiple selections are an array, as in Acrobat
+func (e *JSEngine) fieldScriptValue(f *jsField) jsValue {
+	if f.node == nil {
+		return jsUndefined
+	}
+	if len(f.values) > 1 && f.ft == "Ch" {
+		values := make([]jsValue, len(f.values))
+		for i, v := range f.values {
+			values[i] = v
+		}
+		return e.interp.newArray(values)
+	}
+	v := f.value()
+	if f.ft != "Btn" && jsNumericValue.MatchString(v) {
+		return jsStringToNumber(v)
+	}
+	return v
+}
+
+// valuesFromScript converts a value assigned by a script to field values
+func (e *JSEngine) valuesFromScript(f *jsField, v jsValue) []string {
+	it := e.interp
+	if a, ok := v.(*jsObject); ok && a.class == "Array" {
+		values := make([]string, len(a.array))
+		for i, item := range a.array {
+			values[i] = it.toString(item)
+		}
+		return values
+	}
+	if isNullish(v) {
+		return []string{""}
+	}
+	return []string{it.toString(v)}
+}
+
+// setFieldValue changes a field value on behalf of a script
+func (e *JSEngine) setFieldValue(f *jsField, values []string) {
+	if f.node == nil {
+		for _, kid := range f.kids {
+			e.setFieldValue(e.form.fields[kid], values)
+		}
+		return
+	}
+	f.values = values
+	f.changed = true
+	e.form.markDirty(f)
+}
+
+// fieldObject returns the script object of a field
+func (e *JSEngine) fieldObject(f *jsField) *jsObject {
+	if f.obj == nil {
+		f.obj = e.interp.newObjectWithProto("Field", e.fieldProto)
+		f.obj.host = &jsFieldHost{e: e, f: f}
+	}
+	return f.obj
+}
+
+// thisField returns the field a Field method was called on
+func (e *JSEngine) thisField(this jsValue) *jsField {
+	if o, ok := this.(*jsObject); ok {
+		if h, ok := o.host.(*jsFieldHost); ok {
+			return h.f
+		}
+	}
+	e.interp.throwError("TypeError", "Field method called on incompatible receiver")
+	return nil
+}
+
+// installAcrobat installs the Acrobat JavaScript API: the Doc methods on
+// the global object and the event, util, app, console, display and color
+// objects together with the AF* functions of AForm.js
+func (e *JSEngine) installAcrobat() {
+	it := e.interp
+	g := it.global
+	g.host = &jsDocHost{e: e}
+
+	// Doc methods
+	it.defineMethod(g, "getField", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		if f, ok := e.form.fields[it.argString(args, 0)]; ok {
+			return e.fieldObject(f)
+		}
+		return jsNull
+	})
+	it.defineMethod(g, "getNthFieldName", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		n := int(it.toInteger(argument(args, 0)))
+		if n < 0 || n >= len(e.form.order) {
+			return jsNull
+		}
+		return e.form.order[n]
+	})
+	it.defineMethod(g, "calculateNow", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		if err := e.calculate(); err != nil && isScriptLimitError(err) {
+			panic(jsFatal{err})
+		}
+		return jsUndefined
+	})
+	it.defineMethod(g, "resetForm", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		names := e.form.order
+		if a, ok := argument(args, 0).(*jsObject); ok && a.class == "Array" {
+			names = nil
+			for _, v := range a.array {
+				names = append(names, it.toString(v))
+			}
+		}
+		for _, name := range names {
+			if f, ok := e.form.fields[name]; ok {
+				e.resetField(f)
+			}
+		}
+		return jsUndefined
+	})
+	noop := func(it *jsInterp, this jsValue, args []jsValue) jsValue { return jsUndefined }
+	it.defineMethod(g, "syncAnnotScan", noop)
+	it.defineMethod(g, "dirty", noop)
+
+	// Field prototype
+	e.fieldProto = it.newObject()
+	fp := e.fieldProto
+	it.defineMethod(fp, "getArray", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		f := e.thisField(this)
+		var out []jsValue
+		if f.node != nil {
+			out = append(out, e.fieldObject(f))
+		}
+		for _, kid := range f.kids {
+			out = append(out, e.fieldObject(e.form.fields[kid]))
+		}
+		return it.newArray(out)
+	})
+	it.defineMethod(fp, "isBoxChecked", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		f := e.thisField(this)
+		n := int(it.toInteger(argument(args, 0)))
+		return n >= 0 && n < len(f.states) && f.states[n] != "" && f.value() == f.states[n]
+	})
+	it.defineMethod(fp, "checkThisBox", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		f := e.thisField(this)
+		n := int(it.toInteger(argument(args, 0)))
+		check := len(args) < 2 || it.toBoolean(args[1])
+		if n < 0 || n >= len(f.states) {
+			return jsUndefined
+		}
+		switch {
+		case check:
+			e.setFieldValue(f, []string{f.states[n]})
+		case f.value() == f.states[n]:
+			e.setFieldValue(f, []string{"Off"})
+		}
+		return jsUndefined
+	})
+	it.defineMethod(fp, "getItemAt", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		f := e.thisField(this)
+		n := int(it.toInteger(argument(args, 0)))
+		if n < 0 {
+			n = len(f.options) - 1
+		}
+		if n >= len(f.options) {
+			it.throwError("RangeError", "Invalid item index")
+		}
+		if len(args) > 1 && !it.toBoolean(args[1]) {
+			return f.options[n][1]
+		}
+		return f.options[n][0]
+	})
+	it.defineMethod(fp, "setItems", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		f := e.thisField(this)
+		f.options = nil
+		if a, ok := argument(args, 0).(*jsObject); ok && a.class == "Array" {
+			for _, item := range a.array {
+				if pair, ok := item.(*jsObject); ok && pair.class == "Array" && len(pair.array) == 2 {
+					f.options = append(f.options, [2]string{it.toString(pair.array[1]), it.toString(pair.array[0])})
+					continue
+				}
+				s := it.toString(item)
+				f.options = append(f.options, [2]string{s, s})
+			}
+		}
+		return jsUndefined
+	})
+	it.defineMethod(fp, "clearItems", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		e.thisField(this).options = nil
+		return jsUndefined
+	})
+	it.defineMethod(fp, "buttonGetCaption", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return ""
+	})
+	for _, name := range []string{"setFocus", "setAction", "buttonSetCaption", "buttonSetIcon"} {
+		it.defineMethod(fp, name, noop)
+	}
+
+	// display, color and border constants
+	display := it.newObject()
+	for name, v := range map[string]float64{"visible": jsDisplayVisible, "hidden": jsDisplayHidden, "noPrint": jsDisplayNoPrint, "noView": jsDisplayNoView} {
+		it.setProp(display, name, v)
+	}
+	it.define(g, "display", display)
+
+	color := it.newObject()
+	colors := map[string][]jsValue{
+		"transparent": {"T"}, "black": {"G", 0.0}, "white": {"G", 1.0},
+		"dkGray": {"G", 0.25}, "gray": {"G", 0.5}, "ltGray": {"G", 0.75},
+		"red": {"RGB", 1.0, 0.0, 0.0}, "green": {"RGB", 0.0, 1.0, 0.0}, "blue": {"RGB", 0.0, 0.0, 1.0},
+		"cyan": {"CMYK", 1.0, 0.0, 0.0, 0.0}, "magenta": {"CMYK", 0.0, 1.0, 0.0, 0.0}, "yellow": {"CMYK", 0.0, 0.0, 1.0, 0.0},
+	}
+	for name, v := range colors {
+		it.setProp(color, name, it.newArray(v))
+	}
+	it.define(g, "color", color)
+
+	border := it.newObject()
+	for name, v := range map[string]string{"s": "solid", "b": "beveled", "d": "dashed", "i": "inset", "u": "underline"} {
+		it.setProp(border, name, v)
+	}
+	it.define(g, "border", border)
+	it.define(g, "global", it.newObject())
+
+	e.installApp()
+	e.installUtil()
+	e.installAForm()
+}
+
+// resetField restores the default value of a field
+func (e *JSEngine) resetField(f *jsField) {
+	if f.node == nil {
+		for _, kid := range f.kids {
+			e.resetField(e.form.fields[kid])
+		}
+		return
+	}
+	e.setFieldValue(f, []string{f.defValue})
+}
+
+// installApp installs the app and console objects. Dialogs cannot be
+// shown, so their messages go to the engine console.
+func (e *JSEngine) installApp() {
+	it := e.interp
+	g := it.global
+
+	app := it.newObject()
+	it.defineMethod(app, "alert", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		msg := argument(args, 0)
+		if o, ok := msg.(*jsObject); ok && o.class != "Array" && !o.callable() {
+			msg = it.getProp(o, "cMsg")
+		}
+		e.console.Log("ALERT: " + it.toString(msg))
+		return float64(1)
+	})
+	it.defineMethod(app, "beep", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return jsUndefined
+	})
+	it.defineMethod(app, "response", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		e.console.Log("PROMPT: " + it.argString(args, 0))
+		return jsNull
+	})
+	timer := func(it *jsInterp, this jsValue, args []jsValue) jsValue { return it.newObject() }
+	noop := func(it *jsInterp, this jsValue, args []jsValue) jsValue { return jsUndefined }
+	it.defineMethod(app, "setTimeOut", timer)
+	it.defineMethod(app, "setInterval", timer)
+	it.defineMethod(app, "clearTimeOut", noop)
+	it.defineMethod(app, "clearInterval", noop)
+	it.defineMethod(app, "launchURL", noop)
+	it.setProp(app, "viewerType", "Exchange-Pro")
+	it.setProp(app, "viewerVariation", "Full")
+	it.setProp(app, "viewerVersion", float64(11))
+	it.setProp(app, "formsVersion", float64(11))
+	it.setProp(app, "platform", "UNIX")
+	it.setProp(app, "language", "ENU")
+	it.define(g, "app", app)
+
+	console := it.newObject()
+	it.defineMethod(console, "println", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		e.console.Log(it.argString(args, 0))
+		return jsUndefined
+	})
+	it.define(console, "log", it.getProp(console, "println"))
+	it.defineMethod(console, "clear", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		e.console.Clear()
+		return jsUndefined
+	})
+	it.defineMethod(console, "show", noop)
+	it.defineMethod(console, "hide", noop)
+	it.define(g, "console", console)
+}
+
+// Events
+
+// jsEventNames maps action triggers to event names
+var jsEventNames = map[string]string{
+	"K": "Keystroke",
+	"F": "Format",
+	"V": "Validate",
+	"C": "Calculate",
+}
+
+// fieldEvent runs the script of a field action (K, F, V or C) with the
+// given event value and returns the resulting event.value and event.rc.
+// Script errors are logged to the console and leave the value unchanged;
+// resource limit violations are returned.
+func (e *JSEngine) fieldEvent(f *jsField, trigger, value string) (string, bool, error) {
+	script := f.actions[trigger]
+	if script == "" {
+		return value, true, nil
+	}
+	it := e.interp
+	var ev *jsObject
+	var prev jsValue
+	err := e.guard(func() {
+		target := e.fieldObject(f)
+		ev = it.newObject()
+		it.setProp(ev, "name", jsEventNames[trigger])
+		it.setProp(ev, "type", "Field")
+		it.setProp(ev, "target", target)
+		it.setProp(ev, "source", target)
+		it.setProp(ev, "targetName", f.name)
+		it.setProp(ev, "value", value)
+		it.setProp(ev, "change", "")
+		it.setProp(ev, "changeEx", "")
+		it.setProp(ev, "willCommit", true)
+		it.setProp(ev, "commitKey", float64(2))
+		it.setProp(ev, "selStart", float64(0))
+		it.setProp(ev, "selEnd", float64(len([]rune(value))))
+		it.setProp(ev, "fieldFull", false)
+		it.setProp(ev, "keyDown", false)
+		it.setProp(ev, "modifier", false)
+		it.setProp(ev, "shift", false)
+		it.setProp(ev, "rc", true)
+
+		prev = it.getProp(it.global, "event")
+		it.setProp(it.global, "event", ev)
+	})
+	if err != nil {
+		return value, true, err
+	}
+	defer func() { it.global.props["event"] = prev }()
+
+	if _, err := it.run(script, it.global); err != nil {
+		if isScriptLimitError(err) {
+			return value, true, err
+		}
+		e.console.Error(fmt.Sprintf("%s script of field %q: %v", jsEventNames[trigger], f.name, err))
+		return value, true, nil
+	}
+
+	result := value
+	rc := true
+	err = e.guard(func() {
+		v := it.getProp(ev, "value")
+		if !isNullish(v) {
+			result = it.toString(v)
+		}
+		rc = it.toBoolean(it.getProp(ev, "rc"))
+	})
+	if err != nil {
+		if isScriptLimitError(err) {
+			return value, true, err
+		}
+		return value, true, nil
+	}
+	return result, rc, nil
+}
+
+// calculate runs the calculate scripts of the form in calculation order,
+// updating the values they change
+func (e *JSEngine) calculate() error {
+	if e.calculating || e.noCalculate {
+		return nil
+	}
+	e.calculating = true
+	defer func() { e.calculating = false }()
+
+	for _, name := range e.form.calcOrder {
+		f := e.form.fields[name]
+		value, rc, err := e.fieldEvent(f, "C", f.value())
+		if err != nil {
+			return err
+		}
+		if rc && value != f.value() {
+			e.setFieldValue(f, []string{value})
+		}
+	}
+	return nil
+}
+
+// eventObject returns the current event object
+func (e *JSEngine) eventObject() *jsObject {
+	ev, ok := e.interp.getProp(e.interp.global, "event").(*jsObject)
+	if !ok {
+		e.interp.throwError("TypeError", "no event is being processed")
+	}
+	return ev
+}
+
+// util
+
+// jsMonthNames are the month names used by util.printd and util.scand
+var jsMonthNames = []string{"January", "February", "March", "April", "May", "June",
+	"July", "August", "September", "October", "November", "December"}
+
+// jsDayNames are the day names used by util.printd
+var jsDayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+// jsDateTokens lists the util.printd format tokens, longest first
+var jsDateTokens = []string{"mmmm", "mmm", "mm", "m", "dddd", "ddd", "dd", "d", "yyyy", "yy",
+	"HH", "H", "hh", "h", "MM", "M", "ss", "s", "tt", "t"}
+
+// jsDateFormatTokens splits a date format into tokens; literal text is
+// returned with a leading backslash
+func jsDateFormatTokens(format string) []string {
+	var tokens []string
+	for i := 0; i < len(format); {
+		if format[i] == '\\' && i+1 < len(format) {
+			tokens = append(tokens, format[i:i+2])
+			i += 2
+			continue
+		}
+		matched := false
+		for _, tok := range jsDateTokens {
+			if strings.HasPrefix(format[i:], tok) {
+				tokens = append(tokens, tok)
+				i += len(tok)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			tokens = append(tokens, "\\"+format[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+// jsPrintDate formats a time with a util.printd format
+func jsPrintDate(format string, t time.Time) string {
+	var sb strings.Builder
+	hour12 := t.Hour() % 12
+	if hour12 == 0 {
+		hour12 = 12
+	}
+	for _, tok := range jsDateFormatTokens(format) {
+		switch tok {
+		case "mmmm":
+			sb.WriteString(jsMonthNames[t.Month()-1])
+		case "mmm":
+			sb.WriteString(jsMonthNames[t.Month()-1][:3])
+		case "mm":
+			fmt.Fprintf(&sb, "%02d", int(t.Month()))
+		case "m":
+			sb.WriteString(strconv.Itoa(int(t.Month())))
+		case "dddd":
+			sb.WriteString(jsDayNames[t.Weekday()])
+		case "ddd":
+			sb.WriteString(jsDayNames[t.Weekday()][:3])
+		case "dd":
+			fmt.Fprintf(&sb, "%02d", t.Day())
+		case "d":
+			sb.WriteString(strconv.Itoa(t.Day()))
+		case "yyyy":
+			fmt.Fprintf(&sb, "%04d", t.Year())
+		case "yy":
+			fmt.Fprintf(&sb, "%02d", t.Year()%100)
+		case "HH":
+			fmt.Fprintf(&sb, "%02d", t.Hour())
+		case "H":
+			sb.WriteString(strconv.Itoa(t.Hour()))
+		case "hh":
+			fmt.Fprintf(&sb, "%02d", hour12)
+		case "h":
+			sb.WriteString(strconv.Itoa(hour12))
+		case "MM":
+			fmt.Fprintf(&sb, "%02d", t.Minute())
+		case "M":
+			sb.WriteString(strconv.Itoa(t.Minute()))
+		case "ss":
+			fmt.Fprintf(&sb, "%02d", t.Second())
+		case "s":
+			sb.WriteString(strconv.Itoa(t.Second()))
+		case "tt":
+			if t.Hour() < 12 {
+				sb.WriteString("am")
+			} else {
+				sb.WriteString("pm")
+			}
+		case "t":
+			if t.Hour() < 12 {
+				sb.WriteString("a")
+			} else {
+				sb.WriteString("p")
+			}
+		default:
+			sb.WriteString(tok[1:])
+		}
+	}
+	return sb.String()
+}
+
+// jsScanDate parses a date with a util.printd format. Like Acrobat it is
+// lenient about separators: the numbers and month names of the input are
+// matched to the fields of the format in order.
+func jsScanDate(format, input string, now time.Time) (time.Time, bool) {
+	year, month, day := now.Year(), int(now.Month()), 1
+	hour, minute, second := 0, 0, 0
+	pm, ampm := false, false
+	hasDate := false
+
+	in := []rune(input)
+	pos := 0
+	skip := func(keep func(rune) bool) {
+		for pos < len(in) && !keep(in[pos]) {
+			pos++
+		}
+	}
+	readDigits := func(max int) (int, int, bool) {
+		skip(unicode.IsDigit)
+		start := pos
+		for pos < len(in) && pos-start < max && unicode.IsDigit(in[pos]) {
+			pos++
+		}
+		if pos == start {
+			return 0, 0, false
+		}
+		n, _ := strconv.Atoi(string(in[start:pos]))
+		return n, pos - start, true
+	}
+	readWord := func() string {
+		skip(unicode.IsLetter)
+		start := pos
+		for pos < len(in) && unicode.IsLetter(in[pos]) {
+			pos++
+		}
+		return string(in[start:pos])
+	}
+
+	for _, tok := range jsDateFormatTokens(format) {
+		switch tok {
+		case "yyyy", "yy":
+			n, width, ok := readDigits(4)
+			if !ok {
+				return time.Time{}, false
+			}
+			if width <= 2 {
+				if n < 50 {
+					n += 2000
+				} else {
+					n += 1900
+				}
+			}
+			year = n
+			hasDate = true
+		case "mm", "m":
+			n, _, ok := readDigits(2)
+			if !ok {
+				return time.Time{}, false
+			}
+			month = n
+			hasDate = true
+		case "mmmm", "mmm":
+			word := strings.ToLower(readWord())
+			found := false
+			for i, name := range jsMonthNames {
+				if len(word) >= 3 && strings.HasPrefix(strings.ToLower(name), word) {
+					month = i + 1
+					found = true
+					break
+				}
+			}
+			if !found {
+				return time.Time{}, false
+			}
+			hasDate = true
+		case "dd", "d":
+			n, _, ok := readDigits(2)
+			if !ok {
+				return time.Time{}, false
+			}
+			day = n
+			hasDate = true
+		case "dddd", "ddd":
+			readWord()
+		case "HH", "H", "hh", "h":
+			n, _, ok := readDigits(2)
+			if !ok {
+				return time.Time{}, false
+			}
+			hour = n
+		case "MM", "M":
+			n, _, ok := readDigits(2)
+			if !ok {
+				return time.Time{}, false
+			}
+			minute = n
+		case "ss", "s":
+			n, _, ok := readDigits(2)
+			if !ok {
+				return time.Time{}, false
+			}
+			second = n
+		case "tt", "t":
+			save := pos
+			word := strings.ToLower(readWord())
+			if strings.HasPrefix(word, "a") || strings.HasPrefix(word, "p") {
+				ampm = true
+				pm = word[0] == 'p'
+			} else {
+				pos = save
+			}
+		}
+	}
+
+	// Unconsumed numbers mean the input does not match the format
+	for _, r := range in[pos:] {
+		if unicode.IsDigit(r) {
+			return time.Time{}, false
+		}
+	}
+	if ampm {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, false
+		}
+		hour %= 12
+		if pm {
+			hour += 12
+		}
+	}
+	if month < 1 || month > 12 || day < 1 || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, false
+	}
+	if !hasDate {
+		year, month, day = now.Year(), int(now.Month()), now.Day()
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, time.Local)
+	if t.Day() != day {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// jsGroupDigits inserts thousands separators into a digit string
+func jsGroupDigits(digits, sep string) string {
+	if sep == "" || len(digits) <= 3 {
+		return digits
+	}
+	var sb strings.Builder
+	first := len(digits) % 3
+	if first > 0 {
+		sb.WriteString(digits[:first])
+	}
+	for i := first; i < len(digits); i += 3 {
+		if sb.Len() > 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteString(digits[i : i+3])
+	}
+	return sb.String()
+}
+
+// jsFormatNumber formats a non-negative number with a separator style
+// of util.printf: 0 "1,234.56", 1 "1234.56", 2 "1.234,56", 3 "1234,56"
+// and 4 "1'234.56"
+func jsFormatNumber(v float64, decimals, sepStyle int) string {
+	s := jsFormatFixed(v, decimals)
+	intPart, frac, hasFrac := strings.Cut(s, ".")
+	neg := strings.HasPrefix(intPart, "-")
+	intPart = strings.TrimPrefix(intPart, "-")
+
+	group, point := "", "."
+	switch sepStyle {
+	case 0:
+		group = ","
+	case 2:
+		group, point = ".", ","
+	case 3:
+		point = ","
+	case 4:
+		group = "'"
+	}
+	out := jsGroupDigits(intPart, group)
+	if hasFrac {
+		out += point + frac
+	}
+	if neg {
+		out = "-" + out
+	}
+	return out
+}
+
+// jsPrintf implements util.printf
+func (e *JSEngine) jsPrintf(format string, args []jsValue) string {
+	it := e.interp
+	var sb strings.Builder
+	argIndex := 0
+	next := func() jsValue {
+		v := argument(args, argIndex)
+		argIndex++
+		return v
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			sb.WriteByte('%')
+			i++
+			continue
+		}
+
+		sepStyle := 1
+		plus, space, zero, alt := false, false, false, false
+		j := i + 1
+	flags:
+		for j < len(format) {
+			switch format[j] {
+			case ',':
+				if j+1 < len(format) && format[j+1] >= '0' && format[j+1] <= '4' {
+					sepStyle = int(format[j+1] - '0')
+					j++
+				}
+			case '+':
+				plus = true
+			case ' ':
+				space = true
+			case '0':
+				zero = true
+			case '#':
+				alt = true
+			default:
+				break flags
+			}
+			j++
+		}
+		width := 0
+		for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+			width = width*10 + int(format[j]-'0')
+			j++
+		}
+		precision := -1
+		if j < len(format) && format[j] == '.' {
+			j++
+			precision = 0
+			for j < len(format) && format[j] >= '0' && format[j] <= '9' {
+				precision = precision*10 + int(format[j]-'0')
+				j++
+			}
+		}
+		if j >= len(format) {
+			sb.WriteString(format[i:])
+			break
+		}
+
+		var out string
+		numeric := true
+		switch format[j] {
+		case 'd':
+			v := math.Trunc(it.toNumber(next()))
+			out = jsFormatNumber(math.Abs(v), 0, sepStyle)
+			if v < 0 {
+				out = "-" + out
+			}
+		case 'f':
+			v := it.toNumber(next())
+			if precision < 0 {
+				precision = 6
+			}
+			out = jsFormatNumber(math.Abs(v), precision, sepStyle)
+			if alt && precision == 0 {
+				out += "."
+			}
+			if v < 0 && strings.Trim(out, "0.,'") != "" {
+				out = "-" + out
+			}
+		case 'x':
+			out = strconv.FormatInt(int64(it.toNumber(next())), 16)
+			if alt {
+				out = "0x" + out
+			}
+		case 's':
+			numeric = false
+			out = it.toString(next())
+			if precision >= 0 && precision < len([]rune(out)) {
+				out = string([]rune(out)[:precision])
+			}
+		default:
+			sb.WriteString(format[i : j+1])
+			i = j
+			continue
+		}
+		if numeric && !strings.HasPrefix(out, "-") {
+			if plus {
+				out = "+" + out
+			} else if space {
+				out = " " + out
+			}
+		}
+		if pad := width - len([]rune(out)); pad > 0 {
+			if zero && numeric {
+				sign := ""
+				if strings.HasPrefix(out, "-") || strings.HasPrefix(out, "+") || strings.HasPrefix(out, " ") {
+					sign, out = out[:1], out[1:]
+				}
+				out = sign + strings.Repeat("0", pad) + out
+			} else {
+				out = strings.Repeat(" ", pad) + out
+			}
+		}
+		sb.WriteString(out)
+		i = j
+	}
+	return sb.String()
+}
+
+// jsPrintx implements util.printx, which formats a source string with a
+// mask: ? copies a character, X an alphanumeric, A a letter, 9 a digit,
+// * the rest; > and < switch to upper and lower case, = back to as is
+func jsPrintx(mask, source string) string {
+	src := []rune(source)
+	pos := 0
+	caseMode := 0
+	applyCase := func(r rune) rune {
+		switch caseMode {
+		case 1:
+			return unicode.ToUpper(r)
+		case -1:
+			return unicode.ToLower(r)
+		}
+		return r
+	}
+	nextMatching := func(ok func(rune) bool) (rune, bool) {
+		for pos < len(src) {
+			r := src[pos]
+			pos++
+			if ok(r) {
+				return r, true
+			}
+		}
+		return 0, false
+	}
+
+	var sb strings.Builder
+	m := []rune(mask)
+	for i := 0; i < len(m); i++ {
+		switch m[i] {
+		case '?':
+			if pos < len(src) {
+				sb.WriteRune(applyCase(src[pos]))
+				pos++
+			}
+		case 'X':
+			if r, ok := nextMatching(func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }); ok {
+				sb.WriteRune(applyCase(r))
+			}
+		case 'A':
+			if r, ok := nextMatching(unicode.IsLetter); ok {
+				sb.WriteRune(applyCase(r))
+			}
+		case '9':
+			if r, ok := nextMatching(unicode.IsDigit); ok {
+				sb.WriteRune(r)
+			}
+		case '*':
+			for ; pos < len(src); pos++ {
+				sb.WriteRune(applyCase(src[pos]))
+			}
+		case '>':
+			caseMode = 1
+		case '<':
+			caseMode = -1
+		case '=':
+			caseMode = 0
+		case '\\':
+			if i+1 < len(m) {
+				i++
+				sb.WriteRune(m[i])
+			}
+		default:
+			sb.WriteRune(m[i])
+		}
+	}
+	return sb.String()
+}
+
+// jsDateValue converts a script value to a time
+func (e *JSEngine) jsDateValue(v jsValue) (time.Time, bool) {
+	it := e.interp
+	var ms float64
+	if d, ok := v.(*jsObject); ok && d.class == "Date" {
+		ms = d.value.(float64)
+	} else {
+		ms = it.toNumber(v)
+	}
+	if math.IsNaN(ms) {
+		return time.Time{}, false
+	}
+	return jsTime(ms, time.Local), true
+}
+
+// jsUtilDateFormats are the formats selected by a numeric util.printd format
+var jsUtilDateFormats = []string{"D:yyyymmddHHMMss", "yyyy.mm.dd HH:MM:ss", "m/d/yy h:MM:ss tt"}
+
+func (e *JSEngine) installUtil() {
+	it := e.interp
+	util := it.newObject()
+	it.define(it.global, "util", util)
+
+	it.defineMethod(util, "printf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var rest []jsValue
+		if len(args) > 1 {
+			rest = args[1:]
+		}
+		return e.jsPrintf(it.argString(args, 0), rest)
+	})
+	it.defineMethod(util, "printd", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		format := argument(args, 0)
+		layout := ""
+		if n, ok := format.(float64); ok {
+			if i := int(n); i >= 0 && i < len(jsUtilDateFormats) {
+				layout = jsUtilDateFormats[i]
+			}
+		} else {
+			layout = it.toString(format)
+		}
+		t, ok := e.jsDateValue(argument(args, 1))
+		if !ok {
+			return jsNull
+		}
+		return jsPrintDate(layout, t)
+	})
+	it.defineMethod(util, "scand", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		t, ok := jsScanDate(it.argString(args, 0), it.argString(args, 1), time.Now())
+		if !ok {
+			return jsNull
+		}
+		return it.newDate(float64(t.UnixMilli()))
+	})
+	it.defineMethod(util, "printx", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return jsPrintx(it.argString(args, 0), it.argString(args, 1))
+	})
+}
+
+// AForm.js
+
+// jsAFDateFormats are the formats of AFDate_Format
+var jsAFDateFormats = []string{"m/d", "m/d/yy", "mm/dd/yy", "mm/yy", "d-mmm", "d-mmm-yy", "dd-mmm-yy",
+	"yy-mm-dd", "mmm-yy", "mmmm-yy", "mmm d, yyyy", "mmmm d, yyyy", "m/d/yy h:MM tt", "m/d/yy HH:MM"}
+
+// jsAFTimeFormats are the formats of AFTime_Format
+var jsAFTimeFormats = []string{"HH:MM", "h:MM tt", "HH:MM:ss", "h:MM:ss tt"}
+
+// jsAFMakeNumber converts a field value to a number the way AFMakeNumber
+// does, accepting a decimal comma and thousands separators
+func jsAFMakeNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	s = strings.ReplaceAll(s, " ", "")
+	dot := strings.LastIndex(s, ".")
+	comma := strings.LastIndex(s, ",")
+	switch {
+	case dot >= 0 && comma >= 0:
+		if comma > dot {
+			s = strings.ReplaceAll(s, ".", "")
+			s = strings.Replace(s, ",", ".", 1)
+		} else {
+			s = strings.ReplaceAll(s, ",", "")
+		}
+	case comma >= 0:
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	s = strings.ReplaceAll(s, "'", "")
+	if !jsNumericValue.MatchString(s) {
+		return 0, false
+	}
+	v := jsStringToNumber(s)
+	return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// jsAFKeystrokeAllowed reports whether a partial entry of a number field
+// may still become a number
+var jsAFNumberEntry = regexp.MustCompile(`^[+-]?[0-9 ,.']*$`)
+
+func (e *JSEngine) installAForm() {
+	it := e.interp
+	g := it.global
+
+	eventString := func(ev *jsObject, name string) string {
+		v := it.getProp(ev, name)
+		if isNullish(v) {
+			return ""
+		}
+		return it.toString(v)
+	}
+	reject := func(ev *jsObject, msg string) {
+		it.setProp(ev, "rc", false)
+		e.console.Log("ALERT: " + msg)
+	}
+	define := func(name string, fn jsNativeFunc) {
+		it.defineMethod(g, name, fn)
+	}
+
+	define("AFMakeNumber", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		if n, ok := argument(args, 0).(float64); ok {
+			return n
+		}
+		if v, ok := jsAFMakeNumber(it.argString(args, 0)); ok {
+			return v
+		}
+		return jsNull
+	})
+	define("AFMergeChange", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ev, ok := argument(args, 0).(*jsObject)
+		if !ok {
+			ev = e.eventObject()
+		}
+		value := eventString(ev, "value")
+		if it.toBoolean(it.getProp(ev, "willCommit")) {
+			return value
+		}
+		r := []rune(value)
+		start := jsRelativeIndex(it.toInteger(it.getProp(ev, "selStart")), len(r))
+		end := jsRelativeIndex(it.toInteger(it.getProp(ev, "selEnd")), len(r))
+		if end < start {
+			end = start
+		}
+		return string(r[:start]) + eventString(ev, "change") + string(r[end:])
+	})
+	define("AFExtractNums", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		nums := regexp.MustCompile(`[0-9]+`).FindAllString(it.argString(args, 0), -1)
+		if nums == nil {
+			return jsNull
+		}
+		out := make([]jsValue, len(nums))
+		for i, n := range nums {
+			out[i] = n
+		}
+		return it.newArray(out)
+	})
+
+	// Numbers
+	numberFormat := func(args []jsValue, percent bool) {
+		ev := e.eventObject()
+		value := eventString(ev, "value")
+		v, ok := jsAFMakeNumber(value)
+		if !ok {
+			if strings.TrimSpace(value) == "" || !percent {
+				it.setProp(ev, "value", "")
+			}
+			return
+		}
+		decimals := int(math.Max(0, it.toInteger(argument(args, 0))))
+		sepStyle := int(it.toInteger(argument(args, 1)))
+
+		if percent {
+			s := jsFormatNumber(math.Abs(v*100), decimals, sepStyle)
+			if v < 0 {
+				s = "-" + s
+			}
+			if it.toBoolean(argument(args, 2)) {
+				s = "%" + s
+			} else {
+				s += "%"
+			}
+			it.setProp(ev, "value", s)
+			return
+		}
+
+		negStyle := int(it.toInteger(argument(args, 2)))
+		currency := it.toString(argument(args, 4))
+		if isNullish(argument(args, 4)) {
+			currency = ""
+		}
+		s := jsFormatNumber(math.Abs(v), decimals, sepStyle)
+		if it.toBoolean(argument(args, 5)) {
+			s = currency + s
+		} else {
+			s += currency
+		}
+		if v < 0 && strings.Trim(jsFormatFixed(math.Abs(v), decimals), "0.") != "" {
+			switch negStyle {
+			case 0:
+				s = "-" + s
+			case 2, 3:
+				s = "(" + s + ")"
+			}
+		}
+		it.setProp(ev, "value", s)
+	}
+	numberKeystroke := func(args []jsValue) {
+		ev := e.eventObject()
+		if it.toBoolean(it.getProp(ev, "willCommit")) {
+			value := eventString(ev, "value")
+			if _, ok := jsAFMakeNumber(value); !ok && strings.TrimSpace(value) != "" {
+				reject(ev, "The value entered does not match the format of the field ["+eventString(ev, "targetName")+"]")
+			}
+			return
+		}
+		merged := it.toString(it.call(it.getProp(g, "AFMergeChange"), jsUndefined, []jsValue{ev}))
+		if !jsAFNumberEntry.MatchString(merged) {
+			it.setProp(ev, "rc", false)
+		}
+	}
+	define("AFNumber_Format", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		numberFormat(args, false)
+		return jsUndefined
+	})
+	define("AFNumber_Keystroke", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		numberKeystroke(args)
+		return jsUndefined
+	})
+	define("AFPercent_Format", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		numberFormat(args, true)
+		return jsUndefined
+	})
+	define("AFPercent_Keystroke", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		numberKeystroke(args)
+		return jsUndefined
+	})
+
+	// Dates and times
+	parseDate := func(format, value string) (time.Time, bool) {
+		if t, ok := jsScanDate(format, value, time.Now()); ok {
+			return t, true
+		}
+		if ms := jsParseDate(value); !math.IsNaN(ms) {
+			return jsTime(ms, time.Local), true
+		}
+		return time.Time{}, false
+	}
+	dateFormat := func(format string) {
+		ev := e.eventObject()
+		value := eventString(ev, "value")
+		if value == "" {
+			return
+		}
+		if t, ok := parseDate(format, value); ok {
+			it.setProp(ev, "value", jsPrintDate(format, t))
+		}
+	}
+	dateKeystroke := func(format string) {
+		ev := e.eventObject()
+		if !it.toBoolean(it.getProp(ev, "willCommit")) {
+			return
+		}
+		value := eventString(ev, "value")
+		if value == "" {
+			return
+		}
+		if _, ok := parseDate(format, value); !ok {
+			reject(ev, "Invalid date/time: please ensure that the date/time exists. Field ["+
+				eventString(ev, "targetName")+"] should match format "+format)
+		}
+	}
+	indexed := func(formats []string, v jsValue) string {
+		i := int(it.toInteger(v))
+		if i < 0 || i >= len(formats) {
+			i = 0
+		}
+		return formats[i]
+	}
+	define("AFDate_FormatEx", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		dateFormat(it.argString(args, 0))
+		return jsUndefined
+	})
+	define("AFDate_KeystrokeEx", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		dateKeystroke(it.argString(args, 0))
+		return jsUndefined
+	})
+	define("AFDate_Format", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		dateFormat(indexed(jsAFDateFormats, argument(args, 0)))
+		return jsUndefined
+	})
+	define("AFDate_Keystroke", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		dateKeystroke(indexed(jsAFDateFormats, argument(args, 0)))
+		return jsUndefined
+	})
+	define("AFTime_FormatEx", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		dateFormat(it.argString(args, 0))
+		return jsUndefined
+	})
+	define("AFTime_KeystrokeEx", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		dateKeystroke(it.argString(args, 0))
+		return jsUndefined
+	})
+	define("AFTime_Format", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		dateFormat(indexed(jsAFTimeFormats, argument(args, 0)))
+		return jsUndefined
+	})
+	define("AFTime_Keystroke", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		dateKeystroke(indexed(jsAFTimeFormats, argument(args, 0)))
+		return jsUndefined
+	})
+
+	// Special formats: 0 zip code, 1 zip+4, 2 phone number, 3 SSN
+	specialMask := func(psf int, value string) string {
+		switch psf {
+		case 0:
+			return "99999"
+		case 1:
+			return "99999-9999"
+		case 2:
+			if len(regexp.MustCompile(`[0-9]`).FindAllString(value, -1)) >= 10 {
+				return "(999) 999-9999"
+			}
+			return "999-9999"
+		case 3:
+			return "999-99-9999"
+		}
+		return ""
+	}
+	define("AFSpecial_Format", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ev := e.eventObject()
+		value := eventString(ev, "value")
+		if value == "" {
+			return jsUndefined
+		}
+		if mask := specialMask(int(it.toInteger(argument(args, 0))), value); mask != "" {
+			it.setProp(ev, "value", jsPrintx(mask, value))
+		}
+		return jsUndefined
+	})
+	maskKeystroke := func(ev *jsObject, masks ...string) {
+		if !it.toBoolean(it.getProp(ev, "willCommit")) {
+			return
+		}
+		value := eventString(ev, "value")
+		if value == "" {
+			return
+		}
+		for _, mask := range masks {
+			if jsMatchMask(mask, value) {
+				return
+			}
+		}
+		reject(ev, "The value entered does not match the format of the field ["+eventString(ev, "targetName")+"]")
+	}
+	define("AFSpecial_Keystroke", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ev := e.eventObject()
+		switch int(it.toInteger(argument(args, 0))) {
+		case 0:
+			maskKeystroke(ev, "99999")
+		case 1:
+			maskKeystroke(ev, "99999-9999")
+		case 2:
+			maskKeystroke(ev, "(999) 999-9999", "999-9999")
+		case 3:
+			maskKeystroke(ev, "999-99-9999")
+		}
+		return jsUndefined
+	})
+	define("AFSpecial_KeystrokeEx", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		if mask := it.argString(args, 0); mask != "" {
+			maskKeystroke(e.eventObject(), mask)
+		}
+		return jsUndefined
+	})
+
+	// Calculation and validation
+	define("AFSimple_Calculate", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		op := strings.ToUpper(it.argString(args, 0))
+		var names []string
+		if a, ok := argument(args, 1).(*jsObject); ok && a.class == "Array" {
+			for _, v := range a.array {
+				names = append(names, it.toString(v))
+			}
+		} else {
+			for _, name := range strings.Split(it.argString(args, 1), ",") {
+				names = append(names, strings.TrimSpace(name))
+			}
+		}
+
+		var values []float64
+		var collect func(f *jsField)
+		collect = func(f *jsField) {
+			if f.node == nil {
+				for _, kid := range f.kids {
+					collect(e.form.fields[kid])
+				}
+				return
+			}
+			v, _ := jsAFMakeNumber(f.value())
+			values = append(values, v)
+		}
+		for _, name := range names {
+			if f, ok := e.form.fields[name]; ok {
+				collect(f)
+			}
+		}
+
+		result := 0.0
+		switch op {
+		case "PRD":
+			result = 1
+			for _, v := range values {
+				result *= v
+			}
+		case "AVG", "SUM":
+			for _, v := range values {
+				result += v
+			}
+			if op == "AVG" && len(values) > 0 {
+				result /= float64(len(values))
+			}
+		case "MIN", "MAX":
+			for i, v := range values {
+				if i == 0 || op == "MIN" && v < result || op == "MAX" && v > result {
+					result = v
+				}
+			}
+		}
+		it.setProp(e.eventObject(), "value", result)
+		return jsUndefined
+	})
+	define("AFRange_Validate", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ev := e.eventObject()
+		value := eventString(ev, "value")
+		if value == "" {
+			return jsUndefined
+		}
+		v, ok := jsAFMakeNumber(value)
+		if !ok {
+			return jsUndefined
+		}
+		checkGT, gt := it.toBoolean(argument(args, 0)), it.toNumber(argument(args, 1))
+		checkLT, lt := it.toBoolean(argument(args, 2)), it.toNumber(argument(args, 3))
+		var msg string
+		switch {
+		case checkGT && checkLT && (v < gt || v > lt):
+			msg = fmt.Sprintf("Invalid value: must be greater than or equal to %s and less than or equal to %s.", jsNumberToString(gt), jsNumberToString(lt))
+		case checkGT && !checkLT && v < gt:
+			msg = fmt.Sprintf("Invalid value: must be greater than or equal to %s.", jsNumberToString(gt))
+		case checkLT && !checkGT && v > lt:
+			msg = fmt.Sprintf("Invalid value: must be less than or equal to %s.", jsNumberToString(lt))
+		}
+		if msg != "" {
+			reject(ev, msg)
+		}
+		return jsUndefined
+	})
+}
+
+// jsMatchMask reports whether a value matches an AFSpecial_KeystrokeEx
+// mask: 9 is a digit, A a letter, O or X an alphanumeric, ? any character
+// and other mask characters are literals
+func jsMatchMask(mask, value string) bool {
+	m, v := []rune(mask), []rune(value)
+	if len(m) != len(v) {
+		return false
+	}
+	for i, c := range m {
+		r := v[i]
+		switch c {
+		case '9':
+			if !unicode.IsDigit(r) {
+				return false
+			}
+		case 'A':
+			if !unicode.IsLetter(r) {
+				return false
+			}
+		case 'O', 'X':
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return false
+			}
+		case '?':
+		default:
+			if c != r {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Go value conversion
+
+// importValue converts a Go value to a script value
+func (e *JSEngine) importValue(v interface{}) jsValue {
+	it := e.interp
+	switch x := v.(type) {
+	case nil:
+		return jsNull
+	case jsValue:
+		switch x.(type) {
+		case jsUndefinedType, jsNullType, *jsObject:
+			return x
+		}
+	}
+	switch x := v.(type) {
+	case bool:
+		return x
+	case string:
+		return x
+	case float64:
+		return x
+	case float32:
+		return float64(x)
+	case int:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case int32:
+		return float64(x)
+	case uint:
+		return float64(x)
+	case uint32:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	case time.Time:
+		return it.newDate(float64(x.UnixMilli()))
+	case []string:
+		out := make([]jsValue, len(x))
+		for i, s := range x {
+			out[i] = s
+		}
+		return it.newArray(out)
+	case []interface{}:
+		out := make([]jsValue, len(x))
+		for i, item := range x {
+			out[i] = e.importValue(item)
+		}
+		return it.newArray(out)
+	case map[string]interface{}:
+		o := it.newObject()
+		for k, item := range x {
+			it.setProp(o, k, e.importValue(item))
+		}
+		return o
+	case JSFunction:
+		return e.goFunction("", x)
+	}
+	return fmt.Sprint(v)
+}
+
+// exportValue converts a script value to a Go value: nil, bool, float64,
+// string, time.Time, []interface{} or map[string]interface{}
+func (e *JSEngine) exportValue(v jsValue) interface{} {
+	return e.export(v, 0)
+}
+
+func (e *JSEngine) export(v jsValue, depth int) interface{} {
+	it := e.interp
+	switch x := v.(type) {
+	case bool, float64, string:
+		return x
+	case *jsObject:
+		if depth > 16 {
+			return nil
+		}
+		switch {
+		case x.class == "Date":
+			if ms := x.value.(float64); !math.IsNaN(ms) {
+				return jsTime(ms, time.Local)
+			}
+			return nil
+		case x.class == "Array":
+			out := make([]interface{}, len(x.array))
+			for i, item := range x.array {
+				out[i] = e.export(item, depth+1)
+			}
+			return out
+		case x.callable():
+			return "function " + x.name
+		case x.host != nil:
+			if h, ok := x.host.(*jsFieldHost); ok {
+				return map[string]interface{}{
+					"name":     h.f.name,
+					"type":     h.f.typeName(),
+					"value":    h.f.value(),
+					"readOnly": h.f.readonly,
+					"required": h.f.required,
+				}
+			}
+		}
+		out := make(map[string]interface{})
+		for _, k := range it.ownKeys(x) {
+			if val, ok := x.props[k]; ok {
+				out[k] = e.export(val, depth+1)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// goFunction wraps a Go function as a script function
+func (e *JSEngine) goFunction(name string, fn JSFunction) *jsObject {
+	return e.interp.newNative(name, func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		goArgs := make([]interface{}, len(args))
+		for i, a := range args {
+			goArgs[i] = e.exportValue(a)
+		}
+		return e.importValue(fn(goArgs))
+	})
+}
@@ -0,0 +1,1961 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// setupGlobals installs the standard built-in objects
+func (it *jsInterp) setupGlobals() {
+	it.objectProto = &jsObject{class: "Object", props: make(map[string]jsValue)}
+	it.functionProto = it.newObjectWithProto("Function", it.objectProto)
+	it.functionProto.native = func(it *jsInterp, this jsValue, args []jsValue) jsValue { return jsUndefined }
+
+	it.global = it.newObjectWithProto("global", it.objectProto)
+	it.globalScope = &jsScope{vars: make(map[string]jsValue), object: it.global, this: it.global, hasThis: true, function: true}
+
+	it.arrayProto = it.newObjectWithProto("Array", it.objectProto)
+	it.arrayProto.array = []jsValue{}
+	it.stringProto = it.newObjectWithProto("String", it.objectProto)
+	it.stringProto.value = ""
+	it.numberProto = it.newObjectWithProto("Number", it.objectProto)
+	it.numberProto.value = float64(0)
+	it.booleanProto = it.newObjectWithProto("Boolean", it.objectProto)
+	it.booleanProto.value = false
+	it.dateProto = it.newObjectWithProto("Object", it.objectProto)
+	it.regexpProto = it.newObjectWithProto("Object", it.objectProto)
+	it.errorProto = it.newObjectWithProto("Object", it.objectProto)
+
+	it.define(it.global, "NaN", math.NaN())
+	it.define(it.global, "Infinity", math.Inf(1))
+	it.define(it.global, "undefined", jsUndefined)
+
+	it.setupObject()
+	it.setupFunction()
+	it.setupArray()
+	it.setupString()
+	it.setupNumber()
+	it.setupBoolean()
+	it.setupMath()
+	it.setupErrors()
+	it.setupDate()
+	it.setupRegExp()
+	it.setupGlobalFunctions()
+}
+
+// defineConstructor installs a built-in constructor with its prototype
+func (it *jsInterp) defineConstructor(name string, proto *jsObject, call, construct jsNativeFunc) *jsObject {
+	ctor := it.newNative(name, call)
+	ctor.construct = construct
+	it.define(ctor, "prototype", proto)
+	it.define(proto, "constructor", ctor)
+	it.define(it.global, name, ctor)
+	return ctor
+}
+
+func (it *jsInterp) argNumber(args []jsValue, i int) float64 {
+	return it.toNumber(argument(args, i))
+}
+
+func (it *jsInterp) argString(args []jsValue, i int) string {
+	return it.toString(argument(args, i))
+}
+
+// argFunction returns a callable argument or throws a TypeError
+func (it *jsInterp) argFunction(args []jsValue, i int) *jsObject {
+	fn, ok := argument(args, i).(*jsObject)
+	if !ok || !fn.callable() {
+		it.throwError("TypeError", "%s is not a function", it.toString(argument(args, i)))
+	}
+	return fn
+}
+
+// jsRelativeIndex resolves a possibly negative index against a length
+func jsRelativeIndex(v float64, n int) int {
+	if v < 0 {
+		v += float64(n)
+		if v < 0 {
+			v = 0
+		}
+	} else if v > float64(n) {
+		v = float64(n)
+	}
+	return int(v)
+}
+
+// Object and Function
+
+func (it *jsInterp) setupObject() {
+	ctor := it.defineConstructor("Object", it.objectProto,
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			if v := argument(args, 0); !isNullish(v) {
+				return it.toObject(v)
+			}
+			return it.newObject()
+		}, nil)
+	ctor.construct = ctor.native
+
+	it.defineMethod(ctor, "keys", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var out []jsValue
+		for _, k := range it.ownKeys(it.toObject(argument(args, 0))) {
+			out = append(out, k)
+		}
+		return it.newArray(out)
+	})
+	it.defineMethod(ctor, "values", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		o := it.toObject(argument(args, 0))
+		var out []jsValue
+		for _, k := range it.ownKeys(o) {
+			out = append(out, it.getProp(o, k))
+		}
+		return it.newArray(out)
+	})
+	it.defineMethod(ctor, "entries", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		o := it.toObject(argument(args, 0))
+		var out []jsValue
+		for _, k := range it.ownKeys(o) {
+			out = append(out, it.newArray([]jsValue{k, it.getProp(o, k)}))
+		}
+		return it.newArray(out)
+	})
+	it.defineMethod(ctor, "assign", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		target := it.toObject(argument(args, 0))
+		for _, src := range args[1:] {
+			if s, ok := src.(*jsObject); ok {
+				for _, k := range it.ownKeys(s) {
+					it.setProp(target, k, it.getProp(s, k))
+				}
+			}
+		}
+		return target
+	})
+	it.defineMethod(ctor, "create", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		o := it.newObject()
+		switch p := argument(args, 0).(type) {
+		case *jsObject:
+			o.proto = p
+		case jsNullType:
+			o.proto = nil
+		default:
+			it.throwError("TypeError", "Object prototype may only be an Object or null")
+		}
+		return o
+	})
+	it.defineMethod(ctor, "getPrototypeOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		if p := it.toObject(argument(args, 0)).proto; p != nil {
+			return p
+		}
+		return jsNull
+	})
+	it.defineMethod(ctor, "defineProperty", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		o, ok := argument(args, 0).(*jsObject)
+		desc, ok2 := argument(args, 2).(*jsObject)
+		if !ok || !ok2 {
+			it.throwError("TypeError", "Object.defineProperty called on non-object")
+		}
+		key := it.propertyKey(argument(args, 1))
+		getter, _ := it.getProp(desc, "get").(*jsObject)
+		setter, _ := it.getProp(desc, "set").(*jsObject)
+		if getter == nil && setter == nil {
+			it.setProp(o, key, it.getProp(desc, "value"))
+		} else {
+			if getter != nil {
+				if o.getters == nil {
+					o.getters = make(map[string]*jsObject)
+				}
+				o.getters[key] = getter
+			}
+			if setter != nil {
+				if o.setters == nil {
+					o.setters = make(map[string]*jsObject)
+				}
+				o.setters[key] = setter
+			}
+		}
+		if !it.toBoolean(it.getProp(desc, "enumerable")) {
+			if o.hidden == nil {
+				o.hidden = make(map[string]bool)
+			}
+			o.hidden[key] = true
+		}
+		if _, exists := o.props[key]; !exists && (getter != nil || setter != nil) {
+			o.keys = append(o.keys, key)
+		}
+		return o
+	})
+	identity := func(it *jsInterp, this jsValue, args []jsValue) jsValue { return argument(args, 0) }
+	it.defineMethod(ctor, "freeze", identity)
+	it.defineMethod(ctor, "seal", identity)
+
+	proto := it.objectProto
+	it.defineMethod(proto, "hasOwnProperty", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.hasOwn(it.toObject(this), it.propertyKey(argument(args, 0)))
+	})
+	it.defineMethod(proto, "isPrototypeOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		self, _ := this.(*jsObject)
+		if o, ok := argument(args, 0).(*jsObject); ok {
+			for p := o.proto; p != nil; p = p.proto {
+				if p == self {
+					return true
+				}
+			}
+		}
+		return false
+	})
+	it.defineMethod(proto, "toString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		switch this.(type) {
+		case jsUndefinedType:
+			return "[object Undefined]"
+		case jsNullType:
+			return "[object Null]"
+		}
+		class := it.toObject(this).class
+		if class == "global" {
+			class = "Object"
+		}
+		return "[object " + class + "]"
+	})
+	it.defineMethod(proto, "toLocaleString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.toString(this)
+	})
+	it.defineMethod(proto, "valueOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return this
+	})
+}
+
+func (it *jsInterp) setupFunction() {
+	ctor := it.defineConstructor("Function", it.functionProto,
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			it.throwError("EvalError", "Function constructor is not supported")
+			return nil
+		}, nil)
+	ctor.construct = ctor.native
+
+	proto := it.functionProto
+	it.defineMethod(proto, "call", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var rest []jsValue
+		if len(args) > 1 {
+			rest = args[1:]
+		}
+		return it.call(this, argument(args, 0), rest)
+	})
+	it.defineMethod(proto, "apply", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var list []jsValue
+		if a, ok := argument(args, 1).(*jsObject); ok && a.class == "Array" {
+			list = append(list, a.array...)
+		}
+		return it.call(this, argument(args, 0), list)
+	})
+	it.defineMethod(proto, "bind", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		target, ok := this.(*jsObject)
+		if !ok || !target.callable() {
+			it.throwError("TypeError", "Bind must be called on a function")
+		}
+		fn := it.newObjectWithProto("Function", it.functionProto)
+		fn.boundTarget = target
+		fn.boundThis = argument(args, 0)
+		if len(args) > 1 {
+			fn.boundArgs = append([]jsValue(nil), args[1:]...)
+		}
+		fn.name = "bound " + target.name
+		return fn
+	})
+	it.defineMethod(proto, "toString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		name := ""
+		if fn, ok := this.(*jsObject); ok {
+			name = fn.name
+		}
+		return "function " + name + "() { [native code] }"
+	})
+}
+
+// Array
+
+// thisArray returns the array a method was called on
+func (it *jsInterp) thisArray(this jsValue, method string) *jsObject {
+	a, ok := this.(*jsObject)
+	if !ok || a.class != "Array" {
+		it.throwError("TypeError", "Array.prototype.%s called on non-array", method)
+	}
+	return a
+}
+
+// arrayJoin joins array elements for join and toString
+func (it *jsInterp) arrayJoin(a *jsObject, sep string) string {
+	var sb strings.Builder
+	for i, v := range a.array {
+		it.tick()
+		if i > 0 {
+			it.alloc(len(sep))
+			sb.WriteString(sep)
+		}
+		if !isNullish(v) {
+			str := it.toString(v)
+			it.alloc(len(str))
+			sb.WriteString(str)
+		}
+	}
+	return sb.String()
+}
+
+func (it *jsInterp) setupArray() {
+	ctor := it.defineConstructor("Array", it.arrayProto,
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			if len(args) == 1 {
+				if n, ok := args[0].(float64); ok {
+					if n < 0 || n != math.Floor(n) || n > 1<<32-1 {
+						it.throwError("RangeError", "Invalid array length")
+					}
+					a := it.newArray(nil)
+					it.setArrayLength(a, int(n))
+					return a
+				}
+			}
+			return it.newArray(append([]jsValue(nil), args...))
+		}, nil)
+	ctor.construct = ctor.native
+
+	it.defineMethod(ctor, "isArray", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a, ok := argument(args, 0).(*jsObject)
+		return ok && a.class == "Array"
+	})
+	it.defineMethod(ctor, "from", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var items []jsValue
+		switch src := argument(args, 0).(type) {
+		case string:
+			items = it.spreadValues(src)
+		case *jsObject:
+			if src.class == "Array" {
+				items = append(items, src.array...)
+			} else {
+				n := int(it.toInteger(it.getProp(src, "length")))
+				it.alloc(16 * n)
+				for i := 0; i < n; i++ {
+					it.tick()
+					items = append(items, it.getProp(src, strconv.Itoa(i)))
+				}
+			}
+		}
+		if fn, ok := argument(args, 1).(*jsObject); ok && fn.callable() {
+			for i, v := range items {
+				items[i] = it.call(fn, jsUndefined, []jsValue{v, float64(i)})
+			}
+		}
+		return it.newArray(items)
+	})
+	it.defineMethod(ctor, "of", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.newArray(append([]jsValue(nil), args...))
+	})
+
+	proto := it.arrayProto
+	it.defineMethod(proto, "push", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "push")
+		it.alloc(16 * len(args))
+		a.array = append(a.array, args...)
+		return float64(len(a.array))
+	})
+	it.defineMethod(proto, "pop", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "pop")
+		if len(a.array) == 0 {
+			return jsUndefined
+		}
+		v := a.array[len(a.array)-1]
+		a.array = a.array[:len(a.array)-1]
+		return v
+	})
+	it.defineMethod(proto, "shift", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "shift")
+		if len(a.array) == 0 {
+			return jsUndefined
+		}
+		v := a.array[0]
+		a.array = append([]jsValue(nil), a.array[1:]...)
+		return v
+	})
+	it.defineMethod(proto, "unshift", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "unshift")
+		it.alloc(16 * len(args))
+		a.array = append(append([]jsValue(nil), args...), a.array...)
+		return float64(len(a.array))
+	})
+	it.defineMethod(proto, "slice", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "slice")
+		n := len(a.array)
+		start := jsRelativeIndex(it.toInteger(argument(args, 0)), n)
+		end := n
+		if v := argument(args, 1); v != jsUndefined {
+			end = jsRelativeIndex(it.toInteger(v), n)
+		}
+		if start >= end {
+			return it.newArray(nil)
+		}
+		return it.newArray(append([]jsValue(nil), a.array[start:end]...))
+	})
+	it.defineMethod(proto, "splice", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "splice")
+		n := len(a.array)
+		start := jsRelativeIndex(it.toInteger(argument(args, 0)), n)
+		count := n - start
+		if len(args) > 1 {
+			count = int(math.Max(0, math.Min(it.toInteger(args[1]), float64(n-start))))
+		} else if len(args) == 0 {
+			count = 0
+		}
+		removed := append([]jsValue(nil), a.array[start:start+count]...)
+		var insert []jsValue
+		if len(args) > 2 {
+			insert = args[2:]
+		}
+		it.alloc(16 * len(insert))
+		rest := append([]jsValue(nil), a.array[start+count:]...)
+		a.array = append(append(a.array[:start], insert...), rest...)
+		return it.newArray(removed)
+	})
+	it.defineMethod(proto, "concat", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "concat")
+		it.alloc(16 * len(a.array))
+		out := append([]jsValue(nil), a.array...)
+		for _, v := range args {
+			it.tick()
+			if b, ok := v.(*jsObject); ok && b.class == "Array" {
+				it.alloc(16 * len(b.array))
+				out = append(out, b.array...)
+			} else {
+				out = it.appendValue(out, v, 0)
+			}
+		}
+		return it.arrayOf(out)
+	})
+	it.defineMethod(proto, "join", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		sep := ","
+		if v := argument(args, 0); v != jsUndefined {
+			sep = it.toString(v)
+		}
+		return it.arrayJoin(it.thisArray(this, "join"), sep)
+	})
+	it.defineMethod(proto, "toString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.arrayJoin(it.thisArray(this, "toString"), ",")
+	})
+	it.defineMethod(proto, "reverse", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "reverse")
+		for i, j := 0, len(a.array)-1; i < j; i, j = i+1, j-1 {
+			it.tick()
+			a.array[i], a.array[j] = a.array[j], a.array[i]
+		}
+		return a
+	})
+	indexOf := func(last bool) jsNativeFunc {
+		return func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			a := it.thisArray(this, "indexOf")
+			target := argument(args, 0)
+			if last {
+				for i := len(a.array) - 1; i >= 0; i-- {
+					it.tick()
+					if jsStrictEquals(a.array[i], target) {
+						return float64(i)
+					}
+				}
+				return float64(-1)
+			}
+			for i := jsRelativeIndex(it.toInteger(argument(args, 1)), len(a.array)); i < len(a.array); i++ {
+				it.tick()
+				if jsStrictEquals(a.array[i], target) {
+					return float64(i)
+				}
+			}
+			return float64(-1)
+		}
+	}
+	it.defineMethod(proto, "indexOf", indexOf(false))
+	it.defineMethod(proto, "lastIndexOf", indexOf(true))
+	it.defineMethod(proto, "includes", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		target := argument(args, 0)
+		for _, v := range it.thisArray(this, "includes").array {
+			it.tick()
+			if jsStrictEquals(v, target) {
+				return true
+			}
+			if f, ok := v.(float64); ok && math.IsNaN(f) {
+				if g, ok := target.(float64); ok && math.IsNaN(g) {
+					return true
+				}
+			}
+		}
+		return false
+	})
+	it.defineMethod(proto, "fill", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "fill")
+		n := len(a.array)
+		start := jsRelativeIndex(it.toInteger(argument(args, 1)), n)
+		end := n
+		if v := argument(args, 2); v != jsUndefined {
+			end = jsRelativeIndex(it.toInteger(v), n)
+		}
+		for i := start; i < end; i++ {
+			it.tick()
+			a.array[i] = argument(args, 0)
+		}
+		return a
+	})
+
+	// iterate calls fn(element, index, array) for each element until stop
+	// returns true, reporting the index at which it stopped
+	iterate := func(it *jsInterp, this jsValue, args []jsValue, method string, stop func(i int, v, r jsValue) bool) int {
+		a := it.thisArray(this, method)
+		fn := it.argFunction(args, 0)
+		thisArg := argument(args, 1)
+		for i := 0; i < len(a.array); i++ {
+			v := a.array[i]
+			if stop(i, v, it.call(fn, thisArg, []jsValue{v, float64(i), a})) {
+				return i
+			}
+		}
+		return -1
+	}
+	it.defineMethod(proto, "forEach", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		iterate(it, this, args, "forEach", func(int, jsValue, jsValue) bool { return false })
+		return jsUndefined
+	})
+	it.defineMethod(proto, "map", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var out []jsValue
+		iterate(it, this, args, "map", func(_ int, _, r jsValue) bool {
+			out = append(out, r)
+			return false
+		})
+		return it.newArray(out)
+	})
+	it.defineMethod(proto, "filter", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var out []jsValue
+		iterate(it, this, args, "filter", func(_ int, v, r jsValue) bool {
+			if it.toBoolean(r) {
+				out = append(out, v)
+			}
+			return false
+		})
+		return it.newArray(out)
+	})
+	it.defineMethod(proto, "some", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return iterate(it, this, args, "some", func(_ int, _, r jsValue) bool { return it.toBoolean(r) }) >= 0
+	})
+	it.defineMethod(proto, "every", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return iterate(it, this, args, "every", func(_ int, _, r jsValue) bool { return !it.toBoolean(r) }) < 0
+	})
+	it.defineMethod(proto, "find", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var found jsValue = jsUndefined
+		iterate(it, this, args, "find", func(_ int, v, r jsValue) bool {
+			if it.toBoolean(r) {
+				found = v
+				return true
+			}
+			return false
+		})
+		return found
+	})
+	it.defineMethod(proto, "findIndex", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return float64(iterate(it, this, args, "findIndex", func(_ int, _, r jsValue) bool { return it.toBoolean(r) }))
+	})
+	reduce := func(right bool) jsNativeFunc {
+		return func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			a := it.thisArray(this, "reduce")
+			fn := it.argFunction(args, 0)
+			n := len(a.array)
+			idx := make([]int, n)
+			for i := range idx {
+				idx[i] = i
+				if right {
+					idx[i] = n - 1 - i
+				}
+			}
+			var acc jsValue
+			if len(args) > 1 {
+				acc = args[1]
+			} else {
+				if n == 0 {
+					it.throwError("TypeError", "Reduce of empty array with no initial value")
+				}
+				acc = a.array[idx[0]]
+				idx = idx[1:]
+			}
+			for _, i := range idx {
+				if i < len(a.array) {
+					acc = it.call(fn, jsUndefined, []jsValue{acc, a.array[i], float64(i), a})
+				}
+			}
+			return acc
+		}
+	}
+	it.defineMethod(proto, "reduce", reduce(false))
+	it.defineMethod(proto, "reduceRight", reduce(true))
+	it.defineMethod(proto, "sort", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		a := it.thisArray(this, "sort")
+		cmp, _ := argument(args, 0).(*jsObject)
+		sort.SliceStable(a.array, func(i, j int) bool {
+			it.tick()
+			x, y := a.array[i], a.array[j]
+			if x == jsUndefined || y == jsUndefined {
+				return y == jsUndefined && x != jsUndefined
+			}
+			if cmp != nil && cmp.callable() {
+				return it.toNumber(it.call(cmp, jsUndefined, []jsValue{x, y})) < 0
+			}
+			return it.toString(x) < it.toString(y)
+		})
+		return a
+	})
+}
+
+// String
+
+// thisString returns the string a method was called on
+func (it *jsInterp) thisString(this jsValue) string {
+	if o, ok := this.(*jsObject); ok && o.class == "String" {
+		if s, ok := o.value.(string); ok {
+			return s
+		}
+	}
+	if isNullish(this) {
+		it.throwError("TypeError", "String.prototype method called on null or undefined")
+	}
+	return it.toString(this)
+}
+
+func (it *jsInterp) setupString() {
+	it.defineConstructor("String", it.stringProto,
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			if len(args) == 0 {
+				return ""
+			}
+			return it.toString(args[0])
+		},
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			s := ""
+			if len(args) > 0 {
+				s = it.toString(args[0])
+			}
+			return it.toObject(s)
+		})
+	ctor := it.getProp(it.global, "String").(*jsObject)
+	it.defineMethod(ctor, "fromCharCode", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		units := make([]uint16, len(args))
+		for i, a := range args {
+			units[i] = uint16(it.toUint32(a))
+		}
+		return jsFromUTF16(units)
+	})
+
+	proto := it.stringProto
+	it.defineMethod(proto, "toString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.thisString(this)
+	})
+	it.defineMethod(proto, "valueOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.thisString(this)
+	})
+	it.defineMethod(proto, "charAt", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		i := it.toInteger(argument(args, 0))
+		if i < 0 || i >= float64(len(r)) {
+			return ""
+		}
+		return string(r[int(i)])
+	})
+	it.defineMethod(proto, "charCodeAt", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		i := it.toInteger(argument(args, 0))
+		if i < 0 || i >= float64(len(r)) {
+			return math.NaN()
+		}
+		return float64(r[int(i)])
+	})
+	it.defineMethod(proto, "indexOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		sub := []rune(it.argString(args, 0))
+		from := jsRelativeIndex(math.Max(0, it.toInteger(argument(args, 1))), len(r))
+		return float64(it.runeIndex(r, sub, from))
+	})
+	it.defineMethod(proto, "lastIndexOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		sub := []rune(it.argString(args, 0))
+		for i := len(r) - len(sub); i >= 0; i-- {
+			it.tick()
+			if string(r[i:i+len(sub)]) == string(sub) {
+				return float64(i)
+			}
+		}
+		return float64(-1)
+	})
+	it.defineMethod(proto, "includes", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return strings.Contains(it.thisString(this), it.argString(args, 0))
+	})
+	it.defineMethod(proto, "startsWith", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		from := jsRelativeIndex(math.Max(0, it.toInteger(argument(args, 1))), len(r))
+		return strings.HasPrefix(string(r[from:]), it.argString(args, 0))
+	})
+	it.defineMethod(proto, "endsWith", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		end := len(r)
+		if v := argument(args, 1); v != jsUndefined {
+			end = jsRelativeIndex(math.Max(0, it.toInteger(v)), len(r))
+		}
+		return strings.HasSuffix(string(r[:end]), it.argString(args, 0))
+	})
+	it.defineMethod(proto, "slice", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		start := jsRelativeIndex(it.toInteger(argument(args, 0)), len(r))
+		end := len(r)
+		if v := argument(args, 1); v != jsUndefined {
+			end = jsRelativeIndex(it.toInteger(v), len(r))
+		}
+		if start >= end {
+			return ""
+		}
+		return string(r[start:end])
+	})
+	it.defineMethod(proto, "substring", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		clamp := func(v jsValue, def int) int {
+			if v == jsUndefined {
+				return def
+			}
+			return int(math.Max(0, math.Min(it.toInteger(v), float64(len(r)))))
+		}
+		start, end := clamp(argument(args, 0), 0), clamp(argument(args, 1), len(r))
+		if start > end {
+			start, end = end, start
+		}
+		return string(r[start:end])
+	})
+	it.defineMethod(proto, "substr", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		r := []rune(it.thisString(this))
+		start := jsRelativeIndex(it.toInteger(argument(args, 0)), len(r))
+		n := len(r) - start
+		if v := argument(args, 1); v != jsUndefined {
+			n = int(math.Max(0, math.Min(it.toInteger(v), float64(n))))
+		}
+		return string(r[start : start+n])
+	})
+	it.defineMethod(proto, "toUpperCase", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return strings.ToUpper(it.thisString(this))
+	})
+	it.defineMethod(proto, "toLowerCase", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return strings.ToLower(it.thisString(this))
+	})
+	it.define(proto, "toLocaleUpperCase", it.getProp(proto, "toUpperCase"))
+	it.define(proto, "toLocaleLowerCase", it.getProp(proto, "toLowerCase"))
+	it.defineMethod(proto, "trim", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return strings.TrimSpace(it.thisString(this))
+	})
+	it.defineMethod(proto, "trimStart", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return strings.TrimLeft(it.thisString(this), jsWhitespace)
+	})
+	it.defineMethod(proto, "trimEnd", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return strings.TrimRight(it.thisString(this), jsWhitespace)
+	})
+	pad := func(start bool) jsNativeFunc {
+		return func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			s := it.thisString(this)
+			n := int(it.toInteger(argument(args, 0)))
+			filler := " "
+			if v := argument(args, 1); v != jsUndefined {
+				filler = it.toString(v)
+			}
+			missing := n - utf8.RuneCountInString(s)
+			if missing <= 0 || filler == "" {
+				return s
+			}
+			it.alloc(missing)
+			fill := []rune(strings.Repeat(filler, missing/utf8.RuneCountInString(filler)+1))[:missing]
+			if start {
+				return string(fill) + s
+			}
+			return s + string(fill)
+		}
+	}
+	it.defineMethod(proto, "padStart", pad(true))
+	it.defineMethod(proto, "padEnd", pad(false))
+	it.defineMethod(proto, "repeat", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		s := it.thisString(this)
+		n := it.toInteger(argument(args, 0))
+		if n < 0 || math.IsInf(n, 0) {
+			it.throwError("RangeError", "Invalid count value")
+		}
+		it.alloc(len(s) * int(math.Min(n, 1<<31)))
+		return strings.Repeat(s, int(n))
+	})
+	it.defineMethod(proto, "concat", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		var sb strings.Builder
+		sb.WriteString(it.thisString(this))
+		for _, a := range args {
+			it.tick()
+			str := it.toString(a)
+			it.alloc(len(str))
+			sb.WriteString(str)
+		}
+		return sb.String()
+	})
+	it.defineMethod(proto, "localeCompare", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return float64(strings.Compare(it.thisString(this), it.argString(args, 0)))
+	})
+	it.defineMethod(proto, "split", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		s := it.thisString(this)
+		limit := -1
+		if v := argument(args, 1); v != jsUndefined {
+			limit = int(it.toUint32(v))
+		}
+		out := []jsValue{}
+		emit := func(p string) bool {
+			if limit >= 0 && len(out) >= limit {
+				return false
+			}
+			out = it.appendValue(out, p, len(p))
+			return true
+		}
+		switch sep := argument(args, 0).(type) {
+		case jsUndefinedType:
+			emit(s)
+		case *jsObject:
+			if sep.re != nil {
+				it.regExpSplit(sep.re, s, emit)
+				break
+			}
+			jsSplitString(s, it.toString(sep), emit)
+		default:
+			jsSplitString(s, it.toString(sep), emit)
+		}
+		return it.arrayOf(out)
+	})
+	it.defineMethod(proto, "replace", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.stringReplace(it.thisString(this), argument(args, 0), argument(args, 1), false)
+	})
+	it.defineMethod(proto, "replaceAll", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.stringReplace(it.thisString(this), argument(args, 0), argument(args, 1), true)
+	})
+	it.defineMethod(proto, "match", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		s := it.thisString(this)
+		re := it.toRegExp(argument(args, 0))
+		if !strings.Contains(it.toString(it.getProp(re, "flags")), "g") {
+			return it.regExpExec(re, s)
+		}
+		matches := it.regExpFindAll(re.re, s)
+		if len(matches) == 0 {
+			return jsNull
+		}
+		out := make([]jsValue, 0, len(matches))
+		for _, m := range matches {
+			out = it.appendValue(out, s[m[0]:m[1]], m[1]-m[0])
+		}
+		return it.arrayOf(out)
+	})
+	it.defineMethod(proto, "search", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		s := it.thisString(this)
+		loc := it.toRegExp(argument(args, 0)).re.FindStringIndex(s)
+		if loc == nil {
+			return float64(-1)
+		}
+		return float64(utf8.RuneCountInString(s[:loc[0]]))
+	})
+}
+
+// jsFromUTF16 decodes UTF-16 code units, combining surrogate pairs
+func jsFromUTF16(units []uint16) string {
+	var sb strings.Builder
+	for i := 0; i < len(units); i++ {
+		u := rune(units[i])
+		if u >= 0xD800 && u < 0xDC00 && i+1 < len(units) && units[i+1] >= 0xDC00 && units[i+1] < 0xE000 {
+			u = (u-0xD800)<<10 + (rune(units[i+1]) - 0xDC00) + 0x10000
+			i++
+		}
+		sb.WriteRune(u)
+	}
+	return sb.String()
+}
+
+// runeIndex finds sub in r starting at from
+func (it *jsInterp) runeIndex(r, sub []rune, from int) int {
+	for i := from; i+len(sub) <= len(r); i++ {
+		it.tick()
+		if string(r[i:i+len(sub)]) == string(sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// jsSplitString splits like String.prototype.split with a string
+// separator, passing each part to emit until it returns false
+func jsSplitString(s, sep string, emit func(string) bool) {
+	if sep == "" {
+		for _, r := range s {
+			if !emit(string(r)) {
+				return
+			}
+		}
+		return
+	}
+	for {
+		i := strings.Index(s, sep)
+		if i < 0 {
+			emit(s)
+			return
+		}
+		if !emit(s[:i]) {
+			return
+		}
+		s = s[i+len(sep):]
+	}
+}
+
+// regExpSplit splits a string at regular expression matches, passing each
+// part and captured group to emit until it returns false
+func (it *jsInterp) regExpSplit(re *regexp.Regexp, s string, emit func(string) bool) {
+	if s == "" {
+		if !re.MatchString(s) {
+			emit(s)
+		}
+		return
+	}
+	last := 0
+	for _, m := range it.regExpFindAll(re, s) {
+		if m[1] == m[0] && (m[0] == 0 || m[0] == len(s)) {
+			continue
+		}
+		if !emit(s[last:m[0]]) {
+			return
+		}
+		for g := 2; g+1 < len(m); g += 2 {
+			part := ""
+			if m[g] >= 0 {
+				part = s[m[g]:m[g+1]]
+			}
+			if !emit(part) {
+				return
+			}
+		}
+		last = m[1]
+	}
+	emit(s[last:])
+}
+
+// stringReplace implements replace and replaceAll
+func (it *jsInterp) stringReplace(s string, pattern, replacement jsValue, all bool) jsValue {
+	var matches [][]int
+	if re, ok := pattern.(*jsObject); ok && re.re != nil {
+		if all || strings.Contains(it.toString(it.getProp(re, "flags")), "g") {
+			matches = it.regExpFindAll(re.re, s)
+		} else if m := re.re.FindStringSubmatchIndex(s); m != nil {
+			matches = [][]int{m}
+		}
+	} else {
+		needle := it.toString(pattern)
+		for from := 0; from <= len(s); {
+			i := strings.Index(s[from:], needle)
+			if i < 0 {
+				break
+			}
+			it.tick()
+			it.alloc(40)
+			matches = append(matches, []int{from + i, from + i + len(needle)})
+			if !all {
+				break
+			}
+			from += i + len(needle)
+			if needle == "" {
+				from++
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return s
+	}
+
+	fn, _ := replacement.(*jsObject)
+	if fn != nil && !fn.callable() {
+		fn = nil
+	}
+	template := ""
+	if fn == nil {
+		template = it.toString(replacement)
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		it.tick()
+		n := sb.Len()
+		sb.WriteString(s[last:m[0]])
+		if fn != nil {
+			args := []jsValue{s[m[0]:m[1]]}
+			for g := 2; g+1 < len(m); g += 2 {
+				if m[g] >= 0 {
+					args = append(args, s[m[g]:m[g+1]])
+				} else {
+					args = append(args, jsUndefined)
+				}
+			}
+			args = append(args, float64(utf8.RuneCountInString(s[:m[0]])), s)
+			sb.WriteString(it.toString(it.call(fn, jsUndefined, args)))
+		} else {
+			sb.WriteString(jsExpandReplacement(template, s, m))
+		}
+		last = m[1]
+		it.alloc(sb.Len() - n)
+	}
+	it.alloc(len(s) - last)
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// jsExpandReplacement expands $ patterns in a replacement string
+func jsExpandReplacement(template, s string, m []int) string {
+	if !strings.Contains(template, "$") {
+		return template
+	}
+	var sb strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '$' || i+1 >= len(template) {
+			sb.WriteByte(c)
+			continue
+		}
+		next := template[i+1]
+		switch {
+		case next == '$':
+			sb.WriteByte('$')
+			i++
+		case next == '&':
+			sb.WriteString(s[m[0]:m[1]])
+			i++
+		case next == '`':
+			sb.WriteString(s[:m[0]])
+			i++
+		case next == '\'':
+			sb.WriteString(s[m[1]:])
+			i++
+		case next >= '0' && next <= '9':
+			n := int(next - '0')
+			width := 1
+			if i+2 < len(template) && template[i+2] >= '0' && template[i+2] <= '9' {
+				if two := n*10 + int(template[i+2]-'0'); two > 0 && 2*two+1 < len(m) {
+					n, width = two, 2
+				}
+			}
+			if n > 0 && 2*n+1 < len(m) {
+				if m[2*n] >= 0 {
+					sb.WriteString(s[m[2*n]:m[2*n+1]])
+				}
+				i += width
+			} else {
+				sb.WriteByte(c)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// Number and Boolean
+
+// thisNumber returns the number a method was called on
+func (it *jsInterp) thisNumber(this jsValue) float64 {
+	switch x := this.(type) {
+	case float64:
+		return x
+	case *jsObject:
+		if f, ok := x.value.(float64); ok && x.class == "Number" {
+			return f
+		}
+	}
+	it.throwError("TypeError", "Number.prototype method called on incompatible receiver")
+	return 0
+}
+
+// jsFormatFixed formats a number with a fixed count of decimals, rounding
+// ties away from zero like Number.prototype.toFixed
+func jsFormatFixed(x float64, digits int) string {
+	if math.IsNaN(x) || math.IsInf(x, 0) || math.Abs(x) >= 1e21 {
+		return jsNumberToString(x)
+	}
+	neg := x < 0
+	// The exact decimal expansion of a double has at most 1074 fraction digits
+	exact := new(big.Float).SetFloat64(math.Abs(x)).Text('f', 1100)
+	intPart, frac, _ := strings.Cut(exact, ".")
+	frac += strings.Repeat("0", digits+1)
+	digitsStr := []byte(intPart + frac[:digits])
+	if frac[digits] >= '5' {
+		i := len(digitsStr) - 1
+		for ; i >= 0; i-- {
+			if digitsStr[i] == '9' {
+				digitsStr[i] = '0'
+				continue
+			}
+			digitsStr[i]++
+			break
+		}
+		if i < 0 {
+			digitsStr = append([]byte{'1'}, digitsStr...)
+		}
+	}
+	s := string(digitsStr)
+	intLen := len(s) - digits
+	out := s[:intLen]
+	if digits > 0 {
+		out += "." + s[intLen:]
+	}
+	if neg && strings.Trim(out, "0.") != "" {
+		out = "-" + out
+	}
+	return out
+}
+
+func (it *jsInterp) setupNumber() {
+	ctor := it.defineConstructor("Number", it.numberProto,
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			if len(args) == 0 {
+				return float64(0)
+			}
+			return it.toNumber(args[0])
+		},
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			n := float64(0)
+			if len(args) > 0 {
+				n = it.toNumber(args[0])
+			}
+			return it.toObject(n)
+		})
+	it.define(ctor, "MAX_VALUE", math.MaxFloat64)
+	it.define(ctor, "MIN_VALUE", 5e-324)
+	it.define(ctor, "POSITIVE_INFINITY", math.Inf(1))
+	it.define(ctor, "NEGATIVE_INFINITY", math.Inf(-1))
+	it.define(ctor, "NaN", math.NaN())
+	it.define(ctor, "EPSILON", math.Nextafter(1, 2)-1)
+	it.define(ctor, "MAX_SAFE_INTEGER", float64(1<<53-1))
+	it.define(ctor, "MIN_SAFE_INTEGER", -float64(1<<53-1))
+	it.defineMethod(ctor, "isNaN", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		f, ok := argument(args, 0).(float64)
+		return ok && math.IsNaN(f)
+	})
+	it.defineMethod(ctor, "isFinite", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		f, ok := argument(args, 0).(float64)
+		return ok && !math.IsNaN(f) && !math.IsInf(f, 0)
+	})
+	it.defineMethod(ctor, "isInteger", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		f, ok := argument(args, 0).(float64)
+		return ok && !math.IsInf(f, 0) && f == math.Trunc(f)
+	})
+
+	proto := it.numberProto
+	it.defineMethod(proto, "valueOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.thisNumber(this)
+	})
+	it.defineMethod(proto, "toString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		x := it.thisNumber(this)
+		radix := 10
+		if v := argument(args, 0); v != jsUndefined {
+			radix = int(it.toInteger(v))
+			if radix < 2 || radix > 36 {
+				it.throwError("RangeError", "toString() radix must be between 2 and 36")
+			}
+		}
+		if radix == 10 || math.IsNaN(x) || math.IsInf(x, 0) {
+			return jsNumberToString(x)
+		}
+		return jsFormatRadix(x, radix)
+	})
+	it.defineMethod(proto, "toLocaleString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return jsNumberToString(it.thisNumber(this))
+	})
+	it.defineMethod(proto, "toFixed", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		digits := it.toInteger(argument(args, 0))
+		if digits < 0 || digits > 100 {
+			it.throwError("RangeError", "toFixed() digits argument must be between 0 and 100")
+		}
+		return jsFormatFixed(it.thisNumber(this), int(digits))
+	})
+	it.defineMethod(proto, "toPrecision", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		x := it.thisNumber(this)
+		if argument(args, 0) == jsUndefined || math.IsNaN(x) || math.IsInf(x, 0) {
+			return jsNumberToString(x)
+		}
+		p := int(it.toInteger(argument(args, 0)))
+		if p < 1 || p > 100 {
+			it.throwError("RangeError", "toPrecision() argument must be between 1 and 100")
+		}
+		if x == 0 {
+			return jsFormatFixed(0, p-1)
+		}
+		e := int(math.Floor(math.Log10(math.Abs(x))))
+		// Rounding can carry into the next power of ten
+		if s := strconv.FormatFloat(x, 'e', p-1, 64); s != "" {
+			_, exp, _ := strings.Cut(s, "e")
+			e, _ = strconv.Atoi(exp)
+		}
+		if e < -6 || e >= p {
+			s := strconv.FormatFloat(x, 'e', p-1, 64)
+			mant, exp, _ := strings.Cut(s, "e")
+			n, _ := strconv.Atoi(exp)
+			sign := "+"
+			if n < 0 {
+				sign = "-"
+				n = -n
+			}
+			return mant + "e" + sign + strconv.Itoa(n)
+		}
+		return jsFormatFixed(x, p-1-e)
+	})
+}
+
+// jsFormatRadix formats a number in a base other than ten
+func jsFormatRadix(x float64, radix int) string {
+	neg := x < 0
+	x = math.Abs(x)
+	ip := math.Floor(x)
+	var s string
+	if ip < 1<<63 {
+		s = strconv.FormatUint(uint64(ip), radix)
+	} else {
+		bi, _ := new(big.Float).SetFloat64(ip).Int(nil)
+		s = bi.Text(radix)
+	}
+	if frac := x - ip; frac > 0 {
+		var sb strings.Builder
+		sb.WriteByte('.')
+		for i := 0; i < 52 && frac > 0; i++ {
+			frac *= float64(radix)
+			d := int(frac)
+			sb.WriteByte("0123456789abcdefghijklmnopqrstuvwxyz"[d])
+			frac -= float64(d)
+		}
+		s += sb.String()
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+func (it *jsInterp) setupBoolean() {
+	it.defineConstructor("Boolean", it.booleanProto,
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			return it.toBoolean(argument(args, 0))
+		},
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			return it.toObject(it.toBoolean(argument(args, 0)))
+		})
+	thisBool := func(it *jsInterp, this jsValue) bool {
+		switch x := this.(type) {
+		case bool:
+			return x
+		case *jsObject:
+			if b, ok := x.value.(bool); ok && x.class == "Boolean" {
+				return b
+			}
+		}
+		it.throwError("TypeError", "Boolean.prototype method called on incompatible receiver")
+		return false
+	}
+	it.defineMethod(it.booleanProto, "valueOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return thisBool(it, this)
+	})
+	it.defineMethod(it.booleanProto, "toString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.toString(thisBool(it, this))
+	})
+}
+
+// Math
+
+func (it *jsInterp) setupMath() {
+	m := it.newObject()
+	m.class = "Math"
+	it.define(it.global, "Math", m)
+
+	consts := map[string]float64{
+		"PI": math.Pi, "E": math.E, "LN2": math.Ln2, "LN10": math.Ln10,
+		"LOG2E": math.Log2E, "LOG10E": math.Log10E, "SQRT2": math.Sqrt2, "SQRT1_2": math.Sqrt2 / 2,
+	}
+	for name, v := range consts {
+		it.define(m, name, v)
+	}
+
+	unary := map[string]func(float64) float64{
+		"abs": math.Abs, "ceil": math.Ceil, "floor": math.Floor, "trunc": math.Trunc,
+		"sqrt": math.Sqrt, "cbrt": math.Cbrt, "exp": math.Exp, "log": math.Log,
+		"log10": math.Log10, "log2": math.Log2, "sin": math.Sin, "cos": math.Cos,
+		"tan": math.Tan, "asin": math.Asin, "acos": math.Acos, "atan": math.Atan,
+		"round": func(x float64) float64 {
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				return x
+			}
+			return math.Floor(x + 0.5)
+		},
+		"sign": func(x float64) float64 {
+			switch {
+			case x > 0:
+				return 1
+			case x < 0:
+				return -1
+			}
+			return x
+		},
+	}
+	for name, f := range unary {
+		f := f
+		it.defineMethod(m, name, func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			return f(it.argNumber(args, 0))
+		})
+	}
+
+	it.defineMethod(m, "pow", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return math.Pow(it.argNumber(args, 0), it.argNumber(args, 1))
+	})
+	it.defineMethod(m, "atan2", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return math.Atan2(it.argNumber(args, 0), it.argNumber(args, 1))
+	})
+	it.defineMethod(m, "random", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return rand.Float64()
+	})
+	it.defineMethod(m, "hypot", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		sum := 0.0
+		for _, a := range args {
+			v := it.toNumber(a)
+			sum += v * v
+		}
+		return math.Sqrt(sum)
+	})
+	extreme := func(max bool) jsNativeFunc {
+		return func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			r := math.Inf(1)
+			if max {
+				r = math.Inf(-1)
+			}
+			for _, a := range args {
+				v := it.toNumber(a)
+				if math.IsNaN(v) {
+					return math.NaN()
+				}
+				if max && v > r || !max && v < r {
+					r = v
+				}
+			}
+			return r
+		}
+	}
+	it.defineMethod(m, "max", extreme(true))
+	it.defineMethod(m, "min", extreme(false))
+}
+
+// Errors
+
+func (it *jsInterp) setupErrors() {
+	it.define(it.errorProto, "name", "Error")
+	it.define(it.errorProto, "message", "")
+	it.defineMethod(it.errorProto, "toString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		o, ok := this.(*jsObject)
+		if !ok {
+			it.throwError("TypeError", "Error.prototype.toString called on non-object")
+		}
+		name := it.toString(it.getProp(o, "name"))
+		msg := it.toString(it.getProp(o, "message"))
+		switch {
+		case msg == "":
+			return name
+		case name == "":
+			return msg
+		}
+		return name + ": " + msg
+	})
+
+	for _, kind := range []string{"Error", "TypeError", "RangeError", "SyntaxError", "ReferenceError", "EvalError", "URIError"} {
+		proto := it.errorProto
+		if kind != "Error" {
+			proto = it.newObjectWithProto("Object", it.errorProto)
+			it.define(proto, "name", kind)
+		}
+		it.errorProtos[kind] = proto
+		kind := kind
+		create := func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			msg := ""
+			if v := argument(args, 0); v != jsUndefined {
+				msg = it.toString(v)
+			}
+			return it.newError(kind, msg)
+		}
+		it.defineConstructor(kind, proto, create, create)
+	}
+}
+
+// Date
+
+// thisDate returns the time value of a Date receiver
+func (it *jsInterp) thisDate(this jsValue) *jsObject {
+	o, ok := this.(*jsObject)
+	if !ok || o.class != "Date" {
+		it.throwError("TypeError", "this is not a Date object")
+	}
+	return o
+}
+
+// newDate creates a Date object for a time value in milliseconds
+func (it *jsInterp) newDate(ms float64) *jsObject {
+	o := it.newObjectWithProto("Date", it.dateProto)
+	o.value = jsTimeClip(ms)
+	return o
+}
+
+// jsTimeClip limits a time value to the range of valid dates
+func jsTimeClip(ms float64) float64 {
+	if math.IsNaN(ms) || math.Abs(ms) > 8.64e15 {
+		return math.NaN()
+	}
+	return math.Trunc(ms)
+}
+
+// jsTime converts a time value to a Go time
+func jsTime(ms float64, loc *time.Location) time.Time {
+	return time.UnixMilli(int64(ms)).In(loc)
+}
+
+// jsMakeTime builds a time value from calendar fields, normalising
+// overflowing fields like MakeDate
+func jsMakeTime(f [7]float64, loc *time.Location) float64 {
+	for _, v := range f {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return math.NaN()
+		}
+	}
+	t := time.Date(int(f[0]), time.Month(int(f[1])+1), int(f[2]), int(f[3]), int(f[4]), int(f[5]), 0, loc)
+	return float64(t.UnixMilli()) + math.Trunc(f[6])
+}
+
+// jsTimeFields splits a time value into calendar fields
+func jsTimeFields(ms float64, loc *time.Location) [7]float64 {
+	t := jsTime(ms, loc)
+	return [7]float64{
+		float64(t.Year()), float64(t.Month() - 1), float64(t.Day()),
+		float64(t.Hour()), float64(t.Minute()), float64(t.Second()),
+		float64(t.Nanosecond() / int(time.Millisecond)),
+	}
+}
+
+// jsDateLayouts are the layouts accepted by Date.parse, with whether they
+// denote local time
+var jsDateLayouts = []struct {
+	layout string
+	local  bool
+}{
+	{time.RFC3339Nano, false},
+	{"2006-01-02T15:04:05.000Z07:00", false},
+	{"2006-01-02T15:04Z07:00", false},
+	{"2006-01-02", false},
+	{"2006-01", false},
+	{"2006-01-02T15:04:05.000", true},
+	{"2006-01-02T15:04:05", true},
+	{"2006-01-02T15:04", true},
+	{"Mon Jan 02 2006 15:04:05 GMT-0700", false},
+	{"Mon Jan 02 2006", true},
+	{"Mon, 02 Jan 2006 15:04:05 GMT", false},
+	{time.RFC1123Z, false},
+	{"Jan 2, 2006 15:04:05", true},
+	{"Jan 2, 2006", true},
+	{"January 2, 2006", true},
+	{"2 January 2006", true},
+	{"1/2/2006 15:04:05", true},
+	{"1/2/2006", true},
+	{"2006/1/2 15:04:05", true},
+	{"2006/1/2", true},
+}
+
+// jsParseDate implements Date.parse
+func jsParseDate(s string) float64 {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, " ("); i > 0 {
+		s = s[:i]
+	}
+	for _, l := range jsDateLayouts {
+		loc := time.UTC
+		if l.local {
+			loc = time.Local
+		}
+		if t, err := time.ParseInLocation(l.layout, s, loc); err == nil {
+			return float64(t.UnixMilli())
+		}
+	}
+	return math.NaN()
+}
+
+func (it *jsInterp) setupDate() {
+	ctor := it.defineConstructor("Date", it.dateProto,
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			return jsTime(float64(time.Now().UnixMilli()), time.Local).Format("Mon Jan 02 2006 15:04:05 GMT-0700")
+		},
+		func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			switch len(args) {
+			case 0:
+				return it.newDate(float64(time.Now().UnixMilli()))
+			case 1:
+				if d, ok := args[0].(*jsObject); ok && d.class == "Date" {
+					return it.newDate(d.value.(float64))
+				}
+				v := it.toPrimitive(args[0], "")
+				if s, ok := v.(string); ok {
+					return it.newDate(jsParseDate(s))
+				}
+				return it.newDate(it.toNumber(v))
+			}
+			return it.newDate(jsMakeTime(it.dateFields(args), time.Local))
+		})
+
+	it.defineMethod(ctor, "now", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return float64(time.Now().UnixMilli())
+	})
+	it.defineMethod(ctor, "parse", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return jsParseDate(it.argString(args, 0))
+	})
+	it.defineMethod(ctor, "UTC", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return jsTimeClip(jsMakeTime(it.dateFields(args), time.UTC))
+	})
+
+	proto := it.dateProto
+	value := func(it *jsInterp, this jsValue) float64 {
+		return it.thisDate(this).value.(float64)
+	}
+	it.defineMethod(proto, "getTime", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return value(it, this)
+	})
+	it.defineMethod(proto, "valueOf", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return value(it, this)
+	})
+	it.defineMethod(proto, "getTimezoneOffset", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ms := value(it, this)
+		if math.IsNaN(ms) {
+			return ms
+		}
+		_, offset := jsTime(ms, time.Local).Zone()
+		return float64(-offset / 60)
+	})
+	it.defineMethod(proto, "getYear", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ms := value(it, this)
+		if math.IsNaN(ms) {
+			return ms
+		}
+		return jsTimeFields(ms, time.Local)[0] - 1900
+	})
+	it.defineMethod(proto, "getDay", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ms := value(it, this)
+		if math.IsNaN(ms) {
+			return ms
+		}
+		return float64(jsTime(ms, time.Local).Weekday())
+	})
+	it.defineMethod(proto, "getUTCDay", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ms := value(it, this)
+		if math.IsNaN(ms) {
+			return ms
+		}
+		return float64(jsTime(ms, time.UTC).Weekday())
+	})
+
+	fields := []string{"FullYear", "Month", "Date", "Hours", "Minutes", "Seconds", "Milliseconds"}
+	for i, name := range fields {
+		i := i
+		for _, utc := range []bool{false, true} {
+			loc := time.Local
+			prefix := ""
+			if utc {
+				loc = time.UTC
+				prefix = "UTC"
+			}
+			it.defineMethod(proto, "get"+prefix+name, func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+				ms := value(it, this)
+				if math.IsNaN(ms) {
+					return ms
+				}
+				return jsTimeFields(ms, loc)[i]
+			})
+			it.defineMethod(proto, "set"+prefix+name, func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+				d := it.thisDate(this)
+				ms := d.value.(float64)
+				if math.IsNaN(ms) {
+					ms = 0
+				}
+				f := jsTimeFields(ms, loc)
+				for j := 0; j < len(args) && i+j < len(f); j++ {
+					f[i+j] = it.toNumber(args[j])
+				}
+				d.value = jsTimeClip(jsMakeTime(f, loc))
+				return d.value
+			})
+		}
+	}
+	it.defineMethod(proto, "setTime", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		d := it.thisDate(this)
+		d.value = jsTimeClip(it.argNumber(args, 0))
+		return d.value
+	})
+
+	formats := map[string]struct {
+		layout string
+		loc    *time.Location
+	}{
+		"toString":           {"Mon Jan 02 2006 15:04:05 GMT-0700", time.Local},
+		"toDateString":       {"Mon Jan 02 2006", time.Local},
+		"toTimeString":       {"15:04:05 GMT-0700", time.Local},
+		"toUTCString":        {"Mon, 02 Jan 2006 15:04:05 GMT", time.UTC},
+		"toGMTString":        {"Mon, 02 Jan 2006 15:04:05 GMT", time.UTC},
+		"toLocaleString":     {"1/2/2006, 3:04:05 PM", time.Local},
+		"toLocaleDateString": {"1/2/2006", time.Local},
+		"toLocaleTimeString": {"3:04:05 PM", time.Local},
+	}
+	for name, f := range formats {
+		f := f
+		it.defineMethod(proto, name, func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+			ms := value(it, this)
+			if math.IsNaN(ms) {
+				return "Invalid Date"
+			}
+			return jsTime(ms, f.loc).Format(f.layout)
+		})
+	}
+	iso := func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		ms := value(it, this)
+		if math.IsNaN(ms) {
+			it.throwError("RangeError", "Invalid time value")
+		}
+		return jsTime(ms, time.UTC).Format("2006-01-02T15:04:05.000Z")
+	}
+	it.defineMethod(proto, "toISOString", iso)
+	it.defineMethod(proto, "toJSON", iso)
+}
+
+// dateFields converts Date constructor arguments to calendar fields
+func (it *jsInterp) dateFields(args []jsValue) [7]float64 {
+	f := [7]float64{0, 0, 1, 0, 0, 0, 0}
+	for i := 0; i < len(args) && i < 7; i++ {
+		f[i] = it.toInteger(args[i])
+		if n := it.toNumber(args[i]); math.IsNaN(n) {
+			f[i] = n
+		}
+	}
+	if f[0] >= 0 && f[0] <= 99 {
+		f[0] += 1900
+	}
+	return f
+}
+
+// RegExp
+
+// jsTranslateRegExp converts an ECMAScript pattern to RE2 syntax
+func jsTranslateRegExp(pattern, flags string) (string, error) {
+	var sb strings.Builder
+	prefix := ""
+	for _, f := range flags {
+		switch f {
+		case 'i':
+			prefix += "i"
+		case 'm':
+			prefix += "m"
+		case 's':
+			prefix += "s"
+		case 'g', 'y', 'u':
+		default:
+			return "", fmt.Errorf("invalid flags %q", flags)
+		}
+	}
+	if prefix != "" {
+		sb.WriteString("(?" + prefix + ")")
+	}
+
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			next := pattern[i+1]
+			switch {
+			case next == 'u':
+				if r, n := jsScanUnicodeEscape(pattern[i+2:]); n > 0 {
+					fmt.Fprintf(&sb, `\x{%X}`, r)
+					i += 1 + n
+					continue
+				}
+				sb.WriteString("u")
+			case next == '/':
+				sb.WriteByte('/')
+			case next == 'c' && i+2 < len(pattern):
+				fmt.Fprintf(&sb, `\x{%X}`, pattern[i+2]%32)
+				i++
+			case next >= '1' && next <= '9' && !inClass:
+				return "", fmt.Errorf("backreferences are not supported")
+			case next == '0':
+				sb.WriteString(`\x00`)
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(next)
+			}
+			i++
+		case c == '[' && !inClass:
+			if strings.HasPrefix(pattern[i:], "[^]") {
+				sb.WriteString(`[\s\S]`)
+				i += 2
+				continue
+			}
+			if strings.HasPrefix(pattern[i:], "[]") {
+				sb.WriteString(`[^\s\S]`)
+				i++
+				continue
+			}
+			inClass = true
+			sb.WriteByte(c)
+		case c == ']' && inClass:
+			inClass = false
+			sb.WriteByte(c)
+		case c == '(' && !inClass && strings.HasPrefix(pattern[i:], "(?") &&
+			(strings.HasPrefix(pattern[i:], "(?=") || strings.HasPrefix(pattern[i:], "(?!") ||
+				strings.HasPrefix(pattern[i:], "(?<=") || strings.HasPrefix(pattern[i:], "(?<!")):
+			return "", fmt.Errorf("lookaround assertions are not supported")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+// newRegExp creates a RegExp object
+func (it *jsInterp) newRegExp(pattern, flags string) *jsObject {
+	src, err := jsTranslateRegExp(pattern, flags)
+	if err != nil {
+		it.throwError("SyntaxError", "Invalid regular expression: /%s/: %v", pattern, err)
+	}
+	re, err := regexp.Compile(src)
+	if err != nil {
+		it.throwError("SyntaxError", "Invalid regular expression: /%s/: %v", pattern, err)
+	}
+	o := it.newObjectWithProto("RegExp", it.regexpProto)
+	o.re = re
+	it.define(o, "source", pattern)
+	it.define(o, "flags", flags)
+	it.define(o, "global", strings.Contains(flags, "g"))
+	it.define(o, "ignoreCase", strings.Contains(flags, "i"))
+	it.define(o, "multiline", strings.Contains(flags, "m"))
+	it.setProp(o, "lastIndex", float64(0))
+	o.hidden["lastIndex"] = true
+	return o
+}
+
+// toRegExp converts a value to a RegExp object
+func (it *jsInterp) toRegExp(v jsValue) *jsObject {
+	if o, ok := v.(*jsObject); ok && o.re != nil {
+		return o
+	}
+	if v == jsUndefined {
+		return it.newRegExp("", "")
+	}
+	return it.newRegExp(regexp.QuoteMeta(it.toString(v)), "")
+}
+
+// regExpExec implements RegExp.prototype.exec
+func (it *jsInterp) regExpExec(re *jsObject, s string) jsValue {
+	global := it.toBoolean(it.getProp(re, "global"))
+	start := 0
+	if global {
+		idx := int(it.toInteger(it.getProp(re, "lastIndex")))
+		if idx > utf8.RuneCountInString(s) || idx < 0 {
+			it.setProp(re, "lastIndex", float64(0))
+			return jsNull
+		}
+		start = len(string([]rune(s)[:idx]))
+	}
+
+	m := re.re.FindStringSubmatchIndex(s[start:])
+	if m == nil {
+		if global {
+			it.setProp(re, "lastIndex", float64(0))
+		}
+		return jsNull
+	}
+	groups := make([]jsValue, 0, len(m)/2)
+	for g := 0; g+1 < len(m); g += 2 {
+		if m[g] >= 0 {
+			groups = append(groups, s[start+m[g]:start+m[g+1]])
+		} else {
+			groups = append(groups, jsUndefined)
+		}
+	}
+	result := it.newArray(groups)
+	it.setProp(result, "index", float64(utf8.RuneCountInString(s[:start+m[0]])))
+	it.setProp(result, "input", s)
+	if global {
+		end := utf8.RuneCountInString(s[:start+m[1]])
+		if m[1] == m[0] {
+			end++
+		}
+		it.setProp(re, "lastIndex", float64(end))
+	}
+	return result
+}
+
+func (it *jsInterp) setupRegExp() {
+	create := func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		flags := ""
+		if v := argument(args, 1); v != jsUndefined {
+			flags = it.toString(v)
+		}
+		if src, ok := argument(args, 0).(*jsObject); ok && src.re != nil {
+			if argument(args, 1) == jsUndefined {
+				flags = it.toString(it.getProp(src, "flags"))
+			}
+			return it.newRegExp(it.toString(it.getProp(src, "source")), flags)
+		}
+		pattern := ""
+		if v := argument(args, 0); v != jsUndefined {
+			pattern = it.toString(v)
+		}
+		return it.newRegExp(pattern, flags)
+	}
+	it.defineConstructor("RegExp", it.regexpProto, create, create)
+
+	thisRegExp := func(it *jsInterp, this jsValue) *jsObject {
+		o, ok := this.(*jsObject)
+		if !ok || o.re == nil {
+			it.throwError("TypeError", "RegExp method called on incompatible receiver")
+		}
+		return o
+	}
+	it.defineMethod(it.regexpProto, "exec", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.regExpExec(thisRegExp(it, this), it.argString(args, 0))
+	})
+	it.defineMethod(it.regexpProto, "test", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return it.regExpExec(thisRegExp(it, this), it.argString(args, 0)) != jsNull
+	})
+	it.defineMethod(it.regexpProto, "toString", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		re := thisRegExp(it, this)
+		return "/" + it.toString(it.getProp(re, "source")) + "/" + it.toString(it.getProp(re, "flags"))
+	})
+}
+
+// Global functions
+
+// jsParseInt implements parseInt
+func jsParseInt(s string, radix int) float64 {
+	s = strings.TrimSpace(s)
+	neg := false
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	if (radix == 0 || radix == 16) && len(s) > 1 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		s = s[2:]
+		radix = 16
+	}
+	if radix == 0 {
+		radix = 10
+	}
+	if radix < 2 || radix > 36 {
+		return math.NaN()
+	}
+	result := 0.0
+	n := 0
+	for ; n < len(s); n++ {
+		c := s[n]
+		var d int
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case c >= 'a' && c <= 'z':
+			d = int(c-'a') + 10
+		case c >= 'A' && c <= 'Z':
+			d = int(c-'A') + 10
+		default:
+			d = 99
+		}
+		if d >= radix {
+			break
+		}
+		result = result*float64(radix) + float64(d)
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	if neg {
+		return -result
+	}
+	return result
+}
+
+var jsFloatPrefix = regexp.MustCompile(`^[+-]?(Infinity|[0-9]+\.?[0-9]*([eE][+-]?[0-9]+)?|\.[0-9]+([eE][+-]?[0-9]+)?)`)
+
+// jsParseFloat implements parseFloat
+func jsParseFloat(s string) float64 {
+	m := jsFloatPrefix.FindString(strings.TrimSpace(s))
+	if m == "" {
+		return math.NaN()
+	}
+	return jsStringToNumber(m)
+}
+
+// jsWhitespace lists the characters removed by trimStart and trimEnd
+const jsWhitespace = " \t\n\r\f\v\u00a0\ufeff"
+
+// jsURIUnreserved lists characters encodeURIComponent leaves alone
+const jsURIUnreserved = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_.!~*'()"
+
+func (it *jsInterp) setupGlobalFunctions() {
+	g := it.global
+	it.defineMethod(g, "parseInt", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return jsParseInt(it.argString(args, 0), int(it.toInt32(argument(args, 1))))
+	})
+	it.defineMethod(g, "parseFloat", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return jsParseFloat(it.argString(args, 0))
+	})
+	it.defineMethod(g, "isNaN", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		return math.IsNaN(it.argNumber(args, 0))
+	})
+	it.defineMethod(g, "isFinite", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		v := it.argNumber(args, 0)
+		return !math.IsNaN(v) && !math.IsInf(v, 0)
+	})
+	numberCtor := it.getProp(g, "Number").(*jsObject)
+	it.define(numberCtor, "parseInt", it.getProp(g, "parseInt"))
+	it.define(numberCtor, "parseFloat", it.getProp(g, "parseFloat"))
+
+	it.defineMethod(g, "encodeURIComponent", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		s := it.argString(args, 0)
+		var sb strings.Builder
+		for i := 0; i < len(s); i++ {
+			if strings.IndexByte(jsURIUnreserved, s[i]) >= 0 {
+				sb.WriteByte(s[i])
+			} else {
+				fmt.Fprintf(&sb, "%%%02X", s[i])
+			}
+		}
+		return sb.String()
+	})
+	it.defineMethod(g, "decodeURIComponent", func(it *jsInterp, this jsValue, args []jsValue) jsValue {
+		s := it.argString(args, 0)
+		var out []byte
+		for i := 0; i < len(s); i++ {
+			if s[i] == '%' {
+				if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+					it.throwError("URIError", "URI malformed")
+				}
+				v, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+				out = append(out, byte(v))
+				i += 2
+				continue
+			}
+			out = append(out, s[i])
+		}
+		if !utf8.Valid(out) {
+			it.throwError("URIError", "URI malformed")
+		}
+		return string(out)
+	})
+}
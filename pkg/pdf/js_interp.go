@@ -0,0 +1,1802 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Errors returned when a script exceeds its resource limits
+var (
+	ErrScriptTimeout = errors.New("javascript: execution time limit exceeded")
+	ErrScriptMemory  = errors.New("javascript: memory limit exceeded")
+)
+
+// JSLimits bounds the resources a single script run may use
+type JSLimits struct {
+	Timeout   time.Duration // Maximum execution time (0 = unlimited)
+	MaxMemory int64         // Approximate number of bytes a run may allocate (0 = unlimited)
+	MaxDepth  int           // Maximum call stack depth (0 = default)
+}
+
+// DefaultJSLimits are the limits applied by NewJSEngine
+var DefaultJSLimits = JSLimits{
+	Timeout:   2 * time.Second,
+	MaxMemory: 64 << 20,
+	MaxDepth:  256,
+}
+
+// jsValue is a script value: jsUndefined, jsNull, bool, float64, string
+// or *jsObject
+type jsValue interface{}
+
+type jsUndefinedType struct{}
+type jsNullType struct{}
+
+var (
+	jsUndefined jsValue = jsUndefinedType{}
+	jsNull      jsValue = jsNullType{}
+)
+
+// jsNativeFunc implements a built-in function
+type jsNativeFunc func(it *jsInterp, this jsValue, args []jsValue) jsValue
+
+// jsHost supplies the dynamic properties of a host object. get reports
+// whether it handled the property; set likewise.
+type jsHost interface {
+	get(it *jsInterp, name string) (jsValue, bool)
+	set(it *jsInterp, name string, v jsValue) bool
+}
+
+// jsObject is a script object. Arrays, functions, dates, regular
+// expressions and host objects are objects with extra state.
+type jsObject struct {
+	class   string
+	proto   *jsObject
+	props   map[string]jsValue
+	keys    []string
+	hidden  map[string]bool // non-enumerable properties
+	getters map[string]*jsObject
+	setters map[string]*jsObject
+
+	array []jsValue // elements of an Array
+
+	native    jsNativeFunc // built-in function
+	construct jsNativeFunc // built-in constructor
+	fn        *jsFuncLit   // script function
+	scope     *jsScope     // closure scope
+	name      string
+
+	boundTarget *jsObject
+	boundThis   jsValue
+	boundArgs   []jsValue
+
+	value jsValue        // primitive value of Date, Number, String and Boolean objects
+	re    *regexp.Regexp // compiled RegExp
+	host  jsHost
+}
+
+// callable reports whether the object is a function
+func (o *jsObject) callable() bool {
+	return o.native != nil || o.fn != nil || o.boundTarget != nil || o.construct != nil
+}
+
+// jsException is a value thrown by a script
+type jsException struct {
+	value jsValue
+	msg   string
+}
+
+func (e *jsException) Error() string {
+	return e.msg
+}
+
+// jsFatal aborts execution regardless of try/catch
+type jsFatal struct {
+	err error
+}
+
+// jsScope is a lexical environment
+type jsScope struct {
+	vars     map[string]jsValue
+	consts   map[string]bool
+	parent   *jsScope
+	object   *jsObject // the global object, for the global scope
+	this     jsValue
+	hasThis  bool
+	function bool
+}
+
+func newJSScope(parent *jsScope, function bool) *jsScope {
+	return &jsScope{vars: make(map[string]jsValue), parent: parent, function: function}
+}
+
+// functionScope returns the nearest scope receiving var declarations
+func (s *jsScope) functionScope() *jsScope {
+	for s.parent != nil && !s.function && s.object == nil {
+		s = s.parent
+	}
+	return s
+}
+
+type jsCompletionType int
+
+const (
+	jsNormal jsCompletionType = iota
+	jsReturnCompletion
+	jsBreakCompletion
+	jsContinueCompletion
+)
+
+// jsCompletion is the result of executing a statement
+type jsCompletion struct {
+	typ   jsCompletionType
+	value jsValue
+	label string
+}
+
+// jsInterp is a sandboxed tree-walking interpreter. Scripts can only
+// reach the objects installed in its global object.
+type jsInterp struct {
+	global      *jsObject
+	globalScope *jsScope
+
+	objectProto   *jsObject
+	functionProto *jsObject
+	arrayProto    *jsObject
+	stringProto   *jsObject
+	numberProto   *jsObject
+	booleanProto  *jsObject
+	dateProto     *jsObject
+	regexpProto   *jsObject
+	errorProto    *jsObject
+	errorProtos   map[string]*jsObject
+
+	limits    JSLimits
+	deadline  time.Time
+	steps     int
+	allocated int64
+	depth     int
+	running   bool
+}
+
+// newJSInterp creates an interpreter with the standard built-ins
+func newJSInterp(limits JSLimits) *jsInterp {
+	it := &jsInterp{limits: limits, errorProtos: make(map[string]*jsObject)}
+	it.setupGlobals()
+	return it
+}
+
+// run parses and executes a script with the given this value
+func (it *jsInterp) run(src string, this jsValue) (result jsValue, err error) {
+	prog, err := jsParse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	// A run started from a native function shares the limits of the
+	// enclosing run
+	if !it.running {
+		it.begin()
+		it.running = true
+		defer func() { it.running = false }()
+	}
+
+	result = jsUndefined
+	err = it.protect(func() {
+		// Top-level let/const are private to the run, while var and
+		// function declarations become properties of the global object
+		scope := newJSScope(it.globalScope, false)
+		scope.this = this
+		scope.hasThis = true
+		for _, name := range prog.vars {
+			if !it.hasOwn(it.global, name) {
+				it.setProp(it.global, name, jsUndefined)
+			}
+		}
+		it.hoistFunctions(prog.body, scope)
+		for _, stmt := range prog.body {
+			c := it.exec(stmt, scope)
+			if c.typ != jsNormal {
+				break
+			}
+			if c.value != nil {
+				result = c.value
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// begin resets the resource counters for a new run
+func (it *jsInterp) begin() {
+	it.steps = 0
+	it.allocated = 0
+	it.depth = 0
+	it.deadline = time.Time{}
+	if it.limits.Timeout > 0 {
+		it.deadline = time.Now().Add(it.limits.Timeout)
+	}
+}
+
+// protect calls fn, converting thrown exceptions and limit violations
+// into errors
+func (it *jsInterp) protect(fn func()) (err error) {
+	depth := it.depth
+	defer func() {
+		if r := recover(); r != nil {
+			it.depth = depth
+			switch v := r.(type) {
+			case *jsException:
+				err = v
+			case jsFatal:
+				err = v.err
+			default:
+				err = fmt.Errorf("javascript: internal error: %v", r)
+			}
+		}
+	}()
+	fn()
+	return nil
+}
+
+// tick counts an evaluation step and enforces the time limit
+func (it *jsInterp) tick() {
+	it.steps++
+	if it.steps&1023 == 0 && !it.deadline.IsZero() && time.Now().After(it.deadline) {
+		panic(jsFatal{ErrScriptTimeout})
+	}
+}
+
+// alloc charges n bytes against the memory limit
+func (it *jsInterp) alloc(n int) {
+	it.allocated += int64(n)
+	if it.limits.MaxMemory > 0 && it.allocated > it.limits.MaxMemory {
+		panic(jsFatal{ErrScriptMemory})
+	}
+}
+
+// appendValue adds v to a result a native builtin is building, charging
+// its slot and size bytes of content as it goes
+func (it *jsInterp) appendValue(out []jsValue, v jsValue, size int) []jsValue {
+	it.tick()
+	it.alloc(16 + size)
+	return append(out, v)
+}
+
+// regExpFindAll finds the matches of re in s, asking for no more than the
+// memory limit leaves room for so the match list can't outgrow it
+func (it *jsInterp) regExpFindAll(re *regexp.Regexp, s string) [][]int {
+	cost := 24 + 16*(re.NumSubexp()+1)
+	n := -1
+	if it.limits.MaxMemory > 0 {
+		n = int(max(0, it.limits.MaxMemory-it.allocated)/int64(cost)) + 1
+	}
+	matches := re.FindAllStringSubmatchIndex(s, n)
+	for range matches {
+		it.tick()
+	}
+	it.alloc(cost * len(matches))
+	return matches
+}
+
+// Object helpers
+
+func (it *jsInterp) newObjectWithProto(class string, proto *jsObject) *jsObject {
+	it.alloc(64)
+	return &jsObject{class: class, proto: proto, props: make(map[string]jsValue)}
+}
+
+func (it *jsInterp) newObject() *jsObject {
+	return it.newObjectWithProto("Object", it.objectProto)
+}
+
+func (it *jsInterp) newArray(elems []jsValue) *jsObject {
+	it.alloc(16 * len(elems))
+	return it.arrayOf(elems)
+}
+
+// arrayOf wraps elements that were already charged as they were collected
+func (it *jsInterp) arrayOf(elems []jsValue) *jsObject {
+	o := it.newObjectWithProto("Array", it.arrayProto)
+	if elems == nil {
+		elems = []jsValue{}
+	}
+	o.array = elems
+	return o
+}
+
+// newNative creates a built-in function object
+func (it *jsInterp) newNative(name string, fn jsNativeFunc) *jsObject {
+	o := it.newObjectWithProto("Function", it.functionProto)
+	o.native = fn
+	o.name = name
+	return o
+}
+
+// newFunction creates a closure for a function literal
+func (it *jsInterp) newFunction(lit *jsFuncLit, scope *jsScope) *jsObject {
+	o := it.newObjectWithProto("Function", it.functionProto)
+	o.fn = lit
+	o.scope = scope
+	o.name = lit.name
+	return o
+}
+
+// define sets a non-enumerable property, used for built-ins
+func (it *jsInterp) define(o *jsObject, name string, v jsValue) {
+	if _, exists := o.props[name]; !exists {
+		o.keys = append(o.keys, name)
+	}
+	o.props[name] = v
+	if o.hidden == nil {
+		o.hidden = make(map[string]bool)
+	}
+	o.hidden[name] = true
+}
+
+// defineMethod adds a built-in method to an object
+func (it *jsInterp) defineMethod(o *jsObject, name string, fn jsNativeFunc) {
+	it.define(o, name, it.newNative(name, fn))
+}
+
+// jsArrayIndex parses an array index property key
+func jsArrayIndex(key string) (int, bool) {
+	if key == "" || len(key) > 10 || key[0] == '-' || key[0] == '+' || len(key) > 1 && key[0] == '0' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(key)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// hasOwn reports whether o has an own property
+func (it *jsInterp) hasOwn(o *jsObject, key string) bool {
+	if o.host != nil {
+		if _, ok := o.host.get(it, key); ok {
+			return true
+		}
+	}
+	if o.class == "Array" {
+		if key == "length" {
+			return true
+		}
+		if idx, ok := jsArrayIndex(key); ok {
+			return idx < len(o.array)
+		}
+	}
+	if _, ok := o.props[key]; ok {
+		return true
+	}
+	_, ok := o.getters[key]
+	return ok
+}
+
+// hasProperty reports whether key is found on o or its prototype chain
+func (it *jsInterp) hasProperty(o *jsObject, key string) bool {
+	for ; o != nil; o = o.proto {
+		if it.hasOwn(o, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// getProp reads a property of an object, walking the prototype chain
+func (it *jsInterp) getProp(o *jsObject, key string) jsValue {
+	return it.getPropThis(o, key, o)
+}
+
+func (it *jsInterp) getPropThis(o *jsObject, key string, this jsValue) jsValue {
+	for obj := o; obj != nil; obj = obj.proto {
+		if obj.host != nil {
+			if v, ok := obj.host.get(it, key); ok {
+				return v
+			}
+		}
+		switch obj.class {
+		case "Array":
+			if key == "length" {
+				return float64(len(obj.array))
+			}
+			if idx, ok := jsArrayIndex(key); ok {
+				if idx < len(obj.array) {
+					return obj.array[idx]
+				}
+				return jsUndefined
+			}
+		case "String":
+			if s, ok := obj.value.(string); ok {
+				if key == "length" {
+					return float64(len([]rune(s)))
+				}
+				if idx, ok := jsArrayIndex(key); ok {
+					if r := []rune(s); idx < len(r) {
+						return string(r[idx])
+					}
+				}
+			}
+		case "Function":
+			if obj == o {
+				switch key {
+				case "length":
+					if obj.fn != nil {
+						return float64(len(obj.fn.params))
+					}
+					if _, ok := obj.props["length"]; !ok {
+						return float64(0)
+					}
+				case "name":
+					if _, ok := obj.props["name"]; !ok {
+						return obj.name
+					}
+				case "prototype":
+					if _, ok := obj.props["prototype"]; !ok && obj.fn != nil && !obj.fn.arrow {
+						proto := it.newObject()
+						it.define(proto, "constructor", obj)
+						it.define(obj, "prototype", proto)
+					}
+				}
+			}
+		}
+		if g, ok := obj.getters[key]; ok {
+			return it.call(g, this, nil)
+		}
+		if v, ok := obj.props[key]; ok {
+			return v
+		}
+	}
+	return jsUndefined
+}
+
+// setProp writes a property of an object
+func (it *jsInterp) setProp(o *jsObject, key string, v jsValue) {
+	if o.host != nil && o.host.set(it, key, v) {
+		return
+	}
+	if o.class == "Array" {
+		if key == "length" {
+			n := it.toNumber(v)
+			if n < 0 || n != math.Floor(n) || n > 1<<32-1 {
+				it.throwError("RangeError", "Invalid array length")
+			}
+			it.setArrayLength(o, int(n))
+			return
+		}
+		if idx, ok := jsArrayIndex(key); ok {
+			if idx >= len(o.array) {
+				it.setArrayLength(o, idx+1)
+			}
+			o.array[idx] = v
+			return
+		}
+	}
+	for obj := o; obj != nil; obj = obj.proto {
+		if s, ok := obj.setters[key]; ok {
+			it.call(s, o, []jsValue{v})
+			return
+		}
+		if _, ok := obj.getters[key]; ok {
+			return
+		}
+	}
+	if _, exists := o.props[key]; !exists {
+		it.alloc(32 + len(key))
+		o.keys = append(o.keys, key)
+	}
+	o.props[key] = v
+}
+
+// setArrayLength truncates or extends an array
+func (it *jsInterp) setArrayLength(o *jsObject, n int) {
+	if n <= len(o.array) {
+		o.array = o.array[:n]
+		return
+	}
+	it.alloc(16 * (n - len(o.array)))
+	for len(o.array) < n {
+		o.array = append(o.array, jsUndefined)
+	}
+}
+
+// deleteProp removes an own property
+func (it *jsInterp) deleteProp(o *jsObject, key string) bool {
+	if o.class == "Array" {
+		if idx, ok := jsArrayIndex(key); ok {
+			if idx < len(o.array) {
+				o.array[idx] = jsUndefined
+			}
+			return true
+		}
+	}
+	if _, ok := o.props[key]; ok {
+		delete(o.props, key)
+		for i, k := range o.keys {
+			if k == key {
+				o.keys = append(o.keys[:i], o.keys[i+1:]...)
+				break
+			}
+		}
+	}
+	delete(o.getters, key)
+	delete(o.setters, key)
+	return true
+}
+
+// ownKeys returns the enumerable own property keys of an object
+func (it *jsInterp) ownKeys(o *jsObject) []string {
+	var keys []string
+	if o.class == "Array" {
+		for i := range o.array {
+			keys = append(keys, strconv.Itoa(i))
+		}
+	}
+	if s, ok := o.value.(string); ok && o.class == "String" {
+		for i := range []rune(s) {
+			keys = append(keys, strconv.Itoa(i))
+		}
+	}
+	if lister, ok := o.host.(interface{ keys() []string }); ok {
+		keys = append(keys, lister.keys()...)
+	}
+	for _, k := range o.keys {
+		if !o.hidden[k] {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// getMember reads a property of any value
+func (it *jsInterp) getMember(v jsValue, key string) jsValue {
+	switch x := v.(type) {
+	case *jsObject:
+		return it.getProp(x, key)
+	case string:
+		if key == "length" {
+			return float64(len([]rune(x)))
+		}
+		if idx, ok := jsArrayIndex(key); ok {
+			if r := []rune(x); idx < len(r) {
+				return string(r[idx])
+			}
+			return jsUndefined
+		}
+		return it.getPropThis(it.stringProto, key, v)
+	case float64:
+		return it.getPropThis(it.numberProto, key, v)
+	case bool:
+		return it.getPropThis(it.booleanProto, key, v)
+	}
+	it.throwError("TypeError", "Cannot read property '%s' of %s", key, it.toString(v))
+	return nil
+}
+
+// setMember writes a property of any value
+func (it *jsInterp) setMember(v jsValue, key string, val jsValue) {
+	switch x := v.(type) {
+	case *jsObject:
+		it.setProp(x, key, val)
+	case jsUndefinedType, jsNullType:
+		it.throwError("TypeError", "Cannot set property '%s' of %s", key, it.toString(v))
+	}
+	// Assignments to properties of primitives are ignored
+}
+
+// Errors
+
+// throwError throws a new error object of the given constructor name
+func (it *jsInterp) throwError(kind, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	panic(&jsException{value: it.newError(kind, msg), msg: kind + ": " + msg})
+}
+
+// newError creates an error object
+func (it *jsInterp) newError(kind, msg string) *jsObject {
+	proto := it.errorProtos[kind]
+	if proto == nil {
+		proto = it.errorProto
+	}
+	o := it.newObjectWithProto("Error", proto)
+	it.define(o, "message", msg)
+	return o
+}
+
+// throwValue throws an arbitrary script value
+func (it *jsInterp) throwValue(v jsValue) {
+	msg := "uncaught exception"
+	if o, ok := v.(*jsObject); ok && o.class == "Error" {
+		name := it.toString(it.getProp(o, "name"))
+		msg = name + ": " + it.toString(it.getProp(o, "message"))
+	} else if _, ok := v.(*jsObject); !ok {
+		msg = "uncaught exception: " + it.toString(v)
+	}
+	panic(&jsException{value: v, msg: msg})
+}
+
+// Conversions
+
+// jsTypeOf implements the typeof operator
+func jsTypeOf(v jsValue) string {
+	switch x := v.(type) {
+	case jsUndefinedType:
+		return "undefined"
+	case jsNullType:
+		return "object"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *jsObject:
+		if x.callable() {
+			return "function"
+		}
+	}
+	return "object"
+}
+
+func (it *jsInterp) toBoolean(v jsValue) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case float64:
+		return x != 0 && !math.IsNaN(x)
+	case string:
+		return x != ""
+	case *jsObject:
+		return true
+	}
+	return false
+}
+
+// toPrimitive converts an object to a primitive, preferring strings when
+// hint is "string"
+func (it *jsInterp) toPrimitive(v jsValue, hint string) jsValue {
+	o, ok := v.(*jsObject)
+	if !ok {
+		return v
+	}
+	if hint == "" {
+		hint = "number"
+		if o.class == "Date" {
+			hint = "string"
+		}
+	}
+	methods := []string{"valueOf", "toString"}
+	if hint == "string" {
+		methods = []string{"toString", "valueOf"}
+	}
+	for _, name := range methods {
+		if fn, ok := it.getProp(o, name).(*jsObject); ok && fn.callable() {
+			if r := it.call(fn, o, nil); !isJSObject(r) {
+				return r
+			}
+		}
+	}
+	it.throwError("TypeError", "Cannot convert object to primitive value")
+	return nil
+}
+
+func isJSObject(v jsValue) bool {
+	_, ok := v.(*jsObject)
+	return ok
+}
+
+func (it *jsInterp) toNumber(v jsValue) float64 {
+	switch x := v.(type) {
+	case float64:
+		return x
+	case bool:
+		if x {
+			return 1
+		}
+		return 0
+	case string:
+		return jsStringToNumber(x)
+	case jsNullType:
+		return 0
+	case *jsObject:
+		return it.toNumber(it.toPrimitive(x, "number"))
+	}
+	return math.NaN()
+}
+
+// jsStringToNumber converts a string using the StringToNumber grammar
+func jsStringToNumber(s string) float64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	switch s {
+	case "Infinity", "+Infinity":
+		return math.Inf(1)
+	case "-Infinity":
+		return math.Inf(-1)
+	}
+	if len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		if v, err := strconv.ParseUint(s[2:], 16, 64); err == nil {
+			return float64(v)
+		}
+		return math.NaN()
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-') {
+			return math.NaN()
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return v
+		}
+		return math.NaN()
+	}
+	return v
+}
+
+func (it *jsInterp) toString(v jsValue) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return jsNumberToString(x)
+	case bool:
+		if x {
+			return "true"
+		}
+		return "false"
+	case jsUndefinedType:
+		return "undefined"
+	case jsNullType:
+		return "null"
+	case *jsObject:
+		return it.toString(it.toPrimitive(x, "string"))
+	}
+	return ""
+}
+
+// jsNumberToString formats a number like Number.prototype.toString
+func jsNumberToString(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	}
+	abs := math.Abs(f)
+	if abs >= 1e21 || abs < 1e-6 {
+		s := strconv.FormatFloat(f, 'e', -1, 64)
+		mant, exp, _ := strings.Cut(s, "e")
+		sign := exp[0]
+		exp = strings.TrimLeft(exp[1:], "0")
+		return mant + "e" + string(sign) + exp
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func (it *jsInterp) toObject(v jsValue) *jsObject {
+	switch x := v.(type) {
+	case *jsObject:
+		return x
+	case string:
+		o := it.newObjectWithProto("String", it.stringProto)
+		o.value = x
+		return o
+	case float64:
+		o := it.newObjectWithProto("Number", it.numberProto)
+		o.value = x
+		return o
+	case bool:
+		o := it.newObjectWithProto("Boolean", it.booleanProto)
+		o.value = x
+		return o
+	}
+	it.throwError("TypeError", "Cannot convert %s to object", it.toString(v))
+	return nil
+}
+
+func (it *jsInterp) toInteger(v jsValue) float64 {
+	n := it.toNumber(v)
+	if math.IsNaN(n) {
+		return 0
+	}
+	if math.IsInf(n, 0) {
+		return n
+	}
+	return math.Trunc(n)
+}
+
+func (it *jsInterp) toInt32(v jsValue) int32 {
+	return int32(it.toUint32(v))
+}
+
+func (it *jsInterp) toUint32(v jsValue) uint32 {
+	n := it.toNumber(v)
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0
+	}
+	return uint32(int64(math.Mod(math.Trunc(n), 1<<32)))
+}
+
+// propertyKey converts a value to a property key
+func (it *jsInterp) propertyKey(v jsValue) string {
+	if f, ok := v.(float64); ok && f >= 0 && f == math.Floor(f) && f < 1e15 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return it.toString(v)
+}
+
+// Equality and comparison
+
+func jsStrictEquals(a, b jsValue) bool {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		return ok && x == y
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case bool:
+		y, ok := b.(bool)
+		return ok && x == y
+	case jsUndefinedType:
+		_, ok := b.(jsUndefinedType)
+		return ok
+	case jsNullType:
+		_, ok := b.(jsNullType)
+		return ok
+	case *jsObject:
+		y, ok := b.(*jsObject)
+		return ok && x == y
+	}
+	return false
+}
+
+func (it *jsInterp) looseEquals(a, b jsValue) bool {
+	isNullish := func(v jsValue) bool {
+		switch v.(type) {
+		case jsUndefinedType, jsNullType:
+			return true
+		}
+		return false
+	}
+	if isNullish(a) || isNullish(b) {
+		return isNullish(a) && isNullish(b)
+	}
+	switch x := a.(type) {
+	case float64:
+		switch y := b.(type) {
+		case float64:
+			return x == y
+		case string:
+			return x == jsStringToNumber(y)
+		case bool:
+			return it.looseEquals(x, it.toNumber(y))
+		case *jsObject:
+			return it.looseEquals(x, it.toPrimitive(y, ""))
+		}
+	case string:
+		switch y := b.(type) {
+		case string:
+			return x == y
+		case float64, bool:
+			return jsStringToNumber(x) == it.toNumber(y)
+		case *jsObject:
+			return it.looseEquals(x, it.toPrimitive(y, ""))
+		}
+	case bool:
+		return it.looseEquals(it.toNumber(x), b)
+	case *jsObject:
+		if y, ok := b.(*jsObject); ok {
+			return x == y
+		}
+		return it.looseEquals(b, a)
+	}
+	return false
+}
+
+// compare implements the relational operators; undefined results
+// (involving NaN) yield false
+func (it *jsInterp) compare(op string, a, b jsValue) bool {
+	pa := it.toPrimitive(a, "number")
+	pb := it.toPrimitive(b, "number")
+	if sa, ok := pa.(string); ok {
+		if sb, ok := pb.(string); ok {
+			switch op {
+			case "<":
+				return sa < sb
+			case ">":
+				return sa > sb
+			case "<=":
+				return sa <= sb
+			case ">=":
+				return sa >= sb
+			}
+		}
+	}
+	x, y := it.toNumber(pa), it.toNumber(pb)
+	switch op {
+	case "<":
+		return x < y
+	case ">":
+		return x > y
+	case "<=":
+		return x <= y
+	case ">=":
+		return x >= y
+	}
+	return false
+}
+
+// binaryOp applies a binary operator to evaluated operands
+func (it *jsInterp) binaryOp(op string, a, b jsValue) jsValue {
+	switch op {
+	case "+":
+		pa := it.toPrimitive(a, "")
+		pb := it.toPrimitive(b, "")
+		_, sa := pa.(string)
+		_, sb := pb.(string)
+		if sa || sb {
+			s := it.toString(pa) + it.toString(pb)
+			it.alloc(len(s))
+			return s
+		}
+		return it.toNumber(pa) + it.toNumber(pb)
+	case "-":
+		return it.toNumber(a) - it.toNumber(b)
+	case "*":
+		return it.toNumber(a) * it.toNumber(b)
+	case "/":
+		return it.toNumber(a) / it.toNumber(b)
+	case "%":
+		return math.Mod(it.toNumber(a), it.toNumber(b))
+	case "**":
+		return math.Pow(it.toNumber(a), it.toNumber(b))
+	case "==":
+		return it.looseEquals(a, b)
+	case "!=":
+		return !it.looseEquals(a, b)
+	case "===":
+		return jsStrictEquals(a, b)
+	case "!==":
+		return !jsStrictEquals(a, b)
+	case "<", ">", "<=", ">=":
+		return it.compare(op, a, b)
+	case "&":
+		return float64(it.toInt32(a) & it.toInt32(b))
+	case "|":
+		return float64(it.toInt32(a) | it.toInt32(b))
+	case "^":
+		return float64(it.toInt32(a) ^ it.toInt32(b))
+	case "<<":
+		return float64(it.toInt32(a) << (it.toUint32(b) & 31))
+	case ">>":
+		return float64(it.toInt32(a) >> (it.toUint32(b) & 31))
+	case ">>>":
+		return float64(it.toUint32(a) >> (it.toUint32(b) & 31))
+	case "instanceof":
+		fn, ok := b.(*jsObject)
+		if !ok || !fn.callable() {
+			it.throwError("TypeError", "Right-hand side of 'instanceof' is not callable")
+		}
+		obj, ok := a.(*jsObject)
+		if !ok {
+			return false
+		}
+		proto, _ := it.getProp(fn, "prototype").(*jsObject)
+		for p := obj.proto; p != nil; p = p.proto {
+			if p == proto {
+				return true
+			}
+		}
+		return false
+	case "in":
+		obj, ok := b.(*jsObject)
+		if !ok {
+			it.throwError("TypeError", "Cannot use 'in' operator to search for '%s' in %s", it.toString(a), it.toString(b))
+		}
+		return it.hasProperty(obj, it.propertyKey(a))
+	}
+	it.throwError("SyntaxError", "unknown operator %s", op)
+	return nil
+}
+
+// Variables
+
+// lookup resolves an identifier
+func (it *jsInterp) lookup(scope *jsScope, name string) (jsValue, bool) {
+	for s := scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+		if s.object != nil && it.hasProperty(s.object, name) {
+			return it.getProp(s.object, name), true
+		}
+	}
+	return nil, false
+}
+
+// assign stores a value in the nearest binding, creating a global
+// property for undeclared names
+func (it *jsInterp) assign(scope *jsScope, name string, v jsValue) {
+	for s := scope; s != nil; s = s.parent {
+		if _, ok := s.vars[name]; ok {
+			if s.consts[name] {
+				it.throwError("TypeError", "Assignment to constant variable '%s'", name)
+			}
+			s.vars[name] = v
+			return
+		}
+		if s.object != nil && it.hasProperty(s.object, name) {
+			it.setProp(s.object, name, v)
+			return
+		}
+	}
+	it.setProp(it.global, name, v)
+}
+
+// declare creates a binding in a scope
+func (it *jsInterp) declare(scope *jsScope, name string, v jsValue, kind string) {
+	if scope.object != nil {
+		it.setProp(scope.object, name, v)
+		return
+	}
+	it.alloc(32 + len(name))
+	scope.vars[name] = v
+	if kind == "const" {
+		if scope.consts == nil {
+			scope.consts = make(map[string]bool)
+		}
+		scope.consts[name] = true
+	}
+}
+
+// thisValue returns the this binding visible from a scope
+func (it *jsInterp) thisValue(scope *jsScope) jsValue {
+	for s := scope; s != nil; s = s.parent {
+		if s.hasThis {
+			return s.this
+		}
+	}
+	return it.global
+}
+
+// Statements
+
+// hoistFunctions instantiates function declarations of a statement list
+// in the nearest function scope
+func (it *jsInterp) hoistFunctions(body []jsNode, scope *jsScope) {
+	for _, stmt := range body {
+		if decl, ok := stmt.(*jsFuncDecl); ok {
+			it.declare(scope.functionScope(), decl.fn.name, it.newFunction(decl.fn, scope), "var")
+		}
+	}
+}
+
+// execList executes a statement list
+func (it *jsInterp) execList(body []jsNode, scope *jsScope) jsCompletion {
+	it.hoistFunctions(body, scope)
+	var last jsValue
+	for _, stmt := range body {
+		c := it.exec(stmt, scope)
+		if c.typ != jsNormal {
+			return c
+		}
+		if c.value != nil {
+			last = c.value
+		}
+	}
+	return jsCompletion{value: last}
+}
+
+// exec executes a statement
+func (it *jsInterp) exec(node jsNode, scope *jsScope) jsCompletion {
+	it.tick()
+
+	switch n := node.(type) {
+	case *jsExprStmt:
+		return jsCompletion{value: it.eval(n.x, scope)}
+
+	case *jsVarDecl:
+		for _, d := range n.decls {
+			if n.kind == "var" {
+				if d.init != nil {
+					it.assign(scope, d.name, it.namedValue(d.init, d.name, scope))
+				}
+				continue
+			}
+			var v jsValue = jsUndefined
+			if d.init != nil {
+				v = it.namedValue(d.init, d.name, scope)
+			}
+			it.declare(scope, d.name, v, n.kind)
+		}
+
+	case *jsFuncDecl, *jsEmpty:
+		// Function declarations are hoisted
+
+	case *jsBlock:
+		return it.execList(n.body, newJSScope(scope, false))
+
+	case *jsIf:
+		if it.toBoolean(it.eval(n.test, scope)) {
+			return it.exec(n.cons, scope)
+		} else if n.alt != nil {
+			return it.exec(n.alt, scope)
+		}
+
+	case *jsFor, *jsForIn, *jsWhile, *jsDoWhile:
+		return it.execLoop(node, scope, "")
+
+	case *jsLabeled:
+		switch n.body.(type) {
+		case *jsFor, *jsForIn, *jsWhile, *jsDoWhile:
+			return it.execLoop(n.body, scope, n.label)
+		}
+		c := it.exec(n.body, scope)
+		if c.typ == jsBreakCompletion && c.label == n.label {
+			return jsCompletion{}
+		}
+		return c
+
+	case *jsReturn:
+		var v jsValue = jsUndefined
+		if n.x != nil {
+			v = it.eval(n.x, scope)
+		}
+		return jsCompletion{typ: jsReturnCompletion, value: v}
+
+	case *jsBreak:
+		return jsCompletion{typ: jsBreakCompletion, label: n.label}
+
+	case *jsContinue:
+		return jsCompletion{typ: jsContinueCompletion, label: n.label}
+
+	case *jsThrow:
+		it.throwValue(it.eval(n.x, scope))
+
+	case *jsTry:
+		return it.execTry(n, scope)
+
+	case *jsSwitch:
+		return it.execSwitch(n, scope)
+
+	default:
+		it.throwError("SyntaxError", "unsupported statement %T", node)
+	}
+	return jsCompletion{}
+}
+
+// execLoop executes a loop statement with an optional label
+func (it *jsInterp) execLoop(node jsNode, scope *jsScope, label string) jsCompletion {
+	// body runs one iteration and reports whether the loop must stop
+	body := func(stmt jsNode, s *jsScope) (jsCompletion, bool) {
+		c := it.exec(stmt, s)
+		switch c.typ {
+		case jsBreakCompletion:
+			if c.label == "" || c.label == label {
+				return jsCompletion{}, true
+			}
+			return c, true
+		case jsContinueCompletion:
+			if c.label != "" && c.label != label {
+				return c, true
+			}
+		case jsReturnCompletion:
+			return c, true
+		}
+		return jsCompletion{}, false
+	}
+
+	switch n := node.(type) {
+	case *jsWhile:
+		for it.toBoolean(it.eval(n.test, scope)) {
+			if c, stop := body(n.body, scope); stop {
+				return c
+			}
+		}
+
+	case *jsDoWhile:
+		for {
+			if c, stop := body(n.body, scope); stop {
+				return c
+			}
+			if !it.toBoolean(it.eval(n.test, scope)) {
+				break
+			}
+		}
+
+	case *jsFor:
+		loopScope := newJSScope(scope, false)
+		if n.init != nil {
+			it.exec(n.init, loopScope)
+		}
+		for {
+			if n.test != nil && !it.toBoolean(it.eval(n.test, loopScope)) {
+				break
+			}
+			if c, stop := body(n.body, loopScope); stop {
+				return c
+			}
+			// Give closures created in the body their own copy of let bindings
+			if len(loopScope.vars) > 0 {
+				next := newJSScope(scope, false)
+				for k, v := range loopScope.vars {
+					next.vars[k] = v
+				}
+				next.consts = loopScope.consts
+				loopScope = next
+			}
+			if n.update != nil {
+				it.eval(n.update, loopScope)
+			}
+		}
+
+	case *jsForIn:
+		obj := it.eval(n.object, scope)
+		var items []jsValue
+		if n.of {
+			switch x := obj.(type) {
+			case string:
+				for _, r := range x {
+					items = append(items, string(r))
+				}
+			case *jsObject:
+				if x.class != "Array" {
+					it.throwError("TypeError", "object is not iterable")
+				}
+				items = append(items, x.array...)
+			default:
+				it.throwError("TypeError", "%s is not iterable", it.toString(obj))
+			}
+		} else {
+			switch x := obj.(type) {
+			case *jsObject:
+				seen := make(map[string]bool)
+				for o := x; o != nil; o = o.proto {
+					for _, k := range it.ownKeys(o) {
+						if !seen[k] {
+							seen[k] = true
+							items = append(items, k)
+						}
+					}
+				}
+			case string:
+				for i := range []rune(x) {
+					items = append(items, strconv.Itoa(i))
+				}
+			}
+		}
+
+		for _, item := range items {
+			iterScope := newJSScope(scope, false)
+			switch {
+			case n.kind == "var":
+				it.assign(scope, n.name, item)
+			case n.kind != "":
+				it.declare(iterScope, n.name, item, n.kind)
+			default:
+				it.assignTo(n.target, item, scope)
+			}
+			if c, stop := body(n.body, iterScope); stop {
+				return c
+			}
+		}
+	}
+	return jsCompletion{}
+}
+
+// execTry executes a try statement. Only script exceptions are caught;
+// resource limit violations always propagate.
+func (it *jsInterp) execTry(n *jsTry, scope *jsScope) jsCompletion {
+	c, exc := it.execCatching(n.block, scope)
+	if exc != nil && n.handler != nil {
+		handlerScope := newJSScope(scope, false)
+		if n.param != "" {
+			it.declare(handlerScope, n.param, exc.value, "let")
+		}
+		c, exc = it.execCatching(n.handler, handlerScope)
+	}
+	if n.finalizer != nil {
+		if fc := it.exec(n.finalizer, scope); fc.typ != jsNormal {
+			return fc
+		}
+	}
+	if exc != nil {
+		panic(exc)
+	}
+	return c
+}
+
+// execCatching executes a block and returns a thrown exception instead of
+// unwinding
+func (it *jsInterp) execCatching(block *jsBlock, scope *jsScope) (c jsCompletion, exc *jsException) {
+	depth := it.depth
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*jsException); ok {
+				it.depth = depth
+				exc = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	return it.exec(block, scope), nil
+}
+
+func (it *jsInterp) execSwitch(n *jsSwitch, scope *jsScope) jsCompletion {
+	disc := it.eval(n.disc, scope)
+	switchScope := newJSScope(scope, false)
+
+	start := -1
+	for i, c := range n.cases {
+		if c.test != nil && jsStrictEquals(disc, it.eval(c.test, switchScope)) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		for i, c := range n.cases {
+			if c.test == nil {
+				start = i
+				break
+			}
+		}
+	}
+	if start < 0 {
+		return jsCompletion{}
+	}
+
+	var last jsValue
+	for _, c := range n.cases[start:] {
+		res := it.execList(c.body, switchScope)
+		if res.typ == jsBreakCompletion && res.label == "" {
+			return jsCompletion{value: last}
+		}
+		if res.typ != jsNormal {
+			return res
+		}
+		if res.value != nil {
+			last = res.value
+		}
+	}
+	return jsCompletion{value: last}
+}
+
+// Expressions
+
+// namedValue evaluates an initializer, naming anonymous functions after
+// the variable they are assigned to
+func (it *jsInterp) namedValue(node jsNode, name string, scope *jsScope) jsValue {
+	v := it.eval(node, scope)
+	if fn, ok := v.(*jsObject); ok && fn.fn != nil && fn.name == "" {
+		fn.name = name
+	}
+	return v
+}
+
+// eval evaluates an expression
+func (it *jsInterp) eval(node jsNode, scope *jsScope) jsValue {
+	it.tick()
+
+	switch n := node.(type) {
+	case *jsNumberLit:
+		return n.value
+	case *jsStringLit:
+		return n.value
+	case *jsBoolLit:
+		return n.value
+	case *jsNullLit:
+		return jsNull
+	case *jsThisExpr:
+		return it.thisValue(scope)
+
+	case *jsIdent:
+		if v, ok := it.lookup(scope, n.name); ok {
+			return v
+		}
+		switch n.name {
+		case "undefined":
+			return jsUndefined
+		case "NaN":
+			return math.NaN()
+		case "Infinity":
+			return math.Inf(1)
+		}
+		it.throwError("ReferenceError", "%s is not defined", n.name)
+
+	case *jsRegExpLit:
+		return it.newRegExp(n.pattern, n.flags)
+
+	case *jsArrayLit:
+		var elems []jsValue
+		for _, e := range n.elems {
+			if e == nil {
+				elems = append(elems, jsUndefined)
+				continue
+			}
+			if spread, ok := e.(*jsSpread); ok {
+				elems = append(elems, it.spreadValues(it.eval(spread.arg, scope))...)
+				continue
+			}
+			elems = append(elems, it.eval(e, scope))
+		}
+		return it.newArray(elems)
+
+	case *jsObjectLit:
+		obj := it.newObject()
+		for _, p := range n.props {
+			key := p.key
+			if p.computed != nil {
+				key = it.propertyKey(it.eval(p.computed, scope))
+			}
+			switch p.kind {
+			case "get", "set":
+				fn := it.newFunction(p.value.(*jsFuncLit), scope)
+				if p.kind == "get" {
+					if obj.getters == nil {
+						obj.getters = make(map[string]*jsObject)
+					}
+					obj.getters[key] = fn
+				} else {
+					if obj.setters == nil {
+						obj.setters = make(map[string]*jsObject)
+					}
+					obj.setters[key] = fn
+				}
+				if _, ok := obj.props[key]; !ok {
+					obj.keys = append(obj.keys, key)
+				}
+			default:
+				it.setProp(obj, key, it.namedValue(p.value, key, scope))
+			}
+		}
+		return obj
+
+	case *jsFuncLit:
+		if n.name != "" && !n.arrow {
+			// Named function expressions can refer to themselves
+			inner := newJSScope(scope, false)
+			fn := it.newFunction(n, inner)
+			inner.vars[n.name] = fn
+			return fn
+		}
+		return it.newFunction(n, scope)
+
+	case *jsMember:
+		obj := it.eval(n.object, scope)
+		if n.optional && isNullish(obj) {
+			return jsUndefined
+		}
+		return it.getMember(obj, it.memberKey(n, scope))
+
+	case *jsCall:
+		return it.evalCall(n, scope)
+
+	case *jsNew:
+		callee := it.eval(n.callee, scope)
+		return it.construct(callee, it.evalArgs(n.args, scope))
+
+	case *jsUnary:
+		return it.evalUnary(n, scope)
+
+	case *jsUpdate:
+		old := it.toNumber(it.eval(n.x, scope))
+		v := old + 1
+		if n.op == "--" {
+			v = old - 1
+		}
+		it.assignTo(n.x, v, scope)
+		if n.prefix {
+			return v
+		}
+		return old
+
+	case *jsBinary:
+		return it.binaryOp(n.op, it.eval(n.l, scope), it.eval(n.r, scope))
+
+	case *jsLogical:
+		l := it.eval(n.l, scope)
+		switch n.op {
+		case "&&":
+			if !it.toBoolean(l) {
+				return l
+			}
+		case "||":
+			if it.toBoolean(l) {
+				return l
+			}
+		case "??":
+			if !isNullish(l) {
+				return l
+			}
+		}
+		return it.eval(n.r, scope)
+
+	case *jsConditional:
+		if it.toBoolean(it.eval(n.test, scope)) {
+			return it.eval(n.cons, scope)
+		}
+		return it.eval(n.alt, scope)
+
+	case *jsAssign:
+		if n.op == "=" {
+			var v jsValue
+			if id, ok := n.target.(*jsIdent); ok {
+				v = it.namedValue(n.value, id.name, scope)
+			} else {
+				v = it.eval(n.value, scope)
+			}
+			it.assignTo(n.target, v, scope)
+			return v
+		}
+		// Compound assignment evaluates the target reference once
+		op := strings.TrimSuffix(n.op, "=")
+		if m, ok := n.target.(*jsMember); ok {
+			obj := it.eval(m.object, scope)
+			key := it.memberKey(m, scope)
+			v := it.binaryOp(op, it.getMember(obj, key), it.eval(n.value, scope))
+			it.setMember(obj, key, v)
+			return v
+		}
+		v := it.binaryOp(op, it.eval(n.target, scope), it.eval(n.value, scope))
+		it.assignTo(n.target, v, scope)
+		return v
+
+	case *jsSequence:
+		var v jsValue = jsUndefined
+		for _, x := range n.list {
+			v = it.eval(x, scope)
+		}
+		return v
+
+	case *jsSpread:
+		it.throwError("SyntaxError", "unexpected spread")
+	}
+
+	it.throwError("SyntaxError", "unsupported expression %T", node)
+	return nil
+}
+
+func isNullish(v jsValue) bool {
+	switch v.(type) {
+	case jsUndefinedType, jsNullType:
+		return true
+	}
+	return false
+}
+
+// memberKey evaluates the property key of a member expression
+func (it *jsInterp) memberKey(m *jsMember, scope *jsScope) string {
+	if m.computed != nil {
+		return it.propertyKey(it.eval(m.computed, scope))
+	}
+	return m.name
+}
+
+// assignTo stores a value into an identifier or member target
+func (it *jsInterp) assignTo(target jsNode, v jsValue, scope *jsScope) {
+	switch t := target.(type) {
+	case *jsIdent:
+		it.assign(scope, t.name, v)
+	case *jsMember:
+		obj := it.eval(t.object, scope)
+		it.setMember(obj, it.memberKey(t, scope), v)
+	default:
+		it.throwError("SyntaxError", "invalid assignment target")
+	}
+}
+
+func (it *jsInterp) evalUnary(n *jsUnary, scope *jsScope) jsValue {
+	switch n.op {
+	case "typeof":
+		if id, ok := n.x.(*jsIdent); ok {
+			v, found := it.lookup(scope, id.name)
+			if !found {
+				return "undefined"
+			}
+			return jsTypeOf(v)
+		}
+		return jsTypeOf(it.eval(n.x, scope))
+	case "delete":
+		if m, ok := n.x.(*jsMember); ok {
+			if obj, ok := it.eval(m.object, scope).(*jsObject); ok {
+				return it.deleteProp(obj, it.memberKey(m, scope))
+			}
+		}
+		return true
+	}
+
+	v := it.eval(n.x, scope)
+	switch n.op {
+	case "!":
+		return !it.toBoolean(v)
+	case "-":
+		return -it.toNumber(v)
+	case "+":
+		return it.toNumber(v)
+	case "~":
+		return float64(^it.toInt32(v))
+	case "void":
+		return jsUndefined
+	}
+	it.throwError("SyntaxError", "unknown operator %s", n.op)
+	return nil
+}
+
+// spreadValues expands an iterable for spread syntax
+func (it *jsInterp) spreadValues(v jsValue) []jsValue {
+	switch x := v.(type) {
+	case *jsObject:
+		if x.class == "Array" {
+			return append([]jsValue(nil), x.array...)
+		}
+	case string:
+		var out []jsValue
+		for _, r := range x {
+			out = it.appendValue(out, string(r), utf8.RuneLen(r))
+		}
+		return out
+	}
+	it.throwError("TypeError", "%s is not iterable", it.toString(v))
+	return nil
+}
+
+func (it *jsInterp) evalArgs(args []jsNode, scope *jsScope) []jsValue {
+	out := make([]jsValue, 0, len(args))
+	for _, a := range args {
+		if spread, ok := a.(*jsSpread); ok {
+			out = append(out, it.spreadValues(it.eval(spread.arg, scope))...)
+			continue
+		}
+		out = append(out, it.eval(a, scope))
+	}
+	return out
+}
+
+func (it *jsInterp) evalCall(n *jsCall, scope *jsScope) jsValue {
+	var this jsValue = jsUndefined
+	var fn jsValue
+	var what string
+
+	switch callee := n.callee.(type) {
+	case *jsMember:
+		obj := it.eval(callee.object, scope)
+		if callee.optional && isNullish(obj) {
+			return jsUndefined
+		}
+		key := it.memberKey(callee, scope)
+		fn = it.getMember(obj, key)
+		this = obj
+		what = key
+	case *jsIdent:
+		fn = it.eval(callee, scope)
+		what = callee.name
+	default:
+		fn = it.eval(callee, scope)
+		what = "expression"
+	}
+
+	if n.optional && isNullish(fn) {
+		return jsUndefined
+	}
+	if f, ok := fn.(*jsObject); !ok || !f.callable() {
+		it.throwError("TypeError", "%s is not a function", what)
+	}
+	return it.call(fn, this, it.evalArgs(n.args, scope))
+}
+
+// call invokes a function value
+func (it *jsInterp) call(fnv jsValue, this jsValue, args []jsValue) jsValue {
+	fn, ok := fnv.(*jsObject)
+	if !ok || !fn.callable() {
+		it.throwError("TypeError", "%s is not a function", jsTypeOf(fnv))
+	}
+
+	maxDepth := it.limits.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultJSLimits.MaxDepth
+	}
+	if it.depth >= maxDepth {
+		it.throwError("RangeError", "Maximum call stack size exceeded")
+	}
+	it.depth++
+	defer func() { it.depth-- }()
+
+	switch {
+	case fn.boundTarget != nil:
+		return it.call(fn.boundTarget, fn.boundThis, append(append([]jsValue(nil), fn.boundArgs...), args...))
+	case fn.native != nil:
+		return fn.native(it, this, args)
+	case fn.fn == nil:
+		// Constructor-only built-in called as a function
+		return fn.construct(it, this, args)
+	}
+
+	lit := fn.fn
+	scope := newJSScope(fn.scope, true)
+	if !lit.arrow {
+		if isNullish(this) {
+			this = it.global
+		}
+		scope.this = this
+		scope.hasThis = true
+		scope.vars["arguments"] = it.newArray(append([]jsValue(nil), args...))
+	}
+	for i, name := range lit.params {
+		if i < len(args) {
+			scope.vars[name] = args[i]
+		} else {
+			scope.vars[name] = jsUndefined
+		}
+	}
+	if lit.rest != "" {
+		var rest []jsValue
+		if len(args) > len(lit.params) {
+			rest = append(rest, args[len(lit.params):]...)
+		}
+		scope.vars[lit.rest] = it.newArray(rest)
+	}
+	for _, name := range lit.vars {
+		if _, ok := scope.vars[name]; !ok {
+			scope.vars[name] = jsUndefined
+		}
+	}
+
+	if lit.exprBody != nil {
+		return it.eval(lit.exprBody, scope)
+	}
+	if c := it.execList(lit.body, scope); c.typ == jsReturnCompletion {
+		return c.value
+	}
+	return jsUndefined
+}
+
+// construct implements the new operator
+func (it *jsInterp) construct(fnv jsValue, args []jsValue) jsValue {
+	fn, ok := fnv.(*jsObject)
+	if !ok || !fn.callable() {
+		it.throwError("TypeError", "%s is not a constructor", jsTypeOf(fnv))
+	}
+	if fn.construct != nil {
+		return fn.construct(it, jsUndefined, args)
+	}
+	if fn.fn == nil || fn.fn.arrow {
+		it.throwError("TypeError", "%s is not a constructor", fn.name)
+	}
+
+	obj := it.newObject()
+	if proto, ok := it.getProp(fn, "prototype").(*jsObject); ok {
+		obj.proto = proto
+	}
+	if res, ok := it.call(fn, obj, args).(*jsObject); ok {
+		return res
+	}
+	return obj
+}
+
+// argument returns args[i] or undefined
+func argument(args []jsValue, i int) jsValue {
+	if i < len(args) {
+		return args[i]
+	}
+	return jsUndefined
+}
@@ -0,0 +1,425 @@
+package pdf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// jsTokenKind identifies the kind of a JavaScript token
+type jsTokenKind int
+
+const (
+	jsTokEOF jsTokenKind = iota
+	jsTokIdent
+	jsTokKeyword
+	jsTokNumber
+	jsTokString
+	jsTokRegExp
+	jsTokPunct
+)
+
+// jsToken is a lexical token of a script
+type jsToken struct {
+	kind  jsTokenKind
+	text  string  // identifier, keyword, punctuator, string value or regexp body
+	num   float64 // numeric value
+	flags string  // regexp flags
+	nl    bool    // a line terminator precedes the token
+	line  int
+}
+
+// jsKeywords lists the reserved words recognised by the parser
+var jsKeywords = map[string]bool{
+	"var": true, "let": true, "const": true, "function": true, "return": true,
+	"if": true, "else": true, "for": true, "while": true, "do": true,
+	"break": true, "continue": true, "new": true, "delete": true, "typeof": true,
+	"instanceof": true, "in": true, "this": true, "null": true, "true": true,
+	"false": true, "switch": true, "case": true, "default": true, "throw": true,
+	"try": true, "catch": true, "finally": true, "void": true,
+}
+
+// jsPunctuators lists punctuators, longest first
+var jsPunctuators = []string{
+	">>>=", "...", "===", "!==", "**=", "<<=", ">>=", ">>>",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "**", "<<", ">>",
+	"{", "}", "(", ")", "[", "]", ";", ",", "<", ">", "+", "-", "*", "/",
+	"%", "&", "|", "^", "!", "~", "?", ":", "=", ".",
+}
+
+// jsSyntaxError is a parse error with its line number
+type jsSyntaxError struct {
+	line int
+	msg  string
+}
+
+func (e *jsSyntaxError) Error() string {
+	return fmt.Sprintf("SyntaxError: %s (line %d)", e.msg, e.line)
+}
+
+// jsTokenize splits a script into tokens
+func jsTokenize(src string) ([]jsToken, error) {
+	var tokens []jsToken
+	line := 1
+	i := 0
+	nl := false
+
+	for {
+		// Skip white space and comments
+		for i < len(src) {
+			c := src[i]
+			if c == '\n' {
+				line++
+				nl = true
+				i++
+			} else if c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v' {
+				i++
+			} else if c == '/' && i+1 < len(src) && src[i+1] == '/' {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			} else if c == '/' && i+1 < len(src) && src[i+1] == '*' {
+				end := strings.Index(src[i+2:], "*/")
+				if end < 0 {
+					return nil, &jsSyntaxError{line, "unterminated comment"}
+				}
+				comment := src[i : i+2+end+2]
+				if n := strings.Count(comment, "\n"); n > 0 {
+					line += n
+					nl = true
+				}
+				i += len(comment)
+			} else if c >= 0x80 {
+				r, size := utf8.DecodeRuneInString(src[i:])
+				if r == 0x2028 || r == 0x2029 {
+					line++
+					nl = true
+				} else if !unicode.IsSpace(r) && r != 0xFEFF {
+					break
+				}
+				i += size
+			} else {
+				break
+			}
+		}
+		if i >= len(src) {
+			tokens = append(tokens, jsToken{kind: jsTokEOF, nl: true, line: line})
+			return tokens, nil
+		}
+
+		tok := jsToken{nl: nl, line: line}
+		nl = false
+		c := src[i]
+
+		switch {
+		case isJSIdentStart(src, i):
+			start := i
+			for i < len(src) && isJSIdentPart(src, i) {
+				_, size := utf8.DecodeRuneInString(src[i:])
+				i += size
+			}
+			tok.text = src[start:i]
+			tok.kind = jsTokIdent
+			if jsKeywords[tok.text] {
+				tok.kind = jsTokKeyword
+			}
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			n, size, err := jsScanNumber(src[i:])
+			if err != nil {
+				return nil, &jsSyntaxError{line, err.Error()}
+			}
+			tok.kind = jsTokNumber
+			tok.num = n
+			i += size
+
+		case c == '"' || c == '\'':
+			s, size, lines, err := jsScanString(src[i:])
+			if err != nil {
+				return nil, &jsSyntaxError{line, err.Error()}
+			}
+			tok.kind = jsTokString
+			tok.text = s
+			line += lines
+			i += size
+
+		case c == '/' && jsRegExpAllowed(tokens):
+			body, flags, size, err := jsScanRegExp(src[i:])
+			if err != nil {
+				return nil, &jsSyntaxError{line, err.Error()}
+			}
+			tok.kind = jsTokRegExp
+			tok.text = body
+			tok.flags = flags
+			i += size
+
+		default:
+			matched := false
+			for _, p := range jsPunctuators {
+				if strings.HasPrefix(src[i:], p) {
+					// "?." followed by a digit is a conditional and a number
+					if p == "?." && i+2 < len(src) && src[i+2] >= '0' && src[i+2] <= '9' {
+						continue
+					}
+					tok.kind = jsTokPunct
+					tok.text = p
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				r, _ := utf8.DecodeRuneInString(src[i:])
+				return nil, &jsSyntaxError{line, fmt.Sprintf("unexpected character %q", r)}
+			}
+		}
+
+		tokens = append(tokens, tok)
+	}
+}
+
+// isJSIdentStart reports whether an identifier starts at src[i]
+func isJSIdentStart(src string, i int) bool {
+	c := src[i]
+	if c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+		return true
+	}
+	if c >= 0x80 {
+		r, _ := utf8.DecodeRuneInString(src[i:])
+		return unicode.IsLetter(r)
+	}
+	return false
+}
+
+// isJSIdentPart reports whether src[i] may continue an identifier
+func isJSIdentPart(src string, i int) bool {
+	if isJSIdentStart(src, i) {
+		return true
+	}
+	c := src[i]
+	if c >= '0' && c <= '9' {
+		return true
+	}
+	if c >= 0x80 {
+		r, _ := utf8.DecodeRuneInString(src[i:])
+		return unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+	}
+	return false
+}
+
+// jsRegExpAllowed reports whether a slash starts a regular expression
+// literal rather than a division, judging by the previous token
+func jsRegExpAllowed(tokens []jsToken) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	prev := tokens[len(tokens)-1]
+	switch prev.kind {
+	case jsTokNumber, jsTokString, jsTokRegExp, jsTokIdent:
+		return false
+	case jsTokKeyword:
+		switch prev.text {
+		case "this", "null", "true", "false":
+			return false
+		}
+		return true
+	case jsTokPunct:
+		switch prev.text {
+		case ")", "]", "++", "--":
+			return false
+		}
+	}
+	return true
+}
+
+// jsScanNumber scans a numeric literal
+func jsScanNumber(s string) (float64, int, error) {
+	if len(s) > 1 && s[0] == '0' {
+		base := 0
+		switch s[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 0 {
+			i := 2
+			for i < len(s) && isHexDigit(s[i]) {
+				i++
+			}
+			v, err := strconv.ParseUint(s[2:i], base, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid number %q", s[:i])
+			}
+			return float64(v), i, nil
+		}
+	}
+
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			i = j
+		}
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange {
+			return 0, 0, fmt.Errorf("invalid number %q", s[:i])
+		}
+	}
+	return v, i, nil
+}
+
+// isHexDigit reports whether c is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// jsScanString scans a quoted string literal and decodes its escapes
+func jsScanString(s string) (string, int, int, error) {
+	quote := s[0]
+	var sb strings.Builder
+	lines := 0
+	i := 1
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == quote:
+			return sb.String(), i + 1, lines, nil
+		case c == '\n':
+			return "", 0, 0, fmt.Errorf("unterminated string")
+		case c == '\\' && i+1 < len(s):
+			i++
+			e := s[i]
+			i++
+			switch e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'v':
+				sb.WriteByte('\v')
+			case '0':
+				sb.WriteByte(0)
+			case '\r':
+				if i < len(s) && s[i] == '\n' {
+					i++
+				}
+				lines++
+			case '\n':
+				lines++
+			case 'x':
+				if i+2 <= len(s) {
+					if v, err := strconv.ParseUint(s[i:i+2], 16, 8); err == nil {
+						sb.WriteRune(rune(v))
+						i += 2
+						continue
+					}
+				}
+				sb.WriteByte('x')
+			case 'u':
+				r, size := jsScanUnicodeEscape(s[i:])
+				if size == 0 {
+					sb.WriteByte('u')
+					continue
+				}
+				i += size
+				// Combine surrogate pairs
+				if r >= 0xD800 && r < 0xDC00 && strings.HasPrefix(s[i:], "\\u") {
+					if low, n := jsScanUnicodeEscape(s[i+2:]); n > 0 && low >= 0xDC00 && low < 0xE000 {
+						r = (r-0xD800)<<10 + (low - 0xDC00) + 0x10000
+						i += 2 + n
+					}
+				}
+				sb.WriteRune(r)
+			default:
+				sb.WriteByte(e)
+			}
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, 0, fmt.Errorf("unterminated string")
+}
+
+// jsScanUnicodeEscape decodes the hex digits of a \u escape
+func jsScanUnicodeEscape(s string) (rune, int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return 0, 0
+		}
+		v, err := strconv.ParseUint(s[1:end], 16, 32)
+		if err != nil {
+			return 0, 0
+		}
+		return rune(v), end + 1
+	}
+	if len(s) < 4 {
+		return 0, 0
+	}
+	v, err := strconv.ParseUint(s[:4], 16, 32)
+	if err != nil {
+		return 0, 0
+	}
+	return rune(v), 4
+}
+
+// jsScanRegExp scans a regular expression literal
+func jsScanRegExp(s string) (string, string, int, error) {
+	inClass := false
+	i := 1
+	for i < len(s) {
+		c := s[i]
+		if c == '\n' {
+			break
+		}
+		if c == '\\' {
+			i += 2
+			continue
+		}
+		if c == '[' {
+			inClass = true
+		} else if c == ']' {
+			inClass = false
+		} else if c == '/' && !inClass {
+			body := s[1:i]
+			i++
+			start := i
+			for i < len(s) && (s[i] >= 'a' && s[i] <= 'z') {
+				i++
+			}
+			return body, s[start:i], i, nil
+		}
+		i++
+	}
+	return "", "", 0, fmt.Errorf("unterminated regular expression")
+}
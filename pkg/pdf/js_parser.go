@@ -0,0 +1,988 @@
+package pdf
+
+import "fmt"
+
+// jsNode is a node of the script syntax tree
+type jsNode interface{}
+
+// Expressions
+
+type jsNumberLit struct{ value float64 }
+type jsStringLit struct{ value string }
+type jsBoolLit struct{ value bool }
+type jsNullLit struct{}
+type jsThisExpr struct{}
+type jsIdent struct{ name string }
+
+type jsRegExpLit struct {
+	pattern string
+	flags   string
+}
+
+type jsArrayLit struct {
+	elems []jsNode // nil entries are holes
+}
+
+type jsProperty struct {
+	key      string
+	computed jsNode
+	value    jsNode
+	kind     string // "init", "get" or "set"
+}
+
+type jsObjectLit struct {
+	props []jsProperty
+}
+
+// jsFuncLit is a function expression, declaration or arrow function
+type jsFuncLit struct {
+	name     string
+	params   []string
+	rest     string
+	body     []jsNode
+	exprBody jsNode // arrow function with an expression body
+	arrow    bool
+	vars     []string // hoisted var declarations
+}
+
+type jsMember struct {
+	object   jsNode
+	name     string
+	computed jsNode
+	optional bool
+}
+
+type jsCall struct {
+	callee   jsNode
+	args     []jsNode
+	optional bool
+}
+
+type jsNew struct {
+	callee jsNode
+	args   []jsNode
+}
+
+type jsSpread struct{ arg jsNode }
+
+type jsUnary struct {
+	op string
+	x  jsNode
+}
+
+type jsUpdate struct {
+	op     string
+	prefix bool
+	x      jsNode
+}
+
+type jsBinary struct {
+	op   string
+	l, r jsNode
+}
+
+type jsLogical struct {
+	op   string
+	l, r jsNode
+}
+
+type jsConditional struct {
+	test, cons, alt jsNode
+}
+
+type jsAssign struct {
+	op     string
+	target jsNode
+	value  jsNode
+}
+
+type jsSequence struct{ list []jsNode }
+
+// Statements
+
+type jsDeclarator struct {
+	name string
+	init jsNode
+}
+
+type jsVarDecl struct {
+	kind  string
+	decls []jsDeclarator
+}
+
+type jsFuncDecl struct{ fn *jsFuncLit }
+type jsExprStmt struct{ x jsNode }
+type jsEmpty struct{}
+
+type jsBlock struct {
+	body []jsNode
+}
+
+type jsIf struct {
+	test      jsNode
+	cons, alt jsNode
+}
+
+type jsFor struct {
+	init, test, update jsNode
+	body               jsNode
+}
+
+type jsForIn struct {
+	kind   string // declaration kind, or "" for an assignment target
+	name   string
+	target jsNode
+	object jsNode
+	body   jsNode
+	of     bool
+}
+
+type jsWhile struct {
+	test jsNode
+	body jsNode
+}
+
+type jsDoWhile struct {
+	body jsNode
+	test jsNode
+}
+
+type jsReturn struct{ x jsNode }
+type jsBreak struct{ label string }
+type jsContinue struct{ label string }
+type jsThrow struct{ x jsNode }
+
+type jsTry struct {
+	block     *jsBlock
+	param     string
+	handler   *jsBlock
+	finalizer *jsBlock
+}
+
+type jsCase struct {
+	test jsNode // nil for default
+	body []jsNode
+}
+
+type jsSwitch struct {
+	disc  jsNode
+	cases []jsCase
+}
+
+type jsLabeled struct {
+	label string
+	body  jsNode
+}
+
+// jsProgram is a parsed script
+type jsProgram struct {
+	body []jsNode
+	vars []string
+}
+
+// jsParser is a recursive descent parser for an ECMAScript 5 subset with
+// let/const, arrow functions, spread arguments and for-of loops
+type jsParser struct {
+	tokens []jsToken
+	pos    int
+	funcs  []*jsFuncLit // enclosing functions collecting var names
+	top    *jsProgram
+	noIn   bool
+	depth  int
+}
+
+// jsMaxNesting bounds the syntactic nesting depth of a script
+const jsMaxNesting = 500
+
+// jsParse parses a script
+func jsParse(src string) (prog *jsProgram, err error) {
+	tokens, err := jsTokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &jsParser{tokens: tokens, top: &jsProgram{}}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if se, ok := r.(*jsSyntaxError); ok {
+				prog, err = nil, se
+				return
+			}
+			panic(r)
+		}
+	}()
+
+	for p.peek().kind != jsTokEOF {
+		p.top.body = append(p.top.body, p.statement())
+	}
+	return p.top, nil
+}
+
+func (p *jsParser) peek() jsToken {
+	return p.tokens[p.pos]
+}
+
+func (p *jsParser) peekAt(n int) jsToken {
+	if p.pos+n < len(p.tokens) {
+		return p.tokens[p.pos+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *jsParser) next() jsToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != jsTokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *jsParser) fail(format string, args ...interface{}) {
+	panic(&jsSyntaxError{p.peek().line, fmt.Sprintf(format, args...)})
+}
+
+// is reports whether the next token is the given punctuator or keyword
+func (p *jsParser) is(text string) bool {
+	tok := p.peek()
+	return (tok.kind == jsTokPunct || tok.kind == jsTokKeyword) && tok.text == text
+}
+
+// accept consumes the given punctuator or keyword if present
+func (p *jsParser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *jsParser) expect(text string) {
+	if !p.accept(text) {
+		p.fail("expected %q but found %q", text, p.describe(p.peek()))
+	}
+}
+
+func (p *jsParser) describe(tok jsToken) string {
+	switch tok.kind {
+	case jsTokEOF:
+		return "end of script"
+	case jsTokNumber:
+		return jsNumberToString(tok.num)
+	}
+	return tok.text
+}
+
+// identifier consumes an identifier; contextual words such as "of" are
+// identifiers as well
+func (p *jsParser) identifier() string {
+	tok := p.peek()
+	if tok.kind != jsTokIdent {
+		p.fail("expected identifier but found %q", p.describe(tok))
+	}
+	p.pos++
+	return tok.text
+}
+
+// semicolon consumes a statement terminator, applying automatic semicolon
+// insertion
+func (p *jsParser) semicolon() {
+	if p.accept(";") {
+		return
+	}
+	tok := p.peek()
+	if tok.kind == jsTokEOF || tok.nl || p.is("}") {
+		return
+	}
+	p.fail("unexpected %q", p.describe(tok))
+}
+
+// declareVar records a var declaration in the enclosing function
+func (p *jsParser) declareVar(name string) {
+	if len(p.funcs) > 0 {
+		fn := p.funcs[len(p.funcs)-1]
+		fn.vars = append(fn.vars, name)
+	} else {
+		p.top.vars = append(p.top.vars, name)
+	}
+}
+
+// enter tracks nesting depth so hostile input cannot exhaust the stack
+func (p *jsParser) enter() {
+	p.depth++
+	if p.depth > jsMaxNesting {
+		p.fail("script nested too deeply")
+	}
+}
+
+func (p *jsParser) leave() {
+	p.depth--
+}
+
+func (p *jsParser) statement() jsNode {
+	p.enter()
+	defer p.leave()
+	tok := p.peek()
+	if tok.kind == jsTokIdent && p.peekAt(1).kind == jsTokPunct && p.peekAt(1).text == ":" {
+		p.pos += 2
+		return &jsLabeled{label: tok.text, body: p.statement()}
+	}
+	if tok.kind == jsTokPunct {
+		switch tok.text {
+		case "{":
+			return p.block()
+		case ";":
+			p.pos++
+			return &jsEmpty{}
+		}
+	}
+	if tok.kind != jsTokKeyword {
+		return p.expressionStatement()
+	}
+
+	switch tok.text {
+	case "var", "let", "const":
+		p.pos++
+		decl := p.varDeclarationList(tok.text)
+		p.semicolon()
+		return decl
+	case "function":
+		p.pos++
+		fn := p.function(true)
+		return &jsFuncDecl{fn: fn}
+	case "if":
+		p.pos++
+		p.expect("(")
+		stmt := &jsIf{test: p.expression()}
+		p.expect(")")
+		stmt.cons = p.statement()
+		if p.accept("else") {
+			stmt.alt = p.statement()
+		}
+		return stmt
+	case "for":
+		return p.forStatement()
+	case "while":
+		p.pos++
+		p.expect("(")
+		stmt := &jsWhile{test: p.expression()}
+		p.expect(")")
+		stmt.body = p.statement()
+		return stmt
+	case "do":
+		p.pos++
+		stmt := &jsDoWhile{body: p.statement()}
+		p.expect("while")
+		p.expect("(")
+		stmt.test = p.expression()
+		p.expect(")")
+		p.accept(";")
+		return stmt
+	case "return":
+		p.pos++
+		stmt := &jsReturn{}
+		if !p.is(";") && !p.is("}") && !p.peek().nl && p.peek().kind != jsTokEOF {
+			stmt.x = p.expression()
+		}
+		p.semicolon()
+		return stmt
+	case "break", "continue":
+		p.pos++
+		label := ""
+		if p.peek().kind == jsTokIdent && !p.peek().nl {
+			label = p.next().text
+		}
+		p.semicolon()
+		if tok.text == "break" {
+			return &jsBreak{label: label}
+		}
+		return &jsContinue{label: label}
+	case "throw":
+		p.pos++
+		if p.peek().nl {
+			p.fail("illegal newline after throw")
+		}
+		stmt := &jsThrow{x: p.expression()}
+		p.semicolon()
+		return stmt
+	case "try":
+		p.pos++
+		stmt := &jsTry{block: p.block()}
+		if p.accept("catch") {
+			if p.accept("(") {
+				stmt.param = p.identifier()
+				p.expect(")")
+			}
+			stmt.handler = p.block()
+		}
+		if p.accept("finally") {
+			stmt.finalizer = p.block()
+		}
+		if stmt.handler == nil && stmt.finalizer == nil {
+			p.fail("missing catch or finally after try")
+		}
+		return stmt
+	case "switch":
+		p.pos++
+		p.expect("(")
+		stmt := &jsSwitch{disc: p.expression()}
+		p.expect(")")
+		p.expect("{")
+		for !p.accept("}") {
+			var c jsCase
+			if p.accept("default") {
+				p.expect(":")
+			} else {
+				p.expect("case")
+				c.test = p.expression()
+				p.expect(":")
+			}
+			for !p.is("case") && !p.is("default") && !p.is("}") {
+				if p.peek().kind == jsTokEOF {
+					p.fail("unterminated switch")
+				}
+				c.body = append(c.body, p.statement())
+			}
+			stmt.cases = append(stmt.cases, c)
+		}
+		return stmt
+	}
+	return p.expressionStatement()
+}
+
+func (p *jsParser) expressionStatement() jsNode {
+	x := p.expression()
+	p.semicolon()
+	return &jsExprStmt{x: x}
+}
+
+func (p *jsParser) block() *jsBlock {
+	p.expect("{")
+	b := &jsBlock{}
+	for !p.accept("}") {
+		if p.peek().kind == jsTokEOF {
+			p.fail("unterminated block")
+		}
+		b.body = append(b.body, p.statement())
+	}
+	return b
+}
+
+func (p *jsParser) varDeclarationList(kind string) *jsVarDecl {
+	decl := &jsVarDecl{kind: kind}
+	for {
+		d := jsDeclarator{name: p.identifier()}
+		if kind == "var" {
+			p.declareVar(d.name)
+		}
+		if p.accept("=") {
+			d.init = p.assignment()
+		}
+		decl.decls = append(decl.decls, d)
+		if !p.accept(",") {
+			return decl
+		}
+	}
+}
+
+func (p *jsParser) forStatement() jsNode {
+	p.pos++
+	p.expect("(")
+
+	var init jsNode
+	tok := p.peek()
+	isDecl := tok.kind == jsTokKeyword && (tok.text == "var" || tok.text == "let" || tok.text == "const")
+
+	if isDecl {
+		p.pos++
+		kind := tok.text
+		name := p.identifier()
+		if p.is("in") || p.peek().kind == jsTokIdent && p.peek().text == "of" {
+			of := p.next().text == "of"
+			if kind == "var" {
+				p.declareVar(name)
+			}
+			stmt := &jsForIn{kind: kind, name: name, of: of}
+			stmt.object = p.expression()
+			p.expect(")")
+			stmt.body = p.statement()
+			return stmt
+		}
+		p.pos--
+		p.noIn = true
+		init = p.varDeclarationList(kind)
+		p.noIn = false
+	} else if !p.is(";") {
+		start := p.pos
+		x := p.leftHandSide()
+		if p.is("in") || p.peek().kind == jsTokIdent && p.peek().text == "of" {
+			of := p.next().text == "of"
+			stmt := &jsForIn{target: x, of: of}
+			stmt.object = p.expression()
+			p.expect(")")
+			stmt.body = p.statement()
+			return stmt
+		}
+		p.pos = start
+		p.noIn = true
+		init = &jsExprStmt{x: p.expression()}
+		p.noIn = false
+	}
+
+	stmt := &jsFor{init: init}
+	p.expect(";")
+	if !p.is(";") {
+		stmt.test = p.expression()
+	}
+	p.expect(";")
+	if !p.is(")") {
+		stmt.update = p.expression()
+	}
+	p.expect(")")
+	stmt.body = p.statement()
+	return stmt
+}
+
+// function parses the remainder of a function after the keyword
+func (p *jsParser) function(declaration bool) *jsFuncLit {
+	fn := &jsFuncLit{}
+	if p.peek().kind == jsTokIdent {
+		fn.name = p.next().text
+	} else if declaration {
+		p.fail("function name expected")
+	}
+
+	p.expect("(")
+	for !p.accept(")") {
+		if p.accept("...") {
+			fn.rest = p.identifier()
+			p.expect(")")
+			break
+		}
+		fn.params = append(fn.params, p.identifier())
+		if !p.is(")") {
+			p.expect(",")
+		}
+	}
+	p.functionBody(fn)
+	return fn
+}
+
+func (p *jsParser) functionBody(fn *jsFuncLit) {
+	p.funcs = append(p.funcs, fn)
+	defer func() { p.funcs = p.funcs[:len(p.funcs)-1] }()
+
+	noIn := p.noIn
+	p.noIn = false
+	defer func() { p.noIn = noIn }()
+
+	p.expect("{")
+	for !p.accept("}") {
+		if p.peek().kind == jsTokEOF {
+			p.fail("unterminated function body")
+		}
+		fn.body = append(fn.body, p.statement())
+	}
+}
+
+func (p *jsParser) expression() jsNode {
+	first := p.assignment()
+	if !p.is(",") {
+		return first
+	}
+	seq := &jsSequence{list: []jsNode{first}}
+	for p.accept(",") {
+		seq.list = append(seq.list, p.assignment())
+	}
+	return seq
+}
+
+// arrowFunction tries to parse an arrow function at the current position
+func (p *jsParser) arrowFunction() *jsFuncLit {
+	start := p.pos
+	fn := &jsFuncLit{arrow: true}
+
+	if p.peek().kind == jsTokIdent && p.peekAt(1).kind == jsTokPunct && p.peekAt(1).text == "=>" {
+		fn.params = []string{p.next().text}
+	} else if p.is("(") {
+		p.pos++
+		for !p.accept(")") {
+			if p.accept("...") {
+				if p.peek().kind != jsTokIdent {
+					p.pos = start
+					return nil
+				}
+				fn.rest = p.next().text
+				if !p.accept(")") {
+					p.pos = start
+					return nil
+				}
+				break
+			}
+			if p.peek().kind != jsTokIdent {
+				p.pos = start
+				return nil
+			}
+			fn.params = append(fn.params, p.next().text)
+			if !p.is(")") && !p.accept(",") {
+				p.pos = start
+				return nil
+			}
+		}
+		if !p.is("=>") || p.peek().nl {
+			p.pos = start
+			return nil
+		}
+	} else {
+		return nil
+	}
+
+	p.expect("=>")
+	if p.is("{") {
+		p.functionBody(fn)
+	} else {
+		p.funcs = append(p.funcs, fn)
+		fn.exprBody = p.assignment()
+		p.funcs = p.funcs[:len(p.funcs)-1]
+	}
+	return fn
+}
+
+func (p *jsParser) assignment() jsNode {
+	p.enter()
+	defer p.leave()
+	if fn := p.arrowFunction(); fn != nil {
+		return fn
+	}
+	return p.assignmentRest(p.conditional())
+}
+
+// assignmentRest parses an assignment operator following target, if any
+func (p *jsParser) assignmentRest(target jsNode) jsNode {
+	tok := p.peek()
+	if tok.kind != jsTokPunct {
+		return target
+	}
+	switch tok.text {
+	case "=", "+=", "-=", "*=", "/=", "%=", "**=", "<<=", ">>=", ">>>=", "&=", "|=", "^=":
+		switch target.(type) {
+		case *jsIdent, *jsMember:
+		default:
+			p.fail("invalid assignment target")
+		}
+		p.pos++
+		return &jsAssign{op: tok.text, target: target, value: p.assignment()}
+	}
+	return target
+}
+
+func (p *jsParser) conditional() jsNode {
+	test := p.binary(0)
+	if !p.accept("?") {
+		return test
+	}
+	noIn := p.noIn
+	p.noIn = false
+	cons := p.assignment()
+	p.noIn = noIn
+	p.expect(":")
+	return &jsConditional{test: test, cons: cons, alt: p.assignment()}
+}
+
+// jsBinaryPrecedence gives the precedence of binary operators
+var jsBinaryPrecedence = map[string]int{
+	"??": 1,
+	"||": 2,
+	"&&": 3,
+	"|":  4,
+	"^":  5,
+	"&":  6,
+	"==": 7, "!=": 7, "===": 7, "!==": 7,
+	"<": 8, ">": 8, "<=": 8, ">=": 8, "instanceof": 8, "in": 8,
+	"<<": 9, ">>": 9, ">>>": 9,
+	"+": 10, "-": 10,
+	"*": 11, "/": 11, "%": 11,
+	"**": 12,
+}
+
+// binary parses binary operators by precedence climbing
+func (p *jsParser) binary(minPrec int) jsNode {
+	left := p.unary()
+	for {
+		tok := p.peek()
+		if tok.kind != jsTokPunct && tok.kind != jsTokKeyword {
+			return left
+		}
+		prec, ok := jsBinaryPrecedence[tok.text]
+		if !ok || prec <= minPrec || tok.text == "in" && p.noIn {
+			return left
+		}
+		p.pos++
+
+		var right jsNode
+		if tok.text == "**" {
+			right = p.binary(prec - 1) // right associative
+		} else {
+			right = p.binary(prec)
+		}
+
+		switch tok.text {
+		case "&&", "||", "??":
+			left = &jsLogical{op: tok.text, l: left, r: right}
+		default:
+			left = &jsBinary{op: tok.text, l: left, r: right}
+		}
+	}
+}
+
+func (p *jsParser) unary() jsNode {
+	p.enter()
+	defer p.leave()
+	tok := p.peek()
+	if tok.kind == jsTokPunct || tok.kind == jsTokKeyword {
+		switch tok.text {
+		case "!", "-", "+", "~", "typeof", "void", "delete":
+			p.pos++
+			return &jsUnary{op: tok.text, x: p.unary()}
+		case "++", "--":
+			p.pos++
+			x := p.unary()
+			switch x.(type) {
+			case *jsIdent, *jsMember:
+			default:
+				p.fail("invalid increment operand")
+			}
+			return &jsUpdate{op: tok.text, prefix: true, x: x}
+		}
+	}
+
+	x := p.leftHandSide()
+	if next := p.peek(); next.kind == jsTokPunct && (next.text == "++" || next.text == "--") && !next.nl {
+		switch x.(type) {
+		case *jsIdent, *jsMember:
+			p.pos++
+			return &jsUpdate{op: next.text, x: x}
+		}
+	}
+	return x
+}
+
+// leftHandSide parses member access, calls and new expressions
+func (p *jsParser) leftHandSide() jsNode {
+	var x jsNode
+	if p.accept("new") {
+		if p.is(".") {
+			p.fail("new.target is not supported")
+		}
+		callee := p.memberOnly(p.primary())
+		n := &jsNew{callee: callee}
+		if p.is("(") {
+			n.args = p.arguments()
+		}
+		x = n
+	} else {
+		x = p.primary()
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			x = &jsMember{object: x, name: p.propertyName()}
+		case p.accept("?."):
+			if p.is("(") {
+				x = &jsCall{callee: x, args: p.arguments(), optional: true}
+			} else if p.accept("[") {
+				x = &jsMember{object: x, computed: p.expression(), optional: true}
+				p.expect("]")
+			} else {
+				x = &jsMember{object: x, name: p.propertyName(), optional: true}
+			}
+		case p.is("["):
+			p.pos++
+			noIn := p.noIn
+			p.noIn = false
+			x = &jsMember{object: x, computed: p.expression()}
+			p.noIn = noIn
+			p.expect("]")
+		case p.is("("):
+			x = &jsCall{callee: x, args: p.arguments()}
+		default:
+			return x
+		}
+	}
+}
+
+// memberOnly parses member accesses without calls (for new expressions)
+func (p *jsParser) memberOnly(x jsNode) jsNode {
+	for {
+		switch {
+		case p.accept("."):
+			x = &jsMember{object: x, name: p.propertyName()}
+		case p.accept("["):
+			x = &jsMember{object: x, computed: p.expression()}
+			p.expect("]")
+		default:
+			return x
+		}
+	}
+}
+
+// propertyName parses a property name after a dot; reserved words are allowed
+func (p *jsParser) propertyName() string {
+	tok := p.next()
+	if tok.kind != jsTokIdent && tok.kind != jsTokKeyword {
+		p.pos--
+		p.fail("unexpected %q after '.'", p.describe(tok))
+	}
+	return tok.text
+}
+
+func (p *jsParser) arguments() []jsNode {
+	p.expect("(")
+	noIn := p.noIn
+	p.noIn = false
+	defer func() { p.noIn = noIn }()
+
+	var args []jsNode
+	for !p.accept(")") {
+		if p.accept("...") {
+			args = append(args, &jsSpread{arg: p.assignment()})
+		} else {
+			args = append(args, p.assignment())
+		}
+		if !p.is(")") {
+			p.expect(",")
+		}
+	}
+	return args
+}
+
+func (p *jsParser) primary() jsNode {
+	tok := p.next()
+	switch tok.kind {
+	case jsTokNumber:
+		return &jsNumberLit{value: tok.num}
+	case jsTokString:
+		return &jsStringLit{value: tok.text}
+	case jsTokRegExp:
+		return &jsRegExpLit{pattern: tok.text, flags: tok.flags}
+	case jsTokIdent:
+		return &jsIdent{name: tok.text}
+	case jsTokKeyword:
+		switch tok.text {
+		case "this":
+			return &jsThisExpr{}
+		case "null":
+			return &jsNullLit{}
+		case "true":
+			return &jsBoolLit{value: true}
+		case "false":
+			return &jsBoolLit{value: false}
+		case "function":
+			return p.function(false)
+		}
+	case jsTokPunct:
+		switch tok.text {
+		case "(":
+			noIn := p.noIn
+			p.noIn = false
+			x := p.expression()
+			p.noIn = noIn
+			p.expect(")")
+			return x
+		case "[":
+			return p.arrayLiteral()
+		case "{":
+			return p.objectLiteral()
+		}
+	}
+	p.pos--
+	p.fail("unexpected %q", p.describe(tok))
+	return nil
+}
+
+func (p *jsParser) arrayLiteral() jsNode {
+	noIn := p.noIn
+	p.noIn = false
+	defer func() { p.noIn = noIn }()
+
+	arr := &jsArrayLit{}
+	for !p.accept("]") {
+		if p.is(",") {
+			p.pos++
+			arr.elems = append(arr.elems, nil)
+			continue
+		}
+		if p.accept("...") {
+			arr.elems = append(arr.elems, &jsSpread{arg: p.assignment()})
+		} else {
+			arr.elems = append(arr.elems, p.assignment())
+		}
+		if !p.is("]") {
+			p.expect(",")
+		}
+	}
+	return arr
+}
+
+func (p *jsParser) objectLiteral() jsNode {
+	noIn := p.noIn
+	p.noIn = false
+	defer func() { p.noIn = noIn }()
+
+	obj := &jsObjectLit{}
+	for !p.accept("}") {
+		prop := jsProperty{kind: "init"}
+		tok := p.next()
+
+		// Accessor properties
+		if tok.kind == jsTokIdent && (tok.text == "get" || tok.text == "set") && !p.is(":") && !p.is("(") && !p.is(",") && !p.is("}") {
+			prop.kind = tok.text
+			tok = p.next()
+		}
+
+		switch tok.kind {
+		case jsTokIdent, jsTokKeyword, jsTokString:
+			prop.key = tok.text
+		case jsTokNumber:
+			prop.key = jsNumberToString(tok.num)
+		case jsTokPunct:
+			if tok.text != "[" {
+				p.pos--
+				p.fail("unexpected %q in object literal", p.describe(tok))
+			}
+			prop.computed = p.assignment()
+			p.expect("]")
+		default:
+			p.pos--
+			p.fail("unexpected %q in object literal", p.describe(tok))
+		}
+
+		switch {
+		case prop.kind != "init" || p.is("("):
+			// Method or accessor
+			fn := &jsFuncLit{name: prop.key}
+			p.expect("(")
+			for !p.accept(")") {
+				fn.params = append(fn.params, p.identifier())
+				if !p.is(")") {
+					p.expect(",")
+				}
+			}
+			p.functionBody(fn)
+			prop.value = fn
+		case p.accept(":"):
+			prop.value = p.assignment()
+		case tok.kind == jsTokIdent:
+			// Shorthand property
+			prop.value = &jsIdent{name: tok.text}
+		default:
+			p.fail("expected ':' in object literal")
+		}
+
+		obj.props = append(obj.props, prop)
+		if !p.is("}") {
+			p.expect(",")
+		}
+	}
+	return obj
+}
@@ -36,15 +36,24 @@ const (
 
 // Token represents a lexical token
 type Token struct {
-	Type  TokenType
-	Value interface{}
-	Pos   int64
+	Type    TokenType
+	Value   interface{}
+	Pos     int64
+	Keyword bool // a bare keyword rather than a /Name
 }
 
 // Lexer performs lexical analysis on PDF data
 type Lexer struct {
 	reader *bufio.Reader
 	pos    int64
+
+	// data holds the input from offset dataStart on, once it is needed
+	// to find the end of inline image data
+	data      []byte
+	dataStart int64
+	// inlineData is set after an ID keyword: the next token is the
+	// binary data of an inline image
+	inlineData bool
 }
 
 // NewLexer creates a new lexer for the given reader
@@ -57,7 +66,9 @@ func NewLexer(r io.Reader) *Lexer {
 
 // NewLexerFromBytes creates a new lexer from byte slice
 func NewLexerFromBytes(data []byte) *Lexer {
-	return NewLexer(bytes.NewReader(data))
+	l := NewLexer(bytes.NewReader(data))
+	l.data = data
+	return l
 }
 
 // Position returns the current position
@@ -145,6 +156,14 @@ func isDelimiter(b byte) bool {
 
 // NextToken returns the next token
 func (l *Lexer) NextToken() (Token, error) {
+	if l.inlineData {
+		pos := l.pos
+		data, err := l.readInlineImageData(-1)
+		if err != nil {
+			return Token{}, err
+		}
+		return Token{Type: TokenString, Value: data, Pos: pos}, nil
+	}
 	if err := l.skipWhitespace(); err != nil {
 		return Token{}, err
 	}
@@ -196,7 +215,7 @@ func (l *Lexer) NextToken() (Token, error) {
 			return l.readNumber(pos)
 		}
 		if b == '\'' || b == '"' {
-			return Token{Type: TokenName, Value: string(b), Pos: pos}, nil
+			return Token{Type: TokenName, Value: string(b), Pos: pos, Keyword: true}, nil
 		}
 		if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' {
 			l.unreadByte()
@@ -287,7 +306,7 @@ func (l *Lexer) readEscapeSequence() ([]byte, error) {
 				b, _ = l.readByte()
 				octal = append(octal, b)
 			}
-			val, _ := strconv.ParseInt(string(octal), 8, 8)
+			val, _ := strconv.ParseUint(string(octal), 8, 16)
 			return []byte{byte(val)}, nil
 		}
 		// Unknown escape, return as-is
@@ -478,7 +497,8 @@ func (l *Lexer) readKeyword(pos int64) (Token, error) {
 	case "startxref":
 		return Token{Type: TokenStartXRef, Pos: pos}, nil
 	default:
-		return Token{Type: TokenName, Value: keyword, Pos: pos}, nil
+		l.inlineData = keyword == "ID"
+		return Token{Type: TokenName, Value: keyword, Pos: pos, Keyword: true}, nil
 	}
 }
 
@@ -507,6 +527,77 @@ func (l *Lexer) ReadLine() ([]byte, error) {
 	}
 }
 
+// readInlineImageData reads the data of an inline image following its ID
+// keyword and leaves the lexer before the EI keyword. When length is not
+// negative and EI follows that many bytes, the data has exactly that
+// length; otherwise it ends at the first EI that is surrounded by white
+// space and followed by plausible content stream text.
+func (l *Lexer) readInlineImageData(length int) ([]byte, error) {
+	l.inlineData = false
+	if l.data == nil {
+		rest, err := io.ReadAll(l.reader)
+		if err != nil {
+			return nil, err
+		}
+		l.data, l.dataStart = rest, l.pos
+	}
+	buf := l.data
+	start := int(l.pos - l.dataStart)
+	// A single white-space character separates ID from the data
+	if start < len(buf) && isWhitespace(buf[start]) {
+		start++
+	}
+
+	end, next := -1, len(buf)
+	if length >= 0 && start+length <= len(buf) && isInlineImageEnd(buf, start+length) {
+		end = start + length
+		next = end
+		for next < len(buf) && isWhitespace(buf[next]) {
+			next++
+		}
+	} else {
+		for i := start; i+1 < len(buf); i++ {
+			if buf[i] == 'E' && buf[i+1] == 'I' && (i == start || isWhitespace(buf[i-1])) && isInlineImageEnd(buf, i) {
+				end, next = i, i
+				if end > start {
+					end-- // the white space before EI
+				}
+				break
+			}
+		}
+		if end < 0 {
+			end = len(buf)
+		}
+	}
+
+	data := buf[start:end]
+	l.pos = l.dataStart + int64(next)
+	l.reader.Reset(bytes.NewReader(buf[next:]))
+	return data, nil
+}
+
+// isInlineImageEnd reports whether an EI keyword ends inline image data
+// at offset i: the keyword must be delimited and be followed by text
+// rather than binary data
+func isInlineImageEnd(buf []byte, i int) bool {
+	for i < len(buf) && isWhitespace(buf[i]) {
+		i++
+	}
+	if i+2 > len(buf) || buf[i] != 'E' || buf[i+1] != 'I' {
+		return false
+	}
+	rest := buf[i+2:]
+	if len(rest) > 0 && !isWhitespace(rest[0]) && !isDelimiter(rest[0]) {
+		return false
+	}
+	for _, b := range rest[:min(len(rest), 64)] {
+		if !isWhitespace(b) && (b < 0x20 || b > 0x7E) {
+			return false
+		}
+	}
+	return true
+}
+
 // ReadBytes reads n bytes
 func (l *Lexer) ReadBytes(n int) ([]byte, error) {
 	buf := make([]byte, n)
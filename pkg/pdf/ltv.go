@@ -0,0 +1,456 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// oidAttrRevocationInfoArchival is Adobe's signed attribute carrying the
+// revocation data of the signer's chain at signing time
+var oidAttrRevocationInfoArchival = asn1.ObjectIdentifier{1, 2, 840, 113583, 1, 1, 8}
+
+// revocationInfoArchival is the value of adbe-revocationInfoArchival
+type revocationInfoArchival struct {
+	CRL   []asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	OCSP  []asn1.RawValue `asn1:"optional,explicit,tag:1"`
+	Other []asn1.RawValue `asn1:"optional,explicit,tag:2"`
+}
+
+// DSS is the document security store of a document (ISO 32000-2 section
+// 12.8.4.3). It keeps the certificates and revocation data needed to
+// validate the document's signatures without network access.
+type DSS struct {
+	Certs [][]byte // DER encoded certificates
+	OCSPs [][]byte // DER encoded OCSP responses
+	CRLs  [][]byte // DER encoded CRLs
+
+	// VRI holds the validation data of individual signatures, keyed by
+	// the uppercase hexadecimal SHA-1 digest of the signature's /Contents
+	VRI map[string]*VRI
+}
+
+// VRI is the validation-related information of one signature
+type VRI struct {
+	Certs [][]byte
+	OCSPs [][]byte
+	CRLs  [][]byte
+	TU    time.Time // when the data was added, zero if unknown
+}
+
+// LTVOptions configures the validation data added by AddLTV
+type LTVOptions struct {
+	// Certificates, OCSPs and CRLs are added to the store in addition
+	// to the certificates carried by the document's signatures. OCSP
+	// responses and CRLs are DER encoded.
+	Certificates []*x509.Certificate
+	OCSPs        [][]byte
+	CRLs         [][]byte
+
+	// TSA, when set, adds a document timestamp covering the store in
+	// the same update (PAdES B-LTA). Hash is its digest algorithm,
+	// SHA-256 by default.
+	TSA  TimestampAuthority
+	Hash crypto.Hash
+
+	// Time is the /TU entry of the VRI dictionaries, by default the
+	// current time
+	Time time.Time
+}
+
+// DSS returns the document security store, or nil if the document has
+// none
+func (d *Document) DSS() *DSS {
+	dict, ok := resolveDict(d, d.Root.Get("DSS"))
+	if !ok {
+		return nil
+	}
+	dss := &DSS{
+		Certs: d.dssStreams(dict.Get("Certs")),
+		OCSPs: d.dssStreams(dict.Get("OCSPs")),
+		CRLs:  d.dssStreams(dict.Get("CRLs")),
+		VRI:   make(map[string]*VRI),
+	}
+	if vris, ok := resolveDict(d, dict.Get("VRI")); ok {
+		for key, obj := range vris {
+			vd, ok := resolveDict(d, obj)
+			if !ok {
+				continue
+			}
+			vri := &VRI{
+				Certs: d.dssStreams(vd.Get("Cert")),
+				OCSPs: d.dssStreams(vd.Get("OCSP")),
+				CRLs:  d.dssStreams(vd.Get("CRL")),
+			}
+			if s, ok := vd.Get("TU").(String); ok {
+				vri.TU = parsePDFDate(string(s.Value))
+			}
+			dss.VRI[strings.ToUpper(string(key))] = vri
+		}
+	}
+	return dss
+}
+
+// dssStreams returns the decoded streams of a DSS array
+func (d *Document) dssStreams(obj Object) [][]byte {
+	arr, _ := resolveArray(d, obj)
+	var out [][]byte
+	for _, item := range arr {
+		if ref, ok := item.(Reference); ok {
+			resolved, err := d.ResolveObject(ref)
+			if err != nil {
+				continue
+			}
+			item = resolved
+		}
+		stream, ok := item.(Stream)
+		if !ok {
+			continue
+		}
+		if data, err := stream.Decode(); err == nil {
+			out = append(out, data)
+		}
+	}
+	return out
+}
+
+// vriKeys returns the keys under which a VRI dictionary of the signature
+// may be stored. Writers differ on whether the zero padding of /Contents
+// is part of the digest, so both forms are returned.
+func vriKeys(contents []byte) []string {
+	sum := sha1.Sum(contents)
+	keys := []string{strings.ToUpper(hex.EncodeToString(sum[:]))}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(contents, &raw); err == nil && len(rest) > 0 {
+		sum = sha1.Sum(raw.FullBytes)
+		keys = append(keys, strings.ToUpper(hex.EncodeToString(sum[:])))
+	}
+	return keys
+}
+
+// revocationData is the offline validation data available for a
+// signature
+type revocationData struct {
+	certs []*x509.Certificate
+	ocsps [][]byte
+	crls  [][]byte
+}
+
+// addDSS adds the document-wide data of a DSS and that of the
+// signature's VRI dictionary
+func (r *revocationData) addDSS(dss *DSS, contents []byte) {
+	if dss == nil {
+		return
+	}
+	r.add(dss.Certs, dss.OCSPs, dss.CRLs)
+	for _, key := range vriKeys(contents) {
+		if vri := dss.VRI[key]; vri != nil {
+			r.add(vri.Certs, vri.OCSPs, vri.CRLs)
+		}
+	}
+}
+
+// addCMS adds the CRLs of signed data and the revocation data archived
+// in the signer's adbe-revocationInfoArchival attribute
+func (r *revocationData) addCMS(cms *cmsVerified) {
+	if cms == nil {
+		return
+	}
+	r.certs = append(r.certs, cms.certs...)
+	rest := cms.signedData.CRLs.Bytes
+	for len(rest) > 0 {
+		var crl asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &crl); err != nil {
+			break
+		}
+		r.crls = append(r.crls, crl.FullBytes)
+	}
+
+	value := cmsAttributeValue(cms.signed, oidAttrRevocationInfoArchival)
+	if value == nil {
+		return
+	}
+	var archival revocationInfoArchival
+	if _, err := asn1.Unmarshal(value, &archival); err != nil {
+		return
+	}
+	for _, crl := range archival.CRL {
+		r.crls = append(r.crls, crl.FullBytes)
+	}
+	for _, resp := range archival.OCSP {
+		r.ocsps = append(r.ocsps, resp.FullBytes)
+	}
+}
+
+// add adds DER encoded certificates, OCSP responses and CRLs
+func (r *revocationData) add(certs, ocsps, crls [][]byte) {
+	for _, der := range certs {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			r.certs = append(r.certs, cert)
+		}
+	}
+	r.ocsps = append(r.ocsps, ocsps...)
+	r.crls = append(r.crls, crls...)
+}
+
+// status checks every certificate of chain except the trust anchor at
+// its end. A chain is revoked when any certificate was revoked at the
+// given time, good when all were shown to be good, and unknown
+// otherwise. Problems with individual responses are returned as well.
+func (r *revocationData) status(chain []*x509.Certificate, at time.Time) (string, []string) {
+	if len(chain) < 2 {
+		return "unknown", nil
+	}
+	result := "good"
+	var problems []string
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		status := "unknown"
+		for _, der := range r.ocsps {
+			st := CheckOCSPResponse(der, cert, issuer, at)
+			if st == nil {
+				continue
+			}
+			if st.Error != nil {
+				problems = append(problems, fmt.Sprintf("OCSP for %s: %v", cert.Subject.CommonName, st.Error))
+			}
+			if st.Status == "revoked" || st.Status == "good" && status == "unknown" {
+				status = st.Status
+			}
+		}
+		for _, der := range r.crls {
+			st := CheckCRLData(der, cert, issuer, at)
+			if st == nil {
+				continue
+			}
+			switch {
+			case st.IsRevoked:
+				status = "revoked"
+			case st.Error != nil:
+				problems = append(problems, fmt.Sprintf("CRL for %s: %v", cert.Subject.CommonName, st.Error))
+			case status == "unknown":
+				status = "good"
+			}
+		}
+		switch {
+		case status == "revoked":
+			return "revoked", problems
+		case status == "unknown":
+			result = "unknown"
+		}
+	}
+	return result, problems
+}
+
+// AddLTV adds a document security store with the certificates and
+// revocation data needed to validate the document's signatures in the
+// long term (PAdES B-LT), merging it with any existing store. The
+// certificates of all signatures and their timestamps are included
+// automatically; revocation data must be supplied in opts. With a TSA a
+// document timestamp is added as well (PAdES B-LTA). The result is the
+// document with the update appended.
+func (d *Document) AddLTV(opts LTVOptions) ([]byte, error) {
+	if opts.Time.IsZero() {
+		opts.Time = time.Now()
+	}
+	if opts.TSA == nil {
+		w := NewIncrementalWriter(d)
+		if err := d.writeDSS(w, opts); err != nil {
+			return nil, err
+		}
+		return w.Bytes()
+	}
+	return d.writeDocumentTimestamp(opts.TSA, opts.Hash, func(w *IncrementalWriter) error {
+		return d.writeDSS(w, opts)
+	})
+}
+
+// AddDocumentTimestamp adds a document timestamp signature
+// (ETSI.RFC3161) covering the whole document, using hash as the digest
+// algorithm (SHA-256 if zero). It is typically added after AddLTV to
+// protect the validation data (PAdES B-LTA).
+func (d *Document) AddDocumentTimestamp(tsa TimestampAuthority, hash crypto.Hash) ([]byte, error) {
+	return d.writeDocumentTimestamp(tsa, hash, nil)
+}
+
+// writeDocumentTimestamp writes an update with an invisible document
+// timestamp field, calling extra to add other objects to the update
+func (d *Document) writeDocumentTimestamp(tsa TimestampAuthority, hash crypto.Hash, extra func(w *IncrementalWriter) error) ([]byte, error) {
+	if tsa == nil {
+		return nil, fmt.Errorf("sign: no timestamp authority")
+	}
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("sign: digest algorithm %v is not available", hash)
+	}
+	return d.writeSignedUpdate(signedUpdate{
+		sigDict: Dictionary{
+			"Type":      Name("DocTimeStamp"),
+			"Filter":    Name("Adobe.PPKLite"),
+			"SubFilter": Name(SubFilterRFC3161),
+		},
+		hash: hash,
+		value: func(digest []byte) ([]byte, error) {
+			return tsa.Timestamp(digest, hash)
+		},
+		size: 8192,
+		prepare: func(w *IncrementalWriter, sigRef Reference) error {
+			if extra != nil {
+				if err := extra(w); err != nil {
+					return err
+				}
+			}
+			return d.attachSignatureField(w, sigRef, "", 1, Rectangle{}, nil)
+		},
+	})
+}
+
+// writeDSS adds the merged document security store to an update
+func (d *Document) writeDSS(w *IncrementalWriter, opts LTVOptions) error {
+	rootRef, err := w.RootRef()
+	if err != nil {
+		return err
+	}
+	dssObj := d.Root.Get("DSS")
+	dss, _ := resolveDict(d, dssObj)
+	dss = cloneDict(dss)
+	dss["Type"] = Name("DSS")
+
+	// Existing streams are kept and referenced again by identical data
+	refs := make(map[string]Object)
+	lists := make(map[Name]Array)
+	for _, key := range []Name{"Certs", "OCSPs", "CRLs"} {
+		arr, _ := resolveArray(d, dss[key])
+		lists[key] = append(Array(nil), arr...)
+		for _, item := range arr {
+			if data := d.dssStreams(Array{item}); len(data) == 1 {
+				refs[string(key)+string(data[0])] = item
+			}
+		}
+	}
+	add := func(key Name, data []byte) Object {
+		if obj, ok := refs[string(key)+string(data)]; ok {
+			return obj
+		}
+		ref := w.AddObject(newFlateStream(nil, data))
+		refs[string(key)+string(data)] = ref
+		lists[key] = append(lists[key], ref)
+		return ref
+	}
+
+	for _, cert := range opts.Certificates {
+		add("Certs", cert.Raw)
+	}
+	for _, der := range opts.OCSPs {
+		add("OCSPs", der)
+	}
+	for _, der := range opts.CRLs {
+		add("CRLs", der)
+	}
+
+	vris, _ := resolveDict(d, dss.Get("VRI"))
+	vris = cloneDict(vris)
+	for _, sig := range GetSignatures(d) {
+		certs := signatureCertificates(sig)
+		if len(certs) == 0 {
+			continue
+		}
+		certs = append(certs, opts.Certificates...)
+		vri := Dictionary{"TU": String{Value: []byte(formatPDFDate(opts.Time))}}
+		var certRefs, ocspRefs, crlRefs Array
+		for _, cert := range certs {
+			certRefs = append(certRefs, add("Certs", cert.Raw))
+		}
+		for _, der := range opts.OCSPs {
+			if ocspMatches(der, certs) {
+				ocspRefs = append(ocspRefs, add("OCSPs", der))
+			}
+		}
+		for _, der := range opts.CRLs {
+			if crlMatches(der, certs) {
+				crlRefs = append(crlRefs, add("CRLs", der))
+			}
+		}
+		vri["Cert"] = certRefs
+		if len(ocspRefs) > 0 {
+			vri["OCSP"] = ocspRefs
+		}
+		if len(crlRefs) > 0 {
+			vri["CRL"] = crlRefs
+		}
+		vris[Name(vriKeys(sig.SignedData)[0])] = vri
+	}
+	if len(vris) > 0 {
+		dss["VRI"] = vris
+	}
+	for key, arr := range lists {
+		if len(arr) > 0 {
+			dss[key] = arr
+		}
+	}
+
+	if ref, ok := dssObj.(Reference); ok {
+		w.UpdateObject(ref, dss)
+	} else {
+		w.EditDictionary(rootRef)["DSS"] = w.AddObject(dss)
+	}
+	return nil
+}
+
+// signatureCertificates returns the certificates carried by a
+// signature and by the timestamp tokens in it
+func signatureCertificates(sig Signature) []*x509.Certificate {
+	sd, err := parseCMS(sig.SignedData)
+	if err != nil {
+		return sig.Certificates
+	}
+	certs := sd.certificates()
+	for _, si := range sd.SignerInfos {
+		attrs, err := parseCMSAttributes(si.UnsignedAttrs.Bytes)
+		if err != nil {
+			continue
+		}
+		if token := cmsAttributeValue(attrs, oidAttrTimeStampToken); token != nil {
+			if tsd, err := parseCMS(token); err == nil {
+				certs = append(certs, tsd.certificates()...)
+			}
+		}
+	}
+	return certs
+}
+
+// ocspMatches reports whether an OCSP response is about one of certs
+func ocspMatches(der []byte, certs []*x509.Certificate) bool {
+	for _, cert := range certs {
+		if _, err := ocsp.ParseResponseForCert(der, cert, nil); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// crlMatches reports whether a CRL was issued by the issuer of one of
+// certs
+func crlMatches(der []byte, certs []*x509.Certificate) bool {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return false
+	}
+	for _, cert := range certs {
+		if bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+			return true
+		}
+	}
+	return false
+}
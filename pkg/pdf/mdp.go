@@ -0,0 +1,650 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of changes made to a document after it was signed, from the
+// least to the most intrusive
+const (
+	ModificationValidationData = "validation data" // DSS and document timestamps
+	ModificationSignature      = "signature"       // new signatures and signature fields
+	ModificationMetadata       = "metadata"        // document information and XMP metadata
+	ModificationFormFill       = "form fill"       // values and appearances of existing fields
+	ModificationAnnotation     = "annotation"      // annotations and new form fields
+	ModificationPageContent    = "page content"    // pages, their contents and resources
+	ModificationOther          = "other"           // any other change of the document structure
+)
+
+// modificationSeverity orders the kinds of changes
+var modificationSeverity = map[string]int{
+	ModificationValidationData: 1,
+	ModificationSignature:      2,
+	ModificationMetadata:       3,
+	ModificationFormFill:       4,
+	ModificationAnnotation:     5,
+	ModificationPageContent:    6,
+	ModificationOther:          7,
+}
+
+// worseModification returns the more intrusive of two kinds of changes
+func worseModification(a, b string) string {
+	if modificationSeverity[b] > modificationSeverity[a] {
+		return b
+	}
+	return a
+}
+
+// Modification is an object added, changed or removed after a signature
+type Modification struct {
+	ObjectNumber int
+	Change       string // "added", "changed" or "removed"
+	Kind         string // one of the Modification* kinds
+	Field        string // fully qualified name of the form field involved, if any
+	Permitted    bool
+	Reason       string // why the change is not permitted
+}
+
+// ModificationReport lists the changes made after a signature and
+// whether the permissions granted by the signers allow them
+type ModificationReport struct {
+	FieldName     string
+	Revision      int // length of the file revision the signature covers
+	DocMDP        int // permission level of a certification in effect, 0 if none
+	Modifications []Modification
+	Permitted     bool
+}
+
+// fieldLock is a /Lock dictionary or FieldMDP transform. Locked fields
+// may not be changed after the signature.
+type fieldLock struct {
+	action string // All, Include or Exclude
+	fields []string
+}
+
+// locks reports whether the fully qualified field name is locked
+func (l *fieldLock) locks(name string) bool {
+	listed := false
+	for _, f := range l.fields {
+		if name == f || strings.HasPrefix(name, f+".") {
+			listed = true
+			break
+		}
+	}
+	switch l.action {
+	case "All":
+		return true
+	case "Include":
+		return listed
+	case "Exclude":
+		return !listed
+	}
+	return false
+}
+
+// mdpSignature is a signature with the permissions it grants
+type mdpSignature struct {
+	name   string
+	end    int // end of the signed revision
+	docMDP int // P of a DocMDP transform or /Lock, 0 if none
+	lock   *fieldLock
+}
+
+// mdpContext classifies the objects of one revision of a document by
+// what they belong to
+type mdpContext struct {
+	doc        *Document
+	kinds      map[int]string // kind of change to each reachable object
+	fieldNames map[int]string // fully qualified names of fields and widgets
+	sigFields  map[int]bool   // signature fields and their widgets
+	rootNum    int
+	acroNum    int
+	sigs       []mdpSignature
+	certNum    int // signature dictionary of the certification signature
+}
+
+// newMDPContext walks the document from its catalog
+func newMDPContext(doc *Document) *mdpContext {
+	c := &mdpContext{
+		doc:        doc,
+		kinds:      make(map[int]string),
+		fieldNames: make(map[int]string),
+		sigFields:  make(map[int]bool),
+	}
+	if ref, ok := doc.Trailer.Get("Root").(Reference); ok {
+		c.rootNum = ref.ObjectNumber
+		c.kinds[c.rootNum] = ModificationOther
+	}
+	if ref, ok := doc.Trailer.Get("Info").(Reference); ok {
+		c.kinds[ref.ObjectNumber] = ModificationMetadata
+	}
+	c.mark(doc.Root.Get("Metadata"), ModificationMetadata)
+	c.mark(doc.Root.Get("DSS"), ModificationValidationData)
+	if perms, ok := resolveDict(doc, doc.Root.Get("Perms")); ok {
+		if ref, ok := perms.Get("DocMDP").(Reference); ok {
+			c.certNum = ref.ObjectNumber
+		}
+	}
+
+	// Form fields, including signatures and their appearances
+	if ref, ok := doc.Root.Get("AcroForm").(Reference); ok {
+		c.acroNum = ref.ObjectNumber
+		c.kinds[c.acroNum] = ModificationSignature
+	}
+	form := doc.acroForm()
+	fields, _ := resolveArray(doc, form.Get("Fields"))
+	for _, f := range fields {
+		c.walkField(f, "", false)
+	}
+	c.mark(form.Get("DR"), ModificationFormFill)
+
+	// Pages and annotations. Annotations are marked before the pages so
+	// that page resources shared with appearances count as page content.
+	for _, page := range doc.Pages {
+		annots, _ := resolveArray(doc, page.Dictionary.Get("Annots"))
+		for _, a := range annots {
+			ref, ok := a.(Reference)
+			if !ok || c.isField(ref.ObjectNumber) {
+				continue
+			}
+			if dict, ok := resolveDict(doc, ref); ok && dict.Get("FT") == nil && dict.Get("Parent") == nil {
+				c.markExcept(ref, ModificationAnnotation, "P", "Parent", "IRT")
+			}
+		}
+	}
+	c.markExcept(doc.Root.Get("Pages"), ModificationPageContent, "Parent", "Annots")
+	return c
+}
+
+// walkField records a field, its kids and the objects they use
+func (c *mdpContext) walkField(obj Object, parent string, sig bool) {
+	ref, _ := obj.(Reference)
+	if ref.ObjectNumber != 0 {
+		if _, seen := c.kinds[ref.ObjectNumber]; seen {
+			return
+		}
+	}
+	dict, ok := resolveDict(c.doc, obj)
+	if !ok {
+		return
+	}
+	name := parent
+	if t, ok := dict.Get("T").(String); ok {
+		if name != "" {
+			name += "."
+		}
+		name += t.Text()
+	}
+	if ft, ok := dict.GetName("FT"); ok {
+		sig = ft == "Sig"
+	}
+
+	kind := ModificationFormFill
+	if sig {
+		kind = ModificationSignature
+		if v, ok := dict.Get("V").(Reference); ok {
+			value, _ := resolveDict(c.doc, v)
+			c.addSignature(name, dict, value, v.ObjectNumber)
+			if t, _ := value.GetName("Type"); t == "DocTimeStamp" {
+				kind = ModificationValidationData
+			}
+			c.kinds[v.ObjectNumber] = kind
+		}
+	}
+	if ref.ObjectNumber != 0 {
+		c.kinds[ref.ObjectNumber] = kind
+		c.fieldNames[ref.ObjectNumber] = name
+		c.sigFields[ref.ObjectNumber] = sig
+	}
+	c.mark(dict.Get("AP"), kind)
+	c.mark(dict.Get("Lock"), kind)
+	kids, _ := resolveArray(c.doc, dict.Get("Kids"))
+	for _, kid := range kids {
+		c.walkField(kid, name, sig)
+	}
+}
+
+// addSignature records the permissions granted by a signature
+func (c *mdpContext) addSignature(name string, field, value Dictionary, num int) {
+	s := mdpSignature{name: name}
+	br, _ := resolveArray(c.doc, value.Get("ByteRange"))
+	if n := len(br); n >= 2 {
+		start, _ := br[n-2].(Integer)
+		length, _ := br[n-1].(Integer)
+		s.end = int(start + length)
+	}
+	// A /P in the field's lock restricts the document like DocMDP
+	if lock, ok := resolveDict(c.doc, field.Get("Lock")); ok {
+		s.lock = newFieldLock(c.doc, lock)
+		if p, ok := lock.GetInt("P"); ok && p >= 1 && p <= 3 {
+			s.docMDP = int(p)
+		}
+	}
+	refs, _ := resolveArray(c.doc, value.Get("Reference"))
+	for _, r := range refs {
+		ref, _ := resolveDict(c.doc, r)
+		params, _ := resolveDict(c.doc, ref.Get("TransformParams"))
+		switch method, _ := ref.GetName("TransformMethod"); method {
+		case "DocMDP":
+			// Only the signature referenced from /Perms certifies
+			if num != c.certNum {
+				continue
+			}
+			p := 2
+			if v, ok := params.GetInt("P"); ok && v >= 1 && v <= 3 {
+				p = int(v)
+			}
+			if s.docMDP == 0 || p < s.docMDP {
+				s.docMDP = p
+			}
+		case "FieldMDP":
+			if s.lock == nil {
+				s.lock = newFieldLock(c.doc, params)
+			}
+		}
+	}
+	c.sigs = append(c.sigs, s)
+}
+
+// newFieldLock reads the /Action and /Fields of a lock
+func newFieldLock(doc *Document, dict Dictionary) *fieldLock {
+	action, _ := dict.GetName("Action")
+	lock := &fieldLock{action: string(action)}
+	fields, _ := resolveArray(doc, dict.Get("Fields"))
+	for _, f := range fields {
+		if s, ok := f.(String); ok {
+			lock.fields = append(lock.fields, s.Text())
+		}
+	}
+	return lock
+}
+
+// isField reports whether num is a form field or widget
+func (c *mdpContext) isField(num int) bool {
+	_, ok := c.fieldNames[num]
+	return ok
+}
+
+// mark assigns kind to the objects reachable from obj
+func (c *mdpContext) mark(obj Object, kind string) {
+	c.markExcept(obj, kind)
+}
+
+// markExcept assigns kind to the objects reachable from obj without
+// following the given dictionary keys, which lead back up the tree
+func (c *mdpContext) markExcept(obj Object, kind string, skip ...string) {
+	visited := make(map[int]bool)
+	var walk func(obj Object)
+	walk = func(obj Object) {
+		switch v := obj.(type) {
+		case Reference:
+			if visited[v.ObjectNumber] || v.ObjectNumber == c.rootNum {
+				return
+			}
+			visited[v.ObjectNumber] = true
+			if prev, ok := c.kinds[v.ObjectNumber]; !ok || modificationSeverity[kind] > modificationSeverity[prev] {
+				c.kinds[v.ObjectNumber] = kind
+			}
+			resolved, err := c.doc.ResolveObject(v)
+			if err == nil {
+				walk(resolved)
+			}
+		case Dictionary:
+			for key, value := range v {
+				if !containsString(skip, string(key)) {
+					walk(value)
+				}
+			}
+		case Stream:
+			walk(v.Dictionary)
+		case Array:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(obj)
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AnalyzeModifications compares the revision covered by each signature
+// with the final document. Every object added, changed or removed later
+// is classified and checked against the DocMDP permission level of a
+// certification signature and the field locks of the signatures made
+// up to that revision. Without a certification annotations, form
+// filling and further signatures are permitted, as viewers do.
+func (v *SignatureValidator) AnalyzeModifications() []ModificationReport {
+	final := newMDPContext(v.doc)
+	var reports []ModificationReport
+	for _, sig := range GetSignatures(v.doc) {
+		if report, ok := v.analyzeModifications(final, sig); ok {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+// analyzeModifications reports the changes made after one signature
+func (v *SignatureValidator) analyzeModifications(final *mdpContext, sig Signature) (ModificationReport, bool) {
+	br := sig.ByteRange
+	if len(br) < 2 {
+		return ModificationReport{}, false
+	}
+	end := br[len(br)-2] + br[len(br)-1]
+	if end <= 0 || end > len(v.doc.data) {
+		return ModificationReport{}, false
+	}
+	report := ModificationReport{FieldName: sig.FieldName, Revision: end, Permitted: true}
+
+	// Permissions granted by the signatures of this revision and earlier
+	var locks []mdpSignature
+	for _, s := range final.sigs {
+		if s.end > 0 && s.end <= end {
+			if s.docMDP > 0 && (report.DocMDP == 0 || s.docMDP < report.DocMDP) {
+				report.DocMDP = s.docMDP
+			}
+			if s.lock != nil {
+				locks = append(locks, s)
+			}
+		}
+	}
+
+	if len(bytes.TrimRight(v.doc.data[end:], "\r\n\t\f\x00 ")) == 0 {
+		return report, true
+	}
+	revDoc, err := NewDocument(v.doc.data[:end])
+	if err != nil {
+		report.Permitted = false
+		report.Modifications = append(report.Modifications, Modification{
+			Change: "changed",
+			Kind:   ModificationOther,
+			Reason: fmt.Sprintf("signed revision cannot be read: %v", err),
+		})
+		return report, true
+	}
+	rev := newMDPContext(revDoc)
+
+	nums := make(map[int]bool)
+	for num := range v.doc.xref {
+		nums[num] = true
+	}
+	for num := range revDoc.xref {
+		nums[num] = true
+	}
+	sorted := make([]int, 0, len(nums))
+	for num := range nums {
+		sorted = append(sorted, num)
+	}
+	sort.Ints(sorted)
+
+	for _, num := range sorted {
+		m, ok := classifyModification(final, rev, num)
+		if !ok {
+			continue
+		}
+		m.Permitted, m.Reason = modificationPermitted(m, report.DocMDP, locks)
+		if !m.Permitted {
+			report.Permitted = false
+		}
+		report.Modifications = append(report.Modifications, m)
+	}
+	return report, true
+}
+
+// classifyModification compares object num in the final document and
+// the signed revision. Objects that did not change, and objects that
+// are not used by either revision, are skipped.
+func classifyModification(final, rev *mdpContext, num int) (Modification, bool) {
+	m := Modification{ObjectNumber: num}
+	newEntry, inFinal := final.doc.xref[num]
+	oldEntry, inRev := rev.doc.xref[num]
+	inFinal = inFinal && newEntry.InUse
+	inRev = inRev && oldEntry.InUse
+	switch {
+	case inFinal && inRev:
+		if newEntry == oldEntry {
+			return m, false
+		}
+		m.Change = "changed"
+	case inFinal:
+		m.Change = "added"
+	case inRev:
+		m.Change = "removed"
+	default:
+		return m, false
+	}
+
+	var newObj, oldObj Object
+	if inFinal {
+		newObj, _ = final.doc.GetObject(num)
+	}
+	if inRev {
+		oldObj, _ = rev.doc.GetObject(num)
+	}
+	if isStructuralStream(newObj) || isStructuralStream(oldObj) {
+		return m, false
+	}
+	if m.Change == "changed" && bytes.Equal(serializeObject(newObj), serializeObject(oldObj)) {
+		return m, false
+	}
+
+	ctx := final
+	if m.Change == "removed" {
+		ctx = rev
+	}
+	kind, used := ctx.kinds[num]
+	if !used {
+		kind, used = rev.kinds[num]
+	}
+	if !used {
+		return m, false
+	}
+	m.Field = ctx.fieldNames[num]
+	if m.Field == "" {
+		m.Field = rev.fieldNames[num]
+	}
+
+	newDict, _ := newObj.(Dictionary)
+	oldDict, _ := oldObj.(Dictionary)
+	switch {
+	case num == final.rootNum:
+		kind = catalogChange(final, rev, newDict, oldDict)
+	case num == final.acroNum && m.Change == "changed":
+		kind = acroFormChange(final, rev, newDict, oldDict)
+	case final.isPage(num) && m.Change == "changed":
+		kind = pageChange(final, rev, newDict, oldDict)
+	case ctx.isField(num) && !ctx.sigFields[num]:
+		// Adding or removing fields, and changes beyond the value and
+		// appearance, alter the form rather than fill it in
+		if m.Change != "changed" || !onlyKeysDiffer(newDict, oldDict, "V", "AS", "AP", "DV", "MK") {
+			kind = ModificationAnnotation
+		}
+	}
+	m.Kind = kind
+	return m, true
+}
+
+// isStructuralStream reports whether obj is a cross-reference or object
+// stream, which every update may rewrite
+func isStructuralStream(obj Object) bool {
+	s, ok := obj.(Stream)
+	if !ok {
+		return false
+	}
+	t, _ := s.Dictionary.GetName("Type")
+	return t == "XRef" || t == "ObjStm"
+}
+
+// isPage reports whether num is a page object
+func (c *mdpContext) isPage(num int) bool {
+	for _, page := range c.doc.Pages {
+		if page.ref.ObjectNumber == num {
+			return true
+		}
+	}
+	return false
+}
+
+// changedKeys returns the keys whose values differ between a and b
+func changedKeys(a, b Dictionary) []string {
+	var keys []string
+	for key, value := range a {
+		if other, ok := b[key]; !ok || !bytes.Equal(serializeObject(value), serializeObject(other)) {
+			keys = append(keys, string(key))
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, string(key))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// onlyKeysDiffer reports whether a and b differ at most in the given keys
+func onlyKeysDiffer(a, b Dictionary, keys ...string) bool {
+	for _, key := range changedKeys(a, b) {
+		if !containsString(keys, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// catalogChange classifies a change of the document catalog by the
+// entries that changed
+func catalogChange(final, rev *mdpContext, newDict, oldDict Dictionary) string {
+	kind := ModificationValidationData
+	for _, key := range changedKeys(newDict, oldDict) {
+		switch key {
+		case "DSS", "Extensions":
+		case "AcroForm":
+			newForm, newDirect := newDict.Get("AcroForm").(Dictionary)
+			oldForm, oldDirect := oldDict.Get("AcroForm").(Dictionary)
+			if newDirect && oldDirect {
+				kind = worseModification(kind, acroFormChange(final, rev, newForm, oldForm))
+			} else {
+				kind = worseModification(kind, ModificationSignature)
+			}
+		case "Perms":
+			kind = worseModification(kind, ModificationSignature)
+		case "Metadata":
+			kind = worseModification(kind, ModificationMetadata)
+		default:
+			kind = worseModification(kind, ModificationOther)
+		}
+	}
+	return kind
+}
+
+// acroFormChange classifies a change of the interactive form dictionary
+// by the fields added or removed. Fields other than signature fields
+// change the form.
+func acroFormChange(final, rev *mdpContext, newDict, oldDict Dictionary) string {
+	kind := ModificationValidationData
+	for _, key := range changedKeys(newDict, oldDict) {
+		switch key {
+		case "SigFlags":
+			kind = worseModification(kind, ModificationSignature)
+		case "Fields":
+			for _, num := range addedRefs(final.doc, newDict.Get("Fields"), oldDict.Get("Fields")) {
+				kind = worseModification(kind, final.annotationKind(num))
+			}
+			for _, num := range addedRefs(rev.doc, oldDict.Get("Fields"), newDict.Get("Fields")) {
+				kind = worseModification(kind, rev.annotationKind(num))
+			}
+		case "NeedAppearances", "DA", "DR":
+			kind = worseModification(kind, ModificationFormFill)
+		default:
+			kind = worseModification(kind, ModificationOther)
+		}
+	}
+	return kind
+}
+
+// pageChange classifies a change of a page object. A change limited to
+// /Annots is classified by the annotations added or removed.
+func pageChange(final, rev *mdpContext, newDict, oldDict Dictionary) string {
+	if !onlyKeysDiffer(newDict, oldDict, "Annots") {
+		return ModificationPageContent
+	}
+	kind := ModificationValidationData
+	for _, num := range addedRefs(final.doc, newDict.Get("Annots"), oldDict.Get("Annots")) {
+		kind = worseModification(kind, final.annotationKind(num))
+	}
+	for _, num := range addedRefs(rev.doc, oldDict.Get("Annots"), newDict.Get("Annots")) {
+		kind = worseModification(kind, rev.annotationKind(num))
+	}
+	return kind
+}
+
+// annotationKind is the kind of change of adding or removing an
+// annotation or field: signature fields and their widgets belong to the
+// signature
+func (c *mdpContext) annotationKind(num int) string {
+	if c.sigFields[num] {
+		return c.kinds[num]
+	}
+	return ModificationAnnotation
+}
+
+// addedRefs returns the object numbers referenced by array a but not b
+func addedRefs(doc *Document, a, b Object) []int {
+	old := make(map[int]bool)
+	arr, _ := resolveArray(doc, b)
+	for _, item := range arr {
+		if ref, ok := item.(Reference); ok {
+			old[ref.ObjectNumber] = true
+		}
+	}
+	var added []int
+	arr, _ = resolveArray(doc, a)
+	for _, item := range arr {
+		if ref, ok := item.(Reference); ok && !old[ref.ObjectNumber] {
+			added = append(added, ref.ObjectNumber)
+		}
+	}
+	return added
+}
+
+// modificationPermitted checks a change against the DocMDP level and
+// the field locks in effect
+func modificationPermitted(m Modification, docMDP int, locks []mdpSignature) (bool, string) {
+	switch m.Kind {
+	case ModificationValidationData:
+		return true, ""
+	case ModificationSignature, ModificationMetadata, ModificationFormFill:
+		if docMDP == 1 {
+			return false, fmt.Sprintf("%s change not permitted by certification level 1", m.Kind)
+		}
+	case ModificationAnnotation:
+		if docMDP == 1 || docMDP == 2 {
+			return false, fmt.Sprintf("annotation change not permitted by certification level %d", docMDP)
+		}
+	case ModificationPageContent:
+		return false, "page content changed after signing"
+	default:
+		return false, "document structure changed after signing"
+	}
+	if m.Field != "" && (m.Kind == ModificationFormFill || m.Kind == ModificationAnnotation) {
+		for _, s := range locks {
+			if s.lock.locks(m.Field) {
+				return false, fmt.Sprintf("field %q is locked by signature %q", m.Field, s.name)
+			}
+		}
+	}
+	return true, ""
+}
@@ -0,0 +1,245 @@
+package pdf
+
+// mqState is an entry of the MQ coder probability estimation table
+// (ITU-T T.800 Table C.2, identical to ITU-T T.88 Table E.1)
+type mqState struct {
+	qe   uint32
+	nmps uint8
+	nlps uint8
+	swap bool
+}
+
+var mqStates = [47]mqState{
+	{0x5601, 1, 1, true}, {0x3401, 2, 6, false}, {0x1801, 3, 9, false},
+	{0x0AC1, 4, 12, false}, {0x0521, 5, 29, false}, {0x0221, 38, 33, false},
+	{0x5601, 7, 6, true}, {0x5401, 8, 14, false}, {0x4801, 9, 14, false},
+	{0x3801, 10, 14, false}, {0x3001, 11, 17, false}, {0x2401, 12, 18, false},
+	{0x1C01, 13, 20, false}, {0x1601, 29, 21, false}, {0x5601, 15, 14, true},
+	{0x5401, 16, 14, false}, {0x5101, 17, 15, false}, {0x4801, 18, 16, false},
+	{0x3801, 19, 17, false}, {0x3401, 20, 18, false}, {0x3001, 21, 19, false},
+	{0x2801, 22, 19, false}, {0x2401, 23, 20, false}, {0x2201, 24, 21, false},
+	{0x1C01, 25, 22, false}, {0x1801, 26, 23, false}, {0x1601, 27, 24, false},
+	{0x1401, 28, 25, false}, {0x1201, 29, 26, false}, {0x1101, 30, 27, false},
+	{0x0AC1, 31, 28, false}, {0x09C1, 32, 29, false}, {0x08A1, 33, 30, false},
+	{0x0521, 34, 31, false}, {0x0441, 35, 32, false}, {0x02A1, 36, 33, false},
+	{0x0221, 37, 34, false}, {0x0141, 38, 35, false}, {0x0111, 39, 36, false},
+	{0x0085, 40, 37, false}, {0x0049, 41, 38, false}, {0x0025, 42, 39, false},
+	{0x0015, 43, 40, false}, {0x0009, 44, 41, false}, {0x0005, 45, 42, false},
+	{0x0001, 45, 43, false}, {0x5601, 46, 46, false},
+}
+
+// mqContext is the adaptive state of one context: the index into
+// mqStates shifted left by one, with the more probable symbol in bit 0
+type mqContext uint8
+
+// newMQContext returns a context starting at the given state index
+func newMQContext(index int) mqContext {
+	return mqContext(index << 1)
+}
+
+// mqDecoder is the MQ arithmetic decoder shared by JPEG 2000 and JBIG2
+// (ITU-T T.800 Annex C, software conventions of Figures C.19 to C.21)
+type mqDecoder struct {
+	data []byte
+	pos  int // index of the byte last read into c
+	a    uint32
+	c    uint32
+	ct   int
+}
+
+// newMQDecoder initialises a decoder on data (INITDEC)
+func newMQDecoder(data []byte) *mqDecoder {
+	d := &mqDecoder{data: data}
+	d.c = uint32(d.byteAt(0)) << 16
+	d.byteIn()
+	d.c <<= 7
+	d.ct -= 7
+	d.a = 0x8000
+	return d
+}
+
+// byteAt returns the byte at i, or 0xFF past the end of the data so that
+// the decoder behaves as if a marker followed
+func (d *mqDecoder) byteAt(i int) byte {
+	if i < len(d.data) {
+		return d.data[i]
+	}
+	return 0xFF
+}
+
+// byteIn reads the next byte, undoing bit stuffing after 0xFF
+func (d *mqDecoder) byteIn() {
+	if d.byteAt(d.pos) == 0xFF {
+		if next := d.byteAt(d.pos + 1); next > 0x8F {
+			d.c += 0xFF00
+			d.ct = 8
+		} else {
+			d.pos++
+			d.c += uint32(next) << 9
+			d.ct = 7
+		}
+	} else {
+		d.pos++
+		d.c += uint32(d.byteAt(d.pos)) << 8
+		d.ct = 8
+	}
+}
+
+// decode decodes one binary decision in context cx
+func (d *mqDecoder) decode(cx *mqContext) int {
+	state := &mqStates[*cx>>1]
+	mps := int(*cx & 1)
+	qe := state.qe
+	var bit int
+
+	d.a -= qe
+	if d.c>>16 < qe {
+		// LPS exchange
+		if d.a < qe {
+			bit = mps
+			*cx = mqContext(state.nmps<<1) | mqContext(mps)
+		} else {
+			bit = 1 - mps
+			if state.swap {
+				mps = 1 - mps
+			}
+			*cx = mqContext(state.nlps<<1) | mqContext(mps)
+		}
+		d.a = qe
+	} else {
+		d.c -= qe << 16
+		if d.a&0x8000 != 0 {
+			return mps
+		}
+		// MPS exchange
+		if d.a < qe {
+			bit = 1 - mps
+			if state.swap {
+				mps = 1 - mps
+			}
+			*cx = mqContext(state.nlps<<1) | mqContext(mps)
+		} else {
+			bit = mps
+			*cx = mqContext(state.nmps<<1) | mqContext(mps)
+		}
+	}
+
+	// RENORMD
+	for {
+		if d.ct == 0 {
+			d.byteIn()
+		}
+		d.a <<= 1
+		d.c <<= 1
+		d.ct--
+		if d.a&0x8000 != 0 {
+			break
+		}
+	}
+	return bit
+}
+
+// mqEncoder is the MQ arithmetic encoder (ITU-T T.88 Annex E, software
+// conventions of Figures E.3 to E.11). out[0] stands for the byte before
+// the first one written.
+type mqEncoder struct {
+	a   uint32
+	c   uint32
+	ct  int
+	out []byte
+}
+
+// newMQEncoder initialises an encoder (INITENC)
+func newMQEncoder() *mqEncoder {
+	return &mqEncoder{a: 0x8000, ct: 12, out: []byte{0}}
+}
+
+// encode codes one binary decision in context cx
+func (e *mqEncoder) encode(bit int, cx *mqContext) {
+	state := &mqStates[*cx>>1]
+	mps := int(*cx & 1)
+	qe := state.qe
+
+	e.a -= qe
+	if bit == mps {
+		// CODEMPS
+		if e.a&0x8000 != 0 {
+			e.c += qe
+			return
+		}
+		if e.a < qe {
+			e.a = qe
+		} else {
+			e.c += qe
+		}
+		*cx = mqContext(state.nmps<<1) | mqContext(mps)
+	} else {
+		// CODELPS
+		if e.a < qe {
+			e.c += qe
+		} else {
+			e.a = qe
+		}
+		if state.swap {
+			mps = 1 - mps
+		}
+		*cx = mqContext(state.nlps<<1) | mqContext(mps)
+	}
+
+	// RENORME
+	for {
+		e.a <<= 1
+		e.c <<= 1
+		e.ct--
+		if e.ct == 0 {
+			e.byteOut()
+		}
+		if e.a&0x8000 != 0 {
+			break
+		}
+	}
+}
+
+// byteOut writes a byte, stuffing a bit after 0xFF (BYTEOUT)
+func (e *mqEncoder) byteOut() {
+	last := len(e.out) - 1
+	switch {
+	case e.out[last] == 0xFF:
+		e.emit(20, 0xFFFFF, 7)
+	case e.c < 0x8000000:
+		e.emit(19, 0x7FFFF, 8)
+	default:
+		e.out[last]++
+		if e.out[last] == 0xFF {
+			e.c &= 0x7FFFFFF
+			e.emit(20, 0xFFFFF, 7)
+		} else {
+			e.emit(19, 0x7FFFF, 8)
+		}
+	}
+}
+
+func (e *mqEncoder) emit(shift uint, mask uint32, ct int) {
+	e.out = append(e.out, byte(e.c>>shift))
+	e.c &= mask
+	e.ct = ct
+}
+
+// flush terminates the code stream (FLUSH) and returns it, followed by
+// the 0xFF 0xAC end marker of T.88 E.2.9
+func (e *mqEncoder) flush() []byte {
+	temp := e.c + e.a
+	e.c |= 0xFFFF
+	if e.c >= temp {
+		e.c -= 0x8000
+	}
+	e.c <<= uint(e.ct)
+	e.byteOut()
+	e.c <<= uint(e.ct)
+	e.byteOut()
+	out := e.out[1:]
+	if len(out) > 0 && out[len(out)-1] == 0xFF {
+		out = out[:len(out)-1]
+	}
+	return append(out, 0xFF, 0xAC)
+}
@@ -0,0 +1,43 @@
+package pdf
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The test sequence for the arithmetic coder of ITU-T T.88 Annex H.2:
+// 256 decisions in a single context and the data the encoder produces
+var (
+	mqTestDecisions = []byte{
+		0x00, 0x02, 0x00, 0x51, 0x00, 0x00, 0x00, 0xC0, 0x03, 0x52, 0x87, 0x2A,
+		0xAA, 0xAA, 0xAA, 0xAA, 0x82, 0xC0, 0x20, 0x00, 0xFC, 0xD7, 0x9E, 0xF6,
+		0xBF, 0x7F, 0xED, 0x90, 0x4F, 0x46, 0xA3, 0xBF,
+	}
+	mqTestCoded = []byte{
+		0x84, 0xC7, 0x3B, 0xFC, 0xE1, 0xA1, 0x43, 0x04, 0x02, 0x20, 0x00, 0x00,
+		0x41, 0x0D, 0xBB, 0x86, 0xF4, 0x31, 0x7F, 0xFF, 0x88, 0xFF, 0x37, 0x47,
+		0x1A, 0xDB, 0x6A, 0xDF, 0xFF, 0xAC,
+	}
+)
+
+func TestMQDecoderTestSequence(t *testing.T) {
+	d := newMQDecoder(mqTestCoded)
+	var cx mqContext
+	for i := 0; i < 8*len(mqTestDecisions); i++ {
+		want := int(mqTestDecisions[i/8]>>(7-i%8)) & 1
+		if got := d.decode(&cx); got != want {
+			t.Fatalf("decision %d = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestMQEncoderTestSequence(t *testing.T) {
+	e := newMQEncoder()
+	var cx mqContext
+	for i := 0; i < 8*len(mqTestDecisions); i++ {
+		e.encode(int(mqTestDecisions[i/8]>>(7-i%8))&1, &cx)
+	}
+	if got := e.flush(); !bytes.Equal(got, mqTestCoded) {
+		t.Errorf("coded data\n got % X\nwant % X", got, mqTestCoded)
+	}
+}
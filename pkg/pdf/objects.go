@@ -88,6 +88,33 @@ func (s String) Text() string {
 	return decodePDFDocEncoding(s.Value)
 }
 
+// textString encodes text as a PDF text string, using UTF-16BE with a
+// byte order mark when the text is not plain ASCII
+func textString(text string) String {
+	ascii := true
+	for i := 0; i < len(text); i++ {
+		if text[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return String{Value: []byte(text)}
+	}
+
+	data := []byte{0xFE, 0xFF}
+	for _, r := range text {
+		if r >= 0x10000 {
+			r -= 0x10000
+			hi, lo := 0xD800+(r>>10), 0xDC00+(r&0x3FF)
+			data = append(data, byte(hi>>8), byte(hi), byte(lo>>8), byte(lo))
+			continue
+		}
+		data = append(data, byte(r>>8), byte(r))
+	}
+	return String{Value: data, IsHex: true}
+}
+
 // Name represents a PDF name object
 type Name string
 
@@ -238,6 +265,8 @@ func applyFilter(data []byte, filter Name, params Dictionary) ([]byte, error) {
 		return data, nil
 	case "CCITTFaxDecode":
 		return ccittFaxDecode(data, params)
+	case "JBIG2Decode":
+		return jbig2Filter(data, params)
 	default:
 		return nil, fmt.Errorf("unsupported filter: %s", filter)
 	}
@@ -599,10 +628,20 @@ func runLengthDecode(data []byte) ([]byte, error) {
 	return result, nil
 }
 
-// ccittFaxDecode decodes CCITT fax encoded data (stub)
+// ccittFaxDecode decodes CCITT fax encoded data. params is the stream
+// dictionary; the filter parameters are taken from its DecodeParms.
 func ccittFaxDecode(data []byte, params Dictionary) ([]byte, error) {
-	// CCITT fax decoding is complex, return data as-is for now
-	return data, nil
+	switch dp := params.Get("DecodeParms").(type) {
+	case Dictionary:
+		params = dp
+	case Array:
+		for _, p := range dp {
+			if pd, ok := p.(Dictionary); ok && (pd.Get("K") != nil || pd.Get("Columns") != nil) {
+				params = pd
+			}
+		}
+	}
+	return DecodeCCITTFax(data, params)
 }
 
 // Reference represents a PDF indirect object reference
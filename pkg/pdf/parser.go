@@ -366,7 +366,9 @@ type Operation struct {
 	Operands []Object
 }
 
-// ParseOperations parses all operations from a content stream
+// ParseOperations parses all operations from a content stream. An inline
+// image (BI ... ID data EI) becomes one BI operation whose operand is the
+// image as a Stream with its abbreviated keys and names expanded.
 func (p *ContentStreamParser) ParseOperations() ([]Operation, error) {
 	var operations []Operation
 	var operands []Object
@@ -384,6 +386,17 @@ func (p *ContentStreamParser) ParseOperations() ([]Operation, error) {
 			break
 		}
 
+		// Inline images are returned as a single BI operation
+		if tok.Keyword && tok.Value == "BI" {
+			img, err := p.parseInlineImage()
+			if err != nil {
+				return operations, err
+			}
+			operations = append(operations, Operation{Operator: "BI", Operands: []Object{img}})
+			operands = nil
+			continue
+		}
+
 		// Check if this is an operator (keyword that's not a standard keyword)
 		if isOperator(tok) {
 			op := Operation{
@@ -439,6 +452,135 @@ func (p *ContentStreamParser) parseOperand(tok Token) (Object, error) {
 	}
 }
 
+// inlineImageKeys maps the abbreviated keys of inline images to the keys
+// of image XObjects
+var inlineImageKeys = map[Name]Name{
+	"BPC": "BitsPerComponent",
+	"CS":  "ColorSpace",
+	"D":   "Decode",
+	"DP":  "DecodeParms",
+	"F":   "Filter",
+	"H":   "Height",
+	"IM":  "ImageMask",
+	"I":   "Interpolate",
+	"L":   "Length",
+	"W":   "Width",
+}
+
+// inlineImageNames maps abbreviated colour space and filter names
+var inlineImageNames = map[Name]Name{
+	"G":    "DeviceGray",
+	"RGB":  "DeviceRGB",
+	"CMYK": "DeviceCMYK",
+	"I":    "Indexed",
+	"AHx":  "ASCIIHexDecode",
+	"A85":  "ASCII85Decode",
+	"LZW":  "LZWDecode",
+	"Fl":   "FlateDecode",
+	"RL":   "RunLengthDecode",
+	"CCF":  "CCITTFaxDecode",
+	"DCT":  "DCTDecode",
+}
+
+// parseInlineImage parses the dictionary and data of an inline image
+// after its BI keyword
+func (p *ContentStreamParser) parseInlineImage() (Stream, error) {
+	dict := Dictionary{"Type": Name("XObject"), "Subtype": Name("Image")}
+	for {
+		tok, err := p.lexer.NextToken()
+		if err != nil {
+			return Stream{}, err
+		}
+		if tok.Type == TokenEOF {
+			return Stream{}, fmt.Errorf("inline image without ID")
+		}
+		if tok.Keyword && tok.Value == "ID" {
+			break
+		}
+		if tok.Type != TokenName || tok.Keyword {
+			return Stream{}, fmt.Errorf("expected name as inline image key")
+		}
+		key := Name(tok.Value.(string))
+		if full, ok := inlineImageKeys[key]; ok {
+			key = full
+		}
+		valueTok, err := p.lexer.NextToken()
+		if err != nil {
+			return Stream{}, err
+		}
+		value, err := p.parseOperand(valueTok)
+		if err != nil {
+			return Stream{}, err
+		}
+		switch key {
+		case "ColorSpace", "Filter":
+			value = expandInlineImageNames(value)
+		}
+		dict[key] = value
+	}
+
+	data, err := p.lexer.readInlineImageData(inlineImageLength(dict))
+	if err != nil {
+		return Stream{}, err
+	}
+	if tok, err := p.lexer.NextToken(); err != nil || !tok.Keyword || tok.Value != "EI" {
+		return Stream{}, fmt.Errorf("inline image without EI")
+	}
+	return Stream{Dictionary: dict, Data: data}, nil
+}
+
+// expandInlineImageNames expands abbreviated names in a colour space or
+// filter value
+func expandInlineImageNames(obj Object) Object {
+	switch v := obj.(type) {
+	case Name:
+		if full, ok := inlineImageNames[v]; ok {
+			return full
+		}
+	case Array:
+		out := make(Array, len(v))
+		for i, item := range v {
+			out[i] = expandInlineImageNames(item)
+		}
+		return out
+	}
+	return obj
+}
+
+// inlineImageLength returns the length of inline image data when the
+// dictionary gives it or it follows from the image size, or -1
+func inlineImageLength(dict Dictionary) int {
+	if n, ok := dict.GetInt("Length"); ok {
+		return int(n)
+	}
+	if dict.Get("Filter") != nil {
+		return -1
+	}
+	width, _ := dict.GetInt("Width")
+	height, _ := dict.GetInt("Height")
+	bpc, ok := dict.GetInt("BitsPerComponent")
+	if !ok {
+		bpc = 8
+	}
+	comps := 0
+	if IsImageMask(dict) {
+		comps, bpc = 1, 1
+	} else {
+		switch cs := dict.Get("ColorSpace").(type) {
+		case Name:
+			comps = map[Name]int{"DeviceGray": 1, "CalGray": 1, "DeviceRGB": 3, "CalRGB": 3, "Lab": 3, "DeviceCMYK": 4}[cs]
+		case Array:
+			if len(cs) > 0 && cs[0] == Name("Indexed") {
+				comps = 1
+			}
+		}
+	}
+	if comps == 0 || width <= 0 || height <= 0 {
+		return -1
+	}
+	return int(height) * ((int(width)*comps*int(bpc) + 7) / 8)
+}
+
 // parseArray parses an array in content stream
 func (p *ContentStreamParser) parseArray() (Array, error) {
 	var arr Array
@@ -503,8 +645,8 @@ func isOperator(tok Token) bool {
 		TokenXRef, TokenTrailer, TokenStartXRef:
 		return false
 	case TokenName:
-		// Names starting with / are not operators, they are name objects
-		if str, ok := tok.Value.(string); ok && len(str) > 0 && str[0] == '/' {
+		// Names written with a slash are name objects, not operators
+		if !tok.Keyword {
 			return false
 		}
 		// Check if it's a known operator
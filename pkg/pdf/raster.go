@@ -0,0 +1,1163 @@
+package pdf
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/math/fixed"
+)
+
+// rasterSubsamples is the number of sub-scanlines sampled per pixel row
+const rasterSubsamples = 4
+
+// maxRasterFormDepth limits the nesting of form XObjects
+const maxRasterFormDepth = 12
+
+// coverageMask holds per-pixel coverage in [0, 1] for a device rectangle
+type coverageMask struct {
+	rect image.Rectangle
+	cov  []float32
+}
+
+// at returns the coverage of a device pixel
+func (m *coverageMask) at(x, y int) float32 {
+	if !image.Pt(x, y).In(m.rect) {
+		return 0
+	}
+	c := m.cov[(y-m.rect.Min.Y)*m.rect.Dx()+x-m.rect.Min.X]
+	if c > 1 {
+		return 1
+	}
+	return c
+}
+
+// intersect returns the pixel-wise product of two masks
+func (m *coverageMask) intersect(o *coverageMask) *coverageMask {
+	rect := m.rect.Intersect(o.rect)
+	out := &coverageMask{rect: rect, cov: make([]float32, rect.Dx()*rect.Dy())}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			out.cov[(y-rect.Min.Y)*rect.Dx()+x-rect.Min.X] = m.at(x, y) * o.at(x, y)
+		}
+	}
+	return out
+}
+
+// subpath is a flattened subpath in device space
+type subpath struct {
+	pts    []Point
+	closed bool
+}
+
+// rasterState is the graphics state of a contentRasterizer
+type rasterState struct {
+	ctm         Matrix
+	fill        color.RGBA
+	stroke      color.RGBA
+	fillSpace   ColorSpace
+	strokeSpace ColorSpace
+	fillAlpha   float64
+	strokeAlpha float64
+	multiply    bool
+	lineWidth   float64
+	lineCap     int
+	lineJoin    int
+	miterLimit  float64
+	dash        []float64
+	dashPhase   float64
+	clip        *coverageMask
+
+	font        Dictionary
+	fontSize    float64
+	charSpacing float64
+	wordSpacing float64
+	hScale      float64
+	leading     float64
+	rise        float64
+	renderMode  int
+}
+
+// contentRasterizer paints content streams onto an RGBA image. It supports
+// paths, clipping, colours, constant alpha, text with the fonts available
+// to FontRenderer, image XObjects and nested form XObjects.
+type contentRasterizer struct {
+	doc   *Document
+	img   *image.RGBA
+	state rasterState
+	stack []rasterState
+
+	path        []subpath
+	current     Point
+	clipPending bool
+	clipEvenOdd bool
+
+	textMatrix Matrix
+	lineMatrix Matrix
+
+	fonts     *FontRenderer
+	ttfFonts  map[string]*truetype.Font
+	toUnicode map[string]map[uint16]rune
+	depth     int
+
+	// imagesOnly skips painting paths and text; clipping still applies
+	imagesOnly bool
+}
+
+// newContentRasterizer creates a rasterizer drawing onto img with the given
+// initial transformation from user space to device pixels
+func newContentRasterizer(doc *Document, img *image.RGBA, ctm Matrix) *contentRasterizer {
+	return &contentRasterizer{
+		doc: doc,
+		img: img,
+		state: rasterState{
+			ctm:         ctm,
+			fill:        color.RGBA{0, 0, 0, 255},
+			stroke:      color.RGBA{0, 0, 0, 255},
+			fillSpace:   DeviceGray,
+			strokeSpace: DeviceGray,
+			fillAlpha:   1,
+			strokeAlpha: 1,
+			lineWidth:   1,
+			miterLimit:  10,
+			hScale:      1,
+		},
+		textMatrix: IdentityMatrix(),
+		lineMatrix: IdentityMatrix(),
+		ttfFonts:   make(map[string]*truetype.Font),
+		toUnicode:  make(map[string]map[uint16]rune),
+	}
+}
+
+// drawForm paints a form XObject with the current graphics state. The
+// form's /Matrix is applied and its /BBox is used as a clip.
+func (r *contentRasterizer) drawForm(form Stream, parentResources Dictionary) {
+	if r.depth >= maxRasterFormDepth {
+		return
+	}
+	data, err := form.Decode()
+	if err != nil {
+		return
+	}
+
+	r.save()
+	defer r.restore()
+
+	if m, ok := form.Dictionary.GetArray("Matrix"); ok && len(m) == 6 {
+		r.state.ctm = Matrix{
+			objectToFloat(m[0]), objectToFloat(m[1]), objectToFloat(m[2]),
+			objectToFloat(m[3]), objectToFloat(m[4]), objectToFloat(m[5]),
+		}.Multiply(r.state.ctm)
+	}
+	if bbox, ok := resolveArray(r.doc, form.Dictionary.Get("BBox")); ok && len(bbox) == 4 {
+		rect := arrayToRectangle(bbox)
+		r.path = nil
+		r.rectangle(rect.LLX, rect.LLY, rect.URX-rect.LLX, rect.URY-rect.LLY)
+		r.clipPending = true
+		r.clipEvenOdd = false
+		r.endPath()
+	}
+
+	resources, ok := resolveDict(r.doc, form.Dictionary.Get("Resources"))
+	if !ok {
+		resources = parentResources
+	}
+
+	r.depth++
+	r.run(data, resources)
+	r.depth--
+}
+
+// run executes the operators of a content stream
+func (r *contentRasterizer) run(data []byte, resources Dictionary) {
+	ops, err := NewContentStreamParser(data).ParseOperations()
+	if err != nil && len(ops) == 0 {
+		return
+	}
+
+	for _, op := range ops {
+		args := op.Operands
+		num := func(i int) float64 {
+			if i < len(args) {
+				return objectToFloat(args[i])
+			}
+			return 0
+		}
+
+		switch op.Operator {
+		// Graphics state
+		case "q":
+			r.save()
+		case "Q":
+			r.restore()
+		case "cm":
+			if len(args) == 6 {
+				r.state.ctm = Matrix{num(0), num(1), num(2), num(3), num(4), num(5)}.Multiply(r.state.ctm)
+			}
+		case "w":
+			r.state.lineWidth = num(0)
+		case "J":
+			r.state.lineCap = int(num(0))
+		case "j":
+			r.state.lineJoin = int(num(0))
+		case "M":
+			r.state.miterLimit = num(0)
+		case "d":
+			r.state.dash = nil
+			if len(args) == 2 {
+				if arr, ok := args[0].(Array); ok {
+					for _, v := range arr {
+						r.state.dash = append(r.state.dash, objectToFloat(v))
+					}
+				}
+				r.state.dashPhase = num(1)
+			}
+		case "gs":
+			if len(args) == 1 {
+				if name, ok := args[0].(Name); ok {
+					r.setExtGState(resources, string(name))
+				}
+			}
+
+		// Path construction
+		case "m":
+			r.moveTo(num(0), num(1))
+		case "l":
+			r.lineTo(num(0), num(1))
+		case "c":
+			r.curveTo(r.userPoint(num(0), num(1)), r.userPoint(num(2), num(3)), r.userPoint(num(4), num(5)))
+		case "v":
+			r.curveTo(r.current, r.userPoint(num(0), num(1)), r.userPoint(num(2), num(3)))
+		case "y":
+			end := r.userPoint(num(2), num(3))
+			r.curveTo(r.userPoint(num(0), num(1)), end, end)
+		case "h":
+			r.closePath()
+		case "re":
+			r.rectangle(num(0), num(1), num(2), num(3))
+
+		// Path painting
+		case "S":
+			r.strokePath()
+			r.endPath()
+		case "s":
+			r.closePath()
+			r.strokePath()
+			r.endPath()
+		case "f", "F", "f*":
+			r.fillPath(op.Operator == "f*")
+			r.endPath()
+		case "B", "B*":
+			r.fillPath(op.Operator == "B*")
+			r.strokePath()
+			r.endPath()
+		case "b", "b*":
+			r.closePath()
+			r.fillPath(op.Operator == "b*")
+			r.strokePath()
+			r.endPath()
+		case "n":
+			r.endPath()
+		case "W", "W*":
+			r.clipPending = true
+			r.clipEvenOdd = op.Operator == "W*"
+
+		// Colour
+		case "g", "rg", "k":
+			r.state.fillSpace = deviceSpaces[op.Operator]
+			r.setColor(args, false)
+		case "G", "RG", "K":
+			r.state.strokeSpace = deviceSpaces[strings.ToLower(op.Operator)]
+			r.setColor(args, true)
+		case "sc", "scn":
+			r.setColor(args, false)
+		case "SC", "SCN":
+			r.setColor(args, true)
+		case "cs", "CS":
+			if len(args) == 1 {
+				r.setColorSpace(resources, args[0], op.Operator == "CS")
+			}
+
+		// Text
+		case "BT":
+			r.textMatrix = IdentityMatrix()
+			r.lineMatrix = IdentityMatrix()
+		case "Tf":
+			if len(args) == 2 {
+				if name, ok := args[0].(Name); ok {
+					r.state.font = r.resource(resources, "Font", string(name))
+				}
+				r.state.fontSize = num(1)
+			}
+		case "Tc":
+			r.state.charSpacing = num(0)
+		case "Tw":
+			r.state.wordSpacing = num(0)
+		case "Tz":
+			r.state.hScale = num(0) / 100
+		case "TL":
+			r.state.leading = num(0)
+		case "Ts":
+			r.state.rise = num(0)
+		case "Tr":
+			r.state.renderMode = int(num(0))
+		case "Td":
+			r.nextLine(num(0), num(1))
+		case "TD":
+			r.state.leading = -num(1)
+			r.nextLine(num(0), num(1))
+		case "Tm":
+			if len(args) == 6 {
+				r.textMatrix = Matrix{num(0), num(1), num(2), num(3), num(4), num(5)}
+				r.lineMatrix = r.textMatrix
+			}
+		case "T*":
+			r.nextLine(0, -r.state.leading)
+		case "Tj":
+			if len(args) == 1 {
+				if s, ok := args[0].(String); ok {
+					r.showText(s.Value)
+				}
+			}
+		case "'":
+			r.nextLine(0, -r.state.leading)
+			if len(args) == 1 {
+				if s, ok := args[0].(String); ok {
+					r.showText(s.Value)
+				}
+			}
+		case "\"":
+			if len(args) == 3 {
+				r.state.wordSpacing = num(0)
+				r.state.charSpacing = num(1)
+				r.nextLine(0, -r.state.leading)
+				if s, ok := args[2].(String); ok {
+					r.showText(s.Value)
+				}
+			}
+		case "TJ":
+			if len(args) == 1 {
+				if arr, ok := args[0].(Array); ok {
+					for _, item := range arr {
+						switch v := item.(type) {
+						case String:
+							r.showText(v.Value)
+						case Integer, Real:
+							tx := -objectToFloat(v) / 1000 * r.state.fontSize * r.state.hScale
+							r.textMatrix = Matrix{1, 0, 0, 1, tx, 0}.Multiply(r.textMatrix)
+						}
+					}
+				}
+			}
+
+		// XObjects and inline images
+		case "Do":
+			if len(args) == 1 {
+				if name, ok := args[0].(Name); ok {
+					r.drawXObject(resources, string(name))
+				}
+			}
+		case "BI":
+			if len(args) == 1 {
+				if img, ok := args[0].(Stream); ok {
+					r.drawImage(img, resources)
+				}
+			}
+		}
+	}
+}
+
+// save pushes the graphics state
+func (r *contentRasterizer) save() {
+	r.stack = append(r.stack, r.state)
+}
+
+// restore pops the graphics state
+func (r *contentRasterizer) restore() {
+	if len(r.stack) == 0 {
+		return
+	}
+	r.state = r.stack[len(r.stack)-1]
+	r.stack = r.stack[:len(r.stack)-1]
+}
+
+// resource looks up a named entry in a resource category
+func (r *contentRasterizer) resource(resources Dictionary, category, name string) Dictionary {
+	if resources == nil {
+		return nil
+	}
+	entries, ok := resolveDict(r.doc, resources.Get(category))
+	if !ok {
+		return nil
+	}
+	dict, _ := resolveDict(r.doc, entries.Get(name))
+	return dict
+}
+
+// setExtGState applies the supported entries of an ExtGState resource
+func (r *contentRasterizer) setExtGState(resources Dictionary, name string) {
+	gs := r.resource(resources, "ExtGState", name)
+	if gs == nil {
+		return
+	}
+	if ca := gs.Get("ca"); ca != nil {
+		r.state.fillAlpha = objectToFloat(ca)
+	}
+	if ca := gs.Get("CA"); ca != nil {
+		r.state.strokeAlpha = objectToFloat(ca)
+	}
+	if lw := gs.Get("LW"); lw != nil {
+		r.state.lineWidth = objectToFloat(lw)
+	}
+	switch bm := gs.Get("BM").(type) {
+	case Name:
+		r.state.multiply = bm == "Multiply"
+	case Array:
+		if len(bm) > 0 {
+			name, _ := bm[0].(Name)
+			r.state.multiply = name == "Multiply"
+		}
+	}
+}
+
+// deviceSpaces maps the colour operators to their device colour spaces
+var deviceSpaces = map[string]ColorSpace{"g": DeviceGray, "rg": DeviceRGB, "k": DeviceCMYK}
+
+// setColorSpace selects a colour space for filling or stroking and its
+// initial colour
+func (r *contentRasterizer) setColorSpace(resources Dictionary, obj Object, stroke bool) {
+	cs, err := r.doc.ParseColorSpace(obj, resources)
+	if err != nil {
+		cs = DeviceGray
+	}
+	c := color.RGBA{0, 0, 0, 255}
+	if cs.NComponents() > 0 {
+		c = colorRGBA(cs, cs.InitialColor())
+	}
+	if stroke {
+		r.state.strokeSpace, r.state.stroke = cs, c
+	} else {
+		r.state.fillSpace, r.state.fill = cs, c
+	}
+}
+
+// setColor converts colour operands through the current fill or stroke
+// colour space. Pattern names are ignored and keep the previous colour.
+func (r *contentRasterizer) setColor(args []Object, stroke bool) {
+	cs := r.state.fillSpace
+	if stroke {
+		cs = r.state.strokeSpace
+	}
+	var v []float64
+	for _, arg := range args {
+		switch arg.(type) {
+		case Integer, Real:
+			v = append(v, objectToFloat(arg))
+		}
+	}
+	if len(v) == 0 || len(v) < cs.NComponents() {
+		return
+	}
+	if stroke {
+		r.state.stroke = colorRGBA(cs, v)
+	} else {
+		r.state.fill = colorRGBA(cs, v)
+	}
+}
+
+// userPoint transforms a user space point to device space
+func (r *contentRasterizer) userPoint(x, y float64) Point {
+	return r.state.ctm.TransformPoint(Point{x, y})
+}
+
+// moveTo starts a new subpath
+func (r *contentRasterizer) moveTo(x, y float64) {
+	r.current = r.userPoint(x, y)
+	r.path = append(r.path, subpath{pts: []Point{r.current}})
+}
+
+// lineTo appends a line segment to the current subpath
+func (r *contentRasterizer) lineTo(x, y float64) {
+	if len(r.path) == 0 {
+		r.moveTo(x, y)
+		return
+	}
+	r.current = r.userPoint(x, y)
+	sp := &r.path[len(r.path)-1]
+	sp.pts = append(sp.pts, r.current)
+}
+
+// curveTo appends a cubic Bézier curve given in device space
+func (r *contentRasterizer) curveTo(p1, p2, p3 Point) {
+	if len(r.path) == 0 {
+		r.path = append(r.path, subpath{pts: []Point{r.current}})
+	}
+	p0 := r.current
+	length := math.Hypot(p1.X-p0.X, p1.Y-p0.Y) + math.Hypot(p2.X-p1.X, p2.Y-p1.Y) + math.Hypot(p3.X-p2.X, p3.Y-p2.Y)
+	steps := int(math.Ceil(length / 2))
+	if steps < 4 {
+		steps = 4
+	} else if steps > 100 {
+		steps = 100
+	}
+
+	sp := &r.path[len(r.path)-1]
+	for i := 1; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		mt := 1 - t
+		a, b, c, d := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+		sp.pts = append(sp.pts, Point{
+			X: a*p0.X + b*p1.X + c*p2.X + d*p3.X,
+			Y: a*p0.Y + b*p1.Y + c*p2.Y + d*p3.Y,
+		})
+	}
+	r.current = p3
+}
+
+// closePath closes the current subpath
+func (r *contentRasterizer) closePath() {
+	if len(r.path) == 0 {
+		return
+	}
+	sp := &r.path[len(r.path)-1]
+	sp.closed = true
+	if len(sp.pts) > 0 {
+		r.current = sp.pts[0]
+	}
+}
+
+// rectangle appends a closed rectangle subpath
+func (r *contentRasterizer) rectangle(x, y, w, h float64) {
+	r.moveTo(x, y)
+	r.lineTo(x+w, y)
+	r.lineTo(x+w, y+h)
+	r.lineTo(x, y+h)
+	r.closePath()
+}
+
+// endPath applies a pending clip and discards the current path
+func (r *contentRasterizer) endPath() {
+	if r.clipPending {
+		clip := fillCoverage(r.path, r.clipEvenOdd, r.img.Bounds())
+		if clip == nil {
+			clip = &coverageMask{}
+		}
+		if r.state.clip != nil {
+			clip = r.state.clip.intersect(clip)
+		}
+		r.state.clip = clip
+	}
+	r.clipPending = false
+	r.path = nil
+}
+
+// fillPath fills the current path with the fill colour
+func (r *contentRasterizer) fillPath(evenOdd bool) {
+	if r.imagesOnly {
+		return
+	}
+	if mask := fillCoverage(r.path, evenOdd, r.img.Bounds()); mask != nil {
+		r.paint(mask, r.state.fill, r.state.fillAlpha)
+	}
+}
+
+// strokePath strokes the current path with the stroke colour
+func (r *contentRasterizer) strokePath() {
+	if r.imagesOnly {
+		return
+	}
+	outline := r.strokeOutline(r.path)
+	if mask := fillCoverage(outline, false, r.img.Bounds()); mask != nil {
+		r.paint(mask, r.state.stroke, r.state.strokeAlpha)
+	}
+}
+
+// deviceScale returns the average scale factor of the CTM
+func (r *contentRasterizer) deviceScale() float64 {
+	m := r.state.ctm
+	return math.Sqrt(math.Abs(m.A*m.D - m.B*m.C))
+}
+
+// strokeOutline converts the path into polygons covering its stroke. All
+// polygons share one orientation so that they can be filled as a union
+// with the nonzero winding rule.
+func (r *contentRasterizer) strokeOutline(path []subpath) []subpath {
+	scale := r.deviceScale()
+	half := math.Max(r.state.lineWidth*scale, 1) / 2
+
+	if len(r.state.dash) > 0 {
+		dash := make([]float64, len(r.state.dash))
+		total := 0.0
+		for i, d := range r.state.dash {
+			dash[i] = d * scale
+			total += dash[i]
+		}
+		if total > 0 {
+			path = dashSubpaths(path, dash, r.state.dashPhase*scale)
+		}
+	}
+
+	var out []subpath
+	add := func(pts ...Point) {
+		out = append(out, subpath{pts: orientPolygon(pts), closed: true})
+	}
+
+	for _, sp := range path {
+		pts := dedupePoints(sp.pts)
+		closed := sp.closed && len(pts) > 2
+		if closed && pts[0] == pts[len(pts)-1] {
+			pts = pts[:len(pts)-1]
+		}
+		if len(pts) == 1 {
+			switch r.state.lineCap {
+			case 1:
+				add(circlePolygon(pts[0], half)...)
+			case 2:
+				p := pts[0]
+				add(Point{p.X - half, p.Y - half}, Point{p.X + half, p.Y - half},
+					Point{p.X + half, p.Y + half}, Point{p.X - half, p.Y + half})
+			}
+			continue
+		}
+		if len(pts) < 2 {
+			continue
+		}
+
+		if closed {
+			pts = append(pts, pts[0])
+		} else if r.state.lineCap == 2 {
+			pts[0] = extendPoint(pts[1], pts[0], half)
+			pts[len(pts)-1] = extendPoint(pts[len(pts)-2], pts[len(pts)-1], half)
+		}
+
+		for i := 0; i+1 < len(pts); i++ {
+			p1, p2 := pts[i], pts[i+1]
+			nx, ny := segmentNormal(p1, p2, half)
+			add(Point{p1.X + nx, p1.Y + ny}, Point{p2.X + nx, p2.Y + ny},
+				Point{p2.X - nx, p2.Y - ny}, Point{p1.X - nx, p1.Y - ny})
+		}
+
+		// Joins between consecutive segments
+		last := len(pts) - 1
+		for i := 1; i <= last; i++ {
+			if i == last && !closed {
+				break
+			}
+			prev, p := pts[i-1], pts[i]
+			next := pts[(i+1)%len(pts)]
+			if i == last {
+				next = pts[1]
+			}
+			add(joinPolygon(prev, p, next, half, r.state.lineJoin, r.state.miterLimit)...)
+		}
+
+		if !closed && r.state.lineCap == 1 {
+			add(circlePolygon(pts[0], half)...)
+			add(circlePolygon(pts[last], half)...)
+		}
+	}
+	return out
+}
+
+// dedupePoints drops consecutive duplicate points
+func dedupePoints(pts []Point) []Point {
+	out := make([]Point, 0, len(pts))
+	for _, p := range pts {
+		if len(out) == 0 || out[len(out)-1] != p {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// segmentNormal returns the left normal of a segment scaled to length half
+func segmentNormal(p1, p2 Point, half float64) (float64, float64) {
+	dx, dy := p2.X-p1.X, p2.Y-p1.Y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return 0, 0
+	}
+	return -dy / length * half, dx / length * half
+}
+
+// extendPoint moves end away from from by distance d
+func extendPoint(from, end Point, d float64) Point {
+	dx, dy := end.X-from.X, end.Y-from.Y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return end
+	}
+	return Point{end.X + dx/length*d, end.Y + dy/length*d}
+}
+
+// joinPolygon returns the polygon filling the outer corner at p
+func joinPolygon(prev, p, next Point, half float64, join int, miterLimit float64) []Point {
+	if join == 1 {
+		return circlePolygon(p, half)
+	}
+	n1x, n1y := segmentNormal(prev, p, half)
+	n2x, n2y := segmentNormal(p, next, half)
+	cross := (p.X-prev.X)*(next.Y-p.Y) - (p.Y-prev.Y)*(next.X-p.X)
+	sign := 1.0
+	if cross > 0 {
+		sign = -1
+	}
+	o1 := Point{p.X + sign*n1x, p.Y + sign*n1y}
+	o2 := Point{p.X + sign*n2x, p.Y + sign*n2y}
+
+	if join == 0 {
+		cosPhi := (n1x*n2x + n1y*n2y) / (half * half)
+		if c := (1 + cosPhi) / 2; c > 0 {
+			ratio := 1 / math.Sqrt(c)
+			if ratio <= miterLimit {
+				mx, my := n1x+n2x, n1y+n2y
+				ml := math.Hypot(mx, my)
+				if ml > 0 {
+					miter := Point{p.X + sign*mx/ml*half*ratio, p.Y + sign*my/ml*half*ratio}
+					return []Point{p, o1, miter, o2}
+				}
+			}
+		}
+	}
+	return []Point{p, o1, o2}
+}
+
+// circlePolygon approximates a circle
+func circlePolygon(c Point, radius float64) []Point {
+	const n = 16
+	pts := make([]Point, n)
+	for i := range pts {
+		a := 2 * math.Pi * float64(i) / n
+		pts[i] = Point{c.X + radius*math.Cos(a), c.Y + radius*math.Sin(a)}
+	}
+	return pts
+}
+
+// orientPolygon returns pts with a positive signed area
+func orientPolygon(pts []Point) []Point {
+	area := 0.0
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		area += pts[i].X*pts[j].Y - pts[j].X*pts[i].Y
+	}
+	if area < 0 {
+		for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+			pts[i], pts[j] = pts[j], pts[i]
+		}
+	}
+	return pts
+}
+
+// dashSubpaths splits subpaths into the "on" intervals of a dash pattern
+func dashSubpaths(path []subpath, dash []float64, phase float64) []subpath {
+	var out []subpath
+	for _, sp := range path {
+		pts := sp.pts
+		if sp.closed && len(pts) > 1 {
+			pts = append(append([]Point(nil), pts...), pts[0])
+		}
+
+		// Find the starting position in the pattern
+		idx := 0
+		remaining := dash[0]
+		for phase > 0 {
+			if phase < remaining {
+				remaining -= phase
+				break
+			}
+			phase -= remaining
+			idx = (idx + 1) % len(dash)
+			remaining = dash[idx]
+		}
+		on := idx%2 == 0
+
+		var cur []Point
+		if on && len(pts) > 0 {
+			cur = []Point{pts[0]}
+		}
+		for i := 0; i+1 < len(pts); i++ {
+			p1, p2 := pts[i], pts[i+1]
+			segLen := math.Hypot(p2.X-p1.X, p2.Y-p1.Y)
+			pos := 0.0
+			for segLen-pos > remaining {
+				pos += remaining
+				t := pos / segLen
+				p := Point{p1.X + (p2.X-p1.X)*t, p1.Y + (p2.Y-p1.Y)*t}
+				if on {
+					cur = append(cur, p)
+					out = append(out, subpath{pts: cur})
+					cur = nil
+				} else {
+					cur = []Point{p}
+				}
+				on = !on
+				idx = (idx + 1) % len(dash)
+				remaining = dash[idx]
+			}
+			remaining -= segLen - pos
+			if on {
+				cur = append(cur, p2)
+			}
+		}
+		if on && len(cur) > 1 {
+			out = append(out, subpath{pts: cur})
+		}
+	}
+	return out
+}
+
+// fillCoverage rasterizes subpaths (each implicitly closed) into an
+// anti-aliased coverage mask clipped to bounds. It returns nil when
+// nothing is covered.
+func fillCoverage(path []subpath, evenOdd bool, bounds image.Rectangle) *coverageMask {
+	type edge struct {
+		x0, y0, x1, y1 float64
+		dir            int
+	}
+
+	var edges []edge
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, sp := range path {
+		n := len(sp.pts)
+		if n < 2 {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			p1, p2 := sp.pts[i], sp.pts[(i+1)%n]
+			minX, maxX = math.Min(minX, p1.X), math.Max(maxX, p1.X)
+			minY, maxY = math.Min(minY, p1.Y), math.Max(maxY, p1.Y)
+			if p1.Y == p2.Y {
+				continue
+			}
+			dir := 1
+			if p2.Y < p1.Y {
+				dir = -1
+			}
+			edges = append(edges, edge{p1.X, p1.Y, p2.X, p2.Y, dir})
+		}
+	}
+	if len(edges) == 0 {
+		return nil
+	}
+
+	rect := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1).Intersect(bounds)
+	if rect.Empty() {
+		return nil
+	}
+
+	mask := &coverageMask{rect: rect, cov: make([]float32, rect.Dx()*rect.Dy())}
+	type crossing struct {
+		x   float64
+		dir int
+	}
+	var crossings []crossing
+	weight := 1.0 / rasterSubsamples
+
+	for py := rect.Min.Y; py < rect.Max.Y; py++ {
+		row := mask.cov[(py-rect.Min.Y)*rect.Dx() : (py-rect.Min.Y+1)*rect.Dx()]
+		for s := 0; s < rasterSubsamples; s++ {
+			sy := float64(py) + (float64(s)+0.5)/rasterSubsamples
+			crossings = crossings[:0]
+			for _, e := range edges {
+				if (e.y0 <= sy && e.y1 > sy) || (e.y1 <= sy && e.y0 > sy) {
+					t := (sy - e.y0) / (e.y1 - e.y0)
+					crossings = append(crossings, crossing{e.x0 + t*(e.x1-e.x0), e.dir})
+				}
+			}
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+			winding := 0
+			for i := 0; i+1 < len(crossings); i++ {
+				if evenOdd {
+					winding++
+				} else {
+					winding += crossings[i].dir
+				}
+				inside := winding != 0
+				if evenOdd {
+					inside = winding%2 == 1
+				}
+				if inside {
+					addSpan(row, rect.Min.X, crossings[i].x, crossings[i+1].x, weight)
+				}
+			}
+		}
+	}
+	return mask
+}
+
+// addSpan adds weighted coverage for [x0, x1) to a mask row starting at minX
+func addSpan(row []float32, minX int, x0, x1, weight float64) {
+	left, right := float64(minX), float64(minX+len(row))
+	x0, x1 = math.Max(x0, left), math.Min(x1, right)
+	if x1 <= x0 {
+		return
+	}
+	ix0, ix1 := int(math.Floor(x0)), int(math.Floor(x1))
+	if ix0 == ix1 {
+		row[ix0-minX] += float32((x1 - x0) * weight)
+		return
+	}
+	row[ix0-minX] += float32((float64(ix0+1) - x0) * weight)
+	for x := ix0 + 1; x < ix1; x++ {
+		row[x-minX] += float32(weight)
+	}
+	if ix1 < minX+len(row) {
+		row[ix1-minX] += float32((x1 - float64(ix1)) * weight)
+	}
+}
+
+// paint composites a colour through a coverage mask and the current clip
+func (r *contentRasterizer) paint(mask *coverageMask, col color.RGBA, alpha float64) {
+	rect := mask.rect.Intersect(r.img.Bounds())
+	clip := r.state.clip
+	if clip != nil {
+		rect = rect.Intersect(clip.rect)
+	}
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			a := float64(mask.at(x, y)) * alpha
+			if clip != nil {
+				a *= float64(clip.at(x, y))
+			}
+			if a <= 0 {
+				continue
+			}
+			dst := r.img.RGBAAt(x, y)
+			src := col
+			if r.state.multiply {
+				src.R = uint8(uint16(src.R) * uint16(dst.R) / 255)
+				src.G = uint8(uint16(src.G) * uint16(dst.G) / 255)
+				src.B = uint8(uint16(src.B) * uint16(dst.B) / 255)
+			}
+			blend := func(s, d uint8) uint8 {
+				return uint8(math.Round(float64(s)*a + float64(d)*(1-a)))
+			}
+			r.img.SetRGBA(x, y, color.RGBA{
+				R: blend(src.R, dst.R),
+				G: blend(src.G, dst.G),
+				B: blend(src.B, dst.B),
+				A: blend(255, dst.A),
+			})
+		}
+	}
+}
+
+// nextLine moves to the start of the next text line offset by (tx, ty)
+func (r *contentRasterizer) nextLine(tx, ty float64) {
+	r.lineMatrix = Matrix{1, 0, 0, 1, tx, ty}.Multiply(r.lineMatrix)
+	r.textMatrix = r.lineMatrix
+}
+
+// showText paints a text string with the current font and advances the
+// text matrix by the glyph widths
+func (r *contentRasterizer) showText(data []byte) {
+	font := r.state.font
+	size := r.state.fontSize
+	subtype, _ := font.GetName("Subtype")
+	twoByte := subtype == "Type0"
+	toUnicode := r.fontToUnicode(font)
+
+	type glyph struct {
+		ch   rune
+		x, y float64
+	}
+	var glyphs []glyph
+	pixelSize := 0.0
+
+	for i := 0; i < len(data); {
+		code := uint16(data[i])
+		i++
+		if twoByte && i < len(data) {
+			code = code<<8 | uint16(data[i])
+			i++
+		}
+
+		ch, ok := toUnicode[code]
+		if !ok {
+			ch = simpleCodeRune(code, twoByte)
+		}
+
+		trm := Matrix{size * r.state.hScale, 0, 0, size, 0, r.state.rise}.Multiply(r.textMatrix).Multiply(r.state.ctm)
+		pixelSize = math.Hypot(trm.C, trm.D)
+		glyphs = append(glyphs, glyph{ch, trm.E, trm.F})
+
+		tx := fontGlyphWidth(font, code, twoByte)/1000*size + r.state.charSpacing
+		if !twoByte && code == 32 {
+			tx += r.state.wordSpacing
+		}
+		r.textMatrix = Matrix{1, 0, 0, 1, tx * r.state.hScale, 0}.Multiply(r.textMatrix)
+	}
+
+	if len(glyphs) == 0 || r.imagesOnly || pixelSize < 1 || r.state.renderMode == 3 || r.state.renderMode == 7 {
+		return
+	}
+
+	// Rasterize the glyphs into an alpha mask covering the run
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, g := range glyphs {
+		minX, maxX = math.Min(minX, g.x), math.Max(maxX, g.x)
+		minY, maxY = math.Min(minY, g.y), math.Max(maxY, g.y)
+	}
+	rect := image.Rect(int(minX-pixelSize), int(minY-pixelSize*1.2),
+		int(maxX+pixelSize*1.5)+1, int(maxY+pixelSize*0.5)+1).Intersect(r.img.Bounds())
+	if rect.Empty() {
+		return
+	}
+
+	alpha := image.NewAlpha(rect)
+	ctx := freetype.NewContext()
+	ctx.SetDPI(72)
+	ctx.SetFont(r.loadFont(font))
+	ctx.SetFontSize(pixelSize)
+	ctx.SetClip(rect)
+	ctx.SetDst(alpha)
+	ctx.SetSrc(image.Opaque)
+	for _, g := range glyphs {
+		if g.ch == ' ' || g.ch == 0 {
+			continue
+		}
+		pt := fixed.Point26_6{X: fixed.Int26_6(g.x * 64), Y: fixed.Int26_6(g.y * 64)}
+		ctx.DrawString(string(g.ch), pt)
+	}
+
+	mask := &coverageMask{rect: rect, cov: make([]float32, rect.Dx()*rect.Dy())}
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			mask.cov[y*rect.Dx()+x] = float32(alpha.Pix[y*alpha.Stride+x]) / 255
+		}
+	}
+
+	switch r.state.renderMode {
+	case 1, 5:
+		r.paint(mask, r.state.stroke, r.state.strokeAlpha)
+	default:
+		r.paint(mask, r.state.fill, r.state.fillAlpha)
+	}
+}
+
+// fontKey identifies a font dictionary for caching
+func fontKey(font Dictionary) string {
+	name, _ := font.GetName("BaseFont")
+	subtype, _ := font.GetName("Subtype")
+	return string(subtype) + "/" + string(name)
+}
+
+// loadFont returns a TrueType font for rendering a PDF font, falling back
+// to a system font
+func (r *contentRasterizer) loadFont(font Dictionary) *truetype.Font {
+	if r.fonts == nil {
+		r.fonts = NewFontRenderer(72)
+	}
+	key := fontKey(font)
+	if ttf, ok := r.ttfFonts[key]; ok {
+		return ttf
+	}
+	ttf := r.fonts.GetFallbackFont()
+	if font != nil {
+		if loaded, err := r.fonts.LoadPDFFont(font, r.doc); err == nil && loaded != nil {
+			ttf = loaded
+		}
+	}
+	r.ttfFonts[key] = ttf
+	return ttf
+}
+
+// fontToUnicode returns the ToUnicode mapping of a font, if any
+func (r *contentRasterizer) fontToUnicode(font Dictionary) map[uint16]rune {
+	key := fontKey(font)
+	if m, ok := r.toUnicode[key]; ok {
+		return m
+	}
+	m := make(map[uint16]rune)
+	if font != nil {
+		if obj, err := r.doc.ResolveObject(font.Get("ToUnicode")); err == nil {
+			if stream, ok := obj.(Stream); ok {
+				if data, err := stream.Decode(); err == nil {
+					ParseCMapData(data, m)
+				}
+			}
+		}
+	}
+	r.toUnicode[key] = m
+	return m
+}
+
+// simpleCodeRune maps a character code without a ToUnicode entry, treating
+// single-byte codes as WinAnsiEncoding
+func simpleCodeRune(code uint16, twoByte bool) rune {
+	if !twoByte {
+		for ch, b := range winAnsiSpecial {
+			if uint16(b) == code {
+				return ch
+			}
+		}
+	}
+	return rune(code)
+}
+
+// fontGlyphWidth returns the width of a glyph in thousandths of text
+// space units, using /Widths (or /DW for composite fonts) and falling
+// back to Helvetica metrics
+func fontGlyphWidth(font Dictionary, code uint16, twoByte bool) float64 {
+	if twoByte {
+		if dw, ok := font.GetFloat("DW"); ok {
+			return dw
+		}
+		return 1000
+	}
+	if widths, ok := font.GetArray("Widths"); ok {
+		first, _ := font.GetInt("FirstChar")
+		if i := int(code) - int(first); i >= 0 && i < len(widths) {
+			return objectToFloat(widths[i])
+		}
+	}
+	if code >= 32 && int(code-32) < len(helveticaWidths) {
+		return float64(helveticaWidths[code-32])
+	}
+	return 556
+}
+
+// drawXObject paints a named image or form XObject
+func (r *contentRasterizer) drawXObject(resources Dictionary, name string) {
+	if resources == nil {
+		return
+	}
+	xobjects, ok := resolveDict(r.doc, resources.Get("XObject"))
+	if !ok {
+		return
+	}
+	obj, err := r.doc.ResolveObject(xobjects.Get(name))
+	if err != nil {
+		return
+	}
+	stream, ok := obj.(Stream)
+	if !ok {
+		return
+	}
+
+	switch subtype, _ := stream.Dictionary.GetName("Subtype"); subtype {
+	case "Form":
+		r.drawForm(stream, resources)
+	case "Image":
+		r.drawImage(stream, resources)
+	}
+}
+
+// drawImage paints an image XObject or inline image into the unit square
+// of the CTM. Stencil masks are painted with the fill colour.
+func (r *contentRasterizer) drawImage(stream Stream, resources Dictionary) {
+	src, err := r.doc.paintableImage(stream, resources, r.state.fill)
+	if err != nil {
+		return
+	}
+	drawTransformedImage(r.img, src, r.state.ctm, r.state.clip, r.state.fillAlpha, imageInterpolates(stream.Dictionary))
+}
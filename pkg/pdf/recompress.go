@@ -0,0 +1,216 @@
+package pdf
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BilevelCompression selects the filter used for 1-bit images
+type BilevelCompression int
+
+const (
+	// BilevelCCITT uses CCITT Group 4 (CCITTFaxDecode)
+	BilevelCCITT BilevelCompression = iota
+	// BilevelJBIG2 uses a JBIG2 generic region
+	BilevelJBIG2
+	// BilevelJBIG2Symbols uses a JBIG2 symbol dictionary and text region
+	BilevelJBIG2Symbols
+	// BilevelAuto tries every method and keeps the smallest result
+	BilevelAuto
+)
+
+// String returns the name of a compression method
+func (c BilevelCompression) String() string {
+	switch c {
+	case BilevelCCITT:
+		return "ccitt"
+	case BilevelJBIG2:
+		return "jbig2"
+	case BilevelJBIG2Symbols:
+		return "jbig2-symbols"
+	case BilevelAuto:
+		return "auto"
+	}
+	return fmt.Sprintf("BilevelCompression(%d)", int(c))
+}
+
+// ParseBilevelCompression parses a method name as returned by String
+func ParseBilevelCompression(name string) (BilevelCompression, error) {
+	for c := BilevelCCITT; c <= BilevelAuto; c++ {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown bilevel compression %q", name)
+}
+
+// compressBilevel compresses packed 1-bit samples, 0 meaning black, and
+// returns the data with its Filter and DecodeParms entries
+func compressBilevel(data []byte, width, height int, method BilevelCompression) ([]byte, Name, Object, error) {
+	switch method {
+	case BilevelCCITT:
+		out, params, err := EncodeCCITTFax(data, width, height)
+		return out, "CCITTFaxDecode", params, err
+	case BilevelJBIG2, BilevelJBIG2Symbols:
+		enc := &JBIG2Encoder{Symbols: method == BilevelJBIG2Symbols}
+		out, err := enc.Encode(data, width, height)
+		return out, "JBIG2Decode", nil, err
+	case BilevelAuto:
+		var best []byte
+		var filter Name
+		var params Object
+		for m := BilevelCCITT; m < BilevelAuto; m++ {
+			out, f, p, err := compressBilevel(data, width, height, m)
+			if err != nil {
+				return nil, "", nil, err
+			}
+			if best == nil || len(out) < len(best) {
+				best, filter, params = out, f, p
+			}
+		}
+		return best, filter, params, nil
+	}
+	return nil, "", nil, fmt.Errorf("unknown bilevel compression %d", int(method))
+}
+
+// NewBilevelImage creates a DeviceGray image XObject from packed rows of
+// 1-bit samples, 0 meaning black
+func NewBilevelImage(data []byte, width, height int, method BilevelCompression) (Stream, error) {
+	out, filter, params, err := compressBilevel(data, width, height, method)
+	if err != nil {
+		return Stream{}, err
+	}
+	dict := Dictionary{
+		"Type":             Name("XObject"),
+		"Subtype":          Name("Image"),
+		"Width":            Integer(width),
+		"Height":           Integer(height),
+		"ColorSpace":       Name("DeviceGray"),
+		"BitsPerComponent": Integer(1),
+		"Filter":           filter,
+	}
+	if params != nil {
+		dict["DecodeParms"] = params
+	}
+	return Stream{Dictionary: dict, Data: out}, nil
+}
+
+// RecompressOptions control RecompressBilevelImages
+type RecompressOptions struct {
+	Method BilevelCompression
+	// Force keeps the new stream even when it is not smaller
+	Force bool
+}
+
+// RecompressResult summarises a recompression pass
+type RecompressResult struct {
+	Images        int // bilevel images found
+	Recompressed  int // images replaced
+	OriginalBytes int // stream size of the replaced images before
+	NewBytes      int // and after recompression
+	Skipped       map[int]string
+}
+
+// RecompressBilevelImages recompresses the 1-bit images of the document
+// behind w. Samples are kept unchanged, so the result is lossless. Use
+// Rewrite to drop the original streams from the output.
+func RecompressBilevelImages(w *IncrementalWriter, opts RecompressOptions) (*RecompressResult, error) {
+	doc := w.doc
+	if doc.security != nil || doc.Trailer.Get("Encrypt") != nil {
+		return nil, fmt.Errorf("recompressing encrypted documents is not supported")
+	}
+	res := &RecompressResult{Skipped: make(map[int]string)}
+
+	nums := make([]int, 0, len(doc.xref))
+	for num, entry := range doc.xref {
+		if entry.InUse {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+
+	for _, num := range nums {
+		obj, err := doc.GetObject(num)
+		if err != nil {
+			continue
+		}
+		stream, ok := obj.(Stream)
+		if !ok || !isBilevelImage(doc, stream.Dictionary) {
+			continue
+		}
+		res.Images++
+
+		width, _ := stream.Dictionary.GetInt("Width")
+		height, _ := stream.Dictionary.GetInt("Height")
+		if stream.Dictionary.Get("F") != nil {
+			res.Skipped[num] = "external stream"
+			continue
+		}
+		data, err := doc.resolveJBIG2Globals(stream).Decode()
+		if err != nil {
+			res.Skipped[num] = err.Error()
+			continue
+		}
+		if len(data) < (int(width)+7)/8*int(height) {
+			res.Skipped[num] = "truncated image data"
+			continue
+		}
+
+		out, filter, params, err := compressBilevel(data, int(width), int(height), opts.Method)
+		if err != nil {
+			res.Skipped[num] = err.Error()
+			continue
+		}
+		if !opts.Force && len(out) >= len(stream.Data) {
+			res.Skipped[num] = "not smaller"
+			continue
+		}
+
+		dict := cloneDict(stream.Dictionary)
+		for _, key := range []Name{"Filter", "DecodeParms", "DL", "Length"} {
+			delete(dict, key)
+		}
+		dict["Filter"] = filter
+		if params != nil {
+			dict["DecodeParms"] = params
+		}
+		w.UpdateObject(Reference{ObjectNumber: num}, Stream{Dictionary: dict, Data: out})
+		res.Recompressed++
+		res.OriginalBytes += len(stream.Data)
+		res.NewBytes += len(out)
+	}
+	return res, nil
+}
+
+// isBilevelImage reports whether an image dictionary describes one
+// component of 1 bit per pixel
+func isBilevelImage(doc *Document, dict Dictionary) bool {
+	if subtype, _ := dict.GetName("Subtype"); subtype != "Image" {
+		return false
+	}
+	if mask, ok := dict.Get("ImageMask").(Boolean); ok && bool(mask) {
+		return true
+	}
+	if bpc, ok := dict.GetInt("BitsPerComponent"); !ok || bpc != 1 {
+		return false
+	}
+	cs := doc.resolve(dict.Get("ColorSpace"))
+	if arr, ok := cs.(Array); ok && len(arr) > 0 {
+		cs = arr[0]
+		switch cs {
+		case Name("Indexed"), Name("I"), Name("Separation"):
+			return true
+		case Name("DeviceN"):
+			if len(arr) < 2 {
+				return false
+			}
+			names, _ := doc.resolve(arr[1]).(Array)
+			return len(names) == 1
+		}
+	}
+	switch cs {
+	case Name("DeviceGray"), Name("G"), Name("CalGray"):
+		return true
+	}
+	return false
+}
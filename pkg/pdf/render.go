@@ -3,7 +3,6 @@ package pdf
 
 import (
 	"bytes"
-	"compress/zlib"
 	"encoding/binary"
 	"fmt"
 	"image"
@@ -31,12 +30,26 @@ type RenderOptions struct {
 	ScaleToY  int    // Scale height to specified size
 	OwnerPwd  string // Owner password
 	UserPwd   string // User password
+
+	Annotations AnnotationMode // Which annotations to draw (default: as displayed)
 }
 
+// AnnotationMode selects the annotations drawn by PageRenderer
+type AnnotationMode int
+
+const (
+	AnnotationsDisplay AnnotationMode = iota // Annotations visible on screen
+	AnnotationsPrint                         // Only annotations with the Print flag
+	AnnotationsNone                          // No annotations
+)
+
 // PageRenderer renders PDF pages to images
 type PageRenderer struct {
 	doc     *Document
 	options RenderOptions
+
+	xfaPages  []*XFAPage // pages laid out from the document's XFA form
+	xfaLoaded bool
 }
 
 // NewPageRenderer creates a new page renderer
@@ -62,21 +75,57 @@ type RenderedPage struct {
 	Format  string
 }
 
+// NumPages returns the number of pages the renderer produces. For a
+// document rendered from its XFA form this is the number of laid out
+// pages rather than the number of PDF pages.
+func (r *PageRenderer) NumPages() int {
+	if pages := r.xfaLayout(); pages != nil {
+		return len(pages)
+	}
+	return r.doc.NumPages()
+}
+
+// xfaLayout returns the laid out XFA pages when the document's pages have
+// to be generated from its XFA form, or nil
+func (r *PageRenderer) xfaLayout() []*XFAPage {
+	if !r.xfaLoaded {
+		r.xfaLoaded = true
+		if r.doc.needsXFARendering() {
+			if xfa, err := NewXFAForm(r.doc); err == nil {
+				r.xfaPages, _ = xfa.Layout()
+			}
+		}
+	}
+	return r.xfaPages
+}
+
 // RenderPage renders a single page to an image
 func (r *PageRenderer) RenderPage(pageNum int) (*RenderedPage, error) {
-	if pageNum < 1 || pageNum > r.doc.NumPages() {
+	if pageNum < 1 || pageNum > r.NumPages() {
 		return nil, fmt.Errorf("invalid page number: %d", pageNum)
 	}
 
+	if pages := r.xfaLayout(); pages != nil {
+		xp := pages[pageNum-1]
+		return r.renderImage(pageNum, xp.Width, xp.Height, func(img *image.RGBA, width, height int) {
+			r.renderXFAPage(xp, img, width, height)
+		})
+	}
+
 	page, err := r.doc.GetPage(pageNum)
 	if err != nil {
 		return nil, err
 	}
+	return r.renderImage(pageNum, page.Width(), page.Height(), func(img *image.RGBA, width, height int) {
+		r.renderPageContent(page, img, width, height)
+	})
+}
 
+// renderImage creates an image for a page of the given size in points,
+// draws it with paint and encodes it in the requested format
+func (r *PageRenderer) renderImage(pageNum int, pageWidth, pageHeight float64, paint func(img *image.RGBA, width, height int)) (*RenderedPage, error) {
 	// Calculate dimensions based on DPI
 	scale := r.options.DPI / 72.0
-	pageWidth := page.Width()
-	pageHeight := page.Height()
 	width := int(math.Ceil(pageWidth * scale))
 	height := int(math.Ceil(pageHeight * scale))
 
@@ -94,16 +143,17 @@ func (r *PageRenderer) RenderPage(pageNum int) (*RenderedPage, error) {
 	// Create image
 	var img image.Image
 	if r.options.Gray {
-		img = r.renderGray(page, width, height)
+		img = r.renderGray(paint, width, height)
 	} else if r.options.Mono {
-		img = r.renderMono(page, width, height)
+		img = r.renderMono(paint, width, height)
 	} else {
-		img = r.renderRGBA(page, width, height)
+		img = r.renderRGBA(paint, width, height)
 	}
 
 	// Encode to requested format
 	var data []byte
 	var format string
+	var err error
 
 	switch strings.ToLower(r.options.Format) {
 	case "png":
@@ -141,8 +191,8 @@ func (r *PageRenderer) RenderPages(firstPage, lastPage int) ([]*RenderedPage, er
 	if firstPage < 1 {
 		firstPage = 1
 	}
-	if lastPage == 0 || lastPage > r.doc.NumPages() {
-		lastPage = r.doc.NumPages()
+	if lastPage == 0 || lastPage > r.NumPages() {
+		lastPage = r.NumPages()
 	}
 
 	var pages []*RenderedPage
@@ -157,7 +207,7 @@ func (r *PageRenderer) RenderPages(firstPage, lastPage int) ([]*RenderedPage, er
 }
 
 // renderRGBA renders page to RGBA image
-func (r *PageRenderer) renderRGBA(page *Page, width, height int) *image.RGBA {
+func (r *PageRenderer) renderRGBA(paint func(img *image.RGBA, width, height int), width, height int) *image.RGBA {
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
 
 	// Fill with white background
@@ -168,19 +218,19 @@ func (r *PageRenderer) renderRGBA(page *Page, width, height int) *image.RGBA {
 	}
 
 	// Render page content
-	r.renderPageContent(page, img, width, height)
+	paint(img, width, height)
 
 	return img
 }
 
 // renderGray renders page to grayscale image
-func (r *PageRenderer) renderGray(page *Page, width, height int) *image.Gray {
+func (r *PageRenderer) renderGray(paint func(img *image.RGBA, width, height int), width, height int) *image.Gray {
+	rgba := r.renderRGBA(paint, width, height)
 	img := image.NewGray(image.Rect(0, 0, width, height))
 
-	// Fill with white background
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			img.Set(x, y, color.White)
+			img.Set(x, y, color.GrayModel.Convert(rgba.RGBAAt(x, y)))
 		}
 	}
 
@@ -188,8 +238,8 @@ func (r *PageRenderer) renderGray(page *Page, width, height int) *image.Gray {
 }
 
 // renderMono renders page to monochrome image
-func (r *PageRenderer) renderMono(page *Page, width, height int) *image.Gray {
-	gray := r.renderGray(page, width, height)
+func (r *PageRenderer) renderMono(paint func(img *image.RGBA, width, height int), width, height int) *image.Gray {
+	gray := r.renderGray(paint, width, height)
 
 	// Apply threshold
 	for y := 0; y < height; y++ {
@@ -209,116 +259,104 @@ func (r *PageRenderer) renderMono(page *Page, width, height int) *image.Gray {
 // renderPageContent renders page content to RGBA image
 func (r *PageRenderer) renderPageContent(page *Page, img *image.RGBA, width, height int) {
 	contents, err := page.GetContents()
-	if err != nil || contents == nil {
-		return
+	if err == nil && contents != nil {
+		// Extract and render images from page
+		r.renderImages(page, img, width, height)
 	}
 
-	// Extract and render images from page
-	r.renderImages(page, img, width, height)
+	r.renderAnnotations(page, img, width, height)
 }
 
-// renderImages renders images from page
-func (r *PageRenderer) renderImages(page *Page, img *image.RGBA, width, height int) {
-	if page.Resources == nil {
-		return
-	}
+// renderXFAPage draws a page laid out from the XFA form
+func (r *PageRenderer) renderXFAPage(page *XFAPage, img *image.RGBA, width, height int) {
+	content, resources := page.ContentStream()
+	sx := float64(width) / page.Width
+	sy := float64(height) / page.Height
+	raster := newContentRasterizer(r.doc, img, Matrix{sx, 0, 0, -sy, 0, page.Height * sy})
+	raster.run(content, resources)
+}
 
-	xobjects := page.Resources.Get("XObject")
-	if xobjects == nil {
+// renderAnnotations draws the normal appearance streams of the page's
+// annotations on top of the page content. Hidden annotations are skipped;
+// NoView or Print decide visibility depending on the annotation mode, and
+// the /AS entry selects the state of multi-state appearances.
+func (r *PageRenderer) renderAnnotations(page *Page, img *image.RGBA, width, height int) {
+	if r.options.Annotations == AnnotationsNone {
 		return
 	}
-
-	xobjDict, ok := xobjects.(Dictionary)
-	if !ok {
+	annots, ok := resolveArray(r.doc, page.Dictionary.Get("Annots"))
+	if !ok || len(annots) == 0 {
 		return
 	}
 
-	pageWidth := page.Width()
-	scale := float64(width) / pageWidth
+	// Map page space to device pixels with the origin at the top left
+	box := page.MediaBox
+	sx := float64(width) / page.Width()
+	sy := float64(height) / page.Height()
+	pageMatrix := Matrix{sx, 0, 0, -sy, -box.LLX * sx, box.URY * sy}
 
-	for name := range xobjDict {
-		obj := xobjDict.Get(string(name))
-		if obj == nil {
-			continue
-		}
+	form := r.doc.acroForm()
+	var raster *contentRasterizer
 
-		streamObj, err := page.doc.ResolveObject(obj)
-		if err != nil {
+	for _, annotObj := range annots {
+		annot, ok := resolveDict(r.doc, annotObj)
+		if !ok || !r.annotationVisible(annot) {
 			continue
 		}
 
-		stream, ok := streamObj.(Stream)
-		if !ok {
-			continue
+		var ap *Stream
+		subtype, _ := annot.GetName("Subtype")
+		if !needsAppearance(r.doc, form, annot) {
+			ap, _ = annotationAppearance(r.doc, annot, "N")
 		}
-
-		subtype, _ := stream.Dictionary.GetName("Subtype")
-		if subtype != "Image" {
-			continue
+		if ap == nil {
+			if subtype == "Widget" {
+				ap = generateWidgetAppearance(r.doc, form, annot)
+			} else if annot.Get("AP") == nil {
+				ap = buildAnnotationAppearance(r.doc, annot)
+			}
 		}
-
-		imgWidth, _ := stream.Dictionary.GetInt("Width")
-		imgHeight, _ := stream.Dictionary.GetInt("Height")
-		if imgWidth == 0 || imgHeight == 0 {
+		if ap == nil {
 			continue
 		}
 
-		imgData, err := r.decodeImageStream(stream)
-		if err != nil {
+		m, ok := appearanceMatrix(ap, annot)
+		if !ok {
 			continue
 		}
-
-		scaledWidth := int(float64(imgWidth) * scale)
-		scaledHeight := int(float64(imgHeight) * scale)
-
-		r.drawImage(img, imgData, int(imgWidth), int(imgHeight), 0, 0, scaledWidth, scaledHeight)
+		if raster == nil {
+			raster = newContentRasterizer(r.doc, img, pageMatrix)
+		}
+		raster.state.ctm = Matrix{m[0], m[1], m[2], m[3], m[4], m[5]}.Multiply(pageMatrix)
+		raster.drawForm(*ap, nil)
 	}
 }
 
-// decodeImageStream decodes image data from PDF stream
-func (r *PageRenderer) decodeImageStream(stream Stream) ([]byte, error) {
-	filter, _ := stream.Dictionary.GetName("Filter")
-
-	data := stream.Data
-	var err error
-
-	switch filter {
-	case "FlateDecode":
-		data, err = r.decodeFlateDecode(data)
-	case "DCTDecode":
-		return data, nil
-	case "JPXDecode":
-		return data, nil
+// annotationVisible reports whether an annotation is drawn in the
+// current annotation mode
+func (r *PageRenderer) annotationVisible(annot Dictionary) bool {
+	flags, _ := annot.GetInt("F")
+	if flags&AnnotFlagHidden != 0 {
+		return false
 	}
-
-	return data, err
-}
-
-// decodeFlateDecode decompresses zlib data
-func (r *PageRenderer) decodeFlateDecode(data []byte) ([]byte, error) {
-	reader, err := zlib.NewReader(bytes.NewReader(data))
-	if err != nil {
-		return nil, err
+	if subtype, _ := annot.GetName("Subtype"); subtype == "Popup" {
+		return false
 	}
-	defer reader.Close()
-
-	return io.ReadAll(reader)
+	if r.options.Annotations == AnnotationsPrint {
+		return flags&AnnotFlagPrint != 0
+	}
+	return flags&AnnotFlagNoView == 0
 }
 
-// drawImage draws decoded image data onto target image
-func (r *PageRenderer) drawImage(target *image.RGBA, data []byte, srcW, srcH, dstX, dstY, dstW, dstH int) {
-	for y := 0; y < dstH; y++ {
-		srcY := y * srcH / dstH
-		for x := 0; x < dstW; x++ {
-			srcX := x * srcW / dstW
-			idx := (srcY*srcW + srcX) * 3
-
-			if idx+2 < len(data) {
-				c := color.RGBA{data[idx], data[idx+1], data[idx+2], 255}
-				target.Set(dstX+x, dstY+y, c)
-			}
-		}
+// renderImages renders the image XObjects and inline images painted by
+// the page content, placed by the current transformation matrix
+func (r *PageRenderer) renderImages(page *Page, img *image.RGBA, width, height int) {
+	if page.Width() <= 0 || page.Height() <= 0 {
+		return
 	}
+	scaleX := float64(width) / page.Width()
+	scaleY := float64(height) / page.Height()
+	(&Renderer{doc: r.doc}).renderImagesWithTransform(page, img, width, height, scaleX, scaleY)
 }
 
 // encodePNG encodes image to PNG format
@@ -773,342 +811,20 @@ func (r *Renderer) renderPageBase(pageNum int) (*RenderedImage, error) {
 	}, nil
 }
 
-// renderImagesWithTransform renders images with proper transformation matrix
+// renderImagesWithTransform paints the image XObjects and inline images
+// of a page through the content rasterizer, which places them by the full
+// CTM and clips them to the current clip path. Paths and text are skipped.
 func (r *Renderer) renderImagesWithTransform(page *Page, img *image.RGBA, width, height int, scaleX, scaleY float64) {
 	contents, err := page.GetContents()
 	if err != nil || contents == nil {
 		return
 	}
 
-	// Parse content stream to get image operations with transforms
-	parser := NewContentStreamParser(contents)
-	ops, err := parser.ParseOperations()
-	if err != nil {
-		return
-	}
-
-	// Graphics state stack
-	type graphicsState struct {
-		ctm [6]float64 // Current Transformation Matrix
-	}
-
-	// Initialize with identity matrix
-	currentState := &graphicsState{
-		ctm: [6]float64{1, 0, 0, 1, 0, 0},
-	}
-	stateStack := []*graphicsState{}
-
-	// Process operations
-	for _, op := range ops {
-		switch op.Operator {
-		case "q": // Save graphics state
-			// Clone current state
-			newState := &graphicsState{
-				ctm: currentState.ctm,
-			}
-			stateStack = append(stateStack, currentState)
-			currentState = newState
-
-		case "Q": // Restore graphics state
-			if len(stateStack) > 0 {
-				currentState = stateStack[len(stateStack)-1]
-				stateStack = stateStack[:len(stateStack)-1]
-			}
-
-		case "cm": // Concatenate matrix
-			if len(op.Operands) >= 6 {
-				a := objectToFloat(op.Operands[0])
-				b := objectToFloat(op.Operands[1])
-				c := objectToFloat(op.Operands[2])
-				d := objectToFloat(op.Operands[3])
-				e := objectToFloat(op.Operands[4])
-				f := objectToFloat(op.Operands[5])
-
-				// Concatenate: CTM' = CTM * M
-				oldCTM := currentState.ctm
-				currentState.ctm = [6]float64{
-					a*oldCTM[0] + b*oldCTM[2],
-					a*oldCTM[1] + b*oldCTM[3],
-					c*oldCTM[0] + d*oldCTM[2],
-					c*oldCTM[1] + d*oldCTM[3],
-					e*oldCTM[0] + f*oldCTM[2] + oldCTM[4],
-					e*oldCTM[1] + f*oldCTM[3] + oldCTM[5],
-				}
-			}
-
-		case "Do": // Draw XObject
-			if len(op.Operands) < 1 {
-				continue
-			}
-
-			xobjName, ok := op.Operands[0].(Name)
-			if !ok {
-				continue
-			}
-
-			// Get XObject from resources
-			if page.Resources == nil {
-				continue
-			}
-
-			xobjects := page.Resources.Get("XObject")
-			if xobjects == nil {
-				continue
-			}
-
-			xobjDict, ok := xobjects.(Dictionary)
-			if !ok {
-				continue
-			}
-
-			xobjRef := xobjDict.Get(string(xobjName))
-			if xobjRef == nil {
-				continue
-			}
-
-			streamObj, err := page.doc.ResolveObject(xobjRef)
-			if err != nil {
-				continue
-			}
-
-			stream, ok := streamObj.(Stream)
-			if !ok {
-				continue
-			}
-
-			subtype, _ := stream.Dictionary.GetName("Subtype")
-			if subtype != "Image" {
-				continue
-			}
-
-			// Render image with current transformation matrix
-			r.renderImageWithCTM(img, stream, currentState.ctm, page.Height(), scaleX, scaleY)
-		}
-	}
-}
-
-// renderImageWithCTM renders an image with the given transformation matrix
-func (r *Renderer) renderImageWithCTM(img *image.RGBA, stream Stream, ctm [6]float64, pageHeight float64, scaleX, scaleY float64) {
-	imgWidth, _ := stream.Dictionary.GetInt("Width")
-	imgHeight, _ := stream.Dictionary.GetInt("Height")
-	if imgWidth == 0 || imgHeight == 0 {
-		return
-	}
-
-	// Decode image to Go image
-	goImg, err := r.decodeStreamToImage(stream)
-	if err != nil {
-		return
-	}
-
-	// The CTM transforms from image space (0,0)-(1,1) to user space
-	// PDF coordinate system: origin at bottom-left, Y increases upward
-	// Image coordinate system: origin at top-left, Y increases downward
-
-	// Transform image corners from image space to user space
-	// Bottom-left corner (0, 0) in image space
-	x0 := ctm[4]
-	y0 := ctm[5]
-
-	// Calculate width and height in user space (absolute values)
-	userWidth := math.Abs(ctm[0])
-	userHeight := math.Abs(ctm[3])
-
-	// Transform to device coordinates
-	// Convert from PDF coordinates (bottom-left origin) to image coordinates (top-left origin)
-	deviceX := x0 * scaleX
-	deviceY := (pageHeight - y0 - userHeight) * scaleY
-	deviceWidth := userWidth * scaleX
-	deviceHeight := userHeight * scaleY
-
-	dstX := int(math.Round(deviceX))
-	dstY := int(math.Round(deviceY))
-	dstW := int(math.Round(deviceWidth))
-	dstH := int(math.Round(deviceHeight))
-
-	// Ensure positive dimensions
-	if dstW < 0 {
-		dstX += dstW
-		dstW = -dstW
-	}
-	if dstH < 0 {
-		dstY += dstH
-		dstH = -dstH
-	}
-
-	// Draw image
-	r.drawGoImageToTarget(img, goImg, dstX, dstY, dstW, dstH)
-}
-
-// decodeStreamToImage decodes a PDF image stream to a Go image
-func (r *Renderer) decodeStreamToImage(stream Stream) (image.Image, error) {
-	// Get filter
-	filter, _ := stream.Dictionary.GetName("Filter")
-
-	// Handle JPEG images directly
-	if filter == "DCTDecode" {
-		return jpeg.Decode(bytes.NewReader(stream.Data))
-	}
-
-	// Get image properties
-	imgWidth, _ := stream.Dictionary.GetInt("Width")
-	imgHeight, _ := stream.Dictionary.GetInt("Height")
-	bitsPerComponent, _ := stream.Dictionary.GetInt("BitsPerComponent")
-	if bitsPerComponent == 0 {
-		bitsPerComponent = 8
-	}
-
-	// Get color space
-	colorSpace := "DeviceRGB"
-	components := 3
-
-	if cs := stream.Dictionary.Get("ColorSpace"); cs != nil {
-		if name, ok := cs.(Name); ok {
-			colorSpace = string(name)
-			switch colorSpace {
-			case "DeviceGray":
-				components = 1
-			case "DeviceRGB":
-				components = 3
-			case "DeviceCMYK":
-				components = 4
-			}
-		}
-	}
-
-	// Decode stream data
-	data := stream.Data
-
-	switch filter {
-	case "FlateDecode":
-		reader, err := zlib.NewReader(bytes.NewReader(data))
-		if err != nil {
-			return nil, err
-		}
-		defer reader.Close()
-		data, err = io.ReadAll(reader)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	// Convert to Go image based on color space
-	switch components {
-	case 1: // Grayscale
-		return r.decodeGrayImageData(data, int(imgWidth), int(imgHeight))
-	case 3: // RGB
-		return r.decodeRGBImageData(data, int(imgWidth), int(imgHeight))
-	case 4: // CMYK
-		return r.decodeCMYKImageData(data, int(imgWidth), int(imgHeight))
-	default:
-		return r.decodeRGBImageData(data, int(imgWidth), int(imgHeight))
-	}
-}
-
-// decodeGrayImageData decodes grayscale image data
-func (r *Renderer) decodeGrayImageData(data []byte, width, height int) (image.Image, error) {
-	img := image.NewGray(image.Rect(0, 0, width, height))
-	expectedSize := width * height
-
-	if len(data) < expectedSize {
-		return nil, fmt.Errorf("insufficient gray data: got %d, need %d", len(data), expectedSize)
-	}
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			idx := y*width + x
-			img.SetGray(x, y, color.Gray{Y: data[idx]})
-		}
-	}
-
-	return img, nil
-}
-
-// decodeRGBImageData decodes RGB image data
-func (r *Renderer) decodeRGBImageData(data []byte, width, height int) (image.Image, error) {
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	expectedSize := width * height * 3
-
-	if len(data) < expectedSize {
-		return nil, fmt.Errorf("insufficient RGB data: got %d, need %d", len(data), expectedSize)
-	}
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			idx := (y*width + x) * 3
-			img.SetRGBA(x, y, color.RGBA{
-				R: data[idx],
-				G: data[idx+1],
-				B: data[idx+2],
-				A: 255,
-			})
-		}
-	}
-
-	return img, nil
-}
-
-// decodeCMYKImageData decodes CMYK image data and converts to RGB
-func (r *Renderer) decodeCMYKImageData(data []byte, width, height int) (image.Image, error) {
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	expectedSize := width * height * 4
-
-	if len(data) < expectedSize {
-		return nil, fmt.Errorf("insufficient CMYK data: got %d, need %d", len(data), expectedSize)
-	}
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			idx := (y*width + x) * 4
-			c := float64(data[idx]) / 255.0
-			m := float64(data[idx+1]) / 255.0
-			yVal := float64(data[idx+2]) / 255.0
-			k := float64(data[idx+3]) / 255.0
-
-			// CMYK to RGB conversion
-			rVal := uint8((1.0 - c) * (1.0 - k) * 255.0)
-			gVal := uint8((1.0 - m) * (1.0 - k) * 255.0)
-			bVal := uint8((1.0 - yVal) * (1.0 - k) * 255.0)
-
-			img.SetRGBA(x, y, color.RGBA{R: rVal, G: gVal, B: bVal, A: 255})
-		}
-	}
-
-	return img, nil
-}
-
-// drawGoImageToTarget draws a Go image to target RGBA image with scaling
-func (r *Renderer) drawGoImageToTarget(target *image.RGBA, src image.Image, dstX, dstY, dstW, dstH int) {
-	bounds := target.Bounds()
-	srcBounds := src.Bounds()
-	srcW := srcBounds.Dx()
-	srcH := srcBounds.Dy()
-
-	for y := 0; y < dstH; y++ {
-		dstYPos := dstY + y
-		if dstYPos < bounds.Min.Y || dstYPos >= bounds.Max.Y {
-			continue
-		}
-
-		srcY := srcBounds.Min.Y + (y * srcH / dstH)
-		if srcY >= srcBounds.Max.Y {
-			srcY = srcBounds.Max.Y - 1
-		}
-
-		for x := 0; x < dstW; x++ {
-			dstXPos := dstX + x
-			if dstXPos < bounds.Min.X || dstXPos >= bounds.Max.X {
-				continue
-			}
-
-			srcX := srcBounds.Min.X + (x * srcW / dstW)
-			if srcX >= srcBounds.Max.X {
-				srcX = srcBounds.Max.X - 1
-			}
-
-			target.Set(dstXPos, dstYPos, src.At(srcX, srcY))
-		}
-	}
+	// Map page space to device pixels with the origin at the top left
+	box := page.MediaBox
+	raster := newContentRasterizer(r.doc, img, Matrix{scaleX, 0, 0, -scaleY, -box.LLX * scaleX, box.URY * scaleY})
+	raster.imagesOnly = true
+	raster.run(contents, page.Resources)
 }
 
 // drawImageToRGBA draws image data to RGBA image
@@ -0,0 +1,447 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature field and sub-filter names used when signing
+const (
+	SubFilterCAdESDetached = "ETSI.CAdES.detached"
+	SubFilterPKCS7Detached = "adbe.pkcs7.detached"
+	SubFilterRFC3161       = "ETSI.RFC3161"
+)
+
+// AcroForm /SigFlags bits
+const (
+	SigFlagSignaturesExist = 1 << 0
+	SigFlagAppendOnly      = 1 << 1
+)
+
+// SignOptions configures a document signature
+type SignOptions struct {
+	Signer       crypto.Signer       // private key of the signer
+	Certificates []*x509.Certificate // signing certificate first, then its chain
+	Hash         crypto.Hash         // digest algorithm, SHA-256 by default
+
+	// FieldName selects the signature field. An existing unsigned field
+	// of that name is reused; otherwise a new field is created. An empty
+	// name creates a field named "Signature1", "Signature2", ...
+	FieldName string
+
+	Name        string    // signer name, by default the certificate's common name
+	Reason      string    // reason for signing
+	Location    string    // location of signing
+	ContactInfo string    // contact information of the signer
+	SigningTime time.Time // the /M entry, by default the current time
+
+	// Page and Rect place the widget of a new field. A zero Rect creates
+	// an invisible signature. A visible signature shows Text, or a summary
+	// of the signer, time, reason and location when Text is empty.
+	Page int
+	Rect Rectangle
+	Text string
+
+	// TSA, when set, timestamps the signature value (PAdES B-T)
+	TSA TimestampAuthority
+
+	// DocMDP, when 1 to 3, makes this a certification signature that
+	// permits no changes (1), form filling and signing (2), or in
+	// addition annotations (3). Only the first signature may certify.
+	DocMDP int
+
+	// EstimatedSize is the number of bytes reserved for the CMS
+	// signature. Zero estimates it from the certificates.
+	EstimatedSize int
+}
+
+// byteRangePlaceholder is written for /ByteRange until the offsets are
+// known; it is wide enough for files of up to 10 GB
+var byteRangePlaceholder = Array{Integer(0), Integer(9999999999), Integer(9999999999), Integer(9999999999)}
+
+// Sign signs the document and returns it with the signature appended as
+// an incremental update. The signature is a detached CMS SignedData with
+// the ETSI.CAdES.detached sub-filter as required by PAdES baseline
+// signatures, covering the whole file except the signature value itself.
+func (d *Document) Sign(opts SignOptions) ([]byte, error) {
+	if opts.Signer == nil || len(opts.Certificates) == 0 {
+		return nil, fmt.Errorf("sign: a signer and its certificate are required")
+	}
+	if opts.Hash == 0 {
+		opts.Hash = crypto.SHA256
+	}
+	if !opts.Hash.Available() {
+		return nil, fmt.Errorf("sign: digest algorithm %v is not available", opts.Hash)
+	}
+	if opts.SigningTime.IsZero() {
+		opts.SigningTime = time.Now()
+	}
+	if opts.Name == "" {
+		opts.Name = opts.Certificates[0].Subject.CommonName
+	}
+	if opts.DocMDP < 0 || opts.DocMDP > 3 {
+		return nil, fmt.Errorf("sign: invalid DocMDP permission level: %d", opts.DocMDP)
+	}
+	if opts.DocMDP != 0 && len(GetSignatures(d)) > 0 {
+		return nil, fmt.Errorf("sign: only the first signature can certify a document")
+	}
+	signer := &cmsSigner{signer: opts.Signer, chain: opts.Certificates, hash: opts.Hash, tsa: opts.TSA}
+
+	size := opts.EstimatedSize
+	if size == 0 {
+		size = 4096
+		for _, cert := range opts.Certificates {
+			size += len(cert.Raw)
+		}
+		if opts.TSA != nil {
+			size += 8192
+		}
+	}
+
+	sigDict := Dictionary{
+		"Type":      Name("Sig"),
+		"Filter":    Name("Adobe.PPKLite"),
+		"SubFilter": Name(SubFilterCAdESDetached),
+		"M":         String{Value: []byte(formatPDFDate(opts.SigningTime))},
+	}
+	if opts.DocMDP != 0 {
+		sigDict["Reference"] = Array{Dictionary{
+			"Type":            Name("SigRef"),
+			"TransformMethod": Name("DocMDP"),
+			"TransformParams": Dictionary{
+				"Type": Name("TransformParams"),
+				"P":    Integer(opts.DocMDP),
+				"V":    Name("1.2"),
+			},
+		}}
+	}
+	for key, value := range map[Name]string{
+		"Name":        opts.Name,
+		"Reason":      opts.Reason,
+		"Location":    opts.Location,
+		"ContactInfo": opts.ContactInfo,
+	} {
+		if value != "" {
+			sigDict[key] = textString(value)
+		}
+	}
+
+	return d.writeSignedUpdate(signedUpdate{
+		sigDict:   sigDict,
+		hash:      opts.Hash,
+		value:     signer.sign,
+		size:      size,
+		fixedSize: opts.EstimatedSize != 0,
+		prepare: func(w *IncrementalWriter, sigRef Reference) error {
+			if opts.DocMDP != 0 {
+				rootRef, err := w.RootRef()
+				if err != nil {
+					return err
+				}
+				w.EditDictionary(rootRef)["Perms"] = Dictionary{"DocMDP": sigRef}
+			}
+			return d.attachSignatureField(w, sigRef, opts.FieldName, opts.Page, opts.Rect, func(width, height float64) ([]byte, Dictionary) {
+				return signatureAppearance(opts, width, height)
+			})
+		},
+	})
+}
+
+// SignToFile signs the document and writes the result to a file
+func (d *Document) SignToFile(filename string, opts SignOptions) error {
+	data, err := d.Sign(opts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// signedUpdate describes an incremental update carrying a signature
+// whose value is computed over the bytes of the updated file
+type signedUpdate struct {
+	sigDict   Dictionary                          // without /ByteRange and /Contents
+	hash      crypto.Hash                         // digest of the signed bytes
+	value     func(digest []byte) ([]byte, error) // computes the /Contents value
+	size      int                                 // bytes reserved for the value
+	fixedSize bool                                // fail rather than retry when the value is larger
+
+	// prepare adds the signature field and any other objects of the update
+	prepare func(w *IncrementalWriter, sigRef Reference) error
+}
+
+// writeSignedUpdate writes a signed incremental update. A value larger
+// than reserved is retried once with the space it needs, since the
+// reserved size is part of the signed bytes.
+func (d *Document) writeSignedUpdate(u signedUpdate) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		out, value, err := d.writeSignedUpdateOnce(u)
+		if err != nil {
+			return nil, err
+		}
+		if len(value) <= u.size {
+			return out, nil
+		}
+		if attempt > 0 || u.fixedSize {
+			return nil, fmt.Errorf("sign: signature of %d bytes exceeds the %d bytes reserved", len(value), u.size)
+		}
+		u.size = len(value) + 1024
+	}
+}
+
+// writeSignedUpdateOnce writes the update with the reserved space, then
+// fills in the byte range and the value. The value is returned so the
+// caller can tell whether it fit.
+func (d *Document) writeSignedUpdateOnce(u signedUpdate) ([]byte, []byte, error) {
+	w := NewIncrementalWriter(d)
+	sigDict := cloneDict(u.sigDict)
+	sigDict["ByteRange"] = byteRangePlaceholder
+	sigDict["Contents"] = String{Value: make([]byte, u.size), IsHex: true}
+	sigRef := w.AddObject(sigDict)
+	if err := u.prepare(w, sigRef); err != nil {
+		return nil, nil, err
+	}
+
+	out, err := w.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return fillSignature(out, len(d.data), u.size, u.hash, u.value)
+}
+
+// attachSignatureField sets sigRef as the value of the signature field
+// named name, creating the field on a page when it does not exist. A
+// widget with a non-empty rectangle gets the appearance generated by
+// appearance; other widgets an empty one, which PDF/A and some viewers
+// expect. The form's /SigFlags are updated.
+func (d *Document) attachSignatureField(w *IncrementalWriter, sigRef Reference, name string, pageNum int, rect Rectangle, appearance func(width, height float64) ([]byte, Dictionary)) error {
+	rootRef, err := w.RootRef()
+	if err != nil {
+		return err
+	}
+	form := cloneDict(d.acroForm())
+	fields, _ := resolveArray(d, form.Get("Fields"))
+	fields = append(Array(nil), fields...)
+
+	fieldRef, widgetRef, fieldRect, err := d.findSignatureField(name)
+	if err != nil {
+		return err
+	}
+	if fieldRef.ObjectNumber != 0 {
+		rect = fieldRect
+		w.EditDictionary(fieldRef)["V"] = sigRef
+	} else {
+		if pageNum == 0 {
+			pageNum = 1
+		}
+		if pageNum < 1 || pageNum > len(d.Pages) {
+			return fmt.Errorf("sign: invalid page number: %d", pageNum)
+		}
+		page := d.Pages[pageNum-1]
+		if page.ref.ObjectNumber == 0 {
+			return fmt.Errorf("sign: page %d object reference unknown", pageNum)
+		}
+		if name == "" {
+			name = d.newSignatureFieldName()
+		}
+		fieldRef = w.AddObject(Dictionary{
+			"Type":    Name("Annot"),
+			"Subtype": Name("Widget"),
+			"FT":      Name("Sig"),
+			"T":       textString(name),
+			"V":       sigRef,
+			"F":       Integer(AnnotFlagPrint | AnnotFlagLocked),
+			"P":       page.ref,
+			"Rect":    Array{Real(rect.LLX), Real(rect.LLY), Real(rect.URX), Real(rect.URY)},
+		})
+		widgetRef = fieldRef
+		fields = append(fields, fieldRef)
+
+		annots, _ := resolveArray(d, page.Dictionary.Get("Annots"))
+		annots = append(append(Array(nil), annots...), fieldRef)
+		w.EditDictionary(page.ref)["Annots"] = annots
+	}
+
+	width, height := rect.URX-rect.LLX, rect.URY-rect.LLY
+	ap := newAppearanceStream(0, 0, nil, nil)
+	if width > 0 && height > 0 && appearance != nil {
+		content, resources := appearance(width, height)
+		ap = newAppearanceStream(width, height, content, resources)
+	}
+	w.EditDictionary(widgetRef)["AP"] = Dictionary{"N": w.AddObject(ap)}
+
+	form["Fields"] = fields
+	flags, _ := form.GetInt("SigFlags")
+	form["SigFlags"] = Integer(flags | SigFlagSignaturesExist | SigFlagAppendOnly)
+	if ref, ok := d.Root.Get("AcroForm").(Reference); ok {
+		w.UpdateObject(ref, form)
+	} else {
+		w.EditDictionary(rootRef)["AcroForm"] = form
+	}
+	return nil
+}
+
+// fillSignature replaces the byte range placeholder of the signature
+// dictionary written after offset start, digests the signed bytes and
+// writes the value into the reserved /Contents string
+func fillSignature(out []byte, start, size int, hash crypto.Hash, value func([]byte) ([]byte, error)) ([]byte, []byte, error) {
+	placeholder := serializeObject(byteRangePlaceholder)
+	brPos := bytes.Index(out[start:], placeholder)
+	if brPos < 0 {
+		return nil, nil, fmt.Errorf("sign: byte range placeholder not found")
+	}
+	brPos += start
+	contents := []byte("/Contents <" + strings.Repeat("0", 2*size) + ">")
+	cPos := bytes.Index(out[start:], contents)
+	if cPos < 0 {
+		return nil, nil, fmt.Errorf("sign: signature placeholder not found")
+	}
+	// The signed ranges exclude the hex string including its delimiters
+	from := start + cPos + len("/Contents ")
+	to := from + 2*size + 2
+
+	byteRange := fmt.Sprintf("[0 %d %d %d", from, to, len(out)-to)
+	if len(byteRange)+1 > len(placeholder) {
+		return nil, nil, fmt.Errorf("sign: document too large to sign")
+	}
+	byteRange += strings.Repeat(" ", len(placeholder)-len(byteRange)-1) + "]"
+	copy(out[brPos:], byteRange)
+
+	sig, err := value(digestOf(hash, [][]byte{out[:from], out[to:]}))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sig) <= size {
+		copy(out[from+1:], strings.ToUpper(hex.EncodeToString(sig)))
+	}
+	return out, sig, nil
+}
+
+// findSignatureField looks up a signature field by its fully qualified
+// name and returns the field, its widget and the widget rectangle. A zero
+// field reference means the field does not exist.
+func (d *Document) findSignatureField(name string) (field, widget Reference, rect Rectangle, err error) {
+	if name == "" {
+		return
+	}
+	var found *FormField
+	var walk func(fields []*FormField)
+	walk = func(fields []*FormField) {
+		for _, f := range fields {
+			if found != nil {
+				return
+			}
+			if f.Name == name && f.ref.ObjectNumber != 0 {
+				found = f
+				return
+			}
+			walk(f.Kids)
+		}
+	}
+	walk(d.GetFormFields())
+	if found == nil {
+		return
+	}
+
+	dict, _ := resolveDict(d, found.ref)
+	if ft, _ := d.inheritedFieldAttr(dict, "FT").(Name); ft != "Sig" {
+		return field, widget, rect, fmt.Errorf("sign: field %q is not a signature field", name)
+	}
+	if dict.Get("V") != nil {
+		return field, widget, rect, fmt.Errorf("sign: field %q is already signed", name)
+	}
+	field, widget = found.ref, found.ref
+	if kids, ok := resolveArray(d, dict.Get("Kids")); ok && len(kids) > 0 {
+		if ref, ok := kids[0].(Reference); ok {
+			widget = ref
+			dict, _ = resolveDict(d, ref)
+		}
+	}
+	if r, ok := resolveArray(d, dict.Get("Rect")); ok && len(r) == 4 {
+		rect = Rectangle{
+			LLX: objectToFloat(r[0]), LLY: objectToFloat(r[1]),
+			URX: objectToFloat(r[2]), URY: objectToFloat(r[3]),
+		}
+		if rect.LLX > rect.URX {
+			rect.LLX, rect.URX = rect.URX, rect.LLX
+		}
+		if rect.LLY > rect.URY {
+			rect.LLY, rect.URY = rect.URY, rect.LLY
+		}
+	}
+	return field, widget, rect, nil
+}
+
+// newSignatureFieldName returns the first unused name "SignatureN"
+func (d *Document) newSignatureFieldName() string {
+	used := make(map[string]bool)
+	var walk func(fields []*FormField)
+	walk = func(fields []*FormField) {
+		for _, f := range fields {
+			used[f.Name] = true
+			walk(f.Kids)
+		}
+	}
+	walk(d.GetFormFields())
+	for n := 1; ; n++ {
+		name := "Signature" + strconv.Itoa(n)
+		if !used[name] {
+			return name
+		}
+	}
+}
+
+// signatureAppearance generates the content of a visible signature: a
+// border and lines of Helvetica text scaled to fit the box
+func signatureAppearance(opts SignOptions, width, height float64) ([]byte, Dictionary) {
+	var lines []string
+	if opts.Text != "" {
+		lines = strings.Split(opts.Text, "\n")
+	} else {
+		lines = append(lines, "Digitally signed by "+opts.Name)
+		lines = append(lines, "Date: "+opts.SigningTime.Format("2006.01.02 15:04:05 -07'00'"))
+		if opts.Reason != "" {
+			lines = append(lines, "Reason: "+opts.Reason)
+		}
+		if opts.Location != "" {
+			lines = append(lines, "Location: "+opts.Location)
+		}
+	}
+
+	encoded := make([][]byte, len(lines))
+	widest := 0.0
+	for i, line := range lines {
+		encoded[i] = encodeWinAnsi(line)
+		if w := helveticaTextWidth(encoded[i], 1); w > widest {
+			widest = w
+		}
+	}
+	const margin = 2.0
+	size := (height - 2*margin) / (float64(len(lines)) * 1.2)
+	if widest > 0 {
+		size = math.Min(size, (width-2*margin)/widest)
+	}
+	size = math.Min(size, 12)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "q 0.5 w 0 G %s %s %s %s re S Q\n",
+		formatNum(0.25), formatNum(0.25), formatNum(width-0.5), formatNum(height-0.5))
+	buf.WriteString("BT 0 g\n")
+	fmt.Fprintf(&buf, "/Helv %s Tf %s TL\n", formatNum(size), formatNum(size*1.2))
+	fmt.Fprintf(&buf, "%s %s Td\n", formatNum(margin), formatNum(height-margin-size))
+	for i, line := range encoded {
+		if i > 0 {
+			buf.WriteString("T* ")
+		}
+		fmt.Fprintf(&buf, "%s Tj\n", contentString(line))
+	}
+	buf.WriteString("ET\n")
+	return buf.Bytes(), Dictionary{"Font": Dictionary{"Helv": helveticaFont()}}
+}
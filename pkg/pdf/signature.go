@@ -6,8 +6,6 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
-	"io"
-	"net/http"
 	"time"
 
 	"golang.org/x/crypto/ocsp"
@@ -15,6 +13,7 @@ import (
 
 // Signature represents a PDF digital signature
 type Signature struct {
+	FieldName    string
 	Signer       string
 	SigningTime  string
 	Reason       string
@@ -24,7 +23,8 @@ type Signature struct {
 	SubFilter    string
 	Certificate  *x509.Certificate
 	Certificates []*x509.Certificate
-	SignedData   []byte
+	SignedData   []byte // raw /Contents, including any zero padding
+	ByteRange    []int  // offset and length pairs of the signed bytes
 }
 
 // GetSignatures extracts digital signatures from a PDF document
@@ -65,7 +65,7 @@ func GetSignatures(doc *Document) []Signature {
 
 	// Search for signature fields
 	for _, fieldRef := range fields {
-		sig := extractSignatureFromField(doc, fieldRef)
+		sig := extractSignatureFromField(doc, fieldRef, "")
 		if sig != nil {
 			signatures = append(signatures, *sig)
 		}
@@ -74,7 +74,7 @@ func GetSignatures(doc *Document) []Signature {
 	return signatures
 }
 
-func extractSignatureFromField(doc *Document, fieldRef Object) *Signature {
+func extractSignatureFromField(doc *Document, fieldRef Object, parentName string) *Signature {
 	fieldObj, err := doc.ResolveObject(fieldRef)
 	if err != nil {
 		return nil
@@ -85,6 +85,14 @@ func extractSignatureFromField(doc *Document, fieldRef Object) *Signature {
 		return nil
 	}
 
+	name := parentName
+	if t, ok := field.Get("T").(String); ok {
+		if name != "" {
+			name += "."
+		}
+		name += t.Text()
+	}
+
 	// Check field type
 	ft, _ := field.GetName("FT")
 	if ft != "Sig" {
@@ -94,7 +102,7 @@ func extractSignatureFromField(doc *Document, fieldRef Object) *Signature {
 			if err == nil {
 				if kids, ok := kidsObj.(Array); ok {
 					for _, kidRef := range kids {
-						sig := extractSignatureFromField(doc, kidRef)
+						sig := extractSignatureFromField(doc, kidRef, name)
 						if sig != nil {
 							return sig
 						}
@@ -121,7 +129,7 @@ func extractSignatureFromField(doc *Document, fieldRef Object) *Signature {
 		return nil
 	}
 
-	sig := &Signature{}
+	sig := &Signature{FieldName: name}
 
 	// Extract signature info
 	if filter, ok := sigDict.GetName("Filter"); ok {
@@ -146,14 +154,45 @@ func extractSignatureFromField(doc *Document, fieldRef Object) *Signature {
 		sig.SigningTime = objectToString(m)
 	}
 
+	if br, ok := resolveArray(doc, sigDict.Get("ByteRange")); ok {
+		for _, v := range br {
+			n, _ := v.(Integer)
+			sig.ByteRange = append(sig.ByteRange, int(n))
+		}
+	}
+
 	// Extract certificates from Contents
 	if contents := sigDict.Get("Contents"); contents != nil {
+		if s, ok := contents.(String); ok {
+			sig.SignedData = s.Value
+		}
 		sig.Certificates = extractCertificatesFromPKCS7(doc, contents)
 		if len(sig.Certificates) > 0 {
 			sig.Certificate = sig.Certificates[0]
 		}
 	}
 
+	// adbe.x509.rsa_sha1 signatures carry their certificates in /Cert
+	if len(sig.Certificates) == 0 {
+		var raw []Object
+		switch cert := sigDict.Get("Cert").(type) {
+		case String:
+			raw = append(raw, cert)
+		case Array:
+			raw = cert
+		}
+		for _, c := range raw {
+			if s, ok := c.(String); ok {
+				if cert, err := x509.ParseCertificate(s.Value); err == nil {
+					sig.Certificates = append(sig.Certificates, cert)
+				}
+			}
+		}
+		if len(sig.Certificates) > 0 {
+			sig.Certificate = sig.Certificates[0]
+		}
+	}
+
 	return sig
 }
 
@@ -255,149 +294,170 @@ type RevocationInfo struct {
 
 // CheckOCSP checks the revocation status of a certificate using OCSP
 func CheckOCSP(cert, issuer *x509.Certificate) *OCSPStatus {
-	status := &OCSPStatus{Status: "unknown"}
-
-	if len(cert.OCSPServer) == 0 {
-		status.Error = ErrNoOCSPServer
-		return status
-	}
+	return CheckOCSPWith(DefaultRevocationFetcher, cert, issuer)
+}
 
-	// Create OCSP request
-	ocspReq, err := ocsp.CreateRequest(cert, issuer, nil)
+// CheckOCSPWith checks the current revocation status of a certificate
+// with an OCSP response obtained from fetcher
+func CheckOCSPWith(fetcher RevocationFetcher, cert, issuer *x509.Certificate) *OCSPStatus {
+	der, err := fetcher.FetchOCSP(cert, issuer)
 	if err != nil {
-		status.Status = "error"
-		status.Error = err
+		status := &OCSPStatus{Status: "error", Error: err}
+		if err == ErrNoOCSPServer {
+			status.Status = "unknown"
+		}
 		return status
 	}
+	status := CheckOCSPResponse(der, cert, issuer, time.Now())
+	if status == nil {
+		return &OCSPStatus{Status: "error", Error: ErrOCSPCheckFailed}
+	}
+	return status
+}
 
-	// Try each OCSP server
-	for _, server := range cert.OCSPServer {
-		resp, err := sendOCSPRequest(server, ocspReq)
-		if err != nil {
-			continue
-		}
+// CheckCRL checks the revocation status of a certificate using CRL
+func CheckCRL(cert *x509.Certificate) *CRLStatus {
+	return CheckCRLWith(DefaultRevocationFetcher, cert, nil)
+}
 
-		// Parse OCSP response
-		ocspResp, err := ocsp.ParseResponse(resp, issuer)
-		if err != nil {
-			continue
+// CheckCRLWith checks the current revocation status of a certificate
+// with a CRL obtained from fetcher. The CRL signature is verified with
+// issuer; a nil issuer is fetched as well, and when that fails the status
+// is unknown.
+func CheckCRLWith(fetcher RevocationFetcher, cert, issuer *x509.Certificate) *CRLStatus {
+	der, err := fetcher.FetchCRL(cert)
+	if err != nil {
+		if err == ErrNoCRLPoint {
+			return &CRLStatus{Error: err}
 		}
-
-		status.ProducedAt = ocspResp.ProducedAt
-		status.ThisUpdate = ocspResp.ThisUpdate
-		status.NextUpdate = ocspResp.NextUpdate
-
-		switch ocspResp.Status {
-		case ocsp.Good:
-			status.Status = "good"
-		case ocsp.Revoked:
-			status.Status = "revoked"
-			status.RevokedAt = ocspResp.RevokedAt
-			status.RevokeReason = getRevocationReason(ocspResp.RevocationReason)
-		case ocsp.Unknown:
-			status.Status = "unknown"
+		return &CRLStatus{Error: ErrCRLCheckFailed}
+	}
+	if issuer == nil {
+		if issuer, _ = fetcher.FetchIssuer(cert); issuer == nil {
+			return &CRLStatus{Error: ErrCRLNotVerified}
 		}
-
+	}
+	if status := CheckCRLData(der, cert, issuer, time.Now()); status != nil {
 		return status
 	}
+	return &CRLStatus{Error: ErrCRLCheckFailed}
+}
 
-	status.Status = "error"
-	status.Error = ErrOCSPCheckFailed
-	return status
+// CheckRevocation performs both OCSP and CRL checks
+func CheckRevocation(cert, issuer *x509.Certificate) *RevocationInfo {
+	return CheckRevocationWith(DefaultRevocationFetcher, cert, issuer)
 }
 
-// sendOCSPRequest sends an OCSP request to the specified server
-func sendOCSPRequest(server string, request []byte) ([]byte, error) {
-	client := &http.Client{Timeout: 10 * time.Second}
+// CheckRevocationWith performs both OCSP and CRL checks with data
+// obtained from fetcher. A nil issuer is fetched as well.
+func CheckRevocationWith(fetcher RevocationFetcher, cert, issuer *x509.Certificate) *RevocationInfo {
+	info := &RevocationInfo{}
+	if issuer == nil {
+		issuer, _ = fetcher.FetchIssuer(cert)
+	}
 
-	resp, err := client.Post(server, "application/ocsp-request", bytes.NewReader(request))
-	if err != nil {
-		return nil, err
+	// Try OCSP first (faster and more current). Whether a response is
+	// available is up to the fetcher, but it can only be checked against
+	// the issuer.
+	if issuer != nil {
+		info.OCSP = CheckOCSPWith(fetcher, cert, issuer)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, ErrOCSPServerError
+	// Fall back to CRL if OCSP failed or unavailable
+	if info.OCSP == nil || info.OCSP.Status == "error" || info.OCSP.Status == "unknown" {
+		info.CRL = CheckCRLWith(fetcher, cert, issuer)
 	}
 
-	return io.ReadAll(resp.Body)
+	return info
 }
 
-// CheckCRL checks the revocation status of a certificate using CRL
-func CheckCRL(cert *x509.Certificate) *CRLStatus {
-	status := &CRLStatus{}
-
-	if len(cert.CRLDistributionPoints) == 0 {
-		status.Error = ErrNoCRLPoint
-		return status
-	}
-
-	// Try each CRL distribution point
-	for _, crlURL := range cert.CRLDistributionPoints {
-		crlData, err := fetchCRL(crlURL)
-		if err != nil {
-			continue
+// CheckOCSPResponse checks the revocation status of a certificate at a
+// given time using a DER encoded OCSP response, such as one embedded in
+// a document, instead of asking the responder. The response must be
+// signed by issuer or by a responder certificate issued by it. It
+// returns nil when the response is not about cert.
+//
+// A certificate revoked after at was still good at that time. A good
+// response is accepted if it was produced after at, since revocation is
+// permanent, or if at lies before its next update.
+func CheckOCSPResponse(der []byte, cert, issuer *x509.Certificate, at time.Time) *OCSPStatus {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		// Serial numbers are only unique per issuer
+		if _, err := ocsp.ParseResponseForCert(der, cert, nil); err != nil {
+			return nil
 		}
+		return &OCSPStatus{Status: "error", Error: err}
+	}
+	if rc := resp.Certificate; rc != nil && !rc.Equal(issuer) && !hasExtKeyUsage(rc, x509.ExtKeyUsageOCSPSigning) {
+		return &OCSPStatus{Status: "error", Error: ErrOCSPResponderNotAuthorized}
+	}
 
-		// Parse CRL
-		crl, err := x509.ParseRevocationList(crlData)
-		if err != nil {
-			continue
+	status := &OCSPStatus{
+		Status:     "unknown",
+		ProducedAt: resp.ProducedAt,
+		ThisUpdate: resp.ThisUpdate,
+		NextUpdate: resp.NextUpdate,
+	}
+	switch resp.Status {
+	case ocsp.Revoked:
+		if !resp.RevokedAt.After(at) {
+			status.Status = "revoked"
+			status.RevokedAt = resp.RevokedAt
+			status.RevokeReason = getRevocationReason(resp.RevocationReason)
+		} else {
+			status.Status = "good"
 		}
-
-		// Check if certificate is in the revoked list
-		for _, revoked := range crl.RevokedCertificateEntries {
-			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
-				status.IsRevoked = true
-				status.RevokedAt = revoked.RevocationTime
-				status.RevokeReason = getRevocationReasonFromExtensions(revoked.Extensions)
-				return status
-			}
+	case ocsp.Good:
+		if !resp.ThisUpdate.Before(at) || resp.NextUpdate.IsZero() || !at.After(resp.NextUpdate) {
+			status.Status = "good"
+		} else {
+			status.Error = ErrRevocationOutdated
 		}
-
-		// Certificate not found in CRL, it's not revoked
-		status.IsRevoked = false
-		return status
 	}
-
-	status.Error = ErrCRLCheckFailed
 	return status
 }
 
-// fetchCRL downloads a CRL from the specified URL
-func fetchCRL(url string) ([]byte, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, ErrCRLFetchFailed
+// hasExtKeyUsage reports whether cert has the extended key usage
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
 	}
-
-	return io.ReadAll(resp.Body)
+	return false
 }
 
-// CheckRevocation performs both OCSP and CRL checks
-func CheckRevocation(cert, issuer *x509.Certificate) *RevocationInfo {
-	info := &RevocationInfo{}
-
-	// Try OCSP first (faster and more current)
-	if len(cert.OCSPServer) > 0 && issuer != nil {
-		info.OCSP = CheckOCSP(cert, issuer)
+// CheckCRLData checks the revocation status of a certificate at a given
+// time using a DER encoded CRL, such as one embedded in a document,
+// instead of downloading it. It returns nil when the CRL was not issued
+// by issuer. The freshness rules are those of CheckOCSPResponse.
+func CheckCRLData(der []byte, cert, issuer *x509.Certificate, at time.Time) *CRLStatus {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil || !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+		return nil
+	}
+	status := &CRLStatus{}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		status.Error = err
+		return status
 	}
 
-	// Fall back to CRL if OCSP failed or unavailable
-	if info.OCSP == nil || info.OCSP.Status == "error" || info.OCSP.Status == "unknown" {
-		if len(cert.CRLDistributionPoints) > 0 {
-			info.CRL = CheckCRL(cert)
+	for _, revoked := range crl.RevokedCertificateEntries {
+		if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			if revoked.RevocationTime.After(at) {
+				return status
+			}
+			status.IsRevoked = true
+			status.RevokedAt = revoked.RevocationTime
+			status.RevokeReason = getRevocationReasonFromExtensions(revoked.Extensions)
+			return status
 		}
 	}
-
-	return info
+	if crl.ThisUpdate.Before(at) && !crl.NextUpdate.IsZero() && at.After(crl.NextUpdate) {
+		status.Error = ErrRevocationOutdated
+	}
+	return status
 }
 
 // getRevocationReason converts OCSP revocation reason code to string
@@ -444,6 +504,10 @@ var (
 	ErrNoCRLPoint      = &RevocationError{"no CRL distribution point available"}
 	ErrCRLCheckFailed  = &RevocationError{"CRL check failed"}
 	ErrCRLFetchFailed  = &RevocationError{"failed to fetch CRL"}
+	ErrCRLNotVerified  = &RevocationError{"CRL issuer certificate not available"}
+
+	ErrOCSPResponderNotAuthorized = &RevocationError{"OCSP responder not authorized by the issuer"}
+	ErrRevocationOutdated         = &RevocationError{"revocation data does not cover the validation time"}
 )
 
 // RevocationError represents a revocation check error
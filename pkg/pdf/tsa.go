@@ -0,0 +1,194 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// TimestampAuthority obtains RFC 3161 timestamp tokens. Implementations
+// other than HTTPTimestampAuthority can be used to reach a TSA through a
+// different transport or to timestamp offline in tests.
+type TimestampAuthority interface {
+	// Timestamp returns a DER encoded TimeStampToken for digest, which
+	// was computed with hash
+	Timestamp(digest []byte, hash crypto.Hash) ([]byte, error)
+}
+
+// HTTPTimestampAuthority requests timestamps from a TSA over HTTP as
+// described in RFC 3161 section 3.4
+type HTTPTimestampAuthority struct {
+	URL      string
+	Username string // optional HTTP basic authentication
+	Password string
+	Client   *http.Client // nil uses a client with a 30 second timeout
+}
+
+// tsaMessageImprint is the digest being timestamped
+type tsaMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// tsaRequest is a TimeStampReq
+type tsaRequest struct {
+	Version        int
+	MessageImprint tsaMessageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+}
+
+// tsaStatusInfo is a PKIStatusInfo
+type tsaStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+// tsaResponse is a TimeStampResp
+type tsaResponse struct {
+	Status         tsaStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// tstAccuracy is the accuracy of a timestamp
+type tstAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// tstInfo is the content signed by a TSA
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsaMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       tstAccuracy   `asn1:"optional"`
+	Ordering       bool          `asn1:"optional,default:false"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// Timestamp sends a timestamp request for digest and returns the token
+// of the response. The response must carry the same digest and nonce.
+func (a *HTTPTimestampAuthority) Timestamp(digest []byte, hash crypto.Hash) ([]byte, error) {
+	oid, err := digestAlgorithmOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(tsaRequest{
+		Version: 1,
+		MessageImprint: tsaMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, a.URL, bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/timestamp-query")
+	if a.Username != "" {
+		httpReq.SetBasicAuth(a.Username, a.Password)
+	}
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tsa: server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := parseTimestampResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, fmt.Errorf("tsa: token does not match the requested digest")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("tsa: token nonce does not match the request")
+	}
+	return token, nil
+}
+
+// parseTimestampResponse returns the token of a granted TimeStampResp
+func parseTimestampResponse(data []byte) ([]byte, error) {
+	var resp tsaResponse
+	if _, err := asn1.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("tsa: malformed response: %w", err)
+	}
+	// 0 is granted, 1 granted with modifications
+	if resp.Status.Status > 1 {
+		msg := fmt.Sprintf("status %d", resp.Status.Status)
+		if len(resp.Status.StatusString) > 0 {
+			msg += ": " + resp.Status.StatusString[0]
+		}
+		return nil, fmt.Errorf("tsa: request rejected: %s", msg)
+	}
+	if len(resp.TimeStampToken.FullBytes) == 0 {
+		return nil, fmt.Errorf("tsa: response carries no token")
+	}
+	return resp.TimeStampToken.FullBytes, nil
+}
+
+// parseTimestampToken returns the TSTInfo signed in a TimeStampToken
+func parseTimestampToken(token []byte) (*tstInfo, error) {
+	var ci cmsContentInfo
+	if _, err := asn1.Unmarshal(token, &ci); err != nil {
+		return nil, fmt.Errorf("tsa: malformed token: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("tsa: token is not signed data")
+	}
+	var sd cmsSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("tsa: malformed token: %w", err)
+	}
+	if !sd.EncapContentInfo.ContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("tsa: token does not contain TSTInfo")
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.Content.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("tsa: malformed TSTInfo: %w", err)
+	}
+	info := &tstInfo{}
+	if _, err := asn1.Unmarshal(content, info); err != nil {
+		return nil, fmt.Errorf("tsa: malformed TSTInfo: %w", err)
+	}
+	return info, nil
+}
@@ -60,8 +60,12 @@ type VectorWriter struct {
 	ctm Matrix
 	// Font resources
 	fonts map[string]*FontInfo
-	// Color spaces
+	// Color spaces of the current page by resource name, and the spaces
+	// selected for filling and stroking
 	colorSpaces map[string]ColorSpace
+	fillSpace   ColorSpace
+	strokeSpace ColorSpace
+	spaceStack  [][2]ColorSpace
 }
 
 // GraphicsState represents the current graphics state
@@ -104,15 +108,6 @@ type Color struct {
 	SpotName   string    // For spot colors
 }
 
-// ColorSpace represents a PDF color space
-type ColorSpace struct {
-	Type       string
-	Components int
-	Profile    []byte // ICC profile data
-	Alternate  string // Alternate color space
-	TintFunc   []byte // Tint transformation function
-}
-
 // Path represents a graphics path
 type Path struct {
 	Commands []PathCommand
@@ -146,6 +141,22 @@ func (m Matrix) Transform(x, y float64) (float64, float64) {
 	return m.A*x + m.C*y + m.E, m.B*x + m.D*y + m.F
 }
 
+// Invert returns the inverse matrix; ok is false for a singular matrix
+func (m Matrix) Invert() (Matrix, bool) {
+	det := m.A*m.D - m.B*m.C
+	if det == 0 {
+		return Matrix{}, false
+	}
+	return Matrix{
+		A: m.D / det,
+		B: -m.B / det,
+		C: -m.C / det,
+		D: m.A / det,
+		E: (m.C*m.F - m.D*m.E) / det,
+		F: (m.B*m.E - m.A*m.F) / det,
+	}, true
+}
+
 // TransformPoint applies the matrix to a Point and returns a new Point
 func (m Matrix) TransformPoint(p Point) Point {
 	x, y := m.Transform(p.X, p.Y)
@@ -388,6 +399,7 @@ func (w *VectorWriter) writePSPage(pageNum, outputPageNum int) error {
 	// Process page contents
 	contents, err := page.GetContents()
 	if err == nil && len(contents) > 0 {
+		w.resetColorSpaces()
 		w.convertContentStreamToPS(contents, page)
 	}
 
@@ -432,6 +444,7 @@ func (w *VectorWriter) writeEPS() error {
 
 	contents, err := page.GetContents()
 	if err == nil && len(contents) > 0 {
+		w.resetColorSpaces()
 		w.convertContentStreamToPS(contents, page)
 	}
 
@@ -997,7 +1010,16 @@ func tokenToObject(token Token) Object {
 func (w *VectorWriter) handlePSOperator(op string, operands []Object, page *Page) {
 	switch op {
 	// Graphics state
-	case "q", "Q", "cm", "w", "J", "j", "M", "d", "ri", "i":
+	case "q":
+		w.spaceStack = append(w.spaceStack, [2]ColorSpace{w.fillSpace, w.strokeSpace})
+		w.writePSOperator(op, operands)
+	case "Q":
+		if n := len(w.spaceStack); n > 0 {
+			w.fillSpace, w.strokeSpace = w.spaceStack[n-1][0], w.spaceStack[n-1][1]
+			w.spaceStack = w.spaceStack[:n-1]
+		}
+		w.writePSOperator(op, operands)
+	case "cm", "w", "J", "j", "M", "d", "ri", "i":
 		w.writePSOperator(op, operands)
 
 	// Path construction
@@ -1014,16 +1036,28 @@ func (w *VectorWriter) handlePSOperator(op string, operands []Object, page *Page
 
 	// Color
 	case "g", "G", "rg", "RG", "k", "K":
+		space := DeviceGray
+		switch strings.ToLower(op) {
+		case "rg":
+			space = DeviceRGB
+		case "k":
+			space = DeviceCMYK
+		}
+		if op == strings.ToLower(op) {
+			w.fillSpace = space
+		} else {
+			w.strokeSpace = space
+		}
 		w.writePSOperator(op, operands)
 	case "cs", "CS":
 		// Handle color space
 		if len(operands) > 0 {
 			if name, ok := operands[0].(Name); ok {
-				w.handleColorSpace(string(name), page)
+				w.handleColorSpace(string(name), page, op == "CS")
 			}
 		}
 	case "sc", "SC", "scn", "SCN":
-		w.writePSOperator(op, operands)
+		w.handleSetColor(op, operands)
 
 	// Text
 	case "BT", "ET", "Tc", "Tw", "Tz", "TL", "Tf", "Tr", "Ts":
@@ -1081,89 +1115,96 @@ func (w *VectorWriter) writePSOperator(op string, operands []Object) {
 	fmt.Fprintf(w.output, "%s\n", op)
 }
 
-// handleColorSpace handles color space setup
-func (w *VectorWriter) handleColorSpace(name string, page *Page) {
-	// Look up color space in page resources
-	if page.Resources == nil {
-		return
-	}
+// resetColorSpaces selects DeviceGray for filling and stroking at the
+// start of a page
+func (w *VectorWriter) resetColorSpaces() {
+	w.colorSpaces = make(map[string]ColorSpace)
+	w.fillSpace, w.strokeSpace = DeviceGray, DeviceGray
+	w.spaceStack = nil
+}
 
-	csRef := page.Resources.Get("ColorSpace")
-	if csRef == nil {
-		return
+// handleColorSpace handles color space setup. Device spaces are selected
+// in PostScript; colours in other spaces are converted to RGB when they
+// are set, so spot, calibrated and ICC colours print as they display.
+func (w *VectorWriter) handleColorSpace(name string, page *Page, stroke bool) {
+	cs, ok := w.colorSpaces[name]
+	if !ok {
+		var err error
+		cs, err = w.doc.ParseColorSpace(Name(name), page.Resources)
+		if err != nil {
+			return
+		}
+		w.colorSpaces[name] = cs
 	}
-
-	csObj, err := w.doc.ResolveObject(csRef)
-	if err != nil {
-		return
+	if stroke {
+		w.strokeSpace = cs
+	} else {
+		w.fillSpace = cs
 	}
 
-	csDict, ok := csObj.(Dictionary)
-	if !ok {
+	switch cs {
+	case DeviceGray, DeviceRGB, DeviceCMYK:
+		fmt.Fprintf(w.output, "/%s setcolorspace\n", cs.Family())
 		return
 	}
-
-	cs := csDict.Get(name)
-	if cs == nil {
+	switch cs.Family() {
+	case "Pattern":
 		return
+	case "Separation":
+		w.handleSeparationColorSpace(cs.(*tintColorSpace))
+	case "DeviceN":
+		w.handleDeviceNColorSpace(cs.(*tintColorSpace))
 	}
+	w.writeRGBColor(cs, cs.InitialColor(), stroke)
+}
 
-	// Handle different color space types
-	csResolved, err := w.doc.ResolveObject(cs)
-	if err != nil {
-		return
-	}
+// handleSeparationColorSpace handles Separation color space
+func (w *VectorWriter) handleSeparationColorSpace(cs *tintColorSpace) {
+	fmt.Fprintf(w.output, "%% Separation color: %s\n", cs.names[0])
+}
 
-	switch v := csResolved.(type) {
-	case Name:
-		fmt.Fprintf(w.output, "/%s setcolorspace\n", v)
-	case Array:
-		if len(v) > 0 {
-			if csType, ok := v[0].(Name); ok {
-				switch string(csType) {
-				case "ICCBased":
-					// Use alternate color space for PS
-					fmt.Fprintf(w.output, "/DeviceRGB setcolorspace\n")
-				case "Separation":
-					w.handleSeparationColorSpace(v)
-				case "DeviceN":
-					w.handleDeviceNColorSpace(v)
-				}
-			}
-		}
+// handleDeviceNColorSpace handles DeviceN color space
+func (w *VectorWriter) handleDeviceNColorSpace(cs *tintColorSpace) {
+	names := make([]string, len(cs.names))
+	for i, name := range cs.names {
+		names[i] = string(name)
 	}
+	fmt.Fprintf(w.output, "%% DeviceN color space: %s\n", strings.Join(names, " "))
 }
 
-// handleSeparationColorSpace handles Separation color space
-func (w *VectorWriter) handleSeparationColorSpace(arr Array) {
-	if len(arr) < 4 {
-		return
+// handleSetColor handles sc, SC, scn and SCN in the current colour space
+func (w *VectorWriter) handleSetColor(op string, operands []Object) {
+	stroke := op == "SC" || op == "SCN"
+	cs := w.fillSpace
+	if stroke {
+		cs = w.strokeSpace
 	}
-
-	// Separation color space: [/Separation name alternateSpace tintTransform]
-	if name, ok := arr[1].(Name); ok {
-		fmt.Fprintf(w.output, "%% Separation color: %s\n", name)
+	var comps []float64
+	for _, operand := range operands {
+		switch operand.(type) {
+		case Integer, Real:
+			comps = append(comps, objectToFloat(operand))
+		}
 	}
 
-	// Use alternate color space
-	if alt, ok := arr[2].(Name); ok {
-		fmt.Fprintf(w.output, "/%s setcolorspace\n", alt)
+	switch {
+	case cs == nil || cs == DeviceGray || cs == DeviceRGB || cs == DeviceCMYK:
+		w.writePSOperator(op, operands)
+	case cs.Family() == "Pattern":
+		// Patterns are not converted; the previous colour stays in effect
+	case len(comps) >= cs.NComponents():
+		w.writeRGBColor(cs, comps, stroke)
 	}
 }
 
-// handleDeviceNColorSpace handles DeviceN color space
-func (w *VectorWriter) handleDeviceNColorSpace(arr Array) {
-	if len(arr) < 4 {
-		return
-	}
-
-	// DeviceN color space: [/DeviceN names alternateSpace tintTransform]
-	fmt.Fprintf(w.output, "%% DeviceN color space\n")
-
-	// Use alternate color space
-	if alt, ok := arr[2].(Name); ok {
-		fmt.Fprintf(w.output, "/%s setcolorspace\n", alt)
+// writeRGBColor converts a colour to RGB and sets it
+func (w *VectorWriter) writeRGBColor(cs ColorSpace, comps []float64, stroke bool) {
+	r, g, b := cs.RGB(comps)
+	op := "rg"
+	if stroke {
+		op = "RG"
 	}
+	fmt.Fprintf(w.output, "%.4f %.4f %.4f %s\n", r, g, b, op)
 }
 
 // handleXObject handles XObject references
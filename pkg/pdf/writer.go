@@ -2,8 +2,14 @@ package pdf
 
 import (
 	"bytes"
+	"compress/zlib"
+	"crypto/md5"
 	"fmt"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"time"
 )
 
 // ExtractPage extracts a single page from a document and saves it to a file
@@ -186,3 +192,411 @@ func MergeDocuments(docs []*Document, outputFile string) error {
 
 	return os.WriteFile(outputFile, buf.Bytes(), 0644)
 }
+
+// IncrementalWriter appends new and modified objects to an existing
+// document as an incremental update. The original bytes are kept intact,
+// which preserves existing signatures and the document history.
+type IncrementalWriter struct {
+	doc     *Document
+	objects map[int]Object
+	nextNum int
+}
+
+// NewIncrementalWriter creates a writer that updates doc incrementally
+func NewIncrementalWriter(doc *Document) *IncrementalWriter {
+	next := 1
+	if size, ok := doc.Trailer.GetInt("Size"); ok {
+		next = int(size)
+	}
+	for num := range doc.xref {
+		if num >= next {
+			next = num + 1
+		}
+	}
+	return &IncrementalWriter{
+		doc:     doc,
+		objects: make(map[int]Object),
+		nextNum: next,
+	}
+}
+
+// AddObject allocates a new object number for obj and returns its reference
+func (w *IncrementalWriter) AddObject(obj Object) Reference {
+	ref := Reference{ObjectNumber: w.nextNum}
+	w.nextNum++
+	w.objects[ref.ObjectNumber] = obj
+	return ref
+}
+
+// ReserveObject allocates a new object number whose value is supplied
+// later with UpdateObject. This allows objects to reference each other.
+func (w *IncrementalWriter) ReserveObject() Reference {
+	return w.AddObject(Null{})
+}
+
+// UpdateObject replaces the object with the given reference
+func (w *IncrementalWriter) UpdateObject(ref Reference, obj Object) {
+	w.objects[ref.ObjectNumber] = obj
+}
+
+// EditDictionary returns a modifiable copy of a dictionary object. The
+// copy is written with the update, so later changes to it are included.
+func (w *IncrementalWriter) EditDictionary(ref Reference) Dictionary {
+	if obj, ok := w.objects[ref.ObjectNumber]; ok {
+		if dict, ok := obj.(Dictionary); ok {
+			return dict
+		}
+	}
+	orig, _ := resolveDict(w.doc, ref)
+	dict := cloneDict(orig)
+	w.objects[ref.ObjectNumber] = dict
+	return dict
+}
+
+// Modified reports whether any objects have been added or updated
+func (w *IncrementalWriter) Modified() bool {
+	return len(w.objects) > 0
+}
+
+// RootRef returns the reference of the document catalog
+func (w *IncrementalWriter) RootRef() (Reference, error) {
+	ref, ok := w.doc.Trailer.Get("Root").(Reference)
+	if !ok {
+		return Reference{}, fmt.Errorf("document catalog is not an indirect object")
+	}
+	return ref, nil
+}
+
+// generation returns the generation number to write for objNum
+func (w *IncrementalWriter) generation(objNum int) int {
+	if entry, ok := w.doc.xref[objNum]; ok && entry.InUse && entry.StreamObjNum == 0 {
+		return entry.Generation
+	}
+	return 0
+}
+
+// Bytes returns the original document followed by the incremental update
+func (w *IncrementalWriter) Bytes() ([]byte, error) {
+	if w.doc.security != nil || w.doc.Trailer.Get("Encrypt") != nil {
+		return nil, fmt.Errorf("incremental update of encrypted documents is not supported")
+	}
+
+	if len(w.objects) == 0 {
+		return append([]byte(nil), w.doc.data...), nil
+	}
+
+	prev, err := w.doc.findStartXRef()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(w.doc.data)
+	if n := len(w.doc.data); n > 0 && w.doc.data[n-1] != '\n' && w.doc.data[n-1] != '\r' {
+		buf.WriteByte('\n')
+	}
+
+	nums := make([]int, 0, len(w.objects))
+	for num := range w.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	offsets := make(map[int]int, len(nums))
+	for _, num := range nums {
+		offsets[num] = buf.Len()
+		fmt.Fprintf(&buf, "%d %d obj\n", num, w.generation(num))
+		writeObject(&buf, w.objects[num])
+		buf.WriteString("\nendobj\n")
+	}
+
+	// Cross-reference table with one subsection per run of numbers
+	xrefOffset := buf.Len()
+	buf.WriteString("xref\n")
+	for i := 0; i < len(nums); {
+		j := i + 1
+		for j < len(nums) && nums[j] == nums[j-1]+1 {
+			j++
+		}
+		fmt.Fprintf(&buf, "%d %d\n", nums[i], j-i)
+		for _, num := range nums[i:j] {
+			fmt.Fprintf(&buf, "%010d %05d n \n", offsets[num], w.generation(num))
+		}
+		i = j
+	}
+
+	trailer := Dictionary{
+		"Size": Integer(w.nextNum),
+		"Prev": Integer(prev),
+	}
+	for _, key := range []Name{"Root", "Info"} {
+		if v, ok := w.doc.Trailer[key]; ok {
+			trailer[key] = v
+		}
+	}
+	if id, ok := w.doc.Trailer.Get("ID").(Array); ok && len(id) == 2 {
+		trailer["ID"] = Array{id[0], newDocumentID(buf.Bytes())}
+	}
+
+	buf.WriteString("trailer\n")
+	writeObject(&buf, trailer)
+	fmt.Fprintf(&buf, "\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	return buf.Bytes(), nil
+}
+
+// WriteToFile writes the updated document to a file
+func (w *IncrementalWriter) WriteToFile(filename string) error {
+	data, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// Rewrite returns a complete document with the updates applied. Unlike
+// Bytes it drops superseded objects, so replacing large streams shrinks
+// the file, but signatures over the original bytes become invalid.
+// Objects from object streams are written uncompressed.
+func (w *IncrementalWriter) Rewrite() ([]byte, error) {
+	if w.doc.security != nil || w.doc.Trailer.Get("Encrypt") != nil {
+		return nil, fmt.Errorf("rewriting encrypted documents is not supported")
+	}
+
+	objects := make(map[int]Object, len(w.doc.xref)+len(w.objects))
+	for num, entry := range w.doc.xref {
+		if !entry.InUse || num == 0 {
+			continue
+		}
+		obj, err := w.doc.GetObject(num)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", num, err)
+		}
+		if s, ok := obj.(Stream); ok {
+			// Object and cross-reference streams are replaced by the
+			// classic cross-reference table
+			if t, _ := s.Dictionary.GetName("Type"); t == "ObjStm" || t == "XRef" {
+				continue
+			}
+		}
+		objects[num] = obj
+	}
+	for num, obj := range w.objects {
+		objects[num] = obj
+	}
+
+	version := w.doc.Version
+	if version == "" {
+		version = "1.4"
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%%PDF-%s\n%%\xe2\xe3\xcf\xd3\n", version)
+
+	offsets := make(map[int]int, len(objects))
+	for num := 1; num < w.nextNum; num++ {
+		obj, ok := objects[num]
+		if !ok {
+			continue
+		}
+		offsets[num] = buf.Len()
+		gen := 0
+		if _, updated := w.objects[num]; !updated {
+			gen = w.generation(num)
+		}
+		fmt.Fprintf(&buf, "%d %d obj\n", num, gen)
+		writeObject(&buf, obj)
+		buf.WriteString("\nendobj\n")
+	}
+
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", w.nextNum)
+	for num := 1; num < w.nextNum; num++ {
+		if off, ok := offsets[num]; ok {
+			gen := 0
+			if _, updated := w.objects[num]; !updated {
+				gen = w.generation(num)
+			}
+			fmt.Fprintf(&buf, "%010d %05d n \n", off, gen)
+		} else {
+			buf.WriteString("0000000000 00000 f \n")
+		}
+	}
+
+	trailer := Dictionary{"Size": Integer(w.nextNum)}
+	for _, key := range []Name{"Root", "Info"} {
+		if v, ok := w.doc.Trailer[key]; ok {
+			trailer[key] = v
+		}
+	}
+	if id, ok := w.doc.Trailer.Get("ID").(Array); ok && len(id) == 2 {
+		trailer["ID"] = Array{id[0], newDocumentID(buf.Bytes())}
+	}
+	buf.WriteString("trailer\n")
+	writeObject(&buf, trailer)
+	fmt.Fprintf(&buf, "\nstartxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	return buf.Bytes(), nil
+}
+
+// RewriteToFile writes the rewritten document to a file
+func (w *IncrementalWriter) RewriteToFile(filename string) error {
+	data, err := w.Rewrite()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// newDocumentID generates a file identifier from the document data
+func newDocumentID(data []byte) String {
+	h := md5.New()
+	h.Write(data)
+	h.Write([]byte(time.Now().String()))
+	return String{Value: h.Sum(nil), IsHex: true}
+}
+
+// newFlateStream creates a stream whose data is compressed with FlateDecode
+func newFlateStream(dict Dictionary, data []byte) Stream {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+
+	if dict == nil {
+		dict = Dictionary{}
+	}
+	dict["Filter"] = Name("FlateDecode")
+	return Stream{Dictionary: dict, Data: buf.Bytes()}
+}
+
+// cloneDict returns a shallow copy of a dictionary
+func cloneDict(d Dictionary) Dictionary {
+	c := make(Dictionary, len(d))
+	for k, v := range d {
+		c[k] = v
+	}
+	return c
+}
+
+// serializeObject returns the PDF syntax for an object
+func serializeObject(obj Object) []byte {
+	var buf bytes.Buffer
+	writeObject(&buf, obj)
+	return buf.Bytes()
+}
+
+// writeObject writes the PDF syntax for an object. Dictionary keys are
+// written in sorted order so that output is deterministic.
+func writeObject(buf *bytes.Buffer, obj Object) {
+	switch v := obj.(type) {
+	case nil, Null:
+		buf.WriteString("null")
+	case Boolean:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case Integer:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case Real:
+		buf.WriteString(formatReal(float64(v)))
+	case String:
+		writeString(buf, v)
+	case Name:
+		writeName(buf, v)
+	case Array:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeObject(buf, elem)
+		}
+		buf.WriteByte(']')
+	case Dictionary:
+		writeDictionary(buf, v)
+	case Stream:
+		dict := cloneDict(v.Dictionary)
+		dict["Length"] = Integer(len(v.Data))
+		writeDictionary(buf, dict)
+		buf.WriteString("\nstream\n")
+		buf.Write(v.Data)
+		buf.WriteString("\nendstream")
+	case Reference:
+		fmt.Fprintf(buf, "%d %d R", v.ObjectNumber, v.GenerationNumber)
+	case Operator:
+		buf.WriteString(string(v))
+	default:
+		buf.WriteString("null")
+	}
+}
+
+// writeDictionary writes a dictionary with sorted keys
+func writeDictionary(buf *bytes.Buffer, d Dictionary) {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+
+	buf.WriteString("<<")
+	for _, k := range keys {
+		writeName(buf, Name(k))
+		buf.WriteByte(' ')
+		writeObject(buf, d[Name(k)])
+	}
+	buf.WriteString(">>")
+}
+
+// writeString writes a literal or hexadecimal string
+func writeString(buf *bytes.Buffer, s String) {
+	if s.IsHex {
+		buf.WriteByte('<')
+		for _, b := range s.Value {
+			fmt.Fprintf(buf, "%02X", b)
+		}
+		buf.WriteByte('>')
+		return
+	}
+
+	buf.WriteByte('(')
+	for _, b := range s.Value {
+		switch b {
+		case '(', ')', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(b)
+		case '\r':
+			buf.WriteString("\\r")
+		case '\n':
+			buf.WriteString("\\n")
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	buf.WriteByte(')')
+}
+
+// writeName writes a name, escaping delimiters and non-regular characters
+func writeName(buf *bytes.Buffer, n Name) {
+	buf.WriteByte('/')
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if c < 0x21 || c > 0x7E || c == '#' || isDelimiter(c) {
+			fmt.Fprintf(buf, "#%02X", c)
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+}
+
+// formatReal formats a real number without exponent notation
+func formatReal(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "0"
+	}
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
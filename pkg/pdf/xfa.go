@@ -3,8 +3,12 @@ package pdf
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/xml"
 	"fmt"
+	"html"
+	"image/color"
+	"image/png"
 	"regexp"
 	"strconv"
 	"strings"
@@ -25,7 +29,11 @@ type XFAForm struct {
 	LocaleSet     *XFALocaleSet
 	Stylesheet    *XFAStylesheet
 	ConnectionSet *XFAConnectionSet
+	Form          *XFAFormNode // 模板与数据合并后的表单 DOM
 	rawPackets    map[string][]byte
+	templateDOM   *xfaNode
+	datasetsDOM   *xfaNode
+	scriptLimits  *JSLimits
 }
 
 // XFATemplate XFA 模板
@@ -693,9 +701,44 @@ func (xfa *XFAForm) parseXFAPackets(data []byte) error {
 		xml.Unmarshal(connectionData, xfa.ConnectionSet)
 	}
 
+	// 构建模板与数据集 DOM，并合并数据
+	xfa.buildDOM(data)
+	xfa.merge()
+
 	return nil
 }
 
+// buildDOM 解析模板包与数据集包的节点树
+func (xfa *XFAForm) buildDOM(data []byte) {
+	if root, err := parseXFAXML(data); err == nil {
+		switch root.local() {
+		case "xdp":
+			xfa.templateDOM = root.child("template")
+			xfa.datasetsDOM = root.child("datasets")
+		case "template":
+			xfa.templateDOM = root
+		}
+	}
+
+	// 各包分别存放时逐个解析
+	if xfa.templateDOM == nil {
+		if raw, ok := xfa.rawPackets["template"]; ok {
+			xfa.templateDOM, _ = parseXFAXML(raw)
+		}
+	}
+	if xfa.datasetsDOM == nil {
+		if raw, ok := xfa.rawPackets["datasets"]; ok {
+			xfa.datasetsDOM, _ = parseXFAXML(raw)
+		}
+	}
+	if xfa.templateDOM != nil {
+		xfa.templateDOM.parent = nil
+	}
+	if xfa.datasetsDOM != nil {
+		xfa.datasetsDOM.parent = nil
+	}
+}
+
 func (xfa *XFAForm) extractPacket(data []byte, name string) []byte {
 	// 查找 XFA 包
 	startTag := fmt.Sprintf("<%s", name)
@@ -747,8 +790,14 @@ func (xfa *XFAForm) collectFields(subform XFASubform) []XFAField {
 	return fields
 }
 
-// GetFieldValue 获取字段值
+// GetFieldValue 获取字段值，字段可用名称或 SOM 引用指定
 func (xfa *XFAForm) GetFieldValue(fieldName string) string {
+	if xfa.Form != nil {
+		if node, err := xfa.findField(fieldName); err == nil {
+			return node.Value
+		}
+		return ""
+	}
 	if xfa.Data == nil {
 		return ""
 	}
@@ -764,8 +813,18 @@ func (xfa *XFAForm) GetFieldValue(fieldName string) string {
 	return ""
 }
 
-// SetFieldValue 设置字段值
+// SetFieldValue 设置字段值，同时更新字段绑定的数据节点
+//
+// 设置后执行表单的 FormCalc 计算脚本并验证该字段；验证失败时恢复原值并
+// 返回 *XFAValidationError。
 func (xfa *XFAForm) SetFieldValue(fieldName, value string) error {
+	if xfa.Form != nil {
+		node, err := xfa.findField(fieldName)
+		if err != nil {
+			return err
+		}
+		return xfa.setFieldChecked(node, value)
+	}
 	if xfa.Data == nil {
 		xfa.Data = &XFAData{}
 	}
@@ -785,8 +844,16 @@ func (xfa *XFAForm) SetFieldValue(fieldName, value string) error {
 	return nil
 }
 
-// RenderToHTML 将 XFA 表单渲染为 HTML
+// RenderToHTML 将 XFA 表单按布局渲染为 HTML
+//
+// 每个版面页是一个绝对定位的容器：边框、标题、绘制对象等静态内容以 SVG
+// 绘制，字段在其控件区域放置可编辑的输入元素。
 func (xfa *XFAForm) RenderToHTML() (string, error) {
+	pages, err := xfa.Layout()
+	if err != nil {
+		return "", err
+	}
+
 	var buf bytes.Buffer
 
 	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n")
@@ -796,10 +863,22 @@ func (xfa *XFAForm) RenderToHTML() (string, error) {
 	buf.WriteString("</style>\n")
 	buf.WriteString("</head>\n<body>\n")
 
-	if xfa.Template != nil {
-		for _, subform := range xfa.Template.Subforms {
-			buf.WriteString(xfa.renderSubform(subform))
+	for i, page := range pages {
+		w, h := formatNum(page.Width), formatNum(page.Height)
+		fmt.Fprintf(&buf, "<div class=\"xfa-page\" id=\"xfa-page-%d\" style=\"width:%spt;height:%spt\">\n", i+1, w, h)
+		fmt.Fprintf(&buf, "<svg width=\"%spt\" height=\"%spt\" viewBox=\"0 0 %s %s\">\n", w, h, w, h)
+		for _, op := range page.Ops {
+			// 字段的值由输入元素显示
+			if op.Value && op.Kind != XFADrawImage {
+				continue
+			}
+			buf.WriteString(xfaSVGElement(op))
+		}
+		buf.WriteString("</svg>\n")
+		for _, widget := range page.Widgets {
+			buf.WriteString(xfa.renderWidget(widget))
 		}
+		buf.WriteString("</div>\n")
 	}
 
 	buf.WriteString("</body>\n</html>")
@@ -809,135 +888,125 @@ func (xfa *XFAForm) RenderToHTML() (string, error) {
 
 func (xfa *XFAForm) generateCSS() string {
 	return `
-.xfa-subform { position: relative; margin: 10px; padding: 10px; border: 1px solid #ccc; }
-.xfa-field { margin: 5px 0; }
-.xfa-field label { display: inline-block; min-width: 150px; }
-.xfa-field input, .xfa-field select, .xfa-field textarea { padding: 5px; border: 1px solid #999; }
-.xfa-draw { margin: 5px 0; }
-.xfa-button { padding: 8px 16px; background: #007bff; color: white; border: none; cursor: pointer; }
-.xfa-button:hover { background: #0056b3; }
+body { background: #e0e0e0; margin: 0; padding: 10px; }
+.xfa-page { position: relative; margin: 10px auto; background: white; box-shadow: 0 0 4px #999; }
+.xfa-page svg { position: absolute; left: 0; top: 0; }
+.xfa-field { position: absolute; box-sizing: border-box; margin: 0; padding: 0 2px; border: none; background: rgba(204, 215, 255, 0.4); }
+.xfa-check { position: absolute; margin: 0; }
+.xfa-button { position: absolute; background: transparent; border: none; cursor: pointer; }
+.xfa-button:hover { background: rgba(0, 0, 0, 0.1); }
 `
 }
 
-func (xfa *XFAForm) renderSubform(subform XFASubform) string {
-	var buf bytes.Buffer
-
-	buf.WriteString(fmt.Sprintf("<div class=\"xfa-subform\" id=\"%s\">\n", subform.Name))
-
-	// 渲染绘制元素
-	for _, draw := range subform.Draws {
-		buf.WriteString(xfa.renderDraw(draw))
-	}
-
-	// 渲染字段
-	for _, field := range subform.Fields {
-		buf.WriteString(xfa.renderField(field))
-	}
-
-	// 渲染区域
-	for _, area := range subform.Areas {
-		buf.WriteString(fmt.Sprintf("<div class=\"xfa-area\" id=\"%s\">\n", area.Name))
-		for _, field := range area.Fields {
-			buf.WriteString(xfa.renderField(field))
+// renderWidget 在字段的控件区域生成输入元素
+func (xfa *XFAForm) renderWidget(widget XFAWidget) string {
+	n := widget.Node
+	ui, kind := xfaUI(n.template)
+	style := xfaStyle(n.template)
+	name := html.EscapeString(n.SOM())
+	value := html.EscapeString(n.Value)
+	box := fmt.Sprintf("left:%spt;top:%spt;width:%spt;height:%spt;font-size:%spt",
+		formatNum(widget.X), formatNum(widget.Y), formatNum(widget.W), formatNum(widget.H), formatNum(style.font.Size))
+
+	switch kind {
+	case "button":
+		return fmt.Sprintf("<button class=\"xfa-button\" name=\"%s\" style=\"%s\"></button>\n", name, box)
+	case "signature", "imageEdit":
+		return ""
+	case "checkButton":
+		checked := ""
+		if n.Value != "" && n.Value == xfaOnValue(n.template) {
+			checked = " checked"
 		}
-		buf.WriteString("</div>\n")
-	}
-
-	// 渲染互斥组
-	for _, exclGroup := range subform.ExclGroups {
-		buf.WriteString(fmt.Sprintf("<div class=\"xfa-exclgroup\" id=\"%s\">\n", exclGroup.Name))
-		for _, field := range exclGroup.Fields {
-			buf.WriteString(xfa.renderField(field))
+		if n.Parent != nil && n.Parent.Class == "exclGroup" {
+			return fmt.Sprintf("<input class=\"xfa-check\" type=\"radio\" name=\"%s\" value=\"%s\" style=\"%s\"%s>\n",
+				html.EscapeString(n.Parent.SOM()), html.EscapeString(xfaOnValue(n.template)), box, checked)
+		}
+		return fmt.Sprintf("<input class=\"xfa-check\" type=\"checkbox\" name=\"%s\" style=\"%s\"%s>\n", name, box, checked)
+	case "choiceList":
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "<select class=\"xfa-field\" name=\"%s\" style=\"%s\">\n", name, box)
+		for _, item := range xfaItems(n.template) {
+			selected := ""
+			if item == n.Value {
+				selected = " selected"
+			}
+			fmt.Fprintf(&sb, "<option value=\"%s\"%s>%s</option>\n", html.EscapeString(item), selected,
+				html.EscapeString(xfaFieldDisplay(&XFAFormNode{Value: item, template: n.template}, kind)))
+		}
+		sb.WriteString("</select>\n")
+		return sb.String()
+	case "textEdit":
+		if ui != nil && ui.attr("multiLine") == "1" {
+			return fmt.Sprintf("<textarea class=\"xfa-field\" name=\"%s\" style=\"%s\">%s</textarea>\n", name, box, value)
 		}
-		buf.WriteString("</div>\n")
-	}
-
-	// 渲染子表单
-	for _, child := range subform.Subforms {
-		buf.WriteString(xfa.renderSubform(child))
-	}
-
-	buf.WriteString("</div>\n")
-
-	return buf.String()
-}
-
-func (xfa *XFAForm) renderField(field XFAField) string {
-	var buf bytes.Buffer
-
-	buf.WriteString(fmt.Sprintf("<div class=\"xfa-field\" id=\"%s\">\n", field.Name))
-
-	// 渲染标题
-	if field.Caption.Value.Text != "" {
-		buf.WriteString(fmt.Sprintf("<label>%s</label>\n", field.Caption.Value.Text))
 	}
 
-	// 根据 UI 类型渲染控件
-	if field.UI.TextEdit != nil {
-		if field.UI.TextEdit.MultiLine == "1" {
-			buf.WriteString(fmt.Sprintf("<textarea name=\"%s\">%s</textarea>\n",
-				field.Name, xfa.GetFieldValue(field.Name)))
-		} else {
-			buf.WriteString(fmt.Sprintf("<input type=\"text\" name=\"%s\" value=\"%s\">\n",
-				field.Name, xfa.GetFieldValue(field.Name)))
+	inputType := "text"
+	switch kind {
+	case "numericEdit":
+		inputType = "number"
+	case "passwordEdit":
+		inputType = "password"
+	}
+	return fmt.Sprintf("<input class=\"xfa-field\" type=\"%s\" name=\"%s\" value=\"%s\" style=\"%s\">\n",
+		inputType, name, value, box)
+}
+
+// xfaSVGElement 将绘制操作转换为 SVG 元素
+func xfaSVGElement(op XFADrawOp) string {
+	paint := fmt.Sprintf("fill=\"%s\" stroke=\"%s\" stroke-width=\"%s\"",
+		xfaCSSColor(op.Fill), xfaCSSColor(op.Stroke), formatNum(op.LineWidth))
+	switch op.Kind {
+	case XFADrawRect:
+		return fmt.Sprintf("<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" %s/>\n",
+			formatNum(op.X), formatNum(op.Y), formatNum(op.W), formatNum(op.H), paint)
+	case XFADrawLine:
+		return fmt.Sprintf("<line x1=\"%s\" y1=\"%s\" x2=\"%s\" y2=\"%s\" %s/>\n",
+			formatNum(op.X), formatNum(op.Y), formatNum(op.X+op.W), formatNum(op.Y+op.H), paint)
+	case XFADrawEllipse:
+		return fmt.Sprintf("<ellipse cx=\"%s\" cy=\"%s\" rx=\"%s\" ry=\"%s\" %s/>\n",
+			formatNum(op.X+op.W/2), formatNum(op.Y+op.H/2), formatNum(op.W/2), formatNum(op.H/2), paint)
+	case XFADrawPath:
+		points := make([]string, 0, len(op.Points)/2)
+		for i := 0; i+1 < len(op.Points); i += 2 {
+			points = append(points, formatNum(op.Points[i])+","+formatNum(op.Points[i+1]))
 		}
-	} else if field.UI.NumericEdit != nil {
-		buf.WriteString(fmt.Sprintf("<input type=\"number\" name=\"%s\" value=\"%s\">\n",
-			field.Name, xfa.GetFieldValue(field.Name)))
-	} else if field.UI.DateTimeEdit != nil {
-		buf.WriteString(fmt.Sprintf("<input type=\"datetime-local\" name=\"%s\" value=\"%s\">\n",
-			field.Name, xfa.GetFieldValue(field.Name)))
-	} else if field.UI.ChoiceList != nil {
-		buf.WriteString(fmt.Sprintf("<select name=\"%s\">\n", field.Name))
-		for _, items := range field.Items {
-			for _, text := range items.Texts {
-				selected := ""
-				if text == xfa.GetFieldValue(field.Name) {
-					selected = " selected"
-				}
-				buf.WriteString(fmt.Sprintf("<option value=\"%s\"%s>%s</option>\n", text, selected, text))
-			}
+		elem := "polyline"
+		if op.Closed {
+			elem = "polygon"
 		}
-		buf.WriteString("</select>\n")
-	} else if field.UI.CheckButton != nil {
-		checked := ""
-		if xfa.GetFieldValue(field.Name) == "1" {
-			checked = " checked"
+		return fmt.Sprintf("<%s points=\"%s\" %s stroke-linecap=\"round\" stroke-linejoin=\"round\"/>\n",
+			elem, strings.Join(points, " "), paint)
+	case XFADrawText:
+		weight, fontStyle := "normal", "normal"
+		if op.Font.Bold {
+			weight = "bold"
 		}
-		buf.WriteString(fmt.Sprintf("<input type=\"checkbox\" name=\"%s\"%s>\n", field.Name, checked))
-	} else if field.UI.Button != nil {
-		buf.WriteString(fmt.Sprintf("<button class=\"xfa-button\" name=\"%s\">%s</button>\n",
-			field.Name, field.Caption.Value.Text))
-	} else if field.UI.PasswordEdit != nil {
-		buf.WriteString(fmt.Sprintf("<input type=\"password\" name=\"%s\">\n", field.Name))
-	} else {
-		// 默认文本输入
-		buf.WriteString(fmt.Sprintf("<input type=\"text\" name=\"%s\" value=\"%s\">\n",
-			field.Name, xfa.GetFieldValue(field.Name)))
+		if op.Font.Italic {
+			fontStyle = "italic"
+		}
+		return fmt.Sprintf("<text x=\"%s\" y=\"%s\" font-family=\"%s\" font-size=\"%s\" font-weight=\"%s\" font-style=\"%s\" fill=\"%s\" xml:space=\"preserve\">%s</text>\n",
+			formatNum(op.X), formatNum(op.Y), html.EscapeString(op.Font.Typeface), formatNum(op.Font.Size),
+			weight, fontStyle, xfaCSSColor(op.Fill), html.EscapeString(op.Text))
+	case XFADrawImage:
+		var data bytes.Buffer
+		if op.Image == nil || png.Encode(&data, op.Image) != nil {
+			return ""
+		}
+		return fmt.Sprintf("<image x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" preserveAspectRatio=\"none\" href=\"data:image/png;base64,%s\"/>\n",
+			formatNum(op.X), formatNum(op.Y), formatNum(op.W), formatNum(op.H), base64.StdEncoding.EncodeToString(data.Bytes()))
 	}
-
-	buf.WriteString("</div>\n")
-
-	return buf.String()
+	return ""
 }
 
-func (xfa *XFAForm) renderDraw(draw XFADraw) string {
-	var buf bytes.Buffer
-
-	buf.WriteString(fmt.Sprintf("<div class=\"xfa-draw\" id=\"%s\">\n", draw.Name))
-
-	if draw.Value.Text != "" {
-		buf.WriteString(fmt.Sprintf("<span>%s</span>\n", draw.Value.Text))
+// xfaCSSColor 返回颜色的 CSS 表示，nil 为 none
+func xfaCSSColor(c color.Color) string {
+	if c == nil {
+		return "none"
 	}
-
-	if draw.Value.Image != nil && draw.Value.Image.Content != "" {
-		buf.WriteString(fmt.Sprintf("<img src=\"data:%s;base64,%s\">\n",
-			draw.Value.Image.ContentType, draw.Value.Image.Content))
-	}
-
-	buf.WriteString("</div>\n")
-
-	return buf.String()
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("rgb(%d,%d,%d)", r>>8, g>>8, b>>8)
 }
 
 // ============================================================================
@@ -945,13 +1014,25 @@ func (xfa *XFAForm) renderDraw(draw XFADraw) string {
 // ============================================================================
 
 // JSEngine JavaScript 执行引擎
+//
+// 脚本由沙箱化的 ECMAScript 解释器执行，可访问 Acrobat 的 this.getField、
+// event、util、app 与 AF* 函数，字段对象映射到文档的表单模型。
 type JSEngine struct {
-	doc       *Document
-	variables map[string]interface{}
-	functions map[string]JSFunction
-	events    map[string][]JSEventHandler
-	console   *JSConsole
-	mu        sync.RWMutex
+	doc        *Document
+	functions  map[string]JSFunction
+	events     map[string][]JSEventHandler
+	console    *JSConsole
+	mu         sync.RWMutex
+	runMu      sync.Mutex
+	interp     *jsInterp
+	form       *jsForm
+	fieldProto *jsObject
+
+	calculating bool
+	noCalculate bool
+
+	xfa       *XFAForm // FormCalc 脚本使用的 XFA 表单
+	xfaLoaded bool
 }
 
 // JSFunction JavaScript 函数
@@ -978,20 +1059,41 @@ type JSLogEntry struct {
 	Time    int64
 }
 
-// NewJSEngine 创建 JavaScript 引擎
+// NewJSEngine 创建 JavaScript 引擎并执行文档级脚本
 func NewJSEngine(doc *Document) *JSEngine {
 	engine := &JSEngine{
 		doc:       doc,
-		variables: make(map[string]interface{}),
 		functions: make(map[string]JSFunction),
 		events:    make(map[string][]JSEventHandler),
 		console:   NewJSConsole(1000),
+		interp:    newJSInterp(DefaultJSLimits),
+		form:      newJSForm(doc),
 	}
 
 	engine.registerBuiltinFunctions()
+	for name, fn := range engine.functions {
+		engine.interp.define(engine.interp.global, name, engine.goFunction(name, fn))
+	}
+	engine.installAcrobat()
+
+	if doc == nil {
+		return engine
+	}
+	for _, script := range doc.GetJavaScript() {
+		if _, err := engine.interp.run(script, engine.interp.global); err != nil {
+			engine.console.Error("document script: " + err.Error())
+		}
+	}
 	return engine
 }
 
+// SetLimits 设置脚本的执行时间、内存与调用深度限制
+func (e *JSEngine) SetLimits(limits JSLimits) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	e.interp.limits = limits
+}
+
 // NewJSConsole 创建控制台
 func NewJSConsole(maxLogs int) *JSConsole {
 	return &JSConsole{
@@ -1047,7 +1149,6 @@ func (c *JSConsole) Clear() {
 
 func (e *JSEngine) registerBuiltinFunctions() {
 	// 文档操作函数
-	e.functions["getField"] = e.jsGetField
 	e.functions["getPageNum"] = e.jsGetPageNum
 	e.functions["getPageCount"] = e.jsGetPageCount
 	e.functions["getDocumentTitle"] = e.jsGetDocumentTitle
@@ -1098,39 +1199,21 @@ func (e *JSEngine) registerBuiltinFunctions() {
 }
 
 // 文档操作函数实现
-func (e *JSEngine) jsGetField(args []interface{}) interface{} {
-	if len(args) < 1 {
-		return nil
-	}
-	fieldName, ok := args[0].(string)
-	if !ok {
-		return nil
-	}
-
-	fields := e.doc.GetFormFields()
-	for _, field := range fields {
-		if field.Name == fieldName {
-			return map[string]interface{}{
-				"name":     field.Name,
-				"type":     field.Type,
-				"value":    field.Value,
-				"readOnly": field.ReadOnly,
-				"required": field.Required,
-			}
-		}
-	}
-	return nil
-}
-
 func (e *JSEngine) jsGetPageNum(args []interface{}) interface{} {
 	return 1 // 当前页码
 }
 
 func (e *JSEngine) jsGetPageCount(args []interface{}) interface{} {
+	if e.doc == nil {
+		return 0
+	}
 	return len(e.doc.Pages)
 }
 
 func (e *JSEngine) jsGetDocumentTitle(args []interface{}) interface{} {
+	if e.doc == nil {
+		return ""
+	}
 	if title := e.doc.Info.Get("Title"); title != nil {
 		if s, ok := title.(String); ok {
 			return s.Text()
@@ -1140,6 +1223,9 @@ func (e *JSEngine) jsGetDocumentTitle(args []interface{}) interface{} {
 }
 
 func (e *JSEngine) jsGetDocumentAuthor(args []interface{}) interface{} {
+	if e.doc == nil {
+		return ""
+	}
 	if author := e.doc.Info.Get("Author"); author != nil {
 		if s, ok := author.(String); ok {
 			return s.Text()
@@ -1153,16 +1239,11 @@ func (e *JSEngine) jsSetFieldValue(args []interface{}) interface{} {
 	if len(args) < 2 {
 		return false
 	}
-	fieldName, ok := args[0].(string)
-	if !ok {
+	f := e.legacyField(args[0])
+	if f == nil {
 		return false
 	}
-	value := fmt.Sprintf("%v", args[1])
-
-	e.mu.Lock()
-	e.variables["field_"+fieldName] = value
-	e.mu.Unlock()
-
+	e.setFieldValue(f, []string{fmt.Sprintf("%v", args[1])})
 	return true
 }
 
@@ -1170,44 +1251,27 @@ func (e *JSEngine) jsGetFieldValue(args []interface{}) interface{} {
 	if len(args) < 1 {
 		return ""
 	}
-	fieldName, ok := args[0].(string)
-	if !ok {
+	f := e.legacyField(args[0])
+	if f == nil {
 		return ""
 	}
-
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-
-	if val, ok := e.variables["field_"+fieldName]; ok {
-		return val
-	}
-
-	fields := e.doc.GetFormFields()
-	for _, field := range fields {
-		if field.Name == fieldName {
-			return field.Value
-		}
-	}
-	return ""
+	return f.value()
 }
 
 func (e *JSEngine) jsSetFieldHidden(args []interface{}) interface{} {
 	if len(args) < 2 {
 		return false
 	}
-	fieldName, ok := args[0].(string)
-	if !ok {
-		return false
-	}
+	f := e.legacyField(args[0])
 	hidden, ok := args[1].(bool)
-	if !ok {
+	if f == nil || !ok {
 		return false
 	}
-
-	e.mu.Lock()
-	e.variables["hidden_"+fieldName] = hidden
-	e.mu.Unlock()
-
+	f.display = jsDisplayVisible
+	if hidden {
+		f.display = jsDisplayHidden
+	}
+	e.form.markDirty(f)
 	return true
 }
 
@@ -1215,22 +1279,25 @@ func (e *JSEngine) jsSetFieldReadOnly(args []interface{}) interface{} {
 	if len(args) < 2 {
 		return false
 	}
-	fieldName, ok := args[0].(string)
-	if !ok {
-		return false
-	}
+	f := e.legacyField(args[0])
 	readOnly, ok := args[1].(bool)
-	if !ok {
+	if f == nil || !ok {
 		return false
 	}
-
-	e.mu.Lock()
-	e.variables["readonly_"+fieldName] = readOnly
-	e.mu.Unlock()
-
+	f.readonly = readOnly
+	e.form.markDirty(f)
 	return true
 }
 
+// legacyField 按名称查找字段
+func (e *JSEngine) legacyField(name interface{}) *jsField {
+	s, ok := name.(string)
+	if !ok {
+		return nil
+	}
+	return e.form.fields[s]
+}
+
 // 数学函数实现
 func (e *JSEngine) jsAbs(args []interface{}) interface{} {
 	if len(args) < 1 {
@@ -1529,186 +1596,88 @@ func (e *JSEngine) jsPrint(args []interface{}) interface{} {
 	return nil
 }
 
-// Execute 执行 JavaScript 代码
+// Execute 执行 JavaScript 代码，返回最后一条语句的值
+//
+// 脚本抛出的异常与语法错误作为 error 返回；超出执行时间或内存限制时返回
+// ErrScriptTimeout 或 ErrScriptMemory。
 func (e *JSEngine) Execute(script string) (interface{}, error) {
-	// 简单的脚本解析和执行
-	script = strings.TrimSpace(script)
-
-	// 处理变量赋值
-	if strings.Contains(script, "=") && !strings.Contains(script, "==") {
-		parts := strings.SplitN(script, "=", 2)
-		if len(parts) == 2 {
-			varName := strings.TrimSpace(parts[0])
-			varName = strings.TrimPrefix(varName, "var ")
-			varName = strings.TrimPrefix(varName, "let ")
-			varName = strings.TrimPrefix(varName, "const ")
-			varName = strings.TrimSpace(varName)
-
-			value := strings.TrimSpace(parts[1])
-			value = strings.TrimSuffix(value, ";")
-
-			result, err := e.evaluateExpression(value)
-			if err != nil {
-				return nil, err
-			}
-
-			e.mu.Lock()
-			e.variables[varName] = result
-			e.mu.Unlock()
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
 
-			return result, nil
-		}
-	}
-
-	// 处理函数调用
-	if strings.Contains(script, "(") && strings.Contains(script, ")") {
-		return e.executeFunction(script)
-	}
-
-	// 处理变量引用
-	e.mu.RLock()
-	if val, ok := e.variables[script]; ok {
-		e.mu.RUnlock()
-		return val, nil
-	}
-	e.mu.RUnlock()
-
-	return nil, nil
-}
-
-func (e *JSEngine) evaluateExpression(expr string) (interface{}, error) {
-	expr = strings.TrimSpace(expr)
-
-	// 字符串字面量
-	if (strings.HasPrefix(expr, "\"") && strings.HasSuffix(expr, "\"")) ||
-		(strings.HasPrefix(expr, "'") && strings.HasSuffix(expr, "'")) {
-		return expr[1 : len(expr)-1], nil
-	}
-
-	// 数字字面量
-	if n, err := strconv.ParseFloat(expr, 64); err == nil {
-		return n, nil
-	}
-
-	// 布尔字面量
-	if expr == "true" {
-		return true, nil
-	}
-	if expr == "false" {
-		return false, nil
-	}
-
-	// null/undefined
-	if expr == "null" || expr == "undefined" {
-		return nil, nil
-	}
-
-	// 函数调用
-	if strings.Contains(expr, "(") {
-		return e.executeFunction(expr)
-	}
-
-	// 变量引用
-	e.mu.RLock()
-	if val, ok := e.variables[expr]; ok {
-		e.mu.RUnlock()
-		return val, nil
+	result, err := e.interp.run(script, e.interp.global)
+	if err != nil {
+		return nil, err
 	}
-	e.mu.RUnlock()
-
-	return expr, nil
+	return e.exportValue(result), nil
 }
 
-func (e *JSEngine) executeFunction(script string) (interface{}, error) {
-	// 解析函数名和参数
-	parenIdx := strings.Index(script, "(")
-	if parenIdx == -1 {
-		return nil, fmt.Errorf("invalid function call")
-	}
-
-	funcName := strings.TrimSpace(script[:parenIdx])
-	argsStr := script[parenIdx+1:]
-	argsStr = strings.TrimSuffix(argsStr, ")")
-	argsStr = strings.TrimSuffix(argsStr, ";")
-
-	// 解析参数
-	var args []interface{}
-	if argsStr != "" {
-		argParts := splitArgs(argsStr)
-		for _, arg := range argParts {
-			val, err := e.evaluateExpression(strings.TrimSpace(arg))
-			if err != nil {
-				return nil, err
-			}
-			args = append(args, val)
-		}
-	}
+// ExecuteFormCalc 执行 FormCalc 脚本，返回最后一个表达式的值（float64、
+// string 或 nil）
+//
+// 文档为 XFA 表单时，脚本可通过 SOM 表达式访问合并后的表单与数据；否则只能
+// 使用内置函数与局部变量。
+func (e *JSEngine) ExecuteFormCalc(script string) (interface{}, error) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
 
-	// 查找并执行函数
-	if fn, ok := e.functions[funcName]; ok {
-		return fn(args), nil
-	}
-
-	return nil, fmt.Errorf("unknown function: %s", funcName)
-}
-
-func splitArgs(argsStr string) []string {
-	var args []string
-	var current strings.Builder
-	depth := 0
-	inString := false
-	stringChar := byte(0)
-
-	for i := 0; i < len(argsStr); i++ {
-		c := argsStr[i]
-
-		if !inString && (c == '"' || c == '\'') {
-			inString = true
-			stringChar = c
-			current.WriteByte(c)
-		} else if inString && c == stringChar {
-			inString = false
-			current.WriteByte(c)
-		} else if !inString && c == '(' {
-			depth++
-			current.WriteByte(c)
-		} else if !inString && c == ')' {
-			depth--
-			current.WriteByte(c)
-		} else if !inString && c == ',' && depth == 0 {
-			args = append(args, current.String())
-			current.Reset()
-		} else {
-			current.WriteByte(c)
+	if !e.xfaLoaded {
+		e.xfaLoaded = true
+		if e.doc != nil && e.doc.IsXFA() {
+			e.xfa, _ = NewXFAForm(e.doc)
 		}
 	}
+	in := newFCInterp(e.xfa, e.interp.limits)
+	return in.run(script, nil)
+}
 
-	if current.Len() > 0 {
-		args = append(args, current.String())
-	}
-
-	return args
+// Calculate 按计算顺序执行表单的计算脚本
+func (e *JSEngine) Calculate() error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	return e.calculate()
 }
 
-// SetVariable 设置变量
+// SetVariable 设置全局变量
 func (e *JSEngine) SetVariable(name string, value interface{}) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	e.variables[name] = value
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	e.guard(func() {
+		e.interp.setProp(e.interp.global, name, e.importValue(value))
+	})
 }
 
-// GetVariable 获取变量
+// GetVariable 获取全局变量
 func (e *JSEngine) GetVariable(name string) interface{} {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	return e.variables[name]
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	var value interface{}
+	e.guard(func() {
+		if e.interp.hasOwn(e.interp.global, name) {
+			value = e.exportValue(e.interp.getProp(e.interp.global, name))
+		}
+	})
+	return value
+}
+
+// guard 在脚本运行之外访问解释器，并重置资源计数
+func (e *JSEngine) guard(fn func()) error {
+	if !e.interp.running {
+		e.interp.begin()
+	}
+	return e.interp.protect(fn)
 }
 
 // RegisterFunction 注册自定义函数
 func (e *JSEngine) RegisterFunction(name string, fn JSFunction) {
 	e.mu.Lock()
-	defer e.mu.Unlock()
 	e.functions[name] = fn
+	e.mu.Unlock()
+
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	e.guard(func() {
+		e.interp.define(e.interp.global, name, e.goFunction(name, fn))
+	})
 }
 
 // AddEventHandler 添加事件处理器
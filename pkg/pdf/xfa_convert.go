@@ -0,0 +1,446 @@
+package pdf
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ConvertToAcroForm downgrades the XFA form to an AcroForm for tools that
+// cannot process XFA. The form is laid out and the document's pages are
+// replaced by the laid out pages: borders, captions and draws become page
+// content, and each field becomes an AcroForm field whose widgets carry
+// appearance streams showing its current value. Field names follow the
+// form hierarchy, such as "form1[0].items[0].row[1].desc[0]", and an
+// exclusion group becomes a radio button field. The /XFA entry is removed
+// and the result is returned as an incremental update of the document.
+func (xfa *XFAForm) ConvertToAcroForm() ([]byte, error) {
+	if xfa.Form == nil {
+		return nil, fmt.Errorf("xfa: no template to convert")
+	}
+	pages, err := xfa.Layout()
+	if err != nil {
+		return nil, err
+	}
+
+	w := NewIncrementalWriter(xfa.doc)
+	rootRef, err := w.RootRef()
+	if err != nil {
+		return nil, err
+	}
+	c := &xfaConverter{
+		w:       w,
+		fields:  make(map[*XFAFormNode]*xfaAcroField),
+		widgets: make(map[*XFAFormNode]int),
+		fonts:   Dictionary{"Helv": helveticaFont()},
+	}
+	for _, page := range pages {
+		for _, widget := range page.Widgets {
+			c.widgets[widget.Node]++
+		}
+	}
+
+	pagesRef := w.ReserveObject()
+	kids := make(Array, 0, len(pages))
+	for _, page := range pages {
+		kids = append(kids, c.convertPage(page, pagesRef))
+	}
+	w.UpdateObject(pagesRef, Dictionary{
+		"Type":  Name("Pages"),
+		"Kids":  kids,
+		"Count": Integer(len(kids)),
+	})
+	for _, f := range c.fields {
+		if len(f.kids) > 0 {
+			f.dict["Kids"] = f.kids
+		}
+		w.UpdateObject(f.ref, f.dict)
+	}
+
+	root := w.EditDictionary(rootRef)
+	root["Pages"] = pagesRef
+	delete(root, "NeedsRendering")
+
+	form := cloneDict(xfa.doc.acroForm())
+	delete(form, "XFA")
+	delete(form, "NeedAppearances")
+	form["Fields"] = c.top
+	form["DA"] = String{Value: []byte("/Helv 0 Tf 0 g")}
+	form["DR"] = Dictionary{"Font": c.fonts}
+	if ref, ok := xfa.doc.Root.Get("AcroForm").(Reference); ok {
+		w.UpdateObject(ref, form)
+	} else {
+		root["AcroForm"] = form
+	}
+	return w.Bytes()
+}
+
+// ConvertToAcroFormFile converts the form to an AcroForm and writes the
+// document to a file
+func (xfa *XFAForm) ConvertToAcroFormFile(filename string) error {
+	data, err := xfa.ConvertToAcroForm()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// xfaConverter builds the AcroForm field tree of a converted XFA form
+type xfaConverter struct {
+	w       *IncrementalWriter
+	fields  map[*XFAFormNode]*xfaAcroField // fields by form node
+	widgets map[*XFAFormNode]int           // number of widgets per form node
+	top     Array                          // top-level fields
+	fonts   Dictionary                     // default resources fonts
+}
+
+// xfaAcroField is a field dictionary being built
+type xfaAcroField struct {
+	ref  Reference
+	dict Dictionary
+	kids Array
+}
+
+// convertPage writes a laid out page with its static content and the
+// widgets of its fields, and returns the page reference
+func (c *xfaConverter) convertPage(page *XFAPage, parent Reference) Reference {
+	pageRef := c.w.ReserveObject()
+
+	var static []XFADrawOp
+	values := make(map[*XFAFormNode][]XFADrawOp)
+	for _, op := range page.Ops {
+		if op.Value {
+			values[op.Node] = append(values[op.Node], op)
+		} else {
+			static = append(static, op)
+		}
+	}
+	content, resources := (&XFAPage{Width: page.Width, Height: page.Height, Ops: static}).ContentStream()
+	dict := Dictionary{
+		"Type":      Name("Page"),
+		"Parent":    parent,
+		"MediaBox":  Array{Integer(0), Integer(0), Real(page.Width), Real(page.Height)},
+		"Resources": c.indirectResources(resources),
+		"Contents":  c.w.AddObject(newFlateStream(Dictionary{}, content)),
+	}
+
+	var annots Array
+	for _, widget := range page.Widgets {
+		annots = append(annots, c.addWidget(widget, page.Height, values[widget.Node], pageRef))
+	}
+	if len(annots) > 0 {
+		dict["Annots"] = annots
+	}
+	c.w.UpdateObject(pageRef, dict)
+	return pageRef
+}
+
+// addWidget creates the widget annotation of a field on a page. A field
+// with a single widget shares its dictionary with the widget.
+func (c *xfaConverter) addWidget(widget XFAWidget, pageHeight float64, ops []XFADrawOp, pageRef Reference) Reference {
+	n := widget.Node
+	f := c.field(n)
+
+	annot := f.dict
+	ref := f.ref
+	group := n.Parent != nil && n.Parent.Class == "exclGroup"
+	if group || c.widgets[n] > 1 {
+		annot = Dictionary{"Parent": f.ref}
+		ref = c.w.AddObject(annot)
+		f.kids = append(f.kids, ref)
+	}
+
+	annot["Type"] = Name("Annot")
+	annot["Subtype"] = Name("Widget")
+	annot["P"] = pageRef
+	annot["Rect"] = Array{
+		Real(widget.X), Real(pageHeight - widget.Y - widget.H),
+		Real(widget.X + widget.W), Real(pageHeight - widget.Y),
+	}
+	flags := AnnotFlagPrint
+	for p := n; p != nil; p = p.Parent {
+		if p.template != nil && p.template.attr("presence") == "invisible" {
+			flags = AnnotFlagHidden
+			break
+		}
+	}
+	annot["F"] = Integer(flags)
+
+	local := make([]XFADrawOp, len(ops))
+	for i, op := range ops {
+		local[i] = op.translate(-widget.X, -widget.Y)
+	}
+	ui, kind := xfaUI(n.template)
+	if kind != "checkButton" {
+		annot["AP"] = Dictionary{"N": c.appearance(widget.W, widget.H, local)}
+		if kind == "button" {
+			if caption := n.template.child("caption"); caption != nil {
+				annot["MK"] = Dictionary{"CA": textString(xfaValueText(caption.child("value")))}
+			}
+		}
+		return ref
+	}
+
+	// The mark of the on state is drawn whatever the current value
+	on := xfaOnValue(n.template)
+	size := 10.0
+	if s, ok := xfaMeasureAttr(ui, "size"); ok {
+		size = s
+	}
+	m := xfaInsets(ui.child("margin"))
+	var mark []XFADrawOp
+	checked := &XFAFormNode{Class: n.Class, Value: on, template: n.template}
+	for _, op := range xfaCheckButtonOps(checked, ui, xfaStyle(n.template), size,
+		m[3], m[0], widget.W-m[1]-m[3], widget.H-m[0]-m[2]) {
+		if op.Value {
+			mark = append(mark, op)
+		}
+	}
+	annot["AP"] = Dictionary{"N": Dictionary{
+		Name(on):    c.appearance(widget.W, widget.H, mark),
+		Name("Off"): c.appearance(widget.W, widget.H, nil),
+	}}
+	state := Name("Off")
+	if n.Value != "" && n.Value == on {
+		state = Name(on)
+	}
+	annot["AS"] = state
+	annot["MK"] = Dictionary{"CA": String{Value: []byte(xfaZapfMark(ui))}}
+	return ref
+}
+
+// field returns the terminal field of a form node, creating it and its
+// ancestors on first use. The fields of an exclusion group share the
+// group's radio button field.
+func (c *xfaConverter) field(n *XFAFormNode) *xfaAcroField {
+	if n.Parent != nil && n.Parent.Class == "exclGroup" {
+		n = n.Parent
+	}
+	if f, ok := c.fields[n]; ok {
+		return f
+	}
+	f := &xfaAcroField{ref: c.w.ReserveObject(), dict: c.fieldDict(n)}
+	c.fields[n] = f
+	c.attach(n, f)
+	return f
+}
+
+// container returns the non-terminal field of a named container
+func (c *xfaConverter) container(n *XFAFormNode) *xfaAcroField {
+	if f, ok := c.fields[n]; ok {
+		return f
+	}
+	f := &xfaAcroField{ref: c.w.ReserveObject(), dict: Dictionary{"T": xfaPartialName(n)}}
+	c.fields[n] = f
+	c.attach(n, f)
+	return f
+}
+
+// attach links a field to the field of its nearest named ancestor
+func (c *xfaConverter) attach(n *XFAFormNode, f *xfaAcroField) {
+	p := n.Parent
+	for p != nil && p.transparent() {
+		p = p.Parent
+	}
+	if p == nil {
+		c.top = append(c.top, f.ref)
+		return
+	}
+	parent := c.container(p)
+	f.dict["Parent"] = parent.ref
+	parent.kids = append(parent.kids, f.ref)
+}
+
+// xfaPartialName returns the partial field name of a form node
+func xfaPartialName(n *XFAFormNode) String {
+	return textString(n.Name + "[" + strconv.Itoa(n.Index) + "]")
+}
+
+// fieldDict creates the field dictionary of a field or exclusion group
+func (c *xfaConverter) fieldDict(n *XFAFormNode) Dictionary {
+	t := n.template
+	d := Dictionary{}
+	if n.Name != "" {
+		d["T"] = xfaPartialName(n)
+	}
+	if assist := t.child("assist"); assist != nil {
+		if tip := assist.child("toolTip"); tip != nil && tip.text() != "" {
+			d["TU"] = textString(tip.text())
+		}
+	}
+
+	flags := 0
+	switch t.attr("access") {
+	case "readOnly", "protected", "nonInteractive":
+		flags |= FieldFlagReadOnly
+	}
+	if validate := t.child("validate"); validate != nil && validate.attr("nullTest") == "error" {
+		flags |= FieldFlagRequired
+	}
+
+	if n.Class == "exclGroup" {
+		d["FT"] = Name("Btn")
+		flags |= FieldFlagRadio | FieldFlagNoToggle
+		d["V"] = Name("Off")
+		if n.Value != "" {
+			d["V"] = Name(n.Value)
+		}
+		d["Ff"] = Integer(flags)
+		return d
+	}
+
+	ui, kind := xfaUI(t)
+	style := xfaStyle(t)
+	switch kind {
+	case "checkButton":
+		d["FT"] = Name("Btn")
+		d["V"] = Name("Off")
+		if on := xfaOnValue(t); n.Value != "" && n.Value == on {
+			d["V"] = Name(on)
+		}
+	case "button", "imageEdit":
+		d["FT"] = Name("Btn")
+		flags |= FieldFlagPushButton
+	case "signature":
+		d["FT"] = Name("Sig")
+	case "choiceList":
+		d["FT"] = Name("Ch")
+		switch ui.attr("open") {
+		case "always":
+		case "multiSelect":
+			flags |= FieldFlagMultiSel
+		default:
+			flags |= FieldFlagCombo
+		}
+		if ui.attr("textEntry") == "1" {
+			flags |= FieldFlagEdit
+		}
+		d["Opt"] = xfaChoiceOptions(t)
+		d["V"] = textString(n.Value)
+		d["DA"] = c.defaultAppearance(style)
+	default:
+		d["FT"] = Name("Tx")
+		if kind == "textEdit" && ui != nil && ui.attr("multiLine") == "1" {
+			flags |= FieldFlagMultiline
+		}
+		if kind == "passwordEdit" {
+			flags |= FieldFlagPassword
+		}
+		if value := t.child("value"); value != nil {
+			for _, v := range value.elements() {
+				if max, err := strconv.Atoi(v.attr("maxChars")); err == nil && max > 0 {
+					d["MaxLen"] = Integer(max)
+				}
+			}
+		}
+		switch style.hAlign {
+		case "center":
+			d["Q"] = Integer(1)
+		case "right":
+			d["Q"] = Integer(2)
+		}
+		d["V"] = textString(n.Value)
+		d["DA"] = c.defaultAppearance(style)
+	}
+	if flags != 0 {
+		d["Ff"] = Integer(flags)
+	}
+	return d
+}
+
+// xfaChoiceOptions returns the /Opt array of a choice list: pairs of
+// export value and display text when the template saves other values
+// than it displays
+func xfaChoiceOptions(t *xfaNode) Array {
+	var lists []*xfaNode
+	for _, c := range t.elements() {
+		if c.local() == "items" {
+			lists = append(lists, c)
+		}
+	}
+	var opts Array
+	if len(lists) == 0 {
+		return opts
+	}
+	display, save := lists[0], lists[0]
+	if len(lists) > 1 {
+		save = lists[1]
+		if display.attr("save") == "1" {
+			display, save = save, display
+		}
+	}
+	de, se := display.elements(), save.elements()
+	for i, e := range se {
+		text := e.text()
+		if i < len(de) && de[i].text() != text {
+			opts = append(opts, Array{textString(text), textString(de[i].text())})
+		} else {
+			opts = append(opts, textString(text))
+		}
+	}
+	return opts
+}
+
+// defaultAppearance returns the /DA string for text in the given style,
+// adding its font to the default resources
+func (c *xfaConverter) defaultAppearance(style xfaTextStyle) String {
+	base := xfaBaseFont(style.font)
+	key := Name(strings.ReplaceAll(base, "-", ""))
+	if base == "Helvetica" {
+		key = "Helv"
+	}
+	if _, ok := c.fonts[key]; !ok {
+		font := helveticaFont()
+		font["BaseFont"] = Name(base)
+		c.fonts[key] = font
+	}
+	da := fmt.Sprintf("/%s %s Tf %s", key, formatNum(style.font.Size), xfaColorOp(style.color, false))
+	return String{Value: []byte(da)}
+}
+
+// appearance writes an appearance stream drawing ops in a w by h box
+func (c *xfaConverter) appearance(w, h float64, ops []XFADrawOp) Reference {
+	content, resources := (&XFAPage{Width: w, Height: h, Ops: ops}).ContentStream()
+	if len(resources) == 0 {
+		resources = nil
+	}
+	return c.w.AddObject(newAppearanceStream(w, h, content, c.indirectResources(resources)))
+}
+
+// indirectResources writes the image XObjects of a resource dictionary as
+// indirect objects, since streams cannot be direct objects
+func (c *xfaConverter) indirectResources(resources Dictionary) Dictionary {
+	xobjects, ok := resources.Get("XObject").(Dictionary)
+	if !ok {
+		return resources
+	}
+	for name, obj := range xobjects {
+		if stream, ok := obj.(Stream); ok {
+			xobjects[name] = c.w.AddObject(stream)
+		}
+	}
+	return resources
+}
+
+// xfaZapfMark returns the ZapfDingbats character of a check button mark
+func xfaZapfMark(ui *xfaNode) string {
+	switch ui.attr("mark") {
+	case "circle":
+		return "l"
+	case "cross":
+		return "8"
+	case "diamond":
+		return "u"
+	case "square":
+		return "n"
+	case "star":
+		return "H"
+	case "check":
+		return "4"
+	}
+	if ui.attr("shape") == "round" {
+		return "l"
+	}
+	return "4"
+}
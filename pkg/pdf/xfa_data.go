@@ -0,0 +1,1145 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// XFA data namespace used when a document has no datasets packet
+const xfaDataNamespace = "http://www.xfa.org/schema/xfa-data/1.0/"
+
+// ErrXFAOccurMax is returned when adding a subform instance would exceed
+// the maximum of its occur element
+var ErrXFAOccurMax = errors.New("xfa: maximum number of subform instances reached")
+
+// xfaNode is a node of an XFA packet. Element names keep their namespace
+// prefix so that a packet can be written back the way it was read. Text
+// nodes have an empty name.
+type xfaNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xfaNode
+	data     string
+	parent   *xfaNode
+}
+
+// parseXFAXML parses an XFA packet and returns its root element
+func parseXFAXML(data []byte) (*xfaNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	doc := &xfaNode{}
+	cur := doc
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xfaNode{name: xfaQualifiedName(t.Name), attrs: append([]xml.Attr(nil), t.Attr...), parent: cur}
+			cur.children = append(cur.children, n)
+			cur = n
+		case xml.EndElement:
+			if cur.parent == nil {
+				return nil, fmt.Errorf("xfa: unexpected end element %s", xfaQualifiedName(t.Name))
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != doc {
+				cur.children = append(cur.children, &xfaNode{data: string(t), parent: cur})
+			}
+		}
+	}
+	for _, n := range doc.children {
+		if n.name != "" {
+			n.parent = nil
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("xfa: packet has no root element")
+}
+
+func xfaQualifiedName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// local returns the element name without its namespace prefix
+func (n *xfaNode) local() string {
+	if i := strings.IndexByte(n.name, ':'); i >= 0 {
+		return n.name[i+1:]
+	}
+	return n.name
+}
+
+// attr returns the value of an attribute, matched by local name
+func (n *xfaNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// elements returns the element children
+func (n *xfaNode) elements() []*xfaNode {
+	var out []*xfaNode
+	for _, c := range n.children {
+		if c.name != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// child returns the first element child with the given local name
+func (n *xfaNode) child(local string) *xfaNode {
+	for _, c := range n.children {
+		if c.name != "" && c.local() == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// text returns the character data of the node and its descendants
+func (n *xfaNode) text() string {
+	if n.name == "" {
+		return n.data
+	}
+	var sb strings.Builder
+	for _, c := range n.children {
+		sb.WriteString(c.text())
+	}
+	return sb.String()
+}
+
+// setText replaces the content of the node with text
+func (n *xfaNode) setText(s string) {
+	n.children = nil
+	if s != "" {
+		n.children = []*xfaNode{{data: s, parent: n}}
+	}
+}
+
+// appendChild adds an element after the last child with the same name,
+// or at the end
+func (n *xfaNode) appendChild(c *xfaNode) {
+	c.parent = n
+	pos := len(n.children)
+	for i, sib := range n.children {
+		if sib.name == c.name {
+			pos = i + 1
+		}
+	}
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = c
+}
+
+// isDataGroup reports whether a data element is a data group rather than
+// a data value
+func (n *xfaNode) isDataGroup() bool {
+	switch n.attr("dataNode") {
+	case "dataGroup":
+		return true
+	case "dataValue":
+		return false
+	}
+	return len(n.elements()) > 0
+}
+
+// write serializes the node as XML
+func (n *xfaNode) write(buf *bytes.Buffer) {
+	if n.name == "" {
+		xml.EscapeText(buf, []byte(n.data))
+		return
+	}
+	buf.WriteByte('<')
+	buf.WriteString(n.name)
+	for _, a := range n.attrs {
+		buf.WriteByte(' ')
+		buf.WriteString(xfaQualifiedName(a.Name))
+		buf.WriteString(`="`)
+		xml.EscapeText(buf, []byte(a.Value))
+		buf.WriteByte('"')
+	}
+	if len(n.children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteByte('>')
+	for _, c := range n.children {
+		c.write(buf)
+	}
+	buf.WriteString("</")
+	buf.WriteString(n.name)
+	buf.WriteByte('>')
+}
+
+// Form DOM
+
+// XFAFormNode is a node of the merged form: a container of the template
+// together with the data it is bound to. Repeating subforms appear once
+// per instance.
+type XFAFormNode struct {
+	Class    string // subform, field, exclGroup, draw, area, subformSet or pageSet
+	Name     string
+	Index    int    // instance number among siblings of the same name
+	Value    string // field or exclGroup value
+	Parent   *XFAFormNode
+	Children []*XFAFormNode
+
+	template *xfaNode
+	data     *xfaNode
+}
+
+// Bound reports whether the node is bound to a data node
+func (n *XFAFormNode) Bound() bool {
+	return n.data != nil
+}
+
+// SOM returns the fully qualified scripting object model reference of the
+// node, such as "form1[0].items[0].row[2].amount[0]"
+func (n *XFAFormNode) SOM() string {
+	var parts []string
+	for p := n; p != nil; p = p.Parent {
+		if p.Name == "" {
+			continue
+		}
+		parts = append(parts, p.Name+"["+strconv.Itoa(p.Index)+"]")
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ".")
+}
+
+// Fields returns the fields below the node in document order
+func (n *XFAFormNode) Fields() []*XFAFormNode {
+	var out []*XFAFormNode
+	n.walk(func(c *XFAFormNode) {
+		if c.Class == "field" {
+			out = append(out, c)
+		}
+	})
+	return out
+}
+
+// walk calls fn for the node and its descendants in document order
+func (n *XFAFormNode) walk(fn func(*XFAFormNode)) {
+	fn(n)
+	for _, c := range n.Children {
+		c.walk(fn)
+	}
+}
+
+// transparent reports whether SOM references see through the node
+func (n *XFAFormNode) transparent() bool {
+	return n.Name == "" || n.Class == "area" || n.Class == "subformSet" || n.Class == "pageSet"
+}
+
+// namedChildren returns the children called name, looking through
+// transparent containers
+func (n *XFAFormNode) namedChildren(name string) []*XFAFormNode {
+	var out []*XFAFormNode
+	for _, c := range n.Children {
+		if c.Name == name {
+			out = append(out, c)
+		} else if c.transparent() {
+			out = append(out, c.namedChildren(name)...)
+		}
+	}
+	return out
+}
+
+// xfaContainerClasses are the template elements that become form nodes
+var xfaContainerClasses = map[string]bool{
+	"subform": true, "field": true, "exclGroup": true, "draw": true,
+	"area": true, "subformSet": true, "pageSet": true, "pageArea": true,
+}
+
+// xfaOccur returns the min, max and initial occurrences of a subform;
+// max is -1 when unbounded
+func xfaOccur(tmpl *xfaNode) (min, max, initial int) {
+	min, max = 1, 1
+	occur := tmpl.child("occur")
+	if occur == nil {
+		return 1, 1, 1
+	}
+	if v, err := strconv.Atoi(occur.attr("min")); err == nil && v >= 0 {
+		min = v
+	}
+	max = min
+	if min < 1 {
+		max = 1
+	}
+	if v, err := strconv.Atoi(occur.attr("max")); err == nil {
+		max = v
+	}
+	if max >= 0 && max < min {
+		max = min
+	}
+	initial = min
+	if v, err := strconv.Atoi(occur.attr("initial")); err == nil && v >= 0 {
+		initial = v
+	}
+	if initial < min {
+		initial = min
+	}
+	if max >= 0 && initial > max {
+		initial = max
+	}
+	return min, max, initial
+}
+
+// xfaBinding returns the match rule and reference of a container. Unnamed
+// containers never bind.
+func xfaBinding(tmpl *xfaNode) (match, ref string) {
+	match = "once"
+	if bind := tmpl.child("bind"); bind != nil {
+		if m := bind.attr("match"); m != "" {
+			match = m
+		}
+		ref = bind.attr("ref")
+	}
+	if tmpl.attr("name") == "" && match != "dataRef" {
+		match = "none"
+	}
+	return match, ref
+}
+
+// xfaDefaultValue returns the default value of a field or exclGroup
+func xfaDefaultValue(tmpl *xfaNode) string {
+	value := tmpl.child("value")
+	if value == nil {
+		return ""
+	}
+	if elems := value.elements(); len(elems) > 0 {
+		return elems[0].text()
+	}
+	return ""
+}
+
+// xfaItems returns the values a check button or choice list saves: the
+// items list marked save="1", else the first list
+func xfaItems(tmpl *xfaNode) []string {
+	var items *xfaNode
+	for _, c := range tmpl.elements() {
+		if c.local() != "items" {
+			continue
+		}
+		if items == nil || c.attr("save") == "1" {
+			items = c
+		}
+	}
+	if items == nil {
+		return nil
+	}
+	var out []string
+	for _, c := range items.elements() {
+		out = append(out, c.text())
+	}
+	return out
+}
+
+// xfaOnValue returns the value of a check button when it is on
+func xfaOnValue(tmpl *xfaNode) string {
+	if items := xfaItems(tmpl); len(items) > 0 {
+		return items[0]
+	}
+	return "1"
+}
+
+// xfaMerger binds a template to a data DOM, creating the form DOM
+type xfaMerger struct {
+	data   *xfaNode // the xfa:data element
+	record *xfaNode // the data root ($record)
+	bound  map[*xfaNode]bool
+}
+
+// merge builds the form DOM from the template and datasets packets,
+// creating any data nodes the template requires
+func (xfa *XFAForm) merge() {
+	xfa.Form = nil
+	if xfa.templateDOM == nil {
+		return
+	}
+	var root *xfaNode
+	for _, c := range xfa.templateDOM.elements() {
+		if c.local() == "subform" {
+			root = c
+			break
+		}
+	}
+	if root == nil {
+		return
+	}
+
+	if xfa.datasetsDOM == nil {
+		xfa.datasetsDOM = &xfaNode{
+			name:  "xfa:datasets",
+			attrs: []xml.Attr{{Name: xml.Name{Space: "xmlns", Local: "xfa"}, Value: xfaDataNamespace}},
+		}
+	}
+	data := xfa.datasetsDOM.child("data")
+	if data == nil {
+		data = &xfaNode{name: xfaDataPrefix(xfa.datasetsDOM) + "data"}
+		xfa.datasetsDOM.children = append([]*xfaNode{data}, xfa.datasetsDOM.children...)
+		data.parent = xfa.datasetsDOM
+	}
+
+	m := &xfaMerger{data: data, bound: make(map[*xfaNode]bool)}
+	name := root.attr("name")
+	for _, c := range data.elements() {
+		if name == "" || c.local() == name {
+			m.record = c
+			break
+		}
+	}
+	if m.record == nil {
+		recordName := name
+		if recordName == "" {
+			recordName = "form1"
+		}
+		m.record = &xfaNode{name: recordName}
+		data.appendChild(m.record)
+	}
+	m.bound[m.record] = true
+
+	form := &XFAFormNode{Class: "subform", Name: name, template: root, data: m.record}
+	m.mergeChildren(root, form, m.record)
+	xfa.Form = form
+}
+
+// xfaDataPrefix returns the namespace prefix of the datasets element
+func xfaDataPrefix(datasets *xfaNode) string {
+	if i := strings.IndexByte(datasets.name, ':'); i >= 0 {
+		return datasets.name[:i+1]
+	}
+	return ""
+}
+
+// mergeChildren merges the containers of a template node into a form
+// node; scope is the data group that children bind relative to
+func (m *xfaMerger) mergeChildren(tmpl *xfaNode, parent *XFAFormNode, scope *xfaNode) {
+	for _, c := range tmpl.elements() {
+		class := c.local()
+		if !xfaContainerClasses[class] {
+			continue
+		}
+		switch class {
+		case "subform":
+			m.mergeSubform(c, parent, scope)
+		case "field":
+			m.mergeField(c, parent, scope)
+		case "exclGroup":
+			m.mergeExclGroup(c, parent, scope)
+		case "draw":
+			m.addNode(parent, &XFAFormNode{Class: class, Name: c.attr("name"), template: c, Value: xfaDefaultValue(c)})
+		default:
+			node := &XFAFormNode{Class: class, Name: c.attr("name"), template: c}
+			m.addNode(parent, node)
+			m.mergeChildren(c, node, scope)
+		}
+	}
+}
+
+// addNode appends a form node, numbering it among same-named siblings
+func (m *xfaMerger) addNode(parent, node *XFAFormNode) {
+	node.Parent = parent
+	if node.Name != "" {
+		scope := parent
+		for scope.transparent() && scope.Parent != nil {
+			scope = scope.Parent
+		}
+		node.Index = len(scope.namedChildren(node.Name))
+	}
+	parent.Children = append(parent.Children, node)
+}
+
+// mergeSubform creates the instances of a subform, one per matching data
+// group within the limits of its occur element
+func (m *xfaMerger) mergeSubform(tmpl *xfaNode, parent *XFAFormNode, scope *xfaNode) {
+	name := tmpl.attr("name")
+	match, ref := xfaBinding(tmpl)
+	min, max, initial := xfaOccur(tmpl)
+
+	var groups []*xfaNode
+	switch match {
+	case "once", "global":
+		for _, c := range scope.elements() {
+			if c.local() == name && !m.bound[c] && c.isDataGroup() {
+				groups = append(groups, c)
+			}
+		}
+		// An empty data value of the same name can hold the subform
+		if len(groups) == 0 {
+			for _, c := range scope.elements() {
+				if c.local() == name && !m.bound[c] && c.text() == "" {
+					groups = append(groups, c)
+					break
+				}
+			}
+		}
+	case "dataRef":
+		groups = m.resolve(ref, scope)
+	}
+
+	count := len(groups)
+	if count == 0 {
+		count = initial
+	} else if count < min {
+		count = min
+	}
+	if max >= 0 && count > max {
+		count = max
+	}
+	if match == "none" {
+		count = initial
+	}
+
+	for i := 0; i < count; i++ {
+		var group *xfaNode
+		switch {
+		case match == "none":
+			group = nil
+		case i < len(groups):
+			group = groups[i]
+		case match == "dataRef":
+			group = m.create(ref, scope, true)
+		default:
+			group = &xfaNode{name: name}
+			scope.appendChild(group)
+		}
+		node := &XFAFormNode{Class: "subform", Name: name, template: tmpl, data: group}
+		if group != nil && match != "dataRef" {
+			m.bound[group] = true
+		}
+		m.addNode(parent, node)
+
+		childScope := group
+		if childScope == nil {
+			childScope = scope
+		}
+		m.mergeChildren(tmpl, node, childScope)
+	}
+}
+
+// bindValue finds or creates the data value a field or exclGroup binds to
+func (m *xfaMerger) bindValue(tmpl *xfaNode, scope *xfaNode, defValue string) *xfaNode {
+	name := tmpl.attr("name")
+	match, ref := xfaBinding(tmpl)
+
+	var node *xfaNode
+	switch match {
+	case "none":
+		return nil
+	case "once":
+		node = m.findValue(scope, name)
+	case "global":
+		// A global field shares the first data value of its name anywhere
+		// in the record
+		node = m.findGlobal(m.record, name)
+		if node == nil {
+			node = m.findValue(scope, name)
+		}
+	case "dataRef":
+		if nodes := m.resolve(ref, scope); len(nodes) > 0 {
+			return nodes[0]
+		}
+		node := m.create(ref, scope, false)
+		if node != nil {
+			node.setText(defValue)
+		}
+		return node
+	}
+	if node == nil {
+		node = &xfaNode{name: name}
+		node.setText(defValue)
+		scope.appendChild(node)
+	}
+	if match == "once" {
+		m.bound[node] = true
+	}
+	return node
+}
+
+// findValue returns the first unbound data value of a name in scope
+func (m *xfaMerger) findValue(scope *xfaNode, name string) *xfaNode {
+	for _, c := range scope.elements() {
+		if c.local() == name && !m.bound[c] && !c.isDataGroup() {
+			return c
+		}
+	}
+	return nil
+}
+
+// findGlobal returns the first data value of a name below n
+func (m *xfaMerger) findGlobal(n *xfaNode, name string) *xfaNode {
+	for _, c := range n.elements() {
+		if c.local() == name && !c.isDataGroup() {
+			return c
+		}
+		if found := m.findGlobal(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func (m *xfaMerger) mergeField(tmpl *xfaNode, parent *XFAFormNode, scope *xfaNode) {
+	defValue := xfaDefaultValue(tmpl)
+	node := &XFAFormNode{Class: "field", Name: tmpl.attr("name"), template: tmpl, Value: defValue}
+	if data := m.bindValue(tmpl, scope, defValue); data != nil {
+		node.data = data
+		node.Value = data.text()
+	}
+	m.addNode(parent, node)
+}
+
+// mergeExclGroup binds a group of radio buttons to one data value holding
+// the on-value of the selected button
+func (m *xfaMerger) mergeExclGroup(tmpl *xfaNode, parent *XFAFormNode, scope *xfaNode) {
+	defValue := xfaDefaultValue(tmpl)
+	var fields []*xfaNode
+	for _, c := range tmpl.elements() {
+		if c.local() == "field" {
+			fields = append(fields, c)
+			if defValue == "" && xfaDefaultValue(c) != "" && xfaDefaultValue(c) == xfaOnValue(c) {
+				defValue = xfaOnValue(c)
+			}
+		}
+	}
+
+	group := &XFAFormNode{Class: "exclGroup", Name: tmpl.attr("name"), template: tmpl, Value: defValue}
+	if data := m.bindValue(tmpl, scope, defValue); data != nil {
+		group.data = data
+		group.Value = data.text()
+	}
+	m.addNode(parent, group)
+	for _, f := range fields {
+		m.addNode(group, &XFAFormNode{Class: "field", Name: f.attr("name"), template: f})
+	}
+	group.syncExclGroup()
+}
+
+// syncExclGroup sets the value of each button of a group to its on-value
+// when selected and to "" otherwise
+func (n *XFAFormNode) syncExclGroup() {
+	for _, c := range n.Children {
+		c.Value = ""
+		if on := xfaOnValue(c.template); n.Value != "" && n.Value == on {
+			c.Value = on
+		}
+	}
+}
+
+// xfaRefStep is one step of a SOM reference
+type xfaRefStep struct {
+	name  string
+	index int // -1 for [*]
+}
+
+// parseXFARef splits a SOM reference into steps; "$", "$record" and
+// "$data" at the start select the base node
+func parseXFARef(ref string) (base string, steps []xfaRefStep) {
+	ref = strings.TrimSpace(ref)
+	parts := strings.Split(ref, ".")
+	if len(parts) > 0 && strings.HasPrefix(parts[0], "$") {
+		base, parts = parts[0], parts[1:]
+	}
+	for _, p := range parts {
+		if p == "" {
+			continue
+		}
+		step := xfaRefStep{name: p}
+		if i := strings.IndexByte(p, '['); i >= 0 && strings.HasSuffix(p, "]") {
+			step.name = p[:i]
+			idx := strings.TrimSpace(p[i+1 : len(p)-1])
+			if idx == "*" {
+				step.index = -1
+			} else if n, err := strconv.Atoi(idx); err == nil {
+				step.index = n
+			}
+		}
+		steps = append(steps, step)
+	}
+	return base, steps
+}
+
+// refBase returns the data node a reference starts from
+func (m *xfaMerger) refBase(base string, scope *xfaNode) *xfaNode {
+	switch base {
+	case "$record":
+		return m.record
+	case "$data", "$xfa.datasets.data":
+		return m.data
+	}
+	return scope
+}
+
+// resolve returns the data nodes selected by a bind reference
+func (m *xfaMerger) resolve(ref string, scope *xfaNode) []*xfaNode {
+	base, steps := parseXFARef(ref)
+	nodes := []*xfaNode{m.refBase(base, scope)}
+	for _, step := range steps {
+		var next []*xfaNode
+		for _, n := range nodes {
+			i := 0
+			for _, c := range n.elements() {
+				if c.local() != step.name {
+					continue
+				}
+				if step.index < 0 || step.index == i {
+					next = append(next, c)
+				}
+				i++
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// create adds the data node a bind reference names, creating missing
+// ancestors. For [*] references a new instance is appended.
+func (m *xfaMerger) create(ref string, scope *xfaNode, group bool) *xfaNode {
+	base, steps := parseXFARef(ref)
+	n := m.refBase(base, scope)
+	for i, step := range steps {
+		last := i == len(steps)-1
+		var found *xfaNode
+		if !(last && step.index < 0) {
+			index := 0
+			for _, c := range n.elements() {
+				if c.local() != step.name {
+					continue
+				}
+				if index == step.index || step.index < 0 {
+					found = c
+					break
+				}
+				index++
+			}
+		}
+		if found == nil {
+			found = &xfaNode{name: step.name}
+			n.appendChild(found)
+		}
+		n = found
+	}
+	if n == m.refBase(base, scope) {
+		return nil
+	}
+	return n
+}
+
+// Form access
+
+// FindNodes returns the nodes of the merged form matching a SOM
+// reference such as "form1.items.row[2].amount", "row[*]" or "amount".
+// A reference that does not start at the root finds the first node of
+// that name in document order; [*] selects all instances.
+func (xfa *XFAForm) FindNodes(ref string) []*XFAFormNode {
+	if xfa.Form == nil {
+		return nil
+	}
+	ref = strings.TrimPrefix(strings.TrimPrefix(ref, "xfa.form."), "$form.")
+	_, steps := parseXFARef(ref)
+	if len(steps) == 0 {
+		return nil
+	}
+	var nodes []*XFAFormNode
+	first := steps[0]
+	if xfa.Form.Name == first.name && first.index <= 0 {
+		nodes = []*XFAFormNode{xfa.Form}
+	} else {
+		xfa.Form.walk(func(n *XFAFormNode) {
+			if n.Name == first.name && (first.index < 0 || n.Index == first.index) {
+				nodes = append(nodes, n)
+			}
+		})
+		if first.index >= 0 && len(nodes) > 1 {
+			nodes = nodes[:1]
+		}
+	}
+
+	for _, step := range steps[1:] {
+		var next []*XFAFormNode
+		for _, n := range nodes {
+			for _, c := range n.namedChildren(step.name) {
+				if step.index < 0 || c.Index == step.index {
+					next = append(next, c)
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// findField returns the field or exclGroup a reference names
+func (xfa *XFAForm) findField(ref string) (*XFAFormNode, error) {
+	for _, n := range xfa.FindNodes(ref) {
+		if n.Class == "field" || n.Class == "exclGroup" {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("xfa: field %q not found", ref)
+}
+
+// setNodeValue sets the value of a field or exclGroup and of the data
+// value it is bound to, together with every node sharing that data value
+func (xfa *XFAForm) setNodeValue(n *XFAFormNode, value string) {
+	if n.Class == "field" && n.Parent != nil && n.Parent.Class == "exclGroup" {
+		// Selecting a radio button sets the value of its group
+		group := n.Parent
+		if value != "" {
+			value = xfaOnValue(n.template)
+		} else if group.Value != xfaOnValue(n.template) {
+			return
+		}
+		n = group
+	}
+
+	n.Value = value
+	if n.Class == "exclGroup" {
+		n.syncExclGroup()
+	}
+	if n.data == nil {
+		return
+	}
+	n.data.setText(value)
+	xfa.Form.walk(func(o *XFAFormNode) {
+		if o != n && o.data == n.data {
+			o.Value = value
+			if o.Class == "exclGroup" {
+				o.syncExclGroup()
+			}
+		}
+	})
+}
+
+// AddInstance adds an instance of a repeating subform after the last
+// instance of the same name, binding it to a new data group
+func (xfa *XFAForm) AddInstance(ref string) (*XFAFormNode, error) {
+	nodes := xfa.FindNodes(ref)
+	if len(nodes) == 0 || nodes[0].Class != "subform" {
+		return nil, fmt.Errorf("xfa: subform %q not found", ref)
+	}
+	proto := nodes[0]
+	parent := proto.Parent
+	if parent == nil {
+		return nil, fmt.Errorf("xfa: the root subform cannot repeat")
+	}
+
+	var last *XFAFormNode
+	count := 0
+	for _, c := range parent.Children {
+		if c.template == proto.template {
+			last = c
+			count++
+		}
+	}
+	if _, max, _ := xfaOccur(proto.template); max >= 0 && count >= max {
+		return nil, ErrXFAOccurMax
+	}
+
+	m := &xfaMerger{data: xfa.datasetsDOM.child("data"), record: xfa.Form.data, bound: xfa.boundData()}
+	scope := parent.dataScope()
+	var group *xfaNode
+	match, bindRef := xfaBinding(proto.template)
+	switch match {
+	case "none":
+	case "dataRef":
+		group = m.create(bindRef, scope, true)
+	default:
+		group = &xfaNode{name: proto.Name}
+		if last.data != nil && last.data.parent == scope {
+			insertAfter(scope, last.data, group)
+		} else {
+			scope.appendChild(group)
+		}
+		m.bound[group] = true
+	}
+
+	node := &XFAFormNode{Class: "subform", Name: proto.Name, template: proto.template, data: group, Parent: parent, Index: last.Index + 1}
+	for i, c := range parent.Children {
+		if c == last {
+			parent.Children = append(parent.Children[:i+1], append([]*XFAFormNode{node}, parent.Children[i+1:]...)...)
+			break
+		}
+	}
+	childScope := group
+	if childScope == nil {
+		childScope = scope
+	}
+	m.mergeChildren(proto.template, node, childScope)
+	return node, nil
+}
+
+// insertAfter inserts c into parent after the child after
+func insertAfter(parent, after, c *xfaNode) {
+	c.parent = parent
+	for i, sib := range parent.children {
+		if sib == after {
+			parent.children = append(parent.children[:i+1], append([]*xfaNode{c}, parent.children[i+1:]...)...)
+			return
+		}
+	}
+	parent.children = append(parent.children, c)
+}
+
+// dataScope returns the data group children of the node bind relative to
+func (n *XFAFormNode) dataScope() *xfaNode {
+	for p := n; p != nil; p = p.Parent {
+		if p.data != nil && p.Class == "subform" {
+			return p.data
+		}
+	}
+	return nil
+}
+
+// boundData returns the data nodes bound with match="once"
+func (xfa *XFAForm) boundData() map[*xfaNode]bool {
+	bound := make(map[*xfaNode]bool)
+	xfa.Form.walk(func(n *XFAFormNode) {
+		if n.data != nil {
+			bound[n.data] = true
+		}
+	})
+	return bound
+}
+
+// ImportData replaces the form data with an XML document, merges it with
+// the template again and runs the calculations of the form. The document
+// may be an xfa:datasets packet, an xfa:data element or the data root
+// element itself.
+func (xfa *XFAForm) ImportData(data []byte) error {
+	if xfa.templateDOM == nil {
+		return fmt.Errorf("xfa: form has no template")
+	}
+	root, err := parseXFAXML(data)
+	if err != nil {
+		return fmt.Errorf("xfa: invalid data: %w", err)
+	}
+
+	var record *xfaNode
+	switch root.local() {
+	case "datasets":
+		xfa.datasetsDOM = root
+		xfa.merge()
+		return xfa.Calculate()
+	case "data":
+		if elems := root.elements(); len(elems) > 0 {
+			record = elems[0]
+		}
+	default:
+		record = root
+	}
+
+	if xfa.datasetsDOM == nil {
+		xfa.merge()
+	}
+	dataNode := xfa.datasetsDOM.child("data")
+	dataNode.children = nil
+	if record != nil {
+		dataNode.appendChild(record)
+	}
+	xfa.merge()
+	return xfa.Calculate()
+}
+
+// DatasetsXML returns the datasets packet reflecting the current data
+func (xfa *XFAForm) DatasetsXML() []byte {
+	if xfa.datasetsDOM == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	xfa.datasetsDOM.write(&buf)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// Bytes returns the document with the updated datasets packet written to
+// the /XFA entry as an incremental update
+func (xfa *XFAForm) Bytes() ([]byte, error) {
+	w := NewIncrementalWriter(xfa.doc)
+	if err := xfa.writeDatasets(w); err != nil {
+		return nil, err
+	}
+	return w.Bytes()
+}
+
+// WriteToFile writes the document with the updated data to a file
+func (xfa *XFAForm) WriteToFile(filename string) error {
+	data, err := xfa.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+// writeDatasets stores the datasets packet in the /XFA entry of the
+// AcroForm, replacing the existing packet or adding one after the template
+func (xfa *XFAForm) writeDatasets(w *IncrementalWriter) error {
+	packet := xfa.DatasetsXML()
+	if packet == nil {
+		return fmt.Errorf("xfa: no datasets to write")
+	}
+
+	formObj := xfa.doc.Root.Get("AcroForm")
+	form, ok := resolveDict(xfa.doc, formObj)
+	if !ok {
+		return fmt.Errorf("no AcroForm found")
+	}
+	xfaObj := form.Get("XFA")
+	resolved, err := xfa.doc.ResolveObject(xfaObj)
+	if err != nil {
+		return err
+	}
+
+	switch v := resolved.(type) {
+	case Array:
+		for i := 0; i+1 < len(v); i += 2 {
+			name, _ := v[i].(String)
+			if string(name.Value) != "datasets" {
+				continue
+			}
+			stream := newFlateStream(nil, packet)
+			if ref, ok := v[i+1].(Reference); ok {
+				w.UpdateObject(ref, stream)
+				return nil
+			}
+			arr := append(Array(nil), v...)
+			arr[i+1] = w.AddObject(stream)
+			return xfa.setXFAEntry(w, formObj, xfaObj, arr)
+		}
+
+		// Datasets follow the template, before the closing packet
+		pos := len(v)
+		for i := 0; i+1 < len(v); i += 2 {
+			name, _ := v[i].(String)
+			if string(name.Value) == "template" {
+				pos = i + 2
+			} else if string(name.Value) == "postamble" && pos == len(v) {
+				pos = i
+			}
+		}
+		arr := make(Array, 0, len(v)+2)
+		arr = append(arr, v[:pos]...)
+		arr = append(arr, String{Value: []byte("datasets")}, w.AddObject(newFlateStream(nil, packet)))
+		arr = append(arr, v[pos:]...)
+		return xfa.setXFAEntry(w, formObj, xfaObj, arr)
+
+	case Stream:
+		data, err := v.Decode()
+		if err != nil {
+			return err
+		}
+		data = xfaReplacePacket(data, packet)
+		stream := newFlateStream(nil, data)
+		if ref, ok := xfaObj.(Reference); ok {
+			w.UpdateObject(ref, stream)
+			return nil
+		}
+		return xfa.setXFAEntry(w, formObj, xfaObj, w.AddObject(stream))
+	}
+	return fmt.Errorf("invalid XFA data type")
+}
+
+// setXFAEntry replaces the value of the /XFA entry
+func (xfa *XFAForm) setXFAEntry(w *IncrementalWriter, formObj, xfaObj Object, value Object) error {
+	if ref, ok := xfaObj.(Reference); ok {
+		if _, isRef := value.(Reference); !isRef {
+			w.UpdateObject(ref, value)
+			return nil
+		}
+	}
+	if ref, ok := formObj.(Reference); ok {
+		w.EditDictionary(ref)["XFA"] = value
+		return nil
+	}
+	rootRef, err := w.RootRef()
+	if err != nil {
+		return err
+	}
+	root := w.EditDictionary(rootRef)
+	form, _ := resolveDict(xfa.doc, root.Get("AcroForm"))
+	form = cloneDict(form)
+	form["XFA"] = value
+	root["AcroForm"] = form
+	return nil
+}
+
+// xfaReplacePacket replaces the datasets element of a complete XDP
+// document, or inserts the packet before the closing xdp element
+func xfaReplacePacket(xdp, packet []byte) []byte {
+	start, end, rootEnd := xfaFindDatasets(xdp)
+	var buf bytes.Buffer
+	if start >= 0 && end >= 0 {
+		buf.Write(xdp[:start])
+		buf.Write(bytes.TrimRight(packet, "\n"))
+		buf.Write(xdp[end:])
+		return buf.Bytes()
+	}
+	pos := rootEnd
+	if pos < 0 {
+		pos = len(xdp)
+	}
+	buf.Write(xdp[:pos])
+	buf.Write(packet)
+	buf.Write(xdp[pos:])
+	return buf.Bytes()
+}
+
+// xfaFindDatasets returns the byte range of the datasets packet in an XDP
+// document, found by namespace and local name whatever its prefix, and the
+// offset of the root element's end tag. Offsets are -1 when not found.
+func xfaFindDatasets(xdp []byte) (start, end, rootEnd int) {
+	start, end, rootEnd = -1, -1, -1
+	dec := xml.NewDecoder(bytes.NewReader(xdp))
+	dec.Strict = false
+	depth, packetDepth := 0, 0
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			// Packets are children of the xdp element. An undeclared xfa
+			// prefix is kept as the namespace.
+			isDatasets := t.Name.Local == "datasets" && (t.Name.Space == xfaDataNamespace || t.Name.Space == "xfa")
+			if start < 0 && depth <= 2 && isDatasets {
+				start, packetDepth = offset, depth
+			}
+		case xml.EndElement:
+			// The decoder reports <datasets/> as a start and an end
+			// element, both ending at the same offset
+			if start >= 0 && end < 0 && depth == packetDepth {
+				end = int(dec.InputOffset())
+			}
+			if depth == 1 {
+				rootEnd = offset
+			}
+			depth--
+		}
+	}
+}
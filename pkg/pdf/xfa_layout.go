@@ -0,0 +1,1538 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// XFADrawKind identifies the kind of an XFADrawOp
+type XFADrawKind int
+
+const (
+	XFADrawRect XFADrawKind = iota
+	XFADrawLine
+	XFADrawEllipse
+	XFADrawPath
+	XFADrawText
+	XFADrawImage
+)
+
+// XFAFontSpec describes the font of a text operation
+type XFAFontSpec struct {
+	Typeface string
+	Size     float64
+	Bold     bool
+	Italic   bool
+}
+
+// XFADrawOp is a drawing operation produced by the XFA layout. Positions
+// are in points from the top-left corner of the page. Rectangles, ellipses
+// and images occupy X, Y, W, H; a line runs from (X, Y) to (X+W, Y+H); a
+// path joins the x, y pairs in Points; text is a single line whose
+// baseline starts at (X, Y) and is W wide.
+type XFADrawOp struct {
+	Kind       XFADrawKind
+	X, Y, W, H float64
+	Points     []float64
+	Closed     bool
+	Fill       color.Color // nil when not filled
+	Stroke     color.Color // nil when not stroked
+	LineWidth  float64
+	Text       string
+	Font       XFAFontSpec
+	Image      image.Image
+	Node       *XFAFormNode // the field or draw that produced the operation
+	Value      bool         // the operation shows the value of a field
+}
+
+// XFAWidget is the area of a page holding the interactive part of a field,
+// excluding its caption
+type XFAWidget struct {
+	Node       *XFAFormNode
+	X, Y, W, H float64
+}
+
+// XFAPage is a laid out page of an XFA form
+type XFAPage struct {
+	Width, Height float64
+	Ops           []XFADrawOp
+	Widgets       []XFAWidget
+}
+
+// Layout lays out the merged form on pages. Containers are positioned or
+// flowed according to their layout attribute, flowed content is
+// paginated across the content areas of the page areas in the pageSet,
+// and the content of each page area is repeated on the pages that use it.
+func (xfa *XFAForm) Layout() ([]*XFAPage, error) {
+	if xfa.Form == nil {
+		return nil, fmt.Errorf("xfa: no template to lay out")
+	}
+	p := &xfaPaginator{areas: xfaPageAreas(xfa.Form)}
+	p.occurs = make([]int, len(p.areas))
+	if root := xfaLayoutNode(xfa.Form, p.areas[0].areas[0].w); root != nil {
+		p.place(root, 0)
+	}
+	if len(p.pages) == 0 {
+		p.newPage("")
+	}
+	return p.pages, nil
+}
+
+// xfaBlock is a laid out container, field or draw. Its operations and
+// widgets are relative to its origin, which is x, y within the parent.
+type xfaBlock struct {
+	x, y, w, h float64
+	ops        []XFADrawOp
+	widgets    []XFAWidget
+	kids       []*xfaBlock
+	flowed     bool // the kids flow top to bottom and may be split
+	before     xfaBreak
+	after      xfaBreak
+}
+
+// xfaBreak is a page or content area break before or after a container
+type xfaBreak struct {
+	kind   string // "page" or "area"
+	target string
+}
+
+// hide removes the visible parts of the block, keeping its extent
+func (b *xfaBlock) hide() {
+	b.ops = nil
+	for _, k := range b.kids {
+		k.hide()
+	}
+}
+
+// translate returns the operation moved by dx, dy
+func (op XFADrawOp) translate(dx, dy float64) XFADrawOp {
+	op.X += dx
+	op.Y += dy
+	if op.Points != nil {
+		pts := make([]float64, len(op.Points))
+		for i, v := range op.Points {
+			if i%2 == 0 {
+				pts[i] = v + dx
+			} else {
+				pts[i] = v + dy
+			}
+		}
+		op.Points = pts
+	}
+	return op
+}
+
+// xfaLayoutNode lays out a form node in the given available width. Nodes
+// whose presence is hidden or inactive take no space and return nil.
+func xfaLayoutNode(n *XFAFormNode, availW float64) *xfaBlock {
+	t := n.template
+	if t == nil {
+		return nil
+	}
+	presence := t.attr("presence")
+	if presence == "hidden" || presence == "inactive" {
+		return nil
+	}
+	var b *xfaBlock
+	switch n.Class {
+	case "field":
+		b = xfaLayoutField(n, availW)
+	case "draw":
+		b = xfaLayoutDraw(n, availW)
+	case "subform", "exclGroup", "area", "subformSet", "pageArea":
+		b = xfaLayoutContainer(n, availW)
+	default:
+		return nil
+	}
+	if presence == "invisible" {
+		b.hide()
+	}
+	return b
+}
+
+// xfaLayoutContainer lays out the children of a subform, exclGroup, area,
+// subformSet or pageArea according to the container's layout attribute
+func xfaLayoutContainer(n *XFAFormNode, availW float64) *xfaBlock {
+	t := n.template
+	b := &xfaBlock{}
+	b.before, b.after = xfaBreaks(t)
+	margin := xfaInsets(t.child("margin"))
+
+	w, hasW := xfaMeasureAttr(t, "w")
+	innerW := availW
+	if hasW {
+		innerW = w
+	}
+	innerW -= margin[1] + margin[3]
+
+	layout := t.attr("layout")
+	if n.Class == "subformSet" {
+		layout = "tb"
+	}
+
+	var contentW, contentH float64
+	switch layout {
+	case "tb", "table":
+		y := 0.0
+		for _, c := range n.Children {
+			if c.Class == "pageSet" {
+				continue
+			}
+			k := xfaLayoutNode(c, innerW)
+			if k == nil {
+				continue
+			}
+			k.x, k.y = margin[3], margin[0]+y
+			y += k.h
+			contentW = math.Max(contentW, k.w)
+			b.kids = append(b.kids, k)
+		}
+		contentH = y
+		b.flowed = true
+
+	case "lr-tb", "rl-tb", "row":
+		var columns []float64
+		if layout == "row" && n.Parent != nil && n.Parent.template != nil {
+			for _, f := range strings.Fields(n.Parent.template.attr("columnWidths")) {
+				v, _ := xfaMeasure(f)
+				columns = append(columns, v)
+			}
+		}
+		var line []*xfaBlock
+		x, y, lineH := 0.0, 0.0, 0.0
+		flush := func() {
+			if layout == "rl-tb" {
+				for _, k := range line {
+					k.x = margin[3] + innerW - (k.x - margin[3]) - k.w
+				}
+			}
+			contentW = math.Max(contentW, x)
+			y += lineH
+			x, lineH, line = 0, 0, nil
+		}
+		col := 0
+		for _, c := range n.Children {
+			avail := innerW - x
+			if col < len(columns) && columns[col] > 0 {
+				avail = columns[col]
+			}
+			k := xfaLayoutNode(c, avail)
+			if k == nil {
+				continue
+			}
+			advance := k.w
+			if col < len(columns) && columns[col] > 0 {
+				advance = columns[col]
+			}
+			col++
+			if layout != "row" && x > 0 && x+advance > innerW+0.01 {
+				flush()
+			}
+			k.x, k.y = margin[3]+x, margin[0]+y
+			x += advance
+			lineH = math.Max(lineH, k.h)
+			line = append(line, k)
+			b.kids = append(b.kids, k)
+		}
+		flush()
+		contentH = y
+
+	default: // position
+		for _, c := range n.Children {
+			if c.Class == "pageSet" {
+				continue
+			}
+			k := xfaLayoutNode(c, innerW)
+			if k == nil {
+				continue
+			}
+			x, _ := xfaMeasureAttr(c.template, "x")
+			y, _ := xfaMeasureAttr(c.template, "y")
+			dx, dy := xfaAnchor(c.template.attr("anchorType"), k.w, k.h)
+			k.x, k.y = margin[3]+x+dx, margin[0]+y+dy
+			contentW = math.Max(contentW, k.x+k.w-margin[3])
+			contentH = math.Max(contentH, k.y+k.h-margin[0])
+			b.kids = append(b.kids, k)
+		}
+	}
+
+	if hasW {
+		b.w = w
+	} else {
+		b.w = xfaClamp(t, "minW", "maxW", contentW+margin[1]+margin[3])
+	}
+	if h, ok := xfaMeasureAttr(t, "h"); ok {
+		b.h = h
+		b.flowed = false
+	} else {
+		b.h = xfaClamp(t, "minH", "maxH", contentH+margin[0]+margin[2])
+	}
+	b.ops = xfaBorderOps(t.child("border"), 0, 0, b.w, b.h, n)
+	return b
+}
+
+// xfaLayoutField lays out a field: its border, caption, widget and value
+func xfaLayoutField(n *XFAFormNode, availW float64) *xfaBlock {
+	t := n.template
+	b := &xfaBlock{}
+	style := xfaStyle(t)
+	margin := xfaInsets(t.child("margin"))
+	ui, kind := xfaUI(t)
+	var uiMargin [4]float64
+	if ui != nil {
+		uiMargin = xfaInsets(ui.child("margin"))
+	}
+
+	// Caption
+	caption := t.child("caption")
+	if caption != nil && (caption.attr("presence") == "hidden" || caption.attr("presence") == "invisible") {
+		caption = nil
+	}
+	var capText string
+	var capStyle xfaTextStyle
+	placement := "left"
+	reserve := 0.0
+	if caption != nil {
+		capText = xfaValueText(caption.child("value"))
+		capStyle = xfaStyle(caption)
+		if p := caption.attr("placement"); p != "" && p != "inline" {
+			placement = p
+		}
+		if kind == "button" {
+			placement = "inline"
+		}
+		if r, ok := xfaMeasureAttr(caption, "reserve"); ok && r > 0 {
+			reserve = r
+		} else if placement == "left" || placement == "right" {
+			if capText != "" {
+				reserve = xfaTextWidth(capText, capStyle.font) + 3
+			}
+		} else if placement != "inline" {
+			_, reserve = xfaTextOps(capText, capStyle, 0, 0, 1e6, 0, false, nil, false)
+		}
+	}
+
+	display := xfaFieldDisplay(n, kind)
+	multiLine := ui != nil && kind == "textEdit" && ui.attr("multiLine") == "1"
+	checkSize := 10.0
+	if kind == "checkButton" {
+		if s, ok := xfaMeasureAttr(ui, "size"); ok {
+			checkSize = s
+		}
+	}
+
+	// Extent
+	w, hasW := xfaMeasureAttr(t, "w")
+	if !hasW {
+		natural := margin[1] + margin[3] + uiMargin[1] + uiMargin[3]
+		if placement == "left" || placement == "right" {
+			natural += reserve
+		}
+		switch kind {
+		case "checkButton":
+			natural += checkSize
+		default:
+			natural += math.Max(xfaTextWidth(display, style.font), 72)
+		}
+		// Text widgets without a width take the rest of the available space
+		w = natural
+		if kind != "checkButton" && availW > w {
+			w = availW
+		}
+		if _, ok := xfaMeasureAttr(t, "minW"); ok {
+			w = natural
+		}
+		w = xfaClamp(t, "minW", "maxW", w)
+	}
+	widgetW := w - margin[1] - margin[3]
+	if placement == "left" || placement == "right" {
+		widgetW -= reserve
+	}
+	valueW := widgetW - uiMargin[1] - uiMargin[3]
+
+	h, hasH := xfaMeasureAttr(t, "h")
+	if !hasH {
+		var contentH float64
+		switch kind {
+		case "checkButton":
+			contentH = checkSize
+		default:
+			_, contentH = xfaTextOps(display, style, 0, 0, valueW, 0, multiLine, nil, false)
+		}
+		contentH += uiMargin[0] + uiMargin[2] + margin[0] + margin[2]
+		if placement == "top" || placement == "bottom" {
+			contentH += reserve
+		}
+		h = xfaClamp(t, "minH", "maxH", contentH)
+	}
+	b.w, b.h = w, h
+
+	// Regions
+	cx, cy := margin[3], margin[0]
+	cw, ch := w-margin[1]-margin[3], h-margin[0]-margin[2]
+	wx, wy, ww, wh := cx, cy, cw, ch
+	var capX, capY, capW, capH float64
+	switch placement {
+	case "left":
+		capX, capY, capW, capH = cx, cy, reserve, ch
+		wx, ww = cx+reserve, cw-reserve
+	case "right":
+		capX, capY, capW, capH = cx+cw-reserve, cy, reserve, ch
+		ww = cw - reserve
+	case "top":
+		capX, capY, capW, capH = cx, cy, cw, reserve
+		wy, wh = cy+reserve, ch-reserve
+	case "bottom":
+		capX, capY, capW, capH = cx, cy+ch-reserve, cw, reserve
+		wh = ch - reserve
+	case "inline":
+		capX, capY, capW, capH = wx, wy, ww, wh
+	}
+
+	b.ops = append(b.ops, xfaBorderOps(t.child("border"), 0, 0, w, h, n)...)
+	if kind == "button" && (ui == nil || ui.child("border") == nil) {
+		gray := color.RGBA{212, 208, 200, 255}
+		b.ops = append(b.ops, XFADrawOp{Kind: XFADrawRect, X: wx, Y: wy, W: ww, H: wh,
+			Fill: gray, Stroke: color.RGBA{128, 128, 128, 255}, LineWidth: 1, Node: n})
+	} else if ui != nil {
+		b.ops = append(b.ops, xfaBorderOps(ui.child("border"), wx, wy, ww, wh, n)...)
+	}
+	if caption != nil && capText != "" {
+		if placement == "inline" && capStyle.hAlign == "" {
+			capStyle.hAlign, capStyle.vAlign = "center", "middle"
+		}
+		ops, _ := xfaTextOps(capText, capStyle, capX, capY, capW, capH, placement == "top" || placement == "bottom", n, false)
+		b.ops = append(b.ops, ops...)
+	}
+	b.widgets = append(b.widgets, XFAWidget{Node: n, X: wx, Y: wy, W: ww, H: wh})
+
+	// Value
+	vx, vy := wx+uiMargin[3], wy+uiMargin[0]
+	vw, vh := ww-uiMargin[1]-uiMargin[3], wh-uiMargin[0]-uiMargin[2]
+	switch kind {
+	case "button", "signature":
+	case "checkButton":
+		b.ops = append(b.ops, xfaCheckButtonOps(n, ui, style, checkSize, vx, vy, vw, vh)...)
+	case "imageEdit":
+		if img := xfaDecodeImage(n.Value); img != nil {
+			b.ops = append(b.ops, xfaImageOp(img, t.child("value"), vx, vy, vw, vh, n, true))
+		}
+	default:
+		ops, _ := xfaTextOps(display, style, vx, vy, vw, vh, multiLine, n, true)
+		b.ops = append(b.ops, ops...)
+	}
+	return b
+}
+
+// xfaLayoutDraw lays out a draw: static text, a line, rectangle, arc or
+// image
+func xfaLayoutDraw(n *XFAFormNode, availW float64) *xfaBlock {
+	t := n.template
+	b := &xfaBlock{}
+	style := xfaStyle(t)
+	margin := xfaInsets(t.child("margin"))
+
+	var content *xfaNode
+	if value := t.child("value"); value != nil {
+		if elems := value.elements(); len(elems) > 0 {
+			content = elems[0]
+		}
+	}
+	kind := ""
+	text := ""
+	if content != nil {
+		kind = content.local()
+		switch kind {
+		case "line", "rectangle", "arc", "image":
+		case "exData":
+			text = xfaRichText(content)
+		default:
+			text = content.text()
+		}
+	}
+	isText := kind != "line" && kind != "rectangle" && kind != "arc" && kind != "image"
+
+	w, hasW := xfaMeasureAttr(t, "w")
+	if !hasW {
+		natural := margin[1] + margin[3]
+		if isText {
+			for _, line := range strings.Split(text, "\n") {
+				natural = math.Max(natural, xfaTextWidth(line, style.font)+style.marginLeft+style.marginRight+margin[1]+margin[3])
+			}
+		}
+		w = xfaClamp(t, "minW", "maxW", natural)
+	}
+	h, hasH := xfaMeasureAttr(t, "h")
+	if !hasH {
+		natural := margin[0] + margin[2]
+		if isText {
+			_, th := xfaTextOps(text, style, 0, 0, w-margin[1]-margin[3], 0, true, nil, false)
+			natural += th
+		}
+		h = xfaClamp(t, "minH", "maxH", natural)
+	}
+	b.w, b.h = w, h
+
+	cx, cy := margin[3], margin[0]
+	cw, ch := w-margin[1]-margin[3], h-margin[0]-margin[2]
+	b.ops = append(b.ops, xfaBorderOps(t.child("border"), 0, 0, w, h, n)...)
+	switch kind {
+	case "line":
+		edge := xfaEdgeOf(content.child("edge"))
+		if edge.visible {
+			x1, y1, x2, y2 := cx, cy, cx+cw, cy+ch
+			if content.attr("slope") == "/" {
+				y1, y2 = cy+ch, cy
+			}
+			b.ops = append(b.ops, XFADrawOp{Kind: XFADrawLine, X: x1, Y: y1, W: x2 - x1, H: y2 - y1,
+				Stroke: edge.color, LineWidth: edge.width, Node: n})
+		}
+	case "rectangle":
+		b.ops = append(b.ops, xfaBorderOps(content, cx, cy, cw, ch, n)...)
+	case "arc":
+		edge := xfaEdgeOf(content.child("edge"))
+		op := XFADrawOp{Kind: XFADrawEllipse, X: cx, Y: cy, W: cw, H: ch, Node: n}
+		if content.attr("circular") == "1" {
+			d := math.Min(cw, ch)
+			op.X, op.Y, op.W, op.H = cx+(cw-d)/2, cy+(ch-d)/2, d, d
+		}
+		if edge.visible {
+			op.Stroke, op.LineWidth = edge.color, edge.width
+		}
+		if fill := content.child("fill"); fill != nil && fill.attr("presence") != "hidden" {
+			op.Fill = xfaColorOf(fill.child("color"), color.White)
+		}
+		if op.Fill != nil || op.Stroke != nil {
+			b.ops = append(b.ops, op)
+		}
+	case "image":
+		if img := xfaDecodeImage(content.text()); img != nil {
+			b.ops = append(b.ops, xfaImageOp(img, content, cx, cy, cw, ch, n, false))
+		}
+	default:
+		ops, _ := xfaTextOps(text, style, cx, cy, cw, ch, true, n, false)
+		b.ops = append(b.ops, ops...)
+	}
+	return b
+}
+
+// xfaCheckButtonOps draws the box of a check button and, when the field is
+// on, its mark
+func xfaCheckButtonOps(n *XFAFormNode, ui *xfaNode, style xfaTextStyle, size, x, y, w, h float64) []XFADrawOp {
+	bx, by := x, y+(h-size)/2
+	switch style.hAlign {
+	case "center":
+		bx = x + (w-size)/2
+	case "right":
+		bx = x + w - size
+	}
+	switch style.vAlign {
+	case "top":
+		by = y
+	case "bottom":
+		by = y + h - size
+	}
+
+	round := ui.attr("shape") == "round"
+	black := color.Color(color.Black)
+	var ops []XFADrawOp
+	if ui.child("border") == nil {
+		box := XFADrawOp{Kind: XFADrawRect, X: bx, Y: by, W: size, H: size, Stroke: black, LineWidth: 0.5, Node: n}
+		if round {
+			box.Kind = XFADrawEllipse
+		}
+		ops = append(ops, box)
+	}
+
+	on := xfaOnValue(n.template)
+	if n.Value == "" || n.Value != on {
+		return ops
+	}
+	mark := ui.attr("mark")
+	if mark == "" || mark == "default" {
+		mark = "check"
+		if round {
+			mark = "circle"
+		}
+	}
+	inset := size * 0.2
+	mx, my, ms := bx+inset, by+inset, size-2*inset
+	op := XFADrawOp{Node: n, Value: true, Fill: style.color}
+	switch mark {
+	case "circle":
+		op.Kind, op.X, op.Y, op.W, op.H = XFADrawEllipse, mx, my, ms, ms
+	case "square":
+		op.Kind, op.X, op.Y, op.W, op.H = XFADrawRect, mx, my, ms, ms
+	case "diamond":
+		op.Kind, op.Closed = XFADrawPath, true
+		op.Points = []float64{mx + ms/2, my, mx + ms, my + ms/2, mx + ms/2, my + ms, mx, my + ms/2}
+	case "star":
+		op.Kind, op.Closed = XFADrawPath, true
+		for i := 0; i < 10; i++ {
+			r := ms / 2
+			if i%2 == 1 {
+				r *= 0.4
+			}
+			a := -math.Pi/2 + float64(i)*math.Pi/5
+			op.Points = append(op.Points, mx+ms/2+r*math.Cos(a), my+ms/2+r*math.Sin(a))
+		}
+	case "cross":
+		return append(ops,
+			XFADrawOp{Kind: XFADrawLine, X: mx, Y: my, W: ms, H: ms, Stroke: style.color, LineWidth: size / 10, Node: n, Value: true},
+			XFADrawOp{Kind: XFADrawLine, X: mx, Y: my + ms, W: ms, H: -ms, Stroke: style.color, LineWidth: size / 10, Node: n, Value: true})
+	default: // check
+		op.Kind, op.Fill, op.Stroke, op.LineWidth = XFADrawPath, nil, style.color, size/8
+		op.Points = []float64{mx, my + ms*0.55, mx + ms*0.4, my + ms, mx + ms, my}
+	}
+	return append(ops, op)
+}
+
+// xfaImageOp places an image in a box according to the aspect attribute of
+// the image element
+func xfaImageOp(img image.Image, elem *xfaNode, x, y, w, h float64, n *XFAFormNode, value bool) XFADrawOp {
+	b := img.Bounds()
+	iw, ih := float64(b.Dx()), float64(b.Dy())
+	op := XFADrawOp{Kind: XFADrawImage, Image: img, Node: n, Value: value, X: x, Y: y, W: w, H: h}
+	aspect := ""
+	if elem != nil {
+		if elem.local() != "image" {
+			elem = elem.child("image")
+		}
+		if elem != nil {
+			aspect = elem.attr("aspect")
+		}
+	}
+	switch aspect {
+	case "none":
+	case "actual":
+		op.W, op.H = iw, ih
+	case "width":
+		op.H = w * ih / iw
+	case "height":
+		op.W = h * iw / ih
+	default: // fit
+		s := math.Min(w/iw, h/ih)
+		op.W, op.H = iw*s, ih*s
+	}
+	return op
+}
+
+// xfaDecodeImage decodes base64 image data
+func xfaDecodeImage(data string) image.Image {
+	data = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, data)
+	if data == "" {
+		return nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// xfaUI returns the widget element of a field's ui and its name
+func xfaUI(t *xfaNode) (*xfaNode, string) {
+	ui := t.child("ui")
+	if ui != nil {
+		for _, c := range ui.elements() {
+			switch c.local() {
+			case "picture", "extras":
+				continue
+			}
+			return c, c.local()
+		}
+	}
+	return nil, "textEdit"
+}
+
+// xfaFieldDisplay returns the text a field shows for its value
+func xfaFieldDisplay(n *XFAFormNode, kind string) string {
+	switch kind {
+	case "passwordEdit":
+		return strings.Repeat("*", len([]rune(n.Value)))
+	case "choiceList":
+		var lists []*xfaNode
+		for _, c := range n.template.elements() {
+			if c.local() == "items" {
+				lists = append(lists, c)
+			}
+		}
+		if len(lists) == 2 {
+			display, save := lists[0], lists[1]
+			if display.attr("save") == "1" {
+				display, save = save, display
+			}
+			de, se := display.elements(), save.elements()
+			for i, e := range se {
+				if e.text() == n.Value && i < len(de) {
+					return de[i].text()
+				}
+			}
+		}
+	}
+	return n.Value
+}
+
+// xfaValueText returns the text of a value element, flattening rich text
+func xfaValueText(value *xfaNode) string {
+	if value == nil {
+		return ""
+	}
+	for _, c := range value.elements() {
+		if c.local() == "exData" {
+			return xfaRichText(c)
+		}
+		return c.text()
+	}
+	return ""
+}
+
+// xfaRichText flattens an exData element holding XHTML to plain text with
+// paragraphs and line breaks as newlines
+func xfaRichText(n *xfaNode) string {
+	var sb strings.Builder
+	var walk func(n *xfaNode)
+	walk = func(n *xfaNode) {
+		if n.name == "" {
+			sb.WriteString(n.data)
+			return
+		}
+		local := n.local()
+		if local == "br" {
+			sb.WriteString("\n")
+			return
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+		if (local == "p" || local == "div" || local == "li") && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	for _, c := range n.children {
+		walk(c)
+	}
+	text := strings.TrimRight(sb.String(), "\n")
+	if len(n.elements()) == 0 && strings.Contains(text, "<") {
+		// Rich text stored as escaped markup
+		text = html.UnescapeString(xfaStripTags(text))
+	}
+	return text
+}
+
+// xfaStripTags removes markup from text, turning paragraph ends and line
+// breaks into newlines
+func xfaStripTags(s string) string {
+	var sb strings.Builder
+	for {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			sb.WriteString(s)
+			break
+		}
+		sb.WriteString(s[:i])
+		j := strings.IndexByte(s[i:], '>')
+		if j < 0 {
+			break
+		}
+		tag := strings.ToLower(strings.Trim(s[i+1:i+j], "/ "))
+		if strings.HasPrefix(tag, "br") || tag == "p" && strings.HasPrefix(s[i+1:], "/") {
+			sb.WriteString("\n")
+		}
+		s = s[i+j+1:]
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// xfaTextStyle is the font and paragraph formatting of text
+type xfaTextStyle struct {
+	font                    XFAFontSpec
+	color                   color.Color
+	hAlign, vAlign          string
+	spaceAbove, spaceBelow  float64
+	marginLeft, marginRight float64
+	textIndent, lineHeight  float64
+}
+
+// xfaStyle reads the font and para elements of a field, draw or caption
+func xfaStyle(t *xfaNode) xfaTextStyle {
+	s := xfaTextStyle{
+		font:  XFAFontSpec{Typeface: "Courier", Size: 10},
+		color: color.Black,
+	}
+	if f := t.child("font"); f != nil {
+		if tf := f.attr("typeface"); tf != "" {
+			s.font.Typeface = tf
+		}
+		if size, ok := xfaMeasureAttr(f, "size"); ok && size > 0 {
+			s.font.Size = size
+		}
+		s.font.Bold = f.attr("weight") == "bold"
+		s.font.Italic = f.attr("posture") == "italic"
+		if fill := f.child("fill"); fill != nil {
+			s.color = xfaColorOf(fill.child("color"), color.Black)
+		}
+	}
+	if p := t.child("para"); p != nil {
+		s.hAlign = p.attr("hAlign")
+		s.vAlign = p.attr("vAlign")
+		s.spaceAbove, _ = xfaMeasureAttr(p, "spaceAbove")
+		s.spaceBelow, _ = xfaMeasureAttr(p, "spaceBelow")
+		s.marginLeft, _ = xfaMeasureAttr(p, "marginLeft")
+		s.marginRight, _ = xfaMeasureAttr(p, "marginRight")
+		s.textIndent, _ = xfaMeasureAttr(p, "textIndent")
+		s.lineHeight, _ = xfaMeasureAttr(p, "lineHeight")
+	}
+	return s
+}
+
+// xfaTextOps sets text in a box, wrapping it at the box width when wrap
+// is set, and returns the text operations and the height of the text. A
+// box height of zero aligns the text to the top.
+func xfaTextOps(text string, s xfaTextStyle, x, y, w, h float64, wrap bool, n *XFAFormNode, value bool) ([]XFADrawOp, float64) {
+	size := s.font.Size
+	lineH := s.lineHeight
+	if lineH <= 0 {
+		lineH = size * 1.2
+	}
+	avail := w - s.marginLeft - s.marginRight
+	lines := xfaWrap(text, s.font, avail, wrap)
+	textH := float64(len(lines))*lineH + s.spaceAbove + s.spaceBelow
+
+	top := y + s.spaceAbove
+	if h > 0 {
+		switch s.vAlign {
+		case "middle":
+			top += (h - textH) / 2
+		case "bottom":
+			top += h - textH
+		}
+	}
+	var ops []XFADrawOp
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		lw := xfaTextWidth(line, s.font)
+		lx := x + s.marginLeft
+		if i == 0 {
+			lx += s.textIndent
+		}
+		switch s.hAlign {
+		case "center":
+			lx += (avail - lw) / 2
+		case "right":
+			lx += avail - lw
+		}
+		baseline := top + float64(i)*lineH + (lineH-size)/2 + size*0.8
+		ops = append(ops, XFADrawOp{Kind: XFADrawText, X: lx, Y: baseline, W: lw, H: size,
+			Text: line, Font: s.font, Fill: s.color, Node: n, Value: value})
+	}
+	return ops, textH
+}
+
+// xfaWrap splits text into lines at newlines and, when wrap is set, at
+// word boundaries so each line fits in width
+func xfaWrap(text string, font XFAFontSpec, width float64, wrap bool) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if !wrap || width <= 0 {
+			lines = append(lines, para)
+			continue
+		}
+		words := strings.Fields(para)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := words[0]
+		for _, word := range words[1:] {
+			if xfaTextWidth(line+" "+word, font) > width {
+				lines = append(lines, line)
+				line = word
+			} else {
+				line += " " + word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// xfaTextWidth returns the width of text set in the standard font that
+// stands in for the typeface
+func xfaTextWidth(text string, font XFAFontSpec) float64 {
+	base := xfaBaseFont(font)
+	switch {
+	case strings.HasPrefix(base, "Courier"):
+		return float64(len([]rune(text))) * 0.6 * font.Size
+	case strings.HasPrefix(base, "Times"):
+		return helveticaTextWidth(encodeWinAnsi(text), font.Size) * 0.9
+	case font.Bold:
+		return helveticaTextWidth(encodeWinAnsi(text), font.Size) * 1.05
+	}
+	return helveticaTextWidth(encodeWinAnsi(text), font.Size)
+}
+
+// xfaBaseFont maps a typeface to one of the standard 14 fonts
+func xfaBaseFont(font XFAFontSpec) string {
+	tf := strings.ToLower(font.Typeface)
+	family := "Helvetica"
+	switch {
+	case strings.Contains(tf, "courier") || strings.Contains(tf, "mono"):
+		family = "Courier"
+	case strings.Contains(tf, "times") || strings.Contains(tf, "minion") || strings.Contains(tf, "georgia") ||
+		strings.Contains(tf, "garamond") || strings.Contains(tf, "serif") && !strings.Contains(tf, "sans"):
+		family = "Times"
+	}
+	switch {
+	case family == "Times" && font.Bold && font.Italic:
+		return "Times-BoldItalic"
+	case family == "Times" && font.Bold:
+		return "Times-Bold"
+	case family == "Times" && font.Italic:
+		return "Times-Italic"
+	case family == "Times":
+		return "Times-Roman"
+	case font.Bold && font.Italic:
+		return family + "-BoldOblique"
+	case font.Bold:
+		return family + "-Bold"
+	case font.Italic:
+		return family + "-Oblique"
+	}
+	return family
+}
+
+// xfaEdge is one side of a border
+type xfaEdge struct {
+	visible bool
+	width   float64
+	color   color.Color
+}
+
+// xfaEdgeOf reads an edge element; a missing edge is a visible black
+// 0.5pt line
+func xfaEdgeOf(e *xfaNode) xfaEdge {
+	edge := xfaEdge{visible: true, width: 0.5, color: color.Black}
+	if e == nil {
+		return edge
+	}
+	if p := e.attr("presence"); p == "hidden" || p == "invisible" {
+		edge.visible = false
+	}
+	if t, ok := xfaMeasureAttr(e, "thickness"); ok {
+		edge.width = t
+	}
+	edge.color = xfaColorOf(e.child("color"), color.Black)
+	return edge
+}
+
+// xfaBorderOps draws a border or rectangle element around a box: its fill
+// and then its edges, given in the order top, right, bottom, left
+func xfaBorderOps(border *xfaNode, x, y, w, h float64, n *XFAFormNode) []XFADrawOp {
+	if border == nil {
+		return nil
+	}
+	if p := border.attr("presence"); p == "hidden" || p == "invisible" {
+		return nil
+	}
+	var ops []XFADrawOp
+	if fill := border.child("fill"); fill != nil && fill.attr("presence") != "hidden" {
+		ops = append(ops, XFADrawOp{Kind: XFADrawRect, X: x, Y: y, W: w, H: h,
+			Fill: xfaColorOf(fill.child("color"), color.White), Node: n})
+	}
+
+	var elems []*xfaNode
+	for _, c := range border.elements() {
+		if c.local() == "edge" {
+			elems = append(elems, c)
+		}
+	}
+	var edges [4]xfaEdge
+	switch len(elems) {
+	case 0:
+		e := xfaEdgeOf(nil)
+		edges = [4]xfaEdge{e, e, e, e}
+	case 1:
+		e := xfaEdgeOf(elems[0])
+		edges = [4]xfaEdge{e, e, e, e}
+	case 2:
+		a, b := xfaEdgeOf(elems[0]), xfaEdgeOf(elems[1])
+		edges = [4]xfaEdge{a, b, a, b}
+	case 3:
+		a, b, c := xfaEdgeOf(elems[0]), xfaEdgeOf(elems[1]), xfaEdgeOf(elems[2])
+		edges = [4]xfaEdge{a, b, c, b}
+	default:
+		for i := range edges {
+			edges[i] = xfaEdgeOf(elems[i])
+		}
+	}
+
+	same := true
+	for _, e := range edges[1:] {
+		if e != edges[0] {
+			same = false
+		}
+	}
+	if same {
+		if edges[0].visible {
+			ops = append(ops, XFADrawOp{Kind: XFADrawRect, X: x, Y: y, W: w, H: h,
+				Stroke: edges[0].color, LineWidth: edges[0].width, Node: n})
+		}
+		return ops
+	}
+	lines := [4][4]float64{
+		{x, y, w, 0},
+		{x + w, y, 0, h},
+		{x, y + h, w, 0},
+		{x, y, 0, h},
+	}
+	for i, e := range edges {
+		if e.visible {
+			l := lines[i]
+			ops = append(ops, XFADrawOp{Kind: XFADrawLine, X: l[0], Y: l[1], W: l[2], H: l[3],
+				Stroke: e.color, LineWidth: e.width, Node: n})
+		}
+	}
+	return ops
+}
+
+// xfaColorOf reads the value attribute of a color element
+func xfaColorOf(c *xfaNode, def color.Color) color.Color {
+	if c == nil {
+		return def
+	}
+	parts := strings.Split(c.attr("value"), ",")
+	if len(parts) != 3 {
+		return def
+	}
+	var rgb [3]uint8
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return def
+		}
+		rgb[i] = uint8(min(max(v, 0), 255))
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 255}
+}
+
+// xfaInsets reads a margin element as top, right, bottom, left insets
+func xfaInsets(m *xfaNode) [4]float64 {
+	var out [4]float64
+	if m == nil {
+		return out
+	}
+	for i, name := range []string{"topInset", "rightInset", "bottomInset", "leftInset"} {
+		out[i], _ = xfaMeasureAttr(m, name)
+	}
+	return out
+}
+
+// xfaMeasure converts an XFA measurement such as "0.5in", "9mm" or "12pt"
+// to points; a number without a unit is in inches
+func xfaMeasure(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	i := len(s)
+	for i > 0 && s[i-1] >= 'a' && s[i-1] <= 'z' {
+		i--
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, false
+	}
+	switch s[i:] {
+	case "", "in":
+		v *= 72
+	case "cm":
+		v *= 72 / 2.54
+	case "mm":
+		v *= 72 / 25.4
+	case "mp":
+		v /= 1000
+	}
+	return v, true
+}
+
+// xfaMeasureAttr reads a measurement attribute
+func xfaMeasureAttr(n *xfaNode, name string) (float64, bool) {
+	if n == nil {
+		return 0, false
+	}
+	return xfaMeasure(n.attr(name))
+}
+
+// xfaClamp limits v to the minimum and maximum given by two attributes
+func xfaClamp(t *xfaNode, minAttr, maxAttr string, v float64) float64 {
+	if m, ok := xfaMeasureAttr(t, minAttr); ok {
+		v = math.Max(v, m)
+	}
+	if m, ok := xfaMeasureAttr(t, maxAttr); ok && m > 0 {
+		v = math.Min(v, m)
+	}
+	return v
+}
+
+// xfaAnchor returns the offset of a container's top-left corner from its
+// anchor point
+func xfaAnchor(anchor string, w, h float64) (dx, dy float64) {
+	switch {
+	case strings.HasSuffix(anchor, "Center"):
+		dx = -w / 2
+	case strings.HasSuffix(anchor, "Right"):
+		dx = -w
+	}
+	switch {
+	case strings.HasPrefix(anchor, "middle"):
+		dy = -h / 2
+	case strings.HasPrefix(anchor, "bottom"):
+		dy = -h
+	}
+	return dx, dy
+}
+
+// xfaBreaks reads the breaks before and after a container
+func xfaBreaks(t *xfaNode) (before, after xfaBreak) {
+	for _, c := range t.elements() {
+		switch c.local() {
+		case "breakBefore":
+			before = xfaBreakOf(c.attr("targetType"), c.attr("target"))
+		case "breakAfter":
+			after = xfaBreakOf(c.attr("targetType"), c.attr("target"))
+		case "break":
+			before = xfaBreakOf(c.attr("before"), c.attr("beforeTarget"))
+			after = xfaBreakOf(c.attr("after"), c.attr("afterTarget"))
+		}
+	}
+	return before, after
+}
+
+func xfaBreakOf(kind, target string) xfaBreak {
+	switch kind {
+	case "pageArea", "pageEven", "pageOdd":
+		return xfaBreak{kind: "page", target: target}
+	case "contentArea":
+		return xfaBreak{kind: "area", target: target}
+	}
+	return xfaBreak{}
+}
+
+// xfaRect is a content area in page coordinates
+type xfaRect struct {
+	x, y, w, h float64
+}
+
+// xfaPageArea is a page master: the page size, its content areas and the
+// form node holding the content repeated on each page
+type xfaPageArea struct {
+	node          *XFAFormNode
+	width, height float64
+	areas         []xfaRect
+	max           int // -1 when unbounded
+}
+
+// xfaMediumSizes are the page sizes of the stock names of medium elements
+var xfaMediumSizes = map[string][2]float64{
+	"letter":    {612, 792},
+	"legal":     {612, 1008},
+	"executive": {522, 756},
+	"tabloid":   {792, 1224},
+	"ledger":    {792, 1224},
+	"a3":        {841.89, 1190.55},
+	"a4":        {595.28, 841.89},
+	"a5":        {419.53, 595.28},
+	"b4":        {708.66, 1000.63},
+	"b5":        {498.9, 708.66},
+}
+
+// xfaPageAreas collects the page areas of the form's page sets. A form
+// without one is laid out on letter pages with quarter-inch margins.
+func xfaPageAreas(form *XFAFormNode) []*xfaPageArea {
+	var out []*xfaPageArea
+	var collect func(n *XFAFormNode)
+	collect = func(n *XFAFormNode) {
+		for _, c := range n.Children {
+			switch c.Class {
+			case "pageSet":
+				collect(c)
+			case "pageArea":
+				out = append(out, xfaNewPageArea(c))
+			}
+		}
+	}
+	collect(form)
+	if len(out) == 0 {
+		out = append(out, xfaNewPageArea(nil))
+	}
+	return out
+}
+
+func xfaNewPageArea(n *XFAFormNode) *xfaPageArea {
+	pa := &xfaPageArea{node: n, width: 612, height: 792, max: -1}
+	if n != nil {
+		t := n.template
+		if medium := t.child("medium"); medium != nil {
+			if size, ok := xfaMediumSizes[strings.ToLower(medium.attr("stock"))]; ok {
+				pa.width, pa.height = size[0], size[1]
+			}
+			if v, ok := xfaMeasureAttr(medium, "short"); ok {
+				pa.width = v
+			}
+			if v, ok := xfaMeasureAttr(medium, "long"); ok {
+				pa.height = v
+			}
+			if medium.attr("orientation") == "landscape" {
+				pa.width, pa.height = pa.height, pa.width
+			}
+		}
+		for _, c := range t.elements() {
+			if c.local() != "contentArea" {
+				continue
+			}
+			r := xfaRect{}
+			r.x, _ = xfaMeasureAttr(c, "x")
+			r.y, _ = xfaMeasureAttr(c, "y")
+			var ok bool
+			if r.w, ok = xfaMeasureAttr(c, "w"); !ok {
+				r.w = pa.width - r.x
+			}
+			if r.h, ok = xfaMeasureAttr(c, "h"); !ok {
+				r.h = pa.height - r.y
+			}
+			pa.areas = append(pa.areas, r)
+		}
+		if occur := t.child("occur"); occur != nil {
+			if v, err := strconv.Atoi(occur.attr("max")); err == nil {
+				pa.max = v
+			}
+		}
+	}
+	if len(pa.areas) == 0 {
+		pa.areas = []xfaRect{{18, 18, pa.width - 36, pa.height - 36}}
+	}
+	return pa
+}
+
+// matches reports whether a break target refers to the page area
+func (pa *xfaPageArea) matches(target string) bool {
+	if pa.node == nil {
+		return false
+	}
+	target = strings.TrimPrefix(target, "#")
+	if i := strings.LastIndexAny(target, ".#"); i >= 0 {
+		target = target[i+1:]
+	}
+	return target == pa.node.Name || target == pa.node.template.attr("id")
+}
+
+// xfaPaginator places laid out blocks in the content areas of pages
+type xfaPaginator struct {
+	areas   []*xfaPageArea
+	occurs  []int
+	next    int
+	pages   []*XFAPage
+	page    *XFAPage
+	pa      *xfaPageArea
+	area    int
+	y       float64 // height used in the current content area
+	pending *xfaBreak
+}
+
+// newPage starts a page using the page area named by target, or the next
+// page area in order
+func (p *xfaPaginator) newPage(target string) {
+	i := p.next
+	if target != "" {
+		for j, pa := range p.areas {
+			if pa.matches(target) {
+				i = j
+				break
+			}
+		}
+	}
+	pa := p.areas[i]
+	p.occurs[i]++
+	p.next = i
+	if pa.max >= 0 && p.occurs[i] >= pa.max && i+1 < len(p.areas) {
+		p.next = i + 1
+	}
+
+	p.page = &XFAPage{Width: pa.width, Height: pa.height}
+	p.pages = append(p.pages, p.page)
+	p.pa, p.area, p.y = pa, 0, 0
+	if pa.node != nil {
+		if b := xfaLayoutNode(pa.node, pa.width); b != nil {
+			p.emit(b, 0, 0)
+		}
+	}
+}
+
+// nextArea moves to the next content area, starting a page when the
+// current page has no more
+func (p *xfaPaginator) nextArea() {
+	if p.page != nil && p.area+1 < len(p.pa.areas) {
+		p.area++
+		p.y = 0
+		return
+	}
+	p.newPage("")
+}
+
+// breakTo honours a break unless the current content area is still empty
+func (p *xfaPaginator) breakTo(br xfaBreak) {
+	switch {
+	case p.page == nil:
+		p.newPage(br.target)
+	case br.kind == "area" && p.y > 0:
+		p.nextArea()
+	case br.kind == "page" && (p.y > 0 || br.target != "" && !p.pa.matches(br.target)):
+		p.newPage(br.target)
+	}
+}
+
+// place adds a block to the flow at horizontal offset x within the
+// content area. A flowed block that does not fit is split between its
+// children; any other block moves to the next content area.
+func (p *xfaPaginator) place(b *xfaBlock, x float64) {
+	if p.pending != nil {
+		br := *p.pending
+		p.pending = nil
+		p.breakTo(br)
+	}
+	if b.before.kind != "" {
+		p.breakTo(b.before)
+	}
+	if p.page == nil {
+		p.newPage("")
+	}
+
+	r := p.pa.areas[p.area]
+	if p.y+b.h > r.h+0.01 && b.flowed && len(b.kids) > 0 {
+		top := 0.0
+		for _, k := range b.kids {
+			p.y += math.Max(k.y-top, 0)
+			top = k.y + k.h
+			p.place(k, x+b.x)
+		}
+		p.y += math.Max(b.h-top, 0)
+	} else {
+		if p.y > 0 && p.y+b.h > r.h+0.01 {
+			p.nextArea()
+			r = p.pa.areas[p.area]
+		}
+		p.emit(b, r.x+x+b.x, r.y+p.y)
+		p.y += b.h
+	}
+	if b.after.kind != "" {
+		br := b.after
+		p.pending = &br
+	}
+}
+
+// emit adds the operations and widgets of a block with its origin at x, y
+func (p *xfaPaginator) emit(b *xfaBlock, x, y float64) {
+	for _, op := range b.ops {
+		p.page.Ops = append(p.page.Ops, op.translate(x, y))
+	}
+	for _, w := range b.widgets {
+		w.X += x
+		w.Y += y
+		p.page.Widgets = append(p.page.Widgets, w)
+	}
+	for _, k := range b.kids {
+		p.emit(k, x+k.x, y+k.y)
+	}
+}
+
+// ContentStream converts the page's operations to a PDF content stream in
+// default user space and returns it with the resources it uses: standard
+// Type 1 fonts and image XObjects
+func (p *XFAPage) ContentStream() ([]byte, Dictionary) {
+	var buf bytes.Buffer
+	fonts := Dictionary{}
+	fontNames := make(map[string]string)
+	xobjects := Dictionary{}
+	y := func(v float64) float64 { return p.Height - v }
+
+	for _, op := range p.Ops {
+		fill, stroke := xfaColorOp(op.Fill, false), xfaColorOp(op.Stroke, true)
+		if op.LineWidth <= 0 {
+			stroke = ""
+		}
+		paint := func(closed bool) string {
+			if stroke != "" {
+				fmt.Fprintf(&buf, "%s w %s\n", formatNum(op.LineWidth), stroke)
+			}
+			if fill != "" && closed {
+				buf.WriteString(fill + "\n")
+			}
+			switch {
+			case stroke != "" && fill != "" && closed:
+				return "B"
+			case stroke != "":
+				return "S"
+			case fill != "" && closed:
+				return "f"
+			}
+			return "n"
+		}
+
+		switch op.Kind {
+		case XFADrawRect:
+			if fill == "" && stroke == "" {
+				continue
+			}
+			buf.WriteString("q\n")
+			o := paint(true)
+			fmt.Fprintf(&buf, "%s %s %s %s re %s\nQ\n", formatNum(op.X), formatNum(y(op.Y+op.H)),
+				formatNum(op.W), formatNum(op.H), o)
+		case XFADrawLine:
+			if stroke == "" {
+				continue
+			}
+			buf.WriteString("q\n")
+			o := paint(false)
+			fmt.Fprintf(&buf, "%s %s m %s %s l %s\nQ\n", formatNum(op.X), formatNum(y(op.Y)),
+				formatNum(op.X+op.W), formatNum(y(op.Y+op.H)), o)
+		case XFADrawEllipse:
+			if fill == "" && stroke == "" {
+				continue
+			}
+			buf.WriteString("q\n")
+			o := paint(true)
+			writeEllipse(&buf, op.X+op.W/2, y(op.Y+op.H/2), op.W/2, op.H/2)
+			buf.WriteString(o + "\nQ\n")
+		case XFADrawPath:
+			if len(op.Points) < 4 || fill == "" && stroke == "" {
+				continue
+			}
+			buf.WriteString("q\n1 J 1 j\n")
+			o := paint(op.Closed)
+			for i := 0; i+1 < len(op.Points); i += 2 {
+				verb := "l"
+				if i == 0 {
+					verb = "m"
+				}
+				fmt.Fprintf(&buf, "%s %s %s\n", formatNum(op.Points[i]), formatNum(y(op.Points[i+1])), verb)
+			}
+			if op.Closed {
+				buf.WriteString("h\n")
+			}
+			buf.WriteString(o + "\nQ\n")
+		case XFADrawText:
+			base := xfaBaseFont(op.Font)
+			name, ok := fontNames[base]
+			if !ok {
+				name = "XF" + strconv.Itoa(len(fontNames)+1)
+				fontNames[base] = name
+				fonts[Name(name)] = Dictionary{
+					"Type":     Name("Font"),
+					"Subtype":  Name("Type1"),
+					"BaseFont": Name(base),
+					"Encoding": Name("WinAnsiEncoding"),
+				}
+			}
+			if fill == "" {
+				fill = "0 g"
+			}
+			fmt.Fprintf(&buf, "BT\n/%s %s Tf\n%s\n%s %s Td\n%s Tj\nET\n", name, formatNum(op.Font.Size), fill,
+				formatNum(op.X), formatNum(y(op.Y)), contentString(encodeWinAnsi(op.Text)))
+		case XFADrawImage:
+			if op.Image == nil {
+				continue
+			}
+			name := "XIm" + strconv.Itoa(len(xobjects)+1)
+			xobjects[Name(name)] = xfaImageXObject(op.Image)
+			fmt.Fprintf(&buf, "q\n%s 0 0 %s %s %s cm\n/%s Do\nQ\n", formatNum(op.W), formatNum(op.H),
+				formatNum(op.X), formatNum(y(op.Y+op.H)), name)
+		}
+	}
+
+	resources := Dictionary{}
+	if len(fonts) > 0 {
+		resources["Font"] = fonts
+	}
+	if len(xobjects) > 0 {
+		resources["XObject"] = xobjects
+	}
+	return buf.Bytes(), resources
+}
+
+// xfaColorOp returns the operator setting an RGB colour, or "" for nil
+func xfaColorOp(c color.Color, stroke bool) string {
+	if c == nil {
+		return ""
+	}
+	r, g, b, _ := c.RGBA()
+	op := "rg"
+	if stroke {
+		op = "RG"
+	}
+	return fmt.Sprintf("%s %s %s %s", formatNum(float64(r)/0xffff), formatNum(float64(g)/0xffff),
+		formatNum(float64(b)/0xffff), op)
+}
+
+// xfaImageXObject converts an image to an 8-bit RGB image XObject
+func xfaImageXObject(img image.Image) Stream {
+	b := img.Bounds()
+	data := make([]byte, 0, b.Dx()*b.Dy()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			// Composite transparent pixels over white
+			a := int(c.A)
+			data = append(data,
+				byte((int(c.R)*a+255*(255-a))/255),
+				byte((int(c.G)*a+255*(255-a))/255),
+				byte((int(c.B)*a+255*(255-a))/255))
+		}
+	}
+	return newFlateStream(Dictionary{
+		"Type":             Name("XObject"),
+		"Subtype":          Name("Image"),
+		"Width":            Integer(b.Dx()),
+		"Height":           Integer(b.Dy()),
+		"ColorSpace":       Name("DeviceRGB"),
+		"BitsPerComponent": Integer(8),
+	}, data)
+}
+
+// needsXFARendering reports whether the document's pages have to be
+// generated from its XFA form: the catalog sets NeedsRendering, or the
+// form carries XFA and the PDF pages have no content of their own
+func (d *Document) needsXFARendering() bool {
+	form := d.acroForm()
+	if form == nil || form.Get("XFA") == nil {
+		return false
+	}
+	if v, ok := d.Root.Get("NeedsRendering").(Boolean); ok && bool(v) {
+		return true
+	}
+	for i := 1; i <= d.NumPages(); i++ {
+		page, err := d.GetPage(i)
+		if err != nil {
+			continue
+		}
+		if contents, _ := page.GetContents(); len(bytes.TrimSpace(contents)) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package pdf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxCalculatePasses bounds how often calculations are repeated while
+// values still change, which also stops calculations depending on each
+// other in a cycle
+const maxCalculatePasses = 10
+
+// XFAValidationError reports a field whose value fails its validation
+type XFAValidationError struct {
+	Field   *XFAFormNode
+	Message string
+}
+
+func (e *XFAValidationError) Error() string {
+	return fmt.Sprintf("xfa: validation of %s failed: %s", e.Field.SOM(), e.Message)
+}
+
+// SetScriptLimits sets the resource limits of the scripts run by
+// ExecuteFormCalc, Calculate and Validate
+func (xfa *XFAForm) SetScriptLimits(limits JSLimits) {
+	xfa.scriptLimits = &limits
+}
+
+func (xfa *XFAForm) limits() JSLimits {
+	if xfa.scriptLimits != nil {
+		return *xfa.scriptLimits
+	}
+	return DefaultJSLimits
+}
+
+// ExecuteFormCalc runs a FormCalc script with context as the current
+// object ($) and returns the value of its last expression. A nil context
+// runs the script relative to the root subform.
+func (xfa *XFAForm) ExecuteFormCalc(script string, context *XFAFormNode) (string, error) {
+	v, err := newFCInterp(xfa, xfa.limits()).run(script, context)
+	if err != nil {
+		return "", err
+	}
+	return fcStr(v), nil
+}
+
+// xfaScriptOf returns the FormCalc script of a calculate or validate
+// element of a container. Scripts in other languages are skipped.
+func xfaScriptOf(n *XFAFormNode, element string) string {
+	if n.template == nil {
+		return ""
+	}
+	e := n.template.child(element)
+	if e == nil {
+		return ""
+	}
+	s := e.child("script")
+	if s == nil {
+		return ""
+	}
+	switch strings.ToLower(s.attr("contentType")) {
+	case "", "application/x-formcalc":
+		return s.text()
+	}
+	return ""
+}
+
+// Calculate runs the calculate scripts of the form and stores their
+// results as the values of their fields. Calculations are repeated until
+// the values settle, so that totals depending on other calculated fields
+// come out right regardless of document order.
+func (xfa *XFAForm) Calculate() error {
+	if xfa.Form == nil {
+		return nil
+	}
+	var nodes []*XFAFormNode
+	xfa.Form.walk(func(n *XFAFormNode) {
+		if xfaScriptOf(n, "calculate") != "" {
+			nodes = append(nodes, n)
+		}
+	})
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	in := newFCInterp(xfa, xfa.limits())
+	for pass := 0; pass < maxCalculatePasses; pass++ {
+		changed := false
+		for _, n := range nodes {
+			v, err := in.run(xfaScriptOf(n, "calculate"), n)
+			if err != nil {
+				return fmt.Errorf("xfa: calculate script of %s: %w", n.SOM(), err)
+			}
+			value := fcStr(v)
+			if value == n.Value {
+				continue
+			}
+			changed = true
+			switch n.Class {
+			case "field", "exclGroup":
+				xfa.setNodeValue(n, value)
+			default:
+				n.Value = value
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return nil
+}
+
+// Validate checks the fields of the form against their null tests and
+// validation scripts and returns the first failure as an
+// *XFAValidationError
+func (xfa *XFAForm) Validate() error {
+	if xfa.Form == nil {
+		return nil
+	}
+	var err error
+	xfa.Form.walk(func(n *XFAFormNode) {
+		if err == nil && (n.Class == "field" || n.Class == "exclGroup") {
+			err = xfa.validateNode(n)
+		}
+	})
+	return err
+}
+
+// validateNode checks one field or exclGroup. Fields that are inactive
+// or belong to an exclGroup are not validated on their own.
+func (xfa *XFAForm) validateNode(n *XFAFormNode) error {
+	if n.template == nil || n.template.attr("presence") == "inactive" {
+		return nil
+	}
+	if n.Class == "field" && n.Parent != nil && n.Parent.Class == "exclGroup" {
+		return nil
+	}
+	v := n.template.child("validate")
+	if v == nil {
+		return nil
+	}
+
+	if v.attr("nullTest") == "error" && strings.TrimSpace(n.Value) == "" {
+		msg := xfaValidateMessage(v, "nullTest")
+		if msg == "" {
+			msg = "a value is required"
+		}
+		return &XFAValidationError{Field: n, Message: msg}
+	}
+
+	script := xfaScriptOf(n, "validate")
+	if script == "" || v.attr("scriptTest") == "disabled" {
+		return nil
+	}
+	in := newFCInterp(xfa, xfa.limits())
+	result, err := in.run(script, n)
+	if err != nil {
+		return fmt.Errorf("xfa: validate script of %s: %w", n.SOM(), err)
+	}
+	if !in.truth(result) {
+		msg := xfaValidateMessage(v, "scriptTest")
+		if msg == "" {
+			msg = "the value is invalid"
+		}
+		return &XFAValidationError{Field: n, Message: msg}
+	}
+	return nil
+}
+
+// xfaValidateMessage returns the message text of a validate element for
+// a test
+func xfaValidateMessage(v *xfaNode, test string) string {
+	m := v.child("message")
+	if m == nil {
+		return ""
+	}
+	for _, t := range m.elements() {
+		if t.local() == "text" && t.attr("name") == test {
+			return strings.TrimSpace(t.text())
+		}
+	}
+	return ""
+}
+
+// setFieldChecked sets the value of a field, recalculates the form and
+// validates the field. A value failing validation is rejected: the
+// previous value is restored and the error returned.
+func (xfa *XFAForm) setFieldChecked(n *XFAFormNode, value string) error {
+	target := n
+	if n.Class == "field" && n.Parent != nil && n.Parent.Class == "exclGroup" {
+		target = n.Parent
+	}
+	old := target.Value
+
+	xfa.setNodeValue(n, value)
+	err := xfa.Calculate()
+	if err == nil {
+		err = xfa.validateNode(target)
+	}
+	if err != nil {
+		xfa.setNodeValue(target, old)
+		xfa.Calculate()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// TestAnnotationEditor tests adding, updating and deleting annotations
+func TestAnnotationEditor(t *testing.T) {
+	doc, err := pdf.NewDocument(createMinimalPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	editor := pdf.NewAnnotationEditor(doc)
+	highlight := &pdf.Annotation{
+		Type:       pdf.AnnotHighlight,
+		QuadPoints: []float64{72, 720, 300, 720, 72, 700, 300, 700},
+		Color:      []float64{1, 1, 0},
+		Contents:   "Important",
+	}
+	note := &pdf.Annotation{
+		Type:     pdf.AnnotText,
+		Rect:     pdf.AnnotRect{LLX: 400, LLY: 700, URX: 420, URY: 720},
+		Contents: "Please review",
+		Title:    "Reviewer",
+	}
+	link := &pdf.Annotation{
+		Type:   pdf.AnnotLink,
+		Rect:   pdf.AnnotRect{LLX: 72, LLY: 100, URX: 200, URY: 120},
+		Action: &pdf.Action{S: "URI", URI: "https://example.com/"},
+	}
+	for _, annot := range []*pdf.Annotation{highlight, note, link} {
+		if err := editor.AddAnnotation(1, annot); err != nil {
+			t.Fatalf("AddAnnotation failed: %v", err)
+		}
+	}
+	data, err := editor.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	updated, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen document: %v", err)
+	}
+	annots, err := pdf.NewAnnotationExtractor(updated).GetPageAnnotations(1)
+	if err != nil {
+		t.Fatalf("GetPageAnnotations failed: %v", err)
+	}
+
+	// highlight, note, popup and link
+	if len(annots) != 4 {
+		t.Fatalf("Expected 4 annotations, got %d", len(annots))
+	}
+	for _, annot := range annots {
+		if annot.Subtype != "Popup" && annot.AP == nil {
+			t.Errorf("%s annotation should have an appearance", annot.Subtype)
+		}
+	}
+	if annots[0].Rect.LLX != 72 || annots[0].Rect.URY != 720 {
+		t.Errorf("Highlight rectangle not derived from QuadPoints: %+v", annots[0].Rect)
+	}
+	if annots[3].Action == nil || annots[3].Action.URI != "https://example.com/" {
+		t.Errorf("Link action not written: %+v", annots[3].Action)
+	}
+
+	// Update the note and delete the highlight in a second revision
+	editor = pdf.NewAnnotationEditor(updated)
+	annots[1].Contents = "Reviewed"
+	if err := editor.UpdateAnnotation(annots[1]); err != nil {
+		t.Fatalf("UpdateAnnotation failed: %v", err)
+	}
+	if err := editor.DeleteAnnotation(annots[0]); err != nil {
+		t.Fatalf("DeleteAnnotation failed: %v", err)
+	}
+	data, err = editor.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	final, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen document: %v", err)
+	}
+	annots, err = pdf.NewAnnotationExtractor(final).GetPageAnnotations(1)
+	if err != nil {
+		t.Fatalf("GetPageAnnotations failed: %v", err)
+	}
+	if len(annots) != 3 || annots[0].Subtype != "Text" || annots[0].Contents != "Reviewed" {
+		t.Fatalf("Unexpected annotations after update: %d", len(annots))
+	}
+}
+
+// TestRenderAnnotations tests drawing annotation appearances in PageRenderer
+func TestRenderAnnotations(t *testing.T) {
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 200] /Annots [4 0 R 5 0 R 6 0 R] >>",
+		"<< /Type /Annot /Subtype /Square /F 4 /Rect [20 20 80 80] /AP << /N 7 0 R >> >>",
+		"<< /Type /Annot /Subtype /Square /F 0 /Rect [120 120 180 180] /AP << /N 8 0 R >> >>",
+		"<< /Type /Annot /Subtype /Square /F 6 /Rect [120 20 180 80] /AP << /N 7 0 R >> >>",
+		"<< /Type /XObject /Subtype /Form /BBox [0 0 10 10] /Length 23 >>\nstream\n1 0 0 rg 0 0 10 10 re f\nendstream",
+		"<< /Type /XObject /Subtype /Form /BBox [0 0 10 10] /Length 23 >>\nstream\n0 0 1 rg 0 0 10 10 re f\nendstream",
+	})
+	doc, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	render := func(mode pdf.AnnotationMode) image.Image {
+		renderer := pdf.NewPageRenderer(doc, pdf.RenderOptions{DPI: 72, Annotations: mode})
+		page, err := renderer.RenderPage(1)
+		if err != nil {
+			t.Fatalf("RenderPage failed: %v", err)
+		}
+		img, err := png.Decode(bytes.NewReader(page.Data))
+		if err != nil {
+			t.Fatalf("Failed to decode rendered page: %v", err)
+		}
+		return img
+	}
+	rgb := func(img image.Image, x, y int) [3]uint32 {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return [3]uint32{r >> 8, g >> 8, b >> 8}
+	}
+	white, red, blue := [3]uint32{255, 255, 255}, [3]uint32{255, 0, 0}, [3]uint32{0, 0, 255}
+
+	tests := []struct {
+		mode           pdf.AnnotationMode
+		print, noPrint [3]uint32
+	}{
+		{pdf.AnnotationsDisplay, red, blue},
+		{pdf.AnnotationsPrint, red, white},
+		{pdf.AnnotationsNone, white, white},
+	}
+	for _, tt := range tests {
+		img := render(tt.mode)
+		if got := rgb(img, 50, 150); got != tt.print {
+			t.Errorf("mode %d: printable annotation pixel = %v, want %v", tt.mode, got, tt.print)
+		}
+		if got := rgb(img, 150, 50); got != tt.noPrint {
+			t.Errorf("mode %d: screen-only annotation pixel = %v, want %v", tt.mode, got, tt.noPrint)
+		}
+		if got := rgb(img, 150, 150); got != white {
+			t.Errorf("mode %d: hidden annotation should not be drawn, got %v", tt.mode, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// TestAttachmentEditor tests adding, replacing and removing embedded files
+func TestAttachmentEditor(t *testing.T) {
+	doc, err := pdf.NewDocument(createMinimalPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	invoice := []byte(`<?xml version="1.0" encoding="UTF-8"?><rsm:CrossIndustryInvoice/>`)
+	editor := pdf.NewAttachmentEditor(doc)
+	err = editor.AddAttachment("factur-x.xml", invoice, &pdf.AttachmentOptions{
+		Description:  "Factur-X invoice",
+		MimeType:     "text/xml",
+		Relationship: pdf.RelationshipAlternative,
+	})
+	if err != nil {
+		t.Fatalf("AddAttachment failed: %v", err)
+	}
+	if err := editor.AddAttachment("notes.txt", []byte("notes"), nil); err != nil {
+		t.Fatalf("AddAttachment failed: %v", err)
+	}
+	if err := editor.AddAttachment("notes.txt", []byte("again"), nil); err == nil {
+		t.Error("expected an error when adding a duplicate name")
+	}
+
+	data, err := editor.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	doc, err = pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen document: %v", err)
+	}
+	atts, err := pdf.GetAttachments(doc)
+	if err != nil {
+		t.Fatalf("GetAttachments failed: %v", err)
+	}
+	if len(atts) != 2 || atts[0].Name != "factur-x.xml" || atts[1].Name != "notes.txt" {
+		t.Fatalf("attachments = %v, want factur-x.xml and notes.txt in order", atts)
+	}
+	xml := atts[0]
+	if xml.Description != "Factur-X invoice" || xml.MimeType != "text/xml" || xml.Relationship != pdf.RelationshipAlternative {
+		t.Errorf("attachment = %+v", xml)
+	}
+	sum := md5.Sum(invoice)
+	if xml.Size != int64(len(invoice)) || !bytes.Equal(xml.CheckSum, sum[:]) || xml.ModDate.IsZero() {
+		t.Errorf("params: size %d, checksum %x, mod date %v", xml.Size, xml.CheckSum, xml.ModDate)
+	}
+	if atts[1].Relationship != pdf.RelationshipUnspecified {
+		t.Errorf("default relationship = %q", atts[1].Relationship)
+	}
+	if af, ok := doc.Root.Get("AF").(pdf.Array); !ok || len(af) != 2 {
+		t.Errorf("catalog AF = %v, want two file specifications", doc.Root.Get("AF"))
+	}
+
+	dir := t.TempDir()
+	if err := xml.SaveTo(dir); err != nil {
+		t.Fatalf("SaveTo failed: %v", err)
+	}
+	if saved, _ := os.ReadFile(filepath.Join(dir, "factur-x.xml")); !bytes.Equal(saved, invoice) {
+		t.Errorf("saved data = %q", saved)
+	}
+
+	// Replacing keeps the options of the old file; removing drops the
+	// association as well
+	editor = pdf.NewAttachmentEditor(doc)
+	updated := []byte(`<rsm:CrossIndustryInvoice version="2"/>`)
+	if err := editor.ReplaceAttachment("factur-x.xml", updated, nil); err != nil {
+		t.Fatalf("ReplaceAttachment failed: %v", err)
+	}
+	if err := editor.RemoveAttachment("notes.txt"); err != nil {
+		t.Fatalf("RemoveAttachment failed: %v", err)
+	}
+	if err := editor.RemoveAttachment("missing.txt"); err == nil {
+		t.Error("expected an error when removing a missing attachment")
+	}
+	data, err = editor.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	doc, err = pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen document: %v", err)
+	}
+	atts, _ = pdf.GetAttachments(doc)
+	if len(atts) != 1 {
+		t.Fatalf("got %d attachments after removal, want 1", len(atts))
+	}
+	if atts[0].Relationship != pdf.RelationshipAlternative || atts[0].Description != "Factur-X invoice" || atts[0].Size != int64(len(updated)) {
+		t.Errorf("replaced attachment = %+v", atts[0])
+	}
+	if af, ok := doc.Root.Get("AF").(pdf.Array); !ok || len(af) != 1 {
+		t.Errorf("catalog AF = %v, want one file specification", doc.Root.Get("AF"))
+	}
+
+	// The package-level helpers used by pdfattach
+	out := filepath.Join(dir, "out.pdf")
+	if err := pdf.AddAttachment(doc, "/tmp/data.csv", []byte("a,b\n")); err != nil {
+		t.Fatalf("AddAttachment failed: %v", err)
+	}
+	if err := pdf.WriteToFile(doc, out); err != nil {
+		t.Fatalf("WriteToFile failed: %v", err)
+	}
+	doc, err = pdf.Open(out)
+	if err != nil {
+		t.Fatalf("Failed to open output: %v", err)
+	}
+	defer doc.Close()
+	if atts, _ = pdf.GetAttachments(doc); len(atts) != 2 || atts[0].Name != "data.csv" {
+		t.Errorf("attachments after WriteToFile = %v", atts)
+	}
+}
@@ -0,0 +1,173 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// bilevelPage draws a scan-like page as packed samples, 0 meaning black:
+// a frame larger than any symbol, rows of repeated glyphs and a few
+// irregular marks
+func bilevelPage(w, h int) []byte {
+	stride := (w + 7) / 8
+	data := bytes.Repeat([]byte{0xFF}, stride*h)
+	black := func(x, y int) {
+		if x >= 0 && y >= 0 && x < w && y < h {
+			data[y*stride+x/8] &^= 0x80 >> (x % 8)
+		}
+	}
+	for x := 2; x < w-2; x++ {
+		black(x, 2)
+		black(x, h-3)
+	}
+	for y := 2; y < h-2; y++ {
+		black(2, y)
+		black(w-3, y)
+	}
+	glyphs := [][]string{
+		{".##.", "#..#", "####", "#..#", "#..#"},
+		{"###.", "#..#", "###.", "#..#", "###."},
+		{"#", "#", "#", ".", "#"},
+	}
+	for row := 0; row*14+8 < h-4; row++ {
+		x := 6 + row%3
+		for i := 0; x+6 < w-4; i++ {
+			g := glyphs[(i*7+row)%len(glyphs)]
+			for gy, line := range g {
+				for gx, c := range line {
+					if c == '#' {
+						black(x+gx, 6+row*14+gy)
+					}
+				}
+			}
+			x += len(g[0]) + 3 + (i % 2)
+		}
+	}
+	for i := 0; i < 40; i++ {
+		black((i*37)%w, (i*53)%h)
+	}
+	return data
+}
+
+// maskPadding clears the padding bits at the end of each row
+func maskPadding(data []byte, w, h int) []byte {
+	stride := (w + 7) / 8
+	out := append([]byte(nil), data[:stride*h]...)
+	if pad := stride*8 - w; pad > 0 {
+		for y := 0; y < h; y++ {
+			out[(y+1)*stride-1] &= 0xFF << pad
+		}
+	}
+	return out
+}
+
+func TestCCITTG4RoundTrip(t *testing.T) {
+	sizes := [][2]int{{61, 47}, {200, 90}, {3000, 12}}
+	for _, size := range sizes {
+		w, h := size[0], size[1]
+		page := bilevelPage(w, h)
+		enc, params, err := pdf.EncodeCCITTFax(page, w, h)
+		if err != nil {
+			t.Fatalf("%dx%d: EncodeCCITTFax failed: %v", w, h, err)
+		}
+		dec, err := pdf.DecodeCCITTFax(enc, params)
+		if err != nil {
+			t.Fatalf("%dx%d: DecodeCCITTFax failed: %v", w, h, err)
+		}
+		if len(dec) < len(page) {
+			t.Fatalf("%dx%d: decoded %d bytes, want %d", w, h, len(dec), len(page))
+		}
+		if !bytes.Equal(maskPadding(dec, w, h), maskPadding(page, w, h)) {
+			t.Errorf("%dx%d: CCITT round trip mismatch", w, h)
+		}
+	}
+}
+
+func TestJBIG2EncodeRoundTrip(t *testing.T) {
+	w, h := 301, 170
+	page := bilevelPage(w, h)
+	want := maskPadding(page, w, h)
+	for i := range want {
+		want[i] = ^want[i]
+	}
+	want = maskPadding(want, w, h)
+
+	for _, symbols := range []bool{false, true} {
+		enc := &pdf.JBIG2Encoder{Symbols: symbols}
+		data, err := enc.Encode(page, w, h)
+		if err != nil {
+			t.Fatalf("symbols=%v: Encode failed: %v", symbols, err)
+		}
+		got, gw, gh, err := pdf.JBIG2Decode(data, pdf.Dictionary{})
+		if err != nil {
+			t.Fatalf("symbols=%v: JBIG2Decode failed: %v", symbols, err)
+		}
+		if gw != w || gh != h {
+			t.Fatalf("symbols=%v: size %dx%d, want %dx%d", symbols, gw, gh, w, h)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("symbols=%v: JBIG2 round trip mismatch", symbols)
+		}
+	}
+}
+
+func TestRecompressBilevelImages(t *testing.T) {
+	w, h := 240, 160
+	page := bilevelPage(w, h)
+	content := "q 240 0 0 160 0 0 cm /Im1 Do Q"
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 240 160] /Contents 4 0 R /Resources << /XObject << /Im1 5 0 R >> >> >>",
+		"<< /Length " + formatInt(len(content)) + " >>\nstream\n" + content + "\nendstream",
+		"<< /Type /XObject /Subtype /Image /Width 240 /Height 160 /ColorSpace /DeviceGray /BitsPerComponent 1 /Length " +
+			formatInt(len(page)) + " >>\nstream\n" + string(page) + "\nendstream",
+	})
+
+	for _, method := range []pdf.BilevelCompression{pdf.BilevelCCITT, pdf.BilevelJBIG2, pdf.BilevelJBIG2Symbols, pdf.BilevelAuto} {
+		doc, err := pdf.NewDocument(data)
+		if err != nil {
+			t.Fatalf("NewDocument failed: %v", err)
+		}
+		writer := pdf.NewIncrementalWriter(doc)
+		res, err := pdf.RecompressBilevelImages(writer, pdf.RecompressOptions{Method: method})
+		if err != nil {
+			t.Fatalf("%v: RecompressBilevelImages failed: %v", method, err)
+		}
+		if res.Images != 1 || res.Recompressed != 1 || res.NewBytes >= res.OriginalBytes {
+			t.Fatalf("%v: result %+v", method, res)
+		}
+		out, err := writer.Rewrite()
+		if err != nil {
+			t.Fatalf("%v: Rewrite failed: %v", method, err)
+		}
+		if len(out) >= len(data) {
+			t.Errorf("%v: rewritten file has %d bytes, original %d", method, len(out), len(data))
+		}
+
+		doc2, err := pdf.NewDocument(out)
+		if err != nil {
+			t.Fatalf("%v: reopening failed: %v", method, err)
+		}
+		obj, err := doc2.GetObject(5)
+		if err != nil {
+			t.Fatalf("%v: GetObject failed: %v", method, err)
+		}
+		stream, ok := obj.(pdf.Stream)
+		if !ok {
+			t.Fatalf("%v: object 5 is %T", method, obj)
+		}
+		samples, err := stream.Decode()
+		if err != nil {
+			t.Fatalf("%v: Decode failed: %v", method, err)
+		}
+		if !bytes.Equal(maskPadding(samples, w, h), maskPadding(page, w, h)) {
+			t.Errorf("%v: recompressed samples differ", method)
+		}
+		if doc2.NumPages() != 1 {
+			t.Errorf("%v: %d pages after rewrite", method, doc2.NumPages())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// TestCCITTDecodeExternal decodes fax streams written by other encoders and
+// compares every pixel with the source image
+func TestCCITTDecodeExternal(t *testing.T) {
+	dir := filepath.Join("testdata", "ccitt")
+	f, err := os.Open(filepath.Join(dir, "bw-gopher.png"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	const w, h = 153, 55
+
+	for _, c := range []struct {
+		file    string
+		k       int
+		eol     bool
+		aligned bool
+	}{
+		{"bw-gopher.ccitt_group4", -1, false, false},
+		{"bw-gopher-aligned.ccitt_group4", -1, false, true},
+		{"bw-gopher.ccitt_group3", 0, true, false},
+		{"bw-gopher.ccitt_group3_2d", 4, true, false},
+		{"bw-gopher-aligned.ccitt_group3_2d", 4, true, true},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, c.file))
+		if err != nil {
+			t.Fatalf("read %s: %v", c.file, err)
+		}
+		got, err := pdf.DecodeCCITTFax(data, pdf.Dictionary{
+			"K":                pdf.Integer(c.k),
+			"Columns":          pdf.Integer(w),
+			"Rows":             pdf.Integer(h),
+			"EndOfLine":        pdf.Boolean(c.eol),
+			"EncodedByteAlign": pdf.Boolean(c.aligned),
+		})
+		if err != nil {
+			t.Errorf("%s: %v", c.file, err)
+			continue
+		}
+		stride := (w + 7) / 8
+		if len(got) != stride*h {
+			t.Errorf("%s: decoded %d bytes, want %d", c.file, len(got), stride*h)
+			continue
+		}
+
+		// Without /BlackIs1 a set bit is white
+		bad := 0
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, _, _, _ := want.At(x, y).RGBA()
+				white := got[y*stride+x/8]>>(7-x%8)&1 == 1
+				if white != (r > 0x8000) {
+					if bad == 0 {
+						t.Errorf("%s: first wrong pixel at (%d, %d)", c.file, x, y)
+					}
+					bad++
+				}
+			}
+		}
+		if bad > 0 {
+			t.Errorf("%s: %d of %d pixels differ", c.file, bad, w*h)
+		}
+	}
+}
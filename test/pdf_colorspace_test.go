@@ -0,0 +1,367 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// iccProfile assembles an ICC profile from tag data
+func iccProfile(colorSpace, pcs string, tags map[string][]byte) []byte {
+	sigs := make([]string, 0, len(tags))
+	for sig := range tags {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	header := make([]byte, 132+12*len(sigs))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntr")
+	copy(header[16:], colorSpace)
+	copy(header[20:], pcs)
+	copy(header[36:], "acsp")
+	binary.BigEndian.PutUint32(header[128:], uint32(len(sigs)))
+
+	data := header
+	for i, sig := range sigs {
+		entry := data[132+12*i:]
+		copy(entry, sig)
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(data)))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(tags[sig])))
+		data = append(data, tags[sig]...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	return data
+}
+
+func iccXYZ(x, y, z float64) []byte {
+	b := append([]byte("XYZ "), 0, 0, 0, 0)
+	for _, v := range []float64{x, y, z} {
+		b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+	}
+	return b
+}
+
+// linearRGBProfile is a matrix/TRC profile with sRGB primaries and linear
+// tone curves
+func linearRGBProfile() []byte {
+	linear := append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 0)
+	return iccProfile("RGB ", "XYZ ", map[string][]byte{
+		"rXYZ": iccXYZ(0.4361, 0.2225, 0.0139),
+		"gXYZ": iccXYZ(0.3851, 0.7169, 0.0971),
+		"bXYZ": iccXYZ(0.1431, 0.0606, 0.7141),
+		"rTRC": linear,
+		"gTRC": linear,
+		"bTRC": linear,
+	})
+}
+
+// cmykLabProfile is a lut16 CMYK profile whose output is white, fading
+// linearly to black with the K channel
+func cmykLabProfile() []byte {
+	lut := append([]byte("mft2"), 0, 0, 0, 0, 4, 3, 2, 0)
+	for i := 0; i < 9; i++ {
+		v := uint32(0)
+		if i%4 == 0 {
+			v = 0x10000
+		}
+		lut = binary.BigEndian.AppendUint32(lut, v)
+	}
+	lut = binary.BigEndian.AppendUint16(lut, 2)
+	lut = binary.BigEndian.AppendUint16(lut, 2)
+	for i := 0; i < 4; i++ {
+		lut = binary.BigEndian.AppendUint16(lut, 0)
+		lut = binary.BigEndian.AppendUint16(lut, 0xFFFF)
+	}
+	for p := 0; p < 16; p++ {
+		l := uint16(0xFF00)
+		if p&1 != 0 {
+			l = 0
+		}
+		lut = binary.BigEndian.AppendUint16(lut, l)
+		lut = binary.BigEndian.AppendUint16(lut, 0x8000)
+		lut = binary.BigEndian.AppendUint16(lut, 0x8000)
+	}
+	for i := 0; i < 3; i++ {
+		lut = binary.BigEndian.AppendUint16(lut, 0)
+		lut = binary.BigEndian.AppendUint16(lut, 0xFFFF)
+	}
+	return iccProfile("CMYK", "Lab ", map[string][]byte{"A2B0": lut})
+}
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func nearRGB(got, want [3]float64, tolerance float64) bool {
+	return near(got[0], want[0], tolerance) && near(got[1], want[1], tolerance) && near(got[2], want[2], tolerance)
+}
+
+func TestICCProfiles(t *testing.T) {
+	rgb, err := pdf.ParseICCProfile(linearRGBProfile())
+	if err != nil {
+		t.Fatalf("ParseICCProfile(RGB) failed: %v", err)
+	}
+	if rgb.Channels != 3 {
+		t.Fatalf("RGB profile has %d channels", rgb.Channels)
+	}
+	tests := []struct {
+		in   []float64
+		want [3]float64
+	}{
+		{[]float64{0.5, 0.5, 0.5}, [3]float64{0.735, 0.735, 0.735}},
+		{[]float64{1, 0, 0}, [3]float64{1, 0, 0}},
+		{[]float64{0, 0, 1}, [3]float64{0, 0, 1}},
+	}
+	for _, tt := range tests {
+		r, g, b := rgb.ToRGB(tt.in)
+		if got := [3]float64{r, g, b}; !nearRGB(got, tt.want, 0.02) {
+			t.Errorf("RGB profile %v = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	// Zeroed colourant tags, as some JP2 writers leave them, give no
+	// usable transform
+	linear := append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 0)
+	flat := iccProfile("RGB ", "XYZ ", map[string][]byte{
+		"rXYZ": iccXYZ(0, 0, 0),
+		"gXYZ": iccXYZ(0.3851, 0.7169, 0.0971),
+		"bXYZ": iccXYZ(0, 0, 0),
+		"rTRC": linear,
+		"gTRC": linear,
+		"bTRC": linear,
+	})
+	if _, err := pdf.ParseICCProfile(flat); err == nil {
+		t.Error("ParseICCProfile accepted a singular colourant matrix")
+	}
+
+	cmyk, err := pdf.ParseICCProfile(cmykLabProfile())
+	if err != nil {
+		t.Fatalf("ParseICCProfile(CMYK) failed: %v", err)
+	}
+	for _, tt := range []struct {
+		k, want float64
+	}{{0, 1}, {1, 0}, {0.5, 0.466}} {
+		r, g, b := cmyk.ToRGB([]float64{0.3, 0.2, 0.1, tt.k})
+		if got := [3]float64{r, g, b}; !nearRGB(got, [3]float64{tt.want, tt.want, tt.want}, 0.02) {
+			t.Errorf("CMYK profile K=%v = %v, want gray %v", tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	doc, err := pdf.NewDocument(buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [] /Count 0 >>",
+	}))
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	exp := pdf.Dictionary{
+		"FunctionType": pdf.Integer(2),
+		"Domain":       pdf.Array{pdf.Integer(0), pdf.Integer(1)},
+		"C0":           pdf.Array{pdf.Real(0), pdf.Real(1)},
+		"C1":           pdf.Array{pdf.Real(1), pdf.Real(0)},
+		"N":            pdf.Integer(2),
+	}
+	f, err := doc.ParseFunction(exp)
+	if err != nil {
+		t.Fatalf("ParseFunction(Type 2) failed: %v", err)
+	}
+	if out := f.Evaluate([]float64{0.5}); len(out) != 2 || !near(out[0], 0.25, 1e-9) || !near(out[1], 0.75, 1e-9) {
+		t.Errorf("Type 2 at 0.5 = %v", out)
+	}
+	if out := f.Evaluate([]float64{3}); !near(out[0], 1, 1e-9) {
+		t.Errorf("Type 2 input is not clipped to the domain: %v", out)
+	}
+
+	stitch := pdf.Dictionary{
+		"FunctionType": pdf.Integer(3),
+		"Domain":       pdf.Array{pdf.Integer(0), pdf.Integer(2)},
+		"Functions": pdf.Array{
+			pdf.Dictionary{"FunctionType": pdf.Integer(2), "Domain": pdf.Array{pdf.Integer(0), pdf.Integer(1)}, "N": pdf.Integer(1)},
+			pdf.Dictionary{"FunctionType": pdf.Integer(2), "Domain": pdf.Array{pdf.Integer(0), pdf.Integer(1)}, "C0": pdf.Array{pdf.Integer(1)}, "C1": pdf.Array{pdf.Integer(1)}, "N": pdf.Integer(1)},
+		},
+		"Bounds": pdf.Array{pdf.Integer(1)},
+		"Encode": pdf.Array{pdf.Integer(1), pdf.Integer(0), pdf.Integer(0), pdf.Integer(1)},
+	}
+	f, err = doc.ParseFunction(stitch)
+	if err != nil {
+		t.Fatalf("ParseFunction(Type 3) failed: %v", err)
+	}
+	for _, tt := range []struct{ in, want float64 }{{0, 1}, {0.25, 0.75}, {1.5, 1}, {2, 1}} {
+		if out := f.Evaluate([]float64{tt.in}); !near(out[0], tt.want, 1e-9) {
+			t.Errorf("Type 3 at %v = %v, want %v", tt.in, out, tt.want)
+		}
+	}
+}
+
+// colorSpacePDF builds a one-page document whose resources define the
+// colour spaces used by the tests
+func colorSpacePDF(extra ...string) []byte {
+	rgbICC := linearRGBProfile()
+	cmykICC := cmykLabProfile()
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 40 20] /Resources 4 0 R /Contents 9 0 R /Annots [10 0 R] >>",
+		"<< /ColorSpace << /Spot 5 0 R /Pal [/Indexed /DeviceRGB 2 <FF000000FF000000FF>] " +
+			"/SpotPal [/Indexed 5 0 R 1 <00FF>] " +
+			"/Lab [/Lab << /WhitePoint [0.9505 1 1.089] /Range [-128 127 -128 127] >>] " +
+			"/CalRGB [/CalRGB << /WhitePoint [0.9505 1 1.089] /Matrix [0.4124 0.2126 0.0193 0.3576 0.7152 0.1192 0.1805 0.0722 0.9505] >>] " +
+			"/CalGray [/CalGray << /WhitePoint [0.9505 1 1.089] /Gamma 2.2 >>] " +
+			"/ICC [/ICCBased 6 0 R] /ICCCMYK [/ICCBased 7 0 R] /Broken [/ICCBased 8 0 R] >> " +
+			"/XObject << /Im1 11 0 R >> >>",
+		"[/Separation /PANTONE#20185#20C /DeviceCMYK << /FunctionType 2 /Domain [0 1] /C0 [0 0 0 0] /C1 [0 0.91 0.76 0] /N 1 >>]",
+		"<< /N 3 /Length " + formatInt(len(rgbICC)) + " >>\nstream\n" + string(rgbICC) + "\nendstream",
+		"<< /N 4 /Length " + formatInt(len(cmykICC)) + " >>\nstream\n" + string(cmykICC) + "\nendstream",
+		"<< /N 4 /Alternate /DeviceCMYK /Length 4 >>\nstream\nnone\nendstream",
+	}
+	return buildPDF(append(objects, extra...))
+}
+
+func TestColorSpaces(t *testing.T) {
+	doc, err := pdf.NewDocument(colorSpacePDF())
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	page, err := doc.GetPage(1)
+	if err != nil {
+		t.Fatalf("GetPage failed: %v", err)
+	}
+
+	sr, sg, sb := pdf.DeviceCMYK.RGB([]float64{0, 0.91, 0.76, 0})
+	spot := [3]float64{sr, sg, sb}
+	tests := []struct {
+		name   string
+		family string
+		n      int
+		comps  []float64
+		want   [3]float64
+	}{
+		{"DeviceGray", "DeviceGray", 1, []float64{0.25}, [3]float64{0.25, 0.25, 0.25}},
+		{"DeviceCMYK", "DeviceCMYK", 4, []float64{0, 0, 0, 0}, [3]float64{1, 1, 1}},
+		{"Spot", "Separation", 1, []float64{1}, spot},
+		{"Spot", "Separation", 1, []float64{0}, [3]float64{1, 1, 1}},
+		{"Pal", "Indexed", 1, []float64{1}, [3]float64{0, 1, 0}},
+		{"Pal", "Indexed", 1, []float64{7}, [3]float64{0, 0, 1}},
+		{"SpotPal", "Indexed", 1, []float64{1}, spot},
+		{"Lab", "Lab", 3, []float64{100, 0, 0}, [3]float64{1, 1, 1}},
+		{"Lab", "Lab", 3, []float64{53.24, 80.09, 67.20}, [3]float64{1, 0, 0}},
+		{"CalRGB", "CalRGB", 3, []float64{0.5, 0.5, 0.5}, [3]float64{0.735, 0.735, 0.735}},
+		{"CalRGB", "CalRGB", 3, []float64{0, 1, 0}, [3]float64{0, 1, 0}},
+		{"CalGray", "CalGray", 1, []float64{0.5}, [3]float64{0.503, 0.503, 0.503}},
+		{"ICC", "ICCBased", 3, []float64{0.5, 0.5, 0.5}, [3]float64{0.735, 0.735, 0.735}},
+		{"ICCCMYK", "ICCBased", 4, []float64{1, 1, 1, 0}, [3]float64{1, 1, 1}},
+		{"Broken", "ICCBased", 4, []float64{0, 0, 0, 0}, [3]float64{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		cs, err := doc.ParseColorSpace(pdf.Name(tt.name), page.Resources)
+		if err != nil {
+			t.Errorf("%s: ParseColorSpace failed: %v", tt.name, err)
+			continue
+		}
+		if cs.Family() != tt.family || cs.NComponents() != tt.n {
+			t.Errorf("%s: %s with %d components, want %s with %d", tt.name, cs.Family(), cs.NComponents(), tt.family, tt.n)
+		}
+		r, g, b := cs.RGB(tt.comps)
+		if got := [3]float64{r, g, b}; !nearRGB(got, tt.want, 0.02) {
+			t.Errorf("%s %v = %v, want %v", tt.name, tt.comps, got, tt.want)
+		}
+	}
+
+	// A CMYK spot colour differs from the naive 1 - (c + k) conversion
+	if sg > 0.3 || sr < 0.8 {
+		t.Errorf("PANTONE 185 C renders as %v", spot)
+	}
+	if _, err := doc.ParseColorSpace(pdf.Name("Missing"), page.Resources); err == nil {
+		t.Error("ParseColorSpace accepted an undefined name")
+	}
+}
+
+func TestColorSpaceRendering(t *testing.T) {
+	// A 4x1 image of 2-bit indexes into the Pal palette, and an
+	// annotation filled with the spot colour
+	content := "q 40 0 0 20 0 0 cm /Im1 Do Q"
+	appearance := "/Spot cs 1 scn 0 0 10 10 re f"
+	data := colorSpacePDF(
+		"<< /Length "+formatInt(len(content))+" >>\nstream\n"+content+"\nendstream",
+		"<< /Type /Annot /Subtype /Square /F 4 /Rect [30 0 40 10] /AP << /N 12 0 R >> >>",
+		"<< /Type /XObject /Subtype /Image /Width 4 /Height 1 /BitsPerComponent 2 "+
+			"/ColorSpace [/Indexed /DeviceRGB 2 <FF000000FF000000FF>] /Length 1 >>\nstream\n\x1B\nendstream",
+		"<< /Type /XObject /Subtype /Form /BBox [0 0 10 10] /Resources << /ColorSpace << /Spot 5 0 R >> >> /Length "+
+			formatInt(len(appearance))+" >>\nstream\n"+appearance+"\nendstream",
+	)
+	doc, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	red, green, blue := [3]uint8{255, 0, 0}, [3]uint8{0, 255, 0}, [3]uint8{0, 0, 255}
+	want := [][3]uint8{red, green, blue, blue}
+
+	extractor := pdf.NewImageExtractor(doc)
+	images, err := extractor.ExtractImages(1, 1)
+	if err != nil || len(images) != 1 {
+		t.Fatalf("ExtractImages = %d images, %v", len(images), err)
+	}
+	if images[0].ColorSpace != "index" || images[0].Components != 1 {
+		t.Errorf("image listed as %s with %d components", images[0].ColorSpace, images[0].Components)
+	}
+	pngData, err := extractor.GetImageData(images[0], "png")
+	if err != nil {
+		t.Fatalf("GetImageData failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	pixel := func(img image.Image, x, y int) [3]uint8 {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+	}
+	for x, c := range want {
+		if got := pixel(img, x, 0); got != c {
+			t.Errorf("extracted pixel %d = %v, want %v", x, got, c)
+		}
+	}
+
+	renderer := pdf.NewRenderer(doc)
+	renderer.SetResolution(72, 72)
+	rendered, err := renderer.RenderPage(1)
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	for x, c := range want {
+		i := 3 * (10*rendered.Width + 10*x + 5)
+		if got := [3]uint8{rendered.Data[i], rendered.Data[i+1], rendered.Data[i+2]}; got != c {
+			t.Errorf("rendered image column %d = %v, want %v", x, got, c)
+		}
+	}
+
+	page, err := pdf.NewPageRenderer(doc, pdf.RenderOptions{DPI: 72}).RenderPage(1)
+	if err != nil {
+		t.Fatalf("PageRenderer.RenderPage failed: %v", err)
+	}
+	img, err = png.Decode(bytes.NewReader(page.Data))
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	cs, err := doc.ParseColorSpace(pdf.Array{pdf.Name("Indexed"), pdf.Name("DeviceRGB"), pdf.Integer(0), pdf.String{Value: []byte{0, 0, 0}}}, nil)
+	if err != nil || cs.Family() != "Indexed" {
+		t.Fatalf("ParseColorSpace(inline Indexed) = %v, %v", cs, err)
+	}
+	spotCS, _ := doc.ParseColorSpace(pdf.Reference{ObjectNumber: 5}, nil)
+	r, g, b := spotCS.RGB([]float64{1})
+	spot := [3]uint8{uint8(math.Round(r * 255)), uint8(math.Round(g * 255)), uint8(math.Round(b * 255))}
+	if got := pixel(img, 35, 15); got != spot {
+		t.Errorf("spot colour annotation = %v, want %v", got, spot)
+	}
+}
@@ -0,0 +1,428 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// buildPDF assembles a PDF from object bodies; objects are numbered from 1
+// and object 1 must be the catalog
+func buildPDF(objects []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	buf.WriteString("%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = buf.Len()
+		buf.WriteString(formatInt(i+1) + " 0 obj\n")
+		buf.WriteString(body)
+		buf.WriteString("\nendobj\n")
+	}
+
+	xrefOffset := buf.Len()
+	buf.WriteString("xref\n")
+	buf.WriteString("0 " + formatInt(len(objects)+1) + "\n")
+	buf.WriteString("0000000000 65535 f \n")
+	for _, offset := range offsets {
+		buf.WriteString(formatXRefEntry(offset))
+	}
+	buf.WriteString("trailer\n")
+	buf.WriteString("<< /Size " + formatInt(len(objects)+1) + " /Root 1 0 R >>\n")
+	buf.WriteString("startxref\n")
+	buf.WriteString(formatInt(xrefOffset))
+	buf.WriteString("\n%%EOF\n")
+
+	return buf.Bytes()
+}
+
+// createFormPDF creates a single page PDF with one text field
+func createFormPDF() []byte {
+	return buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [4 0 R] /DA (/Helv 0 Tf 0 g) >> >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 6 0 R /Annots [4 0 R] >>",
+		"<< /Type /Annot /Subtype /Widget /FT /Tx /T (name) /V (Jane) /F 4 /Rect [100 700 300 720] /P 3 0 R /AP << /N 5 0 R >> >>",
+		"<< /Type /XObject /Subtype /Form /BBox [0 0 200 20] /Length 34 >>\nstream\nBT /Helv 12 Tf 2 5 Td (Jane) Tj ET\nendstream",
+		"<< /Length 17 >>\nstream\n0 0 m 10 10 l S\n\nendstream",
+	})
+}
+
+// TestFormFlatten tests baking widget appearances into page content
+func TestFormFlatten(t *testing.T) {
+	doc, err := pdf.NewDocument(createFormPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	if !doc.HasForm() {
+		t.Fatal("Document should have a form")
+	}
+
+	data, err := pdf.NewFormFlattener(doc, pdf.FlattenOptions{}).Flatten()
+	if err != nil {
+		t.Fatalf("Flatten failed: %v", err)
+	}
+
+	flat, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen flattened document: %v", err)
+	}
+	if flat.HasForm() {
+		t.Error("AcroForm should be removed")
+	}
+
+	page, err := flat.GetPage(1)
+	if err != nil {
+		t.Fatalf("GetPage failed: %v", err)
+	}
+	if page.Dictionary.Get("Annots") != nil {
+		t.Error("Widget annotations should be removed")
+	}
+
+	contents, err := page.GetContents()
+	if err != nil {
+		t.Fatalf("GetContents failed: %v", err)
+	}
+	if !bytes.Contains(contents, []byte("0 0 m 10 10 l S")) {
+		t.Error("Original page content should be preserved")
+	}
+	if !bytes.Contains(contents, []byte("cm /Flatten0 Do")) {
+		t.Errorf("Appearance should be drawn, got %q", contents)
+	}
+}
+
+// TestFDFImportExport tests filling a form from FDF and exporting it again
+func TestFDFImportExport(t *testing.T) {
+	doc, err := pdf.NewDocument(createFormPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	fdf := []byte("%FDF-1.2\n1 0 obj\n<< /FDF << /Fields [<< /T (name) /V (Ren\\351e) >>] >> >>\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	filler := pdf.NewFormFiller(doc)
+	if err := filler.ImportFDF(fdf); err != nil {
+		t.Fatalf("ImportFDF failed: %v", err)
+	}
+	data, err := filler.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	filled, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen filled document: %v", err)
+	}
+	fields := filled.GetFormFields()
+	if len(fields) != 1 || fields[0].Value != "Renée" {
+		t.Fatalf("Unexpected fields after import: %+v", fields)
+	}
+
+	exported, err := filled.ExportFDF()
+	if err != nil {
+		t.Fatalf("ExportFDF failed: %v", err)
+	}
+	if !bytes.Contains(exported, []byte("/T (name)")) {
+		t.Errorf("Exported FDF should contain the field, got %q", exported)
+	}
+
+	xfdf, err := filled.ExportXFDF()
+	if err != nil {
+		t.Fatalf("ExportXFDF failed: %v", err)
+	}
+	if !bytes.Contains(xfdf, []byte(`<field name="name">`)) {
+		t.Errorf("Exported XFDF should contain the field, got %s", xfdf)
+	}
+}
+
+// TestXFDFAnnotations tests importing and exporting markup annotations
+func TestXFDFAnnotations(t *testing.T) {
+	doc, err := pdf.NewDocument(createFormPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	xfdf := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<xfdf xmlns="http://ns.adobe.com/xfdf/">
+  <annots>
+    <highlight page="0" rect="10,10,110,30" color="#FFFF00" name="h1" title="Reviewer" coords="10,30,110,30,10,10,110,10">
+      <contents>Check this</contents>
+      <popup page="0" rect="120,10,220,110" open="no"/>
+    </highlight>
+  </annots>
+</xfdf>`)
+
+	filler := pdf.NewFormFiller(doc)
+	if err := filler.ImportXFDF(xfdf); err != nil {
+		t.Fatalf("ImportXFDF failed: %v", err)
+	}
+	data, err := filler.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	updated, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen document: %v", err)
+	}
+	annots, err := pdf.NewAnnotationExtractor(updated).GetPageAnnotations(1)
+	if err != nil {
+		t.Fatalf("GetPageAnnotations failed: %v", err)
+	}
+	var highlight *pdf.Annotation
+	for _, a := range annots {
+		if a.Subtype == "Highlight" {
+			highlight = a
+		}
+	}
+	if highlight == nil || highlight.Contents != "Check this" || len(highlight.QuadPoints) != 8 {
+		t.Fatalf("Highlight not imported correctly: %+v", highlight)
+	}
+
+	exported, err := updated.ExportXFDF()
+	if err != nil {
+		t.Fatalf("ExportXFDF failed: %v", err)
+	}
+	for _, want := range []string{"<highlight", `name="h1"`, `color="#FFFF00"`, "<contents>Check this</contents>", "<popup"} {
+		if !bytes.Contains(exported, []byte(want)) {
+			t.Errorf("Exported XFDF missing %s:\n%s", want, exported)
+		}
+	}
+}
+
+// TestXFDFAnnotationReplacement tests that imported annotations only
+// replace same-named annotations on their own page and take over the
+// replaced annotation's popup
+func TestXFDFAnnotationReplacement(t *testing.T) {
+	doc, err := pdf.NewDocument(buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Annots [5 0 R 6 0 R] >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Annots [7 0 R] >>",
+		"<< /Type /Annot /Subtype /Text /NM (note) /Rect [10 10 30 30] /Contents (Old note) /Popup 6 0 R /P 3 0 R >>",
+		"<< /Type /Annot /Subtype /Popup /Parent 5 0 R /Rect [40 10 140 110] /P 3 0 R >>",
+		"<< /Type /Annot /Subtype /Square /NM (box) /Rect [10 10 50 50] /Contents (Page two) /P 4 0 R >>",
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	importXFDF := func(doc *pdf.Document, annots string) *pdf.Document {
+		t.Helper()
+		filler := pdf.NewFormFiller(doc)
+		if err := filler.ImportXFDF([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<xfdf xmlns="http://ns.adobe.com/xfdf/"><annots>` + annots + `</annots></xfdf>`)); err != nil {
+			t.Fatalf("ImportXFDF failed: %v", err)
+		}
+		data, err := filler.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		updated, err := pdf.NewDocument(data)
+		if err != nil {
+			t.Fatalf("Failed to reopen document: %v", err)
+		}
+		return updated
+	}
+	pageAnnots := func(doc *pdf.Document, page int) map[string][]string {
+		t.Helper()
+		annots, err := pdf.NewAnnotationExtractor(doc).GetPageAnnotations(page)
+		if err != nil {
+			t.Fatalf("GetPageAnnotations failed: %v", err)
+		}
+		bySubtype := make(map[string][]string)
+		for _, a := range annots {
+			bySubtype[a.Subtype] = append(bySubtype[a.Subtype], a.Contents)
+		}
+		return bySubtype
+	}
+
+	// A name used on another page adds a new annotation; the replaced note
+	// keeps its popup
+	updated := importXFDF(doc, `
+<square page="0" name="box" rect="100,100,150,150"><contents>Page one</contents></square>
+<text page="0" name="note" rect="10,10,30,30"><contents>New note</contents><popup page="0" rect="40,10,160,130" open="yes"/></text>`)
+	first, second := pageAnnots(updated, 1), pageAnnots(updated, 2)
+	if got := first["Square"]; len(got) != 1 || got[0] != "Page one" {
+		t.Errorf("Page 1 squares = %q, want the imported one", got)
+	}
+	if got := second["Square"]; len(got) != 1 || got[0] != "Page two" {
+		t.Errorf("Page 2 squares = %q, want the original one", got)
+	}
+	if got := first["Text"]; len(got) != 1 || got[0] != "New note" {
+		t.Errorf("Page 1 notes = %q, want the replacement", got)
+	}
+	if got := first["Popup"]; len(got) != 1 {
+		t.Errorf("Page 1 has %d popups, want the old one reused", len(got))
+	}
+
+	// Replacing the note without a popup drops the old popup
+	updated = importXFDF(updated, `<text page="0" name="note" rect="10,10,30,30"><contents>Bare note</contents></text>`)
+	first = pageAnnots(updated, 1)
+	if got := first["Text"]; len(got) != 1 || got[0] != "Bare note" {
+		t.Errorf("Page 1 notes = %q, want the replacement", got)
+	}
+	if got := first["Popup"]; len(got) != 0 {
+		t.Errorf("Page 1 still has %d popups", len(got))
+	}
+}
+
+// createScriptFormPDF creates a form whose fields carry AcroForm scripts:
+// a validated quantity, a numeric price, their product formatted as
+// currency and their sum
+func createScriptFormPDF() []byte {
+	return buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [4 0 R 5 0 R 6 0 R 7 0 R] /CO [6 0 R 7 0 R] /DA (/Helv 0 Tf 0 g) >> >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Annots [4 0 R 5 0 R 6 0 R 7 0 R] >>",
+		"<< /Type /Annot /Subtype /Widget /FT /Tx /T (qty) /F 4 /Rect [100 700 300 720] /P 3 0 R " +
+			"/AA << /V << /S /JavaScript /JS (AFRange_Validate(true, 0, true, 100);) >> >> >>",
+		"<< /Type /Annot /Subtype /Widget /FT /Tx /T (price) /F 4 /Rect [100 670 300 690] /P 3 0 R " +
+			"/AA << /K << /S /JavaScript /JS (AFNumber_Keystroke(2, 0, 0, 0, \"\", true);) >> >> >>",
+		"<< /Type /Annot /Subtype /Widget /FT /Tx /T (total) /Ff 1 /F 4 /Rect [100 640 300 660] /P 3 0 R " +
+			"/AA << /C << /S /JavaScript /JS (event.value = this.getField\\(\"qty\"\\).value * this.getField\\(\"price\"\\).value;) >> " +
+			"/F << /S /JavaScript /JS (AFNumber_Format(2, 0, 0, 0, \"$\", true);) >> >> >>",
+		"<< /Type /Annot /Subtype /Widget /FT /Tx /T (sum) /Ff 1 /F 4 /Rect [100 610 300 630] /P 3 0 R " +
+			"/AA << /C << /S /JavaScript /JS (AFSimple_Calculate(\"SUM\", [\"qty\", \"price\"]);) >> >> >>",
+	})
+}
+
+// TestFormScripts tests that filling a form runs its keystroke, validate,
+// calculate and format scripts
+func TestFormScripts(t *testing.T) {
+	doc, err := pdf.NewDocument(createScriptFormPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	filler := pdf.NewFormFiller(doc)
+	if err := filler.SetValue("qty", "2"); err != nil {
+		t.Fatalf("SetValue qty failed: %v", err)
+	}
+	if err := filler.SetValue("price", "617.25"); err != nil {
+		t.Fatalf("SetValue price failed: %v", err)
+	}
+	if err := filler.SetValue("qty", "500"); err == nil {
+		t.Error("Validate script should reject a quantity above 100")
+	}
+	if err := filler.SetValue("price", "abc"); err == nil {
+		t.Error("Keystroke script should reject a non-numeric price")
+	}
+
+	data, err := filler.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	filled, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen filled document: %v", err)
+	}
+	values := make(map[string]string)
+	for _, field := range filled.GetFormFields() {
+		values[field.Name] = field.Value
+	}
+	if values["qty"] != "2" || values["total"] != "1234.5" || values["sum"] != "619.25" {
+		t.Errorf("Unexpected values after filling: %v", values)
+	}
+
+	widget, err := filled.ResolveObject(pdf.Reference{ObjectNumber: 6})
+	if err != nil {
+		t.Fatalf("Failed to resolve total widget: %v", err)
+	}
+	ap, _ := widget.(pdf.Dictionary).Get("AP").(pdf.Dictionary)
+	apObj, err := filled.ResolveObject(ap.Get("N"))
+	if err != nil {
+		t.Fatalf("Failed to resolve total appearance: %v", err)
+	}
+	stream, ok := apObj.(pdf.Stream)
+	if !ok {
+		t.Fatalf("Total appearance is %T, want stream", apObj)
+	}
+	content, err := stream.Decode()
+	if err != nil {
+		t.Fatalf("Failed to decode appearance: %v", err)
+	}
+	if !bytes.Contains(content, []byte("($1,234.50)")) {
+		t.Errorf("Total appearance should show the formatted value, got %q", content)
+	}
+
+	engine := pdf.NewJSEngine(filled)
+	result, err := engine.Execute(`util.printf("%,0.2f", this.getField("total").value)`)
+	if err != nil || result != "1,234.50" {
+		t.Errorf("Execute returned %v, %v", result, err)
+	}
+	engine.SetLimits(pdf.JSLimits{Timeout: 50 * time.Millisecond})
+	if _, err := engine.Execute("while (true) {}"); !errors.Is(err, pdf.ErrScriptTimeout) {
+		t.Errorf("Endless loop should hit the time limit, got %v", err)
+	}
+}
+
+func TestJSEngineWithoutDocument(t *testing.T) {
+	engine := pdf.NewJSEngine(nil)
+	result, err := engine.Execute(`var s = 0; for (var i = 1; i <= 4; i++) s += i; util.printf("%d fields, %d pages, sum %d", this.numFields, this.numPages, s)`)
+	if err != nil || result != "0 fields, 0 pages, sum 10" {
+		t.Errorf("Execute returned %v, %v", result, err)
+	}
+	if _, err := engine.Execute(`this.getField("missing").value`); err == nil {
+		t.Error("Reading a field of an empty form should fail")
+	}
+	if err := engine.Calculate(); err != nil {
+		t.Errorf("Calculate failed: %v", err)
+	}
+	if result, err := engine.ExecuteFormCalc("Sum(1, 2)"); err != nil || result != 3.0 {
+		t.Errorf("ExecuteFormCalc returned %v, %v", result, err)
+	}
+}
+
+func TestJSEngineNativeLimits(t *testing.T) {
+	engine := pdf.NewJSEngine(nil)
+	for script, want := range map[string]any{
+		`"a,b,,c".split(",", 3).join("|")`:          "a|b|",
+		`"a1b22c".split(/(\d+)/).join("|")`:         "a|1|b|22|c",
+		`"".split("").length`:                       0.0,
+		`"x1y2".match(/\d/g).join()`:                "1,2",
+		`"aXbXc".replace(/X/g, "-").concat("!")`:    "a-b-c!",
+		`[1, 2].concat([3], 4).join("")`:            "1234",
+		`Array.from("ab").concat([..."cd"]).join()`: "a,b,c,d",
+	} {
+		if got, err := engine.Execute(script); err != nil || got != want {
+			t.Errorf("%s = %v, %v; want %v", script, got, err, want)
+		}
+	}
+
+	// Builtins that build their results natively must stay inside the
+	// sandbox limits too
+	start := time.Now()
+	if _, err := engine.Execute(`'x'.repeat(1 << 26).split('')`); !errors.Is(err, pdf.ErrScriptMemory) {
+		t.Errorf("Splitting a huge string should hit the memory limit, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > pdf.DefaultJSLimits.Timeout {
+		t.Errorf("Memory limit took %v to apply", elapsed)
+	}
+	engine.SetLimits(pdf.JSLimits{Timeout: time.Second, MaxMemory: 8 << 20})
+	for _, script := range []string{
+		`'x'.repeat(1 << 22).split(/(x)/)`,
+		`'x'.repeat(1 << 22).match(/x/g)`,
+		`'x'.repeat(1 << 17).split('').join('x'.repeat(1 << 10))`,
+		`var s = 'x'.repeat(1 << 22); s.concat(s, s)`,
+		`var a = 'x'.repeat(1 << 17).split(''); a.concat(a, a, a, a)`,
+		`'x'.repeat(1 << 21).replace(/x/g, 'yyyy')`,
+		`[...'x'.repeat(1 << 22)]`,
+	} {
+		if _, err := engine.Execute(script); !errors.Is(err, pdf.ErrScriptMemory) {
+			t.Errorf("%s should hit the memory limit, got %v", script, err)
+		}
+	}
+
+	engine.SetLimits(pdf.JSLimits{Timeout: 50 * time.Millisecond})
+	start = time.Now()
+	if _, err := engine.Execute(`var a = 'x'.repeat(1 << 20).split(''); while (true) a.indexOf('y')`); !errors.Is(err, pdf.ErrScriptTimeout) {
+		t.Errorf("Searching a large array in a loop should hit the time limit, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Time limit took %v to apply", elapsed)
+	}
+}
@@ -0,0 +1,174 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+func functionStream(dict, data string) string {
+	return "<< " + dict + " /Length " + formatInt(len(data)) + " >>\nstream\n" + data + "\nendstream"
+}
+
+func TestSampledAndCalculatorFunctions(t *testing.T) {
+	doc, err := pdf.NewDocument(buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 10 10] /Resources << /ColorSpace << /Duo [/DeviceN [/Cyan /Spot] /DeviceCMYK 7 0 R] >> >> >>",
+		// 2x2 grid, one output: samples 0, 255 / 255, 0 with the first input varying fastest
+		functionStream("/FunctionType 0 /Domain [0 1 0 1] /Range [0 1] /Size [2 2] /BitsPerSample 8", "\x00\xFF\xFF\x00"),
+		// 3 samples of 4 bits, decoded to 10..20
+		functionStream("/FunctionType 0 /Domain [0 1] /Range [0 100] /Decode [10 20] /Size [3] /BitsPerSample 4", "\x0F\x00"),
+		functionStream("/FunctionType 4 /Domain [-10 10 -10 10] /Range [-100 100 0 1 -100 100]",
+			"{ 2 copy gt { exch } if % max on top\n 2 copy sub abs exch 2 mul 3 -1 roll pop 1 { 0.5 } { 0 } ifelse exch }"),
+		functionStream("/FunctionType 4 /Domain [0 1 0 1] /Range [0 1 0 1 0 1 0 1]", "{ 0 0 4 2 roll exch }"),
+		functionStream("/FunctionType 4 /Domain [0 1] /Range [0 1]", "{ pop pop }"),
+	}))
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	parse := func(num int) pdf.Function {
+		t.Helper()
+		f, err := doc.ParseFunction(pdf.Reference{ObjectNumber: num})
+		if err != nil {
+			t.Fatalf("ParseFunction(%d) failed: %v", num, err)
+		}
+		return f
+	}
+
+	bilinear := parse(4)
+	if bilinear.Inputs() != 2 || bilinear.Outputs() != 1 {
+		t.Fatalf("sampled function is %d -> %d", bilinear.Inputs(), bilinear.Outputs())
+	}
+	for _, tt := range []struct{ x, y, want float64 }{
+		{0, 0, 0}, {1, 0, 1}, {0, 1, 1}, {1, 1, 0}, {0.5, 0.5, 0.5}, {0.25, 0, 0.25}, {2, -1, 1},
+	} {
+		if out := bilinear.Evaluate([]float64{tt.x, tt.y}); !near(out[0], tt.want, 1e-9) {
+			t.Errorf("sampled(%v, %v) = %v, want %v", tt.x, tt.y, out[0], tt.want)
+		}
+	}
+
+	packed := parse(5)
+	for _, tt := range []struct{ x, want float64 }{{0, 10}, {0.5, 20}, {0.75, 15}, {1, 10}} {
+		if out := packed.Evaluate([]float64{tt.x}); !near(out[0], tt.want, 1e-9) {
+			t.Errorf("4-bit sampled(%v) = %v, want %v", tt.x, out[0], tt.want)
+		}
+	}
+
+	calc := parse(6)
+	if calc.Inputs() != 2 || calc.Outputs() != 3 {
+		t.Fatalf("calculator function is %d -> %d", calc.Inputs(), calc.Outputs())
+	}
+	// Outputs: |a-b|, 0.5 (the true branch), 2 × max(a, b)
+	if out := calc.Evaluate([]float64{3, -4}); !near(out[0], 7, 1e-9) || !near(out[1], 0.5, 1e-9) || !near(out[2], 6, 1e-9) {
+		t.Errorf("calculator(3, -4) = %v", out)
+	}
+
+	// An underflowing program yields the range minimum instead of failing
+	if out := parse(8).Evaluate([]float64{0.5}); len(out) != 1 || out[0] != 0 {
+		t.Errorf("failing calculator = %v", out)
+	}
+
+	for _, program := range []string{"{ 1 2 foo }", "{ 1 { 2 } }", "1 2 add", "{ { 1 } { 2 } if }"} {
+		bad := pdf.Stream{
+			Dictionary: pdf.Dictionary{
+				"FunctionType": pdf.Integer(4),
+				"Domain":       pdf.Array{pdf.Integer(0), pdf.Integer(1)},
+				"Range":        pdf.Array{pdf.Integer(0), pdf.Integer(1)},
+			},
+			Data: []byte(program),
+		}
+		if _, err := doc.ParseFunction(bad); err == nil {
+			t.Errorf("ParseFunction accepted %q", program)
+		}
+	}
+
+	// A DeviceN space whose tint transform maps Cyan and a spot ink to CMYK
+	page, err := doc.GetPage(1)
+	if err != nil {
+		t.Fatalf("GetPage failed: %v", err)
+	}
+	cs, err := doc.ParseColorSpace(pdf.Name("Duo"), page.Resources)
+	if err != nil {
+		t.Fatalf("ParseColorSpace failed: %v", err)
+	}
+	r, g, b := cs.RGB([]float64{0, 1})
+	wr, wg, wb := pdf.DeviceCMYK.RGB([]float64{0, 0, 1, 0})
+	if !nearRGB([3]float64{r, g, b}, [3]float64{wr, wg, wb}, 1e-9) {
+		t.Errorf("DeviceN (0, 1) = %v %v %v, want yellow %v %v %v", r, g, b, wr, wg, wb)
+	}
+}
+
+func TestFunctionWithoutDocument(t *testing.T) {
+	// A stitching function of two exponential functions built in memory
+	f, err := pdf.ParseFunction(pdf.Dictionary{
+		"FunctionType": pdf.Integer(3),
+		"Domain":       pdf.Array{pdf.Integer(0), pdf.Integer(2)},
+		"Functions": pdf.Array{
+			pdf.Dictionary{"FunctionType": pdf.Integer(2), "Domain": pdf.Array{pdf.Integer(0), pdf.Integer(1)}, "N": pdf.Integer(1)},
+			pdf.Dictionary{"FunctionType": pdf.Integer(2), "Domain": pdf.Array{pdf.Integer(0), pdf.Integer(1)}, "N": pdf.Integer(2),
+				"C0": pdf.Array{pdf.Integer(1)}, "C1": pdf.Array{pdf.Integer(0)}},
+		},
+		"Bounds": pdf.Array{pdf.Integer(1)},
+		"Encode": pdf.Array{pdf.Integer(0), pdf.Integer(1), pdf.Integer(0), pdf.Integer(1)},
+	})
+	if err != nil {
+		t.Fatalf("ParseFunction failed: %v", err)
+	}
+	for _, tt := range []struct{ x, want float64 }{{0, 0}, {0.5, 0.5}, {1.5, 0.75}, {2, 0}} {
+		if out := f.Evaluate([]float64{tt.x}); !near(out[0], tt.want, 1e-9) {
+			t.Errorf("stitched(%v) = %v, want %v", tt.x, out[0], tt.want)
+		}
+	}
+
+	calc, err := pdf.ParseFunction(pdf.Stream{
+		Dictionary: pdf.Dictionary{
+			"FunctionType": pdf.Integer(4),
+			"Domain":       pdf.Array{pdf.Integer(0), pdf.Integer(1), pdf.Integer(0), pdf.Integer(1)},
+			"Range":        pdf.Array{pdf.Integer(0), pdf.Integer(2)},
+		},
+		Data: []byte("{ add }"),
+	})
+	if err != nil {
+		t.Fatalf("ParseFunction(calculator) failed: %v", err)
+	}
+	if out := calc.Evaluate([]float64{0.25, 0.5}); !near(out[0], 0.75, 1e-9) {
+		t.Errorf("calculator(0.25, 0.5) = %v", out)
+	}
+
+	// References cannot be followed without a document
+	if _, err := pdf.ParseFunction(pdf.Reference{ObjectNumber: 4}); err == nil {
+		t.Error("ParseFunction resolved a reference without a document")
+	}
+}
+
+func TestCalculatorFunctionInputLimit(t *testing.T) {
+	calculator := func(inputs int) pdf.Stream {
+		domain := pdf.Array{}
+		for i := 0; i < inputs; i++ {
+			domain = append(domain, pdf.Integer(0), pdf.Integer(1))
+		}
+		return pdf.Stream{
+			Dictionary: pdf.Dictionary{
+				"FunctionType": pdf.Integer(4),
+				"Domain":       domain,
+				"Range":        pdf.Array{pdf.Integer(0), pdf.Integer(1)},
+			},
+			Data: []byte("{ }"),
+		}
+	}
+
+	// Every input must fit on the 100-entry operand stack
+	f, err := pdf.ParseFunction(calculator(100))
+	if err != nil {
+		t.Fatalf("ParseFunction(100 inputs) failed: %v", err)
+	}
+	in := make([]float64, 100)
+	in[99] = 1
+	if out := f.Evaluate(in); len(out) != 1 || out[0] != 1 {
+		t.Errorf("100-input calculator = %v, want the last input", out)
+	}
+	if _, err := pdf.ParseFunction(calculator(101)); err == nil {
+		t.Error("ParseFunction accepted a calculator function with 101 inputs")
+	}
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// sharedImagesPDF has three pages. Each paints the logo XObject 6 twice;
+// pages 1 and 3 also paint object 7, a copy of the logo, and page 2 paints
+// a different image. Every page paints the same inline image data through
+// its /Pal palette, which only page 3 defines differently.
+func sharedImagesPDF() []byte {
+	logo := "\xFF\x00\x00\x00\x00\xFF"
+	var objects []string
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 >>")
+	content := map[int]string{
+		1: "q 10 0 0 10 0 0 cm /Logo Do Q q 20 0 0 20 10 0 cm /Logo Do Q /Copy Do",
+		2: "q 10 0 0 10 0 0 cm /Logo Do Q /Logo Do /Other Do",
+		3: "/Logo Do q 5 0 0 5 0 0 cm /Logo Do Q /Copy Do",
+	}
+	for page := 1; page <= 3; page++ {
+		palette := "<FF0000>"
+		if page == 3 {
+			palette = "<0000FF>"
+		}
+		objects = append(objects, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 40 40] /Contents "+formatInt(page+8)+" 0 R "+
+			"/Resources << /XObject << /Logo 6 0 R /Copy 7 0 R /Other 8 0 R >> /ColorSpace << /Pal [/Indexed /DeviceRGB 0 "+palette+"] >> >> >>")
+	}
+	objects = append(objects,
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceRGB /BitsPerComponent 8", logo),
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Name /Im2", logo),
+		imageStream("/Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x80"))
+	for page := 1; page <= 3; page++ {
+		data := content[page] + " BI /W 1 /H 1 /CS /Pal /BPC 8 ID \x00 EI"
+		objects = append(objects, "<< /Length "+formatInt(len(data))+" >>\nstream\n"+data+"\nendstream")
+	}
+	return buildPDF(objects)
+}
+
+func TestImageDeduplication(t *testing.T) {
+	doc, err := pdf.NewDocument(sharedImagesPDF())
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	extractor := pdf.NewImageExtractor(doc)
+	all, err := extractor.ExtractImages(1, 3)
+	if err != nil || len(all) != 12 {
+		t.Fatalf("ExtractImages = %d images, %v", len(all), err)
+	}
+	unique, err := extractor.ExtractUniqueImages(1, 3)
+	if err != nil {
+		t.Fatalf("ExtractUniqueImages failed: %v", err)
+	}
+	if len(unique) != 4 {
+		for _, u := range unique {
+			t.Logf("object %d inline %v: %d uses", u.ObjectNum, u.Inline, len(u.Uses))
+		}
+		t.Fatalf("got %d unique images, want 4", len(unique))
+	}
+
+	// The logo and its copy in object 7 are one image
+	logo := unique[0]
+	if logo.ObjectNum != 6 || len(logo.Uses) != 8 || !reflect.DeepEqual(logo.Pages(), []int{1, 2, 3}) {
+		t.Errorf("logo: object %d, %d uses on pages %v", logo.ObjectNum, len(logo.Uses), logo.Pages())
+	}
+	objects := map[int]int{}
+	for _, use := range logo.Uses {
+		objects[use.ObjectNum]++
+		if use.Hash != logo.Hash || len(use.Hash) != 64 {
+			t.Errorf("use hash %q, want %q", use.Hash, logo.Hash)
+		}
+	}
+	if objects[6] != 6 || objects[7] != 2 {
+		t.Errorf("logo uses by object = %v", objects)
+	}
+
+	// Every use keeps its own placement
+	placements := logo.Placements()
+	if placements[0].ScaleX != 10 || placements[1].ScaleX != 20 || placements[1].X != 10 || placements[2].Page != 1 {
+		t.Errorf("logo placements = %+v", placements[:3])
+	}
+	if placements[0].Hash != logo.Hash {
+		t.Errorf("placement hash = %q", placements[0].Hash)
+	}
+
+	// The inline image is the same on pages 1 and 2 but not with page 3's
+	// palette
+	inline := unique[1]
+	if !inline.Inline || !reflect.DeepEqual(inline.Pages(), []int{1, 2}) {
+		t.Errorf("inline image: inline %v, pages %v", inline.Inline, inline.Pages())
+	}
+	if other := unique[2]; other.ObjectNum != 8 || len(other.Uses) != 1 {
+		t.Errorf("third image: object %d, %d uses", other.ObjectNum, len(other.Uses))
+	}
+	if last := unique[3]; !last.Inline || !reflect.DeepEqual(last.Pages(), []int{3}) {
+		t.Errorf("recoloured inline image: inline %v, pages %v", last.Inline, last.Pages())
+	}
+}
@@ -0,0 +1,162 @@
+package test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+func imageStream(dict, data string) string {
+	return "<< /Type /XObject /Subtype /Image " + dict + " /Length " + formatInt(len(data)) + " >>\nstream\n" + data + "\nendstream"
+}
+
+// maskedImagesPDF places five images in 10pt cells along a 50 × 10 page:
+// a soft-masked image, a colour-keyed image, a 16-bit image, a red stencil
+// and an image with an explicit mask. An annotation paints the stencil in
+// green over the last cell.
+func maskedImagesPDF() []byte {
+	content := "q 10 0 0 10 0 0 cm /Im1 Do Q q 10 0 0 10 10 0 cm /Im2 Do Q q 10 0 0 10 20 0 cm /Im3 Do Q " +
+		"1 0 0 rg q 10 0 0 10 30 0 cm /Im4 Do Q q 10 0 0 10 40 0 cm /Im5 Do Q"
+	appearance := "0 1 0 rg 10 0 0 10 0 0 cm /S Do"
+	return buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 50 10] /Contents 4 0 R /Annots [11 0 R] " +
+			"/Resources << /XObject << /Im1 5 0 R /Im2 7 0 R /Im3 8 0 R /Im4 9 0 R /Im5 10 0 R >> >> >>",
+		"<< /Length " + formatInt(len(content)) + " >>\nstream\n" + content + "\nendstream",
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceRGB /BitsPerComponent 8 /SMask 6 0 R", "\xFF\x00\x00\xFF\x00\x00"),
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8", "\xFF\x00"),
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Mask [0 10]", "\x05\x80"),
+		imageStream("/Width 1 /Height 1 /ColorSpace /DeviceRGB /BitsPerComponent 16 /Decode [1 0 0 1 0 1]", "\x12\x34\xFF\xFF\x00\x00"),
+		imageStream("/Width 8 /Height 1 /ImageMask true /Decode [1 0]", "\xF0"),
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Mask 12 0 R", "\x00\x00\xFF\x00\x00\xFF"),
+		"<< /Type /Annot /Subtype /Stamp /F 4 /Rect [40 0 50 10] /AP << /N 13 0 R >> >>",
+		imageStream("/Width 2 /Height 1 /ImageMask true", "\x40"),
+		"<< /Type /XObject /Subtype /Form /BBox [0 0 10 10] /Resources << /XObject << /S 9 0 R >> >> /Length " +
+			formatInt(len(appearance)) + " >>\nstream\n" + appearance + "\nendstream",
+	})
+}
+
+func TestImageMasksExtraction(t *testing.T) {
+	doc, err := pdf.NewDocument(maskedImagesPDF())
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	extractor := pdf.NewImageExtractor(doc)
+	images, err := extractor.ExtractImages(1, 1)
+	if err != nil || len(images) != 5 {
+		t.Fatalf("ExtractImages = %d images, %v", len(images), err)
+	}
+	byObject := map[int]*pdf.ImageInfo{}
+	for _, img := range images {
+		byObject[img.Width*100+img.BitsPerComponent] = img
+	}
+	decode := func(img *pdf.ImageInfo) image.Image {
+		t.Helper()
+		data, err := extractor.GetImageData(img, "png")
+		if err != nil {
+			t.Fatalf("GetImageData failed: %v", err)
+		}
+		out, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("png.Decode failed: %v", err)
+		}
+		return out
+	}
+	alphaAt := func(img image.Image, x int) uint8 {
+		return color.NRGBAModel.Convert(img.At(x, 0)).(color.NRGBA).A
+	}
+
+	stencil := byObject[801]
+	if stencil == nil || stencil.Type != "stencil" || !stencil.IsMask || !stencil.Invert {
+		t.Errorf("stencil listed as %+v", stencil)
+	}
+
+	var soft, keyed, explicit *pdf.ImageInfo
+	for _, img := range images {
+		switch {
+		case img.HasSoftMask():
+			soft = img
+		case img.HasColorKeyMask():
+			keyed = img
+		case img.HasMask:
+			explicit = img
+		}
+	}
+	if soft == nil || keyed == nil || explicit == nil {
+		t.Fatalf("masks not reported: soft %v, colour key %v, explicit %v", soft, keyed, explicit)
+	}
+	if img := decode(soft); alphaAt(img, 0) != 255 || alphaAt(img, 1) != 0 {
+		t.Errorf("soft mask alpha = %d, %d", alphaAt(img, 0), alphaAt(img, 1))
+	}
+	if img := decode(keyed); alphaAt(img, 0) != 0 || alphaAt(img, 1) != 255 {
+		t.Errorf("colour key alpha = %d, %d", alphaAt(img, 0), alphaAt(img, 1))
+	}
+	if img := decode(explicit); alphaAt(img, 0) != 255 || alphaAt(img, 1) != 0 {
+		t.Errorf("explicit mask alpha = %d, %d", alphaAt(img, 0), alphaAt(img, 1))
+	}
+
+	deep := decode(byObject[116])
+	c := color.RGBA64Model.Convert(deep.At(0, 0)).(color.RGBA64)
+	if c.R != 0xEDCB || c.G != 0xFFFF || c.B != 0 {
+		t.Errorf("16-bit image with Decode = %04x %04x %04x", c.R, c.G, c.B)
+	}
+	if _, ok := deep.(*image.RGBA64); !ok {
+		t.Errorf("16-bit image written as %T", deep)
+	}
+}
+
+func TestImageMasksRendering(t *testing.T) {
+	doc, err := pdf.NewDocument(maskedImagesPDF())
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	renderer := pdf.NewRenderer(doc)
+	renderer.SetResolution(72, 72)
+	page, err := renderer.RenderPage(1)
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	white, red, blue := [3]uint8{255, 255, 255}, [3]uint8{255, 0, 0}, [3]uint8{0, 0, 255}
+	tests := []struct {
+		x    int
+		want [3]uint8
+		what string
+	}{
+		{2, red, "soft-masked opaque pixel"},
+		{7, white, "soft-masked transparent pixel"},
+		{12, white, "colour-keyed pixel"},
+		{17, [3]uint8{128, 128, 128}, "unkeyed pixel"},
+		{32, red, "stencil painted with the fill colour"},
+		{37, white, "stencil background"},
+		{42, blue, "explicitly masked image"},
+		{47, white, "explicit mask hole"},
+	}
+	for _, tt := range tests {
+		i := 3 * (5*page.Width + tt.x)
+		if got := [3]uint8{page.Data[i], page.Data[i+1], page.Data[i+2]}; got != tt.want {
+			t.Errorf("%s at x=%d: %v, want %v", tt.what, tt.x, got, tt.want)
+		}
+	}
+
+	// The content rasterizer paints the stencil in the annotation with its
+	// own fill colour
+	rendered, err := pdf.NewPageRenderer(doc, pdf.RenderOptions{DPI: 72}).RenderPage(1)
+	if err != nil {
+		t.Fatalf("PageRenderer.RenderPage failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(rendered.Data))
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	if r, g, b, _ := img.At(42, 5).RGBA(); r != 0 || g != 0xFFFF || b != 0 {
+		t.Errorf("annotation stencil = %04x %04x %04x, want green", r, g, b)
+	}
+	if r, g, b, _ := img.At(47, 5).RGBA(); r != 0xFFFF || g != 0xFFFF || b != 0xFFFF {
+		t.Errorf("annotation stencil background = %04x %04x %04x, want white", r, g, b)
+	}
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// resampledImagesPDF paints four images in 10pt cells along a 40 × 10 page:
+// a red/blue pair rotated by 90°, a 20 × 20 checkerboard shrunk to 10 × 10,
+// a black/white pair with /Interpolate and the same pair without it,
+// clipped to the left 3pt of its cell.
+func resampledImagesPDF() []byte {
+	content := "q 0 10 -10 0 10 0 cm /Rot Do Q q 10 0 0 10 10 0 cm /Check Do Q q 10 0 0 10 20 0 cm /Smooth Do Q " +
+		"q 30 0 3 10 re W n 10 0 0 10 30 0 cm /Hard Do Q"
+	var checker strings.Builder
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			checker.WriteByte(byte(255 * ((x + y) % 2)))
+		}
+	}
+	return buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 40 10] /Contents 4 0 R " +
+			"/Resources << /XObject << /Rot 5 0 R /Check 6 0 R /Smooth 7 0 R /Hard 8 0 R >> >> >>",
+		"<< /Length " + formatInt(len(content)) + " >>\nstream\n" + content + "\nendstream",
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceRGB /BitsPerComponent 8", "\xFF\x00\x00\x00\x00\xFF"),
+		imageStream("/Width 20 /Height 20 /ColorSpace /DeviceGray /BitsPerComponent 8", checker.String()),
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8 /Interpolate true", "\x00\xFF"),
+		imageStream("/Width 2 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x00\xFF"),
+	})
+}
+
+func TestImageResampling(t *testing.T) {
+	doc, err := pdf.NewDocument(resampledImagesPDF())
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	renderer := pdf.NewRenderer(doc)
+	renderer.SetResolution(72, 72)
+	page, err := renderer.RenderPage(1)
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	rendered, err := pdf.NewPageRenderer(doc, pdf.RenderOptions{DPI: 72}).RenderPage(1)
+	if err != nil {
+		t.Fatalf("PageRenderer.RenderPage failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(rendered.Data))
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+
+	// Both renderers must agree on every pixel
+	pixel := func(x, y int) [3]uint8 {
+		t.Helper()
+		i := 3 * (y*page.Width + x)
+		got := [3]uint8{page.Data[i], page.Data[i+1], page.Data[i+2]}
+		r, g, b, _ := img.At(x, y).RGBA()
+		if other := [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}; other != got {
+			t.Errorf("pixel (%d, %d): Renderer %v, PageRenderer %v", x, y, got, other)
+		}
+		return got
+	}
+
+	// The first image column runs up the page after the rotation
+	if got := pixel(5, 7); got != [3]uint8{255, 0, 0} {
+		t.Errorf("rotated image bottom = %v, want red", got)
+	}
+	if got := pixel(5, 2); got != [3]uint8{0, 0, 255} {
+		t.Errorf("rotated image top = %v, want blue", got)
+	}
+
+	// Each device pixel averages a 2 × 2 block of the checkerboard
+	for y := 0; y < 10; y++ {
+		for x := 10; x < 20; x++ {
+			if got := pixel(x, y); got[0] < 127 || got[0] > 128 {
+				t.Fatalf("checkerboard at (%d, %d) = %v, want mid gray", x, y, got)
+			}
+		}
+	}
+
+	// /Interpolate blends the two samples into a ramp
+	prev := -1
+	for x := 20; x < 30; x++ {
+		v := int(pixel(x, 5)[0])
+		if v < prev {
+			t.Errorf("interpolated ramp falls at x=%d: %d after %d", x, v, prev)
+		}
+		prev = v
+	}
+	if v := pixel(24, 5)[0]; v == 0 || v == 255 {
+		t.Errorf("interpolated image at x=24 = %d, want an intermediate gray", v)
+	}
+
+	// Without /Interpolate a 5× magnification keeps hard edges, and the
+	// clip path hides everything right of x=33
+	if got := pixel(31, 5); got != [3]uint8{0, 0, 0} {
+		t.Errorf("clipped image = %v, want black", got)
+	}
+	if got := pixel(36, 5); got != [3]uint8{255, 255, 255} {
+		t.Errorf("pixel outside the clip = %v, want white", got)
+	}
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// inlineImageContent paints four inline images and a form holding an
+// image XObject in 10pt cells along a 50 × 10 page. The RGB image data
+// contains "\nEI", which only its computed length tells apart from the
+// end of the data.
+const inlineImageContent = "q 10 0 0 10 0 0 cm BI /W 2 /H 1 /CS /RGB /BPC 8 ID \xFF\x00\x00\nEI EI Q\n" +
+	"q 10 0 0 10 10 0 cm BI /W 4 /H 1 /CS /G /BPC 8 /F /AHx ID 00FF80FF> EI Q\n" +
+	"q 10 0 0 10 20 0 cm BI /W 2 /H 1 /CS /Pal /BPC 1 /I true ID @ EI Q\n" +
+	"1 0 0 rg q 10 0 0 10 30 0 cm BI /W 8 /H 1 /IM true /D [1 0] ID \xF0 EI Q\n" +
+	"q 1 0 0 1 40 0 cm /Fm1 Do Q"
+
+func inlineImagePDF() []byte {
+	form := "q 10 0 0 10 0 0 cm /Im1 Do Q"
+	return buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 50 10] /Contents 4 0 R " +
+			"/Resources << /ColorSpace << /Pal [/Indexed /DeviceRGB 1 <00FF000000FF>] >> /XObject << /Fm1 5 0 R >> >> >>",
+		"<< /Length " + formatInt(len(inlineImageContent)) + " >>\nstream\n" + inlineImageContent + "\nendstream",
+		"<< /Type /XObject /Subtype /Form /BBox [0 0 10 10] /Resources << /XObject << /Im1 6 0 R >> >> /Length " +
+			formatInt(len(form)) + " >>\nstream\n" + form + "\nendstream",
+		imageStream("/Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x40"),
+	})
+}
+
+func TestInlineImageParsing(t *testing.T) {
+	ops, err := pdf.NewContentStreamParser([]byte(inlineImageContent)).ParseOperations()
+	if err != nil {
+		t.Fatalf("ParseOperations failed: %v", err)
+	}
+	var images []pdf.Stream
+	var operators []string
+	for _, op := range ops {
+		operators = append(operators, op.Operator)
+		if op.Operator == "BI" {
+			images = append(images, op.Operands[0].(pdf.Stream))
+		}
+	}
+	if len(images) != 4 {
+		t.Fatalf("got %d inline images, operators %v", len(images), operators)
+	}
+	if len(ops) != 21 {
+		t.Errorf("got %d operations: %v", len(ops), operators)
+	}
+
+	rgb := images[0]
+	if w, _ := rgb.Dictionary.GetInt("Width"); w != 2 {
+		t.Errorf("Width = %d", w)
+	}
+	if cs, _ := rgb.Dictionary.GetName("ColorSpace"); cs != "DeviceRGB" {
+		t.Errorf("ColorSpace = %s", cs)
+	}
+	if string(rgb.Data) != "\xFF\x00\x00\nEI" {
+		t.Errorf("RGB data = %q", rgb.Data)
+	}
+	if f, _ := images[1].Dictionary.GetName("Filter"); f != "ASCIIHexDecode" {
+		t.Errorf("Filter = %s", f)
+	}
+	if string(images[1].Data) != "00FF80FF>" {
+		t.Errorf("filtered data = %q", images[1].Data)
+	}
+	if string(images[2].Data) != "@" || !pdf.IsImageMask(images[3].Dictionary) {
+		t.Errorf("indexed data %q, stencil %v", images[2].Data, images[3].Dictionary)
+	}
+
+	// Lexer-based loops see the data as one string operand of EI
+	lexer := pdf.NewLexerFromBytes([]byte("BI /W 1 /H 1 ID \x00(\xFF EI Q"))
+	var kinds []pdf.TokenType
+	for {
+		tok, err := lexer.NextToken()
+		if err != nil || tok.Type == pdf.TokenEOF {
+			break
+		}
+		kinds = append(kinds, tok.Type)
+	}
+	want := []pdf.TokenType{pdf.TokenName, pdf.TokenName, pdf.TokenInteger, pdf.TokenName, pdf.TokenInteger,
+		pdf.TokenName, pdf.TokenString, pdf.TokenName, pdf.TokenName}
+	if len(kinds) != len(want) {
+		t.Fatalf("lexer tokens = %v", kinds)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Errorf("lexer token %d = %v, want %v", i, kinds[i], want[i])
+		}
+	}
+}
+
+func TestInlineImageExtraction(t *testing.T) {
+	doc, err := pdf.NewDocument(inlineImagePDF())
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	extractor := pdf.NewImageExtractor(doc)
+	images, err := extractor.ExtractImages(1, 1)
+	if err != nil || len(images) != 5 {
+		t.Fatalf("ExtractImages = %d images, %v", len(images), err)
+	}
+	for i, img := range images[:4] {
+		if !img.Inline || img.Index != i {
+			t.Errorf("image %d: inline %v, index %d", i, img.Inline, img.Index)
+		}
+	}
+	if images[2].ColorSpace != "index" || images[3].Type != "stencil" {
+		t.Errorf("images listed as %s and %s", images[2].ColorSpace, images[3].Type)
+	}
+	form := images[4]
+	if form.Inline || form.ObjectNum != 6 || form.Name != "Im1" {
+		t.Errorf("form image: inline %v, object %d, name %q", form.Inline, form.ObjectNum, form.Name)
+	}
+	if images[0].XPPI != 14 || form.XPPI != 7 {
+		t.Errorf("PPI = %d, %d", images[0].XPPI, form.XPPI)
+	}
+
+	data, err := extractor.GetImageData(images[0], "png")
+	if err != nil {
+		t.Fatalf("GetImageData failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	if r, g, b, _ := img.At(1, 0).RGBA(); r>>8 != 10 || g>>8 != 'E' || b>>8 != 'I' {
+		t.Errorf("inline pixel = %d %d %d", r>>8, g>>8, b>>8)
+	}
+	data, err = extractor.GetImageData(images[2], "png")
+	if err != nil {
+		t.Fatalf("GetImageData(indexed) failed: %v", err)
+	}
+	if img, err = png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	if r, g, b, _ := img.At(1, 0).RGBA(); r != 0 || g != 0 || b != 0xFFFF {
+		t.Errorf("indexed inline pixel = %04x %04x %04x", r, g, b)
+	}
+}
+
+func TestInlineImageRendering(t *testing.T) {
+	doc, err := pdf.NewDocument(inlineImagePDF())
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	white, red := [3]uint8{255, 255, 255}, [3]uint8{255, 0, 0}
+	tests := []struct {
+		x    int
+		want [3]uint8
+	}{
+		{2, red}, {7, [3]uint8{10, 'E', 'I'}},
+		{11, [3]uint8{0, 0, 0}}, {16, [3]uint8{128, 128, 128}},
+		{22, [3]uint8{0, 255, 0}}, {27, [3]uint8{0, 0, 255}},
+		{32, red}, {37, white},
+		{45, [3]uint8{64, 64, 64}},
+	}
+
+	renderer := pdf.NewRenderer(doc)
+	renderer.SetResolution(72, 72)
+	page, err := renderer.RenderPage(1)
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	rendered, err := pdf.NewPageRenderer(doc, pdf.RenderOptions{DPI: 72}).RenderPage(1)
+	if err != nil {
+		t.Fatalf("PageRenderer.RenderPage failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(rendered.Data))
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	for _, tt := range tests {
+		i := 3 * (5*page.Width + tt.x)
+		if got := [3]uint8{page.Data[i], page.Data[i+1], page.Data[i+2]}; got != tt.want {
+			t.Errorf("Renderer x=%d: %v, want %v", tt.x, got, tt.want)
+		}
+		r, g, b, _ := img.At(tt.x, 5).RGBA()
+		if got := [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}; got != tt.want {
+			t.Errorf("PageRenderer x=%d: %v, want %v", tt.x, got, tt.want)
+		}
+	}
+}
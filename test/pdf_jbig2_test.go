@@ -0,0 +1,873 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// testBitmap is a bilevel image with 1 meaning black
+type testBitmap struct {
+	w, h int
+	pix  []int
+}
+
+func newTestBitmap(w, h int, rows ...string) *testBitmap {
+	b := &testBitmap{w: w, h: h, pix: make([]int, w*h)}
+	for y, row := range rows {
+		for x, c := range row {
+			if c == '#' {
+				b.pix[y*w+x] = 1
+			}
+		}
+	}
+	return b
+}
+
+func (b *testBitmap) get(x, y int) int {
+	if x < 0 || y < 0 || x >= b.w || y >= b.h {
+		return 0
+	}
+	return b.pix[y*b.w+x]
+}
+
+// draw ORs src into b at (x, y)
+func (b *testBitmap) draw(src *testBitmap, x, y int) {
+	for sy := 0; sy < src.h; sy++ {
+		for sx := 0; sx < src.w; sx++ {
+			if src.get(sx, sy) == 1 && x+sx >= 0 && y+sy >= 0 && x+sx < b.w && y+sy < b.h {
+				b.pix[(y+sy)*b.w+x+sx] = 1
+			}
+		}
+	}
+}
+
+// packed returns the rows of b packed MSB first
+func (b *testBitmap) packed() []byte {
+	stride := (b.w + 7) / 8
+	out := make([]byte, stride*b.h)
+	for y := 0; y < b.h; y++ {
+		for x := 0; x < b.w; x++ {
+			if b.get(x, y) == 1 {
+				out[y*stride+x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	return out
+}
+
+// testPattern returns a w x h bitmap with a reproducible pattern
+func testPattern(w, h, seed int) *testBitmap {
+	b := &testBitmap{w: w, h: h, pix: make([]int, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x*7+y*13+seed*5)%11 < 4 || (x/4+y/3+seed)%3 == 0 {
+				b.pix[y*w+x] = 1
+			}
+		}
+	}
+	return b
+}
+
+// jbig2GenericCtx mirrors the context templates of T.88 6.2.5.3
+func jbig2GenericCtx(b *testBitmap, x, y, template int, at [8]int) int {
+	g := b.get
+	switch template {
+	case 0:
+		return g(x-1, y) | g(x-2, y)<<1 | g(x-3, y)<<2 | g(x-4, y)<<3 |
+			g(x+at[0], y+at[1])<<4 |
+			g(x+2, y-1)<<5 | g(x+1, y-1)<<6 | g(x, y-1)<<7 | g(x-1, y-1)<<8 | g(x-2, y-1)<<9 |
+			g(x+at[2], y+at[3])<<10 | g(x+at[4], y+at[5])<<11 |
+			g(x+1, y-2)<<12 | g(x, y-2)<<13 | g(x-1, y-2)<<14 |
+			g(x+at[6], y+at[7])<<15
+	case 1:
+		return g(x-1, y) | g(x-2, y)<<1 | g(x-3, y)<<2 |
+			g(x+at[0], y+at[1])<<3 |
+			g(x+2, y-1)<<4 | g(x+1, y-1)<<5 | g(x, y-1)<<6 | g(x-1, y-1)<<7 | g(x-2, y-1)<<8 |
+			g(x+2, y-2)<<9 | g(x+1, y-2)<<10 | g(x, y-2)<<11 | g(x-1, y-2)<<12
+	case 2:
+		return g(x-1, y) | g(x-2, y)<<1 |
+			g(x+at[0], y+at[1])<<2 |
+			g(x+1, y-1)<<3 | g(x, y-1)<<4 | g(x-1, y-1)<<5 |
+			g(x+1, y-2)<<6 | g(x, y-2)<<7 | g(x-1, y-2)<<8
+	}
+	return g(x-1, y) | g(x-2, y)<<1 | g(x-3, y)<<2 | g(x-4, y)<<3 |
+		g(x+at[0], y+at[1])<<4 |
+		g(x+1, y-1)<<5 | g(x, y-1)<<6 | g(x-1, y-1)<<7 | g(x-2, y-1)<<8 | g(x-3, y-1)<<9
+}
+
+var jbig2DefaultAT = [8]int{3, -1, -3, -1, 2, -2, -2, -2}
+
+// encodeGeneric codes b as a generic region (6.2.5.7)
+func encodeGeneric(e *mqEncoder, cx []mqCtx, b *testBitmap, template int, at [8]int, tpgdon bool) {
+	sltp := [4]int{0x9B25, 0x0795, 0x00E5, 0x0195}[template]
+	ltp := 0
+	for y := 0; y < b.h; y++ {
+		if tpgdon {
+			typical := 1
+			for x := 0; x < b.w; x++ {
+				if b.get(x, y) != b.get(x, y-1) {
+					typical = 0
+					break
+				}
+			}
+			e.encode(typical^ltp, &cx[sltp])
+			ltp = typical
+			if ltp == 1 {
+				continue
+			}
+		}
+		for x := 0; x < b.w; x++ {
+			e.encode(b.get(x, y), &cx[jbig2GenericCtx(b, x, y, template, at)])
+		}
+	}
+}
+
+// encodeRefinement codes b against ref (6.3.5.6)
+func encodeRefinement(e *mqEncoder, cx []mqCtx, b, ref *testBitmap, template, dx, dy int, at [4]int, tpgron bool) {
+	ctx := func(x, y int) int {
+		g, r := b.get, ref.get
+		rx, ry := x-dx, y-dy
+		if template == 0 {
+			return g(x-1, y) | g(x+1, y-1)<<1 | g(x, y-1)<<2 | g(x+at[0], y+at[1])<<3 |
+				r(rx+1, ry+1)<<4 | r(rx, ry+1)<<5 | r(rx-1, ry+1)<<6 |
+				r(rx+1, ry)<<7 | r(rx, ry)<<8 | r(rx-1, ry)<<9 |
+				r(rx+1, ry-1)<<10 | r(rx, ry-1)<<11 | r(rx+at[2], ry+at[3])<<12
+		}
+		return g(x-1, y) | g(x+1, y-1)<<1 | g(x, y-1)<<2 | g(x-1, y-1)<<3 |
+			r(rx+1, ry+1)<<4 | r(rx, ry+1)<<5 | r(rx+1, ry)<<6 | r(rx, ry)<<7 | r(rx-1, ry)<<8 |
+			r(rx, ry-1)<<9
+	}
+	uniform := func(x, y int) (int, bool) {
+		v := ref.get(x, y)
+		for j := -1; j <= 1; j++ {
+			for i := -1; i <= 1; i++ {
+				if ref.get(x+i, y+j) != v {
+					return 0, false
+				}
+			}
+		}
+		return v, true
+	}
+	sltp := 0x0010
+	if template == 1 {
+		sltp = 0x0008
+	}
+	ltp := 0
+	for y := 0; y < b.h; y++ {
+		if tpgron {
+			typical := 1
+			for x := 0; x < b.w; x++ {
+				if v, ok := uniform(x-dx, y-dy); ok && b.get(x, y) != v {
+					typical = 0
+				}
+			}
+			e.encode(typical^ltp, &cx[sltp])
+			ltp = typical
+		}
+		for x := 0; x < b.w; x++ {
+			if ltp == 1 {
+				if _, ok := uniform(x-dx, y-dy); ok {
+					continue
+				}
+			}
+			e.encode(b.get(x, y), &cx[ctx(x, y)])
+		}
+	}
+}
+
+// intEncoder is the arithmetic integer encoder matching T.88 A.2
+type intEncoder struct{ cx [512]mqCtx }
+
+func (ie *intEncoder) bits(e *mqEncoder, prev *int, v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b := (v >> i) & 1
+		e.encode(b, &ie.cx[*prev])
+		if *prev < 256 {
+			*prev = *prev<<1 | b
+		} else {
+			*prev = (*prev<<1|b)&511 | 256
+		}
+	}
+}
+
+func (ie *intEncoder) encode(e *mqEncoder, v int) {
+	prev := 1
+	s, a := 0, v
+	if v < 0 {
+		s, a = 1, -v
+	}
+	ie.bits(e, &prev, s, 1)
+	switch {
+	case a < 4:
+		ie.bits(e, &prev, 0, 1)
+		ie.bits(e, &prev, a, 2)
+	case a < 20:
+		ie.bits(e, &prev, 2, 2)
+		ie.bits(e, &prev, a-4, 4)
+	case a < 84:
+		ie.bits(e, &prev, 6, 3)
+		ie.bits(e, &prev, a-20, 6)
+	case a < 340:
+		ie.bits(e, &prev, 14, 4)
+		ie.bits(e, &prev, a-84, 8)
+	case a < 4436:
+		ie.bits(e, &prev, 30, 5)
+		ie.bits(e, &prev, a-340, 12)
+	default:
+		ie.bits(e, &prev, 31, 5)
+		ie.bits(e, &prev, a-4436, 32)
+	}
+}
+
+func (ie *intEncoder) oob(e *mqEncoder) {
+	prev := 1
+	ie.bits(e, &prev, 1, 1)
+	ie.bits(e, &prev, 0, 3)
+}
+
+// idEncoder codes symbol IDs (A.3)
+type idEncoder struct {
+	n  int
+	cx []mqCtx
+}
+
+func newIDEncoder(n int) *idEncoder { return &idEncoder{n: n, cx: make([]mqCtx, 1<<n)} }
+
+func (ie *idEncoder) encode(e *mqEncoder, id int) {
+	prev := 1
+	for i := ie.n - 1; i >= 0; i-- {
+		b := (id >> i) & 1
+		e.encode(b, &ie.cx[prev])
+		prev = prev<<1 | b
+	}
+}
+
+// textEncoder holds the integer coders of a text region
+type textEncoder struct {
+	iadt, iafs, iads, iait, iari, iardw, iardh, iardx, iardy intEncoder
+	iaid                                                     *idEncoder
+	gr                                                       []mqCtx
+}
+
+// jbig2Segment builds a segment with a short-form header
+func jbig2Segment(number uint32, typ byte, refs []uint32, page byte, data []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, number)
+	buf.WriteByte(typ)
+	buf.WriteByte(byte(len(refs)) << 5)
+	for _, r := range refs {
+		buf.WriteByte(byte(r))
+	}
+	buf.WriteByte(page)
+	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// jbig2PageInfo builds the data of a page information segment
+func jbig2PageInfo(w, h uint32) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, w)
+	binary.Write(&buf, binary.BigEndian, h)
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	buf.WriteByte(0)
+	binary.Write(&buf, binary.BigEndian, uint16(0))
+	return buf.Bytes()
+}
+
+// jbig2RegionInfo builds a region segment information field
+func jbig2RegionInfo(w, h, x, y int, op byte) []byte {
+	var buf bytes.Buffer
+	for _, v := range []int{w, h, x, y} {
+		binary.Write(&buf, binary.BigEndian, uint32(v))
+	}
+	buf.WriteByte(op)
+	return buf.Bytes()
+}
+
+func atBytes(at []int) []byte {
+	out := make([]byte, len(at))
+	for i, v := range at {
+		out[i] = byte(int8(v))
+	}
+	return out
+}
+
+// decodeJBIG2 decodes an embedded stream and checks the page bitmap
+func decodeJBIG2(t *testing.T, name string, data []byte, globals []byte, want *testBitmap) {
+	t.Helper()
+	params := pdf.Dictionary{}
+	if globals != nil {
+		params["JBIG2Globals"] = pdf.Stream{Dictionary: pdf.Dictionary{}, Data: globals}
+	}
+	got, w, h, err := pdf.JBIG2Decode(data, params)
+	if err != nil {
+		t.Fatalf("%s: JBIG2Decode failed: %v", name, err)
+	}
+	if w != want.w || h != want.h {
+		t.Fatalf("%s: size = %dx%d, want %dx%d", name, w, h, want.w, want.h)
+	}
+	if !bytes.Equal(got, want.packed()) {
+		t.Errorf("%s: bitmap mismatch\n got %x\nwant %x", name, got, want.packed())
+	}
+}
+
+func TestJBIG2GenericRegion(t *testing.T) {
+	w, h := 37, 23
+	bm := testPattern(w, h, 1)
+	// Repeated rows exercise typical prediction
+	copy(bm.pix[5*w:6*w], bm.pix[4*w:5*w])
+	copy(bm.pix[6*w:7*w], bm.pix[4*w:5*w])
+	for i := 17 * w; i < 19*w; i++ {
+		bm.pix[i] = 0
+	}
+
+	ats := map[int][8]int{
+		0: {2, -2, -4, -1, 1, -2, -3, -2},
+		1: {3, -1},
+		2: {2, -1},
+		3: {-1, -2},
+	}
+	for template := 0; template < 4; template++ {
+		for _, tpgdon := range []bool{false, true} {
+			at := ats[template]
+			e := newMQEncoder()
+			cx := make([]mqCtx, 1<<16)
+			encodeGeneric(e, cx, bm, template, at, tpgdon)
+			coded := e.flush()
+
+			flags := byte(template << 1)
+			if tpgdon {
+				flags |= 0x08
+			}
+			region := jbig2RegionInfo(w, h, 0, 0, 0)
+			region = append(region, flags)
+			if template == 0 {
+				region = append(region, atBytes(at[:])...)
+			} else {
+				region = append(region, atBytes(at[:2])...)
+			}
+			region = append(region, coded...)
+
+			var stream []byte
+			stream = append(stream, jbig2Segment(0, 48, nil, 1, jbig2PageInfo(uint32(w), uint32(h)))...)
+			stream = append(stream, jbig2Segment(1, 38, nil, 1, region)...)
+			stream = append(stream, jbig2Segment(2, 49, nil, 1, nil)...)
+			decodeJBIG2(t, "generic", stream, nil, bm)
+		}
+	}
+}
+
+func TestJBIG2RefinementRegion(t *testing.T) {
+	w, h := 20, 12
+	ref := testPattern(w, h, 2)
+	target := testPattern(w, h, 2)
+	for _, i := range []int{3, 40, 41, 77, 150, 151, 152, 230} {
+		target.pix[i] ^= 1
+	}
+	// A uniform area lets typical prediction skip pixels
+	for y := 6; y < 12; y++ {
+		for x := 0; x < 8; x++ {
+			ref.pix[y*w+x], target.pix[y*w+x] = 1, 1
+		}
+	}
+
+	e := newMQEncoder()
+	encodeGeneric(e, make([]mqCtx, 1<<16), ref, 0, jbig2DefaultAT, false)
+	generic := append(jbig2RegionInfo(w, h, 0, 0, 0), 0)
+	generic = append(generic, atBytes(jbig2DefaultAT[:])...)
+	generic = append(generic, e.flush()...)
+
+	rat := [4]int{-1, -1, -1, -1}
+	e = newMQEncoder()
+	encodeRefinement(e, make([]mqCtx, 1<<13), target, ref, 0, 0, 0, rat, true)
+	refine := append(jbig2RegionInfo(w, h, 3, 2, 0), 0x02)
+	refine = append(refine, atBytes(rat[:])...)
+	refine = append(refine, e.flush()...)
+
+	var stream []byte
+	stream = append(stream, jbig2Segment(0, 48, nil, 1, jbig2PageInfo(26, 16))...)
+	stream = append(stream, jbig2Segment(1, 36, nil, 1, generic)...)
+	stream = append(stream, jbig2Segment(2, 42, []uint32{1}, 1, refine)...)
+	stream = append(stream, jbig2Segment(3, 49, nil, 1, nil)...)
+
+	want := &testBitmap{w: 26, h: 16, pix: make([]int, 26*16)}
+	want.draw(target, 3, 2)
+	decodeJBIG2(t, "refinement", stream, nil, want)
+}
+
+func TestJBIG2MMR(t *testing.T) {
+	// Row 0: horizontal mode (white 2, black 3) then V0; row 1: V0 x3
+	coded := []byte{0x2F, 0x78}
+	want := newTestBitmap(8, 2, "..###...", "..###...")
+
+	region := append(jbig2RegionInfo(8, 2, 0, 0, 0), 0x01)
+	region = append(region, coded...)
+	var stream []byte
+	stream = append(stream, jbig2Segment(0, 48, nil, 1, jbig2PageInfo(8, 2))...)
+	stream = append(stream, jbig2Segment(1, 38, nil, 1, region)...)
+	decodeJBIG2(t, "mmr", stream, nil, want)
+
+	// Unknown data length: the region ends with 00 00 and a row count
+	region = append(jbig2RegionInfo(8, 0xFFFFFFFF, 0, 0, 0), 0x01)
+	region = append(region, coded...)
+	region = append(region, 0, 0, 0, 0, 0, 2)
+	seg := jbig2Segment(1, 38, nil, 1, region)
+	binary.BigEndian.PutUint32(seg[len(seg)-len(region)-4:], 0xFFFFFFFF)
+	stream = jbig2Segment(0, 48, nil, 1, jbig2PageInfo(8, 2))
+	stream = append(stream, seg...)
+	stream = append(stream, jbig2Segment(2, 49, nil, 1, nil)...)
+	decodeJBIG2(t, "mmr unknown length", stream, nil, want)
+}
+
+// TestJBIG2ExternalMMR decodes a generic region whose MMR data was written
+// by libtiff (T.88 6.2.6 codes it as T.6) against the source bitmap
+func TestJBIG2ExternalMMR(t *testing.T) {
+	dir := filepath.Join("testdata", "ccitt")
+	coded, err := os.ReadFile(filepath.Join(dir, "bw-gopher.ccitt_group4"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	f, err := os.Open(filepath.Join(dir, "bw-gopher.png"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	src, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	want := newTestBitmap(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if r, _, _, _ := src.At(x, y).RGBA(); r < 0x8000 {
+				want.pix[y*w+x] = 1
+			}
+		}
+	}
+
+	region := append(jbig2RegionInfo(w, h, 0, 0, 0), 0x01)
+	region = append(region, coded...)
+	stream := jbig2Segment(0, 48, nil, 1, jbig2PageInfo(uint32(w), uint32(h)))
+	stream = append(stream, jbig2Segment(1, 38, nil, 1, region)...)
+	decodeJBIG2(t, "gopher", stream, nil, want)
+}
+
+func TestJBIG2SymbolsAndText(t *testing.T) {
+	symA := testPattern(5, 4, 3)
+	symB := testPattern(3, 4, 4)
+	symC := testPattern(4, 6, 5)
+
+	// Dictionary 1: generic coded symbols in two height classes
+	e := newMQEncoder()
+	gb := make([]mqCtx, 1<<16)
+	var iadh, iadw, iaex intEncoder
+	iadh.encode(e, 4)
+	iadw.encode(e, 5)
+	encodeGeneric(e, gb, symA, 0, jbig2DefaultAT, false)
+	iadw.encode(e, -2)
+	encodeGeneric(e, gb, symB, 0, jbig2DefaultAT, false)
+	iadw.oob(e)
+	iadh.encode(e, 2)
+	iadw.encode(e, 4)
+	encodeGeneric(e, gb, symC, 0, jbig2DefaultAT, false)
+	iadw.oob(e)
+	iaex.encode(e, 0)
+	iaex.encode(e, 3)
+	dict1 := []byte{0x00, 0x00}
+	dict1 = append(dict1, atBytes(jbig2DefaultAT[:])...)
+	dict1 = binary.BigEndian.AppendUint32(dict1, 3)
+	dict1 = binary.BigEndian.AppendUint32(dict1, 3)
+	dict1 = append(dict1, e.flush()...)
+
+	// Dictionary 2: D refines A, E aggregates B and A
+	symD := testPattern(5, 4, 3)
+	symD.pix[0] ^= 1
+	symD.pix[7] ^= 1
+	symE := &testBitmap{w: 9, h: 4, pix: make([]int, 36)}
+	symE.draw(symB, 0, 0)
+	symE.draw(symA, 4, 0)
+
+	e = newMQEncoder()
+	te := &textEncoder{iaid: newIDEncoder(3), gr: make([]mqCtx, 1<<10)}
+	var iaai intEncoder
+	iadh, iadw, iaex = intEncoder{}, intEncoder{}, intEncoder{}
+	iadh.encode(e, 4)
+	iadw.encode(e, 5)
+	iaai.encode(e, 1)
+	te.iaid.encode(e, 0)
+	te.iardx.encode(e, 0)
+	te.iardy.encode(e, 0)
+	encodeRefinement(e, te.gr, symD, symA, 1, 0, 0, [4]int{}, false)
+	iadw.encode(e, 4)
+	iaai.encode(e, 2)
+	te.iadt.encode(e, 0) // initial STRIPT
+	te.iadt.encode(e, 0)
+	te.iafs.encode(e, 0)
+	te.iaid.encode(e, 1) // B
+	te.iari.encode(e, 0)
+	te.iads.encode(e, 2)
+	te.iaid.encode(e, 0) // A
+	te.iari.encode(e, 0)
+	te.iads.oob(e)
+	iadw.oob(e)
+	iaex.encode(e, 3)
+	iaex.encode(e, 2)
+	dict2 := []byte{0x10, 0x02} // SDREFAGG, SDRTEMPLATE 1
+	dict2 = append(dict2, atBytes(jbig2DefaultAT[:])...)
+	dict2 = binary.BigEndian.AppendUint32(dict2, 2)
+	dict2 = binary.BigEndian.AppendUint32(dict2, 2)
+	dict2 = append(dict2, e.flush()...)
+
+	// Text region with two strips; the last instance is refined
+	symF := testPattern(5, 4, 3)
+	symF.pix[0] ^= 1
+	symF.pix[7] ^= 1
+	symF.pix[2] ^= 1
+	e = newMQEncoder()
+	te = &textEncoder{iaid: newIDEncoder(1), gr: make([]mqCtx, 1<<10)}
+	te.iadt.encode(e, 0)
+	te.iadt.encode(e, 0)
+	te.iafs.encode(e, 2)
+	te.iait.encode(e, 1)
+	te.iaid.encode(e, 0) // D at (2, 1)
+	te.iari.encode(e, 0)
+	te.iads.encode(e, 10-(2+4))
+	te.iait.encode(e, 0)
+	te.iaid.encode(e, 1) // E at (10, 0)
+	te.iari.encode(e, 0)
+	te.iads.oob(e)
+	te.iadt.encode(e, 3)
+	te.iafs.encode(e, -1)
+	te.iait.encode(e, 1)
+	te.iaid.encode(e, 0) // F refines D at (1, 7)
+	te.iari.encode(e, 1)
+	te.iardw.encode(e, 0)
+	te.iardh.encode(e, 0)
+	te.iardx.encode(e, 0)
+	te.iardy.encode(e, 0)
+	encodeRefinement(e, te.gr, symF, symD, 1, 0, 0, [4]int{}, false)
+	te.iads.oob(e)
+	text := jbig2RegionInfo(20, 12, 1, 1, 0)
+	text = binary.BigEndian.AppendUint16(text, 0x8000|1<<4|1<<2|0x02) // SBRTEMPLATE 1, TOPLEFT, 2 strips, SBREFINE
+	text = binary.BigEndian.AppendUint32(text, 3)
+	text = append(text, e.flush()...)
+
+	var globals []byte
+	globals = append(globals, jbig2Segment(0, 0, nil, 0, dict1)...)
+	globals = append(globals, jbig2Segment(1, 0, []uint32{0}, 0, dict2)...)
+	var stream []byte
+	stream = append(stream, jbig2Segment(2, 48, nil, 1, jbig2PageInfo(22, 14))...)
+	stream = append(stream, jbig2Segment(3, 6, []uint32{1}, 1, text)...)
+	stream = append(stream, jbig2Segment(4, 49, nil, 1, nil)...)
+
+	want := &testBitmap{w: 22, h: 14, pix: make([]int, 22*14)}
+	want.draw(symD, 3, 2)
+	want.draw(symE, 11, 1)
+	want.draw(symF, 2, 8)
+	decodeJBIG2(t, "symbols", stream, globals, want)
+}
+
+// bitWriter writes bits MSB first
+type bitWriter struct {
+	out []byte
+	n   int
+}
+
+func (w *bitWriter) write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.out = append(w.out, 0)
+		}
+		if (v>>i)&1 == 1 {
+			w.out[len(w.out)-1] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) align() { w.n = (w.n + 7) / 8 * 8 }
+
+// hufLine is a line of a Huffman table; kind is 'l' for the lower range,
+// 'o' for out-of-band
+type hufLine struct {
+	low, pref, rng int
+	kind           byte
+}
+
+type hufTable struct {
+	lines []hufLine
+	codes []int
+}
+
+func newHufTable(lines ...hufLine) *hufTable {
+	t := &hufTable{lines: lines, codes: make([]int, len(lines))}
+	maxLen := 0
+	for _, l := range lines {
+		maxLen = max(maxLen, l.pref)
+	}
+	count := make([]int, maxLen+1)
+	for _, l := range lines {
+		count[l.pref]++
+	}
+	first := 0
+	for n := 1; n <= maxLen; n++ {
+		if n > 1 {
+			first = (first + count[n-1]) << 1
+		}
+		code := first
+		for i, l := range lines {
+			if l.pref == n {
+				t.codes[i] = code
+				code++
+			}
+		}
+	}
+	return t
+}
+
+func (t *hufTable) encode(w *bitWriter, v int) {
+	for i, l := range t.lines {
+		switch {
+		case l.kind == 'o':
+		case l.kind == 'l' && v <= l.low:
+			w.write(uint64(t.codes[i]), l.pref)
+			w.write(uint64(l.low-v), 32)
+			return
+		case l.kind == 0 && v >= l.low && (l.rng == 32 || v < l.low+1<<l.rng):
+			w.write(uint64(t.codes[i]), l.pref)
+			w.write(uint64(v-l.low), l.rng)
+			return
+		}
+	}
+	panic("value not in table")
+}
+
+func (t *hufTable) oob(w *bitWriter) {
+	for i, l := range t.lines {
+		if l.kind == 'o' {
+			w.write(uint64(t.codes[i]), l.pref)
+			return
+		}
+	}
+	panic("table without OOB")
+}
+
+var (
+	hufB1 = newHufTable(hufLine{0, 1, 4, 0}, hufLine{16, 2, 8, 0}, hufLine{272, 3, 16, 0}, hufLine{65808, 3, 32, 0})
+	hufB2 = newHufTable(hufLine{0, 1, 0, 0}, hufLine{1, 2, 0, 0}, hufLine{2, 3, 0, 0}, hufLine{3, 4, 3, 0},
+		hufLine{11, 5, 6, 0}, hufLine{75, 6, 32, 0}, hufLine{0, 6, 0, 'o'})
+	hufB4 = newHufTable(hufLine{1, 1, 0, 0}, hufLine{2, 2, 0, 0}, hufLine{3, 3, 0, 0}, hufLine{4, 4, 3, 0},
+		hufLine{12, 5, 6, 0}, hufLine{76, 5, 32, 0})
+	hufB6 = newHufTable(hufLine{-2048, 5, 10, 0}, hufLine{-1024, 4, 9, 0}, hufLine{-512, 4, 8, 0},
+		hufLine{-256, 4, 7, 0}, hufLine{-128, 5, 6, 0}, hufLine{-64, 5, 5, 0}, hufLine{-32, 4, 5, 0},
+		hufLine{0, 2, 7, 0}, hufLine{128, 3, 7, 0}, hufLine{256, 3, 8, 0}, hufLine{512, 4, 9, 0},
+		hufLine{1024, 4, 10, 0}, hufLine{-2049, 6, 32, 'l'}, hufLine{2048, 6, 32, 0})
+	hufB8 = newHufTable(hufLine{-15, 8, 3, 0}, hufLine{-7, 9, 1, 0}, hufLine{-5, 8, 1, 0}, hufLine{-3, 9, 0, 0},
+		hufLine{-2, 7, 0, 0}, hufLine{-1, 4, 0, 0}, hufLine{0, 2, 1, 0}, hufLine{2, 5, 0, 0}, hufLine{3, 6, 0, 0},
+		hufLine{4, 3, 4, 0}, hufLine{20, 6, 1, 0}, hufLine{22, 4, 4, 0}, hufLine{38, 4, 5, 0},
+		hufLine{70, 5, 6, 0}, hufLine{134, 5, 7, 0}, hufLine{262, 6, 7, 0}, hufLine{390, 7, 8, 0},
+		hufLine{646, 6, 10, 0}, hufLine{-16, 9, 32, 'l'}, hufLine{1670, 9, 32, 0}, hufLine{0, 2, 0, 'o'})
+)
+
+func TestJBIG2Huffman(t *testing.T) {
+	symA := testPattern(2, 3, 6)
+	symB := testPattern(4, 3, 7)
+
+	// Dictionary with an uncompressed collective bitmap
+	w := &bitWriter{}
+	hufB4.encode(w, 3)
+	hufB2.encode(w, 2)
+	hufB2.encode(w, 2)
+	hufB2.oob(w)
+	hufB1.encode(w, 0)
+	w.align()
+	coll := &testBitmap{w: 6, h: 3, pix: make([]int, 18)}
+	coll.draw(symA, 0, 0)
+	coll.draw(symB, 2, 0)
+	w.out = append(w.out, coll.packed()...)
+	w.n = len(w.out) * 8
+	hufB1.encode(w, 0)
+	hufB1.encode(w, 2)
+	dict := []byte{0x00, 0x01}
+	dict = binary.BigEndian.AppendUint32(dict, 2)
+	dict = binary.BigEndian.AppendUint32(dict, 2)
+	dict = append(dict, w.out...)
+
+	// Custom table for DT: 0 to 7 in one line, then lower and upper lines
+	table := []byte{0x01<<4 | 0x01<<1}
+	table = binary.BigEndian.AppendUint32(table, 0)
+	table = binary.BigEndian.AppendUint32(table, 8)
+	tw := &bitWriter{}
+	tw.write(1, 2) // PREFLEN
+	tw.write(3, 2) // RANGELEN
+	tw.write(2, 2) // lower PREFLEN
+	tw.write(2, 2) // upper PREFLEN
+	table = append(table, tw.out...)
+	dt := newHufTable(hufLine{0, 1, 3, 0}, hufLine{-1, 2, 32, 'l'}, hufLine{8, 2, 32, 0})
+
+	// Text region: symbol ID table with run code 1 of length 1, then
+	// both symbols with 1-bit codes
+	w = &bitWriter{}
+	for i := 0; i < 35; i++ {
+		if i == 1 {
+			w.write(1, 4)
+		} else {
+			w.write(0, 4)
+		}
+	}
+	w.write(0, 1)
+	w.write(0, 1)
+	w.align()
+	dt.encode(w, 0)
+	dt.encode(w, 1)
+	hufB6.encode(w, 1)
+	w.write(1, 1) // B at (1, 1)
+	hufB8.encode(w, 2)
+	w.write(0, 1) // A at (1+3+2, 1)
+	hufB8.oob(w)
+	text := jbig2RegionInfo(10, 5, 0, 0, 0)
+	text = binary.BigEndian.AppendUint16(text, 1<<4|0x01) // TOPLEFT, SBHUFF
+	text = binary.BigEndian.AppendUint16(text, 3<<4)      // custom DT
+	text = binary.BigEndian.AppendUint32(text, 2)
+	text = append(text, w.out...)
+
+	var stream []byte
+	stream = append(stream, jbig2Segment(0, 48, nil, 1, jbig2PageInfo(10, 5))...)
+	stream = append(stream, jbig2Segment(1, 0, nil, 1, dict)...)
+	stream = append(stream, jbig2Segment(2, 53, nil, 1, table)...)
+	stream = append(stream, jbig2Segment(3, 7, []uint32{1, 2}, 1, text)...)
+	stream = append(stream, jbig2Segment(4, 49, nil, 1, nil)...)
+
+	want := &testBitmap{w: 10, h: 5, pix: make([]int, 50)}
+	want.draw(symB, 1, 1)
+	want.draw(symA, 6, 1)
+	decodeJBIG2(t, "huffman", stream, nil, want)
+}
+
+func TestJBIG2Halftone(t *testing.T) {
+	patterns := []*testBitmap{
+		newTestBitmap(2, 2, "..", ".."),
+		newTestBitmap(2, 2, "#.", ".."),
+		newTestBitmap(2, 2, "#.", ".#"),
+		newTestBitmap(2, 2, "##", "##"),
+	}
+	coll := &testBitmap{w: 8, h: 2, pix: make([]int, 16)}
+	for i, p := range patterns {
+		coll.draw(p, 2*i, 0)
+	}
+	at := [8]int{-2, 0, -3, -1, 2, -2, -2, -2}
+	e := newMQEncoder()
+	encodeGeneric(e, make([]mqCtx, 1<<16), coll, 0, at, false)
+	dict := []byte{0x00, 2, 2, 0, 0, 0, 3}
+	dict = append(dict, e.flush()...)
+
+	// Gray values of a 3x2 grid, coded as Gray-code bitplanes
+	gray := []int{0, 1, 3, 2, 3, 1}
+	e = newMQEncoder()
+	cx := make([]mqCtx, 1<<16)
+	for j := 1; j >= 0; j-- {
+		plane := &testBitmap{w: 3, h: 2, pix: make([]int, 6)}
+		for i, v := range gray {
+			plane.pix[i] = (v >> j) & 1
+			if j == 0 {
+				plane.pix[i] ^= (v >> 1) & 1
+			}
+		}
+		encodeGeneric(e, cx, plane, 0, jbig2DefaultAT, false)
+	}
+	region := jbig2RegionInfo(6, 4, 0, 0, 0)
+	region = append(region, 0x08) // HENABLESKIP
+	for _, v := range []uint32{3, 2, 0, 0} {
+		region = binary.BigEndian.AppendUint32(region, v)
+	}
+	region = binary.BigEndian.AppendUint16(region, 2*256)
+	region = binary.BigEndian.AppendUint16(region, 0)
+	region = append(region, e.flush()...)
+
+	var stream []byte
+	stream = append(stream, jbig2Segment(0, 48, nil, 1, jbig2PageInfo(6, 4))...)
+	stream = append(stream, jbig2Segment(1, 16, nil, 1, dict)...)
+	stream = append(stream, jbig2Segment(2, 22, []uint32{1}, 1, region)...)
+	stream = append(stream, jbig2Segment(3, 49, nil, 1, nil)...)
+
+	want := &testBitmap{w: 6, h: 4, pix: make([]int, 24)}
+	for i, v := range gray {
+		want.draw(patterns[v], 2*(i%3), 2*(i/3))
+	}
+	decodeJBIG2(t, "halftone", stream, nil, want)
+}
+
+func TestJBIG2InPDF(t *testing.T) {
+	w, h := 16, 8
+	bm := testPattern(w, h, 8)
+	e := newMQEncoder()
+	encodeGeneric(e, make([]mqCtx, 1<<16), bm, 0, jbig2DefaultAT, true)
+	region := append(jbig2RegionInfo(w, h, 0, 0, 0), 0x08)
+	region = append(region, atBytes(jbig2DefaultAT[:])...)
+	region = append(region, e.flush()...)
+
+	// The globals only carry the page information
+	globals := jbig2Segment(0, 48, nil, 1, jbig2PageInfo(uint32(w), uint32(h)))
+	stream := jbig2Segment(1, 38, nil, 1, region)
+
+	content := "q 16 0 0 8 0 0 cm /Im1 Do Q"
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 16 8] /Contents 4 0 R /Resources << /XObject << /Im1 5 0 R >> >> >>",
+		"<< /Length " + formatInt(len(content)) + " >>\nstream\n" + content + "\nendstream",
+		"<< /Type /XObject /Subtype /Image /Width 16 /Height 8 /ColorSpace /DeviceGray /BitsPerComponent 1 /Filter /JBIG2Decode /DecodeParms << /JBIG2Globals 6 0 R >> /Length " +
+			formatInt(len(stream)) + " >>\nstream\n" + string(stream) + "\nendstream",
+		"<< /Length " + formatInt(len(globals)) + " >>\nstream\n" + string(globals) + "\nendstream",
+	})
+	doc, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+
+	extractor := pdf.NewImageExtractor(doc)
+	images, err := extractor.ExtractImages(1, 1)
+	if err != nil || len(images) != 1 {
+		t.Fatalf("ExtractImages = %d images, %v", len(images), err)
+	}
+	pngData, err := extractor.GetImageData(images[0], "png")
+	if err != nil {
+		t.Fatalf("GetImageData failed: %v", err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, _, _, _ := decoded.At(x, y).RGBA()
+			if black := r == 0; black != (bm.get(x, y) == 1) {
+				t.Fatalf("extracted pixel (%d, %d) black = %v", x, y, black)
+			}
+		}
+	}
+
+	renderer := pdf.NewRenderer(doc)
+	renderer.SetResolution(72, 72)
+	page, err := renderer.RenderPage(1)
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := page.Data[3*(y*w+x)]
+			if black := v < 128; black != (bm.get(x, y) == 1) {
+				t.Fatalf("rendered pixel (%d, %d) = %d", x, y, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,1065 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// Most tests below encode images with a small JPEG 2000 encoder written
+// from ITU-T T.800 and check that the decoder reconstructs them.
+// TestJPEG2000External checks the decoder against a file from another
+// encoder.
+
+// mqTable is the MQ coder probability estimation table (Table C.2):
+// Qe, NMPS, NLPS and SWITCH
+var mqTable = [47][4]uint32{
+	{0x5601, 1, 1, 1}, {0x3401, 2, 6, 0}, {0x1801, 3, 9, 0}, {0x0AC1, 4, 12, 0},
+	{0x0521, 5, 29, 0}, {0x0221, 38, 33, 0}, {0x5601, 7, 6, 1}, {0x5401, 8, 14, 0},
+	{0x4801, 9, 14, 0}, {0x3801, 10, 14, 0}, {0x3001, 11, 17, 0}, {0x2401, 12, 18, 0},
+	{0x1C01, 13, 20, 0}, {0x1601, 29, 21, 0}, {0x5601, 15, 14, 1}, {0x5401, 16, 14, 0},
+	{0x5101, 17, 15, 0}, {0x4801, 18, 16, 0}, {0x3801, 19, 17, 0}, {0x3401, 20, 18, 0},
+	{0x3001, 21, 19, 0}, {0x2801, 22, 19, 0}, {0x2401, 23, 20, 0}, {0x2201, 24, 21, 0},
+	{0x1C01, 25, 22, 0}, {0x1801, 26, 23, 0}, {0x1601, 27, 24, 0}, {0x1401, 28, 25, 0},
+	{0x1201, 29, 26, 0}, {0x1101, 30, 27, 0}, {0x0AC1, 31, 28, 0}, {0x09C1, 32, 29, 0},
+	{0x08A1, 33, 30, 0}, {0x0521, 34, 31, 0}, {0x0441, 35, 32, 0}, {0x02A1, 36, 33, 0},
+	{0x0221, 37, 34, 0}, {0x0141, 38, 35, 0}, {0x0111, 39, 36, 0}, {0x0085, 40, 37, 0},
+	{0x0049, 41, 38, 0}, {0x0025, 42, 39, 0}, {0x0015, 43, 40, 0}, {0x0009, 44, 41, 0},
+	{0x0005, 45, 42, 0}, {0x0001, 45, 43, 0}, {0x5601, 46, 46, 0},
+}
+
+type mqCtx struct{ index, mps int }
+
+// mqEncoder is the MQ encoder of Annex C; out[0] stands for the byte
+// before the first output byte
+type mqEncoder struct {
+	a, c uint32
+	ct   int
+	out  []byte
+}
+
+func newMQEncoder() *mqEncoder {
+	return &mqEncoder{a: 0x8000, ct: 12, out: []byte{0}}
+}
+
+func (e *mqEncoder) encode(bit int, cx *mqCtx) {
+	s := mqTable[cx.index]
+	qe := s[0]
+	e.a -= qe
+	if bit == cx.mps {
+		if e.a&0x8000 != 0 {
+			e.c += qe
+			return
+		}
+		if e.a < qe {
+			e.a = qe
+		} else {
+			e.c += qe
+		}
+		cx.index = int(s[1])
+	} else {
+		if e.a < qe {
+			e.c += qe
+		} else {
+			e.a = qe
+		}
+		if s[3] == 1 {
+			cx.mps = 1 - cx.mps
+		}
+		cx.index = int(s[2])
+	}
+	for {
+		e.a <<= 1
+		e.c <<= 1
+		e.ct--
+		if e.ct == 0 {
+			e.byteOut()
+		}
+		if e.a&0x8000 != 0 {
+			break
+		}
+	}
+}
+
+func (e *mqEncoder) byteOut() {
+	last := len(e.out) - 1
+	if e.out[last] == 0xFF {
+		e.emit(20, 0xFFFFF, 7)
+		return
+	}
+	if e.c < 0x8000000 {
+		e.emit(19, 0x7FFFF, 8)
+		return
+	}
+	e.out[last]++
+	if e.out[last] == 0xFF {
+		e.c &= 0x7FFFFFF
+		e.emit(20, 0xFFFFF, 7)
+		return
+	}
+	e.emit(19, 0x7FFFF, 8)
+}
+
+func (e *mqEncoder) emit(shift uint, mask uint32, ct int) {
+	e.out = append(e.out, byte(e.c>>shift))
+	e.c &= mask
+	e.ct = ct
+}
+
+func (e *mqEncoder) flush() []byte {
+	temp := e.c + e.a
+	e.c |= 0xFFFF
+	if e.c >= temp {
+		e.c -= 0x8000
+	}
+	e.c <<= uint(e.ct)
+	e.byteOut()
+	e.c <<= uint(e.ct)
+	e.byteOut()
+	out := e.out[1:]
+	if len(out) > 0 && out[len(out)-1] == 0xFF {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// t1Encoder codes one code-block with all passes in a single segment
+type t1Encoder struct {
+	w, h   int
+	kind   int
+	q      []int
+	sig    []bool // with a border of one sample
+	neg    []bool
+	visit  []bool
+	refine []bool
+	ctx    [19]mqCtx
+	mq     *mqEncoder
+}
+
+func (t *t1Encoder) idx(x, y int) int { return (y+1)*(t.w+2) + x + 1 }
+
+func (t *t1Encoder) bit(x, y, p int) int {
+	v := t.q[y*t.w+x]
+	if v < 0 {
+		v = -v
+	}
+	return v >> p & 1
+}
+
+func (t *t1Encoder) zeroContext(x, y int) int {
+	i, s := t.idx(x, y), t.w+2
+	n := func(j int) int {
+		if t.sig[j] {
+			return 1
+		}
+		return 0
+	}
+	h := n(i-1) + n(i+1)
+	v := n(i-s) + n(i+s)
+	d := n(i-s-1) + n(i-s+1) + n(i+s-1) + n(i+s+1)
+	if t.kind == 1 {
+		h, v = v, h
+	}
+	if t.kind == 3 {
+		hv := h + v
+		switch {
+		case d >= 3:
+			return 8
+		case d == 2 && hv >= 1:
+			return 7
+		case d == 2:
+			return 6
+		case d == 1 && hv >= 2:
+			return 5
+		case d == 1 && hv == 1:
+			return 4
+		case d == 1:
+			return 3
+		case hv >= 2:
+			return 2
+		}
+		return hv
+	}
+	switch {
+	case h == 2:
+		return 8
+	case h == 1 && v >= 1:
+		return 7
+	case h == 1 && d >= 1:
+		return 6
+	case h == 1:
+		return 5
+	case v == 2:
+		return 4
+	case v == 1:
+		return 3
+	case d >= 2:
+		return 2
+	}
+	return d
+}
+
+func (t *t1Encoder) encodeSign(x, y int) {
+	i, s := t.idx(x, y), t.w+2
+	contrib := func(j int) int {
+		switch {
+		case !t.sig[j]:
+			return 0
+		case t.neg[j]:
+			return -1
+		}
+		return 1
+	}
+	clamp := func(v int) int { return max(-1, min(1, v)) }
+	h := clamp(contrib(i-1) + contrib(i+1))
+	v := clamp(contrib(i-s) + contrib(i+s))
+	// Table D.3
+	table := map[[2]int][2]int{
+		{1, 1}: {13, 0}, {1, 0}: {12, 0}, {1, -1}: {11, 0},
+		{0, 1}: {10, 0}, {0, 0}: {9, 0}, {0, -1}: {10, 1},
+		{-1, 1}: {11, 1}, {-1, 0}: {12, 1}, {-1, -1}: {13, 1},
+	}
+	e := table[[2]int{h, v}]
+	sign := 0
+	if t.q[y*t.w+x] < 0 {
+		sign = 1
+	}
+	t.mq.encode(sign^e[1], &t.ctx[e[0]])
+	t.sig[i] = true
+	t.neg[i] = sign == 1
+}
+
+// encode returns the coded data and number of passes for numbps planes
+func (t *t1Encoder) encode(numbps int) ([]byte, int) {
+	n := (t.w + 2) * (t.h + 2)
+	t.sig, t.neg = make([]bool, n), make([]bool, n)
+	t.visit, t.refine = make([]bool, n), make([]bool, n)
+	t.ctx[0] = mqCtx{4, 0}
+	t.ctx[17] = mqCtx{3, 0}
+	t.ctx[18] = mqCtx{46, 0}
+	t.mq = newMQEncoder()
+	passes := 0
+	for p := numbps - 1; p >= 0; p-- {
+		if p < numbps-1 {
+			t.significancePass(p)
+			t.refinementPass(p)
+			passes += 2
+		}
+		t.cleanupPass(p)
+		passes++
+	}
+	return t.mq.flush(), passes
+}
+
+func (t *t1Encoder) significancePass(p int) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			for y := y0; y < y0+4 && y < t.h; y++ {
+				i := t.idx(x, y)
+				if t.sig[i] {
+					continue
+				}
+				ctx := t.zeroContext(x, y)
+				if ctx == 0 {
+					continue
+				}
+				t.visit[i] = true
+				b := t.bit(x, y, p)
+				t.mq.encode(b, &t.ctx[ctx])
+				if b == 1 {
+					t.encodeSign(x, y)
+				}
+			}
+		}
+	}
+}
+
+func (t *t1Encoder) refinementPass(p int) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			for y := y0; y < y0+4 && y < t.h; y++ {
+				i := t.idx(x, y)
+				if !t.sig[i] || t.visit[i] {
+					continue
+				}
+				ctx := 16
+				if !t.refine[i] {
+					ctx = 14
+					s := t.w + 2
+					for _, j := range []int{i - 1, i + 1, i - s, i + s, i - s - 1, i - s + 1, i + s - 1, i + s + 1} {
+						if t.sig[j] {
+							ctx = 15
+						}
+					}
+				}
+				t.mq.encode(t.bit(x, y, p), &t.ctx[ctx])
+				t.refine[i] = true
+			}
+		}
+	}
+}
+
+func (t *t1Encoder) cleanupPass(p int) {
+	for y0 := 0; y0 < t.h; y0 += 4 {
+		for x := 0; x < t.w; x++ {
+			y := y0
+			if y0+4 <= t.h {
+				run := true
+				for k := 0; k < 4; k++ {
+					i := t.idx(x, y0+k)
+					if t.sig[i] || t.visit[i] || t.zeroContext(x, y0+k) != 0 {
+						run = false
+					}
+				}
+				if run {
+					k := 0
+					for k < 4 && t.bit(x, y0+k, p) == 0 {
+						k++
+					}
+					if k == 4 {
+						t.mq.encode(0, &t.ctx[17])
+						continue
+					}
+					t.mq.encode(1, &t.ctx[17])
+					t.mq.encode(k>>1, &t.ctx[18])
+					t.mq.encode(k&1, &t.ctx[18])
+					t.encodeSign(x, y0+k)
+					y = y0 + k + 1
+				}
+			}
+			for ; y < y0+4 && y < t.h; y++ {
+				i := t.idx(x, y)
+				if t.sig[i] || t.visit[i] {
+					continue
+				}
+				b := t.bit(x, y, p)
+				t.mq.encode(b, &t.ctx[t.zeroContext(x, y)])
+				if b == 1 {
+					t.encodeSign(x, y)
+				}
+			}
+		}
+	}
+	for i := range t.visit {
+		t.visit[i] = false
+	}
+}
+
+// headerWriter writes packet header bits with bit stuffing after 0xFF
+type headerWriter struct {
+	out        []byte
+	cur, n, mx int
+}
+
+func (w *headerWriter) bit(b int) {
+	if w.mx == 0 {
+		w.mx = 8
+	}
+	w.cur = w.cur<<1 | b
+	w.n++
+	if w.n == w.mx {
+		w.out = append(w.out, byte(w.cur))
+		w.mx = 8
+		if w.cur == 0xFF {
+			w.mx = 7
+		}
+		w.cur, w.n = 0, 0
+	}
+}
+
+func (w *headerWriter) bits(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.bit(v >> i & 1)
+	}
+}
+
+func (w *headerWriter) flush() []byte {
+	for w.n > 0 {
+		w.bit(0)
+	}
+	if len(w.out) > 0 && w.out[len(w.out)-1] == 0xFF {
+		w.out = append(w.out, 0)
+	}
+	return w.out
+}
+
+// tagTree is a tag tree encoder (B.10.2)
+type tagTree struct {
+	widths []int
+	value  [][]int
+	low    [][]int
+	known  [][]bool
+}
+
+func newTagTree(w, h int, leaves []int) *tagTree {
+	t := &tagTree{}
+	cur := leaves
+	for {
+		t.widths = append(t.widths, w)
+		t.value = append(t.value, cur)
+		t.low = append(t.low, make([]int, len(cur)))
+		t.known = append(t.known, make([]bool, len(cur)))
+		if w <= 1 && h <= 1 {
+			break
+		}
+		pw, ph := (w+1)/2, (h+1)/2
+		parent := make([]int, pw*ph)
+		for i := range parent {
+			parent[i] = math.MaxInt32
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				j := (y/2)*pw + x/2
+				parent[j] = min(parent[j], cur[y*w+x])
+			}
+		}
+		cur, w, h = parent, pw, ph
+	}
+	return t
+}
+
+func (t *tagTree) encode(hw *headerWriter, x, y, threshold int) {
+	low := 0
+	for i := len(t.widths) - 1; i >= 0; i-- {
+		idx := (y>>i)*t.widths[i] + x>>i
+		if low > t.low[i][idx] {
+			t.low[i][idx] = low
+		} else {
+			low = t.low[i][idx]
+		}
+		for low < threshold {
+			if low >= t.value[i][idx] {
+				if !t.known[i][idx] {
+					hw.bit(1)
+					t.known[i][idx] = true
+				}
+				break
+			}
+			hw.bit(0)
+			low++
+		}
+		t.low[i][idx] = low
+	}
+}
+
+// j2kBand is a subband of a tile-component during encoding
+type j2kBand struct {
+	kind   int
+	w, h   int
+	q      []int
+	mb     int
+	exp    int
+	mant   int
+	coeffs []float64
+}
+
+// j2kParams are the coding parameters of the test encoder
+type j2kParams struct {
+	tileW, tileH int
+	levels       int
+	cb           int // code-block size exponent
+	reversible   bool
+	mct          bool
+	guard        int
+}
+
+// forward1D performs the forward wavelet transform of a signal whose
+// first sample has an even coordinate, leaving it interleaved
+func forward1D(x []float64, reversible bool) {
+	n := len(x)
+	if n == 1 {
+		return
+	}
+	at := func(i int) float64 {
+		if i < 0 {
+			i = -i
+		}
+		if i >= n {
+			i = 2*(n-1) - i
+		}
+		return x[i]
+	}
+	lift := func(start int, c float64) {
+		for i := start; i < n; i += 2 {
+			x[i] += c * (at(i-1) + at(i+1))
+		}
+	}
+	if reversible {
+		for i := 1; i < n; i += 2 {
+			x[i] -= math.Floor((at(i-1) + at(i+1)) / 2)
+		}
+		for i := 0; i < n; i += 2 {
+			x[i] += math.Floor((at(i-1) + at(i+1) + 2) / 4)
+		}
+		return
+	}
+	lift(1, -1.586134342059924)
+	lift(0, -0.052980118572961)
+	lift(1, 0.882911075530934)
+	lift(0, 0.443506852043971)
+	const k = 1.230174104914001
+	for i := range x {
+		if i%2 == 0 {
+			x[i] /= k
+		} else {
+			x[i] *= k
+		}
+	}
+}
+
+// decompose splits samples into subbands, lowest resolution first
+func decompose(samples []float64, w, h, levels int, reversible bool) []*j2kBand {
+	var high [][]*j2kBand
+	for l := 0; l < levels; l++ {
+		col := make([]float64, h)
+		for x := 0; x < w; x++ {
+			for y := 0; y < h; y++ {
+				col[y] = samples[y*w+x]
+			}
+			forward1D(col, reversible)
+			for y := 0; y < h; y++ {
+				samples[y*w+x] = col[y]
+			}
+		}
+		for y := 0; y < h; y++ {
+			forward1D(samples[y*w:(y+1)*w], reversible)
+		}
+		lw, lh := (w+1)/2, (h+1)/2
+		bands := make([]*j2kBand, 4)
+		for kind := 0; kind < 4; kind++ {
+			xo, yo := kind&1, kind>>1
+			b := &j2kBand{kind: kind, w: lw, h: lh}
+			if xo == 1 {
+				b.w = w / 2
+			}
+			if yo == 1 {
+				b.h = h / 2
+			}
+			b.coeffs = make([]float64, b.w*b.h)
+			for y := 0; y < b.h; y++ {
+				for x := 0; x < b.w; x++ {
+					b.coeffs[y*b.w+x] = samples[(2*y+yo)*w+2*x+xo]
+				}
+			}
+			bands[kind] = b
+		}
+		high = append([][]*j2kBand{bands[1:]}, high...)
+		samples, w, h = bands[0].coeffs, lw, lh
+	}
+	out := []*j2kBand{{kind: 0, w: w, h: h, coeffs: samples}}
+	for _, hb := range high {
+		out = append(out, hb...)
+	}
+	return out
+}
+
+// encodeJ2K encodes 8-bit components into a codestream with one layer,
+// LRCP progression and one precinct per resolution
+func encodeJ2K(planes [][]uint8, w, h int, p j2kParams) []byte {
+	var cs bytes.Buffer
+	u16 := func(v int) { binary.Write(&cs, binary.BigEndian, uint16(v)) }
+	u32 := func(v int) { binary.Write(&cs, binary.BigEndian, uint32(v)) }
+	nc := len(planes)
+
+	u16(0xFF4F)
+	u16(0xFF51)
+	u16(38 + 3*nc)
+	u16(0)
+	u32(w)
+	u32(h)
+	u32(0)
+	u32(0)
+	u32(p.tileW)
+	u32(p.tileH)
+	u32(0)
+	u32(0)
+	u16(nc)
+	for range planes {
+		cs.Write([]byte{7, 1, 1})
+	}
+
+	transform := 0
+	if p.reversible {
+		transform = 1
+	}
+	mct := 0
+	if p.mct {
+		mct = 1
+	}
+	u16(0xFF52)
+	u16(12)
+	cs.Write([]byte{0, 0, 0, 1, byte(mct), byte(p.levels), byte(p.cb - 2), byte(p.cb - 2), 0, byte(transform)})
+
+	// Band exponents: the dynamic range for reversible coding, or a step
+	// size of 1/8 for irreversible coding
+	nbands := 3*p.levels + 1
+	exps := make([]int, nbands)
+	for i := range exps {
+		gain := 0
+		if i > 0 {
+			gain = [3]int{1, 1, 2}[(i-1)%3]
+		}
+		exps[i] = 9 + gain
+		if !p.reversible {
+			exps[i] = 8 + gain + 3
+		}
+	}
+	u16(0xFF5C)
+	if p.reversible {
+		u16(3 + nbands)
+		cs.WriteByte(byte(p.guard << 5))
+		for _, e := range exps {
+			cs.WriteByte(byte(e << 3))
+		}
+	} else {
+		u16(3 + 2*nbands)
+		cs.WriteByte(byte(p.guard<<5 | 2))
+		for _, e := range exps {
+			u16(e << 11)
+		}
+	}
+
+	ntx, nty := (w+p.tileW-1)/p.tileW, (h+p.tileH-1)/p.tileH
+	for ty := 0; ty < nty; ty++ {
+		for tx := 0; tx < ntx; tx++ {
+			x0, y0 := tx*p.tileW, ty*p.tileH
+			x1, y1 := min(x0+p.tileW, w), min(y0+p.tileH, h)
+			tw, th := x1-x0, y1-y0
+
+			comps := make([][]float64, nc)
+			for c := range planes {
+				comps[c] = make([]float64, tw*th)
+				for y := 0; y < th; y++ {
+					for x := 0; x < tw; x++ {
+						comps[c][y*tw+x] = float64(planes[c][(y0+y)*w+x0+x]) - 128
+					}
+				}
+			}
+			if p.mct {
+				for i := range comps[0] {
+					r, g, b := comps[0][i], comps[1][i], comps[2][i]
+					if p.reversible {
+						comps[0][i] = math.Floor((r + 2*g + b) / 4)
+						comps[1][i], comps[2][i] = b-g, r-g
+					} else {
+						comps[0][i] = 0.299*r + 0.587*g + 0.114*b
+						comps[1][i] = -0.16875*r - 0.33126*g + 0.5*b
+						comps[2][i] = 0.5*r - 0.41869*g - 0.08131*b
+					}
+				}
+			}
+
+			var body bytes.Buffer
+			bandsOf := make([][]*j2kBand, nc)
+			for c := range comps {
+				bandsOf[c] = decompose(comps[c], tw, th, p.levels, p.reversible)
+				for i, b := range bandsOf[c] {
+					b.exp = exps[i]
+					b.mb = p.guard + b.exp - 1
+					gain := [4]int{0, 1, 1, 2}[b.kind]
+					delta := math.Ldexp(1, 8+gain-b.exp)
+					if p.reversible {
+						delta = 1
+					}
+					b.q = make([]int, len(b.coeffs))
+					for j, v := range b.coeffs {
+						q := int(math.Floor(math.Abs(v) / delta))
+						if v < 0 {
+							q = -q
+						}
+						b.q[j] = q
+					}
+				}
+			}
+			for r := 0; r <= p.levels; r++ {
+				for c := 0; c < nc; c++ {
+					bands := bandsOf[c][:1]
+					if r > 0 {
+						bands = bandsOf[c][3*r-2 : 3*r+1]
+					}
+					body.Write(encodePacket(bands, p.cb))
+				}
+			}
+
+			u16(0xFF90)
+			u16(10)
+			u16(ty*ntx + tx)
+			u32(14 + body.Len())
+			cs.Write([]byte{0, 1})
+			u16(0xFF93)
+			cs.Write(body.Bytes())
+		}
+	}
+	u16(0xFFD9)
+	return cs.Bytes()
+}
+
+// encodePacket codes the code-blocks of the given bands of one resolution
+func encodePacket(bands []*j2kBand, cb int) []byte {
+	hw := &headerWriter{}
+	var data bytes.Buffer
+	empty := true
+	for _, b := range bands {
+		if b.w > 0 && b.h > 0 {
+			empty = false
+		}
+	}
+	if empty {
+		hw.bit(0)
+		return hw.flush()
+	}
+	hw.bit(1)
+	size := 1 << cb
+	for _, b := range bands {
+		if b.w == 0 || b.h == 0 {
+			continue
+		}
+		cw, ch := (b.w+size-1)/size, (b.h+size-1)/size
+		included := make([]int, cw*ch)
+		zero := make([]int, cw*ch)
+		coded := make([][]byte, cw*ch)
+		passes := make([]int, cw*ch)
+		for j := 0; j < ch; j++ {
+			for i := 0; i < cw; i++ {
+				bw, bh := min(size, b.w-i*size), min(size, b.h-j*size)
+				t := &t1Encoder{w: bw, h: bh, kind: b.kind, q: make([]int, bw*bh)}
+				maxMag := 0
+				for y := 0; y < bh; y++ {
+					for x := 0; x < bw; x++ {
+						v := b.q[(j*size+y)*b.w+i*size+x]
+						t.q[y*bw+x] = v
+						if v < 0 {
+							v = -v
+						}
+						maxMag = max(maxMag, v)
+					}
+				}
+				numbps := 0
+				for maxMag>>numbps > 0 {
+					numbps++
+				}
+				k := j*cw + i
+				zero[k] = b.mb - numbps
+				if numbps == 0 {
+					included[k] = 1
+					continue
+				}
+				coded[k], passes[k] = t.encode(numbps)
+			}
+		}
+		inclusion := newTagTree(cw, ch, included)
+		zeroPlanes := newTagTree(cw, ch, zero)
+		for k := range coded {
+			x, y := k%cw, k/cw
+			inclusion.encode(hw, x, y, 1)
+			if included[k] != 0 {
+				continue
+			}
+			zeroPlanes.encode(hw, x, y, zero[k]+1)
+			n := passes[k]
+			switch {
+			case n == 1:
+				hw.bit(0)
+			case n == 2:
+				hw.bits(2, 2)
+			case n <= 5:
+				hw.bits(0xC|(n-3), 4)
+			case n <= 36:
+				hw.bits(0x1E0|(n-6), 9)
+			default:
+				hw.bits(0xFF80|(n-37), 16)
+			}
+			lblock := 3
+			floorLog := 0
+			for n>>(floorLog+1) > 0 {
+				floorLog++
+			}
+			length := len(coded[k])
+			for length >= 1<<(lblock+floorLog) {
+				hw.bit(1)
+				lblock++
+			}
+			hw.bit(0)
+			hw.bits(length, lblock+floorLog)
+			data.Write(coded[k])
+		}
+	}
+	return append(hw.flush(), data.Bytes()...)
+}
+
+// testPlanes returns a deterministic RGB test pattern
+func testPlanes(w, h int) [][]uint8 {
+	planes := [][]uint8{make([]uint8, w*h), make([]uint8, w*h), make([]uint8, w*h)}
+	seed := uint32(1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1103515245 + 12345
+			noise := int(seed>>16) % 32
+			i := y*w + x
+			planes[0][i] = uint8(x * 255 / w)
+			planes[1][i] = uint8(min(255, y*255/h+noise))
+			planes[2][i] = uint8((x*y + noise) % 256)
+			if (x/5+y/5)%2 == 0 {
+				planes[2][i] = 255 - planes[2][i]
+			}
+		}
+	}
+	return planes
+}
+
+// maxError compares decoded 16-bit channels with 8-bit originals
+func maxError(t *testing.T, img *pdf.JPXImage, planes [][]uint8) int {
+	t.Helper()
+	if len(img.Color) != len(planes) {
+		t.Fatalf("got %d colour channels, want %d", len(img.Color), len(planes))
+	}
+	worst := 0
+	for c, plane := range planes {
+		for i, v := range plane {
+			d := int(img.Color[c][i]>>8) - int(v)
+			if d < 0 {
+				d = -d
+			}
+			worst = max(worst, d)
+		}
+	}
+	return worst
+}
+
+func TestJPEG2000Reversible(t *testing.T) {
+	w, h := 45, 37
+	planes := testPlanes(w, h)
+	for _, p := range []j2kParams{
+		{tileW: 64, tileH: 64, levels: 0, cb: 6, reversible: true, guard: 2},
+		{tileW: 64, tileH: 64, levels: 3, cb: 4, reversible: true, guard: 2},
+		{tileW: 32, tileH: 16, levels: 2, cb: 3, reversible: true, mct: true, guard: 2},
+	} {
+		data := encodeJ2K(planes, w, h, p)
+		img, err := pdf.DecodeJPX(data)
+		if err != nil {
+			t.Fatalf("DecodeJPX(%+v) failed: %v", p, err)
+		}
+		if img.Width != w || img.Height != h {
+			t.Fatalf("size = %dx%d, want %dx%d", img.Width, img.Height, w, h)
+		}
+		if e := maxError(t, img, planes); e != 0 {
+			t.Errorf("lossless decode with %+v differs by up to %d", p, e)
+		}
+	}
+}
+
+func TestJPEG2000Irreversible(t *testing.T) {
+	w, h := 40, 30
+	planes := testPlanes(w, h)
+	for _, p := range []j2kParams{
+		{tileW: 40, tileH: 30, levels: 2, cb: 4, guard: 2},
+		{tileW: 16, tileH: 16, levels: 3, cb: 5, mct: true, guard: 2},
+	} {
+		img, err := pdf.DecodeJPX(encodeJ2K(planes, w, h, p))
+		if err != nil {
+			t.Fatalf("DecodeJPX(%+v) failed: %v", p, err)
+		}
+		if e := maxError(t, img, planes); e > 2 {
+			t.Errorf("9-7 decode with %+v differs by up to %d", p, e)
+		}
+	}
+}
+
+// jp2Box returns a JP2 box
+func jp2Box(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, typ...), body...)
+}
+
+// jp2File wraps a codestream with the given header boxes
+func jp2File(w, h, nc int, codestream []byte, boxes ...[]byte) []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, uint32(h))
+	ihdr = binary.BigEndian.AppendUint32(ihdr, uint32(w))
+	ihdr = binary.BigEndian.AppendUint16(ihdr, uint16(nc))
+	ihdr = append(ihdr, 7, 7, 0, 0)
+	header := append([][]byte{jp2Box("ihdr", ihdr)}, boxes...)
+	return bytes.Join([][]byte{
+		jp2Box("jP  ", []byte{0x0D, 0x0A, 0x87, 0x0A}),
+		jp2Box("ftyp", []byte("jp2 \x00\x00\x00\x00jp2 ")),
+		jp2Box("jp2h", header...),
+		jp2Box("jp2c", codestream),
+	}, nil)
+}
+
+func TestJPEG2000PaletteAndChannels(t *testing.T) {
+	w, h := 20, 12
+	params := j2kParams{tileW: w, tileH: h, levels: 1, cb: 4, reversible: true, guard: 2}
+
+	// Palette: one index component mapped to three colour columns
+	index := make([]uint8, w*h)
+	for i := range index {
+		index[i] = uint8(i % 3)
+	}
+	pclr := []byte{0, 3, 3, 7, 7, 7, 255, 0, 0, 0, 255, 0, 0, 0, 255}
+	cmap := []byte{0, 0, 1, 0, 0, 0, 1, 1, 0, 0, 1, 2}
+	colr := []byte{1, 0, 0, 0, 0, 0, 16}
+	data := jp2File(w, h, 1, encodeJ2K([][]uint8{index}, w, h, params),
+		jp2Box("colr", colr), jp2Box("pclr", pclr), jp2Box("cmap", cmap))
+	img, err := pdf.DecodeJPX(data)
+	if err != nil {
+		t.Fatalf("DecodeJPX failed: %v", err)
+	}
+	if len(img.Color) != 3 || img.ColorSpace != "sRGB" {
+		t.Fatalf("got %d channels in %q, want 3 in sRGB", len(img.Color), img.ColorSpace)
+	}
+	for i := range index {
+		for c := 0; c < 3; c++ {
+			want := uint16(0)
+			if int(index[i]) == c {
+				want = 0xFFFF
+			}
+			if img.Color[c][i] != want {
+				t.Fatalf("pixel %d channel %d = %#x, want %#x", i, c, img.Color[c][i], want)
+			}
+		}
+	}
+
+	// Channel definitions: opacity stored first, colours reversed
+	planes := testPlanes(w, h)
+	alpha := make([]uint8, w*h)
+	for i := range alpha {
+		alpha[i] = uint8(i * 7)
+	}
+	stored := [][]uint8{alpha, planes[2], planes[1], planes[0]}
+	cdef := []byte{0, 4, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 3, 0, 2, 0, 0, 0, 2, 0, 3, 0, 0, 0, 1}
+	data = jp2File(w, h, 4, encodeJ2K(stored, w, h, params), jp2Box("colr", colr), jp2Box("cdef", cdef))
+	img, err = pdf.DecodeJPX(data)
+	if err != nil {
+		t.Fatalf("DecodeJPX failed: %v", err)
+	}
+	if e := maxError(t, img, planes); e != 0 {
+		t.Errorf("channel definitions: colours differ by up to %d", e)
+	}
+	if img.Alpha == nil || img.Alpha[5]>>8 != uint16(alpha[5]) {
+		t.Errorf("channel definitions: opacity channel not decoded")
+	}
+
+	info, err := pdf.GetJPEG2000Info(data)
+	if err != nil || info.Width != w || info.Height != h || info.Components != 4 {
+		t.Errorf("GetJPEG2000Info = %+v, %v", info, err)
+	}
+}
+
+func TestJPEG2000InPDF(t *testing.T) {
+	w, h := 40, 30
+	planes := testPlanes(w, h)
+	stream := encodeJ2K(planes, w, h, j2kParams{tileW: 64, tileH: 64, levels: 2, cb: 4, reversible: true, mct: true, guard: 2})
+	content := "q 40 0 0 30 0 0 cm /Im1 Do Q"
+	data := buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 40 30] /Contents 4 0 R /Resources << /XObject << /Im1 5 0 R >> >> >>",
+		"<< /Length " + formatInt(len(content)) + " >>\nstream\n" + content + "\nendstream",
+		"<< /Type /XObject /Subtype /Image /Width 40 /Height 30 /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /JPXDecode /Length " +
+			formatInt(len(stream)) + " >>\nstream\n" + string(stream) + "\nendstream",
+	})
+	doc, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+
+	extractor := pdf.NewImageExtractor(doc)
+	images, err := extractor.ExtractImages(1, 1)
+	if err != nil || len(images) != 1 {
+		t.Fatalf("ExtractImages = %d images, %v", len(images), err)
+	}
+	pngData, err := extractor.GetImageData(images[0], "png")
+	if err != nil {
+		t.Fatalf("GetImageData failed: %v", err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatalf("png.Decode failed: %v", err)
+	}
+	for _, pt := range [][2]int{{0, 0}, {17, 9}, {39, 29}} {
+		r, g, b, _ := decoded.At(pt[0], pt[1]).RGBA()
+		i := pt[1]*w + pt[0]
+		if uint8(r>>8) != planes[0][i] || uint8(g>>8) != planes[1][i] || uint8(b>>8) != planes[2][i] {
+			t.Errorf("extracted pixel %v = %d,%d,%d, want %d,%d,%d", pt, r>>8, g>>8, b>>8, planes[0][i], planes[1][i], planes[2][i])
+		}
+	}
+
+	renderer := pdf.NewRenderer(doc)
+	renderer.SetResolution(72, 72)
+	page, err := renderer.RenderPage(1)
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	i := 9*w + 17
+	got := page.Data[3*i : 3*i+3]
+	if !strings.EqualFold(string(got), string([]byte{planes[0][i], planes[1][i], planes[2][i]})) {
+		t.Errorf("rendered pixel = %v, want %v", got, []byte{planes[0][i], planes[1][i], planes[2][i]})
+	}
+}
+
+// TestJPEG2000External decodes a JP2 file written by Kakadu. Without a
+// reference decoder at hand the expected pixels are the visible content
+// of the photograph, which a misread codestream would not reproduce: the
+// white caption "relax" over dark water, green moss and dark rock.
+func TestJPEG2000External(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "jpeg2000", "relax.jp2"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	img, err := pdf.DecodeJPX(data)
+	if err != nil {
+		t.Fatalf("DecodeJPX failed: %v", err)
+	}
+	if img.Width != 400 || img.Height != 300 || len(img.Color) != 3 || img.Depth != 8 || img.ICCProfile == nil {
+		t.Fatalf("decoded %dx%d with %d channels of %d bits, ICC %v",
+			img.Width, img.Height, len(img.Color), img.Depth, img.ICCProfile != nil)
+	}
+	mean := func(x0, y0, x1, y1 int) [3]int {
+		var sum [3]int
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				for c := range sum {
+					sum[c] += int(img.Color[c][y*img.Width+x] >> 8)
+				}
+			}
+		}
+		n := (x1 - x0) * (y1 - y0)
+		return [3]int{sum[0] / n, sum[1] / n, sum[2] / n}
+	}
+
+	// The stem of the "l" and the water right of it
+	if m := mean(104, 218, 112, 285); m[0] < 240 || m[1] < 240 || m[2] < 240 {
+		t.Errorf("stem of the l = %v, want white", m)
+	}
+	if m := mean(112, 250, 118, 285); m[0] > 100 || m[1] > 100 || m[2] > 100 {
+		t.Errorf("water beside the l = %v, want dark", m)
+	}
+	// Misty water, the moss bank and a rock in the stream
+	if m := mean(80, 140, 120, 180); m[0] < 200 || m[2] < m[0] {
+		t.Errorf("mist = %v, want bluish white", m)
+	}
+	if m := mean(260, 180, 340, 240); m[1] < m[0]+30 || m[1] < m[2]+30 {
+		t.Errorf("moss = %v, want green", m)
+	}
+	if m := mean(165, 35, 195, 55); m[0]+m[1]+m[2] > 200 {
+		t.Errorf("rock = %v, want dark", m)
+	}
+
+	// The ICC profile has zeroed colourants and is ignored, leaving the
+	// RGB channels as they are
+	rgba, err := pdf.DecodeJPEG2000(data)
+	if err != nil {
+		t.Fatalf("DecodeJPEG2000 failed: %v", err)
+	}
+	if r, g, b, _ := rgba.At(108, 250).RGBA(); r>>8 < 240 || g>>8 < 240 || b>>8 < 240 {
+		t.Errorf("DecodeJPEG2000 stem of the l = %d %d %d, want white", r>>8, g>>8, b>>8)
+	}
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// nativeImagesPDF paints a Flate-wrapped JPEG rotated by 90°, a JBIG2
+// image with globals, a CCITT image and an image stored as samples. The
+// JBIG2 and CCITT data are never decoded, so they need not be valid.
+func nativeImagesPDF(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	src := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 4)
+	}
+	src.Set(0, 0, color.Gray{255})
+	var jpg, flated bytes.Buffer
+	if err := jpeg.Encode(&jpg, src, nil); err != nil {
+		t.Fatalf("jpeg.Encode failed: %v", err)
+	}
+	zw := zlib.NewWriter(&flated)
+	zw.Write(jpg.Bytes())
+	zw.Close()
+
+	content := "q 0 20 -10 0 30 5 cm /Jpg Do Q q 10 0 0 10 40 0 cm /Jb2 Do Q q 10 0 0 10 50 0 cm /Fax Do Q q 10 0 0 10 60 0 cm /Raw Do Q"
+	return buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 80 30] /Contents 4 0 R " +
+			"/Resources << /XObject << /Jpg 5 0 R /Jb2 6 0 R /Fax 8 0 R /Raw 9 0 R >> >> >>",
+		"<< /Length " + formatInt(len(content)) + " >>\nstream\n" + content + "\nendstream",
+		imageStream("/Width 8 /Height 8 /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter [/FlateDecode /DCTDecode]", flated.String()),
+		imageStream("/Width 16 /Height 16 /ImageMask true /Filter /JBIG2Decode /DecodeParms << /JBIG2Globals 7 0 R >>", "page segments"),
+		"<< /Length 15 >>\nstream\nglobal segments\nendstream",
+		imageStream("/Width 16 /Height 4 /ColorSpace /DeviceGray /BitsPerComponent 1 /Filter [/CCITTFaxDecode] "+
+			"/DecodeParms [<< /K -1 /Columns 16 /BlackIs1 true >>]", "fax data"),
+		imageStream("/Width 1 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8", "\x80"),
+	}), jpg.Bytes()
+}
+
+func TestNativeImageExtraction(t *testing.T) {
+	data, jpg := nativeImagesPDF(t)
+	doc, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	extractor := pdf.NewImageExtractor(doc)
+	images, err := extractor.ExtractImages(1, 1)
+	if err != nil || len(images) != 4 {
+		t.Fatalf("ExtractImages = %d images, %v", len(images), err)
+	}
+	native := func(img *pdf.ImageInfo) *pdf.NativeImage {
+		t.Helper()
+		n, err := extractor.GetNativeImage(img)
+		if err != nil {
+			t.Fatalf("GetNativeImage(%s) failed: %v", img.Name, err)
+		}
+		return n
+	}
+
+	// Only the Flate wrapper is removed from the JPEG
+	if n := native(images[0]); n == nil || n.Ext != "jpg" || !bytes.Equal(n.Data, jpg) {
+		t.Errorf("native JPEG = %+v", n)
+	}
+	if raw, err := extractor.GetImageDataWithFormat(images[0], "native", 0); err != nil || !bytes.Equal(raw, jpg) {
+		t.Errorf("native format returned %d bytes, %v", len(raw), err)
+	}
+
+	if n := native(images[1]); n == nil || n.Ext != "jb2e" || string(n.Data) != "page segments" || string(n.Globals) != "global segments" {
+		t.Errorf("native JBIG2 = %+v", n)
+	}
+	if n := native(images[2]); n == nil || n.Ext != "ccitt" || string(n.Data) != "fax data" || n.Params != "-4 -P -X 16 -W -M\n" {
+		t.Errorf("native CCITT = %+v", n)
+	}
+	if n := native(images[3]); n != nil {
+		t.Errorf("sampled image has native form %+v", n)
+	}
+
+	// The placement follows the rotated CTM of the JPEG
+	p := images[0].Placement()
+	if p.CTM != [6]float64{0, 20, -10, 0, 30, 5} || p.Rotation != 90 {
+		t.Errorf("placement CTM %v, rotation %d", p.CTM, p.Rotation)
+	}
+	if p.BBox != [4]float64{20, 5, 30, 25} {
+		t.Errorf("placement bbox = %v", p.BBox)
+	}
+	if p.ScaleX != 20 || p.ScaleY != 10 || p.XPPI != 29 || p.YPPI != 58 {
+		t.Errorf("placement scale %v × %v, PPI %d × %d", p.ScaleX, p.ScaleY, p.XPPI, p.YPPI)
+	}
+	if p.ObjectNum != 5 || p.Name != "Jpg" || p.Page != 1 {
+		t.Errorf("placement identifies object %d %q on page %d", p.ObjectNum, p.Name, p.Page)
+	}
+}
@@ -0,0 +1,782 @@
+package test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+	"golang.org/x/crypto/ocsp"
+)
+
+// newTestSigner creates an RSA key with a self-signed certificate
+func newTestSigner(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(42),
+		Subject:               pkix.Name{CommonName: "Test Signer", Organization: []string{"go-poppler"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return key, cert
+}
+
+// stubTSA records the digest it was asked to timestamp
+type stubTSA struct {
+	digest []byte
+}
+
+func (s *stubTSA) Timestamp(digest []byte, hash crypto.Hash) ([]byte, error) {
+	s.digest = digest
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true})
+}
+
+// signedContents returns the byte range and decoded /Contents of the
+// last signature dictionary in data. The contents keep the zero padding,
+// which ASN.1 parsing ignores.
+func signedContents(t *testing.T, data []byte) ([4]int, []byte) {
+	t.Helper()
+	m := regexp.MustCompile(`/ByteRange \[(\d+) (\d+) (\d+) (\d+) *\]`).FindAllSubmatch(data, -1)
+	if len(m) == 0 {
+		t.Fatal("no /ByteRange in signed document")
+	}
+	var br [4]int
+	for i := range br {
+		br[i], _ = strconv.Atoi(string(m[len(m)-1][i+1]))
+	}
+	if br[0] != 0 || br[2]+br[3] != len(data) {
+		t.Fatalf("byte range %v does not cover the file of %d bytes", br, len(data))
+	}
+	contents, err := hex.DecodeString(string(data[br[1]+1 : br[2]-1]))
+	if err != nil {
+		t.Fatalf("decode /Contents: %v", err)
+	}
+	return br, contents
+}
+
+// TestSignDocument tests PAdES signing of a document
+func TestSignDocument(t *testing.T) {
+	key, cert := newTestSigner(t)
+	original := createFormPDF()
+	doc, err := pdf.NewDocument(original)
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	tsa := &stubTSA{}
+	signed, err := doc.Sign(pdf.SignOptions{
+		Signer:       key,
+		Certificates: []*x509.Certificate{cert},
+		Reason:       "Approval",
+		Location:     "Berlin",
+		Rect:         pdf.Rectangle{LLX: 100, LLY: 100, URX: 300, URY: 150},
+		TSA:          tsa,
+	})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !bytes.HasPrefix(signed, original) {
+		t.Fatal("signing did not append an incremental update")
+	}
+
+	br, contents := signedContents(t, signed)
+	if br[1] < len(original) || signed[br[1]] != '<' || signed[br[2]-1] != '>' {
+		t.Errorf("byte range %v does not exclude the /Contents string", br)
+	}
+
+	// Parse the CMS down to the signer info
+	var ci struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(contents, &ci); err != nil {
+		t.Fatalf("parse ContentInfo: %v", err)
+	}
+	var sd struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		EncapContentInfo asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+		SignerInfos      []struct {
+			Version            int
+			SID                asn1.RawValue
+			DigestAlgorithm    asn1.RawValue
+			SignedAttrs        asn1.RawValue `asn1:"tag:0"`
+			SignatureAlgorithm asn1.RawValue
+			Signature          []byte
+			UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+		} `asn1:"set"`
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatalf("parse SignedData: %v", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		t.Fatalf("expected one signer, got %d", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+
+	// The message digest attribute must match the signed byte ranges
+	h := sha256.New()
+	h.Write(signed[:br[1]])
+	h.Write(signed[br[2]:])
+	if !bytes.Contains(si.SignedAttrs.Bytes, h.Sum(nil)) {
+		t.Error("signed attributes do not contain the document digest")
+	}
+
+	// The signature covers the attributes re-tagged as a SET
+	attrs := append([]byte(nil), si.SignedAttrs.FullBytes...)
+	attrs[0] = 0x31
+	digest := sha256.Sum256(attrs)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], si.Signature); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+
+	sigDigest := sha256.Sum256(si.Signature)
+	if !bytes.Equal(tsa.digest, sigDigest[:]) {
+		t.Error("timestamp authority was not asked to stamp the signature value")
+	}
+	if len(si.UnsignedAttrs.Bytes) == 0 {
+		t.Error("signature has no timestamp attribute")
+	}
+
+	// The signature is visible to readers of the document
+	signedDoc, err := pdf.NewDocument(signed)
+	if err != nil {
+		t.Fatalf("Failed to reopen signed document: %v", err)
+	}
+	sigs := pdf.GetSignatures(signedDoc)
+	if len(sigs) != 1 {
+		t.Fatalf("expected 1 signature, got %d", len(sigs))
+	}
+	if sigs[0].SubFilter != pdf.SubFilterCAdESDetached || sigs[0].Reason != "Approval" || sigs[0].Signer != "Test Signer" {
+		t.Errorf("unexpected signature: %+v", sigs[0])
+	}
+	if sigs[0].Certificate == nil || !sigs[0].Certificate.Equal(cert) {
+		t.Error("signing certificate not embedded")
+	}
+
+	// A second signature reuses the same mechanism and keeps the first
+	if _, err := signedDoc.Sign(pdf.SignOptions{Signer: key, Certificates: []*x509.Certificate{cert}, FieldName: "Signature1"}); err == nil {
+		t.Error("expected an error when signing an already signed field")
+	}
+	twice, err := signedDoc.Sign(pdf.SignOptions{Signer: key, Certificates: []*x509.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("second Sign: %v", err)
+	}
+	twiceDoc, err := pdf.NewDocument(twice)
+	if err != nil {
+		t.Fatalf("Failed to reopen twice signed document: %v", err)
+	}
+	if n := len(pdf.GetSignatures(twiceDoc)); n != 2 {
+		t.Errorf("expected 2 signatures, got %d", n)
+	}
+}
+
+// testTSA is an in-memory RFC 3161 timestamp authority
+type testTSA struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+	time time.Time
+}
+
+func newTestTSA(t *testing.T, root *x509.Certificate, rootKey *rsa.PrivateKey) *testTSA {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "Test TSA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, &key.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testTSA{key: key, cert: cert, time: time.Now().UTC().Truncate(time.Second)}
+}
+
+type testAlgorithm struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type testAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+func (a *testTSA) Timestamp(digest []byte, hash crypto.Hash) ([]byte, error) {
+	sha256OID := asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	imprintOID := map[crypto.Hash]asn1.ObjectIdentifier{
+		crypto.SHA256: sha256OID,
+		crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+		crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+	}[hash]
+	tstInfo, err := asn1.Marshal(struct {
+		Version        int
+		Policy         asn1.ObjectIdentifier
+		MessageImprint struct {
+			HashAlgorithm testAlgorithm
+			HashedMessage []byte
+		}
+		SerialNumber *big.Int
+		GenTime      time.Time `asn1:"generalized"`
+	}{
+		Version: 1,
+		Policy:  asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: struct {
+			HashAlgorithm testAlgorithm
+			HashedMessage []byte
+		}{testAlgorithm{Algorithm: imprintOID}, digest},
+		SerialNumber: big.NewInt(1),
+		GenTime:      a.time,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, _ := asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4})
+	sum := sha256.Sum256(tstInfo)
+	messageDigest, _ := asn1.Marshal(sum[:])
+	attr1, _ := asn1.Marshal(testAttribute{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: contentType}})
+	attr2, _ := asn1.Marshal(testAttribute{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: messageDigest}})
+	attrs := append(attr1, attr2...)
+	if bytes.Compare(attr2, attr1) < 0 {
+		attrs = append(attr2, attr1...)
+	}
+	setOfAttrs, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	attrsDigest := sha256.Sum256(setOfAttrs)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, attrsDigest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	eContent, _ := asn1.Marshal(tstInfo)
+	sid, _ := asn1.Marshal(struct {
+		Issuer asn1.RawValue
+		Serial *big.Int
+	}{asn1.RawValue{FullBytes: a.cert.RawIssuer}, a.cert.SerialNumber})
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms []testAlgorithm `asn1:"set"`
+		EncapContentInfo struct {
+			ContentType asn1.ObjectIdentifier
+			Content     asn1.RawValue
+		}
+		Certificates asn1.RawValue
+		SignerInfos  []struct {
+			Version            int
+			SID                asn1.RawValue
+			DigestAlgorithm    testAlgorithm
+			SignedAttrs        asn1.RawValue
+			SignatureAlgorithm testAlgorithm
+			Signature          []byte
+		} `asn1:"set"`
+	}{
+		Version:          3,
+		DigestAlgorithms: []testAlgorithm{{Algorithm: sha256OID}},
+		EncapContentInfo: struct {
+			ContentType asn1.ObjectIdentifier
+			Content     asn1.RawValue
+		}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: eContent}},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: a.cert.Raw},
+		SignerInfos: []struct {
+			Version            int
+			SID                asn1.RawValue
+			DigestAlgorithm    testAlgorithm
+			SignedAttrs        asn1.RawValue
+			SignatureAlgorithm testAlgorithm
+			Signature          []byte
+		}{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    testAlgorithm{Algorithm: sha256OID},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: testAlgorithm{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData}})
+}
+
+// TestVerifySignature tests CMS verification and chain building
+func TestVerifySignature(t *testing.T) {
+	key, cert := newTestSigner(t)
+	tsa := newTestTSA(t, cert, key)
+	doc, err := pdf.NewDocument(createFormPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	signed, err := doc.Sign(pdf.SignOptions{
+		Signer:       key,
+		Certificates: []*x509.Certificate{cert},
+		Hash:         crypto.SHA384,
+		TSA:          tsa,
+	})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	signedDoc, err := pdf.NewDocument(signed)
+	if err != nil {
+		t.Fatalf("Failed to reopen signed document: %v", err)
+	}
+
+	validator := pdf.NewSignatureValidator(signedDoc)
+	results := validator.VerifyAllSignatures()
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	r := results[0]
+	if r.SignatureStatus != pdf.SignatureStatusValid || r.HashAlgorithm != "SHA-384" || r.CoverageStatus != "total" {
+		t.Errorf("unexpected result: %+v", r)
+	}
+	if r.CertificateStatus != pdf.CertificateStatusUntrustedIssuer || r.Valid {
+		t.Errorf("self-signed certificate should be untrusted, got %q", r.CertificateStatus)
+	}
+	if r.TrustedTimestamp != nil {
+		t.Error("timestamp from an untrusted TSA reported as trusted")
+	}
+
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := validator.AddTrustedCert(pemCert); err != nil {
+		t.Fatalf("AddTrustedCert: %v", err)
+	}
+	r = validator.VerifyAllSignatures()[0]
+	if !r.Valid || r.CertificateStatus != pdf.CertificateStatusTrusted {
+		t.Errorf("expected a valid trusted signature, got %+v", r)
+	}
+	if r.TrustedTimestamp == nil || !r.TrustedTimestamp.Equal(tsa.time) {
+		t.Errorf("expected timestamp %v, got %v", tsa.time, r.TrustedTimestamp)
+	}
+	if intact, issues := validator.VerifyDocumentIntegrity(); !intact {
+		t.Errorf("unexpected integrity issues: %v", issues)
+	}
+
+	// Changing a signed byte breaks the digest
+	br, _ := signedContents(t, signed)
+	tampered := append([]byte(nil), signed...)
+	tampered[br[1]-20] ^= 0x01
+	tamperedDoc, err := pdf.NewDocument(tampered)
+	if err != nil {
+		t.Fatalf("Failed to open tampered document: %v", err)
+	}
+	r = pdf.NewSignatureValidator(tamperedDoc).VerifyAllSignatures()[0]
+	if r.Valid || r.SignatureStatus != pdf.SignatureStatusDigestMismatch {
+		t.Errorf("expected a digest mismatch, got %q", r.SignatureStatus)
+	}
+}
+
+func TestLongTermValidation(t *testing.T) {
+	rootKey, root := newTestSigner(t)
+	tsa := newTestTSA(t, root, rootKey)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1001),
+		Subject:      pkix.Name{CommonName: "Test Leaf"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, root, &key.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	ocspResponse := func(template ocsp.Response) []byte {
+		template.SerialNumber = leaf.SerialNumber
+		template.ThisUpdate = now
+		template.NextUpdate = now.Add(time.Hour)
+		resp, err := ocsp.CreateResponse(root, root, template, rootKey)
+		if err != nil {
+			t.Fatalf("CreateResponse: %v", err)
+		}
+		return resp
+	}
+
+	doc, err := pdf.NewDocument(createFormPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	signed, err := doc.Sign(pdf.SignOptions{Signer: key, Certificates: []*x509.Certificate{leaf}})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	signedDoc, err := pdf.NewDocument(signed)
+	if err != nil {
+		t.Fatalf("Failed to reopen signed document: %v", err)
+	}
+
+	// B-LTA: validation data and a document timestamp in one update
+	good := ocspResponse(ocsp.Response{Status: ocsp.Good})
+	lta, err := signedDoc.AddLTV(pdf.LTVOptions{
+		Certificates: []*x509.Certificate{root},
+		OCSPs:        [][]byte{good},
+		TSA:          tsa,
+	})
+	if err != nil {
+		t.Fatalf("AddLTV: %v", err)
+	}
+	ltaDoc, err := pdf.NewDocument(lta)
+	if err != nil {
+		t.Fatalf("Failed to reopen document: %v", err)
+	}
+	dss := ltaDoc.DSS()
+	if dss == nil || len(dss.Certs) != 2 || len(dss.OCSPs) != 1 || len(dss.VRI) != 1 {
+		t.Fatalf("unexpected DSS: %+v", dss)
+	}
+	for _, vri := range dss.VRI {
+		if len(vri.OCSPs) != 1 || !bytes.Equal(vri.OCSPs[0], good) || vri.TU.IsZero() {
+			t.Errorf("unexpected VRI: %+v", vri)
+		}
+	}
+
+	validator := pdf.NewSignatureValidator(ltaDoc)
+	validator.AllowExpired = true
+	validator.AddTrustedCert(root.Raw)
+	results := validator.VerifyAllSignatures()
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if r := results[0]; !r.Valid || r.RevocationStatus != "good" || r.CoverageStatus != "partial" {
+		t.Errorf("unexpected signature result: %+v", r)
+	}
+	if r := results[0]; !r.ValidationTime.Equal(tsa.time) {
+		t.Errorf("expected validation at the document timestamp %v, got %v", tsa.time, r.ValidationTime)
+	}
+	if r := results[1]; !r.Valid || r.SignatureType != pdf.SubFilterRFC3161 || r.TrustedTimestamp == nil {
+		t.Errorf("unexpected document timestamp result: %+v", r)
+	}
+
+	// A revocation only applies from its revocation time on
+	revoked := ocspResponse(ocsp.Response{Status: ocsp.Revoked, RevokedAt: now.Add(time.Hour)})
+	lt, err := signedDoc.AddLTV(pdf.LTVOptions{Certificates: []*x509.Certificate{root}, OCSPs: [][]byte{revoked}})
+	if err != nil {
+		t.Fatalf("AddLTV: %v", err)
+	}
+	ltDoc, err := pdf.NewDocument(lt)
+	if err != nil {
+		t.Fatalf("Failed to reopen document: %v", err)
+	}
+	validator = pdf.NewSignatureValidator(ltDoc)
+	validator.AddTrustedCert(root.Raw)
+	if r := validator.VerifyAllSignatures()[0]; r.RevocationStatus != "good" || r.CertificateStatus != pdf.CertificateStatusTrusted {
+		t.Errorf("expected good before the revocation, got %q / %q", r.RevocationStatus, r.CertificateStatus)
+	}
+	validator.ValidationTime = now.Add(2 * time.Hour)
+	if r := validator.VerifyAllSignatures()[0]; r.Valid || r.CertificateStatus != pdf.CertificateStatusRevoked {
+		t.Errorf("expected a revoked certificate, got %q", r.CertificateStatus)
+	}
+}
+
+func TestModificationDetection(t *testing.T) {
+	key, cert := newTestSigner(t)
+	open := func(data []byte) *pdf.Document {
+		t.Helper()
+		doc, err := pdf.NewDocument(data)
+		if err != nil {
+			t.Fatalf("Failed to open document: %v", err)
+		}
+		return doc
+	}
+	update := func(doc *pdf.Document, edit func(w *pdf.IncrementalWriter)) *pdf.Document {
+		t.Helper()
+		w := pdf.NewIncrementalWriter(doc)
+		edit(w)
+		data, err := w.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes: %v", err)
+		}
+		return open(data)
+	}
+	fill := func(w *pdf.IncrementalWriter) {
+		w.EditDictionary(pdf.Reference{ObjectNumber: 4})["V"] = pdf.String{Value: []byte("John")}
+	}
+	annotate := func(w *pdf.IncrementalWriter) {
+		note := w.AddObject(pdf.Dictionary{
+			"Type":     pdf.Name("Annot"),
+			"Subtype":  pdf.Name("Text"),
+			"Rect":     pdf.Array{pdf.Integer(10), pdf.Integer(10), pdf.Integer(30), pdf.Integer(30)},
+			"Contents": pdf.String{Value: []byte("note")},
+		})
+		w.EditDictionary(pdf.Reference{ObjectNumber: 3})["Annots"] = pdf.Array{pdf.Reference{ObjectNumber: 4}, note}
+	}
+	redraw := func(w *pdf.IncrementalWriter) {
+		w.UpdateObject(pdf.Reference{ObjectNumber: 6}, pdf.Stream{Dictionary: pdf.Dictionary{}, Data: []byte("0 0 m 20 20 l S")})
+	}
+	report := func(doc *pdf.Document) pdf.ModificationReport {
+		t.Helper()
+		reports := pdf.NewSignatureValidator(doc).AnalyzeModifications()
+		if len(reports) != 1 {
+			t.Fatalf("expected 1 report, got %d", len(reports))
+		}
+		return reports[0]
+	}
+
+	// Certification allowing form filling
+	certified, err := open(createFormPDF()).Sign(pdf.SignOptions{
+		Signer: key, Certificates: []*x509.Certificate{cert}, DocMDP: 2,
+	})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	certDoc := open(certified)
+	if r := report(certDoc); !r.Permitted || r.DocMDP != 2 || len(r.Modifications) != 0 {
+		t.Errorf("unexpected report for the certified document: %+v", r)
+	}
+
+	r := report(update(certDoc, fill))
+	if !r.Permitted || len(r.Modifications) != 1 || r.Modifications[0].Kind != pdf.ModificationFormFill || r.Modifications[0].Field != "name" {
+		t.Errorf("form filling should be permitted: %+v", r)
+	}
+	r = report(update(certDoc, annotate))
+	if r.Permitted {
+		t.Errorf("annotations should not be permitted at level 2: %+v", r)
+	}
+	filled := update(certDoc, redraw)
+	r = report(filled)
+	if r.Permitted || r.Modifications[0].Kind != pdf.ModificationPageContent {
+		t.Errorf("page content change should not be permitted: %+v", r)
+	}
+	result := pdf.NewSignatureValidator(filled).VerifyAllSignatures()[0]
+	if result.ChangesPermitted || result.Valid || result.SignatureStatus != pdf.SignatureStatusValid {
+		t.Errorf("unexpected verification result: %+v", result)
+	}
+	if intact, issues := pdf.NewSignatureValidator(filled).VerifyDocumentIntegrity(); intact || len(issues) == 0 {
+		t.Error("integrity check should report the page content change")
+	}
+
+	// An approval signature whose field locks the text field
+	locked := update(open(createFormPDF()), func(w *pdf.IncrementalWriter) {
+		field := w.AddObject(pdf.Dictionary{
+			"FT": pdf.Name("Sig"),
+			"T":  pdf.String{Value: []byte("approval")},
+			"Lock": pdf.Dictionary{
+				"Type":   pdf.Name("SigFieldLock"),
+				"Action": pdf.Name("Include"),
+				"Fields": pdf.Array{pdf.String{Value: []byte("name")}},
+			},
+		})
+		root := w.EditDictionary(pdf.Reference{ObjectNumber: 1})
+		form := root.Get("AcroForm").(pdf.Dictionary)
+		root["AcroForm"] = pdf.Dictionary{"Fields": pdf.Array{pdf.Reference{ObjectNumber: 4}, field}, "DA": form.Get("DA")}
+	})
+	approved, err := locked.Sign(pdf.SignOptions{Signer: key, Certificates: []*x509.Certificate{cert}, FieldName: "approval"})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	approvedDoc := open(approved)
+	if r := report(update(approvedDoc, annotate)); !r.Permitted {
+		t.Errorf("annotations should be permitted without certification: %+v", r)
+	}
+	r = report(update(approvedDoc, fill))
+	if r.Permitted || len(r.Modifications) != 1 || r.Modifications[0].Reason == "" {
+		t.Errorf("filling a locked field should not be permitted: %+v", r)
+	}
+}
+
+func TestRevocationFetcher(t *testing.T) {
+	rootKey, root := newTestSigner(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	var ocspHits int
+	var ocspResp, crl []byte
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ocsp", func(w http.ResponseWriter, r *http.Request) {
+		ocspHits++
+		w.Write(ocspResp)
+	})
+	mux.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) { w.Write(crl) })
+	mux.HandleFunc("/ca.crt", func(w http.ResponseWriter, r *http.Request) { w.Write(root.Raw) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(2002),
+		Subject:               pkix.Name{CommonName: "Fetched Leaf"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		OCSPServer:            []string{server.URL + "/ocsp"},
+		CRLDistributionPoints: []string{server.URL + "/crl"},
+		IssuingCertificateURL: []string{server.URL + "/ca.crt"},
+	}, root, &key.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	ocspResp, err = ocsp.CreateResponse(root, root, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(time.Hour),
+	}, rootKey)
+	if err != nil {
+		t.Fatalf("CreateResponse: %v", err)
+	}
+	crl, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now,
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(99), RevocationTime: now.Add(-time.Minute)},
+		},
+	}, root, rootKey)
+	if err != nil {
+		t.Fatalf("CreateRevocationList: %v", err)
+	}
+
+	// HTTP fetching with a cache and a size limit
+	fetcher := &pdf.HTTPRevocationFetcher{}
+	for i := 0; i < 2; i++ {
+		if status := pdf.CheckOCSPWith(fetcher, leaf, root); status.Status != "good" {
+			t.Errorf("expected a good OCSP status, got %+v", status)
+		}
+	}
+	if ocspHits != 1 {
+		t.Errorf("expected the OCSP response to be cached, got %d requests", ocspHits)
+	}
+	if status := pdf.CheckCRLWith(fetcher, leaf, nil); status.IsRevoked || status.Error != nil {
+		t.Errorf("unexpected CRL status: %+v", status)
+	}
+	small := &pdf.HTTPRevocationFetcher{MaxSize: 16}
+	if _, err := small.FetchCRL(leaf); err != pdf.ErrResponseTooLarge {
+		t.Errorf("expected the size limit to apply, got %v", err)
+	}
+
+	// The same data from local files
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{"leaf.ocsp": ocspResp, "ca.crl": crl, "ca.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offline, err := pdf.NewOfflineRevocationFetcher(dir)
+	if err != nil {
+		t.Fatalf("NewOfflineRevocationFetcher: %v", err)
+	}
+	if issuer, err := offline.FetchIssuer(leaf); err != nil || !issuer.Equal(root) {
+		t.Errorf("expected the root as issuer, got %v", err)
+	}
+	if data, err := offline.FetchCRL(leaf); err != nil || !bytes.Equal(data, crl) {
+		t.Errorf("expected the local CRL, got %v", err)
+	}
+
+	doc, err := pdf.NewDocument(createFormPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	signed, err := doc.Sign(pdf.SignOptions{Signer: key, Certificates: []*x509.Certificate{leaf}})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	signedDoc, err := pdf.NewDocument(signed)
+	if err != nil {
+		t.Fatalf("Failed to reopen signed document: %v", err)
+	}
+	validator := pdf.NewSignatureValidator(signedDoc)
+	validator.AddTrustedCert(root.Raw)
+	if r := validator.VerifyAllSignatures()[0]; r.RevocationStatus != "unknown" {
+		t.Errorf("expected an unknown status without revocation data, got %q", r.RevocationStatus)
+	}
+	validator.Fetcher = offline
+	if r := validator.VerifyAllSignatures()[0]; !r.Valid || r.RevocationStatus != "good" {
+		t.Errorf("expected a good status from local data, got %q: %v", r.RevocationStatus, r.ValidationErrors)
+	}
+
+	// Stored OCSP responses also cover certificates that name no responder
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2003),
+		Subject:      pkix.Name{CommonName: "Offline Leaf"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, root, &key.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	bare, _ := x509.ParseCertificate(der)
+	bareResp, err := ocsp.CreateResponse(root, root, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: bare.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(time.Hour),
+	}, rootKey)
+	if err != nil {
+		t.Fatalf("CreateResponse: %v", err)
+	}
+	stored := &pdf.OfflineRevocationFetcher{Certificates: []*x509.Certificate{root}, OCSPs: [][]byte{bareResp}}
+	if info := pdf.CheckRevocationWith(stored, bare, nil); info.OCSP == nil || info.OCSP.Status != "good" || info.CRL != nil {
+		t.Errorf("expected a good status from the stored OCSP response, got %+v", info)
+	}
+
+	// A CRL whose signature can't be checked decides nothing
+	revoking, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(2),
+		ThisUpdate: now,
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: bare.SerialNumber, RevocationTime: now.Add(-time.Minute)},
+		},
+	}, root, rootKey)
+	if err != nil {
+		t.Fatalf("CreateRevocationList: %v", err)
+	}
+	unsigned := &pdf.OfflineRevocationFetcher{CRLs: [][]byte{revoking}}
+	if status := pdf.CheckCRLWith(unsigned, bare, nil); status.IsRevoked || status.Error != pdf.ErrCRLNotVerified {
+		t.Errorf("expected an unknown status without the CRL issuer, got %+v", status)
+	}
+	unsigned.Certificates = []*x509.Certificate{root}
+	if status := pdf.CheckCRLWith(unsigned, bare, nil); !status.IsRevoked || status.Error != nil {
+		t.Errorf("expected the verified CRL to revoke the certificate, got %+v", status)
+	}
+}
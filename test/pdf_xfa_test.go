@@ -0,0 +1,650 @@
+package test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/novvoo/go-poppler/pkg/pdf"
+)
+
+// streamObject returns the body of an uncompressed stream object
+func streamObject(data string) string {
+	return "<< /Length " + formatInt(len(data)) + " >>\nstream\n" + data + "\nendstream"
+}
+
+const xfaTestTemplate = `<template xmlns="http://www.xfa.org/schema/xfa-template/3.3/">
+<subform name="form1" layout="tb">
+<pageSet><pageArea name="Page1"><contentArea x="0.25in" y="0.25in" w="8in" h="10.5in"/><medium stock="letter"/></pageArea></pageSet>
+<field name="taxpayer" w="3in" h="9mm"><ui><textEdit/></ui><caption><value><text>Name</text></value></caption></field>
+<field name="year" w="1in" h="9mm"><ui><numericEdit/></ui><value><integer>2024</integer></value></field>
+<subform name="items" layout="tb">
+<subform name="row" layout="lr-tb" w="7in">
+<occur min="1" max="4"/>
+<field name="desc" w="4in" h="9mm"><ui><textEdit/></ui></field>
+<field name="amount" w="2in" h="9mm"><ui><numericEdit/></ui></field>
+</subform>
+</subform>
+<exclGroup name="status" layout="lr-tb">
+<field name="single" w="1in" h="9mm"><ui><checkButton shape="round"/></ui><items><text>S</text></items></field>
+<field name="married" w="1in" h="9mm"><ui><checkButton shape="round"/></ui><items><text>M</text></items></field>
+</exclGroup>
+<field name="total" w="2in" h="9mm"><ui><numericEdit/></ui><bind match="dataRef" ref="$.summary.total"/></field>
+</subform>
+</template>`
+
+const xfaTestDatasets = `<xfa:datasets xmlns:xfa="http://www.xfa.org/schema/xfa-data/1.0/"><xfa:data><form1>` +
+	`<taxpayer>Ada</taxpayer><items><row><desc>Rent</desc><amount>1200</amount></row>` +
+	`<row><desc>Power</desc><amount>80</amount></row></items><status>M</status></form1></xfa:data></xfa:datasets>`
+
+// createXFAPDF creates a dynamic XFA form whose packets are stored as an
+// array of streams
+func createXFAPDF() []byte {
+	return buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [] /XFA [(preamble) 4 0 R (template) 5 0 R (datasets) 6 0 R (postamble) 7 0 R] >> /NeedsRendering true >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>",
+		streamObject(`<xdp:xdp xmlns:xdp="http://ns.adobe.com/xdp/">`),
+		streamObject(xfaTestTemplate),
+		streamObject(xfaTestDatasets),
+		streamObject(`</xdp:xdp>`),
+	})
+}
+
+// TestXFADataMerge tests merging the template with its data, changing
+// values and repeating subforms, and saving the datasets packet
+func TestXFADataMerge(t *testing.T) {
+	doc, err := pdf.NewDocument(createXFAPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	xfa, err := pdf.NewXFAForm(doc)
+	if err != nil {
+		t.Fatalf("NewXFAForm failed: %v", err)
+	}
+	if xfa.Form == nil {
+		t.Fatal("Form DOM should be merged")
+	}
+
+	rows := xfa.FindNodes("form1.items.row[*]")
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 row instances, got %d", len(rows))
+	}
+	if got := xfa.GetFieldValue("form1.items.row[1].desc"); got != "Power" {
+		t.Errorf("row[1].desc = %q, want Power", got)
+	}
+	if got := xfa.GetFieldValue("year"); got != "2024" {
+		t.Errorf("Unbound data should take the default value, got %q", got)
+	}
+	if got := xfa.GetFieldValue("married"); got != "M" {
+		t.Errorf("Selected radio button should be on, got %q", got)
+	}
+
+	if err := xfa.SetFieldValue("row[1].amount", "95"); err != nil {
+		t.Fatalf("SetFieldValue failed: %v", err)
+	}
+	if err := xfa.SetFieldValue("single", "1"); err != nil {
+		t.Fatalf("SetFieldValue failed: %v", err)
+	}
+	if err := xfa.SetFieldValue("total", "1295"); err != nil {
+		t.Fatalf("SetFieldValue failed: %v", err)
+	}
+	row, err := xfa.AddInstance("form1.items.row")
+	if err != nil {
+		t.Fatalf("AddInstance failed: %v", err)
+	}
+	if row.SOM() != "form1[0].items[0].row[2]" {
+		t.Errorf("Unexpected SOM of new instance: %s", row.SOM())
+	}
+	if err := xfa.SetFieldValue("row[2].desc", "Water & gas"); err != nil {
+		t.Fatalf("SetFieldValue failed: %v", err)
+	}
+	xfa.AddInstance("row")
+	if _, err := xfa.AddInstance("row"); err != pdf.ErrXFAOccurMax {
+		t.Errorf("Adding a fifth row should fail, got %v", err)
+	}
+
+	data, err := xfa.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	saved, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to reopen saved document: %v", err)
+	}
+	reloaded, err := pdf.NewXFAForm(saved)
+	if err != nil {
+		t.Fatalf("NewXFAForm failed on saved document: %v", err)
+	}
+
+	datasets := reloaded.DatasetsXML()
+	for _, want := range []string{
+		"<amount>95</amount>",
+		"<desc>Water &amp; gas</desc>",
+		"<status>S</status>",
+		"<summary><total>1295</total></summary>",
+		"<year>2024</year>",
+	} {
+		if !bytes.Contains(datasets, []byte(want)) {
+			t.Errorf("Saved datasets should contain %s, got %s", want, datasets)
+		}
+	}
+	if n := len(reloaded.FindNodes("row[*]")); n != 4 {
+		t.Errorf("Expected 4 rows after reload, got %d", n)
+	}
+
+	// Prefilling from a data file
+	err = reloaded.ImportData([]byte(`<form1><taxpayer>Grace</taxpayer><items><row><desc>A</desc></row></items></form1>`))
+	if err != nil {
+		t.Fatalf("ImportData failed: %v", err)
+	}
+	if got := reloaded.GetFieldValue("taxpayer"); got != "Grace" {
+		t.Errorf("taxpayer = %q after import", got)
+	}
+	if n := len(reloaded.FindNodes("row[*]")); n != 1 {
+		t.Errorf("Expected 1 row after import, got %d", n)
+	}
+}
+
+const xfaFlowTemplate = `<template xmlns="http://www.xfa.org/schema/xfa-template/3.3/">
+<subform name="doc" layout="tb">
+<pageSet>
+<pageArea name="Main"><contentArea x="0.5in" y="1in" w="7.5in" h="9in"/><medium stock="letter"/>
+<draw name="header" x="0.5in" y="0.25in" w="7.5in" h="0.5in"><value><text>Statement</text></value><font typeface="Helvetica" size="14pt" weight="bold"/><para hAlign="center"/></draw>
+</pageArea>
+<pageArea name="Back"><contentArea x="1in" y="1in" w="6.5in" h="9in"/><medium stock="letter" orientation="landscape"/></pageArea>
+</pageSet>
+<subform name="line" layout="lr-tb" w="7.5in"><occur min="0" max="-1"/><border><edge/></border>
+<field name="item" w="5in" h="0.5in"/>
+<field name="price" w="2.5in" h="0.5in"><para hAlign="right"/></field>
+</subform>
+<subform name="notes" w="6in" h="2in"><breakBefore targetType="pageArea" target="Back"/>
+<draw name="rule" x="0" y="0" w="6in" h="0"><value><line><edge thickness="2pt"/></line></value></draw>
+</subform>
+</subform>
+</template>`
+
+// TestXFALayout tests laying out positioned and flowed content on pages,
+// rendering pure XFA documents and the HTML output
+func TestXFALayout(t *testing.T) {
+	var rows strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&rows, "<line><item>Item %d</item><price>%d.00</price></line>", i, i)
+	}
+	doc, err := pdf.NewDocument(buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [] /XFA [(template) 4 0 R (datasets) 5 0 R] >> >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>",
+		streamObject(xfaFlowTemplate),
+		streamObject(`<xfa:datasets xmlns:xfa="http://www.xfa.org/schema/xfa-data/1.0/"><xfa:data><doc>` +
+			rows.String() + `</doc></xfa:data></xfa:datasets>`),
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	xfa, err := pdf.NewXFAForm(doc)
+	if err != nil {
+		t.Fatalf("NewXFAForm failed: %v", err)
+	}
+	pages, err := xfa.Layout()
+	if err != nil {
+		t.Fatalf("Layout failed: %v", err)
+	}
+
+	// 18 half-inch rows fit in a 9in content area; the notes break to
+	// the landscape page area
+	if len(pages) != 3 {
+		t.Fatalf("Expected 3 pages, got %d", len(pages))
+	}
+	if n := len(pages[0].Widgets); n != 36 {
+		t.Errorf("Expected 18 rows on page 1, got %d widgets", n)
+	}
+	if n := len(pages[1].Widgets); n != 24 {
+		t.Errorf("Expected 12 rows on page 2, got %d widgets", n)
+	}
+	if pages[2].Width != 792 || pages[2].Height != 612 {
+		t.Errorf("Page 3 should be landscape, got %vx%v", pages[2].Width, pages[2].Height)
+	}
+	for i, page := range pages[:2] {
+		if page.Ops[0].Text != "Statement" {
+			t.Errorf("Page %d should repeat the page area header", i+1)
+		}
+	}
+	first := pages[0].Widgets[0]
+	if first.X != 36 || first.Y != 72 || first.W != 360 || first.H != 36 {
+		t.Errorf("Unexpected first widget box: %+v", first)
+	}
+	for _, op := range pages[1].Ops {
+		if op.Text == "29.00" && op.X+op.W != 576 {
+			t.Errorf("Right aligned value should end at 576, ends at %v", op.X+op.W)
+		}
+	}
+
+	// A document whose pages are generated from XFA renders its layout
+	doc, err = pdf.NewDocument(createXFAPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	renderer := pdf.NewPageRenderer(doc, pdf.RenderOptions{DPI: 72, Format: "png"})
+	if renderer.NumPages() != 1 {
+		t.Fatalf("Expected 1 rendered page, got %d", renderer.NumPages())
+	}
+	rendered, err := renderer.RenderPage(1)
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(rendered.Data))
+	if err != nil {
+		t.Fatalf("Failed to decode rendered page: %v", err)
+	}
+	if countDark(img, image.Rect(18, 18, 234, 44)) == 0 {
+		t.Error("The taxpayer field should be drawn")
+	}
+
+	xfa, err = pdf.NewXFAForm(doc)
+	if err != nil {
+		t.Fatalf("NewXFAForm failed: %v", err)
+	}
+	htmlOut, err := xfa.RenderToHTML()
+	if err != nil {
+		t.Fatalf("RenderToHTML failed: %v", err)
+	}
+	for _, want := range []string{
+		`class="xfa-page"`,
+		`name="form1[0].taxpayer[0]" value="Ada"`,
+		`type="radio" name="form1[0].status[0]" value="M"`,
+		`>Name</text>`,
+	} {
+		if !strings.Contains(htmlOut, want) {
+			t.Errorf("HTML output should contain %s", want)
+		}
+	}
+}
+
+// countDark counts the pixels of img inside r that are not near white
+func countDark(img image.Image, r image.Rectangle) int {
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			red, green, blue, _ := img.At(x, y).RGBA()
+			if red+green+blue < 3*0xc000 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// TestXFAConvertToAcroForm tests downgrading an XFA form to an AcroForm
+func TestXFAConvertToAcroForm(t *testing.T) {
+	doc, err := pdf.NewDocument(createXFAPDF())
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	xfa, err := pdf.NewXFAForm(doc)
+	if err != nil {
+		t.Fatalf("NewXFAForm failed: %v", err)
+	}
+	data, err := xfa.ConvertToAcroForm()
+	if err != nil {
+		t.Fatalf("ConvertToAcroForm failed: %v", err)
+	}
+
+	converted, err := pdf.NewDocument(data)
+	if err != nil {
+		t.Fatalf("Failed to open converted document: %v", err)
+	}
+	if converted.IsXFA() {
+		t.Error("Converted document should not carry XFA")
+	}
+	if _, err := pdf.NewXFAForm(converted); err == nil {
+		t.Error("NewXFAForm should fail without /XFA")
+	}
+
+	values := make(map[string]*pdf.FormField)
+	var collect func(fields []*pdf.FormField)
+	collect = func(fields []*pdf.FormField) {
+		for _, f := range fields {
+			if _, ok := values[f.Name]; !ok {
+				values[f.Name] = f
+			}
+			collect(f.Kids)
+		}
+	}
+	collect(converted.GetFormFields())
+	for name, want := range map[string]string{
+		"form1[0].taxpayer[0]":             "Ada",
+		"form1[0].year[0]":                 "2024",
+		"form1[0].items[0].row[1].desc[0]": "Power",
+		"form1[0].status[0]":               "M",
+	} {
+		f, ok := values[name]
+		if !ok {
+			t.Errorf("Missing field %s", name)
+			continue
+		}
+		if f.Value != want {
+			t.Errorf("%s = %q, want %q", name, f.Value, want)
+		}
+	}
+	if f := values["form1[0].status[0]"]; f != nil && f.Type != "Btn" {
+		t.Errorf("Exclusion group should be a radio button field, got %s", f.Type)
+	}
+
+	// The converted fields can be filled through the AcroForm path
+	filler := pdf.NewFormFiller(converted)
+	if err := filler.SetValue("form1[0].items[0].row[0].amount[0]", "1300"); err != nil {
+		t.Fatalf("SetValue failed: %v", err)
+	}
+	if err := filler.SetValue("form1[0].status[0]", "S"); err != nil {
+		t.Fatalf("SetValue on radio failed: %v", err)
+	}
+	filled, err := filler.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+	reopened, err := pdf.NewDocument(filled)
+	if err != nil {
+		t.Fatalf("Failed to reopen filled document: %v", err)
+	}
+	values = make(map[string]*pdf.FormField)
+	collect(reopened.GetFormFields())
+	if f := values["form1[0].status[0]"]; f == nil || f.Value != "S" {
+		t.Errorf("Radio value after fill = %+v", f)
+	}
+
+	// Widget appearances render without XFA
+	renderer := pdf.NewPageRenderer(reopened, pdf.RenderOptions{DPI: 72, Format: "png"})
+	rendered, err := renderer.RenderPage(1)
+	if err != nil {
+		t.Fatalf("RenderPage failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(rendered.Data))
+	if err != nil {
+		t.Fatalf("Failed to decode rendered page: %v", err)
+	}
+	if countDark(img, image.Rect(45, 18, 234, 44)) == 0 {
+		t.Error("The taxpayer value should be drawn by its widget")
+	}
+}
+
+const xfaCalcTemplate = `<template xmlns="http://www.xfa.org/schema/xfa-template/3.3/">
+<subform name="invoice" layout="tb">
+<field name="customer"><validate nullTest="error"><message><text name="nullTest">Customer is required</text></message></validate></field>
+<subform name="items" layout="tb">
+<subform name="row" layout="lr-tb"><occur min="1" max="-1"/>
+<field name="qty"><ui><numericEdit/></ui></field>
+<field name="price"><ui><numericEdit/></ui>
+<validate><script contentType="application/x-formcalc">$ >= 0</script><message><text name="scriptTest">Price cannot be negative</text></message></validate></field>
+<field name="amount"><ui><numericEdit/></ui><calculate><script>qty * price</script></calculate></field>
+</subform>
+</subform>
+<field name="tax"><ui><numericEdit/></ui><calculate><script contentType="application/x-formcalc">Round(subtotal * 0.2, 2)</script></calculate></field>
+<field name="subtotal"><ui><numericEdit/></ui><calculate><script>Sum(items.row[*].amount)</script></calculate></field>
+<field name="lines"><calculate><script>items._row.count</script></calculate></field>
+<field name="summary"><calculate><script>
+func label(n) do
+  if (n == 1) then "item" else "items" endif
+endfunc
+var total = subtotal + tax
+Concat(lines, " ", label(lines), ": ", Format("num{z,zz9.99}", total))
+</script></calculate></field>
+<field name="js"><calculate><script contentType="application/x-javascript">"ignored"</script></calculate></field>
+</subform>
+</template>`
+
+// TestFormCalc tests the FormCalc built-in functions and XFA calculate
+// and validate scripts
+func TestFormCalc(t *testing.T) {
+	doc, err := pdf.NewDocument(buildPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [] /XFA 4 0 R >> /NeedsRendering true >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>",
+		streamObject(`<xdp:xdp xmlns:xdp="http://ns.adobe.com/xdp/">` + xfaCalcTemplate + `</xdp:xdp>`),
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+	xfa, err := pdf.NewXFAForm(doc)
+	if err != nil {
+		t.Fatalf("NewXFAForm failed: %v", err)
+	}
+
+	builtins := map[string]string{
+		`Sum(1, 2, 3) + Avg(2, 4) * Max(1, 5)`:                                  "21",
+		`Abs(-2.5) & Ceil(1.2) | 0`:                                             "1",
+		`Round(2.675, 2)`:                                                       "2.68",
+		`Mod(-13, 4)`:                                                           "-1",
+		`Concat("a", null, "b") == "ab"`:                                        "1",
+		`Upper(Left("formcalc", 4))`:                                            "FORM",
+		`Substr("ABCDEFG", 3, 2) == "CD" & Len("abc") eq 3`:                     "1",
+		`Stuff("ABCDE", 2, 3, "xyz")`:                                           "AxyzE",
+		`Replace("a-b-c", "-", "+")`:                                            "a+b+c",
+		`At("abcabc", "ca")`:                                                    "3",
+		`Str(3.14159, 6, 2)`:                                                    "  3.14",
+		`WordNum(1154.67, 2)`:                                                   "One Thousand One Hundred Fifty-four Dollars And Sixty-seven Cents",
+		`Num2Date(Date2Num("Mar 15, 1996"), "YYYY-MM-DD")`:                      "1996-03-15",
+		`IsoDate2Num("1900-01-01")`:                                             "1",
+		`Num2Date(IsoDate2Num("2024-02-29") + 1, "EEEE D MMMM")`:                "Friday 1 March",
+		`Time2Num("1:13:13 PM")`:                                                "47593001",
+		`Num2GMTime(IsoTime2Num("T13:13:13Z"), "HH:MM:SS")`:                     "13:13:13",
+		`Format("num{$z,zz9.99}", 1234.5)`:                                      "$1,234.50",
+		`Format("date{MMMM D, YYYY}", "2002-01-15")`:                            "January 15, 2002",
+		`Parse("text{999-99-9999}", "123-45-6789")`:                             "123456789",
+		`Parse("date{D/M/YYYY}", "5/11/2010")`:                                  "2010-11-05",
+		`Pmt(30000, 0.085 / 12, 12) > 2616 & Pmt(30000, 0.085 / 12, 12) < 2617`: "1",
+		`Round(FV(100, 0.1, 2), 2)`:                                             "210",
+		`Choose(2, "a", "b", "c") == "b" & Oneof(3, 1, 2, 3) & Within("c", "a", "d")`: "1",
+		`HasValue("  ") | HasValue(null)`:                                             "0",
+		`UnitValue("1in", "cm")`:                                                      "2.54",
+		`var s = 0
+for i = 1 upto 10 step 2 do
+  if (i == 7) then break endif
+  s = s + i
+endfor
+s`: "9",
+		`var out = ""
+foreach v in (1, "x", 3) do out = Concat(out, v) endfor
+out`: "1x3",
+		`Eval("2 * 3") + 1`: "7",
+	}
+	for script, want := range builtins {
+		got, err := xfa.ExecuteFormCalc(script, nil)
+		if err != nil {
+			t.Errorf("%q: %v", script, err)
+		} else if got != want {
+			t.Errorf("%q = %q, want %q", script, got, want)
+		}
+	}
+	if _, err := xfa.ExecuteFormCalc("1 / 0", nil); err == nil {
+		t.Error("Division by zero should fail")
+	}
+	if _, err := xfa.ExecuteFormCalc("if (1) then", nil); err == nil || !strings.Contains(err.Error(), "syntax error") {
+		t.Errorf("Expected a syntax error, got %v", err)
+	}
+	if _, err := xfa.ExecuteFormCalc(`Get("http://example.com")`, nil); err == nil {
+		t.Error("Get should not reach the network")
+	}
+	xfa.SetScriptLimits(pdf.JSLimits{Timeout: 50 * time.Millisecond})
+	if _, err := xfa.ExecuteFormCalc("while (1) do endwhile", nil); !errors.Is(err, pdf.ErrFormCalcTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	xfa.SetScriptLimits(pdf.DefaultJSLimits)
+
+	// Calculations run when data is imported and when values change
+	err = xfa.ImportData([]byte(`<invoice><customer>Acme</customer><items>` +
+		`<row><qty>2</qty><price>10.5</price></row><row><qty>3</qty><price>100</price></row></items></invoice>`))
+	if err != nil {
+		t.Fatalf("ImportData failed: %v", err)
+	}
+	expect := func(field, want string) {
+		t.Helper()
+		if got := xfa.GetFieldValue(field); got != want {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+	expect("invoice.items.row[0].amount", "21")
+	expect("invoice.items.row[1].amount", "300")
+	expect("subtotal", "321")
+	expect("tax", "64.2")
+	expect("lines", "2")
+	expect("summary", "2 items: 385.20")
+	expect("js", "")
+	if !strings.Contains(string(xfa.DatasetsXML()), "<subtotal>321</subtotal>") {
+		t.Error("Calculated values should be written to the data")
+	}
+
+	if err := xfa.SetFieldValue("invoice.items.row[0].qty", "4"); err != nil {
+		t.Fatalf("SetFieldValue failed: %v", err)
+	}
+	expect("subtotal", "342")
+
+	// SOM expressions address fields by instance, across the form and data
+	rows := xfa.FindNodes("invoice.items.row[*]")
+	checks := map[string]string{
+		`$.rawValue`: "342",
+		`items.row[1].qty + $record.items.row[1].price`: "103",
+		`xfa.form.invoice..row[*].amount.rawValue + 0`:  "42",
+		`Count(invoice.items.row[*].amount)`:            "2",
+		`items.#subform[0].price`:                       "10.5",
+		`$.parent.customer`:                             "Acme",
+		`Exists(items.row[5]) + Exists(customer)`:       "1",
+		`var r = Ref(items.row[1].qty)
+r.rawValue`: "3",
+	}
+	subtotal := xfa.FindNodes("subtotal")[0]
+	for script, want := range checks {
+		got, err := xfa.ExecuteFormCalc(script, subtotal)
+		if err != nil {
+			t.Errorf("%q: %v", script, err)
+		} else if got != want {
+			t.Errorf("%q = %q, want %q", script, got, want)
+		}
+	}
+	if got, err := xfa.ExecuteFormCalc("qty * 10", xfa.FindNodes("amount")[0].Parent.Children[0]); err != nil || got != "40" {
+		t.Errorf("Unqualified names should resolve in the current instance, got %q, %v", got, err)
+	}
+	if _, err := xfa.ExecuteFormCalc("nosuchfield + 1", subtotal); err == nil {
+		t.Error("Unknown accessors should fail")
+	}
+
+	// Scripts may assign values and manage instances
+	if _, err := xfa.ExecuteFormCalc(`items._row.addInstance(1)
+items.row[2].qty = 1
+items.row[2].price = 8`, subtotal); err != nil {
+		t.Fatalf("Assignment script failed: %v", err)
+	}
+	if len(xfa.FindNodes("invoice.items.row[*]")) != len(rows)+1 {
+		t.Error("addInstance should add a row")
+	}
+	if err := xfa.Calculate(); err != nil {
+		t.Fatalf("Calculate failed: %v", err)
+	}
+	expect("subtotal", "350")
+	expect("lines", "3")
+
+	// Validation rejects values failing a script or null test
+	err = xfa.SetFieldValue("invoice.items.row[1].price", "-5")
+	var verr *pdf.XFAValidationError
+	if !errors.As(err, &verr) || verr.Message != "Price cannot be negative" {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	expect("invoice.items.row[1].price", "100")
+	expect("subtotal", "350")
+	if err := xfa.SetFieldValue("customer", ""); err == nil || !strings.Contains(err.Error(), "Customer is required") {
+		t.Errorf("Expected the null test to fail, got %v", err)
+	}
+	if err := xfa.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+
+	// The script engine runs FormCalc against the document's form
+	engine := pdf.NewJSEngine(doc)
+	v, err := engine.ExecuteFormCalc(`Sum(1, 2) * 2 + Exists(invoice.customer)`)
+	if err != nil || v != 7.0 {
+		t.Errorf("ExecuteFormCalc = %v, %v", v, err)
+	}
+}
+
+// TestXFASaveDatasetsPrefixes tests that saving a single-stream XDP replaces
+// its datasets packet whatever its prefix, including an empty packet
+func TestXFASaveDatasetsPrefixes(t *testing.T) {
+	template := `<template xmlns="http://www.xfa.org/schema/xfa-template/3.3/"><subform name="form1">` +
+		`<field name="taxpayer" w="3in" h="9mm"><ui><textEdit/></ui></field></subform></template>`
+	for _, datasets := range []string{
+		`<datasets xmlns="http://www.xfa.org/schema/xfa-data/1.0/"><data><form1><taxpayer>Ada</taxpayer></form1></data></datasets>`,
+		`<xd:datasets xmlns:xd="http://www.xfa.org/schema/xfa-data/1.0/"><xd:data><form1><taxpayer>Ada</taxpayer></form1></xd:data></xd:datasets>`,
+		`<xfa:datasets xmlns:xfa="http://www.xfa.org/schema/xfa-data/1.0/"/>`,
+	} {
+		xdp := `<xdp:xdp xmlns:xdp="http://ns.adobe.com/xdp/">` + template + datasets + `</xdp:xdp>`
+		doc, err := pdf.NewDocument(buildPDF([]string{
+			"<< /Type /Catalog /Pages 2 0 R /AcroForm << /Fields [] /XFA 4 0 R >> >>",
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>",
+			streamObject(xdp),
+		}))
+		if err != nil {
+			t.Fatalf("Failed to create document: %v", err)
+		}
+		xfa, err := pdf.NewXFAForm(doc)
+		if err != nil {
+			t.Fatalf("NewXFAForm failed: %v", err)
+		}
+		if err := xfa.SetFieldValue("taxpayer", "Grace"); err != nil {
+			t.Fatalf("SetFieldValue failed: %v", err)
+		}
+		data, err := xfa.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes failed: %v", err)
+		}
+		saved, err := pdf.NewDocument(data)
+		if err != nil {
+			t.Fatalf("Failed to reopen saved document: %v", err)
+		}
+
+		// The saved XDP holds one datasets packet, after the template
+		form, _ := saved.ResolveObject(saved.Root.Get("AcroForm"))
+		obj, _ := saved.ResolveObject(form.(pdf.Dictionary).Get("XFA"))
+		stream, ok := obj.(pdf.Stream)
+		if !ok {
+			t.Fatalf("XFA entry is %T after saving", obj)
+		}
+		savedXDP, err := stream.Decode()
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		dec := xml.NewDecoder(bytes.NewReader(savedXDP))
+		var packets []string
+		for depth := 0; ; {
+			tok, err := dec.Token()
+			if err != nil {
+				break
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				if depth++; depth == 2 {
+					packets = append(packets, tok.Name.Local)
+				}
+			case xml.EndElement:
+				depth--
+			}
+		}
+		if strings.Join(packets, " ") != "template datasets" {
+			t.Errorf("%s: saved packets are %v", datasets, packets)
+		}
+
+		reloaded, err := pdf.NewXFAForm(saved)
+		if err != nil {
+			t.Fatalf("NewXFAForm failed on saved document: %v", err)
+		}
+		if got := reloaded.GetFieldValue("taxpayer"); got != "Grace" {
+			t.Errorf("%s: taxpayer = %q after saving", datasets, got)
+		}
+	}
+}